@@ -0,0 +1,92 @@
+// Code generated by protoc-gen-go-ttrpc. DO NOT EDIT.
+// source: api/agent/v1/agent.proto
+package agentv1
+
+import (
+	context "context"
+	ttrpc "github.com/containerd/ttrpc"
+)
+
+type AgentService interface {
+	ResolveTenant(context.Context, *ResolveTenantRequest) (*ResolveTenantResponse, error)
+	StrictOverride(context.Context, *StrictOverrideRequest) (*StrictOverrideResponse, error)
+	RecordAttachment(context.Context, *RecordAttachmentRequest) (*RecordAttachmentResponse, error)
+	ReleaseAttachment(context.Context, *ReleaseAttachmentRequest) (*ReleaseAttachmentResponse, error)
+}
+
+func RegisterAgentService(srv *ttrpc.Server, svc AgentService) {
+	srv.RegisterService("tenantrouting.agent.v1.Agent", &ttrpc.ServiceDesc{
+		Methods: map[string]ttrpc.Method{
+			"ResolveTenant": func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+				var req ResolveTenantRequest
+				if err := unmarshal(&req); err != nil {
+					return nil, err
+				}
+				return svc.ResolveTenant(ctx, &req)
+			},
+			"StrictOverride": func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+				var req StrictOverrideRequest
+				if err := unmarshal(&req); err != nil {
+					return nil, err
+				}
+				return svc.StrictOverride(ctx, &req)
+			},
+			"RecordAttachment": func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+				var req RecordAttachmentRequest
+				if err := unmarshal(&req); err != nil {
+					return nil, err
+				}
+				return svc.RecordAttachment(ctx, &req)
+			},
+			"ReleaseAttachment": func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+				var req ReleaseAttachmentRequest
+				if err := unmarshal(&req); err != nil {
+					return nil, err
+				}
+				return svc.ReleaseAttachment(ctx, &req)
+			},
+		},
+	})
+}
+
+type agentClient struct {
+	client *ttrpc.Client
+}
+
+func NewAgentClient(client *ttrpc.Client) AgentService {
+	return &agentClient{
+		client: client,
+	}
+}
+
+func (c *agentClient) ResolveTenant(ctx context.Context, req *ResolveTenantRequest) (*ResolveTenantResponse, error) {
+	var resp ResolveTenantResponse
+	if err := c.client.Call(ctx, "tenantrouting.agent.v1.Agent", "ResolveTenant", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *agentClient) StrictOverride(ctx context.Context, req *StrictOverrideRequest) (*StrictOverrideResponse, error) {
+	var resp StrictOverrideResponse
+	if err := c.client.Call(ctx, "tenantrouting.agent.v1.Agent", "StrictOverride", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *agentClient) RecordAttachment(ctx context.Context, req *RecordAttachmentRequest) (*RecordAttachmentResponse, error) {
+	var resp RecordAttachmentResponse
+	if err := c.client.Call(ctx, "tenantrouting.agent.v1.Agent", "RecordAttachment", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *agentClient) ReleaseAttachment(ctx context.Context, req *ReleaseAttachmentRequest) (*ReleaseAttachmentResponse, error) {
+	var resp ReleaseAttachmentResponse
+	if err := c.client.Call(ctx, "tenantrouting.agent.v1.Agent", "ReleaseAttachment", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
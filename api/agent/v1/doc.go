@@ -0,0 +1,6 @@
+// Package agentv1 is version 1 of the ttrpc service tenant-routingd serves the CNI
+// plugin on agentSocket, generated from agent.proto (see pkg/agentclient for the
+// plugin's client and pkg/agent for the server).
+package agentv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-ttrpc_out=. --go-ttrpc_opt=paths=source_relative agent.proto
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// bypassActive reports whether the pod is temporarily exempted from marking
+// An invalid bypass annotation is ignored with a warning (the pod stays marked)
+func bypassActive(annotations tenant.RoutingAnnotations, podNamespace, podName string) bool {
+	if annotations.BypassError != nil {
+		cniLog.Warnf("ignoring %s on pod %s/%s: %v",
+			api.BypassAnnotationKey, podNamespace, podName, annotations.BypassError)
+		return false
+	}
+	return annotations.BypassActive(time.Now())
+}
+
+// reconcileBypass applies bypass transitions during CHECK
+//
+//   - bypass active, rule installed: rules are removed (annotation added after ADD)
+//   - bypass expired, rule missing: rules are re-applied
+//
+// Every transition is written as an AUDIT log entry. Returns true while the bypass
+// is active so CHECK does not report the intentionally missing rule as drift.
+func reconcileBypass(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, podNamespace, podName, podIP string,
+	annotations tenant.RoutingAnnotations) bool {
+	if annotations.BypassUntil.IsZero() && annotations.BypassError == nil {
+		return false
+	}
+	fwmark := annotations.Fwmark
+	until := annotations.BypassUntil.Format(time.RFC3339)
+	active := bypassActive(annotations, podNamespace, podName)
+
+	unlock, err := acquireNodeLock(conf)
+	if err != nil {
+		cniLog.Warnf("CHECK cannot reconcile bypass for pod %s/%s: %v", podNamespace, podName, err)
+		return active
+	}
+	defer unlock()
+
+	exists, err := ipt.RuleExists(ctx, podIP, fwmark)
+	if err != nil {
+		cniLog.Warnf("CHECK cannot reconcile bypass for pod %s/%s: %v", podNamespace, podName, err)
+		return active
+	}
+
+	switch {
+	case active && exists:
+		if removePodRules(ctx, ipt, conf, podNamespace, podName, podIP, fwmark, annotations.Gateway) {
+			cniLog.Auditf("pod %s/%s (UID: %s, IP: %s, fwmark: %s) bypassed until %s: MARK rule removed",
+				podNamespace, podName, uidOrUnknown(annotations.PodUID), podIP, fwmark, until)
+			recordAssignment(conf, podNamespace, podName, state.Assignment{
+				Cause: state.CauseBypass, PodUID: annotations.PodUID, IP: podIP,
+			})
+		}
+	case !active && !exists && annotations.BypassError == nil:
+		// CHECK never fails a running pod: re-apply failures are skips
+		if added, _ := installPodRules(ctx, ipt, conf, permissive(conf), podNamespace, podName, annotations.PodUID, podIP, fwmark,
+			annotations.Gateway); added {
+			cniLog.Auditf("pod %s/%s (UID: %s, IP: %s, fwmark: %s) bypass expired at %s: MARK rule re-applied",
+				podNamespace, podName, uidOrUnknown(annotations.PodUID), podIP, fwmark, until)
+			recordAssignment(conf, podNamespace, podName, state.Assignment{
+				Cause: state.CauseBypassExpired, Fwmark: fwmark, Gateway: annotations.Gateway,
+				PodUID: annotations.PodUID, IP: podIP,
+			})
+		}
+	}
+
+	return active
+}
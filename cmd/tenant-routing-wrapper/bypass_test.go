@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+)
+
+// TestReconcileBypass verifies rules are removed while bypassed and re-applied after expiry
+func TestReconcileBypass(t *testing.T) {
+	conf := &config.PluginConf{LockFile: filepath.Join(t.TempDir(), "tenant-routing.lock"), LockTimeout: 1}
+	const podIP = "10.200.1.5"
+
+	tests := []struct {
+		name        string
+		installed   bool
+		annotations k8s.RoutingAnnotations
+		wantActive  bool
+		wantRule    bool
+	}{
+		{
+			name:        "no bypass leaves state alone",
+			annotations: k8s.RoutingAnnotations{Fwmark: "0x10"},
+		},
+		{
+			name:        "active bypass removes rule",
+			installed:   true,
+			annotations: k8s.RoutingAnnotations{Fwmark: "0x10", BypassUntil: time.Now().Add(time.Hour)},
+			wantActive:  true,
+		},
+		{
+			name:        "expired bypass re-applies rule",
+			annotations: k8s.RoutingAnnotations{Fwmark: "0x10", BypassUntil: time.Now().Add(-time.Minute)},
+			wantRule:    true,
+		},
+		{
+			name:        "invalid bypass is ignored",
+			installed:   true,
+			annotations: k8s.RoutingAnnotations{Fwmark: "0x10", BypassError: fmt.Errorf("not RFC3339")},
+			wantRule:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipt := iptables.NewFakeManager()
+			if tt.installed {
+				if err := ipt.AddMarkRule(context.Background(), podIP, "0x10"); err != nil {
+					t.Fatalf("AddMarkRule() error = %v", err)
+				}
+			}
+
+			active := reconcileBypass(context.Background(), ipt, conf, "team-a", "web", podIP, tt.annotations)
+			if active != tt.wantActive {
+				t.Errorf("reconcileBypass() = %v, want %v", active, tt.wantActive)
+			}
+			if exists, _ := ipt.RuleExists(context.Background(), podIP, "0x10"); exists != tt.wantRule {
+				t.Errorf("rule exists = %v, want %v", exists, tt.wantRule)
+			}
+		})
+	}
+}
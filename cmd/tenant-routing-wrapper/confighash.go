@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// annotateNodeFunc sets the config hash annotation on this node; replaced in tests
+// Without a kubeconfig the config info metric is the only publication.
+var annotateNodeFunc = func(ctx context.Context, conf *config.PluginConf, hash string) error {
+	if conf.Kubeconfig == "" {
+		return nil
+	}
+	return annotateNode(ctx, conf, hash)
+}
+
+// publishConfigHash exposes the fingerprint of conf as the config info metric and
+// the node's tenant.routing/config-hash annotation
+// Runs on ADD and STATUS but only does work when the fingerprint differs from the one
+// last published; a failed publication is logged and retried on the next call.
+func publishConfigHash(ctx context.Context, conf *config.PluginConf) {
+	hash := conf.Fingerprint()
+	store := state.New(conf.StateDir)
+	if published, err := store.PublishedConfigHash(conf.Name); err == nil && published == hash {
+		return
+	}
+
+	if conf.MetricsFile != "" {
+		recorder, err := metrics.NewRecorder(conf.MetricsFile)
+		if err == nil {
+			err = recorder.SetConfigHash(hash)
+		}
+		if err != nil {
+			cniLog.Warnf("failed to record config hash %s: %v", hash, err)
+		}
+	}
+	if err := annotateNodeFunc(ctx, conf, hash); err != nil {
+		cniLog.Warnf("failed to publish config hash %s on the node: %v", hash, err)
+		return
+	}
+	if err := store.SetPublishedConfigHash(conf.Name, hash); err != nil {
+		cniLog.Warnf("%v", err)
+		return
+	}
+	cniLog.Infof("applied configuration %s of network %s", hash, conf.Name)
+}
+
+// configHashCommand implements `tenant-routing-wrapper config-hash`
+// Prints the fingerprint nodes will publish for a conflist, so rollout tooling can
+// compare it with the tenant.routing/config-hash annotation of every node.
+// Returns the process exit code.
+func configHashCommand(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("config-hash", flag.ContinueOnError)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "config-hash: --conflist is required")
+		return 2
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		cniLog.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		cniLog.Errorf("%v", err)
+		return 1
+	}
+	defer setupLogging(conf)()
+	fmt.Fprintln(stdout, conf.Fingerprint())
+	return 0
+}
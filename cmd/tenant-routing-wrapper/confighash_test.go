@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+)
+
+// TestPublishConfigHash verifies the fingerprint is published once per change and
+// retried after a failed node update
+func TestPublishConfigHash(t *testing.T) {
+	dir := t.TempDir()
+	var annotated []string
+	var annotateErr error
+	orig := annotateNodeFunc
+	annotateNodeFunc = func(_ context.Context, _ *config.PluginConf, hash string) error {
+		annotated = append(annotated, hash)
+		return annotateErr
+	}
+	t.Cleanup(func() { annotateNodeFunc = orig })
+
+	parse := func(strict string) *config.PluginConf {
+		t.Helper()
+		conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+			"type": "tenant-routing-wrapper", "kubeconfig": "/etc/kubeconfig", "delegate": {"type": "ptp"},
+			"stateDir": "` + filepath.Join(dir, "state") + `", "metricsFile": "` + filepath.Join(dir, "metrics.prom") + `",
+			"strict": ` + strict + `}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conf
+	}
+	v1, v2 := parse("false"), parse("true")
+
+	annotateErr = errors.New("API unreachable")
+	publishConfigHash(context.Background(), v1)
+	annotateErr = nil
+	publishConfigHash(context.Background(), v1)
+	publishConfigHash(context.Background(), v1)
+	publishConfigHash(context.Background(), v2)
+
+	want := []string{v1.Fingerprint(), v1.Fingerprint(), v2.Fingerprint()}
+	if strings.Join(annotated, ",") != strings.Join(want, ",") {
+		t.Errorf("node annotated with %v, want %v", annotated, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "metrics.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `tenant_routing_config_info{hash="`+v2.Fingerprint()+`"} 1`) {
+		t.Errorf("metrics file missing current config hash\n%s", data)
+	}
+}
+
+// TestConfigHashCommand verifies the printed fingerprint matches the one nodes publish
+func TestConfigHashCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "10-tenant.conflist")
+	conflist := `{"cniVersion": "1.0.0", "name": "test-network", "plugins": [
+		{"type": "tenant-routing-wrapper", "kubeconfig": "/etc/kubeconfig", "delegate": {"type": "ptp"}}]}`
+	if err := os.WriteFile(path, []byte(conflist), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.ParseConflist([]byte(conflist))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if got := configHashCommand([]string{"--conflist", path}, &stdout); got != 0 {
+		t.Fatalf("configHashCommand() = %d, want 0", got)
+	}
+	if stdout.String() != conf.Fingerprint()+"\n" {
+		t.Errorf("output = %q, want %q", stdout.String(), conf.Fingerprint()+"\n")
+	}
+
+	if got := configHashCommand(nil, &stdout); got != 2 {
+		t.Errorf("configHashCommand() without --conflist = %d, want 2", got)
+	}
+	if got := configHashCommand([]string{"--conflist", path + ".missing"}, &stdout); got != 1 {
+		t.Errorf("configHashCommand() with missing file = %d, want 1", got)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/nodelock"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/result"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// Plugin-specific error codes (CNI spec: 100 and above are free for plugins)
+const (
+	// errDelegateFailed: the delegate plugin failed without reporting an error of its own
+	errDelegateFailed uint = 100
+
+	// errInvalidAnnotation: the fwmark or gateway annotation of the pod is unusable
+	errInvalidAnnotation uint = 101
+
+	// errNoIPv4: the delegate assigned no IPv4 address to mark
+	errNoIPv4 uint = 102
+
+	// errRoutingFailed: the iptables rules or the policy routing of the pod could not be set up
+	errRoutingFailed uint = 103
+)
+
+// setupError is a routing setup step that failed an ADD in strict mode
+type setupError struct {
+	code reason.Code
+	err  error
+}
+
+func (e *setupError) Error() string { return e.err.Error() }
+
+func (e *setupError) Unwrap() error { return e.err }
+
+// cniError returns err as the *types.Error skel prints, so runtimes see the class of
+// the failure instead of the generic code 999
+// Errors that are a *types.Error already and errors of no known class are returned as
+// they are; the message is never changed. An error the delegate reported is returned
+// unmodified, so the runtime sees the delegate's code, message and details; the
+// wrapper's context of it is logged.
+func cniError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*types.Error); ok {
+		return err
+	}
+	if reported := delegate.ReportedError(err); reported != nil {
+		delegateLog.Errorf("%v", err)
+		return reported
+	}
+	code, ok := errorCode(err)
+	if !ok {
+		return err
+	}
+	return types.NewError(code, err.Error(), "")
+}
+
+// errorCode returns the CNI error code for the class of err, false if err has none
+func errorCode(err error) (uint, bool) {
+	var setup *setupError
+	if errors.As(err, &setup) {
+		return reasonErrorCode(setup.code), true
+	}
+	var cniErr *types.Error
+	switch {
+	case errors.Is(err, delegate.ErrDelegateFailed):
+		return errDelegateFailed, true
+	case errors.As(err, &cniErr) && cniErr.Code != 0:
+		return cniErr.Code, true
+	case errors.Is(err, tenant.ErrAPIUnavailable), errors.Is(err, nodelock.ErrTimeout), iptables.IsLocked(err):
+		return types.ErrTryAgainLater, true
+	case errors.Is(err, tenant.ErrInvalidFwmark), errors.Is(err, tenant.ErrInvalidGateway):
+		return errInvalidAnnotation, true
+	case errors.Is(err, result.ErrNoIPs), errors.Is(err, result.ErrNoIPv4):
+		return errNoIPv4, true
+	}
+	return 0, false
+}
+
+// reasonErrorCode returns the CNI error code of a strict-mode failure with code
+// Failures that may pass once the API server, the xtables lock or the node lock is
+// available again, or once a just-created pod is visible, ask the runtime to retry.
+func reasonErrorCode(code reason.Code) uint {
+	switch code {
+	case reason.K8sUnreachable, reason.PodNotFound, reason.IptablesLocked, reason.NodeLocked:
+		return types.ErrTryAgainLater
+	case reason.InvalidFwmark, reason.InvalidGateway:
+		return errInvalidAnnotation
+	case reason.NoPodIP:
+		return errNoIPv4
+	}
+	return errRoutingFailed
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/nodelock"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/result"
+)
+
+// fakeDelegateError is a delegate failure as pkg/delegate returns it
+type fakeDelegateError struct{ err error }
+
+func (e *fakeDelegateError) Error() string        { return e.err.Error() }
+func (e *fakeDelegateError) Unwrap() error        { return e.err }
+func (e *fakeDelegateError) Is(target error) bool { return target == delegate.ErrDelegateFailed }
+
+// TestCniError verifies failure classes map to CNI error codes with the message kept
+func TestCniError(t *testing.T) {
+	strict := func(code reason.Code) error {
+		return failurePolicy(context.Background(), &config.PluginConf{Strict: true}, nil, "team-a")(code, "step failed")
+	}
+	tests := []struct {
+		name     string
+		err      error
+		wantCode uint
+	}{
+		{name: "delegate without code", err: fmt.Errorf("delegation failed: %w", &fakeDelegateError{errors.New("exit status 1")}),
+			wantCode: errDelegateFailed},
+		{name: "delegate without error code", err: &fakeDelegateError{&types.Error{Msg: "netplugin failed: \"segfault\""}},
+			wantCode: errDelegateFailed},
+		{name: "no IPv4", err: fmt.Errorf("failed to extract pod IP from delegate result: %w", result.ErrNoIPv4),
+			wantCode: errNoIPv4},
+		{name: "no IPs", err: fmt.Errorf("failed to extract pod IP from delegate result: %w", result.ErrNoIPs),
+			wantCode: errNoIPv4},
+		{name: "invalid fwmark", err: fmt.Errorf("tenant: %w", k8s.ErrInvalidFwmark), wantCode: errInvalidAnnotation},
+		{name: "API unavailable", err: fmt.Errorf("lookup: %w", k8s.ErrK8sUnavailable), wantCode: types.ErrTryAgainLater},
+		{name: "node lock", err: fmt.Errorf("lock: %w", nodelock.ErrTimeout), wantCode: types.ErrTryAgainLater},
+		{name: "strict unreachable", err: strict(reason.K8sUnreachable), wantCode: types.ErrTryAgainLater},
+		{name: "strict invalid gateway", err: strict(reason.InvalidGateway), wantCode: errInvalidAnnotation},
+		{name: "strict iptables", err: strict(reason.IptablesFailed), wantCode: errRoutingFailed},
+		{name: "strict after rollback failure", err: errors.Join(strict(reason.RoutingFailed), errors.New("rollback failed")),
+			wantCode: errRoutingFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cniError(tt.err)
+			cniErr, ok := got.(*types.Error)
+			if !ok || cniErr.Code != tt.wantCode {
+				t.Fatalf("cniError(%v) = %#v, want code %d", tt.err, got, tt.wantCode)
+			}
+			if cniErr.Msg != tt.err.Error() {
+				t.Errorf("Msg = %q, want %q", cniErr.Msg, tt.err.Error())
+			}
+		})
+	}
+
+	// An error the delegate reported passes unmodified, also under the wrapper's context
+	reported := types.NewError(types.ErrTryAgainLater, "busy", "bridge locked")
+	for _, err := range []error{
+		fmt.Errorf("delegation failed: %w", &fakeDelegateError{fmt.Errorf("delegate plugin %q failed: %w", "bridge", reported)}),
+		errors.Join(&fakeDelegateError{reported}, errors.New("rollback failed")),
+	} {
+		if got := cniError(err); got != reported {
+			t.Errorf("cniError(%v) = %#v, want the delegate's error %#v", err, got, reported)
+		}
+	}
+
+	// Unclassified errors stay generic; *types.Error and nil pass unchanged
+	plain := errors.New("disk full")
+	if got := cniError(plain); got != plain {
+		t.Errorf("cniError(%v) = %#v, want it unchanged", plain, got)
+	}
+	if got := cniError(nil); got != nil {
+		t.Errorf("cniError(nil) = %v", got)
+	}
+	err := cmdAdd(&skel.CmdArgs{StdinData: []byte(`{invalid json}`)}, iptables.NewFakeManager())
+	if got, ok := cniError(err).(*types.Error); !ok || got.Code != types.ErrInvalidNetworkConfig {
+		t.Errorf("cniError() of an invalid config = %#v, want code %d", cniError(err), types.ErrInvalidNetworkConfig)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+)
+
+// podEventFunc records a Warning event about a pod; replaced in tests
+// Without a kubeconfig there is no API server to record it in.
+var podEventFunc = func(ctx context.Context, conf *config.PluginConf, podNamespace, podName, eventReason, message string) error {
+	if conf.Kubeconfig == "" {
+		return nil
+	}
+	return recordWarning(ctx, conf, podNamespace, podName, eventReason, message)
+}
+
+// withEvents wraps fail so that failures the pod's owners can act on also become a
+// Warning event on the pod (see reason.EventReason), shown by `kubectl describe pod`.
+// The event is recorded in permissive and strict mode alike; failing to record it is
+// logged only.
+func withEvents(ctx context.Context, conf *config.PluginConf, fail setupFailed, podNamespace, podName string) setupFailed {
+	return func(code reason.Code, format string, args ...interface{}) error {
+		err := fail(code, format, args...)
+		if eventReason := reason.EventReason(code); eventReason != "" {
+			message := fmt.Sprintf(format+" (reason=%s)", append(args, code)...)
+			if err := podEventFunc(ctx, conf, podNamespace, podName, eventReason, message); err != nil {
+				k8sLog.Warnf("pod %s/%s gets no %s event: %v", podNamespace, podName, eventReason, err)
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+)
+
+// TestWithEvents verifies failures app teams can act on become pod events without
+// changing the outcome of the failure handler
+func TestWithEvents(t *testing.T) {
+	type event struct{ reason, message string }
+	var events []event
+	recordErr := error(nil)
+	orig := podEventFunc
+	podEventFunc = func(_ context.Context, _ *config.PluginConf, podNamespace, podName, eventReason, message string) error {
+		if podNamespace != "team-a" || podName != "web" {
+			t.Errorf("event recorded on pod %s/%s", podNamespace, podName)
+		}
+		events = append(events, event{eventReason, message})
+		return recordErr
+	}
+	defer func() { podEventFunc = orig }()
+
+	permissiveFail := func(reason.Code, string, ...interface{}) error { return nil }
+	strictFail := func(code reason.Code, format string, args ...interface{}) error {
+		return fmt.Errorf("strict mode: "+format, args...)
+	}
+	conf := &config.PluginConf{}
+
+	tests := []struct {
+		name       string
+		fail       setupFailed
+		code       reason.Code
+		recordErr  error
+		wantReason string
+		wantErr    bool
+	}{
+		{name: "invalid fwmark", fail: permissiveFail, code: reason.InvalidFwmark, wantReason: k8s.InvalidFwmarkEventReason},
+		{name: "iptables failure", fail: permissiveFail, code: reason.IptablesFailed, wantReason: k8s.RoutingFailedEventReason},
+		{name: "strict failure", fail: strictFail, code: reason.RoutingFailed, wantReason: k8s.RoutingFailedEventReason, wantErr: true},
+		{name: "unrecorded event", fail: strictFail, code: reason.InvalidGateway, recordErr: errors.New("forbidden"),
+			wantReason: k8s.InvalidGatewayEventReason, wantErr: true},
+		{name: "no annotation", fail: permissiveFail, code: reason.NoAnnotation},
+		{name: "queued lock timeout", fail: permissiveFail, code: reason.IptablesLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, recordErr = nil, tt.recordErr
+			err := withEvents(context.Background(), conf, tt.fail, "team-a", "web")(tt.code, "pod %s failed", "web")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withEvents() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantReason == "" {
+				if len(events) != 0 {
+					t.Errorf("events = %v, want none", events)
+				}
+				return
+			}
+			want := event{tt.wantReason, fmt.Sprintf("pod web failed (reason=%s)", tt.code)}
+			if len(events) != 1 || events[0] != want {
+				t.Errorf("events = %v, want [%v]", events, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/cri"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/gc"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// cmdGC handles CNI GC command (CNI spec 1.1)
+// Called by the runtime with the attachments it still knows (cni.dev/valid-attachments)
+//
+// Flow:
+// 1. Parse CNI config
+// 2. Delegate GC to next CNI plugin (releases IPAM leases of stale attachments)
+// 3. Remove the rules and records of attachments no longer valid, locked (see pruneState)
+// 4. Install rules ADD queued because the xtables lock was held (see retryPending)
+// 5. Remove MARK rules of pods that no longer exist on this node (see collectGarbage)
+//
+// Rules of attachments without a state record are keyed by pod IP, which the
+// attachment list does not carry, so their liveness is taken from the API server.
+// Cleanup failures are logged only; a delegate failure is returned after cleanup ran.
+func cmdGC(args *skel.CmdArgs, ipt iptables.Manager) error {
+	pluginConf, err := config.ParseConfig(args.StdinData)
+	if err != nil {
+		return types.NewError(types.ErrInvalidNetworkConfig, "failed to parse config", err.Error())
+	}
+	defer setupLogging(pluginConf)()
+	setupK8s(pluginConf)
+	setupDelegate(pluginConf)
+	ctx, cancel := invocationContext(pluginConf)
+	defer cancel()
+
+	var delegateErr error
+	if !pluginConf.Chained() {
+		delegateErr = delegate.DelegateGC(ctx, pluginConf.Delegate, pluginConf.Name, args.StdinData)
+	}
+	if delegateErr != nil {
+		gcLog.Warnf("delegate GC failed: %v", delegateErr)
+	}
+
+	pruneCaches(pluginConf)
+
+	unlock, err := acquireNodeLock(pluginConf)
+	if err != nil {
+		gcLog.Warnf("GC skipped rule changes: %v", err)
+	} else {
+		pruneState(ctx, ipt, pluginConf)
+		retryPending(ctx, ipt, pluginConf)
+
+		node, err := nodeName()
+		if err != nil {
+			gcLog.Warnf("GC skipped rule cleanup: %v", err)
+		} else if _, err := collectGarbage(ctx, ipt, pluginConf, apiLivePods(pluginConf, node), false); err != nil {
+			gcLog.Warnf("GC rule cleanup failed (%d valid attachments): %v", len(pluginConf.ValidAttachments), err)
+		}
+		unlock()
+	}
+
+	if delegateErr != nil {
+		return fmt.Errorf("delegate GC failed: %w", delegateErr)
+	}
+	return nil
+}
+
+// nodeName returns $NODE_NAME, falling back to the hostname (the kubelet default)
+func nodeName() (string, error) {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name, nil
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine node name: %w", err)
+	}
+	return name, nil
+}
+
+// livePodsFunc returns the pods whose rules GC must keep
+type livePodsFunc func(ctx context.Context) (tenant.LivePods, error)
+
+// criLivePods takes liveness from the sandboxes the container runtime still knows
+// Catches sandboxes the runtime removed without CNI DEL while the API server still lists the pod
+func criLivePods(endpoint string) livePodsFunc {
+	return func(ctx context.Context) (tenant.LivePods, error) {
+		ctx, cancel := context.WithTimeout(ctx, tenant.DefaultAPITimeout)
+		defer cancel()
+
+		sandboxes, err := cri.ListSandboxes(ctx, endpoint)
+		if err != nil {
+			return tenant.LivePods{}, err
+		}
+		return cri.LivePods(sandboxes, time.Now()), nil
+	}
+}
+
+// collectGarbage removes rules of pods that are not live
+// Conntrack entries of removed pod IPs are flushed and tenant routing is released
+// for tenants left without pods, exactly as DEL would have done.
+func collectGarbage(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, livePods livePodsFunc,
+	dryRun bool) (*gc.Result, error) {
+	live, err := livePods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := gc.Collect(ctx, live, gc.Options{Connmark: conf.Connmark, DryRun: dryRun})
+	if result == nil {
+		return nil, err
+	}
+
+	if len(result.DeferredBy) > 0 {
+		gcLog.Infof("GC kept %d orphaned rules: pods still starting: %v",
+			len(result.Orphans), result.DeferredBy)
+	}
+	for _, rule := range result.Removed {
+		gcLog.Infof("GC removed orphaned rule: %s", rule)
+	}
+	if len(result.Removed) > 0 {
+		for _, ip := range result.OrphanIPs() {
+			flushConntrack(conf, ip)
+		}
+		releaseAllTenantRoutes(ctx, ipt, conf)
+	}
+
+	return result, err
+}
+
+// gcCommand implements the standalone invocation:
+//
+//	tenant-routing-wrapper gc --conflist /etc/cni/net.d/10-tenant.conflist [--node NAME] [--dry-run]
+//	tenant-routing-wrapper gc --conflist ... --source cri [--runtime-endpoint unix:///run/containerd/containerd.sock]
+//
+// With --interval the collection repeats until SIGINT/SIGTERM, so it can run as a
+// node sidecar and catch sandboxes the runtime removed without calling DEL.
+//
+// Returns the process exit code.
+func gcCommand(ipt iptables.Manager, args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	node := fs.String("node", "", "node whose pods are live (default $NODE_NAME, then hostname)")
+	source := fs.String("source", "api", "liveness source: api (pods on the node) or cri (runtime sandboxes)")
+	endpoint := fs.String("runtime-endpoint", "", "CRI endpoint for --source cri (default: crictl configuration)")
+	interval := fs.Duration("interval", 0, "repeat every interval until interrupted (0: run once)")
+	dryRun := fs.Bool("dry-run", false, "report orphaned rules without deleting them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "gc: --conflist is required")
+		return 2
+	}
+	if *interval < 0 {
+		fmt.Fprintln(fs.Output(), "gc: --interval must not be negative")
+		return 2
+	}
+	if *source != "api" && *source != "cri" {
+		fmt.Fprintf(fs.Output(), "gc: unknown --source %q (api or cri)\n", *source)
+		return 2
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		gcLog.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		gcLog.Errorf("%v", err)
+		return 1
+	}
+	defer setupLogging(conf)()
+	setupK8s(conf)
+
+	var livePods livePodsFunc
+	switch *source {
+	case "api":
+		if *node == "" {
+			name, err := nodeName()
+			if err != nil {
+				gcLog.Errorf("%v", err)
+				return 1
+			}
+			*node = name
+		}
+		livePods = apiLivePods(conf, *node)
+	case "cri":
+		livePods = criLivePods(*endpoint)
+	}
+
+	if *interval == 0 {
+		if err := runGCPass(context.Background(), ipt, conf, livePods, *dryRun, stdout); err != nil {
+			gcLog.Errorf("GC failed: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		// A failed pass is retried on the next tick
+		if err := runGCPass(ctx, ipt, conf, livePods, *dryRun, stdout); err != nil {
+			gcLog.Warnf("GC pass failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+// runGCPass runs one collection and prints every orphan with its outcome
+// Rules queued by ADD (see queuePodRules) are installed first, unless dryRun is set.
+// Changes are made holding the node lock.
+func runGCPass(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, livePods livePodsFunc, dryRun bool,
+	stdout io.Writer) error {
+	if !dryRun {
+		unlock, err := acquireNodeLock(conf)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if installed, pending := retryPending(ctx, ipt, conf); installed+pending > 0 {
+			fmt.Fprintf(stdout, "queued\t%d installed, %d still pending\n", installed, pending)
+		}
+	}
+	result, err := collectGarbage(ctx, ipt, conf, livePods, dryRun)
+	if result != nil {
+		removed := map[string]bool{}
+		for _, rule := range result.Removed {
+			removed[rule.String()] = true
+		}
+		for _, rule := range result.Orphans {
+			status := "orphan"
+			if removed[rule.String()] {
+				status = "removed"
+			}
+			fmt.Fprintf(stdout, "%s\t%s\n", status, rule)
+		}
+	}
+	return err
+}
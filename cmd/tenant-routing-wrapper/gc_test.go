@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+)
+
+// TestGCCommand_Usage verifies argument and configuration errors are reported before any API access
+func TestGCCommand_Usage(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.conflist")
+	if err := os.WriteFile(invalid, []byte(`{"name":"n","plugins":[{"type":"bridge"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "missing conflist", args: []string{"--node", "node-1"}, want: 2},
+		{name: "unknown flag", args: []string{"--bogus"}, want: 2},
+		{name: "unreadable conflist", args: []string{"--conflist", filepath.Join(dir, "missing"), "--node", "node-1"}, want: 1},
+		{name: "no wrapper plugin", args: []string{"--conflist", invalid, "--node", "node-1"}, want: 1},
+		{name: "unknown source", args: []string{"--conflist", invalid, "--source", "etcd"}, want: 2},
+		{name: "negative interval", args: []string{"--conflist", invalid, "--interval", "-1s"}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			if got := gcCommand(iptables.NewFakeManager(), tt.args, &stdout); got != tt.want {
+				t.Errorf("gcCommand() = %d, want %d", got, tt.want)
+			}
+			if stdout.Len() != 0 {
+				t.Errorf("unexpected output: %s", stdout.String())
+			}
+		})
+	}
+}
+
+// TestCmdGC_Errors verifies config errors fail GC and delegate failures are reported
+func TestCmdGC_Errors(t *testing.T) {
+	// No kubeconfig on disk: rule cleanup is skipped with a warning
+	t.Setenv("CNI_PATH", t.TempDir())
+	t.Setenv("NODE_NAME", "node-1")
+
+	tests := []struct {
+		name   string
+		stdin  string
+		errMsg string
+	}{
+		{name: "invalid config", stdin: `{invalid json}`, errMsg: "failed to parse config"},
+		{
+			name: "delegate missing",
+			stdin: `{"cniVersion":"1.1.0","name":"tenant-net","type":"tenant-routing-wrapper",
+				"kubeconfig":"/nonexistent/kubeconfig","stateDir":"` + t.TempDir() + `","delegate":{"type":"ptp"},
+				"cni.dev/valid-attachments":[{"containerID":"abc","ifname":"eth0"}]}`,
+			errMsg: "delegate GC failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cmdGC(&skel.CmdArgs{StdinData: []byte(tt.stdin)}, iptables.NewFakeManager())
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("cmdGC() error = %v, want containing %q", err, tt.errMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+//go:build !agentonly
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// healthCheck is the outcome of one component of the node health score
+type healthCheck struct {
+	// name is the check ("iptables"), also the check label of the health metric
+	name string
+
+	// reason is the TenantRoutingReady condition reason while the check fails
+	reason string
+
+	// err is nil when the check passes
+	err error
+
+	// detail is printed with a passing check ("" for none)
+	detail string
+}
+
+// healthAPIFunc checks that the API server answers for this node; replaced in tests
+var healthAPIFunc = func(ctx context.Context, conf *config.PluginConf, node string) error {
+	clientset, err := k8s.NewClient(conf.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create K8s client: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, k8s.K8sAPITimeout)
+	defer cancel()
+	if _, err := clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("failed to get node %s: %w", node, err)
+	}
+	return nil
+}
+
+// healthConditionFunc publishes the TenantRoutingReady condition; replaced in tests
+var healthConditionFunc = func(ctx context.Context, conf *config.PluginConf, node string, cond corev1.NodeCondition) error {
+	clientset, err := k8s.NewClient(conf.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create K8s client: %w", err)
+	}
+	return k8s.SetNodeCondition(ctx, clientset, node, cond, k8s.K8sAPITimeout)
+}
+
+// checkHealth runs the node health checks, in order:
+// 1. iptables is usable (listing the managed MARK rules succeeds)
+// 2. The API server answers (the node object can be read)
+// 3. No configured tenant gateway failed neighbor resolution (see route.CheckGateway)
+// 4. At most maxBacklog attachments have rules queued for GC (see retryPending)
+// 5. Every delegate plugin runs and supports the cniVersion of conf (see
+// delegate.DelegateVersion); passes when chained
+func checkHealth(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, node string, maxBacklog int) []healthCheck {
+	checks := make([]healthCheck, 0, 5)
+
+	_, err := ipt.List(ctx)
+	checks = append(checks, healthCheck{name: "iptables", reason: "IptablesUnavailable", err: err})
+
+	checks = append(checks, healthCheck{name: "apiserver", reason: "APIServerUnreachable", err: healthAPIFunc(ctx, conf, node)})
+
+	var gatewayErrs []error
+	if conf.Routing != nil {
+		fwmarks := make([]string, 0, len(conf.Routing.Tables))
+		for fwmark := range conf.Routing.Tables {
+			fwmarks = append(fwmarks, fwmark)
+		}
+		sort.Strings(fwmarks)
+		for _, fwmark := range fwmarks {
+			tr, ok, err := route.FromConfig(conf, fwmark, "")
+			if err == nil && ok {
+				err = route.CheckGateway(tr)
+			}
+			if err != nil {
+				gatewayErrs = append(gatewayErrs, err)
+			}
+		}
+	}
+	checks = append(checks, healthCheck{name: "gateways", reason: "GatewayUnreachable", err: errors.Join(gatewayErrs...)})
+
+	records, err := state.New(conf.StateDir).List(conf.Name)
+	if err == nil {
+		pending := 0
+		for _, rec := range records {
+			if rec.Pending {
+				pending++
+			}
+		}
+		if pending > maxBacklog {
+			err = fmt.Errorf("%d attachments have queued rules (max %d)", pending, maxBacklog)
+		}
+	}
+	checks = append(checks, healthCheck{name: "backlog", reason: "ReconcileBacklog", err: err})
+
+	// A binary older than the configuration would only fail at the next pod's ADD
+	check := healthCheck{name: "delegate", reason: "DelegateUnavailable", detail: "chained"}
+	if !conf.Chained() {
+		versions, err := delegate.DelegateVersion(ctx, conf.Delegate, conf.CNIVersion)
+		answers := make([]string, len(versions))
+		for i, v := range versions {
+			answers[i] = v.String()
+		}
+		check.err, check.detail = err, strings.Join(answers, "; ")
+	}
+	checks = append(checks, check)
+
+	return checks
+}
+
+// healthScore returns the fraction of checks passing (0-1)
+func healthScore(checks []healthCheck) float64 {
+	if len(checks) == 0 {
+		return 1
+	}
+	passed := 0
+	for _, c := range checks {
+		if c.err == nil {
+			passed++
+		}
+	}
+	return float64(passed) / float64(len(checks))
+}
+
+// readyCondition summarizes checks as the TenantRoutingReady Node condition
+// The node is ready only if every check passes; otherwise the reason is the one of
+// the first failing check and the message lists all failures.
+func readyCondition(checks []healthCheck) corev1.NodeCondition {
+	cond := corev1.NodeCondition{
+		Type:    k8s.TenantRoutingReadyCondition,
+		Status:  corev1.ConditionTrue,
+		Reason:  "AllChecksPassed",
+		Message: "tenant routing is healthy",
+	}
+	var failures []error
+	for _, c := range checks {
+		if c.err == nil {
+			continue
+		}
+		if len(failures) == 0 {
+			cond.Status = corev1.ConditionFalse
+			cond.Reason = c.reason
+		}
+		failures = append(failures, fmt.Errorf("%s: %w", c.name, c.err))
+	}
+	if len(failures) > 0 {
+		cond.Message = errors.Join(failures...).Error()
+	}
+	return cond
+}
+
+// runHealthPass checks the node once, prints the results and publishes them as the
+// health metrics, with setCondition the node's TenantRoutingReady condition and, with
+// problems, node-problem-detector entries
+// Publication failures are logged only. Returns whether every check passed.
+func runHealthPass(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, node string, maxBacklog int, setCondition bool,
+	problems *problemReporter, stdout io.Writer) bool {
+	checks := checkHealth(ctx, ipt, conf, node, maxBacklog)
+	score := healthScore(checks)
+
+	results := make(map[string]bool, len(checks))
+	for _, c := range checks {
+		results[c.name] = c.err == nil
+		switch {
+		case c.err != nil:
+			fmt.Fprintf(stdout, "fail\t%s\t%v\n", c.name, c.err)
+		case c.detail != "":
+			fmt.Fprintf(stdout, "ok\t%s\t%s\n", c.name, c.detail)
+		default:
+			fmt.Fprintf(stdout, "ok\t%s\n", c.name)
+		}
+	}
+	fmt.Fprintf(stdout, "score\t%.2f\n", score)
+
+	if conf.MetricsFile != "" {
+		recorder, err := metrics.NewRecorder(conf.MetricsFile)
+		if err == nil {
+			err = recorder.SetHealth(score, results)
+		}
+		if err != nil {
+			healthLog.Warnf("failed to record node health: %v", err)
+		}
+	}
+	if setCondition {
+		if err := healthConditionFunc(ctx, conf, node, readyCondition(checks)); err != nil {
+			healthLog.Warnf("%v", err)
+		}
+	}
+	if problems != nil {
+		if err := problems.observe(checks); err != nil {
+			healthLog.Warnf("%v", err)
+		}
+	}
+	return score == 1
+}
+
+// healthCommand implements `tenant-routing-wrapper health`
+// Scores tenant routing on this node for autoscaling and drain automation:
+//
+//	tenant-routing-wrapper health --conflist /etc/cni/net.d/10-tenant.conflist [--max-backlog 0] [--node-condition] [--interval 30s]
+//		[--problem-log /var/log/tenant-routing-problems.log [--problem-after 3]]
+//
+// The score (passing checks / all checks, see checkHealth) is written to the metrics
+// file; --node-condition also sets the node's TenantRoutingReady condition. With
+// --interval checks repeat until SIGINT/SIGTERM, and --problem-log reports the checks
+// failing for --problem-after passes in a row to node-problem-detector (see problemReporter).
+//
+// Returns the process exit code; a single run exits 1 if any check fails.
+func healthCommand(ipt iptables.Manager, args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	maxBacklog := fs.Int("max-backlog", 0, "attachments with queued rules tolerated before the node is unhealthy")
+	setCondition := fs.Bool("node-condition", false, "publish the result as the node's TenantRoutingReady condition")
+	interval := fs.Duration("interval", 0, "repeat every interval until interrupted (0: run once)")
+	problemLog := fs.String("problem-log", "", "append node-problem-detector entries for persistently failing checks to this file")
+	problemAfter := fs.Int("problem-after", defaultProblemAfter, "consecutive failing passes before a check is reported to --problem-log")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "health: --conflist is required")
+		return 2
+	}
+	if *maxBacklog < 0 || *interval < 0 {
+		fmt.Fprintln(fs.Output(), "health: --max-backlog and --interval must not be negative")
+		return 2
+	}
+	if *problemAfter < 1 {
+		fmt.Fprintln(fs.Output(), "health: --problem-after must be at least 1")
+		return 2
+	}
+	// Consecutive failures are counted across the passes of one process
+	if *problemLog != "" && *interval == 0 {
+		fmt.Fprintln(fs.Output(), "health: --problem-log requires --interval")
+		return 2
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		healthLog.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		healthLog.Errorf("%v", err)
+		return 1
+	}
+	defer setupLogging(conf)()
+	setupK8s(conf)
+	setupDelegate(conf)
+	node, err := nodeName()
+	if err != nil {
+		healthLog.Errorf("%v", err)
+		return 1
+	}
+
+	if *interval == 0 {
+		if !runHealthPass(context.Background(), ipt, conf, node, *maxBacklog, *setCondition, nil, stdout) {
+			return 1
+		}
+		return 0
+	}
+
+	var problems *problemReporter
+	if *problemLog != "" {
+		problems = newProblemReporter(*problemLog, *problemAfter)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		runHealthPass(ctx, ipt, conf, node, *maxBacklog, *setCondition, problems, stdout)
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,98 @@
+//go:build !agentonly
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestRunHealthPass verifies the score, the metrics and the TenantRoutingReady condition
+func TestRunHealthPass(t *testing.T) {
+	dir := t.TempDir()
+	metricsFile := filepath.Join(dir, "tenant_routing.prom")
+	conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/nonexistent/kubeconfig",
+		"stateDir": "` + dir + `", "metricsFile": "` + metricsFile + `",
+		"delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &state.Record{Network: "test-network", ContainerID: "a", IfName: "eth0", Namespace: "team-a",
+		Pod: "web-0", IPs: []string{"10.200.1.5"}, Fwmark: "0x10", Pending: true}
+	if err := state.New(conf.StateDir).Save(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	origAPI, origCondition := healthAPIFunc, healthConditionFunc
+	t.Cleanup(func() { healthAPIFunc, healthConditionFunc = origAPI, origCondition })
+	var apiErr error
+	healthAPIFunc = func(context.Context, *config.PluginConf, string) error { return apiErr }
+	var conditions []corev1.NodeCondition
+	healthConditionFunc = func(_ context.Context, _ *config.PluginConf, node string, cond corev1.NodeCondition) error {
+		if node != "node-1" {
+			t.Errorf("condition published for node %q", node)
+		}
+		conditions = append(conditions, cond)
+		return nil
+	}
+
+	t.Cleanup(delegate.SetExec(delegate.NewFakeExec()))
+	t.Setenv("CNI_PATH", "/opt/cni/bin")
+
+	ipt := iptables.NewFakeManager()
+	var out bytes.Buffer
+	if !runHealthPass(context.Background(), ipt, conf, "node-1", 1, true, nil, &out) {
+		t.Errorf("runHealthPass() = false with one queued attachment tolerated\n%s", out.String())
+	}
+	if want := "ok\tdelegate\tptp (/opt/cni/bin/ptp) supports CNI "; !strings.Contains(out.String(), want) {
+		t.Errorf("output missing %q\n%s", want, out.String())
+	}
+
+	ipt.Err = fmt.Errorf("xtables lock")
+	apiErr = fmt.Errorf("connection refused")
+	out.Reset()
+	if runHealthPass(context.Background(), ipt, conf, "node-1", 0, true, nil, &out) {
+		t.Errorf("runHealthPass() = true with failing checks\n%s", out.String())
+	}
+	for _, want := range []string{"fail\tiptables\txtables lock", "fail\tapiserver", "ok\tgateways", "fail\tbacklog", "score\t0.40"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q\n%s", want, out.String())
+		}
+	}
+
+	if len(conditions) != 2 {
+		t.Fatalf("conditions = %+v, want 2", conditions)
+	}
+	if c := conditions[0]; c.Type != k8s.TenantRoutingReadyCondition || c.Status != corev1.ConditionTrue {
+		t.Errorf("healthy condition = %+v", c)
+	}
+	if c := conditions[1]; c.Status != corev1.ConditionFalse || c.Reason != "IptablesUnavailable" ||
+		!strings.Contains(c.Message, "backlog: 1 attachments have queued rules") {
+		t.Errorf("unhealthy condition = %+v", c)
+	}
+
+	data, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"tenant_routing_health_score 0.4", `tenant_routing_health_check{check="gateways"} 1`,
+		`tenant_routing_health_check{check="iptables"} 0`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("metrics file missing %q\n%s", want, data)
+		}
+	}
+}
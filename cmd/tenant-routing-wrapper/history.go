@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// historyCommand implements the standalone invocation:
+//
+//	tenant-routing-wrapper history --conflist /etc/cni/net.d/10-tenant.conflist --pod team-a/web
+//		[--from 2024-05-01T00:00:00Z] [--to 2024-05-02T00:00:00Z] [--json]
+//
+// It answers which tenant a pod was routed as: every recorded assignment (ADD, DEL,
+// GC, migrate, relabel, bypass) of the pod on this node, oldest first. With --from
+// and --to only the assignments in effect at some point of that window are printed,
+// including the one made before --from. An empty fwmark means the pod was unmarked.
+//
+// Returns the process exit code.
+func historyCommand(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	podFlag := fs.String("pod", "", "pod as namespace/name")
+	fromFlag := fs.String("from", "", "start of the window, RFC 3339 (default: the first assignment)")
+	toFlag := fs.String("to", "", "end of the window, RFC 3339 (default: now)")
+	asJSON := fs.Bool("json", false, "print the assignments as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "history: --conflist is required")
+		return 2
+	}
+	namespace, pod, ok := strings.Cut(*podFlag, "/")
+	if !ok || namespace == "" || pod == "" {
+		fmt.Fprintln(fs.Output(), "history: --pod must be namespace/name")
+		return 2
+	}
+	from, to := time.Time{}, time.Now()
+	for _, bound := range []struct {
+		flag  string
+		value *string
+		t     *time.Time
+	}{{"--from", fromFlag, &from}, {"--to", toFlag, &to}} {
+		if *bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, *bound.value)
+		if err != nil {
+			fmt.Fprintf(fs.Output(), "history: %s: %v\n", bound.flag, err)
+			return 2
+		}
+		*bound.t = t
+	}
+	if to.Before(from) {
+		fmt.Fprintln(fs.Output(), "history: --to is before --from")
+		return 2
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		cniLog.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		cniLog.Errorf("%v", err)
+		return 1
+	}
+	defer setupLogging(conf)()
+
+	history, err := state.New(conf.StateDir).History(conf.Name, namespace, pod)
+	if err != nil {
+		cniLog.Errorf("%v", err)
+		return 1
+	}
+	history = state.Between(history, from, to)
+
+	if *asJSON {
+		if history == nil {
+			history = []state.Assignment{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(history); err != nil {
+			cniLog.Errorf("%v", err)
+			return 1
+		}
+		return 0
+	}
+	for _, a := range history {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\t%s\n", a.Time.UTC().Format(time.RFC3339), a.Cause,
+			orDash(a.Fwmark), orDash(a.Gateway), orDash(a.IP))
+	}
+	return 0
+}
+
+// orDash renders an empty column of the history as "-"
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestHistoryCommand verifies recorded assignments are printed for the requested window
+func TestHistoryCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "10-tenant.conflist")
+	conflist := `{"cniVersion": "1.0.0", "name": "test-network", "plugins": [
+		{"type": "tenant-routing-wrapper", "kubeconfig": "/etc/kubeconfig", "delegate": {"type": "ptp"},
+		 "stateDir": "` + filepath.Join(dir, "state") + `"}]}`
+	if err := os.WriteFile(path, []byte(conflist), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.ParseConflist([]byte(conflist))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	recordAssignment(conf, "team-a", "web", state.Assignment{Time: t0, Cause: state.CauseAdd, Fwmark: "0x10", IP: "10.0.0.5"})
+	recordAssignment(conf, "team-a", "web", state.Assignment{Time: t0.Add(time.Hour), Cause: state.CauseRelabel, Fwmark: "0x20", IP: "10.0.0.5"})
+	recordAssignment(conf, "team-a", "web", state.Assignment{Time: t0.Add(2 * time.Hour), Cause: state.CauseDelete, IP: "10.0.0.5"})
+
+	var stdout bytes.Buffer
+	args := []string{"--conflist", path, "--pod", "team-a/web", "--from", "2024-05-01T10:30:00Z", "--to", "2024-05-01T11:30:00Z"}
+	if got := historyCommand(args, &stdout); got != 0 {
+		t.Fatalf("historyCommand() = %d, want 0", got)
+	}
+	want := "2024-05-01T10:00:00Z\tadd\t0x10\t-\t10.0.0.5\n" +
+		"2024-05-01T11:00:00Z\trelabel\t0x20\t-\t10.0.0.5\n"
+	if stdout.String() != want {
+		t.Errorf("output = %q, want %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	if got := historyCommand([]string{"--conflist", path, "--pod", "team-a/web", "--json"}, &stdout); got != 0 {
+		t.Fatalf("historyCommand(--json) = %d, want 0", got)
+	}
+	var history []state.Assignment
+	if err := json.Unmarshal(stdout.Bytes(), &history); err != nil || len(history) != 3 || history[2].Cause != state.CauseDelete {
+		t.Errorf("JSON output = %s (%v), want all 3 assignments", stdout.String(), err)
+	}
+
+	stdout.Reset()
+	if got := historyCommand([]string{"--conflist", path, "--pod", "team-b/api", "--json"}, &stdout); got != 0 || stdout.String() != "[]\n" {
+		t.Errorf("historyCommand() of an unknown pod = %d, %q; want 0, []", got, stdout.String())
+	}
+
+	for _, args := range [][]string{
+		{"--pod", "team-a/web"},
+		{"--conflist", path, "--pod", "web"},
+		{"--conflist", path, "--pod", "team-a/web", "--from", "yesterday"},
+		{"--conflist", path, "--pod", "team-a/web", "--from", "2024-05-02T00:00:00Z", "--to", "2024-05-01T00:00:00Z"},
+	} {
+		if got := historyCommand(args, &bytes.Buffer{}); got != 2 {
+			t.Errorf("historyCommand(%v) = %d, want 2", args, got)
+		}
+	}
+}
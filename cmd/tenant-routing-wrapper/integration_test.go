@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
 )
 
 // Integration tests for CNI command handlers
@@ -58,7 +66,7 @@ func TestCmdAdd_MissingCNIArgs(t *testing.T) {
 				StdinData:   stdinData,
 			}
 
-			err := cmdAdd(cmdArgs)
+			err := cmdAdd(cmdArgs, iptables.NewFakeManager())
 			if err == nil {
 				t.Fatal("expected error but got nil")
 			}
@@ -83,14 +91,14 @@ func TestCmdAdd_InvalidConfig(t *testing.T) {
 			wantErr:   "failed to parse config",
 		},
 		{
-			name: "missing delegate",
+			name: "missing delegate and prevResult",
 			stdinData: []byte(`{
 				"cniVersion": "1.0.0",
 				"name": "test-network",
 				"type": "tenant-routing-wrapper",
 				"kubeconfig": "/etc/cni/net.d/kubeconfig"
 			}`),
-			wantErr: "delegate plugin configuration is required",
+			wantErr: "no delegate configured and no prevResult",
 		},
 		{
 			name: "relative kubeconfig path",
@@ -116,7 +124,7 @@ func TestCmdAdd_InvalidConfig(t *testing.T) {
 				StdinData:   tt.stdinData,
 			}
 
-			err := cmdAdd(cmdArgs)
+			err := cmdAdd(cmdArgs, iptables.NewFakeManager())
 			if err == nil {
 				t.Fatal("expected error but got nil")
 			}
@@ -158,7 +166,7 @@ func TestCmdAdd_ValidationOrderIsCorrect(t *testing.T) {
 		StdinData:   stdinData,
 	}
 
-	err := cmdAdd(cmdArgs)
+	err := cmdAdd(cmdArgs, iptables.NewFakeManager())
 	if err == nil {
 		t.Fatal("expected error but got nil")
 	}
@@ -208,10 +216,10 @@ func TestCmdDel_Idempotent(t *testing.T) {
 			wantErr: false, // DEL should be tolerant
 		},
 		{
-			name: "invalid config should not fail",
-			args: "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
+			name:      "invalid config should not fail",
+			args:      "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
 			stdinData: []byte(`{invalid json}`),
-			wantErr: false, // DEL should be tolerant even with invalid config
+			wantErr:   false, // DEL should be tolerant even with invalid config
 		},
 	}
 
@@ -233,7 +241,7 @@ func TestCmdDel_Idempotent(t *testing.T) {
 				}
 			}()
 
-			err := cmdDel(cmdArgs)
+			err := cmdDel(cmdArgs, iptables.NewFakeManager())
 			if tt.wantErr && err == nil {
 				t.Error("expected error but got nil")
 			}
@@ -254,8 +262,28 @@ func TestCleanupIptablesRules(t *testing.T) {
 	}()
 
 	// These will fail validation but should not panic
-	cleanupIptablesRules("10.200.1.5")
-	cleanupIptablesRules("")
+	cleanupIptablesRules(context.Background(), iptables.NewFakeManager(), "10.200.1.5")
+	cleanupIptablesRules(context.Background(), iptables.NewFakeManager(), "")
+}
+
+// TestCleanupIptablesRules_RemovesAnyFwmark verifies cleanup without a known fwmark
+func TestCleanupIptablesRules_RemovesAnyFwmark(t *testing.T) {
+	ipt := iptables.NewFakeManager()
+	if err := ipt.AddMarkRule(context.Background(), "10.200.1.5", "0x20"); err != nil {
+		t.Fatalf("AddMarkRule() error = %v", err)
+	}
+	if err := ipt.AddMarkRule(context.Background(), "10.200.1.6", "0x20"); err != nil {
+		t.Fatalf("AddMarkRule() error = %v", err)
+	}
+
+	cleanupIptablesRules(context.Background(), ipt, "10.200.1.5")
+
+	if count, err := countMarkRules(context.Background(), ipt, "0x20"); err != nil || count != 1 {
+		t.Errorf("countMarkRules() = %d, %v; want 1 remaining rule", count, err)
+	}
+	if exists, _ := ipt.RuleExists(context.Background(), "10.200.1.5", "0x20"); exists {
+		t.Error("rule for cleaned up IP still exists")
+	}
 }
 
 // TestCmdCheck_InvalidConfig verifies CHECK returns errors for invalid config
@@ -271,7 +299,7 @@ func TestCmdCheck_InvalidConfig(t *testing.T) {
 		StdinData:   stdinData,
 	}
 
-	err := cmdCheck(cmdArgs)
+	err := cmdCheck(cmdArgs, iptables.NewFakeManager())
 	if err == nil {
 		t.Fatal("expected error for invalid config")
 	}
@@ -318,3 +346,282 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+// TestCmdAdd_NoIPs verifies an L2-only delegate result is skipped or rejected per noIPs
+func TestCmdAdd_NoIPs(t *testing.T) {
+	// Stub delegate returning an interface but no addresses (macvlan without IPAM)
+	dir := t.TempDir()
+	stub := "#!/bin/sh\necho '{\"cniVersion\":\"1.0.0\",\"interfaces\":[{\"name\":\"eth0\"}]}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "l2only"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	tests := []struct {
+		name    string
+		noIPs   string
+		wantErr bool
+	}{
+		{name: "default skips marking", noIPs: ""},
+		{name: "fail rejects the ADD", noIPs: `"noIPs": "fail",`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := []byte(`{
+				"cniVersion": "1.0.0",
+				"name": "test-network",
+				"type": "tenant-routing-wrapper",
+				"kubeconfig": "/etc/cni/net.d/kubeconfig",
+				` + tt.noIPs + `
+				"delegate": {"type": "l2only"}
+			}`)
+			ipt := iptables.NewFakeManager()
+
+			err := cmdAdd(&skel.CmdArgs{
+				ContainerID: "test-container-123",
+				IfName:      "eth0",
+				Args:        "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
+				StdinData:   stdin,
+			}, ipt)
+			if tt.wantErr {
+				if err == nil || !containsSubstring(err.Error(), "no IP addresses") {
+					t.Errorf("expected no IP addresses error, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cmdAdd() error = %v", err)
+			}
+			if rules, _ := ipt.List(context.Background()); len(rules) != 0 {
+				t.Errorf("expected no MARK rules, got %v", rules)
+			}
+		})
+	}
+}
+
+// TestCmdDel_StateRecord verifies DEL removes the recorded rules without the Kubernetes API
+func TestCmdDel_StateRecord(t *testing.T) {
+	// Stub delegate accepting DEL
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "noop"), []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "DEL")
+
+	stateDir := t.TempDir()
+	store := state.New(stateDir)
+	if err := store.Save(&state.Record{
+		Network: "test-network", ContainerID: "test-container-123", IfName: "eth0",
+		Namespace: "default", Pod: "test", IPs: []string{"10.200.1.5"}, Fwmark: "0x10",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ipt := iptables.NewFakeManager()
+	for _, ip := range []string{"10.200.1.5", "10.200.1.6"} {
+		if err := ipt.AddMarkRule(context.Background(), ip, "0x10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The kubeconfig does not exist: the record is the only source of the fwmark
+	// No prevResult either: the record also provides the pod IP
+	args := &skel.CmdArgs{
+		ContainerID: "test-container-123",
+		IfName:      "eth0",
+		Args:        "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
+		StdinData: []byte(`{
+			"cniVersion": "1.0.0",
+			"name": "test-network",
+			"type": "tenant-routing-wrapper",
+			"kubeconfig": "/nonexistent/kubeconfig",
+			"stateDir": "` + stateDir + `",
+			"delegate": {"type": "noop"}
+		}`),
+	}
+	if err := cmdDel(args, ipt); err != nil {
+		t.Fatalf("cmdDel() error = %v", err)
+	}
+
+	if exists, _ := ipt.RuleExists(context.Background(), "10.200.1.5", "0x10"); exists {
+		t.Error("recorded MARK rule still installed after DEL")
+	}
+	if exists, _ := ipt.RuleExists(context.Background(), "10.200.1.6", "0x10"); !exists {
+		t.Error("MARK rule of another pod removed by DEL")
+	}
+	if _, err := store.Load("test-network", "test-container-123", "eth0"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("state record not removed after DEL: %v", err)
+	}
+
+	// DEL is idempotent
+	if err := cmdDel(args, ipt); err != nil {
+		t.Errorf("second cmdDel() error = %v", err)
+	}
+}
+
+// TestCmdAdd_StrictRollback verifies strict mode fails the ADD and rolls back the delegate
+func TestCmdAdd_StrictRollback(t *testing.T) {
+	// Stub delegate: ADD assigns an address, DEL records its stdin
+	dir := t.TempDir()
+	delStdin := filepath.Join(dir, "del-stdin")
+	stub := `#!/bin/sh
+if [ "$CNI_COMMAND" = "DEL" ]; then
+	cat > ` + delStdin + `
+	exit 0
+fi
+echo '{"cniVersion":"1.0.0","ips":[{"address":"10.200.1.5/24"}]}'
+`
+	if err := os.WriteFile(filepath.Join(dir, "stub"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	tests := []struct {
+		name    string
+		strict  string
+		wantErr bool
+	}{
+		{name: "permissive starts the pod unmarked", strict: "false"},
+		{name: "strict rolls back", strict: "true", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(delStdin)
+
+			// The kubeconfig does not exist: the fwmark cannot be resolved
+			err := cmdAdd(&skel.CmdArgs{
+				ContainerID: "test-container-123",
+				IfName:      "eth0",
+				Args:        "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
+				StdinData: []byte(`{
+					"cniVersion": "1.0.0",
+					"name": "test-network",
+					"type": "tenant-routing-wrapper",
+					"kubeconfig": "/nonexistent/kubeconfig",
+					"stateDir": "` + t.TempDir() + `",
+					"strict": ` + tt.strict + `,
+					"delegate": {"type": "stub"}
+				}`),
+			}, iptables.NewFakeManager())
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("cmdAdd() error = %v", err)
+				}
+				if _, err := os.Stat(delStdin); err == nil {
+					t.Error("delegate DEL called in permissive mode")
+				}
+				return
+			}
+
+			if err == nil || !containsSubstring(err.Error(), "reason=K8S_UNREACHABLE") {
+				t.Fatalf("cmdAdd() error = %v, want strict K8S_UNREACHABLE failure", err)
+			}
+			data, err := os.ReadFile(delStdin)
+			if err != nil {
+				t.Fatalf("delegate DEL not called: %v", err)
+			}
+			var conf struct {
+				PrevResult struct {
+					IPs []struct {
+						Address string `json:"address"`
+					} `json:"ips"`
+				} `json:"prevResult"`
+			}
+			if err := json.Unmarshal(data, &conf); err != nil {
+				t.Fatalf("invalid DEL stdin %s: %v", data, err)
+			}
+			if len(conf.PrevResult.IPs) != 1 || conf.PrevResult.IPs[0].Address != "10.200.1.5/24" {
+				t.Errorf("DEL prevResult = %s, want the delegate ADD result", data)
+			}
+		})
+	}
+}
+
+// TestCmdAdd_RollbackOnResultError verifies a delegate result the wrapper cannot use
+// fails the ADD with the delegate rolled back, independent of strict mode
+func TestCmdAdd_RollbackOnResultError(t *testing.T) {
+	// Stub delegate: ADD assigns no address, DEL records that it was called
+	dir := t.TempDir()
+	delCalled := filepath.Join(dir, "del-called")
+	stub := `#!/bin/sh
+if [ "$CNI_COMMAND" = "DEL" ]; then
+	touch ` + delCalled + `
+	exit 0
+fi
+echo '{"cniVersion":"1.0.0","interfaces":[{"name":"eth0"}]}'
+`
+	if err := os.WriteFile(filepath.Join(dir, "stub"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	err := cmdAdd(&skel.CmdArgs{
+		ContainerID: "test-container-123",
+		IfName:      "eth0",
+		Args:        "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
+		StdinData: []byte(`{
+			"cniVersion": "1.0.0",
+			"name": "test-network",
+			"type": "tenant-routing-wrapper",
+			"kubeconfig": "/nonexistent/kubeconfig",
+			"stateDir": "` + t.TempDir() + `",
+			"noIPs": "fail",
+			"delegate": {"type": "stub"}
+		}`),
+	}, iptables.NewFakeManager())
+
+	if err == nil || !containsSubstring(err.Error(), "failed to extract pod IP") {
+		t.Fatalf("cmdAdd() error = %v, want pod IP extraction failure", err)
+	}
+	if _, err := os.Stat(delCalled); err != nil {
+		t.Errorf("delegate DEL not called: %v", err)
+	}
+}
+
+// TestCmdAdd_Chained verifies that without a delegate block the pod IP comes from
+// prevResult and no delegate is invoked, on ADD and on a failing ADD alike
+func TestCmdAdd_Chained(t *testing.T) {
+	// No CNI_PATH: any delegate invocation would fail
+	t.Setenv("CNI_PATH", "")
+	stateDir := t.TempDir()
+	stdin := func(strict bool) []byte {
+		return []byte(`{
+			"cniVersion": "1.0.0",
+			"name": "test-network",
+			"type": "tenant-routing-wrapper",
+			"kubeconfig": "/nonexistent/kubeconfig",
+			"stateDir": "` + stateDir + `",
+			"strict": ` + strconv.FormatBool(strict) + `,
+			"prevResult": {
+				"cniVersion": "1.0.0",
+				"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+				"ips": [{"address": "10.200.1.5/24", "interface": 0}]
+			}
+		}`)
+	}
+	cmdArgs := &skel.CmdArgs{
+		ContainerID: "test-container-123",
+		IfName:      "eth0",
+		Args:        "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
+	}
+
+	// The kubeconfig does not exist: permissive ADD passes prevResult through
+	cmdArgs.StdinData = stdin(false)
+	if err := cmdAdd(cmdArgs, iptables.NewFakeManager()); err != nil {
+		t.Fatalf("cmdAdd() error = %v", err)
+	}
+
+	// Strict ADD fails without a delegate to roll back
+	cmdArgs.StdinData = stdin(true)
+	err := cmdAdd(cmdArgs, iptables.NewFakeManager())
+	if err == nil || !containsSubstring(err.Error(), "reason=K8S_UNREACHABLE") || containsSubstring(err.Error(), "rollback") {
+		t.Errorf("cmdAdd() strict error = %v, want K8S_UNREACHABLE failure without rollback", err)
+	}
+}
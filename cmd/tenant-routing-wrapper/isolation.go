@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+)
+
+// probeEndpointFunc creates the endpoint a tenant is probed from; replaced in tests
+var probeEndpointFunc = route.AddProbeEndpoint
+
+// missingEnforcementFunc returns the enforcement rules not installed; replaced in tests
+var missingEnforcementFunc = iptables.MissingEnforcement
+
+// isolationCheck is the outcome of one probe of a tenant
+type isolationCheck struct {
+	// Name is "egress" or "isolation <fwmark>" for the gateway of another tenant
+	Name string `json:"name"`
+
+	Passed bool `json:"passed"`
+
+	// Detail is the route the probe took or why the check failed
+	Detail string `json:"detail"`
+}
+
+// isolationTenant is the report of one tenant
+type isolationTenant struct {
+	Fwmark  string           `json:"fwmark"`
+	Table   int              `json:"table"`
+	Gateway string           `json:"gateway,omitempty"`
+	ProbeIP string           `json:"probeIP"`
+	Checks  []isolationCheck `json:"checks"`
+}
+
+// isolationReport is the output of verify-isolation, kept as evidence of the check
+type isolationReport struct {
+	Node    string            `json:"node"`
+	Time    time.Time         `json:"time"`
+	Passed  bool              `json:"passed"`
+	Tenants []isolationTenant `json:"tenants"`
+}
+
+// verifyIsolationCommand implements `tenant-routing-wrapper verify-isolation`
+// Proves per-tenant isolation on this node, e.g. as compliance evidence:
+//
+//	tenant-routing-wrapper verify-isolation --conflist /etc/cni/net.d/10-tenant.conflist
+//		[--probe-cidr 192.0.2.0/24] [--dst 198.51.100.1] [--json]
+//
+// Every tenant of routing.tables is probed from a throwaway endpoint wired like a pod
+// (see route.AddProbeEndpoint) with an address from --probe-cidr. The probes are kernel
+// route lookups with the tenant fwmark entering on the endpoint, so nothing is sent:
+//   - egress: traffic to --dst leaves through the tenant's table and gateway
+//   - isolation <fwmark>: the gateway of each other tenant is unreachable, or protected
+//     by an installed enforcement rule of that tenant (routing.tables enforce)
+//
+// Gateways tenants share are not checked against each other. The endpoints are removed
+// again before the report is printed.
+//
+// Returns the process exit code: 0 if every check passed, 1 otherwise.
+func verifyIsolationCommand(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("verify-isolation", flag.ContinueOnError)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	probeCIDR := fs.String("probe-cidr", "192.0.2.0/24", "unused IPv4 range the probe endpoints are addressed from")
+	dstFlag := fs.String("dst", "198.51.100.1", "IPv4 destination outside the cluster the egress probes are routed to")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "verify-isolation: --conflist is required")
+		return 2
+	}
+	_, probeNet, err := net.ParseCIDR(*probeCIDR)
+	if err != nil || probeNet.IP.To4() == nil {
+		fmt.Fprintln(fs.Output(), "verify-isolation: --probe-cidr must be an IPv4 CIDR")
+		return 2
+	}
+	dst := net.ParseIP(*dstFlag)
+	if dst.To4() == nil {
+		fmt.Fprintln(fs.Output(), "verify-isolation: --dst must be an IPv4 address")
+		return 2
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		routeLog.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		routeLog.Errorf("%v", err)
+		return 1
+	}
+	defer setupLogging(conf)()
+	node, err := nodeName()
+	if err != nil {
+		routeLog.Errorf("%v", err)
+		return 1
+	}
+
+	report, err := verifyIsolation(context.Background(), conf, probeNet, dst)
+	if err != nil {
+		routeLog.Errorf("%v", err)
+		return 1
+	}
+	report.Node, report.Time = node, time.Now().UTC()
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			routeLog.Errorf("%v", err)
+			return 1
+		}
+	} else {
+		printIsolationReport(stdout, report)
+	}
+	if !report.Passed {
+		return 1
+	}
+	return 0
+}
+
+// verifyIsolation probes every tenant of conf, see verifyIsolationCommand
+func verifyIsolation(ctx context.Context, conf *config.PluginConf, probeNet *net.IPNet, dst net.IP) (isolationReport, error) {
+	if conf.Routing == nil || len(conf.Routing.Tables) == 0 {
+		return isolationReport{}, fmt.Errorf("plugin-managed routing is not configured (routing.tables)")
+	}
+
+	tenants := make([]route.TenantRoute, 0, len(conf.Routing.Tables))
+	for key := range conf.Routing.Tables {
+		tr, ok, err := route.FromConfig(conf, key, "")
+		if err != nil {
+			return isolationReport{}, err
+		}
+		if ok {
+			tenants = append(tenants, tr)
+		}
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Fwmark < tenants[j].Fwmark })
+
+	// The network and broadcast addresses are not used
+	if ones, bits := probeNet.Mask.Size(); 1<<(bits-ones)-2 < len(tenants) {
+		return isolationReport{}, fmt.Errorf("probe CIDR %s is too small for %d tenants", probeNet, len(tenants))
+	}
+
+	rules := enforcementRules(conf)
+	missing, err := missingEnforcementFunc(ctx, rules)
+	if err != nil {
+		return isolationReport{}, fmt.Errorf("cannot read tenant enforcement rules: %w", err)
+	}
+	enforced := make(map[iptables.EnforceRule]bool, len(rules))
+	for _, r := range rules {
+		enforced[r] = true
+	}
+	for _, r := range missing {
+		enforced[r] = false
+	}
+
+	report := isolationReport{Passed: true, Tenants: make([]isolationTenant, 0, len(tenants))}
+	for i, tr := range tenants {
+		probeIP := probeAddress(probeNet, i+1)
+		tenant := isolationTenant{Fwmark: fmt.Sprintf("%#x", tr.Fwmark), Table: tr.Table, ProbeIP: probeIP.String()}
+		if tr.Gateway != nil {
+			tenant.Gateway = tr.Gateway.String()
+		}
+		tenant.Checks = probeTenant(fmt.Sprintf("trprobe%d", i), probeIP, dst, tr, tenants, enforced)
+		for _, c := range tenant.Checks {
+			report.Passed = report.Passed && c.Passed
+		}
+		report.Tenants = append(report.Tenants, tenant)
+	}
+	return report, nil
+}
+
+// probeTenant runs the checks of tr from probe endpoint name; enforced tells which
+// configured enforcement rules are installed
+func probeTenant(name string, probeIP, dst net.IP, tr route.TenantRoute, tenants []route.TenantRoute,
+	enforced map[iptables.EnforceRule]bool) []isolationCheck {
+	remove, err := probeEndpointFunc(name, probeIP)
+	if err != nil {
+		return []isolationCheck{{Name: "probe", Detail: err.Error()}}
+	}
+	defer func() {
+		if err := remove(); err != nil {
+			routeLog.Warnf("failed to remove probe endpoint %s: %v", name, err)
+		}
+	}()
+
+	checks := make([]isolationCheck, 0, len(tenants))
+	egress := isolationCheck{Name: "egress"}
+	r, err := lookupFunc(probeIP, dst, tr.Fwmark)
+	switch {
+	case err != nil:
+		egress.Detail = err.Error()
+	case r.Table != tr.Table:
+		egress.Detail = fmt.Sprintf("%s, want table %d", r, tr.Table)
+	case tr.Gateway != nil && !tr.Gateway.Equal(r.Gateway):
+		egress.Detail = fmt.Sprintf("%s, want via %s", r, tr.Gateway)
+	default:
+		egress.Passed, egress.Detail = true, r.String()
+	}
+	checks = append(checks, egress)
+
+	for _, other := range tenants {
+		if other.Fwmark == tr.Fwmark || other.Gateway == nil || other.Gateway.Equal(tr.Gateway) {
+			continue
+		}
+		check := isolationCheck{Name: fmt.Sprintf("isolation %#x", other.Fwmark)}
+		rule := iptables.EnforceRule{Fwmark: fmt.Sprintf("%#x", other.Fwmark), Destination: other.Gateway.String() + "/32"}
+		r, err := lookupFunc(probeIP, other.Gateway, tr.Fwmark)
+		installed, configured := enforced[rule]
+		switch {
+		case err != nil:
+			check.Passed, check.Detail = true, fmt.Sprintf("gateway %s unreachable", other.Gateway)
+		case installed:
+			check.Passed, check.Detail = true, fmt.Sprintf("gateway %s (%s) dropped by enforcement", other.Gateway, r)
+		case configured:
+			check.Detail = fmt.Sprintf("gateway %s reachable (%s), enforcement rule not installed", other.Gateway, r)
+		default:
+			check.Detail = fmt.Sprintf("gateway %s reachable (%s), tenant %#x does not enforce", other.Gateway, r, other.Fwmark)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// probeAddress returns the n-th address of probeNet
+func probeAddress(probeNet *net.IPNet, n int) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	copy(ip, probeNet.IP.To4())
+	for i := net.IPv4len - 1; i >= 0 && n > 0; i-- {
+		sum := int(ip[i]) + n
+		ip[i], n = byte(sum), sum>>8
+	}
+	return ip
+}
+
+// printIsolationReport prints one line per check and the overall result
+func printIsolationReport(w io.Writer, report isolationReport) {
+	result := func(passed bool) string {
+		if passed {
+			return "PASS"
+		}
+		return "FAIL"
+	}
+	fmt.Fprintf(w, "node\t%s\t%s\n", report.Node, report.Time.Format(time.RFC3339))
+	for _, tenant := range report.Tenants {
+		for _, c := range tenant.Checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result(c.Passed), tenant.Fwmark, c.Name, c.Detail)
+		}
+	}
+	fmt.Fprintf(w, "result\t%s\n", result(report.Passed))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+)
+
+const isolationConf = `{"cniVersion": "1.0.0", "name": "test-network", "type": "tenant-routing-wrapper",
+	"kubeconfig": "/nonexistent/kubeconfig", "delegate": {"type": "ptp"},
+	"routing": {"tables": {
+		"0x10": {"table": 100, "gateway": "10.10.10.131", "enforce": true},
+		"0x20": {"table": 200, "gateway": "10.10.10.132", "enforce": true}}}}`
+
+// useFakeProbes replaces probe endpoints and the enforcement check; returns the live endpoints
+func useFakeProbes(t *testing.T, missing []iptables.EnforceRule) map[string]net.IP {
+	t.Helper()
+	origProbe, origMissing := probeEndpointFunc, missingEnforcementFunc
+	t.Cleanup(func() { probeEndpointFunc, missingEnforcementFunc = origProbe, origMissing })
+	live := map[string]net.IP{}
+	probeEndpointFunc = func(name string, ip net.IP) (func() error, error) {
+		live[name] = ip
+		return func() error {
+			delete(live, name)
+			return nil
+		}, nil
+	}
+	missingEnforcementFunc = func(context.Context, []iptables.EnforceRule) ([]iptables.EnforceRule, error) {
+		return missing, nil
+	}
+	return live
+}
+
+// runVerifyIsolation runs the command against isolationConf
+func runVerifyIsolation(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "10-tenant.conf")
+	if err := os.WriteFile(path, []byte(isolationConf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NODE_NAME", "node-1")
+	var stdout bytes.Buffer
+	code := verifyIsolationCommand(append([]string{"--conflist", path}, args...), &stdout)
+	return code, stdout.String()
+}
+
+// TestVerifyIsolation verifies egress and cross-tenant checks and that probes are removed
+func TestVerifyIsolation(t *testing.T) {
+	useFakeLookup(t, map[uint32]route.EffectiveRoute{
+		0x10: {Table: 100, Gateway: net.ParseIP("10.10.10.131"), Interface: "eth1"},
+		0x20: {Table: 200, Gateway: net.ParseIP("10.10.10.132"), Interface: "eth1"},
+	})
+	live := useFakeProbes(t, nil)
+
+	code, out := runVerifyIsolation(t, "--json")
+	if code != 0 {
+		t.Fatalf("verify-isolation = %d, want 0\n%s", code, out)
+	}
+	var report isolationReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("invalid JSON report: %v\n%s", err, out)
+	}
+	if report.Node != "node-1" || !report.Passed || len(report.Tenants) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	if got := report.Tenants[0]; got.Fwmark != "0x10" || got.ProbeIP != "192.0.2.1" || len(got.Checks) != 2 ||
+		got.Checks[1].Name != "isolation 0x20" || !strings.Contains(got.Checks[1].Detail, "dropped by enforcement") {
+		t.Errorf("tenant 0x10 = %+v", got)
+	}
+	if got := report.Tenants[1]; got.Fwmark != "0x20" || got.ProbeIP != "192.0.2.2" {
+		t.Errorf("tenant 0x20 = %+v", got)
+	}
+	if len(live) != 0 {
+		t.Errorf("probe endpoints left behind: %v", live)
+	}
+
+	// Tenant 0x20 egresses through the main table and its enforcement rule is gone
+	useFakeLookup(t, map[uint32]route.EffectiveRoute{
+		0x10: {Table: 100, Gateway: net.ParseIP("10.10.10.131"), Interface: "eth1"},
+		0x20: {Table: 254, Gateway: net.ParseIP("10.99.0.1"), Interface: "eth0"},
+	})
+	useFakeProbes(t, []iptables.EnforceRule{{Fwmark: "0x20", Destination: "10.10.10.132/32"}})
+	code, out = runVerifyIsolation(t)
+	if code != 1 {
+		t.Errorf("verify-isolation = %d, want 1\n%s", code, out)
+	}
+	for _, want := range []string{
+		"PASS\t0x10\tegress\tvia 10.10.10.131 dev eth1 table 100\n",
+		"FAIL\t0x10\tisolation 0x20\tgateway 10.10.10.132 reachable (via 10.10.10.131 dev eth1 table 100), enforcement rule not installed\n",
+		"FAIL\t0x20\tegress\tvia 10.99.0.1 dev eth0 table 254, want table 200\n",
+		"PASS\t0x20\tisolation 0x10\tgateway 10.10.10.131 (via 10.99.0.1 dev eth0 table 254) dropped by enforcement\n",
+		"result\tFAIL\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+}
+
+// TestVerifyIsolation_Usage verifies flag validation
+func TestVerifyIsolation_Usage(t *testing.T) {
+	useFakeProbes(t, nil)
+	for _, args := range [][]string{
+		{"--probe-cidr", "fd00::/64"},
+		{"--probe-cidr", "192.0.2.1"},
+		{"--dst", "example.com"},
+	} {
+		if code, _ := runVerifyIsolation(t, args...); code != 2 {
+			t.Errorf("verify-isolation %v = %d, want 2", args, code)
+		}
+	}
+	if code := verifyIsolationCommand(nil, &bytes.Buffer{}); code != 2 {
+		t.Errorf("verify-isolation without --conflist = %d, want 2", code)
+	}
+	// Two tenants do not fit a /31
+	if code, _ := runVerifyIsolation(t, "--probe-cidr", "192.0.2.0/31"); code != 1 {
+		t.Errorf("verify-isolation with a /31 = %d, want 1", code)
+	}
+}
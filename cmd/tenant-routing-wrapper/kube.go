@@ -0,0 +1,132 @@
+//go:build !agentonly
+
+package main
+
+// The Kubernetes API client of the plugin: pod lookups without a reachable node agent,
+// events, the config hash annotation of the node and the liveness of its pods.
+// The agent-only build (build tag agentonly, see kube_agentonly.go) links none of it.
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// setupClient applies the k8sRetryAttempts, k8sRetryBackoff, namespaceLabels and exclusion
+// settings of conf to the API lookups
+func setupClient(conf *config.PluginConf) {
+	k8s.SetRetryPolicy(k8s.RetryPolicy{
+		Attempts: conf.K8sRetryAttempts,
+		Backoff:  time.Duration(conf.K8sRetryBackoff) * time.Millisecond,
+	})
+	rules := make([]k8s.NamespaceLabelRule, 0, len(conf.NamespaceLabels))
+	for _, rule := range conf.NamespaceLabels {
+		rules = append(rules, k8s.NamespaceLabelRule{Selector: rule.Selector, Fwmark: rule.Fwmark})
+	}
+	// ParseConfig validated the selectors
+	if err := k8s.SetNamespaceLabelRules(rules); err != nil {
+		k8sLog.Warnf("namespace labels ignored: %v", err)
+	}
+	// ParseConfig validated the selector
+	if err := k8s.SetExclusions(conf.ExcludeNamespaces, conf.ExcludePodLabelSelector); err != nil {
+		k8sLog.Warnf("excludePodLabelSelector ignored: %v", err)
+	}
+}
+
+// apiSource reads annotations from the API server
+type apiSource struct {
+	conf      *config.PluginConf
+	clientset kubernetes.Interface
+	cache     *tenant.AnnotationCache
+}
+
+func newAPISource(conf *config.PluginConf, cache *tenant.AnnotationCache) (annotationSource, error) {
+	clientset, err := k8s.NewClient(conf.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return &apiSource{conf: conf, clientset: clientset, cache: cache}, nil
+}
+
+func (s *apiSource) RoutingAnnotations(ctx context.Context, podName, podNamespace, podUID string) (tenant.RoutingAnnotations,
+	error) {
+	defer observeK8sAPI("annotations", time.Now())
+	annotations, err := k8s.GetRoutingAnnotationsCached(ctx, s.clientset, s.cache, podName, podNamespace, podUID,
+		s.conf.AnnotationKey, s.conf.GatewayAnnotationKey, k8sTimeout(s.conf))
+	if err == nil && annotations.FallbackError != nil {
+		k8sLog.Warnf("pod %s/%s gets the tenant configured for its namespace: %v", podNamespace, podName,
+			annotations.FallbackError)
+	}
+	return annotations, err
+}
+
+func (s *apiSource) StrictOverride(ctx context.Context, namespace string) (*bool, error) {
+	defer observeK8sAPI("strict", time.Now())
+	return k8s.GetStrictOverride(ctx, s.clientset, namespace, k8sTimeout(s.conf))
+}
+
+// loadKubeconfig creates a client from kubeconfig, to tell whether it loads
+func loadKubeconfig(kubeconfig string) error {
+	_, err := k8s.NewClient(kubeconfig)
+	return err
+}
+
+// annotateNode sets the config hash annotation on this node
+func annotateNode(ctx context.Context, conf *config.PluginConf, hash string) error {
+	node, err := nodeName()
+	if err != nil {
+		return err
+	}
+	clientset, err := k8s.NewClient(conf.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create K8s client: %w", err)
+	}
+	return k8s.SetNodeAnnotation(ctx, clientset, node, api.ConfigHashAnnotationKey, hash, k8sTimeout(conf))
+}
+
+// recordWarning records a Warning event about a pod
+func recordWarning(ctx context.Context, conf *config.PluginConf, podNamespace, podName, eventReason, message string) error {
+	node, err := nodeName()
+	if err != nil {
+		return err
+	}
+	clientset, err := k8s.NewClient(conf.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create K8s client: %w", err)
+	}
+	return k8s.NewEventRecorder(clientset, node, k8sTimeout(conf)).Warningf(ctx, podNamespace, podName, eventReason, "%s", message)
+}
+
+// recordMarkApplied records the Normal event of a pod migrate marked after the fact
+func recordMarkApplied(ctx context.Context, conf *config.PluginConf, rec *state.Record, message string) error {
+	node, err := nodeName()
+	if err != nil {
+		return err
+	}
+	clientset, err := k8s.NewClient(conf.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to create K8s client: %w", err)
+	}
+	return k8s.RecordPodEvent(ctx, clientset, rec.Namespace, rec.Pod, node, corev1.EventTypeNormal,
+		api.MarkAppliedEventReason, message, k8sTimeout(conf))
+}
+
+// apiLivePods takes liveness from the pods the API server schedules to node
+func apiLivePods(conf *config.PluginConf, node string) livePodsFunc {
+	return func(ctx context.Context) (tenant.LivePods, error) {
+		clientset, err := k8s.NewClient(conf.Kubeconfig)
+		if err != nil {
+			return tenant.LivePods{}, fmt.Errorf("failed to create K8s client: %w", err)
+		}
+		return k8s.ListNodePods(ctx, clientset, node, k8sTimeout(conf))
+	}
+}
@@ -0,0 +1,107 @@
+//go:build agentonly
+
+package main
+
+// The agent-only build: the plugin asks tenant-routingd on agentSocket and links no
+// client-go. While the agent is unreachable, a configuration without a kubeconfig
+// answers from the static tenants; with one, pods and namespaces are read with the
+// minimal client of pkg/kuberest, which cannot resolve tenant name annotations.
+// Events, the node's config hash annotation and the health subcommand are left to the
+// full build.
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/kuberest"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// setupClient applies the namespaceLabels and exclusion settings of conf to the API
+// lookups; the retry settings are applied per client (see newAPISource)
+func setupClient(conf *config.PluginConf) {
+	rules := make([]kuberest.NamespaceLabelRule, 0, len(conf.NamespaceLabels))
+	for _, rule := range conf.NamespaceLabels {
+		rules = append(rules, kuberest.NamespaceLabelRule{Selector: rule.Selector, Fwmark: rule.Fwmark})
+	}
+	// ParseConfig validated the selectors
+	if err := kuberest.SetNamespaceLabelRules(rules); err != nil {
+		k8sLog.Warnf("namespace labels ignored: %v", err)
+	}
+	// ParseConfig validated the selector
+	if err := kuberest.SetExclusions(conf.ExcludeNamespaces, conf.ExcludePodLabelSelector); err != nil {
+		k8sLog.Warnf("excludePodLabelSelector ignored: %v", err)
+	}
+}
+
+// apiSource reads pods and namespaces from the API server with pkg/kuberest
+type apiSource struct {
+	conf   *config.PluginConf
+	client *kuberest.Client
+	cache  *tenant.AnnotationCache
+}
+
+func newAPISource(conf *config.PluginConf, cache *tenant.AnnotationCache) (annotationSource, error) {
+	client, err := kuberest.NewClient(conf.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client.Attempts = conf.K8sRetryAttempts
+	client.Backoff = time.Duration(conf.K8sRetryBackoff) * time.Millisecond
+	return &apiSource{conf: conf, client: client, cache: cache}, nil
+}
+
+func (s *apiSource) RoutingAnnotations(ctx context.Context, podName, podNamespace, podUID string) (tenant.RoutingAnnotations,
+	error) {
+	defer observeK8sAPI("annotations", time.Now())
+	annotations, err := s.client.RoutingAnnotations(ctx, s.cache, podName, podNamespace, podUID,
+		s.conf.AnnotationKey, s.conf.GatewayAnnotationKey, k8sTimeout(s.conf))
+	if err == nil && annotations.FallbackError != nil {
+		k8sLog.Warnf("pod %s/%s gets the tenant configured for its namespace: %v", podNamespace, podName,
+			annotations.FallbackError)
+	}
+	return annotations, err
+}
+
+func (s *apiSource) StrictOverride(ctx context.Context, namespace string) (*bool, error) {
+	defer observeK8sAPI("strict", time.Now())
+	return s.client.StrictOverride(ctx, namespace, k8sTimeout(s.conf))
+}
+
+// loadKubeconfig creates a client from kubeconfig, to tell whether it loads
+func loadKubeconfig(kubeconfig string) error {
+	_, err := kuberest.NewClient(kubeconfig)
+	return err
+}
+
+// annotateNode does nothing: as without a kubeconfig, the config info metric is the
+// only publication
+func annotateNode(context.Context, *config.PluginConf, string) error {
+	return nil
+}
+
+// recordWarning does nothing: there is no API client to record the event with
+func recordWarning(context.Context, *config.PluginConf, string, string, string, string) error {
+	return nil
+}
+
+// recordMarkApplied does nothing: there is no API client to record the event with
+func recordMarkApplied(context.Context, *config.PluginConf, *state.Record, string) error {
+	return nil
+}
+
+// apiLivePods takes liveness from the container runtime at its default endpoint: the
+// build cannot list the pods of node
+func apiLivePods(_ *config.PluginConf, _ string) livePodsFunc {
+	return criLivePods("")
+}
+
+// healthCommand is not available: the node condition is published with the API client
+func healthCommand(_ iptables.Manager, _ []string, _ io.Writer) int {
+	healthLog.Errorf("health: not available in the agent-only build")
+	return 2
+}
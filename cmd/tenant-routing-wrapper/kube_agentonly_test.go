@@ -0,0 +1,70 @@
+//go:build agentonly
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// apiFallbackError is part of the error of a lookup falling back to the API server
+// with a missing kubeconfig
+const apiFallbackError = "kubeconfig file does not exist"
+
+// TestAgentOnly_APIFallback verifies a pod lookup without the agent reads the pod and
+// its namespace from the API server, and fails like an unreachable one once it is gone
+func TestAgentOnly_APIFallback(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/team-a/pods/web":
+			fmt.Fprint(w, `{"metadata":{"name":"web","namespace":"team-a","uid":"uid-1"},"status":{"phase":"Running"}}`)
+		case "/api/v1/namespaces/team-a":
+			fmt.Fprintf(w, `{"metadata":{"name":"team-a","annotations":{%q:"0x10"}}}`, api.FwmarkAnnotationKey)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`current-context: node
+contexts: [{name: node, context: {cluster: local, user: node}}]
+clusters: [{name: local, cluster: {server: %q, certificate-authority-data: %s}}]
+users: [{name: node, user: {}}]
+`, server.URL, base64.StdEncoding.EncodeToString(ca))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.PluginConf{Kubeconfig: kubeconfig, AnnotationKey: []string{api.FwmarkAnnotationKey},
+		K8sRetryAttempts: 1, AgentSocket: filepath.Join(dir, "agent.sock")}
+
+	src, err := newAnnotationSource(conf, nil)
+	if err != nil {
+		t.Fatalf("newAnnotationSource() error = %v", err)
+	}
+	annotations, err := src.RoutingAnnotations(context.Background(), "web", "team-a", "")
+	if err != nil || annotations.Fwmark != "0x10" || annotations.Source != tenant.SourceNamespace ||
+		annotations.PodUID != "uid-1" {
+		t.Errorf("RoutingAnnotations() = %+v, %v; want 0x10 from the namespace", annotations, err)
+	}
+
+	server.Close()
+	src, _ = newAnnotationSource(conf, nil)
+	_, err = src.RoutingAnnotations(context.Background(), "web", "team-b", "")
+	if !errors.Is(err, tenant.ErrAPIUnavailable) || reason.ForAnnotationError(err) != reason.K8sUnreachable {
+		t.Errorf("lookup without agent and API server error = %v, want ErrAPIUnavailable (K8S_UNREACHABLE)", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !agentonly
+
+package main
+
+// apiFallbackError is part of the error of a lookup falling back to the API server
+// with a missing kubeconfig
+const apiFallbackError = "kubeconfig file does not exist"
@@ -7,26 +7,37 @@
 //
 // Responsibilities:
 //   - Delegates network setup to next CNI plugin (ptp)
-//   - Fetches fwmark annotation from pod or namespace
+//   - Fetches fwmark (and optional gateway) annotation from pod or namespace
 //   - Adds iptables mangle/PREROUTING rule: -s <pod-ip> -j MARK --set-mark <fwmark>
+//   - Optionally manages per-tenant policy routing (ip rule fwmark → table, default via gateway)
 //   - Returns delegate CNI Result unchanged (transparent wrapper)
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/version"
 
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
 	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/conntrack"
 	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
 	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
-	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/logging"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
 	"github.com/azalio/kubeCon-cni-wrapper/pkg/result"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
 )
 
 // Version information - injected at build time via ldflags
@@ -39,6 +50,74 @@ var (
 	date = "unknown"
 )
 
+// Component loggers; the component tag names the subsystem a log line is about
+var (
+	cniLog       = logging.Component("cni")
+	delegateLog  = logging.Component("delegate")
+	iptLog       = logging.Component("iptables")
+	k8sLog       = logging.Component("k8s")
+	routeLog     = logging.Component("route")
+	gcLog        = logging.Component("gc")
+	migrateLog   = logging.Component("migrate")
+	healthLog    = logging.Component("health")
+	reconcileLog = logging.Component("reconcile")
+)
+
+// setupLogging applies the logFormat, logLevel and logFile settings of conf
+// Returns a function closing the log file; if it cannot be opened, logs stay on stderr.
+func setupLogging(conf *config.PluginConf) func() {
+	closeLog, err := logging.Setup(logging.Options{Format: conf.LogFormat, Level: conf.LogLevel, File: conf.LogFile})
+	if err != nil {
+		cniLog.Warnf("keeping logs on stderr: %v", err)
+		return func() {}
+	}
+	return closeLog
+}
+
+// setupDelegate applies the securePaths setting of conf to the delegate plugin binaries
+func setupDelegate(conf *config.PluginConf) {
+	if conf.SecurePaths {
+		delegate.SetSecurePaths(conf.SecurePathRoots)
+	} else {
+		delegate.SetSecurePaths(nil)
+	}
+}
+
+// setupK8s applies the k8sRetryAttempts, k8sRetryBackoff, namespaceLabels, tenants and exclusion
+// settings of conf
+func setupK8s(conf *config.PluginConf) {
+	tenants := make(map[string]tenant.StaticTenant, len(conf.Tenants))
+	for namespace, static := range conf.Tenants {
+		tenants[namespace] = tenant.StaticTenant{Fwmark: static.Fwmark, Table: static.Table, Gateway: static.Gateway}
+	}
+	tenant.SetStaticTenants(tenants)
+	tenant.SetExcludedNamespaces(conf.ExcludeNamespaces)
+	setupClient(conf)
+}
+
+// processStart approximates when the runtime started this CNI invocation
+var processStart = time.Now()
+
+// k8sTimeout returns the Kubernetes API timeout for this invocation
+// Adapts to the time left in the configured operation budget (see tenant.APITimeout)
+func k8sTimeout(conf *config.PluginConf) time.Duration {
+	var deadline time.Time
+	if conf.OperationTimeout > 0 {
+		deadline = processStart.Add(time.Duration(conf.OperationTimeout) * time.Second)
+	}
+	return tenant.APITimeout(deadline, time.Now())
+}
+
+// invocationContext returns the context of this invocation: it ends when the configured
+// operation budget is used up, so no delegate, API or iptables call outlives the runtime
+func invocationContext(conf *config.PluginConf) (context.Context, context.CancelFunc) {
+	if conf.OperationTimeout > 0 {
+		return context.WithDeadline(context.Background(),
+			processStart.Add(time.Duration(conf.OperationTimeout)*time.Second))
+	}
+	return context.WithCancel(context.Background())
+}
+
 // parseCNIArgs extracts K8S_POD_NAME and K8S_POD_NAMESPACE from CNI_ARGS
 // CNI_ARGS format: "K8S_POD_NAME=foo;K8S_POD_NAMESPACE=bar;..."
 func parseCNIArgs(cniArgs string) (podName, podNamespace string, err error) {
@@ -70,6 +149,38 @@ func parseCNIArgs(cniArgs string) (podName, podNamespace string, err error) {
 	return podName, podNamespace, nil
 }
 
+// podUIDFromArgs returns K8S_POD_UID from CNI_ARGS ("" if the runtime does not pass it)
+func podUIDFromArgs(cniArgs string) string {
+	for _, pair := range strings.Split(cniArgs, ";") {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 && kv[0] == "K8S_POD_UID" {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+// resolvePodUID picks the UID identifying the pod: the runtime's (CNI_ARGS), which
+// names the sandbox being set up, or else the one of the fetched pod object
+// A mismatch means the pod name was reused since the sandbox was created.
+func resolvePodUID(podNamespace, podName, argsUID, fetchedUID string) string {
+	if argsUID == "" {
+		return fetchedUID
+	}
+	if fetchedUID != "" && fetchedUID != argsUID {
+		k8sLog.Warnf("pod %s/%s: CNI_ARGS names UID %s but the pod object has UID %s (name reused)",
+			podNamespace, podName, argsUID, fetchedUID)
+	}
+	return argsUID
+}
+
+// uidOrUnknown renders a pod UID for log lines
+func uidOrUnknown(podUID string) string {
+	if podUID == "" {
+		return "unknown"
+	}
+	return podUID
+}
+
 // cmdAdd handles CNI ADD command
 // Called when a container is created and network configuration is required
 //
@@ -77,70 +188,203 @@ func parseCNIArgs(cniArgs string) (podName, podNamespace string, err error) {
 // 1. Parse CNI config
 // 2. Extract pod name/namespace from CNI_ARGS
 // 3. Delegate to next CNI plugin (get pod IP)
-// 4. Fetch fwmark annotation from pod or namespace
-// 5. Add iptables MARK rule if fwmark annotation present
-// 6. Return delegate Result unchanged
-func cmdAdd(args *skel.CmdArgs) error {
+// 4. Fetch fwmark annotation from pod or namespace and record it in the state store
+// 5. Add iptables MARK rule if fwmark annotation present and the pod is not bypassed
+// 6. Ensure tenant policy routing if plugin-managed routing is configured
+// 7. Return delegate Result unchanged
+//
+// Any error after step 3 rolls back the delegate ADD, so a failed ADD leaves no
+// interface or IP behind. In strict mode (config or namespace annotation) failures
+// in steps 4-6 are such errors instead of starting the pod unmarked. With
+// iptablesLockTimeout, rules blocked by the xtables lock are queued for GC instead
+// of delaying the pod (permissive mode only).
+func cmdAdd(args *skel.CmdArgs, ipt iptables.Manager) error {
 	// Step 1: Parse CNI configuration
 	pluginConf, err := config.ParseConfig(args.StdinData)
 	if err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+		return types.NewError(types.ErrInvalidNetworkConfig, "failed to parse config", err.Error())
 	}
+	defer setupLogging(pluginConf)()
+	setupK8s(pluginConf)
+	setupDelegate(pluginConf)
+	ctx, cancel := invocationContext(pluginConf)
+	defer cancel()
+	iptables.SetLockTimeout(time.Duration(pluginConf.IptablesLockTimeout) * time.Second)
 
 	// Step 2: Extract pod name/namespace from CNI_ARGS
 	// Required BEFORE delegation to validate input early
 	podName, podNamespace, err := parseCNIArgs(args.Args)
 	if err != nil {
-		return fmt.Errorf("failed to parse CNI_ARGS: %w", err)
+		return types.NewError(types.ErrInvalidEnvironmentVariables, "failed to parse CNI_ARGS", err.Error())
 	}
+	// kubelet retries an ADD that timed out: its effects are recorded once per attempt
+	attempt = startAttempt(args, pluginConf)
+	defer func() { attempt = nil }()
 
 	// Step 3: Delegate to next CNI plugin
 	// This creates the veth pair and assigns IP via IPAM
 	// Pass network name from parent config - required by CNI spec
-	delegateResult, err := delegate.DelegateAdd(pluginConf.Delegate, pluginConf.Name, args.StdinData)
+	// As a chained plugin the previous plugin in the conflist has already done this
+	delegateResult, err := addInterface(ctx, args, pluginConf)
+	if err != nil {
+		return err
+	}
+	delegateDone := time.Now()
+
+	// The delegate has set up an interface and leased an IP: any error from here on
+	// must undo that, or the runtime gives up on a sandbox nobody sends DEL for
+	if err := finishAdd(ctx, args, ipt, pluginConf, podNamespace, podName, delegateResult, delegateDone); err != nil {
+		return rollbackAdd(ctx, args, pluginConf, delegateResult, err)
+	}
+	publishConfigHash(ctx, pluginConf)
+	return nil
+}
+
+// resultOutput receives the result of ADD; replaced in tests
+var resultOutput io.Writer = os.Stdout
+
+// printResult prints the result of ADD in the version the runtime accepts (see
+// config.PluginConf.ResultVersion), converted from whatever the delegate returned
+func printResult(conf *config.PluginConf, res types.Result) error {
+	converted, err := result.ConvertResult(res, conf.ResultCNIVersion())
+	if err != nil {
+		return err
+	}
+	return converted.PrintTo(resultOutput)
+}
+
+// addInterface returns the result of the plugin that set up the pod interface
+// That is the delegate ADD, or prevResult when the wrapper is chained in a conflist.
+func addInterface(ctx context.Context, args *skel.CmdArgs, conf *config.PluginConf) (types.Result, error) {
+	if conf.Chained() {
+		if conf.PrevResult == nil {
+			return nil, types.NewError(types.ErrInvalidNetworkConfig,
+				"no delegate configured and no prevResult: the wrapper must follow an interface plugin in the conflist", "")
+		}
+		return conf.PrevResult, nil
+	}
+	start := time.Now()
+	res, err := delegate.DelegateAdd(ctx, conf.Delegate, conf.Name, args.StdinData)
+	observeDelegate(start)
 	if err != nil {
 		// Delegation failure is fatal - pod cannot start without network
-		return fmt.Errorf("delegation failed: %w", err)
+		var exhausted *delegate.IPAMExhaustedError
+		if errors.As(err, &exhausted) {
+			recordIPAMExhausted(conf, exhausted.Range)
+		}
+		return nil, fmt.Errorf("delegation failed: %w", err)
 	}
+	return res, nil
+}
 
+// finishAdd runs the ADD steps after delegation and prints the delegate result
+// A returned error makes cmdAdd roll back the delegate ADD; MARK and routing rules
+// must already be removed by then.
+func finishAdd(ctx context.Context, args *skel.CmdArgs, ipt iptables.Manager, pluginConf *config.PluginConf,
+	podNamespace, podName string, delegateResult types.Result, delegateDone time.Time) error {
 	// Step 4: Extract pod IP from delegate result
+	// An L2-only delegate assigns no address: nothing to mark unless the config says to fail
 	podIP, err := result.ExtractPodIP(delegateResult)
+	if errors.Is(err, result.ErrNoIPs) && pluginConf.NoIPs == config.NoIPsSkip {
+		delegateLog.Infof("delegate assigned no IP addresses to %s/%s, skipping fwmark setup (reason=%s)",
+			podNamespace, podName, reason.NoPodIP)
+		recordSkip(pluginConf, reason.NoPodIP)
+		return printResult(pluginConf, delegateResult)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to extract pod IP from delegate result: %w", err)
 	}
 
-	// Step 5: Create Kubernetes client and fetch fwmark annotation
-	clientset, err := k8s.NewClient(pluginConf.Kubeconfig)
+	// Enforcement protects tenant gateways from every pod, marked or not
+	if unlock, err := acquireNodeLock(pluginConf); err != nil {
+		iptLog.Warnf("tenant enforcement and connection limits not reconciled: %v", err)
+	} else {
+		ensureEnforcement(ctx, pluginConf)
+		ensureConnLimits(ctx, pluginConf)
+		unlock()
+	}
+
+	// Infrastructure namespaces are neither looked up nor marked
+	if tenant.ExcludedNamespace(podNamespace) {
+		cniLog.Infof("namespace of pod %s/%s is excluded, skipping fwmark setup (reason=%s)", podNamespace, podName,
+			reason.Excluded)
+		recordSkip(pluginConf, reason.Excluded)
+		return printResult(pluginConf, delegateResult)
+	}
+
+	// Step 5: Ask the node agent or the API server for the fwmark annotation
+	// Failures from here on are skips unless strict mode applies to the namespace
+	src, err := newAnnotationSource(pluginConf, annotationCache(pluginConf))
 	if err != nil {
 		// Log warning but don't fail pod creation
 		// This allows pods to start even if K8s API is temporarily unavailable
-		log.Printf("WARNING: failed to create K8s client, skipping fwmark setup: %v", err)
-		return types.PrintResult(delegateResult, pluginConf.CNIVersion)
+		fail := failurePolicy(ctx, pluginConf, nil, podNamespace)
+		if err := fail(reason.K8sUnreachable, "failed to create K8s client for fwmark setup: %v", err); err != nil {
+			return err
+		}
+		return printResult(pluginConf, delegateResult)
 	}
+	fail := withEvents(ctx, pluginConf, failurePolicy(ctx, pluginConf, src, podNamespace), podNamespace, podName)
 
-	fwmark, err := k8s.GetFwmark(clientset, podName, podNamespace, pluginConf.AnnotationKey)
+	annotations, err := src.RoutingAnnotations(ctx, podName, podNamespace, podUIDFromArgs(args.Args))
 	if err != nil {
 		// Log warning but don't fail pod creation
-		log.Printf("WARNING: failed to get fwmark annotation for %s/%s: %v", podNamespace, podName, err)
-		return types.PrintResult(delegateResult, pluginConf.CNIVersion)
+		if err := fail(reason.ForAnnotationError(err), "failed to get fwmark annotation for %s/%s: %v",
+			podNamespace, podName, err); err != nil {
+			return err
+		}
+		return printResult(pluginConf, delegateResult)
+	}
+	tnt, err := annotations.Tenant()
+	if err != nil {
+		if err := fail(reason.ForAnnotationError(err), "failed to get fwmark annotation for %s/%s: %v",
+			podNamespace, podName, err); err != nil {
+			return err
+		}
+		return printResult(pluginConf, delegateResult)
 	}
+	if tnt != nil {
+		k8sLog.Debugf("pod %s/%s: %s", podNamespace, podName, tnt)
+	}
+	if err := checkTenantTable(pluginConf, tnt); err != nil {
+		if err := fail(reason.RoutingFailed, "pod %s/%s left unmarked: %v", podNamespace, podName, err); err != nil {
+			return err
+		}
+		return printResult(pluginConf, delegateResult)
+	}
+	fwmark := annotations.Fwmark
+	podUID := resolvePodUID(podNamespace, podName, podUIDFromArgs(args.Args), annotations.PodUID)
+
+	// Record the resolved state before touching rules, so DEL can undo them without the API
+	saveState(args, pluginConf, podNamespace, podName, podUID, podIP, annotations)
 
 	// Step 6: Add iptables rule if fwmark annotation present
-	if fwmark != "" {
-		if err := iptables.AddMarkRule(podIP, fwmark); err != nil {
-			// Log warning but don't fail pod creation
-			// iptables failure is non-fatal to avoid blocking pod startup
-			log.Printf("WARNING: failed to add iptables rule for pod %s/%s (IP: %s, fwmark: %s): %v",
-				podNamespace, podName, podIP, fwmark, err)
-		} else {
-			log.Printf("INFO: added iptables MARK rule for pod %s/%s: -s %s -j MARK --set-mark %s",
-				podNamespace, podName, podIP, fwmark)
+	// A pod with an active tenant.routing/bypass-until annotation is left unmarked
+	assignment := state.Assignment{Cause: state.CauseAdd, PodUID: podUID, ContainerID: args.ContainerID, IP: podIP}
+	switch {
+	case annotations.Excluded:
+		cniLog.Infof("labels of pod %s/%s are excluded, skipping fwmark setup (reason=%s)", podNamespace, podName,
+			reason.Excluded)
+		recordSkip(pluginConf, reason.Excluded)
+	case fwmark == "":
+		recordSkip(pluginConf, reason.NoAnnotation)
+	case bypassActive(annotations, podNamespace, podName):
+		auditf("pod %s/%s (UID: %s, IP: %s, fwmark: %s) bypassed until %s: MARK rule not installed (reason=%s)",
+			podNamespace, podName, uidOrUnknown(podUID), podIP, fwmark, annotations.BypassUntil.Format(time.RFC3339), reason.Bypassed)
+		recordSkip(pluginConf, reason.Bypassed)
+		assignment.Cause = state.CauseBypass
+	default:
+		if err := addPodRules(ctx, args, ipt, pluginConf, fail, podNamespace, podName, podUID, podIP, annotations,
+			delegateDone); err != nil {
+			return err
 		}
+		assignment.Fwmark, assignment.Gateway = fwmark, annotations.Gateway
 	}
+	recordAssignment(pluginConf, podNamespace, podName, assignment)
 
 	// Return delegate result unchanged
 	// The CNI contract requires we pass through the Result from delegate
-	return types.PrintResult(delegateResult, pluginConf.CNIVersion)
+	return printResult(pluginConf, delegateResult)
 }
 
 // cmdDel handles CNI DEL command
@@ -150,24 +394,32 @@ func cmdAdd(args *skel.CmdArgs) error {
 // 1. Parse CNI config (including prevResult from ADD)
 // 2. Extract pod IP from prevResult
 // 3. Delegate DEL to next CNI plugin
-// 4. Remove iptables MARK rule if we have fwmark annotation
+// 4. Remove iptables MARK rule using the state record ADD wrote (or the agent's copy, or the annotation)
+// 5. Remove tenant policy routing if this was the tenant's last pod on the node
 //
 // DEL operations MUST be idempotent - multiple calls with same args should succeed
-func cmdDel(args *skel.CmdArgs) error {
+func cmdDel(args *skel.CmdArgs, ipt iptables.Manager) error {
 	// Parse CNI configuration
 	pluginConf, err := config.ParseConfig(args.StdinData)
 	if err != nil {
 		// Log error but don't fail - DEL should be tolerant
-		log.Printf("WARNING: failed to parse config in DEL: %v", err)
+		cniLog.Warnf("failed to parse config in DEL: %v", err)
 		return nil
 	}
+	defer setupLogging(pluginConf)()
+	setupK8s(pluginConf)
+	setupDelegate(pluginConf)
+	ctx, cancel := invocationContext(pluginConf)
+	defer cancel()
 
 	// Extract pod info from CNI_ARGS
 	podName, podNamespace, err := parseCNIArgs(args.Args)
 	if err != nil {
 		// CNI_ARGS might be missing during cleanup - not fatal
-		log.Printf("WARNING: failed to parse CNI_ARGS in DEL: %v", err)
+		cniLog.Warnf("failed to parse CNI_ARGS in DEL: %v", err)
 	}
+	// A pod of the same name created later must not reuse this pod's annotations
+	defer annotationCache(pluginConf).Invalidate(podNamespace, podName)
 
 	// Try to extract pod IP from prevResult (the result saved from ADD operation)
 	// CNI spec requires container runtimes to pass prevResult during DEL
@@ -175,127 +427,638 @@ func cmdDel(args *skel.CmdArgs) error {
 	if pluginConf.PrevResult != nil {
 		// PrevResult is already a types.Result interface, can be used directly
 		podIP, err = result.ExtractPodIP(pluginConf.PrevResult)
-		if err != nil {
-			log.Printf("WARNING: failed to extract pod IP from prevResult: %v", err)
+		if errors.Is(err, result.ErrNoIPs) {
+			cniLog.Infof("prevResult has no IP addresses (L2-only delegate), no rules to clean up")
+		} else if err != nil {
+			cniLog.Warnf("failed to extract pod IP from prevResult: %v", err)
 		}
 	}
 
 	// Delegate DEL to next plugin first
 	// Must happen regardless of iptables cleanup success
 	// Pass network name from parent config - required by CNI spec
-	if err := delegate.DelegateDel(pluginConf.Delegate, pluginConf.Name, args.StdinData); err != nil {
-		log.Printf("WARNING: delegate DEL failed: %v", err)
+	if !pluginConf.Chained() {
+		start := time.Now()
+		if err := delegate.DelegateDel(ctx, pluginConf.Delegate, pluginConf.Name, args.StdinData); err != nil {
+			delegateLog.Warnf("delegate DEL failed: %v", err)
+		}
+		observeDelegate(start)
+	}
+
+	// Without prevResult, prevResultPolicy decides whether DEL fails, skips or falls back
+	if pluginConf.PrevResult == nil {
+		if fallback, err := missingPrevResult(pluginConf, "DEL"); !fallback {
+			return err
+		}
+	}
+
+	// The runtime retries a failed DEL, so a held node lock is reported instead of skipped
+	unlock, err := acquireNodeLock(pluginConf)
+	if err != nil {
+		return fmt.Errorf("failed to clean up tenant routing: %w", err)
+	}
+	defer unlock()
+
+	// The state record says exactly what ADD set up; no API lookup or guessing needed
+	if rec := loadState(args, pluginConf); rec != nil {
+		if rec.Fwmark != "" && rec.PodIP() != "" &&
+			!removePodRules(ctx, ipt, pluginConf, rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark, rec.Gateway) {
+			// Keep the record so a retried DEL can finish the cleanup
+			return nil
+		}
+		deleteState(args, pluginConf)
+		recordDeletion(args, pluginConf, rec)
+		return nil
+	}
+	// The node agent keeps a copy of every record it was told about
+	if rec := releaseAttachment(pluginConf, pluginConf.Name, args.ContainerID, args.IfName); rec != nil {
+		if rec.Fwmark != "" && rec.PodIP() != "" &&
+			!removePodRules(ctx, ipt, pluginConf, rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark, rec.Gateway) {
+			// Give the copy back so a retried DEL can finish the cleanup
+			recordAttachment(pluginConf, rec)
+			return nil
+		}
+		recordDeletion(args, pluginConf, rec)
+		return nil
+	}
+	if pluginConf.PrevResult == nil {
+		podIP = cachedPodIP(args, pluginConf, "DEL")
 	}
 
 	// Clean up iptables rule if we have both pod IP and fwmark annotation
 	if podIP != "" && podName != "" && podNamespace != "" {
-		clientset, err := k8s.NewClient(pluginConf.Kubeconfig)
+		src, err := newAnnotationSource(pluginConf, annotationCache(pluginConf))
 		if err != nil {
-			log.Printf("WARNING: failed to create K8s client for cleanup: %v", err)
+			k8sLog.Warnf("failed to create K8s client for cleanup: %v", err)
 			return nil
 		}
 
-		fwmark, err := k8s.GetFwmark(clientset, podName, podNamespace, pluginConf.AnnotationKey)
+		annotations, err := src.RoutingAnnotations(ctx, podName, podNamespace, podUIDFromArgs(args.Args))
 		if err != nil {
 			// Pod might already be deleted - this is expected during cleanup
-			log.Printf("INFO: could not get fwmark for cleanup (pod may be deleted): %v", err)
+			k8sLog.Infof("could not get fwmark for cleanup (pod may be deleted): %v", err)
 			// Try to clean up both possible fwmark values since we don't know which one was used
-			cleanupIptablesRules(podIP)
+			cleanupIptablesRules(ctx, ipt, podIP)
+			cleanupConnmarkRules(ctx, pluginConf, podIP)
+			cleanupOutputRules(ctx, pluginConf, podIP)
+			flushConntrack(pluginConf, podIP)
+			releaseAllTenantRoutes(ctx, ipt, pluginConf)
 			return nil
 		}
 
-		if fwmark != "" {
-			if err := iptables.DeleteMarkRule(podIP, fwmark); err != nil {
-				log.Printf("WARNING: failed to delete iptables rule for pod %s/%s (IP: %s, fwmark: %s): %v",
-					podNamespace, podName, podIP, fwmark, err)
-			} else {
-				log.Printf("INFO: deleted iptables MARK rule for pod %s/%s: -s %s -j MARK --set-mark %s",
-					podNamespace, podName, podIP, fwmark)
-			}
+		if annotations.Fwmark != "" {
+			removePodRules(ctx, ipt, pluginConf, podNamespace, podName, podIP, annotations.Fwmark, annotations.Gateway)
 		}
 	} else if podIP != "" {
 		// We have IP but no pod info - try to clean up any rules for this IP
-		log.Printf("INFO: cleaning up any iptables rules for IP %s (pod info unavailable)", podIP)
-		cleanupIptablesRules(podIP)
+		cniLog.Infof("cleaning up any iptables rules for IP %s (pod info unavailable)", podIP)
+		cleanupIptablesRules(ctx, ipt, podIP)
+		cleanupConnmarkRules(ctx, pluginConf, podIP)
+		cleanupOutputRules(ctx, pluginConf, podIP)
+		flushConntrack(pluginConf, podIP)
+		releaseAllTenantRoutes(ctx, ipt, pluginConf)
 	}
 
 	return nil
 }
 
+// addPodRules installs the rules of a new pod holding the node lock
+// Rules blocked by the xtables lock or the node lock are queued for GC in permissive
+// mode. A returned error fails the ADD; the rules are removed again by then.
+func addPodRules(ctx context.Context, args *skel.CmdArgs, ipt iptables.Manager, conf *config.PluginConf, fail setupFailed,
+	podNamespace, podName, podUID, podIP string, annotations tenant.RoutingAnnotations, delegateDone time.Time) error {
+	var queued bool
+	fail = queueOnLock(fail, &queued)
+
+	added := false
+	unlock, err := acquireNodeLock(conf)
+	if err == nil {
+		added, err = installPodRules(ctx, ipt, conf, fail, podNamespace, podName, podUID, podIP, annotations.Fwmark,
+			annotations.Gateway)
+		if err != nil && added {
+			removePodRules(ctx, ipt, conf, podNamespace, podName, podIP, annotations.Fwmark, annotations.Gateway)
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+	} else if err := fail(reason.NodeLocked, "rules of pod %s/%s (IP: %s, fwmark: %s) not installed: %v",
+		podNamespace, podName, podIP, annotations.Fwmark, err); err != nil {
+		return err
+	}
+
+	if queued {
+		queuePodRules(args, conf)
+	} else if added {
+		recordRoutingLatency(ctx, ipt, conf, podIP, annotations.Fwmark, annotations.Gateway, delegateDone)
+	}
+	return nil
+}
+
+// installPodRules adds the MARK rule, the optional per-pod rules and tenant routing
+// Every failure goes through fail: in permissive mode it is logged and does not fail
+// pod creation, in strict mode setup stops and the error is returned. Optional steps
+// run only after the MARK rule was added. Returns whether the MARK rule was added.
+// With podUIDComments the MARK rule is tagged with podUID, if known.
+func installPodRules(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, fail setupFailed,
+	podNamespace, podName, podUID, podIP, fwmark, gateway string) (bool, error) {
+	var markOpts []iptables.MarkOption
+	if conf.AllowUnsafeSources {
+		markOpts = append(markOpts, iptables.AllowUnsafeSources())
+	}
+	if conf.PodUIDComments {
+		markOpts = append(markOpts, iptables.PodUID(podUID))
+	}
+	if conf.MarkNewConnectionsOnly {
+		markOpts = append(markOpts, iptables.NewConnectionsOnly())
+	}
+	if hook := conf.MarkHook(fwmark); hook != "" {
+		markOpts = append(markOpts, iptables.AtHook(hook))
+	}
+	if err := ipt.AddMarkRule(ctx, podIP, fwmark, markOpts...); err != nil {
+		// iptables failure is non-fatal to avoid blocking pod startup (unless strict)
+		return false, fail(reason.ForMarkError(err), "failed to add iptables rule for pod %s/%s (IP: %s, fwmark: %s): %v",
+			podNamespace, podName, podIP, fwmark, err)
+	}
+	iptLog.Infof("added iptables MARK rule for pod %s/%s: -s %s -j MARK --set-mark %s",
+		podNamespace, podName, podIP, fwmark)
+
+	if conf.Connmark {
+		addConnmarkRules := iptables.AddConnmarkRules
+		if conf.MarkNewConnectionsOnly {
+			addConnmarkRules = iptables.AddNewConnectionConnmarkRules
+		}
+		if err := addConnmarkRules(ctx, podIP); err != nil {
+			if err := fail(reason.ForIptablesError(err), "failed to add CONNMARK rules for pod %s/%s (IP: %s): %v",
+				podNamespace, podName, podIP, err); err != nil {
+				return true, err
+			}
+		}
+	}
+	if conf.MarkHostTraffic {
+		if err := iptables.AddOutputMarkRule(ctx, podIP, fwmark); err != nil {
+			if err := fail(reason.ForIptablesError(err), "failed to add OUTPUT mark rule for pod %s/%s (IP: %s, fwmark: %s): %v",
+				podNamespace, podName, podIP, fwmark, err); err != nil {
+				return true, err
+			}
+		}
+	}
+	flushConntrack(conf, podIP)
+	return true, ensureTenantRoute(conf, fail, fwmark, gateway)
+}
+
+// removePodRules deletes the MARK rule, the optional per-pod rules and, for the
+// tenant's last pod, tenant routing. Returns whether the MARK rule was deleted.
+func removePodRules(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf,
+	podNamespace, podName, podIP, fwmark, gateway string) bool {
+	if err := ipt.DeleteMarkRule(ctx, podIP, fwmark); err != nil {
+		observeFailure(reason.ForIptablesError(err))
+		iptLog.Warnf("failed to delete iptables rule for pod %s/%s (IP: %s, fwmark: %s): %v",
+			podNamespace, podName, podIP, fwmark, err)
+		return false
+	}
+	iptLog.Infof("deleted iptables MARK rule for pod %s/%s: -s %s -j MARK --set-mark %s",
+		podNamespace, podName, podIP, fwmark)
+
+	cleanupConnmarkRules(ctx, conf, podIP)
+	if conf.MarkHostTraffic {
+		if err := iptables.DeleteOutputMarkRule(ctx, podIP, fwmark); err != nil {
+			iptLog.Warnf("failed to delete OUTPUT mark rule for IP %s: %v", podIP, err)
+		}
+	}
+	flushConntrack(conf, podIP)
+	releaseTenantRoute(ctx, ipt, conf, fwmark, gateway)
+	return true
+}
+
 // cleanupIptablesRules attempts to clean up iptables rules for a given IP
 // Tries both valid fwmark values since we might not know which one was used
-func cleanupIptablesRules(podIP string) {
-	for fwmark := range k8s.ValidFwmarkValues {
-		if err := iptables.DeleteMarkRule(podIP, fwmark); err != nil {
+func cleanupIptablesRules(ctx context.Context, ipt iptables.Manager, podIP string) {
+	for fwmark := range api.ValidFwmarkValues {
+		if err := ipt.DeleteMarkRule(ctx, podIP, fwmark); err != nil {
 			// Log at debug level - rule might not exist
-			log.Printf("DEBUG: DeleteMarkRule(%s, %s) failed: %v", podIP, fwmark, err)
+			iptLog.Debugf("DeleteMarkRule(%s, %s) failed: %v", podIP, fwmark, err)
+		}
+	}
+}
+
+// cleanupConnmarkRules removes CONNMARK save/restore rules for podIP if the option is enabled
+func cleanupConnmarkRules(ctx context.Context, conf *config.PluginConf, podIP string) {
+	if !conf.Connmark {
+		return
+	}
+	if err := iptables.DeleteConnmarkRules(ctx, podIP); err != nil {
+		iptLog.Warnf("failed to delete CONNMARK rules for IP %s: %v", podIP, err)
+	}
+}
+
+// cleanupOutputRules removes host-originated traffic rules for podIP for every known fwmark
+// Used when the pod's fwmark cannot be determined during DEL
+func cleanupOutputRules(ctx context.Context, conf *config.PluginConf, podIP string) {
+	if !conf.MarkHostTraffic {
+		return
+	}
+	for fwmark := range api.ValidFwmarkValues {
+		if err := iptables.DeleteOutputMarkRule(ctx, podIP, fwmark); err != nil {
+			iptLog.Debugf("DeleteOutputMarkRule(%s, %s) failed: %v", podIP, fwmark, err)
 		}
 	}
 }
 
+// flushConntrack drops conntrack entries of podIP if the option is enabled
+// Failures are logged only: stale entries still expire on their own
+func flushConntrack(conf *config.PluginConf, podIP string) {
+	if !conf.FlushConntrack {
+		return
+	}
+	deleted, err := conntrack.FlushPodIP(podIP)
+	if err != nil {
+		cniLog.Warnf("%v", err)
+		return
+	}
+	cniLog.Infof("flushed %d conntrack entries for IP %s", deleted, podIP)
+}
+
+// checkTenantTable verifies that the routing table a TenantRoute expects is the one the
+// configuration assigns to its fwmark, so a tenant is never routed through another table
+// Without plugin-managed routing the tables are not the plugin's to check.
+func checkTenantTable(conf *config.PluginConf, tnt *tenant.Tenant) error {
+	if tnt == nil || tnt.RoutingTable == 0 || conf.Routing == nil {
+		return nil
+	}
+	table, ok := conf.RouteTableOf(tnt.Fwmark)
+	if !ok {
+		return fmt.Errorf("TenantRoute %s expects routing table %d, but none is configured for fwmark 0x%x",
+			tnt.Name, tnt.RoutingTable, tnt.Fwmark)
+	}
+	if table.Table != tnt.RoutingTable {
+		return fmt.Errorf("TenantRoute %s expects routing table %d, but fwmark 0x%x is configured with table %d",
+			tnt.Name, tnt.RoutingTable, tnt.Fwmark, table.Table)
+	}
+	return nil
+}
+
+// ensureTenantRoute installs tenant policy routing after a MARK rule was added
+// Failures go through fail (same policy as iptables errors)
+func ensureTenantRoute(conf *config.PluginConf, fail setupFailed, fwmark, gateway string) error {
+	tr, ok, err := route.FromConfig(conf, fwmark, gateway)
+	if err != nil {
+		return fail(reason.RoutingFailed, "invalid routing configuration for fwmark %s: %v", fwmark, err)
+	}
+	if !ok {
+		if gateway != "" {
+			routeLog.Warnf("gateway annotation %s ignored: no routing table configured for fwmark %s", gateway, fwmark)
+		}
+		return nil
+	}
+
+	if err := route.EnsureTenantRoute(tr); err != nil {
+		return fail(reason.RoutingFailed, "failed to ensure policy routing (%s): %v", tr, err)
+	}
+	routeLog.Infof("ensured policy routing: %s", tr)
+
+	if conf.Routing.RelaxRPFilter {
+		dev, err := route.RelaxRPFilter(tr)
+		if err != nil {
+			return fail(reason.RoutingFailed, "failed to relax rp_filter for gateway %s: %v", tr.Gateway, err)
+		}
+		if dev != "" {
+			routeLog.Infof("ensured loose rp_filter and src_valid_mark on %s", dev)
+		}
+	}
+	return nil
+}
+
+// skipped logs a permissive-mode skip tagged with its reason code and counts it
+func skipped(conf *config.PluginConf, code reason.Code, format string, args ...interface{}) {
+	cniLog.Warnf(format+" (reason=%s)", append(args, code)...)
+	recordSkip(conf, code)
+}
+
+// recordSkip counts a permissive-mode skip in the metrics file if metrics are enabled
+// A retried ADD counts its skips only once (see addAttempt).
+func recordSkip(conf *config.PluginConf, code reason.Code) {
+	if conf.MetricsFile == "" || !attempt.once("skip/"+code.String()) {
+		return
+	}
+	recorder, err := metrics.NewRecorder(conf.MetricsFile)
+	if err == nil {
+		err = recorder.ObserveSkip(code.String())
+	}
+	if err != nil {
+		cniLog.Warnf("failed to record skip reason %s: %v", code, err)
+	}
+}
+
+// recordIPAMExhausted counts an ADD that failed on an exhausted IPAM range if metrics are enabled
+func recordIPAMExhausted(conf *config.PluginConf, poolRange string) {
+	delegateLog.Errorf("IPAM of network %s is out of addresses (range: %s)", conf.Name, poolRange)
+	if conf.MetricsFile == "" || !attempt.once("ipam-exhausted") {
+		return
+	}
+	recorder, err := metrics.NewRecorder(conf.MetricsFile)
+	if err == nil {
+		err = recorder.ObserveIPAMExhausted(poolRange)
+	}
+	if err != nil {
+		cniLog.Warnf("failed to record IPAM exhaustion: %v", err)
+	}
+}
+
+// recordRoutingLatency verifies the pod's MARK rule and tenant route and records the
+// time since delegate completion in the per-tenant SLO histogram
+// Nothing is recorded if metrics are disabled or routing is not effective yet
+func recordRoutingLatency(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, podIP, fwmark, gateway string,
+	delegateDone time.Time) {
+	if conf.MetricsFile == "" {
+		return
+	}
+
+	if exists, err := ipt.RuleExists(ctx, podIP, fwmark); err != nil || !exists {
+		cniLog.Warnf("not recording routing latency for IP %s: MARK rule not verified (err: %v)", podIP, err)
+		return
+	}
+	if tr, ok, err := route.FromConfig(conf, fwmark, gateway); err == nil && ok {
+		if err := route.VerifyTenantRoute(tr); err != nil {
+			cniLog.Warnf("not recording routing latency for IP %s: %v", podIP, err)
+			return
+		}
+	}
+	latency := time.Since(delegateDone)
+	if !attempt.once("latency/" + fwmark) {
+		// A retried ADD: the first observed the pod's latency
+		return
+	}
+
+	recorder, err := metrics.NewRecorder(conf.MetricsFile)
+	if err == nil {
+		err = recorder.ObserveRoutingLatency(fwmark, latency)
+	}
+	if err != nil {
+		cniLog.Warnf("failed to record routing latency for fwmark %s: %v", fwmark, err)
+	}
+}
+
+// enforcementRules returns the enforcement rules of every table with enforce set
+func enforcementRules(conf *config.PluginConf) []iptables.EnforceRule {
+	if conf.Routing == nil {
+		return nil
+	}
+	var rules []iptables.EnforceRule
+	for key, table := range conf.Routing.Tables {
+		mark, err := route.ParseFwmark(key)
+		if err != nil {
+			continue // rejected by config validation
+		}
+		for _, dst := range table.EnforcedDestinations() {
+			rules = append(rules, iptables.EnforceRule{Fwmark: fmt.Sprintf("%#x", mark), Destination: dst})
+		}
+	}
+	iptables.SortEnforceRules(rules)
+	return rules
+}
+
+// ensureEnforcement reconciles the enforcement chain with the routing configuration
+// Runs on every ADD with plugin-managed routing, so tables that drop enforce are
+// cleaned up too. Failures are logged only: CHECK reports the drift.
+func ensureEnforcement(ctx context.Context, conf *config.PluginConf) {
+	if conf.Routing == nil {
+		return
+	}
+	if err := iptables.EnsureEnforcement(ctx, enforcementRules(conf)); err != nil {
+		observeFailure(reason.IptablesFailed)
+		iptLog.Warnf("failed to reconcile tenant enforcement rules: %v (reason=%s)", err, reason.IptablesFailed)
+	}
+}
+
+// verifyEnforcement reports missing enforcement rules as configuration drift
+func verifyEnforcement(ctx context.Context, conf *config.PluginConf) error {
+	rules := enforcementRules(conf)
+	if len(rules) == 0 {
+		return nil
+	}
+	missing, err := iptables.MissingEnforcement(ctx, rules)
+	if err != nil {
+		iptLog.Warnf("CHECK cannot verify enforcement rules: %v", err)
+		return nil
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("configuration drift detected: %d tenant enforcement rules missing (first: %s)", len(missing), missing[0])
+	}
+	return nil
+}
+
+// connLimitRules returns the connection limit rules of every table with maxConnections set
+func connLimitRules(conf *config.PluginConf) []iptables.ConnLimitRule {
+	if conf.Routing == nil {
+		return nil
+	}
+	var rules []iptables.ConnLimitRule
+	for key, table := range conf.Routing.Tables {
+		mark, err := route.ParseFwmark(key)
+		if err != nil || table.MaxConnections == 0 {
+			continue // invalid keys are rejected by config validation
+		}
+		rules = append(rules, iptables.ConnLimitRule{Fwmark: fmt.Sprintf("%#x", mark), MaxConnections: table.MaxConnections})
+	}
+	iptables.SortConnLimitRules(rules)
+	return rules
+}
+
+// ensureConnLimits reconciles the connection limit chain with the routing configuration
+// Same lifecycle as ensureEnforcement: every ADD, failures logged, CHECK reports drift.
+func ensureConnLimits(ctx context.Context, conf *config.PluginConf) {
+	if conf.Routing == nil {
+		return
+	}
+	if err := iptables.EnsureConnLimits(ctx, connLimitRules(conf)); err != nil {
+		observeFailure(reason.ForIptablesError(err))
+		iptLog.Warnf("failed to reconcile tenant connection limits: %v (reason=%s)", err, reason.ForIptablesError(err))
+	}
+}
+
+// verifyConnLimits reports missing connection limit rules as configuration drift
+func verifyConnLimits(ctx context.Context, conf *config.PluginConf) error {
+	rules := connLimitRules(conf)
+	if len(rules) == 0 {
+		return nil
+	}
+	missing, err := iptables.MissingConnLimits(ctx, rules)
+	if err != nil {
+		iptLog.Warnf("CHECK cannot verify connection limit rules: %v", err)
+		return nil
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("configuration drift detected: %d tenant connection limit rules missing (first: %s)", len(missing), missing[0])
+	}
+	return nil
+}
+
+// releaseTenantRoute removes tenant policy routing once no MARK rule for fwmark remains
+// Routing state is shared by all pods of a tenant, so it lives until the last pod leaves
+func releaseTenantRoute(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, fwmark, gateway string) {
+	tr, ok, err := route.FromConfig(conf, fwmark, gateway)
+	if err != nil || !ok {
+		return
+	}
+
+	remaining, err := countMarkRules(ctx, ipt, fwmark)
+	if err != nil {
+		routeLog.Warnf("cannot determine remaining pods for fwmark %s, keeping policy routing: %v", fwmark, err)
+		return
+	}
+	if remaining > 0 {
+		return
+	}
+
+	if err := route.RemoveTenantRoute(tr); err != nil {
+		routeLog.Warnf("failed to remove policy routing (%s): %v", tr, err)
+		return
+	}
+	routeLog.Infof("removed policy routing for last pod of tenant: %s", tr)
+}
+
+// countMarkRules returns the number of installed MARK rules setting fwmark
+func countMarkRules(ctx context.Context, ipt iptables.Manager, fwmark string) (int, error) {
+	want, err := route.ParseFwmark(fwmark)
+	if err != nil {
+		return 0, err
+	}
+
+	rules, err := ipt.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, rule := range rules {
+		if mark, err := route.ParseFwmark(rule.Fwmark); err == nil && mark == want {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// releaseAllTenantRoutes runs releaseTenantRoute for every known fwmark
+// Used when the pod's fwmark cannot be determined during DEL; only configured
+// gateways are known here, so annotation-provided default routes are left in place
+func releaseAllTenantRoutes(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf) {
+	for fwmark := range api.ValidFwmarkValues {
+		releaseTenantRoute(ctx, ipt, conf, fwmark, "")
+	}
+}
+
 // cmdCheck handles CNI CHECK command
 // Called to verify that the container's network is configured as expected
 //
 // Flow:
 // 1. Parse CNI config
 // 2. Delegate CHECK to next CNI plugin
-// 3. If fwmark annotation present, verify iptables rule exists
-// 4. Return error if configuration drift detected (annotation present but rule missing)
-func cmdCheck(args *skel.CmdArgs) error {
+// 3. Verify tenant enforcement and connection limit rules if any table sets them
+// 4. If fwmark annotation (or, without the API, the recorded fwmark) present, verify iptables rule exists
+// 5. If plugin-managed routing is configured, verify the tenant policy rule, route and rp_filter
+// 6. Apply bypass transitions (remove rules while bypassed, re-apply once expired)
+// 7. Return error if configuration drift detected (annotation present but rule missing)
+func cmdCheck(args *skel.CmdArgs, ipt iptables.Manager) error {
 	// Parse CNI configuration
 	pluginConf, err := config.ParseConfig(args.StdinData)
 	if err != nil {
-		return fmt.Errorf("failed to parse config: %w", err)
+		return types.NewError(types.ErrInvalidNetworkConfig, "failed to parse config", err.Error())
 	}
+	defer setupLogging(pluginConf)()
+	setupK8s(pluginConf)
+	setupDelegate(pluginConf)
+	ctx, cancel := invocationContext(pluginConf)
+	defer cancel()
 
 	// Delegate CHECK to next plugin first
 	// This verifies the underlying network configuration (veth, IP, routes)
 	// Pass network name from parent config - required by CNI spec
-	if err := delegate.DelegateCheck(pluginConf.Delegate, pluginConf.Name, args.StdinData); err != nil {
-		return fmt.Errorf("delegate CHECK failed: %w", err)
+	if !pluginConf.Chained() {
+		start := time.Now()
+		err := delegate.DelegateCheck(ctx, pluginConf.Delegate, pluginConf.Name, args.StdinData)
+		observeDelegate(start)
+		if err != nil {
+			return fmt.Errorf("delegate CHECK failed: %w", err)
+		}
+	}
+
+	if err := verifyEnforcement(ctx, pluginConf); err != nil {
+		return err
+	}
+	if err := verifyConnLimits(ctx, pluginConf); err != nil {
+		return err
 	}
 
 	// Extract pod info from CNI_ARGS
 	podName, podNamespace, err := parseCNIArgs(args.Args)
 	if err != nil {
 		// Cannot verify iptables without pod info
-		log.Printf("WARNING: CHECK cannot verify iptables - failed to parse CNI_ARGS: %v", err)
+		cniLog.Warnf("CHECK cannot verify iptables - failed to parse CNI_ARGS: %v", err)
+		return nil
+	}
+	// ADD never marks the pods of infrastructure namespaces
+	if tenant.ExcludedNamespace(podNamespace) {
 		return nil
 	}
 
-	// Extract pod IP from prevResult
+	// Extract pod IP from prevResult, falling back to the state record
+	rec := loadState(args, pluginConf)
 	var podIP string
-	if pluginConf.PrevResult != nil {
+	switch {
+	case pluginConf.PrevResult != nil:
+		if err := verifyCachedResult(args, pluginConf, podNamespace, podName); err != nil {
+			return err
+		}
 		podIP, err = result.ExtractPodIP(pluginConf.PrevResult)
+		if errors.Is(err, result.ErrNoIPs) {
+			// L2-only delegate: ADD never marked this pod
+			return nil
+		}
 		if err != nil {
-			log.Printf("WARNING: CHECK cannot verify iptables - failed to extract pod IP: %v", err)
+			cniLog.Warnf("CHECK cannot verify iptables - failed to extract pod IP: %v", err)
+			return nil
+		}
+	default:
+		// prevResultPolicy decides whether CHECK fails, skips or falls back
+		if fallback, err := missingPrevResult(pluginConf, "CHECK"); !fallback {
+			return err
+		}
+		if rec != nil && rec.PodIP() != "" {
+			podIP = rec.PodIP()
+		} else if podIP = cachedPodIP(args, pluginConf, "CHECK"); podIP == "" {
 			return nil
 		}
-	} else {
-		log.Printf("WARNING: CHECK cannot verify iptables - no prevResult available")
-		return nil
 	}
 
-	// Create Kubernetes client and fetch fwmark annotation
-	clientset, err := k8s.NewClient(pluginConf.Kubeconfig)
-	if err != nil {
-		log.Printf("WARNING: CHECK cannot verify iptables - failed to create K8s client: %v", err)
+	// Fetch fwmark annotation; without the API the recorded state is verified instead
+	// (bypass transitions need the live annotation and are skipped)
+	annotations, err := fetchAnnotationsCached(ctx, pluginConf, annotationCache(pluginConf), podName, podNamespace,
+		podUIDFromArgs(args.Args))
+	if err != nil && rec == nil {
+		// Pod might be terminating - not a CHECK failure
+		cniLog.Warnf("CHECK cannot verify iptables - %v", err)
 		return nil
 	}
-
-	fwmark, err := k8s.GetFwmark(clientset, podName, podNamespace, pluginConf.AnnotationKey)
 	if err != nil {
-		// Pod might be terminating - not a CHECK failure
-		log.Printf("WARNING: CHECK cannot verify iptables - failed to get fwmark annotation: %v", err)
+		cniLog.Infof("CHECK verifying recorded state for pod %s/%s: %v", podNamespace, podName, err)
+		annotations = tenant.RoutingAnnotations{Fwmark: rec.Fwmark, Gateway: rec.Gateway, PodUID: rec.PodUID}
+	}
+	annotations.PodUID = resolvePodUID(podNamespace, podName, podUIDFromArgs(args.Args), annotations.PodUID)
+	fwmark := annotations.Fwmark
+
+	// Bypass transitions are the one exception to CHECK being read-only
+	if fwmark != "" && reconcileBypass(ctx, ipt, pluginConf, podNamespace, podName, podIP, annotations) {
 		return nil
 	}
 
 	// If fwmark annotation is present, verify iptables rule exists
+	// CHECK is otherwise read-only: answer from the rule snapshot instead of a per-rule iptables check
 	if fwmark != "" {
-		exists, err := iptables.RuleExists(podIP, fwmark)
+		exists, err := iptables.DefaultRuleCache.RuleExists(ctx, podIP, fwmark)
 		if err != nil {
 			// Cannot determine rule state - log warning but don't fail CHECK
-			log.Printf("WARNING: CHECK cannot verify iptables rule existence: %v", err)
+			iptLog.Warnf("CHECK cannot verify iptables rule existence: %v", err)
 			return nil
 		}
 
@@ -305,33 +1068,132 @@ func cmdCheck(args *skel.CmdArgs) error {
 				fwmark, podNamespace, podName, podIP)
 		}
 
-		log.Printf("INFO: CHECK verified iptables rule exists for pod %s/%s (IP: %s, fwmark: %s)",
+		iptLog.Infof("CHECK verified iptables rule exists for pod %s/%s (IP: %s, fwmark: %s)",
 			podNamespace, podName, podIP, fwmark)
+
+		if pluginConf.MarkHostTraffic {
+			exists, err := iptables.OutputMarkRuleExists(ctx, podIP, fwmark)
+			if err != nil {
+				iptLog.Warnf("CHECK cannot verify OUTPUT mark rule: %v", err)
+			} else if !exists {
+				return fmt.Errorf("configuration drift detected: OUTPUT mark rule missing for pod %s/%s (IP: %s, fwmark: %s)",
+					podNamespace, podName, podIP, fwmark)
+			}
+		}
+
+		if pluginConf.Connmark {
+			connmarkRulesExist := iptables.ConnmarkRulesExist
+			if pluginConf.MarkNewConnectionsOnly {
+				connmarkRulesExist = iptables.NewConnectionConnmarkRulesExist
+			}
+			exists, err := connmarkRulesExist(ctx, podIP)
+			if err != nil {
+				iptLog.Warnf("CHECK cannot verify CONNMARK rules: %v", err)
+			} else if !exists {
+				return fmt.Errorf("configuration drift detected: CONNMARK rules missing for pod %s/%s (IP: %s)",
+					podNamespace, podName, podIP)
+			}
+		}
+
+		tr, ok, err := route.FromConfig(pluginConf, fwmark, annotations.Gateway)
+		if err != nil {
+			routeLog.Warnf("CHECK cannot verify policy routing - invalid configuration: %v", err)
+			return nil
+		}
+		if ok {
+			if err := route.VerifyTenantRoute(tr); err != nil {
+				return fmt.Errorf("configuration drift detected: policy routing for pod %s/%s (fwmark: %s): %w",
+					podNamespace, podName, fwmark, err)
+			}
+			if pluginConf.Routing.RelaxRPFilter {
+				if err := route.VerifyRPFilter(tr); err != nil {
+					return fmt.Errorf("configuration drift detected: reverse-path filter for pod %s/%s (fwmark: %s): %w",
+						podNamespace, podName, fwmark, err)
+				}
+			}
+			// An unresolvable gateway is an outage, not drift of our configuration
+			if err := route.CheckGateway(tr); err != nil {
+				cniLog.Warnf("CHECK for pod %s/%s: %v", podNamespace, podName, err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// fetchAnnotations creates a Kubernetes client and reads the pod's routing annotations
+func fetchAnnotations(ctx context.Context, conf *config.PluginConf, podName, podNamespace string) (tenant.RoutingAnnotations,
+	error) {
+	return fetchAnnotationsCached(ctx, conf, nil, podName, podNamespace, "")
+}
+
+// fetchAnnotationsCached is fetchAnnotations answering from cache while its entry is fresh
+func fetchAnnotationsCached(ctx context.Context, conf *config.PluginConf, cache *tenant.AnnotationCache, podName, podNamespace,
+	podUID string) (tenant.RoutingAnnotations, error) {
+	src, err := newAnnotationSource(conf, cache)
+	if err != nil {
+		return tenant.RoutingAnnotations{}, fmt.Errorf("failed to create K8s client: %w", err)
+	}
+
+	annotations, err := src.RoutingAnnotations(ctx, podName, podNamespace, podUID)
+	if err != nil {
+		return tenant.RoutingAnnotations{}, fmt.Errorf("failed to get fwmark annotation: %w", err)
+	}
+	return annotations, nil
+}
+
 // buildVersionString returns the full version string for CNI about
 func buildVersionString() string {
 	return fmt.Sprintf("tenant-routing-wrapper %s (commit: %s, built: %s)", versionStr, commit, date)
 }
 
 func main() {
-	// Configure logging to stderr (CNI spec: stdout is for results, stderr for logs)
-	log.SetOutput(os.Stderr)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	// Log text to stderr until a configuration says otherwise (CNI spec: stdout is for
+	// results, stderr for logs); the defaults cannot fail
+	_, _ = logging.Setup(logging.Options{})
+
+	// Production iptables backend; tests pass iptables.FakeManager instead
+	ipt := iptables.NewManager()
+
+	// Standalone maintenance invocations; the CNI runtime never passes arguments
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gc":
+			os.Exit(gcCommand(ipt, os.Args[2:], os.Stdout))
+		case "route-get":
+			os.Exit(routeGetCommand(ipt, os.Args[2:], os.Stdout))
+		case "config-hash":
+			os.Exit(configHashCommand(os.Args[2:], os.Stdout))
+		case "migrate":
+			os.Exit(migrateCommand(ipt, os.Args[2:], os.Stdout))
+		case "health":
+			os.Exit(healthCommand(ipt, os.Args[2:], os.Stdout))
+		case "history":
+			os.Exit(historyCommand(os.Args[2:], os.Stdout))
+		case "reconcile":
+			os.Exit(reconcileCommand(ipt, os.Args[2:], os.Stdout))
+		case "verify-isolation":
+			os.Exit(verifyIsolationCommand(os.Args[2:], os.Stdout))
+		}
+	}
 
-	// skel.PluginMain automatically:
+	// skel.PluginMainFuncs automatically:
 	// 1. Reads CNI_COMMAND environment variable
-	// 2. Routes to appropriate handler (cmdAdd/cmdDel/cmdCheck)
+	// 2. Routes to appropriate handler (cmdAdd/cmdDel/cmdCheck/cmdGC/cmdStatus)
 	// 3. Handles stdout/stderr formatting per CNI spec
 	// 4. Sets appropriate exit codes on errors
-	skel.PluginMain(
-		cmdAdd,
-		cmdCheck,
-		cmdDel,
-		version.All,
-		buildVersionString(),
-	)
+	// cniError gives failures of a known class their CNI error code (see errors.go)
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add: func(args *skel.CmdArgs) error {
+			return cniError(observeCommand("ADD", args, ipt, func() error { return cmdAdd(args, ipt) }))
+		},
+		Del: func(args *skel.CmdArgs) error {
+			return cniError(observeCommand("DEL", args, ipt, func() error { return cmdDel(args, ipt) }))
+		},
+		Check: func(args *skel.CmdArgs) error {
+			return cniError(observeCommand("CHECK", args, ipt, func() error { return cmdCheck(args, ipt) }))
+		},
+		GC:     func(args *skel.CmdArgs) error { return cniError(cmdGC(args, ipt)) },
+		Status: func(args *skel.CmdArgs) error { return cmdStatus(args, ipt) },
+	}, version.All, buildVersionString())
 }
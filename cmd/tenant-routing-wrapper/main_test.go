@@ -1,7 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"testing"
+
+	"github.com/containernetworking/cni/pkg/version"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
 )
 
 func TestParseCNIArgs_ValidArgs(t *testing.T) {
@@ -124,3 +133,127 @@ func TestParseCNIArgs_EqualsInValue(t *testing.T) {
 		t.Errorf("podNamespace = %q, want %q", podNamespace, "my=ns")
 	}
 }
+
+// TestResolvePodUID verifies the runtime's UID wins over the fetched pod's
+func TestResolvePodUID(t *testing.T) {
+	argsUID := podUIDFromArgs("K8S_POD_NAME=web-0;K8S_POD_NAMESPACE=team-a;K8S_POD_UID=abc-123")
+	if argsUID != "abc-123" {
+		t.Fatalf("podUIDFromArgs() = %q, want abc-123", argsUID)
+	}
+	if got := podUIDFromArgs("K8S_POD_NAME=web-0;K8S_POD_NAMESPACE=team-a"); got != "" {
+		t.Errorf("podUIDFromArgs() without K8S_POD_UID = %q", got)
+	}
+
+	tests := []struct{ args, fetched, want string }{
+		{"abc-123", "abc-123", "abc-123"},
+		{"abc-123", "def-456", "abc-123"},
+		{"", "def-456", "def-456"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := resolvePodUID("team-a", "web-0", tt.args, tt.fetched); got != tt.want {
+			t.Errorf("resolvePodUID(%q, %q) = %q, want %q", tt.args, tt.fetched, got, tt.want)
+		}
+	}
+}
+
+// TestCheckTenantTable verifies a TenantRoute's table must match the configured one
+func TestCheckTenantTable(t *testing.T) {
+	conf := &config.PluginConf{Routing: &config.RoutingConf{Tables: map[string]config.RouteTableConf{"0x10": {Table: 100}}}}
+	tests := []struct {
+		name    string
+		conf    *config.PluginConf
+		tenant  *k8s.Tenant
+		wantErr bool
+	}{
+		{name: "no tenant", conf: conf},
+		{name: "fwmark annotation", conf: conf, tenant: &k8s.Tenant{Fwmark: 0x10}},
+		{name: "matching table", conf: conf, tenant: &k8s.Tenant{Name: "a", Fwmark: 0x10, RoutingTable: 100}},
+		{name: "other table", conf: conf, tenant: &k8s.Tenant{Name: "a", Fwmark: 0x10, RoutingTable: 101},
+			wantErr: true},
+		{name: "unconfigured fwmark", conf: conf, tenant: &k8s.Tenant{Name: "b", Fwmark: 0x20, RoutingTable: 200},
+			wantErr: true},
+		{name: "routing not managed", conf: &config.PluginConf{},
+			tenant: &k8s.Tenant{Name: "b", Fwmark: 0x20, RoutingTable: 200}},
+	}
+	for _, tt := range tests {
+		if err := checkTenantTable(tt.conf, tt.tenant); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkTenantTable() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// TestPrintResult_ResultVersion verifies every downgrade path of resultVersion
+func TestPrintResult_ResultVersion(t *testing.T) {
+	var out bytes.Buffer
+	orig := resultOutput
+	resultOutput = &out
+	t.Cleanup(func() { resultOutput = orig })
+
+	for i, from := range config.ResultVersions {
+		ipVersion := `"version": "4", `
+		if from >= "1.0.0" {
+			ipVersion = ""
+		}
+		res, err := version.NewResult(from, []byte(`{"cniVersion": "`+from+`",
+			"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/test"}],
+			"ips": [{`+ipVersion+`"address": "10.200.1.5/24", "interface": 0}]}`))
+		if err != nil {
+			t.Fatalf("%s result: %v", from, err)
+		}
+		for _, to := range config.ResultVersions[:i+1] {
+			out.Reset()
+			conf := &config.PluginConf{ResultVersion: to}
+			conf.CNIVersion = "1.1.0"
+			if err := printResult(conf, res); err != nil {
+				t.Errorf("%s -> %s: printResult() error = %v", from, to, err)
+				continue
+			}
+			var printed struct {
+				CNIVersion string `json:"cniVersion"`
+				Interfaces []struct {
+					Name string `json:"name"`
+				} `json:"interfaces"`
+				IPs []struct {
+					Version string `json:"version"`
+					Address string `json:"address"`
+				} `json:"ips"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &printed); err != nil {
+				t.Fatalf("%s -> %s: output %s: %v", from, to, out.String(), err)
+			}
+			wantIPVersion := "4"
+			if to >= "1.0.0" {
+				wantIPVersion = ""
+			}
+			if printed.CNIVersion != to || len(printed.Interfaces) != 1 || len(printed.IPs) != 1 ||
+				printed.IPs[0].Address != "10.200.1.5/24" || printed.IPs[0].Version != wantIPVersion {
+				t.Errorf("%s -> %s: printed %s", from, to, out.String())
+			}
+		}
+	}
+}
+
+// TestInstallPodRules_MarkHook verifies the MARK rule is installed at the hook of its fwmark
+func TestInstallPodRules_MarkHook(t *testing.T) {
+	conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/nonexistent/kubeconfig",
+		"allowUnsafeSources": true, "markHooks": {"0x20": "postrouting"}, "delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipt := iptables.NewFakeManager()
+	for _, fwmark := range []string{"0x10", "0x20"} {
+		if added, err := installPodRules(context.Background(), ipt, conf, permissive(conf), "team-a", "web-0", "",
+			"10.200.1.5", fwmark, ""); !added || err != nil {
+			t.Fatalf("installPodRules(%s) = %v, %v", fwmark, added, err)
+		}
+	}
+	if got := ipt.RuleHook("10.200.1.5", "0x10"); got != "" {
+		t.Errorf("hook of 0x10 = %q, want prerouting", got)
+	}
+	if got := ipt.RuleHook("10.200.1.5", "0x20"); got != iptables.HookPostrouting {
+		t.Errorf("hook of 0x20 = %q, want postrouting", got)
+	}
+}
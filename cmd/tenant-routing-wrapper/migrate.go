@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// migrationLookupFunc resolves a pod's routing annotations; replaced in tests
+var migrationLookupFunc = fetchAnnotations
+
+// migrationEventFunc records the event of a migrated pod; replaced in tests
+var migrationEventFunc = recordMarkApplied
+
+// migrationSleep waits d or until ctx is done; replaced in tests
+var migrationSleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// migrationCandidate is a running pod ADD left unmarked that has a fwmark annotation now
+type migrationCandidate struct {
+	rec         *state.Record
+	annotations tenant.RoutingAnnotations
+}
+
+// migrationCandidates returns the pods to migrate, ordered by namespace and name
+// Candidates come from the state records ADD wrote without a fwmark; pods that are
+// still unannotated, bypassed or whose annotations cannot be read are left for a
+// later pass.
+func migrationCandidates(ctx context.Context, conf *config.PluginConf) []migrationCandidate {
+	records, err := state.New(conf.StateDir).List(conf.Name)
+	if err != nil {
+		migrateLog.Warnf("some state records not considered for migration: %v", err)
+	}
+
+	var candidates []migrationCandidate
+	for _, rec := range records {
+		if rec.Fwmark != "" || rec.Pending || rec.PodIP() == "" {
+			continue
+		}
+		annotations, err := migrationLookupFunc(ctx, conf, rec.Pod, rec.Namespace)
+		if err != nil {
+			migrateLog.Warnf("pod %s/%s: %v", rec.Namespace, rec.Pod, err)
+			continue
+		}
+		if rec.PodUID != "" && annotations.PodUID != "" && rec.PodUID != annotations.PodUID {
+			// The name was reused: the annotations belong to another pod
+			migrateLog.Warnf("pod %s/%s: container %s belongs to UID %s, the pod object has UID %s",
+				rec.Namespace, rec.Pod, rec.ContainerID, rec.PodUID, annotations.PodUID)
+			continue
+		}
+		if annotations.Fwmark == "" || bypassActive(annotations, rec.Namespace, rec.Pod) {
+			continue
+		}
+		candidates = append(candidates, migrationCandidate{rec: rec, annotations: annotations})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].rec, candidates[j].rec
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Pod < b.Pod
+	})
+	return candidates
+}
+
+// migratePod marks one running pod the way ADD would have
+// The record gets the fwmark first, so DEL undoes the rules even if it runs meanwhile.
+// Steps that fail leave the record pending for GC to complete (see retryPending).
+// A pod whose rules cannot be changed because the node lock is held is left for the
+// next pass. Returns whether the pod is marked now.
+func migratePod(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, c migrationCandidate) bool {
+	unlock, err := acquireNodeLock(conf)
+	if err != nil {
+		migrateLog.Warnf("pod %s/%s not marked: %v", c.rec.Namespace, c.rec.Pod, err)
+		return false
+	}
+	defer unlock()
+
+	store := state.New(conf.StateDir)
+	rec := c.rec
+	err = store.Update(rec.Network, rec.ContainerID, rec.IfName, func(current *state.Record) error {
+		current.Fwmark, current.Gateway = c.annotations.Fwmark, c.annotations.Gateway
+		if current.PodUID == "" {
+			current.PodUID = c.annotations.PodUID
+		}
+		rec = current
+		return nil
+	})
+	if errors.Is(err, state.ErrNotFound) {
+		// DEL ran since the candidate was listed
+		return false
+	}
+	if err != nil {
+		migrateLog.Warnf("pod %s/%s not marked: %v", rec.Namespace, rec.Pod, err)
+		return false
+	}
+
+	failed := false
+	fail := func(code reason.Code, format string, args ...interface{}) error {
+		migrateLog.Warnf(format+" (reason=%s)", append(args, code)...)
+		failed = true
+		return nil
+	}
+	added, _ := installPodRules(ctx, ipt, conf, fail, rec.Namespace, rec.Pod, rec.PodUID, rec.PodIP(), rec.Fwmark, rec.Gateway)
+
+	err = store.Update(rec.Network, rec.ContainerID, rec.IfName, func(current *state.Record) error {
+		current.Pending = current.Pending || failed
+		return nil
+	})
+	if errors.Is(err, state.ErrNotFound) {
+		// DEL ran while the rules were installed
+		if added {
+			removePodRules(ctx, ipt, conf, rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark, rec.Gateway)
+		}
+		return false
+	}
+	if failed {
+		if err != nil {
+			migrateLog.Warnf("failed to queue rules of pod %s/%s: %v", rec.Namespace, rec.Pod, err)
+		}
+		return false
+	}
+
+	// The metric and the event do not need the lock (releasing twice is harmless)
+	unlock()
+	migrateLog.Infof("migrated pod %s/%s (IP: %s) to fwmark %s", rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark)
+	recordAssignment(conf, rec.Namespace, rec.Pod, state.Assignment{
+		Cause: state.CauseMigrate, Fwmark: rec.Fwmark, Gateway: rec.Gateway,
+		PodUID: rec.PodUID, ContainerID: rec.ContainerID, IP: rec.PodIP(),
+	})
+	if conf.MetricsFile != "" {
+		recorder, err := metrics.NewRecorder(conf.MetricsFile)
+		if err == nil {
+			err = recorder.ObserveMigration(rec.Fwmark)
+		}
+		if err != nil {
+			migrateLog.Warnf("failed to record migration of pod %s/%s: %v", rec.Namespace, rec.Pod, err)
+		}
+	}
+	message := fmt.Sprintf("Tenant fwmark %s applied to the running pod (IP %s)", rec.Fwmark, rec.PodIP())
+	if err := migrationEventFunc(ctx, conf, rec, message); err != nil {
+		migrateLog.Warnf("%v", err)
+	}
+	return true
+}
+
+// runMigrationPass marks up to batch candidates, at most rate pods per second
+// Progress is printed and exposed as the migration pending gauge. dryRun only lists
+// the candidates. Returns the number of pods migrated and still waiting.
+func runMigrationPass(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, rate float64, batch int,
+	dryRun bool, stdout io.Writer) (migrated, remaining int) {
+	candidates := migrationCandidates(ctx, conf)
+	remaining = len(candidates)
+	pending := map[string]int{}
+	for _, c := range candidates {
+		pending[c.annotations.Fwmark]++
+	}
+
+	delay := time.Duration(float64(time.Second) / rate)
+	for i, c := range candidates {
+		if i == batch {
+			break
+		}
+		if dryRun {
+			fmt.Fprintf(stdout, "candidate\t%s/%s\t%s\t%s\n", c.rec.Namespace, c.rec.Pod, c.rec.PodIP(), c.annotations.Fwmark)
+			continue
+		}
+		if i > 0 && migrationSleep(ctx, delay) != nil {
+			break
+		}
+		status := "failed"
+		if migratePod(ctx, ipt, conf, c) {
+			status = "migrated"
+			migrated++
+			remaining--
+			pending[c.annotations.Fwmark]--
+		}
+		fmt.Fprintf(stdout, "%s\t%s/%s\t%s\t%s\n", status, c.rec.Namespace, c.rec.Pod, c.rec.PodIP(), c.annotations.Fwmark)
+	}
+	fmt.Fprintf(stdout, "progress\t%d migrated, %d remaining\n", migrated, remaining)
+
+	if conf.MetricsFile != "" && !dryRun {
+		recorder, err := metrics.NewRecorder(conf.MetricsFile)
+		if err == nil {
+			err = recorder.SetMigrationPending(pending)
+		}
+		if err != nil {
+			migrateLog.Warnf("failed to record migration progress: %v", err)
+		}
+	}
+	return migrated, remaining
+}
+
+// migrateCommand implements `tenant-routing-wrapper migrate`
+// Marks running pods whose namespace (or the pod itself) was annotated after ADD:
+//
+//	tenant-routing-wrapper migrate --conflist /etc/cni/net.d/10-tenant.conflist [--rate 5] [--batch 50] [--interval 30s] [--dry-run]
+//
+// Pods are marked at most --rate per second and --batch per pass, so annotating a
+// large namespace does not reprogram every pod at once. With --interval passes repeat
+// until SIGINT/SIGTERM. Each migrated pod gets a TenantMarkApplied event.
+//
+// Returns the process exit code.
+func migrateCommand(ipt iptables.Manager, args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	rate := fs.Float64("rate", 5, "pods marked per second")
+	batch := fs.Int("batch", 50, "pods marked per pass")
+	interval := fs.Duration("interval", 0, "repeat every interval until interrupted (0: run once)")
+	dryRun := fs.Bool("dry-run", false, "list the pods that would be marked")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "migrate: --conflist is required")
+		return 2
+	}
+	if *rate <= 0 || *batch <= 0 {
+		fmt.Fprintln(fs.Output(), "migrate: --rate and --batch must be positive")
+		return 2
+	}
+	if *interval < 0 {
+		fmt.Fprintln(fs.Output(), "migrate: --interval must not be negative")
+		return 2
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		migrateLog.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		migrateLog.Errorf("%v", err)
+		return 1
+	}
+	defer setupLogging(conf)()
+	setupK8s(conf)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *interval == 0 {
+		runMigrationPass(ctx, ipt, conf, *rate, *batch, *dryRun, stdout)
+		return 0
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		runMigrationPass(ctx, ipt, conf, *rate, *batch, *dryRun, stdout)
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
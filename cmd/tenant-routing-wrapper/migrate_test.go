@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestRunMigrationPass verifies newly annotated pods are marked in rate-limited batches
+func TestRunMigrationPass(t *testing.T) {
+	dir := t.TempDir()
+	conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/nonexistent/kubeconfig",
+		"stateDir": "` + dir + `", "metricsFile": "` + filepath.Join(dir, "tenant_routing.prom") + `",
+		"delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := state.New(conf.StateDir)
+	for _, rec := range []*state.Record{
+		{Network: "test-network", ContainerID: "a", IfName: "eth0", Namespace: "team-a", Pod: "web-0", IPs: []string{"10.200.1.5"}},
+		{Network: "test-network", ContainerID: "b", IfName: "eth0", Namespace: "team-a", Pod: "web-1", IPs: []string{"10.200.1.6"}},
+		{Network: "test-network", ContainerID: "c", IfName: "eth0", Namespace: "other", Pod: "db-0", IPs: []string{"10.200.1.7"}},
+		{Network: "test-network", ContainerID: "d", IfName: "eth0", Namespace: "team-b", Pod: "api-0", IPs: []string{"10.200.1.8"}, Fwmark: "0x20"},
+	} {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origLookup, origEvent, origSleep := migrationLookupFunc, migrationEventFunc, migrationSleep
+	t.Cleanup(func() { migrationLookupFunc, migrationEventFunc, migrationSleep = origLookup, origEvent, origSleep })
+	migrationLookupFunc = func(_ context.Context, _ *config.PluginConf, _, podNamespace string) (k8s.RoutingAnnotations, error) {
+		if podNamespace == "team-a" {
+			return k8s.RoutingAnnotations{Fwmark: "0x10"}, nil
+		}
+		return k8s.RoutingAnnotations{}, nil
+	}
+	var events []string
+	migrationEventFunc = func(_ context.Context, _ *config.PluginConf, rec *state.Record, _ string) error {
+		events = append(events, rec.Namespace+"/"+rec.Pod)
+		return nil
+	}
+	var delays []time.Duration
+	migrationSleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	ipt := iptables.NewFakeManager()
+	var out bytes.Buffer
+	if migrated, remaining := runMigrationPass(context.Background(), ipt, conf, 4, 1, false, &out); migrated != 1 || remaining != 1 {
+		t.Errorf("first pass = %d migrated, %d remaining; want 1, 1\n%s", migrated, remaining, out.String())
+	}
+	if migrated, remaining := runMigrationPass(context.Background(), ipt, conf, 4, 10, false, &out); migrated != 1 || remaining != 0 {
+		t.Errorf("second pass = %d migrated, %d remaining; want 1, 0\n%s", migrated, remaining, out.String())
+	}
+
+	for _, ip := range []string{"10.200.1.5", "10.200.1.6"} {
+		if exists, _ := ipt.RuleExists(context.Background(), ip, "0x10"); !exists {
+			t.Errorf("MARK rule for %s not installed", ip)
+		}
+	}
+	if rec, err := store.Load("test-network", "a", "eth0"); err != nil || rec.Fwmark != "0x10" {
+		t.Errorf("record after migration = %+v, %v; want fwmark 0x10 so DEL removes the rule", rec, err)
+	}
+	if strings.Join(events, ",") != "team-a/web-0,team-a/web-1" {
+		t.Errorf("events = %v", events)
+	}
+	if len(delays) != 0 {
+		t.Errorf("delays = %v, want none (one pod per pass)", delays)
+	}
+	if !strings.Contains(out.String(), "progress\t1 migrated, 0 remaining") {
+		t.Errorf("output missing progress line\n%s", out.String())
+	}
+
+	metricsData, err := os.ReadFile(conf.MetricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`tenant_routing_migrated_pods_total{tenant="0x10"} 2`, `tenant_routing_migration_pending_pods{tenant="0x10"} 0`} {
+		if !strings.Contains(string(metricsData), want) {
+			t.Errorf("metrics missing %s\n%s", want, metricsData)
+		}
+	}
+}
+
+// TestRunMigrationPass_Rate verifies pods of one pass are spaced by 1/rate
+func TestRunMigrationPass_Rate(t *testing.T) {
+	conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/nonexistent/kubeconfig",
+		"stateDir": "` + t.TempDir() + `", "delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := state.New(conf.StateDir)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := store.Save(&state.Record{Network: "test-network", ContainerID: id, IfName: "eth0",
+			Namespace: "team-a", Pod: "web-" + id, IPs: []string{"10.200.1.5"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origLookup, origEvent, origSleep := migrationLookupFunc, migrationEventFunc, migrationSleep
+	t.Cleanup(func() { migrationLookupFunc, migrationEventFunc, migrationSleep = origLookup, origEvent, origSleep })
+	migrationLookupFunc = func(context.Context, *config.PluginConf, string, string) (k8s.RoutingAnnotations, error) {
+		return k8s.RoutingAnnotations{Fwmark: "0x10"}, nil
+	}
+	migrationEventFunc = func(context.Context, *config.PluginConf, *state.Record, string) error { return nil }
+	var delays []time.Duration
+	migrationSleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	var out bytes.Buffer
+	runMigrationPass(context.Background(), iptables.NewFakeManager(), conf, 4, 10, false, &out)
+	if len(delays) != 2 || delays[0] != 250*time.Millisecond {
+		t.Errorf("delays = %v, want 2 x 250ms", delays)
+	}
+}
+
+// TestRunMigrationPass_PodUID verifies records of an earlier pod with the same name are skipped
+// and migrated rules are tagged with the pod UID
+func TestRunMigrationPass_PodUID(t *testing.T) {
+	dir := t.TempDir()
+	conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/nonexistent/kubeconfig",
+		"stateDir": "` + dir + `", "podUIDComments": true, "delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := state.New(conf.StateDir)
+	for _, rec := range []*state.Record{
+		{Network: "test-network", ContainerID: "a", IfName: "eth0", Namespace: "team-a", Pod: "web-0",
+			PodUID: "uid-old", IPs: []string{"10.200.1.5"}},
+		{Network: "test-network", ContainerID: "b", IfName: "eth0", Namespace: "team-a", Pod: "web-1",
+			IPs: []string{"10.200.1.6"}},
+	} {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	origLookup, origEvent := migrationLookupFunc, migrationEventFunc
+	t.Cleanup(func() { migrationLookupFunc, migrationEventFunc = origLookup, origEvent })
+	migrationLookupFunc = func(_ context.Context, _ *config.PluginConf, podName, _ string) (k8s.RoutingAnnotations, error) {
+		return k8s.RoutingAnnotations{Fwmark: "0x10", PodUID: "uid-" + podName}, nil
+	}
+	migrationEventFunc = func(context.Context, *config.PluginConf, *state.Record, string) error { return nil }
+
+	ipt := iptables.NewFakeManager()
+	var out bytes.Buffer
+	if migrated, remaining := runMigrationPass(context.Background(), ipt, conf, 4, 10, false, &out); migrated != 1 || remaining != 0 {
+		t.Errorf("pass = %d migrated, %d remaining; want 1, 0\n%s", migrated, remaining, out.String())
+	}
+	if exists, _ := ipt.RuleExists(context.Background(), "10.200.1.5", "0x10"); exists {
+		t.Error("pod IP of an earlier web-0 marked with the annotation of the current one")
+	}
+	if got := ipt.RulePodUID("10.200.1.6", "0x10"); got != "uid-web-1" {
+		t.Errorf("MARK rule tagged with UID %q, want uid-web-1", got)
+	}
+	if rec, err := store.Load("test-network", "b", "eth0"); err != nil || rec.PodUID != "uid-web-1" {
+		t.Errorf("record after migration = %+v, %v; want the pod UID recorded", rec, err)
+	}
+}
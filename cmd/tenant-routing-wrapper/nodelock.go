@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/nodelock"
+)
+
+// acquireNodeLock takes the node lock (conf.LockFile) before rules are changed
+// Waits at most conf.LockTimeout seconds. Returns the function releasing the lock;
+// calling it more than once is harmless.
+func acquireNodeLock(conf *config.PluginConf) (func(), error) {
+	lock, err := nodelock.Acquire(conf.LockFile, time.Duration(conf.LockTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := lock.Release(); err != nil {
+			cniLog.Warnf("failed to release node lock: %v", err)
+		}
+	}, nil
+}
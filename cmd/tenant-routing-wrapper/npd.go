@@ -0,0 +1,82 @@
+//go:build !agentonly
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultProblemAfter is how many passes in a row a check fails before it is reported
+const defaultProblemAfter = 3
+
+// problemPrefix tags problem log lines; node-problem-detector rules match the reason after it
+const problemPrefix = "tenant-routing: "
+
+// problemTimeFormat is the timestamp of problem log lines, always UTC so it has a fixed
+// width for the timestamp pattern of node-problem-detector's filelog plugin
+const problemTimeFormat = "2006-01-02T15:04:05Z"
+
+// problemReporter appends a node-problem-detector problem entry for every check that
+// failed after consecutive passes, and a recovery entry once it passes again
+//
+// Entries are single lines of a log read by node-problem-detector's system-log-monitor:
+//
+//	2026-10-17T12:00:00Z tenant-routing: IptablesUnavailable: iptables: xtables lock (failing for 3 passes)
+//	2026-10-17T12:05:00Z tenant-routing: recovered: iptables
+//
+// so remediation keyed on node conditions (cordon, reboot) acts on the reasons of
+// readyCondition without a dedicated alert. Transient failures shorter than after
+// passes are not reported.
+type problemReporter struct {
+	path  string
+	after int
+	now   func() time.Time
+
+	// failing counts the consecutive failing passes of each check
+	failing map[string]int
+}
+
+func newProblemReporter(path string, after int) *problemReporter {
+	return &problemReporter{path: path, after: after, now: time.Now, failing: map[string]int{}}
+}
+
+// observe records the outcome of a health pass and appends the entries it causes
+func (r *problemReporter) observe(checks []healthCheck) error {
+	var lines []string
+	timestamp := r.now().UTC().Format(problemTimeFormat)
+	for _, c := range checks {
+		if c.err == nil {
+			if r.failing[c.name] >= r.after {
+				lines = append(lines, fmt.Sprintf("%s %srecovered: %s", timestamp, problemPrefix, c.name))
+			}
+			delete(r.failing, c.name)
+			continue
+		}
+		r.failing[c.name]++
+		if r.failing[c.name] == r.after {
+			// node-problem-detector matches line by line
+			message := strings.ReplaceAll(c.err.Error(), "\n", "; ")
+			lines = append(lines, fmt.Sprintf("%s %s%s: %s: %s (failing for %d passes)", timestamp, problemPrefix,
+				c.reason, c.name, message, r.after))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open problem log: %w", err)
+	}
+	_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write problem log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build !agentonly
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestProblemReporter verifies only checks failing for consecutive passes are reported, once
+func TestProblemReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "problems.log")
+	r := newProblemReporter(path, 2)
+	r.now = func() time.Time { return time.Date(2026, 10, 17, 14, 0, 0, 0, time.FixedZone("CEST", 2*3600)) }
+
+	failing := []healthCheck{
+		{name: "iptables", reason: "IptablesUnavailable", err: errors.New("xtables lock")},
+		{name: "gateways", reason: "GatewayUnreachable", err: errors.Join(errors.New("0x10 dead"), errors.New("0x20 dead"))},
+	}
+	// The gateway recovers after one failing pass, before it is reported
+	flapping := []healthCheck{failing[0], {name: "gateways", reason: "GatewayUnreachable"}}
+	healthy := []healthCheck{{name: "iptables", reason: "IptablesUnavailable"}, {name: "gateways", reason: "GatewayUnreachable"}}
+
+	for _, checks := range [][]healthCheck{failing, flapping, flapping, flapping, healthy, healthy} {
+		if err := r.observe(checks); err != nil {
+			t.Fatalf("observe() error = %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2026-10-17T12:00:00Z tenant-routing: IptablesUnavailable: iptables: xtables lock (failing for 2 passes)\n" +
+		"2026-10-17T12:00:00Z tenant-routing: recovered: iptables\n"
+	if string(data) != want {
+		t.Errorf("problem log = %q, want %q", data, want)
+	}
+
+	// Multi-line errors stay on one line
+	r = newProblemReporter(path, 1)
+	if err := r.observe(failing); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), "GatewayUnreachable: gateways: 0x10 dead; 0x20 dead (failing for 1 passes)\n") {
+		t.Errorf("problem log = %q, want the gateway errors on one line", data)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+)
+
+// invocation collects the metrics of the CNI command being run (see observeCommand)
+// The binary runs one command per process; tests calling cmdAdd and friends directly
+// only accumulate into it.
+var invocation = newInvocation("")
+
+func newInvocation(command string) *metrics.Invocation {
+	return &metrics.Invocation{Command: command, K8sAPI: map[string][]time.Duration{}, IptablesFailures: map[string]int{}}
+}
+
+// observeCommand runs fn as CNI command and records its observations to metricsFile
+// The configuration is parsed again for the path: fn may have failed before parsing it.
+func observeCommand(command string, args *skel.CmdArgs, ipt iptables.Manager, fn func() error) error {
+	invocation = newInvocation(command)
+	start := time.Now()
+	err := fn()
+	invocation.Duration = time.Since(start)
+	invocation.Failed = err != nil
+
+	conf, parseErr := config.ParseConfig(args.StdinData)
+	if parseErr != nil || conf.MetricsFile == "" {
+		return err
+	}
+	// CHECK changes no rules
+	if command != "CHECK" {
+		invocation.ManagedRules = managedRules(context.Background(), ipt)
+	}
+	recorder, recErr := metrics.NewRecorder(conf.MetricsFile)
+	if recErr == nil {
+		recErr = recorder.ObserveInvocation(*invocation)
+	}
+	if recErr != nil {
+		cniLog.Warnf("failed to record %s metrics: %v", command, recErr)
+	}
+	return err
+}
+
+// managedRules counts the installed MARK rules by tenant; nil if they cannot be listed
+func managedRules(ctx context.Context, ipt iptables.Manager) map[string]int {
+	rules, err := ipt.List(ctx)
+	if err != nil {
+		iptLog.Debugf("managed rules not counted: %v", err)
+		return nil
+	}
+	counts := map[string]int{}
+	for _, rule := range rules {
+		if mark, err := route.ParseFwmark(rule.Fwmark); err == nil {
+			counts[fmt.Sprintf("%#x", mark)]++
+		}
+	}
+	return counts
+}
+
+// observeDelegate adds the time since start to the delegate duration of the invocation
+func observeDelegate(start time.Time) {
+	invocation.Delegate += time.Since(start)
+}
+
+// observeK8sAPI records one API lookup of operation that started at start
+func observeK8sAPI(operation string, start time.Time) {
+	invocation.K8sAPI[operation] = append(invocation.K8sAPI[operation], time.Since(start))
+}
+
+// observeFailure counts a failed setup step of the invocation if iptables caused it
+func observeFailure(code reason.Code) {
+	if code == reason.IptablesFailed || code == reason.IptablesLocked {
+		invocation.IptablesFailures[code.String()]++
+	}
+}
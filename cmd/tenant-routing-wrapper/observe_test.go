@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+)
+
+// TestObserveCommand verifies one invocation's observations land in the metrics file
+func TestObserveCommand(t *testing.T) {
+	dir := t.TempDir()
+	metricsFile := filepath.Join(dir, "tenant_routing.prom")
+	args := &skel.CmdArgs{StdinData: []byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/etc/kubeconfig", "delegate": {"type": "ptp"},
+		"stateDir": "` + dir + `", "metricsFile": "` + metricsFile + `"}`)}
+	ipt := iptables.NewFakeManager()
+	if err := ipt.AddMarkRule(context.Background(), "10.0.0.1", "0x10"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := observeCommand("ADD", args, ipt, func() error {
+		observeDelegate(time.Now().Add(-100 * time.Millisecond))
+		observeK8sAPI("annotations", time.Now())
+		observeFailure(reason.IptablesLocked)
+		observeFailure(reason.NoAnnotation)
+		return errors.New("strict mode")
+	})
+	if err == nil || err.Error() != "strict mode" {
+		t.Fatalf("observeCommand() error = %v, want the command's error", err)
+	}
+
+	data, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		`tenant_routing_cni_operations_total{command="ADD",result="error"} 1`,
+		`tenant_routing_cni_operation_duration_seconds_count{command="ADD"} 1`,
+		`tenant_routing_delegate_duration_seconds_bucket{command="ADD",le="0.05"} 0`,
+		`tenant_routing_k8s_api_duration_seconds_count{operation="annotations"} 1`,
+		`tenant_routing_iptables_failures_total{command="ADD",reason="IPTABLES_LOCKED"} 1`,
+		`tenant_routing_managed_rules{tenant="0x10"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %s\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "NO_ANNOTATION") {
+		t.Errorf("a skip without iptables failure was counted as one\n%s", out)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/result"
+)
+
+// errNoPrevResult is returned by CHECK and DEL without prevResult under PrevResultRequire
+var errNoPrevResult = errors.New("runtime passed no prevResult (prevResultPolicy is require)")
+
+// missingPrevResult applies prevResultPolicy to a CHECK or DEL invoked without prevResult
+// Returns an error under PrevResultRequire and false under PrevResultSkip; true means the
+// caller falls back to the state record and then the libcni result cache (see cachedPodIP).
+func missingPrevResult(conf *config.PluginConf, op string) (bool, error) {
+	switch conf.PrevResultPolicy {
+	case config.PrevResultRequire:
+		return false, fmt.Errorf("%s cannot handle tenant routing: %w", op, errNoPrevResult)
+	case config.PrevResultSkip:
+		cniLog.Debugf("%s skips tenant routing: no prevResult", op)
+		return false, nil
+	}
+	return true, nil
+}
+
+// cachedPodIP reads the pod IP from the result libcni cached after ADD
+// Returns "" if the cache has no result for the attachment or it holds no address.
+func cachedPodIP(args *skel.CmdArgs, conf *config.PluginConf, op string) string {
+	cached, err := result.LoadCached(conf.CNICacheDir, conf.Name, args.ContainerID, args.IfName)
+	if err == nil {
+		var podIP string
+		if podIP, err = result.ExtractPodIP(cached); err == nil {
+			cniLog.Infof("%s took pod IP %s from the libcni result cache", op, podIP)
+			return podIP
+		}
+	}
+	if errors.Is(err, result.ErrNoIPs) {
+		// L2-only delegate: ADD never marked this pod
+		return ""
+	}
+	cniLog.Warnf("%s cannot find the pod IP - no prevResult, state record or cached result: %v", op, err)
+	return ""
+}
+
+// verifyCachedResult compares the prevResult of a CHECK with the result libcni cached
+// after ADD, so drift names the addresses, interfaces and routes that changed
+// Runtimes without the cache, or an unreadable cache, are not a CHECK failure.
+func verifyCachedResult(args *skel.CmdArgs, conf *config.PluginConf, podNamespace, podName string) error {
+	cached, err := result.LoadCached(conf.CNICacheDir, conf.Name, args.ContainerID, args.IfName)
+	if err != nil {
+		cniLog.Debugf("CHECK cannot compare prevResult with the libcni result cache: %v", err)
+		return nil
+	}
+	drift, err := result.Diff(cached, conf.PrevResult)
+	if err != nil {
+		cniLog.Warnf("CHECK cannot compare prevResult with the libcni result cache: %v", err)
+		return nil
+	}
+	if !drift.Empty() {
+		return fmt.Errorf("configuration drift detected: prevResult of pod %s/%s differs from the result cached after ADD: %s",
+			podNamespace, podName, drift)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types/create"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestCmdDel_PrevResultPolicy verifies require fails and skip leaves rules and record alone
+func TestCmdDel_PrevResultPolicy(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "noop"), []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "DEL")
+
+	for _, tt := range []struct {
+		policy  string
+		wantErr error
+	}{
+		{policy: config.PrevResultRequire, wantErr: errNoPrevResult},
+		{policy: config.PrevResultSkip},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			stateDir := t.TempDir()
+			store := state.New(stateDir)
+			if err := store.Save(&state.Record{
+				Network: "test-network", ContainerID: "test-container-123", IfName: "eth0",
+				Namespace: "default", Pod: "test", IPs: []string{"10.200.1.5"}, Fwmark: "0x10",
+			}); err != nil {
+				t.Fatal(err)
+			}
+			ipt := iptables.NewFakeManager()
+			if err := ipt.AddMarkRule(context.Background(), "10.200.1.5", "0x10"); err != nil {
+				t.Fatal(err)
+			}
+
+			args := &skel.CmdArgs{
+				ContainerID: "test-container-123",
+				IfName:      "eth0",
+				Args:        "K8S_POD_NAME=test;K8S_POD_NAMESPACE=default",
+				StdinData: []byte(`{
+					"cniVersion": "1.0.0",
+					"name": "test-network",
+					"type": "tenant-routing-wrapper",
+					"kubeconfig": "/nonexistent/kubeconfig",
+					"stateDir": "` + stateDir + `",
+					"lockFile": "` + filepath.Join(stateDir, "lock") + `",
+					"prevResultPolicy": "` + tt.policy + `",
+					"delegate": {"type": "noop"}
+				}`),
+			}
+			if err := cmdDel(args, ipt); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("cmdDel() error = %v, want %v", err, tt.wantErr)
+			}
+			if exists, _ := ipt.RuleExists(context.Background(), "10.200.1.5", "0x10"); !exists {
+				t.Error("MARK rule removed although the policy does not fall back to the state record")
+			}
+			if _, err := store.Load("test-network", "test-container-123", "eth0"); err != nil {
+				t.Errorf("state record removed: %v", err)
+			}
+		})
+	}
+}
+
+// TestCachedPodIP verifies the pod IP is read from the libcni result cache
+func TestCachedPodIP(t *testing.T) {
+	cacheDir := t.TempDir()
+	conf := &config.PluginConf{CNICacheDir: cacheDir}
+	conf.Name = "test-network"
+	args := &skel.CmdArgs{ContainerID: "test-container-123", IfName: "eth0"}
+
+	if ip := cachedPodIP(args, conf, "CHECK"); ip != "" {
+		t.Errorf("cachedPodIP() without cache file = %q, want empty", ip)
+	}
+
+	if err := os.MkdirAll(filepath.Join(cacheDir, "results"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	cached := `{"kind": "cniCacheV1", "containerId": "test-container-123", "ifName": "eth0",
+		"networkName": "test-network", "result": {"cniVersion": "1.0.0", "ips": [{"address": "10.200.1.5/24"}]}}`
+	if err := os.WriteFile(filepath.Join(cacheDir, "results", "test-network-test-container-123-eth0"),
+		[]byte(cached), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if ip := cachedPodIP(args, conf, "CHECK"); ip != "10.200.1.5" {
+		t.Errorf("cachedPodIP() = %q, want 10.200.1.5", ip)
+	}
+}
+
+// TestVerifyCachedResult verifies CHECK reports how prevResult drifted from the cached ADD result
+func TestVerifyCachedResult(t *testing.T) {
+	cacheDir := t.TempDir()
+	conf := &config.PluginConf{CNICacheDir: cacheDir}
+	conf.Name = "test-network"
+	args := &skel.CmdArgs{ContainerID: "test-container-123", IfName: "eth0"}
+	prevResult, err := create.CreateFromBytes([]byte(`{"cniVersion": "1.0.0", "ips": [{"address": "10.200.1.6/24"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.PrevResult = prevResult
+
+	if err := verifyCachedResult(args, conf, "team-a", "web"); err != nil {
+		t.Errorf("verifyCachedResult() without cache file = %v, want nil", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(cacheDir, "results"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	cached := `{"kind": "cniCacheV1", "result": {"cniVersion": "1.0.0", "ips": [{"address": "10.200.1.5/24"}]}}`
+	if err := os.WriteFile(filepath.Join(cacheDir, "results", "test-network-test-container-123-eth0"),
+		[]byte(cached), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err = verifyCachedResult(args, conf, "team-a", "web")
+	if err == nil || !strings.Contains(err.Error(), "IP 10.200.1.5/24 removed; IP 10.200.1.6/24 added") {
+		t.Errorf("verifyCachedResult() error = %v, want the changed address", err)
+	}
+
+	conf.PrevResult, _ = create.CreateFromBytes([]byte(`{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "10.200.1.5/24"}]}`))
+	if err := verifyCachedResult(args, conf, "team-a", "web"); err != nil {
+		t.Errorf("verifyCachedResult() of the cached result = %v, want nil", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// queueOnLock wraps fail so that a skip caused by the xtables lock or the node lock sets *queued
+// Strict mode still fails the ADD: only a skipped step can be completed later.
+func queueOnLock(fail setupFailed, queued *bool) setupFailed {
+	return func(code reason.Code, format string, args ...interface{}) error {
+		if err := fail(code, format, args...); err != nil {
+			return err
+		}
+		if code == reason.IptablesLocked || code == reason.NodeLocked {
+			*queued = true
+		}
+		return nil
+	}
+}
+
+// queuePodRules marks the attachment's state record pending, so GC installs its rules
+// The record was saved before the rules were attempted; without it the intent is
+// lost and the pod stays unmarked until it is recreated.
+func queuePodRules(args *skel.CmdArgs, conf *config.PluginConf) {
+	var rec *state.Record
+	err := state.New(conf.StateDir).Update(conf.Name, args.ContainerID, args.IfName, func(current *state.Record) error {
+		current.Pending = true
+		rec = current
+		return nil
+	})
+	if err != nil {
+		cniLog.Warnf("failed to queue rules of container %s, pod stays unmarked: %v", args.ContainerID, err)
+		return
+	}
+	cniLog.Infof("queued rules of pod %s/%s (IP: %s, fwmark: %s) until the lock is free",
+		rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark)
+}
+
+// retryPending installs the rules of every record queued by ADD
+// Used by GC before collecting orphans. A record is cleared once all of its rules are
+// in place; if DEL removed it meanwhile, the rules are removed again. Returns the
+// number of records installed and still pending.
+func retryPending(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf) (installed, pending int) {
+	store := state.New(conf.StateDir)
+	records, err := store.List(conf.Name)
+	if err != nil {
+		gcLog.Warnf("some state records not retried: %v", err)
+	}
+
+	for _, rec := range records {
+		if !rec.Pending {
+			continue
+		}
+		failed := false
+		fail := func(code reason.Code, format string, args ...interface{}) error {
+			gcLog.Warnf(format+" (reason=%s)", append(args, code)...)
+			failed = true
+			return nil
+		}
+		added, _ := installPodRules(ctx, ipt, conf, fail, rec.Namespace, rec.Pod, rec.PodUID, rec.PodIP(), rec.Fwmark, rec.Gateway)
+		if failed {
+			pending++
+			continue
+		}
+
+		err := store.Update(rec.Network, rec.ContainerID, rec.IfName, func(current *state.Record) error {
+			current.Pending = false
+			return nil
+		})
+		if errors.Is(err, state.ErrNotFound) {
+			if added {
+				removePodRules(ctx, ipt, conf, rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark, rec.Gateway)
+			}
+			continue
+		}
+		if err != nil {
+			gcLog.Warnf("rules of pod %s/%s installed but record not updated: %v", rec.Namespace, rec.Pod, err)
+		}
+		gcLog.Infof("installed queued rules of pod %s/%s (IP: %s, fwmark: %s)",
+			rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark)
+		installed++
+	}
+	return installed, pending
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+var errLocked = errors.New("exit status 4: Another app is currently holding the xtables lock. Stopped waiting after 2s.")
+
+// TestQueueOnLock verifies only permissive lock timeouts are queued
+func TestQueueOnLock(t *testing.T) {
+	permissiveFail := func(reason.Code, string, ...interface{}) error { return nil }
+	strictFail := func(code reason.Code, format string, args ...interface{}) error {
+		return fmt.Errorf("strict mode: "+format, args...)
+	}
+
+	tests := []struct {
+		name       string
+		fail       setupFailed
+		code       reason.Code
+		wantQueued bool
+		wantErr    bool
+	}{
+		{name: "permissive lock timeout", fail: permissiveFail, code: reason.IptablesLocked, wantQueued: true},
+		{name: "permissive node lock timeout", fail: permissiveFail, code: reason.NodeLocked, wantQueued: true},
+		{name: "permissive other failure", fail: permissiveFail, code: reason.IptablesFailed},
+		{name: "strict lock timeout", fail: strictFail, code: reason.IptablesLocked, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queued bool
+			err := queueOnLock(tt.fail, &queued)(tt.code, "failed")
+			if (err != nil) != tt.wantErr || queued != tt.wantQueued {
+				t.Errorf("queueOnLock() = %v, queued %v; want error %v, queued %v", err, queued, tt.wantErr, tt.wantQueued)
+			}
+		})
+	}
+}
+
+// TestRetryPending verifies queued rules are installed once the lock is free
+func TestRetryPending(t *testing.T) {
+	conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/nonexistent/kubeconfig",
+		"stateDir": "` + t.TempDir() + `", "delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := state.New(conf.StateDir)
+	for _, rec := range []*state.Record{
+		{Network: "test-network", ContainerID: "queued", IfName: "eth0", Namespace: "default", Pod: "a",
+			IPs: []string{"10.200.1.5"}, Fwmark: "0x10"},
+		{Network: "test-network", ContainerID: "done", IfName: "eth0", Namespace: "default", Pod: "b",
+			IPs: []string{"10.200.1.6"}, Fwmark: "0x20"},
+	} {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queuePodRules(&skel.CmdArgs{ContainerID: "queued", IfName: "eth0"}, conf)
+
+	ipt := iptables.NewFakeManager()
+	ipt.Err = errLocked
+	if installed, pending := retryPending(context.Background(), ipt, conf); installed != 0 || pending != 1 {
+		t.Errorf("retryPending() while locked = %d installed, %d pending; want 0, 1", installed, pending)
+	}
+
+	ipt.Err = nil
+	if installed, pending := retryPending(context.Background(), ipt, conf); installed != 1 || pending != 0 {
+		t.Errorf("retryPending() = %d installed, %d pending; want 1, 0", installed, pending)
+	}
+	if exists, _ := ipt.RuleExists(context.Background(), "10.200.1.5", "0x10"); !exists {
+		t.Error("queued MARK rule not installed")
+	}
+	if exists, _ := ipt.RuleExists(context.Background(), "10.200.1.6", "0x20"); exists {
+		t.Error("MARK rule installed for a record that was not queued")
+	}
+	if rec, err := store.Load("test-network", "queued", "eth0"); err != nil || rec.Pending {
+		t.Errorf("record after retry = %+v, %v; want not pending", rec, err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reconcile"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// reconcileLookupFunc resolves a pod's routing annotations; replaced in tests
+var reconcileLookupFunc = fetchAnnotations
+
+// apiResolver answers the annotations of pods from the API server, like ADD does
+// (reconcile.Resolver)
+type apiResolver struct {
+	conf *config.PluginConf
+}
+
+func (r apiResolver) RoutingAnnotations(ctx context.Context, podName, podNamespace string, _ []string, _ string,
+	_ time.Duration) (tenant.RoutingAnnotations, error) {
+	return reconcileLookupFunc(ctx, r.conf, podName, podNamespace)
+}
+
+// reconcileCommand implements the standalone invocation:
+//
+//	tenant-routing-wrapper reconcile --conflist /etc/cni/net.d/10-tenant.conflist [--dry-run]
+//
+// It runs the passes of the node agent once, against the API server: the rules of
+// pods whose annotations changed since ADD are added, removed or moved (see
+// reconcile.Relabel), then missing rules and routes are re-added (see reconcile.Run).
+// With --dry-run the changes are printed, one per line, and nothing is changed:
+//
+//	move	pod team-a/web (IP: 10.200.1.5) from fwmark "0x10" gateway "" to fwmark "0x20" gateway ""
+//	add	MARK rule of pod team-a/api (IP: 10.200.1.6, fwmark: 0x10)
+//
+// Returns the process exit code: 1 if a pod could not be verified or changed.
+func reconcileCommand(ipt iptables.Manager, args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	dryRun := fs.Bool("dry-run", false, "print the changes a reconcile would make without making them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "reconcile: --conflist is required")
+		return 2
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		reconcileLog.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		reconcileLog.Errorf("%v", err)
+		return 1
+	}
+	defer setupLogging(conf)()
+	setupK8s(conf)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := runReconcile(ctx, ipt, conf, *dryRun, stdout); err != nil {
+		reconcileLog.Errorf("reconcile failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// runReconcile runs Relabel then Run, or prints their Diff if dryRun is set
+// Every change is printed; a failure does not stop the passes and the first one is returned.
+func runReconcile(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, dryRun bool,
+	stdout io.Writer) error {
+	resolver := apiResolver{conf: conf}
+	timeout := k8sTimeout(conf)
+	if dryRun {
+		changes, err := reconcile.Diff(ctx, ipt, conf, resolver, timeout)
+		counts := map[string]int{}
+		for _, change := range changes {
+			fmt.Fprintln(stdout, change)
+			counts[change.Action]++
+		}
+		fmt.Fprintf(stdout, "summary\t%d to add, %d to remove, %d to move\n",
+			counts[reconcile.ChangeAdd], counts[reconcile.ChangeRemove], counts[reconcile.ChangeMove])
+		return err
+	}
+
+	relabeled, firstErr := reconcile.Relabel(ctx, ipt, conf, resolver, timeout)
+	for _, what := range relabeled.Relabeled {
+		fmt.Fprintf(stdout, "relabeled\t%s\n", what)
+	}
+	repaired, err := reconcile.Run(ctx, ipt, conf, resolver, timeout)
+	for _, what := range repaired.Repaired {
+		fmt.Fprintf(stdout, "repaired\t%s\n", what)
+	}
+	fmt.Fprintf(stdout, "summary\t%d relabeled, %d repaired\n", len(relabeled.Relabeled), len(repaired.Repaired))
+	if firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestRunReconcile verifies --dry-run prints the changes the real pass then makes
+func TestRunReconcile(t *testing.T) {
+	dir := t.TempDir()
+	conf, err := config.ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "test-network",
+		"type": "tenant-routing-wrapper", "kubeconfig": "/nonexistent/kubeconfig",
+		"stateDir": "` + dir + `", "lockFile": "` + filepath.Join(dir, "node.lock") + `",
+		"delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := state.New(conf.StateDir)
+	for _, rec := range []*state.Record{
+		{Network: "test-network", ContainerID: "a", IfName: "eth0", Namespace: "team-a", Pod: "web-0", IPs: []string{"10.200.1.5"}, Fwmark: "0x10"},
+		{Network: "test-network", ContainerID: "b", IfName: "eth0", Namespace: "team-b", Pod: "api-0", IPs: []string{"10.200.1.6"}, Fwmark: "0x10"},
+	} {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orig := reconcileLookupFunc
+	t.Cleanup(func() { reconcileLookupFunc = orig })
+	reconcileLookupFunc = func(_ context.Context, _ *config.PluginConf, _, podNamespace string) (k8s.RoutingAnnotations, error) {
+		if podNamespace == "team-b" {
+			return k8s.RoutingAnnotations{Fwmark: "0x20"}, nil
+		}
+		return k8s.RoutingAnnotations{Fwmark: "0x10"}, nil
+	}
+
+	ipt := iptables.NewFakeManager()
+	if err := ipt.AddMarkRule(context.Background(), "10.200.1.6", "0x10"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runReconcile(context.Background(), ipt, conf, true, &out); err != nil {
+		t.Fatalf("runReconcile(dry-run) error = %v", err)
+	}
+	want := "add\tMARK rule of pod team-a/web-0 (IP: 10.200.1.5, fwmark: 0x10)\n" +
+		"move\tpod team-b/api-0 (IP: 10.200.1.6) from fwmark \"0x10\" gateway \"\" to fwmark \"0x20\" gateway \"\"\n" +
+		"summary\t1 to add, 0 to remove, 1 to move\n"
+	if out.String() != want {
+		t.Errorf("dry-run output =\n%s\nwant\n%s", out.String(), want)
+	}
+	if rules, _ := ipt.List(context.Background()); len(rules) != 1 || rules[0].Fwmark != "0x10" {
+		t.Errorf("rules after dry-run = %v, want them unchanged", rules)
+	}
+
+	out.Reset()
+	if err := runReconcile(context.Background(), ipt, conf, false, &out); err != nil {
+		t.Fatalf("runReconcile() error = %v\n%s", err, out.String())
+	}
+	for ip, fwmark := range map[string]string{"10.200.1.5": "0x10", "10.200.1.6": "0x20"} {
+		if exists, _ := ipt.RuleExists(context.Background(), ip, fwmark); !exists {
+			t.Errorf("MARK rule of %s with %s missing after reconcile\n%s", ip, fwmark, out.String())
+		}
+	}
+
+	// The dry-run finds nothing left to change
+	out.Reset()
+	if err := runReconcile(context.Background(), ipt, conf, true, &out); err != nil || out.String() != "summary\t0 to add, 0 to remove, 0 to move\n" {
+		t.Errorf("dry-run after reconcile = %q, %v; want no changes", out.String(), err)
+	}
+}
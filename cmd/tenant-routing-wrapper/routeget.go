@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+)
+
+// lookupFunc performs the kernel route lookup; replaced in tests
+var lookupFunc = route.Lookup
+
+// routeGetCommand implements the standalone invocation:
+//
+//	tenant-routing-wrapper route-get --pod-ip 10.200.1.5 --dst 8.8.8.8 [--fwmark 0x10] [--json]
+//
+// It answers where the pod's traffic to dst actually goes: the kernel lookup runs with
+// the pod's fwmark, taken from its installed MARK rule unless --fwmark is given. A pod
+// without a MARK rule is looked up unmarked.
+//
+// Returns the process exit code.
+func routeGetCommand(ipt iptables.Manager, args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("route-get", flag.ContinueOnError)
+	podIPFlag := fs.String("pod-ip", "", "pod IPv4 address (source of the traffic)")
+	dstFlag := fs.String("dst", "", "destination IPv4 address")
+	fwmarkFlag := fs.String("fwmark", "", "fwmark to look up with (default: from the pod's MARK rule)")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	podIP, dst := net.ParseIP(*podIPFlag), net.ParseIP(*dstFlag)
+	if podIP == nil || dst == nil {
+		fmt.Fprintln(fs.Output(), "route-get: --pod-ip and --dst must be IP addresses")
+		return 2
+	}
+
+	var mark uint32
+	source := "flag"
+	if *fwmarkFlag != "" {
+		m, err := route.ParseFwmark(*fwmarkFlag)
+		if err != nil {
+			fmt.Fprintf(fs.Output(), "route-get: %v\n", err)
+			return 2
+		}
+		mark = m
+	} else {
+		m, found, err := podFwmark(context.Background(), ipt, podIP)
+		if err != nil {
+			routeLog.Errorf("cannot read MARK rules: %v", err)
+			return 1
+		}
+		mark, source = m, "MARK rule"
+		if !found {
+			source = "unmarked"
+		}
+	}
+
+	r, err := lookupFunc(podIP, dst, mark)
+	if err != nil {
+		routeLog.Errorf("%v", err)
+		return 1
+	}
+
+	if *asJSON {
+		out := struct {
+			PodIP        string `json:"podIP"`
+			Destination  string `json:"destination"`
+			Fwmark       string `json:"fwmark"`
+			FwmarkSource string `json:"fwmarkSource"`
+			Table        int    `json:"table"`
+			Gateway      string `json:"gateway,omitempty"`
+			Interface    string `json:"interface"`
+			Source       string `json:"source,omitempty"`
+		}{
+			PodIP:        podIP.String(),
+			Destination:  dst.String(),
+			Fwmark:       fmt.Sprintf("0x%x", mark),
+			FwmarkSource: source,
+			Table:        r.Table,
+			Interface:    r.Interface,
+		}
+		if r.Gateway != nil {
+			out.Gateway = r.Gateway.String()
+		}
+		if r.Source != nil {
+			out.Source = r.Source.String()
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			routeLog.Errorf("%v", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stdout, "%s from %s fwmark 0x%x (%s): %s\n", dst, podIP, mark, source, r)
+	return 0
+}
+
+// podFwmark returns the fwmark of the pod's installed MARK rule
+func podFwmark(ctx context.Context, ipt iptables.Manager, podIP net.IP) (uint32, bool, error) {
+	rules, err := ipt.List(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, rule := range rules {
+		if !podIP.Equal(net.ParseIP(rule.PodIP)) {
+			continue
+		}
+		mark, err := route.ParseFwmark(rule.Fwmark)
+		if err != nil {
+			return 0, false, err
+		}
+		return mark, true, nil
+	}
+	return 0, false, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+)
+
+// useFakeLookup replaces the kernel lookup with one answering per fwmark
+func useFakeLookup(t *testing.T, routes map[uint32]route.EffectiveRoute) {
+	t.Helper()
+	orig := lookupFunc
+	lookupFunc = func(_, _ net.IP, mark uint32) (route.EffectiveRoute, error) {
+		r, ok := routes[mark]
+		if !ok {
+			return route.EffectiveRoute{}, fmt.Errorf("network is unreachable")
+		}
+		return r, nil
+	}
+	t.Cleanup(func() { lookupFunc = orig })
+}
+
+// TestRouteGetCommand verifies the fwmark is taken from the pod's MARK rule unless given
+func TestRouteGetCommand(t *testing.T) {
+	useFakeLookup(t, map[uint32]route.EffectiveRoute{
+		0:    {Table: 254, Gateway: net.ParseIP("10.99.0.1"), Interface: "eth0"},
+		0x10: {Table: 100, Gateway: net.ParseIP("10.10.10.131"), Interface: "eth1"},
+	})
+	ipt := iptables.NewFakeManager()
+	if err := ipt.AddMarkRule(context.Background(), "10.200.1.5", "0x10"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		want       int
+		wantOutput string
+	}{
+		{name: "marked pod", args: []string{"--pod-ip", "10.200.1.5", "--dst", "8.8.8.8"},
+			wantOutput: "8.8.8.8 from 10.200.1.5 fwmark 0x10 (MARK rule): via 10.10.10.131 dev eth1 table 100\n"},
+		{name: "unmarked pod", args: []string{"--pod-ip", "10.200.1.6", "--dst", "8.8.8.8"},
+			wantOutput: "8.8.8.8 from 10.200.1.6 fwmark 0x0 (unmarked): via 10.99.0.1 dev eth0 table 254\n"},
+		{name: "explicit fwmark", args: []string{"--pod-ip", "10.200.1.6", "--dst", "8.8.8.8", "--fwmark", "16"},
+			wantOutput: "8.8.8.8 from 10.200.1.6 fwmark 0x10 (flag): via 10.10.10.131 dev eth1 table 100\n"},
+		{name: "unreachable", args: []string{"--pod-ip", "10.200.1.6", "--dst", "8.8.8.8", "--fwmark", "0x20"}, want: 1},
+		{name: "missing dst", args: []string{"--pod-ip", "10.200.1.5"}, want: 2},
+		{name: "invalid fwmark", args: []string{"--pod-ip", "10.200.1.5", "--dst", "8.8.8.8", "--fwmark", "x"}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			if got := routeGetCommand(ipt, tt.args, &stdout); got != tt.want {
+				t.Fatalf("routeGetCommand() = %d, want %d", got, tt.want)
+			}
+			if stdout.String() != tt.wantOutput {
+				t.Errorf("output = %q, want %q", stdout.String(), tt.wantOutput)
+			}
+		})
+	}
+}
+
+// TestRouteGetCommand_JSON verifies the machine-readable output
+func TestRouteGetCommand_JSON(t *testing.T) {
+	useFakeLookup(t, map[uint32]route.EffectiveRoute{
+		0x10: {Table: 100, Gateway: net.ParseIP("10.10.10.131"), Interface: "eth1"},
+	})
+
+	var stdout bytes.Buffer
+	args := []string{"--pod-ip", "10.200.1.5", "--dst", "8.8.8.8", "--fwmark", "0x10", "--json"}
+	if got := routeGetCommand(iptables.NewFakeManager(), args, &stdout); got != 0 {
+		t.Fatalf("routeGetCommand() = %d, want 0", got)
+	}
+
+	var out map[string]any
+	if err := json.NewDecoder(strings.NewReader(stdout.String())).Decode(&out); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout.String(), err)
+	}
+	if out["table"] != float64(100) || out["gateway"] != "10.10.10.131" || out["interface"] != "eth1" || out["fwmark"] != "0x10" {
+		t.Errorf("unexpected JSON output: %v", out)
+	}
+}
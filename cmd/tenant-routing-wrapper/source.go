@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agentclient"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// annotationSource resolves the routing annotations of pods and the strict-mode
+// override of namespaces: the node agent, or the API server
+type annotationSource interface {
+	RoutingAnnotations(ctx context.Context, podName, podNamespace, podUID string) (tenant.RoutingAnnotations, error)
+	StrictOverride(ctx context.Context, namespace string) (*bool, error)
+}
+
+// newAnnotationSource returns the node agent if agentSocket is set, else an API client,
+// or the static tenants if there is no kubeconfig either
+// cache is consulted by the API client only; the agent keeps its own informer cache.
+func newAnnotationSource(conf *config.PluginConf, cache *tenant.AnnotationCache) (annotationSource, error) {
+	if conf.AgentSocket != "" {
+		return &agentSource{conf: conf, client: agentclient.New(conf.AgentSocket), cache: cache,
+			strict: map[string]*bool{}}, nil
+	}
+	return newClusterSource(conf, cache)
+}
+
+// newClusterSource returns an API client, or the static tenants without a kubeconfig
+func newClusterSource(conf *config.PluginConf, cache *tenant.AnnotationCache) (annotationSource, error) {
+	if conf.Kubeconfig == "" {
+		return staticSource{}, nil
+	}
+	return newAPISource(conf, cache)
+}
+
+// agentSource asks tenant-routingd and falls back to the API server while it is unreachable
+// Pods are resolved with ResolveTenant, which reads the annotation keys of the agent's
+// configuration and answers the strict-mode override of the namespace along.
+type agentSource struct {
+	conf   *config.PluginConf
+	client *agentclient.Client
+	cache  *tenant.AnnotationCache
+	api    annotationSource
+
+	// strict holds the overrides ResolveTenant answered, by namespace
+	strict map[string]*bool
+}
+
+func (s *agentSource) RoutingAnnotations(ctx context.Context, podName, podNamespace, podUID string) (tenant.RoutingAnnotations,
+	error) {
+	agentCtx, cancel := context.WithTimeout(ctx, k8sTimeout(s.conf))
+	defer cancel()
+
+	annotations, strict, err := s.client.ResolveTenant(agentCtx, podName, podNamespace, podUID)
+	if err == nil {
+		s.strict[podNamespace] = strict
+	}
+	if !errors.Is(err, agentclient.ErrUnavailable) {
+		return annotations, err
+	}
+	api, apiErr := s.fallback(err)
+	if apiErr != nil {
+		return tenant.RoutingAnnotations{}, apiErr
+	}
+	return api.RoutingAnnotations(ctx, podName, podNamespace, podUID)
+}
+
+func (s *agentSource) StrictOverride(ctx context.Context, namespace string) (*bool, error) {
+	if strict, ok := s.strict[namespace]; ok {
+		return strict, nil
+	}
+	agentCtx, cancel := context.WithTimeout(ctx, k8sTimeout(s.conf))
+	defer cancel()
+
+	strict, err := s.client.StrictOverride(agentCtx, namespace)
+	if !errors.Is(err, agentclient.ErrUnavailable) {
+		return strict, err
+	}
+	api, apiErr := s.fallback(err)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return api.StrictOverride(ctx, namespace)
+}
+
+// fallback returns the API client (or the static tenants) used while the agent is
+// unreachable (agentErr)
+func (s *agentSource) fallback(agentErr error) (annotationSource, error) {
+	if s.api == nil {
+		if s.conf.Kubeconfig == "" {
+			k8sLog.Warnf("falling back to the configured tenants: %v", agentErr)
+		} else {
+			k8sLog.Warnf("falling back to the API server: %v", agentErr)
+		}
+		api, err := newClusterSource(s.conf, s.cache)
+		if err != nil {
+			return nil, errors.Join(agentErr, err)
+		}
+		s.api = api
+	}
+	return s.api, nil
+}
+
+// staticSource answers from the static tenants alone, for clusters run without the
+// API server (see config.PluginConf.Tenants); pods of other namespaces have no tenant
+type staticSource struct{}
+
+func (staticSource) RoutingAnnotations(_ context.Context, _, podNamespace, _ string) (tenant.RoutingAnnotations, error) {
+	annotations, _, err := tenant.StaticRoutingAnnotations(podNamespace)
+	return annotations, err
+}
+
+// StrictOverride is always nil: only the configuration sets strict mode
+func (staticSource) StrictOverride(context.Context, string) (*bool, error) {
+	return nil, nil
+}
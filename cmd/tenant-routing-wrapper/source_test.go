@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agent"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// stubResolver answers every pod with the same annotations and counts strict lookups
+type stubResolver struct {
+	annotations   k8s.RoutingAnnotations
+	strictLookups *int
+}
+
+func (s stubResolver) RoutingAnnotations(_ context.Context, _, _ string, _ []string, _ string, _ time.Duration) (k8s.RoutingAnnotations, error) {
+	return s.annotations, nil
+}
+
+func (s stubResolver) StrictOverride(context.Context, string, time.Duration) (*bool, error) {
+	*s.strictLookups++
+	strict := true
+	return &strict, nil
+}
+
+// TestAgentSource verifies the agent answers without a kubeconfig and an unreachable
+// agent falls back to the API server
+func TestAgentSource(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	conf := &config.PluginConf{Kubeconfig: "/nonexistent/kubeconfig", AgentSocket: socket,
+		AnnotationKey: config.AnnotationKeys{"tenant.routing/fwmark"}}
+
+	src, err := newAnnotationSource(conf, nil)
+	if err != nil {
+		t.Fatalf("newAnnotationSource() error = %v", err)
+	}
+	if _, err := src.RoutingAnnotations(context.Background(), "web", "team-a", ""); err == nil ||
+		!strings.Contains(err.Error(), apiFallbackError) {
+		t.Errorf("lookup without agent error = %v, want the API fallback error", err)
+	}
+
+	listener, err := agent.Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var strictLookups int
+	resolver := stubResolver{annotations: k8s.RoutingAnnotations{Fwmark: "0x10"}, strictLookups: &strictLookups}
+	server, err := agent.NewServer(resolver, time.Second, agent.Options{FwmarkKeys: conf.AnnotationKey}).RPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(context.Background(), listener)
+	defer server.Close()
+
+	src, _ = newAnnotationSource(conf, nil)
+	annotations, err := src.RoutingAnnotations(context.Background(), "web", "team-a", "")
+	if err != nil || annotations.Fwmark != "0x10" {
+		t.Errorf("RoutingAnnotations() = %+v, %v; want fwmark 0x10 from the agent", annotations, err)
+	}
+	// ResolveTenant answered the strict mode of the namespace along
+	if strict, err := src.StrictOverride(context.Background(), "team-a"); err != nil || strict == nil || !*strict || strictLookups != 1 {
+		t.Errorf("StrictOverride() = %v, %v after %d agent lookups; want true from the resolve", strict, err, strictLookups)
+	}
+}
+
+// TestStaticSource verifies a configuration without kubeconfig resolves pods from its
+// tenants alone, also when the agent is unreachable
+func TestStaticSource(t *testing.T) {
+	k8s.SetStaticTenants(map[string]k8s.StaticTenant{"team-a": {Fwmark: "0x10", Gateway: "10.10.10.131"}})
+	t.Cleanup(func() { k8s.SetStaticTenants(nil) })
+
+	for _, conf := range []*config.PluginConf{
+		{},
+		{AgentSocket: filepath.Join(t.TempDir(), "agent.sock")},
+	} {
+		src, err := newAnnotationSource(conf, nil)
+		if err != nil {
+			t.Fatalf("newAnnotationSource() error = %v", err)
+		}
+		annotations, err := src.RoutingAnnotations(context.Background(), "web", "team-a", "")
+		if err != nil || annotations.Fwmark != "0x10" || annotations.Gateway != "10.10.10.131" ||
+			annotations.Source != k8s.TenantSourceStatic {
+			t.Errorf("RoutingAnnotations() = %+v, %v; want the static tenant of team-a", annotations, err)
+		}
+		if annotations, err := src.RoutingAnnotations(context.Background(), "web", "team-b", ""); err != nil ||
+			annotations.Fwmark != "" {
+			t.Errorf("RoutingAnnotations() of an unlisted namespace = %+v, %v; want no tenant", annotations, err)
+		}
+		if strict, err := src.StrictOverride(context.Background(), "team-a"); strict != nil || err != nil {
+			t.Errorf("StrictOverride() = %v, %v; want none", strict, err)
+		}
+	}
+}
+
+// TestAgentAttachments verifies DEL removes rules from the agent's copy of a record
+// whose state file is gone
+func TestAgentAttachments(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := agent.Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := agent.NewServer(stubResolver{}, time.Second, agent.Options{}).RPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(context.Background(), listener)
+	defer server.Close()
+
+	conf := &config.PluginConf{StateDir: t.TempDir(), AgentSocket: socket}
+	conf.Name = "tenant-net"
+	args := &skel.CmdArgs{ContainerID: "c1", IfName: "eth0"}
+	saveState(args, conf, "team-a", "web", "", "10.0.0.5", k8s.RoutingAnnotations{Fwmark: "0x10"})
+	if err := state.New(conf.StateDir).Delete(conf.Name, "c1", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := releaseAttachment(conf, conf.Name, "c1", "eth0")
+	if rec == nil || rec.Fwmark != "0x10" || rec.PodIP() != "10.0.0.5" || rec.Pod != "web" {
+		t.Fatalf("releaseAttachment() = %+v, want the record ADD saved", rec)
+	}
+	if rec := releaseAttachment(conf, conf.Name, "c1", "eth0"); rec != nil {
+		t.Errorf("second releaseAttachment() = %+v, want nil", rec)
+	}
+}
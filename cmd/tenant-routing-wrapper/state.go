@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agentclient"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/cri"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// saveState records what ADD resolved for the attachment, and reports it to the node agent
+// Failures, including a read-only stateDir, are logged only: DEL then falls back to the
+// agent or the Kubernetes API lookup
+func saveState(args *skel.CmdArgs, conf *config.PluginConf, podNamespace, podName, podUID, podIP string,
+	annotations tenant.RoutingAnnotations) {
+	rec := &state.Record{
+		Network:     conf.Name,
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		Namespace:   podNamespace,
+		Pod:         podName,
+		PodUID:      podUID,
+		IPs:         []string{podIP},
+		Fwmark:      annotations.Fwmark,
+		Gateway:     annotations.Gateway,
+		Chain:       iptables.MarkChain,
+		ConfigHash:  conf.Fingerprint(),
+		Created:     time.Now().UTC(),
+	}
+	if err := state.New(conf.StateDir).Save(rec); errors.Is(err, state.ErrReadOnly) {
+		cniLog.Warnf("state of pod %s/%s not persisted (%v): DEL relies on the node agent or the API lookup; "+
+			"set stateDir to a writable directory", podNamespace, podName, err)
+	} else if err != nil {
+		cniLog.Warnf("failed to save state for pod %s/%s: %v", podNamespace, podName, err)
+	}
+	recordAttachment(conf, rec)
+}
+
+// loadState returns the record ADD wrote for the attachment, or nil if there is none
+func loadState(args *skel.CmdArgs, conf *config.PluginConf) *state.Record {
+	rec, err := state.New(conf.StateDir).Load(conf.Name, args.ContainerID, args.IfName)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		cniLog.Warnf("ignoring state of container %s: %v", args.ContainerID, err)
+		return nil
+	}
+	return rec
+}
+
+// deleteState removes the record of the attachment and the agent's copy, and ends its
+// ADD attempt; failures are logged only
+func deleteState(args *skel.CmdArgs, conf *config.PluginConf) {
+	store := state.New(conf.StateDir)
+	if err := store.Delete(conf.Name, args.ContainerID, args.IfName); err != nil {
+		cniLog.Warnf("failed to delete state of container %s: %v", args.ContainerID, err)
+	}
+	if err := store.EndAttempt(conf.Name, args.ContainerID, args.IfName); err != nil {
+		cniLog.Debugf("ADD attempt of container %s not ended: %v", args.ContainerID, err)
+	}
+	releaseAttachment(conf, conf.Name, args.ContainerID, args.IfName)
+}
+
+// attempt is the ADD attempt of the invocation; nil outside ADD, where every effect is recorded
+var attempt *addAttempt
+
+// addAttempt records the effects of an ADD attempt once, whatever the number of retries
+// (see state.Attempt)
+type addAttempt struct {
+	store                        *state.Store
+	network, containerID, ifName string
+
+	// key is the idempotency key of the attempt (see state.IdempotencyKey)
+	key string
+}
+
+// startAttempt returns the ADD attempt of the attachment, or nil if the store cannot
+// keep it (effects are then recorded on every retry)
+func startAttempt(args *skel.CmdArgs, conf *config.PluginConf) *addAttempt {
+	store := state.New(conf.StateDir)
+	a, err := store.StartAttempt(conf.Name, args.ContainerID, args.IfName)
+	if err != nil {
+		cniLog.Debugf("ADD effects of container %s not deduplicated: %v", args.ContainerID, err)
+		return nil
+	}
+	if len(a.Effects) > 0 {
+		cniLog.Infof("retried ADD of container %s (attempt %s): recorded effects are not repeated",
+			args.ContainerID, a.ID)
+	}
+	return &addAttempt{store: store, network: conf.Name, containerID: args.ContainerID, ifName: args.IfName,
+		key: state.IdempotencyKey(args.ContainerID, args.IfName, a.ID)}
+}
+
+// once reports whether effect is still to be recorded for the attempt, and marks it
+// recorded; always true for a nil attempt or if the store fails
+func (a *addAttempt) once(effect string) bool {
+	if a == nil {
+		return true
+	}
+	first, err := a.store.Once(a.network, a.containerID, a.ifName, effect)
+	if err != nil {
+		cniLog.Debugf("recording %s although ADD attempt %s is unknown: %v", effect, a.key, err)
+		return true
+	}
+	return first
+}
+
+// auditf writes an AUDIT log entry, once per ADD attempt (tagged with its key)
+func auditf(format string, args ...any) {
+	if attempt == nil {
+		cniLog.Auditf(format, args...)
+		return
+	}
+	entry := fmt.Sprintf(format, args...)
+	if attempt.once("audit/" + entry) {
+		cniLog.Auditf("%s (key=%s)", entry, attempt.key)
+	}
+}
+
+// recordAttachment reports rec to the node agent, if agentSocket is set
+// Failures are logged only: the agent is seeded from the state records when it starts.
+func recordAttachment(conf *config.PluginConf, rec *state.Record) {
+	if conf.AgentSocket == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k8sTimeout(conf))
+	defer cancel()
+	if err := agentclient.New(conf.AgentSocket).RecordAttachment(ctx, rec); err != nil {
+		cniLog.Debugf("node agent did not record attachment %s/%s: %v", rec.ContainerID, rec.IfName, err)
+	}
+}
+
+// releaseAttachment reports the removal of an attachment to the node agent and
+// returns the agent's copy of its record, or nil if there is none
+func releaseAttachment(conf *config.PluginConf, network, containerID, ifName string) *state.Record {
+	if conf.AgentSocket == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k8sTimeout(conf))
+	defer cancel()
+	rec, err := agentclient.New(conf.AgentSocket).ReleaseAttachment(ctx, network, containerID, ifName)
+	if err != nil {
+		cniLog.Debugf("node agent did not release attachment %s/%s: %v", containerID, ifName, err)
+	}
+	return rec
+}
+
+// historyRetention is how long GC keeps the assignment history of a pod after its last change
+const historyRetention = 90 * 24 * time.Hour
+
+// recordAssignment appends a to the assignment history of namespace/pod
+// Failures are logged only: the history is for audits, routing does not depend on it.
+func recordAssignment(conf *config.PluginConf, namespace, pod string, a state.Assignment) {
+	if namespace == "" || pod == "" {
+		return
+	}
+	if a.Time.IsZero() {
+		a.Time = time.Now().UTC()
+	}
+	if attempt != nil {
+		a.Key = attempt.key
+		if !attempt.once(fmt.Sprintf("history/%s/%s/%s/%s", a.Cause, a.Fwmark, a.Gateway, a.IP)) {
+			return
+		}
+	}
+	if err := state.New(conf.StateDir).AppendHistory(conf.Name, namespace, pod, a); err != nil {
+		cniLog.Warnf("failed to record %s of pod %s/%s in its history: %v", a.Cause, namespace, pod, err)
+	}
+}
+
+// recordDeletion records the end of the pod's routing by DEL in its assignment history
+func recordDeletion(args *skel.CmdArgs, conf *config.PluginConf, rec *state.Record) {
+	recordAssignment(conf, rec.Namespace, rec.Pod, state.Assignment{
+		Cause: state.CauseDelete, PodUID: rec.PodUID, ContainerID: args.ContainerID, IP: rec.PodIP(),
+	})
+}
+
+// annotationCache returns the annotation cache of conf, or nil if annotationCacheTTL and
+// negativeAnnotationCacheTTL are 0
+func annotationCache(conf *config.PluginConf) *tenant.AnnotationCache {
+	return tenant.NewAnnotationCache(filepath.Join(conf.StateDir, tenant.AnnotationCacheDir),
+		time.Duration(conf.AnnotationCacheTTL)*time.Second, time.Duration(conf.NegativeAnnotationCacheTTL)*time.Second)
+}
+
+// pruneState removes the rules and records of attachments the runtime no longer
+// considers valid (caller holds the node lock)
+// A record whose rules cannot be removed is kept for the next GC. Rules of a pod IP a
+// valid attachment holds again are left alone: they belong to the new pod.
+func pruneState(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf) {
+	valid := map[string]bool{}
+	for _, a := range conf.ValidAttachments {
+		valid[a.ContainerID+"/"+a.IfName] = true
+	}
+
+	store := state.New(conf.StateDir)
+	records, err := store.List(conf.Name)
+	if err != nil {
+		gcLog.Warnf("GC cannot read all state records: %v", err)
+	}
+	reused := map[string]bool{}
+	for _, rec := range records {
+		if valid[rec.ContainerID+"/"+rec.IfName] && rec.PodIP() != "" {
+			reused[rec.PodIP()] = true
+		}
+	}
+	for _, rec := range records {
+		if valid[rec.ContainerID+"/"+rec.IfName] || time.Since(rec.Created) < cri.StartupGrace {
+			continue
+		}
+		if rec.Fwmark != "" && rec.PodIP() != "" && !reused[rec.PodIP()] &&
+			!removePodRules(ctx, ipt, conf, rec.Namespace, rec.Pod, rec.PodIP(), rec.Fwmark, rec.Gateway) {
+			continue
+		}
+		if err := store.Delete(rec.Network, rec.ContainerID, rec.IfName); err != nil {
+			gcLog.Warnf("%v", err)
+			continue
+		}
+		releaseAttachment(conf, rec.Network, rec.ContainerID, rec.IfName)
+		recordAssignment(conf, rec.Namespace, rec.Pod, state.Assignment{
+			Cause: state.CauseGC, PodUID: rec.PodUID, ContainerID: rec.ContainerID, IP: rec.PodIP(),
+		})
+		gcLog.Infof("GC removed rules and state of stale attachment %s/%s (pod %s/%s)",
+			rec.ContainerID, rec.IfName, rec.Namespace, rec.Pod)
+	}
+
+	removed, err := store.PruneAttempts(conf.Name, func(containerID, ifName string) bool {
+		return valid[containerID+"/"+ifName]
+	}, cri.StartupGrace)
+	if err != nil {
+		gcLog.Warnf("GC cannot prune ADD attempts: %v", err)
+	} else if removed > 0 {
+		gcLog.Debugf("GC removed %d ADD attempts of stale attachments", removed)
+	}
+}
+
+// pruneCaches removes assignment histories past their retention and expired
+// annotation cache entries; neither needs the node lock
+func pruneCaches(conf *config.PluginConf) {
+	store := state.New(conf.StateDir)
+	if removed, err := store.PruneHistory(conf.Name, historyRetention); err != nil {
+		gcLog.Warnf("GC cannot prune assignment histories: %v", err)
+	} else if removed > 0 {
+		gcLog.Debugf("GC removed %d assignment histories older than %s", removed, historyRetention)
+	}
+
+	if removed, err := annotationCache(conf).Prune(); err != nil {
+		gcLog.Warnf("GC cannot prune the annotation cache: %v", err)
+	} else if removed > 0 {
+		gcLog.Debugf("GC removed %d expired annotation cache entries", removed)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/cri"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestAttempt_RetriedADD verifies a retried ADD records its history and metrics once,
+// and that the ADD after DEL records them again
+func TestAttempt_RetriedADD(t *testing.T) {
+	dir := t.TempDir()
+	conf := &config.PluginConf{StateDir: filepath.Join(dir, "state"), MetricsFile: filepath.Join(dir, "metrics.prom")}
+	conf.Name = "test-network"
+	args := &skel.CmdArgs{ContainerID: "abc123", IfName: "eth0"}
+	t.Cleanup(func() { attempt = nil })
+
+	add := func() {
+		attempt = startAttempt(args, conf)
+		if attempt == nil {
+			t.Fatal("startAttempt() = nil")
+		}
+		recordAssignment(conf, "team-a", "web", state.Assignment{Cause: state.CauseAdd, Fwmark: "0x10", IP: "10.0.0.5"})
+		recordSkip(conf, reason.NoAnnotation)
+	}
+	add()
+	key := attempt.key
+	add() // kubelet retries after its timeout
+	if attempt.key != key {
+		t.Errorf("retried ADD got key %s, want %s", attempt.key, key)
+	}
+
+	history, err := state.New(conf.StateDir).History(conf.Name, "team-a", "web")
+	if err != nil || len(history) != 1 || history[0].Key != key {
+		t.Fatalf("History() after a retried ADD = %+v, %v; want one assignment with key %s", history, err, key)
+	}
+	if got := skips(t, conf.MetricsFile); got != "1" {
+		t.Errorf("skips after a retried ADD = %s, want 1", got)
+	}
+
+	deleteState(args, conf)
+	add()
+	if attempt.key == key {
+		t.Errorf("ADD after DEL reused key %s", key)
+	}
+	if history, _ := state.New(conf.StateDir).History(conf.Name, "team-a", "web"); len(history) != 2 {
+		t.Errorf("History() after a new ADD = %+v, want 2 assignments", history)
+	}
+	if got := skips(t, conf.MetricsFile); got != "2" {
+		t.Errorf("skips after a new ADD = %s, want 2", got)
+	}
+}
+
+// skips returns the value of the NO_ANNOTATION skip counter in the metrics file
+func skips(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, `tenant_routing_skips_total{reason="NO_ANNOTATION"} `); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// TestPruneState verifies GC removes the rules of stale attachments with their records,
+// keeps a record whose rules cannot be removed and leaves rules of a reused pod IP alone
+func TestPruneState(t *testing.T) {
+	conf := &config.PluginConf{StateDir: t.TempDir()}
+	conf.Name = "test-network"
+	conf.ValidAttachments = []types.GCAttachment{{ContainerID: "live", IfName: "eth0"}}
+	store := state.New(conf.StateDir)
+	old := time.Now().Add(-cri.StartupGrace)
+	for _, rec := range []*state.Record{
+		{ContainerID: "live", IPs: []string{"10.0.0.5"}, Fwmark: "0x10"},
+		{ContainerID: "stale", IPs: []string{"10.0.0.6"}, Fwmark: "0x10"},
+		{ContainerID: "reused", IPs: []string{"10.0.0.5"}, Fwmark: "0x10"},
+	} {
+		rec.Network, rec.IfName, rec.Created = conf.Name, "eth0", old
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ipt := iptables.NewFakeManager()
+	ctx := context.Background()
+	for _, ip := range []string{"10.0.0.5", "10.0.0.6"} {
+		if err := ipt.AddMarkRule(ctx, ip, "0x10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ipt.Err = errors.New("xtables lock held")
+	pruneState(ctx, ipt, conf)
+	if _, err := store.Load(conf.Name, "stale", "eth0"); err != nil {
+		t.Errorf("record of a stale attachment whose rules remain: %v, want kept", err)
+	}
+
+	ipt.Err = nil
+	pruneState(ctx, ipt, conf)
+	for ip, want := range map[string]bool{"10.0.0.5": true, "10.0.0.6": false} {
+		if exists, _ := ipt.RuleExists(ctx, ip, "0x10"); exists != want {
+			t.Errorf("rule of %s exists = %v, want %v", ip, exists, want)
+		}
+	}
+	records, err := store.List(conf.Name)
+	if err != nil || len(records) != 1 || records[0].ContainerID != "live" {
+		t.Errorf("records after GC = %+v, %v; want only the live one", records, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+)
+
+// STATUS error codes defined by CNI spec 1.1 (not exported by libcni)
+const (
+	// errPluginNotAvailable: the plugin cannot service ADD requests
+	errPluginNotAvailable uint = 50
+
+	// errLimitedConnectivity: not available, and existing containers may have limited connectivity
+	errLimitedConnectivity uint = 51
+)
+
+// newClientFunc creates the Kubernetes client; replaced in tests
+var newClientFunc = loadKubeconfig
+
+// cmdStatus handles CNI STATUS command (CNI spec 1.1)
+// Called by the runtime to learn whether the plugin is ready to serve ADDs
+//
+// Checks, in order:
+// 1. The configuration parses and validates
+// 2. iptables is usable (listing the managed MARK rules succeeds)
+// 3. The kubeconfig loads, if one is configured
+// 4. The delegate answers STATUS (or VERSION if it predates STATUS); not checked when chained
+//
+// Every failure is returned as a *types.Error with a spec error code; a delegate
+// reporting 50/51 keeps its own code. A ready plugin publishes its configuration
+// fingerprint (see publishConfigHash), so nodes converge without waiting for an ADD.
+func cmdStatus(args *skel.CmdArgs, ipt iptables.Manager) error {
+	pluginConf, err := config.ParseConfig(args.StdinData)
+	if err != nil {
+		return types.NewError(types.ErrInvalidNetworkConfig, "invalid configuration", err.Error())
+	}
+	defer setupLogging(pluginConf)()
+	setupK8s(pluginConf)
+	setupDelegate(pluginConf)
+	ctx, cancel := invocationContext(pluginConf)
+	defer cancel()
+
+	if _, err := ipt.List(ctx); err != nil {
+		return types.NewError(errPluginNotAvailable, "iptables is not usable", err.Error())
+	}
+
+	// Without a kubeconfig the static tenants need no API server
+	if pluginConf.Kubeconfig != "" {
+		if err := newClientFunc(pluginConf.Kubeconfig); err != nil {
+			return types.NewError(errPluginNotAvailable, "kubeconfig cannot be loaded", err.Error())
+		}
+	}
+
+	// The runtime asks every plugin of a conflist itself
+	if !pluginConf.Chained() {
+		if err := delegate.DelegateStatus(ctx, pluginConf.Delegate, pluginConf.Name, args.StdinData); err != nil {
+			var cniErr *types.Error
+			if errors.As(err, &cniErr) && (cniErr.Code == errPluginNotAvailable || cniErr.Code == errLimitedConnectivity) {
+				return types.NewError(cniErr.Code, "delegate plugin is not available", err.Error())
+			}
+			return types.NewError(errPluginNotAvailable, "delegate plugin is not available", err.Error())
+		}
+	}
+
+	publishConfigHash(ctx, pluginConf)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+)
+
+// useClientResult makes Kubernetes client creation return err
+func useClientResult(t *testing.T, err error) {
+	t.Helper()
+	orig := newClientFunc
+	newClientFunc = func(string) error { return err }
+	t.Cleanup(func() { newClientFunc = orig })
+}
+
+// TestCmdStatus verifies each readiness check maps to the spec error code
+func TestCmdStatus(t *testing.T) {
+	dir := t.TempDir()
+	stub := func(versions string) string {
+		return "#!/bin/sh\n" +
+			"if [ \"$CNI_COMMAND\" = VERSION ]; then echo '{\"cniVersion\":\"1.0.0\",\"supportedVersions\":" + versions + "}'; fi\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ptp"), []byte(stub(`["1.0.0","1.1.0"]`)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bridge"), []byte(stub(`["0.3.1","0.4.0"]`)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+
+	config := func(delegateType string) []byte {
+		return []byte(`{"cniVersion":"1.1.0","name":"tenant-net","type":"tenant-routing-wrapper",
+			"kubeconfig":"/etc/cni/net.d/kubeconfig","delegate":{"type":"` + delegateType + `"}}`)
+	}
+
+	tests := []struct {
+		name      string
+		stdin     []byte
+		iptErr    error
+		clientErr error
+		wantCode  uint
+	}{
+		{name: "ready", stdin: config("ptp")},
+		{name: "invalid config", stdin: []byte(`{invalid json}`), wantCode: types.ErrInvalidNetworkConfig},
+		{name: "iptables broken", stdin: config("ptp"), iptErr: fmt.Errorf("xtables lock"), wantCode: errPluginNotAvailable},
+		{name: "kubeconfig broken", stdin: config("ptp"), clientErr: fmt.Errorf("no such file"), wantCode: errPluginNotAvailable},
+		{name: "delegate missing", stdin: config("macvlan"), wantCode: errPluginNotAvailable},
+		{name: "delegate too old", stdin: config("bridge"), wantCode: errPluginNotAvailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useClientResult(t, tt.clientErr)
+			ipt := iptables.NewFakeManager()
+			ipt.Err = tt.iptErr
+
+			err := cmdStatus(&skel.CmdArgs{StdinData: tt.stdin}, ipt)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("cmdStatus() error = %v", err)
+				}
+				return
+			}
+
+			var cniErr *types.Error
+			if !errors.As(err, &cniErr) || cniErr.Code != tt.wantCode {
+				t.Errorf("cmdStatus() error = %v, want CNI error code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
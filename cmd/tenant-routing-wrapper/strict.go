@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/result"
+)
+
+// setupFailed handles a failed routing setup step of a pod
+// A nil return means setup goes on without the step (permissive mode); an error
+// aborts setup and fails the ADD (strict mode) with the CNI error code of code.
+type setupFailed func(code reason.Code, format string, args ...interface{}) error
+
+// permissive logs and counts every failure as a skip
+func permissive(conf *config.PluginConf) setupFailed {
+	return func(code reason.Code, format string, args ...interface{}) error {
+		observeFailure(code)
+		skipped(conf, code, format, args...)
+		return nil
+	}
+}
+
+// failurePolicy returns the failure handler for an ADD in podNamespace
+// Strictness is only resolved on the first failure, so the happy path costs no
+// extra API call (see strictMode). src is nil if the API is unreachable.
+func failurePolicy(ctx context.Context, conf *config.PluginConf, src annotationSource, podNamespace string) setupFailed {
+	var strict *bool
+	return func(code reason.Code, format string, args ...interface{}) error {
+		observeFailure(code)
+		if strict == nil {
+			s := strictMode(ctx, conf, src, podNamespace)
+			strict = &s
+		}
+		if !*strict {
+			skipped(conf, code, format, args...)
+			return nil
+		}
+		return &setupError{code: code, err: fmt.Errorf("strict mode: "+format+" (reason=%s)", append(args, code)...)}
+	}
+}
+
+// strictMode resolves whether routing setup failures fail the ADD
+// The namespace annotation (api.StrictAnnotationKey) overrides the config; if the
+// namespace cannot be read, the config decides.
+func strictMode(ctx context.Context, conf *config.PluginConf, src annotationSource, podNamespace string) bool {
+	if src == nil {
+		return conf.Strict
+	}
+	override, err := src.StrictOverride(ctx, podNamespace)
+	if err != nil {
+		cniLog.Warnf("using configured strict=%t: %v", conf.Strict, err)
+		return conf.Strict
+	}
+	if override == nil {
+		return conf.Strict
+	}
+	return *override
+}
+
+// rollbackAdd undoes a delegated ADD that is about to fail and returns cause
+// The delegate gets DEL with its own result as prevResult, so it can remove the
+// interface and release the IPAM lease; the pod's state record is removed too.
+// Rules the wrapper installed must be removed by the caller first.
+// As a chained plugin there is nothing to undo here: the runtime calls DEL on the
+// whole conflist when the ADD fails. The DEL runs even if ctx is done already.
+func rollbackAdd(ctx context.Context, args *skel.CmdArgs, conf *config.PluginConf, delegateResult types.Result,
+	cause error) error {
+	if conf.Chained() {
+		deleteState(args, conf)
+		return cause
+	}
+	stdin, err := withPrevResult(args.StdinData, delegateResult, conf.CNIVersion)
+	if err == nil {
+		start := time.Now()
+		err = delegate.DelegateDel(context.WithoutCancel(ctx), conf.Delegate, conf.Name, stdin)
+		observeDelegate(start)
+	}
+	deleteState(args, conf)
+
+	if err != nil {
+		delegateLog.Errorf("rollback of delegate ADD failed, interface and IP may leak until DEL: %v", err)
+		// Not wrapped: the ADD failed for cause, a delegate error of the DEL must not
+		// become its CNI error code (see cniError)
+		return errors.Join(cause, fmt.Errorf("rollback failed: %v", err))
+	}
+	delegateLog.Infof("rolled back delegate ADD for container %s: %v", args.ContainerID, cause)
+	return cause
+}
+
+// withPrevResult returns stdin with prevResult set to res (converted to cniVersion)
+func withPrevResult(stdin []byte, res types.Result, cniVersion string) ([]byte, error) {
+	var conf map[string]any
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if cniVersion != "" {
+		converted, err := result.ConvertResult(res, cniVersion)
+		if err != nil {
+			return nil, fmt.Errorf("delegate result: %w", err)
+		}
+		res = converted
+	}
+	conf["prevResult"] = res
+	return json.Marshal(conf)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+)
+
+// defaultAPICheckInterval is how often the Kubernetes client is health-checked by default
+const defaultAPICheckInterval = time.Minute
+
+// metricsFile is the metrics file of the current configuration ("" if disabled); see
+// applySettings
+var metricsFile atomic.Value
+
+// observeRebuild logs a rebuild of the Kubernetes client and records it in the metrics
+// file, if any (see k8s.ClientManager)
+func observeRebuild(reason string, err error) {
+	if err != nil {
+		log.Warnf("Kubernetes client not rebuilt (%s), keeping the previous one: %v", reason, err)
+	} else {
+		log.Infof("Kubernetes client rebuilt from the kubeconfig (%s)", reason)
+	}
+
+	path, _ := metricsFile.Load().(string)
+	if path == "" {
+		return
+	}
+	recorder, recErr := metrics.NewRecorder(path)
+	if recErr == nil {
+		recErr = recorder.ObserveClientRebuild(reason, err)
+	}
+	if recErr != nil {
+		log.Warnf("client rebuild metrics not recorded: %v", recErr)
+	}
+}
+
+// checkLoop health-checks the Kubernetes client every interval until ctx is done; a
+// failed check rebuilds it (see k8s.ClientManager.Check)
+func checkLoop(ctx context.Context, clients *k8s.ClientManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check, cancel := context.WithTimeout(ctx, k8s.K8sAPITimeout)
+			if err := clients.Check(check); err != nil {
+				log.Warnf("%v", err)
+			}
+			cancel()
+		}
+	}
+}
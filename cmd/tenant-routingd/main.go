@@ -0,0 +1,453 @@
+// Package main implements tenant-routingd, the node agent of the tenant routing plugin.
+//
+// The agent watches the pods of its node and the namespaces through informers and
+// answers the plugin's annotation lookups over ttrpc on a unix socket (see pkg/agent).
+// With agentSocket set in the wrapper configuration, CNI invocations query the agent
+// instead of loading the kubeconfig and calling the API server themselves. Tools use
+// the HTTP API (api/openapi.yaml) on --api-socket:
+//
+//	tenant-routingd --conflist /etc/cni/net.d/10-tenant-routing.conflist [--node NAME] [--socket PATH]
+//		[--api-socket PATH]
+//		[--reconcile-interval 1m] [--reload-interval 30s] [--require-confirmation]
+//		[--namespace-selector tenant.routing/managed=true] [--release-terminated [--terminated-grace 1m]]
+//		[--reconcile-sample 50 [--full-reconcile-interval 15m]] [--api-check-interval 1m]
+//		[--status-interval 1m]
+//
+// The agent reads kubeconfig, agentSocket, the annotation keys, stateDir and the
+// logging settings from the same conflist as the plugin. Its table of attachments
+// starts from the plugin's state records. It runs until SIGINT/SIGTERM.
+//
+// The kubeconfig is loaded once: the informers and the lookups forwarded by CNI
+// invocations share one client (see k8s.ClientManager). It is rebuilt from the
+// kubeconfig when the API server answers 401, e.g. after a token rotation, and when
+// the health check run every --api-check-interval (default 1m, 0 disables) fails.
+// Rebuilds are logged and counted in the metrics file.
+//
+// Namespaces are listed once and watched, all of them unless --namespace-selector
+// narrows the watch with a label selector; a namespace outside it is fetched on its
+// first lookup and kept for the --resync period.
+//
+// When the fwmark or gateway annotation of a running pod or its namespace changes,
+// the agent moves the pod's rules to it right away (see reconcile.Relabel): a pod
+// annotated after ADD is marked, a pod whose annotation was removed is unmarked.
+// The entries the plugin cached for the pod or namespace under stateDir (see
+// annotationCacheTTL and negativeAnnotationCacheTTL) are dropped as well, so a CNI
+// invocation falling back to the API server does not answer the old annotations.
+//
+// Every --reconcile-interval (default 1m, 0 disables) the agent re-adds rules and
+// routes of recorded pods that were deleted behind the plugin's back, for example by
+// a firewalld reload, and applies annotation changes a failed pass left behind.
+// Verifying the rules of thousands of pods every interval is expensive: with
+// --reconcile-sample N a pass verifies N random pods, the pods added since the last
+// pass and those whose annotations changed, and every pod only every
+// --full-reconcile-interval (default 15m) and after a reload.
+//
+// With --release-terminated the same pass removes the rules of pods that reached phase
+// Succeeded or Failed at least --terminated-grace ago (see reconcile.ReleaseTerminated),
+// so completed Job pods do not hold rules until the kubelet sends DEL.
+//
+// Every --status-interval (default 1m, 0 disables) the agent reports the pods of each
+// tenant attached on its node, and how many of them its last full pass verified and
+// found drifted, in the status of the tenant's TenantRoute (see k8s.ReportTenantStatus).
+// The status sums the reports of all nodes, so kubectl get tenantroutes shows the
+// cluster-wide pod and drift counts of every tenant.
+//
+// The agent reloads the conflist on SIGHUP and when it changes (checked every
+// --reload-interval, default 30s, 0 disables). Every reload is previewed first: the
+// per-pod rules it adds and removes, the tenants whose routing table it adds, removes
+// or changes, and the pods it unmarks are logged and answered by GET /v1/config. With
+// --require-confirmation a destructive reload (a tenant removed or pods unmarked) is
+// held back until the node is annotated tenant.routing/confirm-config=<fingerprint>.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/containerd/ttrpc"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agent"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/client"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/logging"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reconcile"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+var log = logging.Component("agent")
+
+// defaultReconcileInterval is how often rules are re-asserted by default
+const defaultReconcileInterval = time.Minute
+
+// defaultReloadInterval is how often the conflist is checked for changes by default
+const defaultReloadInterval = 30 * time.Second
+
+// defaultTerminatedGrace is how long terminated pods keep their rules by default
+const defaultTerminatedGrace = time.Minute
+
+// annotationCache is the plugin's annotation cache of the current configuration (nil
+// if disabled); see applySettings
+var annotationCache atomic.Pointer[k8s.AnnotationCache]
+
+func main() {
+	_, _ = logging.Setup(logging.Options{})
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+// run starts the agent and returns the process exit code
+func run(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tenant-routingd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	conflistPath := fs.String("conflist", "", "CNI conflist (or plugin config) containing the wrapper configuration")
+	node := fs.String("node", "", "node whose pods are watched (default $NODE_NAME, then hostname)")
+	socket := fs.String("socket", "", "unix socket of the plugin's RPC service (default: agentSocket of the configuration, then "+
+		agent.DefaultSocket+")")
+	apiSocket := fs.String("api-socket", client.DefaultSocket, "unix socket of the HTTP API for tools")
+	resync := fs.Duration("resync", k8s.DefaultResync, "informer resync period")
+	namespaceSelector := fs.String("namespace-selector", "",
+		"label selector of the namespaces to watch (default all); others are fetched once per resync period")
+	reconcileInterval := fs.Duration("reconcile-interval", defaultReconcileInterval,
+		"re-add missing rules and routes of recorded pods and retry annotation changes this often (0: never)")
+	reloadInterval := fs.Duration("reload-interval", defaultReloadInterval,
+		"check the conflist for changes this often (0: on SIGHUP only)")
+	requireConfirmation := fs.Bool("require-confirmation", false,
+		"hold back reloads that remove a tenant or unmark pods until the node is annotated "+k8s.ConfirmConfigAnnotationKey)
+	releaseTerminated := fs.Bool("release-terminated", false,
+		"remove the rules of pods that reached phase Succeeded or Failed before DEL")
+	terminatedGrace := fs.Duration("terminated-grace", defaultTerminatedGrace,
+		"how long a terminated pod keeps its rules with --release-terminated")
+	reconcileSample := fs.Int("reconcile-sample", 0,
+		"verify this many random pods per reconcile pass, plus those added or re-annotated since the last pass (0: all pods)")
+	fullReconcileInterval := fs.Duration("full-reconcile-interval", defaultFullReconcileInterval,
+		"verify all pods this often with --reconcile-sample")
+	apiCheckInterval := fs.Duration("api-check-interval", defaultAPICheckInterval,
+		"health-check the Kubernetes client this often, rebuilding it on failure (0: never)")
+	statusInterval := fs.Duration("status-interval", defaultStatusInterval,
+		"report the node's pods and marking health in the TenantRoute status this often (0: never)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *conflistPath == "" {
+		fmt.Fprintln(fs.Output(), "tenant-routingd: --conflist is required")
+		return 2
+	}
+	if *resync < 0 {
+		fmt.Fprintln(fs.Output(), "tenant-routingd: --resync must not be negative")
+		return 2
+	}
+	if *reconcileInterval < 0 || *reloadInterval < 0 || *terminatedGrace < 0 || *reconcileSample < 0 ||
+		*fullReconcileInterval < 0 || *apiCheckInterval < 0 || *statusInterval < 0 {
+		fmt.Fprintln(fs.Output(), "tenant-routingd: --reconcile-interval, --reload-interval, --terminated-grace, "+
+			"--reconcile-sample, --full-reconcile-interval, --api-check-interval and --status-interval must not be negative")
+		return 2
+	}
+	// A negative grace keeps the rules of terminated pods
+	release := time.Duration(-1)
+	if *releaseTerminated {
+		release = *terminatedGrace
+	}
+
+	data, err := os.ReadFile(*conflistPath)
+	if err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	conf, err := config.ParseConflist(data)
+	if err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	closeLog, err := logging.Setup(logging.Options{Format: conf.LogFormat, Level: conf.LogLevel, File: conf.LogFile})
+	if err != nil {
+		log.Warnf("keeping logs on stderr: %v", err)
+	} else {
+		defer closeLog()
+	}
+	applySettings(conf)
+
+	if *node == "" {
+		*node = os.Getenv("NODE_NAME")
+	}
+	if *node == "" {
+		if *node, err = os.Hostname(); err != nil {
+			log.Errorf("cannot determine node name: %v", err)
+			return 1
+		}
+	}
+	if *socket == "" {
+		*socket = conf.AgentSocket
+	}
+	if *socket == "" {
+		*socket = agent.DefaultSocket
+	}
+
+	clients, err := k8s.NewClientManager(conf.Kubeconfig, observeRebuild)
+	if err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	clientset := clients.Client()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Lookups are only answered from synced caches
+	informers, err := k8s.NewInformers(clientset, *node, *namespaceSelector, *resync)
+	if err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	changes := make(chan struct{}, 1)
+	sample := sampling{size: *reconcileSample, fullInterval: *fullReconcileInterval, changed: newChangedPods()}
+	err = informers.OnAnnotationChange(func(namespace, pod string) {
+		log.Debugf("annotations of %s/%s changed", namespace, pod)
+		if pod == "" {
+			annotationCache.Load().InvalidateNamespace(namespace)
+		} else {
+			annotationCache.Load().Invalidate(namespace, pod)
+		}
+		sample.changed.add(namespace, pod)
+		select {
+		case changes <- struct{}{}:
+		default: // a pass is queued already
+		}
+	})
+	if err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	if err := informers.Start(ctx); err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+
+	// Attachments set up before the agent (re)started
+	attachments := agent.NewAttachments()
+	records, err := state.New(conf.StateDir).List(conf.Name)
+	if err != nil {
+		log.Warnf("attachment table incomplete: %v", err)
+	}
+	attachments.Seed(records)
+
+	agentServer := agent.NewServer(informers, k8s.K8sAPITimeout, agent.Options{
+		FwmarkKeys:  conf.AnnotationKey,
+		GatewayKey:  conf.GatewayAnnotationKey,
+		Attachments: attachments,
+		ConfigHash:  conf.Fingerprint(),
+	})
+	ipt := iptables.NewManager()
+	reloaded := make(chan struct{}, 1)
+	reload := &reloader{
+		path:      *conflistPath,
+		node:      *node,
+		clientset: clientset,
+		resolver:  informers,
+		server:    agentServer,
+		ipt:       ipt,
+		confirm:   *requireConfirmation,
+		reloaded:  reloaded,
+		conf:      conf,
+	}
+	go reload.run(ctx, *reloadInterval, hup)
+	if *apiCheckInterval > 0 {
+		go checkLoop(ctx, clients, *apiCheckInterval)
+	}
+	if *statusInterval > 0 {
+		go statusLoop(ctx, clients, *node, attachments, *statusInterval)
+	}
+	go reconcileLoop(ctx, ipt, reload.current, informers, *reconcileInterval, release, sample, changes, reloaded)
+
+	rpcListener, err := agent.Listen(*socket)
+	if err != nil {
+		log.Errorf("failed to listen on %s: %v", *socket, err)
+		return 1
+	}
+	rpcServer, err := agentServer.RPCServer()
+	if err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	listener, err := agent.Listen(*apiSocket)
+	if err != nil {
+		rpcListener.Close()
+		log.Errorf("failed to listen on %s: %v", *apiSocket, err)
+		return 1
+	}
+	server := &http.Server{
+		Handler:           agentServer.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = rpcServer.Shutdown(shutdown)
+		_ = server.Shutdown(shutdown)
+	}()
+	go func() {
+		if err := rpcServer.Serve(ctx, rpcListener); err != nil && !errors.Is(err, ttrpc.ErrServerClosed) {
+			log.Errorf("RPC service stopped: %v", err)
+		}
+	}()
+
+	log.Infof("serving pods of node %s to the plugin at %s and the API at %s (%d attachments)", *node, *socket,
+		*apiSocket, attachments.Len())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("%v", err)
+		return 1
+	}
+	os.Remove(*socket)
+	os.Remove(*apiSocket)
+	return 0
+}
+
+// applySettings applies the process-wide settings of conf: API retries, namespace
+// label rules, static tenants, exclusions, the xtables lock timeout, the annotation cache
+// to invalidate and the metrics file
+func applySettings(conf *config.PluginConf) {
+	k8s.SetRetryPolicy(k8s.RetryPolicy{
+		Attempts: conf.K8sRetryAttempts,
+		Backoff:  time.Duration(conf.K8sRetryBackoff) * time.Millisecond,
+	})
+	rules := make([]k8s.NamespaceLabelRule, 0, len(conf.NamespaceLabels))
+	for _, rule := range conf.NamespaceLabels {
+		rules = append(rules, k8s.NamespaceLabelRule{Selector: rule.Selector, Fwmark: rule.Fwmark})
+	}
+	// ParseConflist validated the selectors
+	if err := k8s.SetNamespaceLabelRules(rules); err != nil {
+		log.Warnf("namespace labels ignored: %v", err)
+	}
+	tenants := make(map[string]k8s.StaticTenant, len(conf.Tenants))
+	for namespace, tenant := range conf.Tenants {
+		tenants[namespace] = k8s.StaticTenant{Fwmark: tenant.Fwmark, Table: tenant.Table, Gateway: tenant.Gateway}
+	}
+	k8s.SetStaticTenants(tenants)
+	// ParseConfig validated the selector
+	if err := k8s.SetExclusions(conf.ExcludeNamespaces, conf.ExcludePodLabelSelector); err != nil {
+		log.Warnf("excludePodLabelSelector ignored: %v", err)
+	}
+	iptables.SetLockTimeout(time.Duration(conf.IptablesLockTimeout) * time.Second)
+	metricsFile.Store(conf.MetricsFile)
+	annotationCache.Store(k8s.NewAnnotationCache(filepath.Join(conf.StateDir, k8s.AnnotationCacheDir),
+		time.Duration(conf.AnnotationCacheTTL)*time.Second, time.Duration(conf.NegativeAnnotationCacheTTL)*time.Second))
+}
+
+// reconcileLoop relabels pods on every annotation change and, every interval (if
+// non-zero) and after every reload, relabels and re-asserts the rules of all recorded
+// pods until ctx is done. Each pass runs with the configuration conf returns then.
+// Passes run one at a time; changes arriving during a pass queue a single new one.
+// Full passes also release pods terminated release ago, unless release is negative.
+// With a sample size, interval passes only re-assert the rules of a sample of the pods
+// and a full pass runs every sample.fullInterval.
+func reconcileLoop(ctx context.Context, ipt iptables.Manager, conf func() *config.PluginConf, resolver reconcile.Resolver,
+	interval, release time.Duration, sample sampling, changes, reloaded <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var lastPass, lastFull time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			relabel(ctx, ipt, conf(), resolver)
+		case <-tick:
+			start := time.Now()
+			if sample.size > 0 && start.Sub(lastFull) < sample.fullInterval {
+				sampledPass(ctx, ipt, conf(), resolver, release, sample, lastPass)
+			} else {
+				fullPass(ctx, ipt, conf(), resolver, release, sample)
+				lastFull = start
+			}
+			lastPass = start
+		case <-reloaded:
+			start := time.Now()
+			fullPass(ctx, ipt, conf(), resolver, release, sample)
+			lastPass, lastFull = start, start
+		}
+	}
+}
+
+// sampledPass is a fullPass that only re-asserts the rules of sample.size random pods,
+// of pods added since the last pass and of pods whose annotations changed meanwhile
+func sampledPass(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver reconcile.Resolver,
+	release time.Duration, sample sampling, since time.Time) {
+	releaseTerminated(ctx, ipt, conf, resolver, release)
+	relabel(ctx, ipt, conf, resolver)
+	pods, namespaces := sample.changed.take()
+	result, err := reconcile.RunSample(ctx, ipt, conf, resolver, k8s.K8sAPITimeout, reconcile.Sample{
+		Size: sample.size, Since: since, Pods: pods, Namespaces: namespaces})
+	if err != nil {
+		log.Warnf("%v", err)
+	}
+	if result != nil {
+		log.Debugf("reconciled %d of %d pods (%d skipped, %d repairs)", result.Checked, result.Records, result.Skipped,
+			len(result.Repaired))
+	}
+	observePass(conf, metrics.ScanSampled, result)
+}
+
+// fullPass releases terminated pods (unless release is negative), relabels pods and
+// re-asserts the rules of all recorded pods; failures are logged only
+func fullPass(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver reconcile.Resolver,
+	release time.Duration, sample sampling) {
+	releaseTerminated(ctx, ipt, conf, resolver, release)
+	relabel(ctx, ipt, conf, resolver)
+	// Every pod is verified, so are the changed ones
+	sample.changed.take()
+	result, err := reconcile.Run(ctx, ipt, conf, resolver, k8s.K8sAPITimeout)
+	if err != nil {
+		log.Warnf("%v", err)
+	}
+	if result != nil {
+		log.Debugf("reconciled %d pods (%d skipped, %d repairs)", result.Checked, result.Skipped, len(result.Repaired))
+		lastFullHealth.Store(&result.Tenants)
+	}
+	observePass(conf, metrics.ScanFull, result)
+}
+
+// releaseTerminated runs one reconcile.ReleaseTerminated pass unless release is
+// negative; failures are logged only
+func releaseTerminated(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver reconcile.Resolver,
+	release time.Duration) {
+	if release < 0 {
+		return
+	}
+	result, err := reconcile.ReleaseTerminated(ctx, ipt, conf, resolver, k8s.K8sAPITimeout, release)
+	if err != nil {
+		log.Warnf("%v", err)
+	}
+	if result != nil && len(result.Released) > 0 {
+		log.Infof("released %d terminated pods", len(result.Released))
+	}
+}
+
+// relabel runs one reconcile.Relabel pass; failures are logged only
+func relabel(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver reconcile.Resolver) {
+	result, err := reconcile.Relabel(ctx, ipt, conf, resolver, k8s.K8sAPITimeout)
+	if err != nil {
+		log.Warnf("%v", err)
+	}
+	if result != nil && len(result.Relabeled) > 0 {
+		log.Infof("relabeled %d pods", len(result.Relabeled))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agent"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reconcile"
+)
+
+// reloader re-reads the conflist of the agent and applies changed configurations
+// Every change is previewed first (see reconcile.Preview). With confirm set, a
+// destructive change is held back until the node's tenant.routing/confirm-config
+// annotation names its fingerprint.
+type reloader struct {
+	path      string
+	node      string
+	clientset kubernetes.Interface
+	resolver  reconcile.Resolver
+	server    *agent.Server
+	ipt       iptables.Manager
+	confirm   bool
+
+	// reloaded is signalled after a configuration was applied
+	reloaded chan<- struct{}
+
+	mu      sync.Mutex
+	conf    *config.PluginConf
+	pending string // fingerprint held back for confirmation, logged once
+}
+
+// current returns the configuration in effect
+func (r *reloader) current() *config.PluginConf {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conf
+}
+
+// run reloads on every hup and every interval (if non-zero) until ctx is done
+func (r *reloader) run(ctx context.Context, interval time.Duration, hup <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Infof("reloading %s", r.path)
+			r.reload(ctx)
+		case <-tick:
+			r.reload(ctx)
+		}
+	}
+}
+
+// reload applies the conflist if its configuration differs from the one in effect
+// A conflist that cannot be read or parsed keeps the current configuration, as does a
+// failure to remove what the new one drops (retried on the next reload). Kubeconfig,
+// agentSocket and the logging settings only change with a restart.
+func (r *reloader) reload(ctx context.Context) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		log.Warnf("configuration not reloaded: %v", err)
+		return
+	}
+	next, err := config.ParseConflist(data)
+	if err != nil {
+		log.Warnf("configuration not reloaded: %v", err)
+		return
+	}
+	old := r.current()
+	if next.Fingerprint() == old.Fingerprint() {
+		if r.pending != "" {
+			log.Infof("configuration %s awaiting confirmation was withdrawn", r.pending)
+			r.pending = ""
+			r.server.SetConfig(old.AnnotationKey, old.GatewayAnnotationKey, agent.ConfigStatus{Applied: old.Fingerprint()})
+		}
+		return
+	}
+
+	impact := reconcile.Preview(ctx, old, next, r.resolver, k8s.K8sAPITimeout)
+	if impact.Destructive() && r.confirm && !r.confirmed(ctx, impact.To) {
+		if r.pending != impact.To {
+			log.Warnf("configuration held back until node %s is annotated %s=%s: %s (unmarked pods: %v)",
+				r.node, k8s.ConfirmConfigAnnotationKey, impact.To, impact, impact.Unmarked)
+			r.pending = impact.To
+		}
+		r.server.SetConfig(old.AnnotationKey, old.GatewayAnnotationKey,
+			agent.ConfigStatus{Applied: impact.From, Pending: impact.To, Impact: wireImpact(impact)})
+		return
+	}
+
+	if impact.Destructive() {
+		log.Warnf("applying destructive configuration %s (unmarked pods: %v)", impact, impact.Unmarked)
+	} else {
+		log.Infof("applying configuration %s", impact)
+	}
+	if err := reconcile.ApplyConfig(ctx, r.ipt, old, next, impact); err != nil {
+		log.Warnf("configuration %s not applied, retrying on the next reload: %v", impact.To, err)
+		return
+	}
+
+	r.mu.Lock()
+	r.conf = next
+	r.mu.Unlock()
+	r.pending = ""
+	applySettings(next)
+	r.server.SetConfig(next.AnnotationKey, next.GatewayAnnotationKey,
+		agent.ConfigStatus{Applied: impact.To, Impact: wireImpact(impact)})
+
+	// Rules the new configuration adds are installed by the next pass
+	select {
+	case r.reloaded <- struct{}{}:
+	default:
+	}
+}
+
+// confirmed reports whether the node annotation approves the configuration hash
+func (r *reloader) confirmed(ctx context.Context, hash string) bool {
+	approved, err := k8s.GetNodeAnnotation(ctx, r.clientset, r.node, k8s.ConfirmConfigAnnotationKey, k8s.K8sAPITimeout)
+	if err != nil {
+		log.Warnf("cannot read confirmation of configuration %s: %v", hash, err)
+		return false
+	}
+	return approved == hash
+}
+
+// wireImpact returns the API form of impact
+func wireImpact(impact *reconcile.Impact) *agent.ConfigImpact {
+	return &agent.ConfigImpact{
+		From:           impact.From,
+		To:             impact.To,
+		RulesAdded:     impact.RulesAdded,
+		RulesRemoved:   impact.RulesRemoved,
+		TenantsAdded:   impact.TenantsAdded,
+		TenantsRemoved: impact.TenantsRemoved,
+		TenantsChanged: impact.TenantsChanged,
+		Unmarked:       impact.Unmarked,
+		Destructive:    impact.Destructive(),
+	}
+}
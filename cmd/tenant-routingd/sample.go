@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/metrics"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reconcile"
+)
+
+// defaultFullReconcileInterval is how often a sampling agent verifies every pod by default
+const defaultFullReconcileInterval = 15 * time.Minute
+
+// sampling configures the reconcile passes between full ones (see reconcile.RunSample)
+type sampling struct {
+	// size is the number of pods verified at random per pass; 0 makes every pass full
+	size int
+
+	// fullInterval is how often a pass verifies every pod anyway
+	fullInterval time.Duration
+
+	// changed collects the pods whose annotations changed, verified by the next pass
+	changed *changedPods
+}
+
+// changedPods collects pods and namespaces whose annotations changed between passes
+type changedPods struct {
+	mu         sync.Mutex
+	pods       map[string]bool
+	namespaces map[string]bool
+}
+
+func newChangedPods() *changedPods {
+	return &changedPods{pods: map[string]bool{}, namespaces: map[string]bool{}}
+}
+
+// add records a change of pod, or of namespace if pod is empty
+func (c *changedPods) add(namespace, pod string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pod == "" {
+		c.namespaces[namespace] = true
+	} else {
+		c.pods[namespace+"/"+pod] = true
+	}
+}
+
+// take returns the changes collected since the last take and starts over
+func (c *changedPods) take() (pods, namespaces map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pods, namespaces = c.pods, c.namespaces
+	c.pods, c.namespaces = map[string]bool{}, map[string]bool{}
+	return pods, namespaces
+}
+
+// observePass records a reconcile pass of scan in the metrics file of conf, if any
+func observePass(conf *config.PluginConf, scan string, result *reconcile.Result) {
+	if conf.MetricsFile == "" || result == nil {
+		return
+	}
+	recorder, err := metrics.NewRecorder(conf.MetricsFile)
+	if err == nil {
+		err = recorder.ObserveReconcile(scan, result.Checked, len(result.Repaired), result.Coverage())
+	}
+	if err != nil {
+		log.Warnf("reconcile metrics not recorded: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agent"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reconcile"
+)
+
+// defaultStatusInterval is how often the TenantRoute status is reported by default
+const defaultStatusInterval = time.Minute
+
+// statusStaleIntervals is how many intervals the report of a node stays in the
+// TenantRoute status without being refreshed
+const statusStaleIntervals = 5
+
+// lastFullHealth is the marking health by tenant of the last full reconcile pass (nil
+// before the first one); see fullPass
+var lastFullHealth atomic.Pointer[map[string]reconcile.TenantHealth]
+
+// statusLoop reports the attached pods of node and the health of the last full pass
+// in the TenantRoute status every interval until ctx is done (see k8s.ReportTenantStatus)
+func statusLoop(ctx context.Context, clients *k8s.ClientManager, node string, attachments *agent.Attachments,
+	interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var health map[string]reconcile.TenantHealth
+			if last := lastFullHealth.Load(); last != nil {
+				health = *last
+			}
+			reports := tenantReports(attachments.Tenants(), health)
+			err := k8s.ReportTenantStatus(ctx, clients.Client(), node, reports, statusStaleIntervals*interval, k8s.K8sAPITimeout)
+			if err != nil {
+				log.Warnf("tenant status not reported: %v", err)
+			}
+		}
+	}
+}
+
+// tenantReports combines the attached pods and the full pass health by tenant into
+// the reports of the node; unmarked pods are left out
+func tenantReports(pods map[string]int, health map[string]reconcile.TenantHealth) map[string]k8s.TenantNodeStatus {
+	reports := make(map[string]k8s.TenantNodeStatus, len(pods))
+	for fwmark, n := range pods {
+		report := reports[fwmark]
+		report.Pods = n
+		reports[fwmark] = report
+	}
+	for fwmark, h := range health {
+		if fwmark == "" {
+			continue
+		}
+		report := reports[fwmark]
+		report.VerifiedPods, report.DriftedPods = h.Checked, h.Drifted
+		reports[fwmark] = report
+	}
+	return reports
+}
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agentclient"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/client"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// fakeResolver answers from fixed maps
+type fakeResolver struct {
+	pods   map[string]k8s.RoutingAnnotations
+	errs   map[string]error
+	strict map[string]bool
+}
+
+func (f *fakeResolver) RoutingAnnotations(_ context.Context, podName, podNamespace string, _ []string, _ string,
+	_ time.Duration) (k8s.RoutingAnnotations, error) {
+	key := podNamespace + "/" + podName
+	if err, ok := f.errs[key]; ok {
+		return k8s.RoutingAnnotations{}, err
+	}
+	return f.pods[key], nil
+}
+
+func (f *fakeResolver) StrictOverride(_ context.Context, namespace string, _ time.Duration) (*bool, error) {
+	if strict, ok := f.strict[namespace]; ok {
+		return &strict, nil
+	}
+	return nil, nil
+}
+
+// serve starts the RPC service of a server for resolver on a temporary socket and
+// returns its client
+func serve(t *testing.T, resolver Resolver, opts Options) *agentclient.Client {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := Listen(socket)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	server, err := NewServer(resolver, time.Second, opts).RPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(context.Background(), listener)
+	t.Cleanup(func() { server.Close() })
+	return agentclient.New(socket)
+}
+
+// TestRPC_ResolveTenant verifies answers, the strict mode and error kinds survive the socket
+func TestRPC_ResolveTenant(t *testing.T) {
+	until := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := serve(t, &fakeResolver{
+		pods: map[string]k8s.RoutingAnnotations{
+			"team-a/web": {Fwmark: "0x10", Gateway: "10.10.10.131", PodUID: "3f1c0b7e-web", BypassUntil: until,
+				BypassError: errors.New("bypass-until value 'soon' is not an RFC3339 timestamp")},
+			"team-a/cilium": {PodUID: "3f1c0b7e-cilium", Excluded: true},
+		},
+		errs: map[string]error{
+			"team-a/gone":  fmt.Errorf("pod team-a/gone not found: %w", tenant.ErrNotFound),
+			"team-a/bad":   fmt.Errorf("invalid fwmark in pod annotation: %w", k8s.ErrInvalidFwmark),
+			"team-a/badgw": fmt.Errorf("invalid gateway in pod annotation: %w", k8s.ErrInvalidGateway),
+			"team-a/down":  errors.New("failed to get pod team-a/down: connection refused"),
+		},
+		strict: map[string]bool{"team-a": true},
+	}, Options{FwmarkKeys: []string{"tenant.routing/fwmark"}})
+	ctx := context.Background()
+
+	got, strict, err := client.ResolveTenant(ctx, "web", "team-a", "")
+	if err != nil {
+		t.Fatalf("ResolveTenant() error = %v", err)
+	}
+	if got.Fwmark != "0x10" || got.Gateway != "10.10.10.131" || got.PodUID != "3f1c0b7e-web" ||
+		!got.BypassUntil.Equal(until) || got.BypassError == nil || strict == nil || !*strict {
+		t.Errorf("ResolveTenant() = %+v, %v; want the annotations of web, strict", got, strict)
+	}
+	if got, _, err := client.ResolveTenant(ctx, "cilium", "team-a", ""); err != nil || !got.Excluded || !got.BypassUntil.IsZero() {
+		t.Errorf("ResolveTenant() of an excluded pod = %+v, %v; want excluded", got, err)
+	}
+
+	if _, _, err := client.ResolveTenant(ctx, "gone", "team-a", ""); !errors.Is(err, tenant.ErrNotFound) {
+		t.Errorf("missing pod error = %v, want NotFound", err)
+	}
+	if _, _, err := client.ResolveTenant(ctx, "bad", "team-a", ""); !errors.Is(err, k8s.ErrInvalidFwmark) {
+		t.Errorf("invalid fwmark error = %v, want ErrInvalidFwmark", err)
+	}
+	if _, _, err := client.ResolveTenant(ctx, "badgw", "team-a", ""); !errors.Is(err, k8s.ErrInvalidGateway) {
+		t.Errorf("invalid gateway error = %v, want ErrInvalidGateway", err)
+	}
+	_, _, err = client.ResolveTenant(ctx, "down", "team-a", "")
+	if err == nil || errors.Is(err, agentclient.ErrUnavailable) || errors.Is(err, tenant.ErrNotFound) ||
+		!strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("API failure of the agent = %v, want a plain error with its message", err)
+	}
+
+	if _, _, err := serve(t, &fakeResolver{}, Options{}).ResolveTenant(ctx, "web", "team-a", ""); err == nil ||
+		errors.Is(err, agentclient.ErrUnavailable) {
+		t.Errorf("ResolveTenant() of an agent without keys error = %v, want a plain error", err)
+	}
+}
+
+// TestRPC_StrictOverride verifies the strict-mode lookup
+func TestRPC_StrictOverride(t *testing.T) {
+	client := serve(t, &fakeResolver{strict: map[string]bool{"team-a": true}}, Options{})
+
+	if strict, err := client.StrictOverride(context.Background(), "team-a"); err != nil || strict == nil || !*strict {
+		t.Errorf("StrictOverride(team-a) = %v, %v; want true", strict, err)
+	}
+	if strict, err := client.StrictOverride(context.Background(), "team-b"); err != nil || strict != nil {
+		t.Errorf("StrictOverride(team-b) = %v, %v; want nil", strict, err)
+	}
+}
+
+// TestRPC_Attachments verifies the table follows records and releases, starting from Seed
+func TestRPC_Attachments(t *testing.T) {
+	attachments := NewAttachments()
+	attachments.Seed([]*state.Record{{Network: "tenant-net", ContainerID: "old", IfName: "eth0", Fwmark: "0x20"}})
+	client, ctx := serve(t, &fakeResolver{}, Options{Attachments: attachments}), context.Background()
+
+	rec := &state.Record{Network: "tenant-net", ContainerID: "c1", IfName: "eth0", Namespace: "team-a", Pod: "web",
+		IPs: []string{"10.0.0.5"}, Fwmark: "0x10"}
+	if err := client.RecordAttachment(ctx, rec); err != nil {
+		t.Fatalf("RecordAttachment() error = %v", err)
+	}
+	if attachments.Len() != 2 {
+		t.Errorf("table has %d attachments, want 2", attachments.Len())
+	}
+	second := Attachment{Namespace: "team-a", Pod: "web", Fwmark: "0x10"}
+	second.Network, second.ContainerID, second.IfName = "tenant-net", "c1", "net1"
+	attachments.Record(second)
+	if got := attachments.Tenants(); len(got) != 2 || got["0x10"] != 1 || got["0x20"] != 1 {
+		t.Errorf("Tenants() = %v, want one pod of 0x10 and one of 0x20", got)
+	}
+	attachments.Release(second.AttachmentKey)
+	if got, err := client.ReleaseAttachment(ctx, "tenant-net", "c1", "eth0"); err != nil || got == nil ||
+		got.PodIP() != "10.0.0.5" || got.Fwmark != "0x10" || got.Pod != "web" {
+		t.Errorf("ReleaseAttachment(c1) = %+v, %v; want the recorded attachment", got, err)
+	}
+	if got, err := client.ReleaseAttachment(ctx, "tenant-net", "old", "eth0"); err != nil || got == nil || got.Fwmark != "0x20" {
+		t.Errorf("ReleaseAttachment(old) = %+v, %v; want the seeded attachment", got, err)
+	}
+	if got, err := client.ReleaseAttachment(ctx, "tenant-net", "c1", "eth0"); err != nil || got != nil {
+		t.Errorf("second ReleaseAttachment(c1) = %+v, %v; want nil", got, err)
+	}
+	if err := client.RecordAttachment(ctx, &state.Record{Network: "tenant-net"}); err == nil {
+		t.Error("RecordAttachment() without container ID succeeded")
+	}
+}
+
+// keyResolver answers every pod with the fwmark keys it was asked with, comma-separated
+type keyResolver struct{ *fakeResolver }
+
+func (keyResolver) RoutingAnnotations(_ context.Context, _, _ string, fwmarkKeys []string, _ string,
+	_ time.Duration) (k8s.RoutingAnnotations, error) {
+	return k8s.RoutingAnnotations{Fwmark: strings.Join(fwmarkKeys, ",")}, nil
+}
+
+// TestServer_SetConfig verifies reloads change the keys ResolveTenant reads and the Config answer
+func TestServer_SetConfig(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(keyResolver{&fakeResolver{}}, time.Second, Options{FwmarkKeys: []string{"tenant.routing/fwmark"}, ConfigHash: "aaaa"})
+	httpServer := &http.Server{Handler: server.Handler()}
+	go httpServer.Serve(listener)
+	t.Cleanup(func() { httpServer.Close() })
+	api, ctx := client.New(socket), context.Background()
+
+	if status, err := api.Config(ctx); err != nil || status.Applied != "aaaa" || status.Impact != nil {
+		t.Errorf("Config() = %+v, %v; want aaaa applied, no impact", status, err)
+	}
+
+	impact := &ConfigImpact{From: "aaaa", To: "bbbb", RulesRemoved: 4, TenantsRemoved: []string{"0x20"}, Destructive: true}
+	server.SetConfig([]string{"example.com/fwmark", "tenant.routing/fwmark"}, "", ConfigStatus{Applied: "aaaa", Pending: "bbbb", Impact: impact})
+	status, err := api.Config(ctx)
+	if err != nil || status.Pending != "bbbb" || status.Impact == nil || !status.Impact.Destructive ||
+		len(status.Impact.TenantsRemoved) != 1 {
+		t.Errorf("Config() = %+v, %v; want bbbb pending with its impact", status, err)
+	}
+	if tenant, err := api.ResolveTenant(ctx, client.ResolveTenantRequest{Namespace: "team-a", Pod: "web"}); err != nil ||
+		tenant.Fwmark != "example.com/fwmark,tenant.routing/fwmark" {
+		t.Errorf("ResolveTenant() = %+v, %v; want the pod read with the new keys", tenant, err)
+	}
+	annotations, err := api.Annotations(ctx, client.AnnotationsRequest{Namespace: "team-a", Pod: "web",
+		FwmarkKeys: []string{"b/fwmark", "a/fwmark"}})
+	if err != nil || annotations.Fwmark != "b/fwmark,a/fwmark" {
+		t.Errorf("Annotations() = %+v, %v; want both keys in order", annotations, err)
+	}
+}
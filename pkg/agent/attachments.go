@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/client"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// Attachments is the agent's table of the attachments the CNI plugin set up
+// The plugin reports each ADD (RecordAttachment) and DEL (ReleaseAttachment); a
+// restarted agent is seeded from the plugin's state records (see Seed).
+type Attachments struct {
+	mu    sync.Mutex
+	byKey map[client.AttachmentKey]client.Attachment
+}
+
+// NewAttachments returns an empty table
+func NewAttachments() *Attachments {
+	return &Attachments{byKey: map[client.AttachmentKey]client.Attachment{}}
+}
+
+// Seed adds the attachments of records that are not in the table yet
+func (a *Attachments) Seed(records []*state.Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, rec := range records {
+		attachment := FromRecord(rec)
+		if _, ok := a.byKey[attachment.AttachmentKey]; !ok {
+			a.byKey[attachment.AttachmentKey] = attachment
+		}
+	}
+}
+
+// Record adds or replaces attachment
+func (a *Attachments) Record(attachment client.Attachment) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.byKey[attachment.AttachmentKey] = attachment
+}
+
+// Release removes the attachment of key and returns it; false if it was not in the table
+func (a *Attachments) Release(key client.AttachmentKey) (client.Attachment, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	attachment, ok := a.byKey[key]
+	delete(a.byKey, key)
+	return attachment, ok
+}
+
+// Len returns the number of attachments in the table
+func (a *Attachments) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.byKey)
+}
+
+// Tenants returns the number of attached pods by tenant (fwmark); unmarked pods are
+// not counted, and a pod with several interfaces counts once
+func (a *Attachments) Tenants() map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pods := map[string]map[string]bool{}
+	for _, attachment := range a.byKey {
+		if attachment.Fwmark == "" {
+			continue
+		}
+		if pods[attachment.Fwmark] == nil {
+			pods[attachment.Fwmark] = map[string]bool{}
+		}
+		pods[attachment.Fwmark][attachment.Namespace+"/"+attachment.Pod] = true
+	}
+	tenants := make(map[string]int, len(pods))
+	for fwmark, names := range pods {
+		tenants[fwmark] = len(names)
+	}
+	return tenants
+}
+
+// FromRecord returns the attachment a state record describes
+func FromRecord(rec *state.Record) client.Attachment {
+	return client.Attachment{
+		AttachmentKey: client.AttachmentKey{Network: rec.Network, ContainerID: rec.ContainerID, IfName: rec.IfName},
+		Namespace:     rec.Namespace,
+		Pod:           rec.Pod,
+		PodUID:        rec.PodUID,
+		IPs:           rec.IPs,
+		Fwmark:        rec.Fwmark,
+		Gateway:       rec.Gateway,
+	}
+}
+
+// ToRecord returns a state record of attachment with the fields the agent keeps
+func ToRecord(attachment client.Attachment) *state.Record {
+	return &state.Record{
+		Network:     attachment.Network,
+		ContainerID: attachment.ContainerID,
+		IfName:      attachment.IfName,
+		Namespace:   attachment.Namespace,
+		Pod:         attachment.Pod,
+		PodUID:      attachment.PodUID,
+		IPs:         attachment.IPs,
+		Fwmark:      attachment.Fwmark,
+		Gateway:     attachment.Gateway,
+	}
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"errors"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	agentv1 "github.com/azalio/kubeCon-cni-wrapper/api/agent/v1"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agentclient"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/client"
+)
+
+// RPCServer returns a ttrpc server of the CNI plugin's service (api/agent/v1)
+// It answers from the same resolver, annotation keys and attachment table as Handler.
+func (s *Server) RPCServer() (*ttrpc.Server, error) {
+	srv, err := ttrpc.NewServer()
+	if err != nil {
+		return nil, err
+	}
+	agentv1.RegisterAgentService(srv, &rpcService{server: s})
+	return srv, nil
+}
+
+// rpcService implements agentv1.AgentService for a Server
+type rpcService struct {
+	server *Server
+}
+
+func (r *rpcService) ResolveTenant(ctx context.Context, req *agentv1.ResolveTenantRequest) (*agentv1.ResolveTenantResponse,
+	error) {
+	if req.Namespace == "" || req.Pod == "" {
+		return nil, status.Error(codes.InvalidArgument, "namespace and pod are required")
+	}
+	annotations, strict, err := r.server.resolve(ctx, req.Namespace, req.Pod)
+	if errors.Is(err, errNoKeys) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &agentv1.ResolveTenantResponse{Annotations: agentclient.ToAnnotations(annotations), Strict: strict}, nil
+}
+
+func (r *rpcService) StrictOverride(ctx context.Context, req *agentv1.StrictOverrideRequest) (*agentv1.StrictOverrideResponse,
+	error) {
+	if req.Namespace == "" {
+		return nil, status.Error(codes.InvalidArgument, "namespace is required")
+	}
+	strict, err := r.server.resolver.StrictOverride(ctx, req.Namespace, r.server.timeout)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &agentv1.StrictOverrideResponse{Strict: strict}, nil
+}
+
+func (r *rpcService) RecordAttachment(_ context.Context, req *agentv1.RecordAttachmentRequest) (*agentv1.RecordAttachmentResponse,
+	error) {
+	attachment := fromRPCAttachment(req.GetAttachment())
+	if !complete(attachment.AttachmentKey) {
+		return nil, status.Error(codes.InvalidArgument, "network, containerID and ifName are required")
+	}
+	r.server.opts.Attachments.Record(attachment)
+	log.Debugf("recorded attachment %s/%s of pod %s/%s", attachment.ContainerID, attachment.IfName,
+		attachment.Namespace, attachment.Pod)
+	return &agentv1.RecordAttachmentResponse{}, nil
+}
+
+func (r *rpcService) ReleaseAttachment(_ context.Context, req *agentv1.ReleaseAttachmentRequest) (*agentv1.ReleaseAttachmentResponse,
+	error) {
+	key := req.GetKey()
+	attachmentKey := client.AttachmentKey{Network: key.GetNetwork(), ContainerID: key.GetContainerId(), IfName: key.GetIfName()}
+	if !complete(attachmentKey) {
+		return nil, status.Error(codes.InvalidArgument, "network, containerID and ifName are required")
+	}
+	var resp agentv1.ReleaseAttachmentResponse
+	if attachment, ok := r.server.opts.Attachments.Release(attachmentKey); ok {
+		resp.Attachment = toRPCAttachment(attachment)
+	}
+	return &resp, nil
+}
+
+// rpcError returns the status of a failed lookup, with its kind as the detail
+func rpcError(err error) error {
+	code, kind := codes.Unavailable, agentv1.ErrorKind_ERROR_KIND_UNAVAILABLE
+	switch errorKind(err) {
+	case KindNotFound:
+		code, kind = codes.NotFound, agentv1.ErrorKind_ERROR_KIND_NOT_FOUND
+	case KindInvalidFwmark:
+		code, kind = codes.InvalidArgument, agentv1.ErrorKind_ERROR_KIND_INVALID_FWMARK
+	case KindInvalidGateway:
+		code, kind = codes.InvalidArgument, agentv1.ErrorKind_ERROR_KIND_INVALID_GATEWAY
+	}
+	st, detailErr := status.New(code, err.Error()).WithDetails(&agentv1.ErrorDetail{Kind: kind})
+	if detailErr != nil {
+		return status.Error(code, err.Error())
+	}
+	return st.Err()
+}
+
+// fromRPCAttachment returns the table entry of an attachment of the RPC service
+func fromRPCAttachment(attachment *agentv1.Attachment) client.Attachment {
+	key := attachment.GetKey()
+	return client.Attachment{
+		AttachmentKey: client.AttachmentKey{Network: key.GetNetwork(), ContainerID: key.GetContainerId(), IfName: key.GetIfName()},
+		Namespace:     attachment.GetNamespace(),
+		Pod:           attachment.GetPod(),
+		PodUID:        attachment.GetPodUid(),
+		IPs:           attachment.GetIps(),
+		Fwmark:        attachment.GetFwmark(),
+		Gateway:       attachment.GetGateway(),
+	}
+}
+
+// toRPCAttachment returns the RPC form of a table entry
+func toRPCAttachment(attachment client.Attachment) *agentv1.Attachment {
+	return &agentv1.Attachment{
+		Key: &agentv1.AttachmentKey{Network: attachment.Network, ContainerId: attachment.ContainerID,
+			IfName: attachment.IfName},
+		Namespace: attachment.Namespace,
+		Pod:       attachment.Pod,
+		PodUid:    attachment.PodUID,
+		Ips:       attachment.IPs,
+		Fwmark:    attachment.Fwmark,
+		Gateway:   attachment.Gateway,
+	}
+}
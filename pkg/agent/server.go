@@ -0,0 +1,353 @@
+// Package agent serves routing annotations from a node daemon to the CNI plugin.
+//
+// The tenant-routingd daemon watches the pods of its node and all namespaces, and
+// answers lookups over unix sockets. A CNI invocation then costs one local
+// round-trip instead of loading a kubeconfig and making two API calls.
+//
+// The plugin calls the ttrpc service of api/agent/v1 (see RPCServer and
+// pkg/agentclient), so its binary needs no HTTP stack. Tools use the HTTP API, with
+// JSON bodies:
+//
+//	GET  /v1/annotations?namespace=&pod=&fwmarkKeys=&gatewayKey=  → Annotations
+//	GET  /v1/strict?namespace=                                   → Strict
+//	POST /v1/resolveTenant      ResolveTenantRequest             → Tenant
+//	POST /v1/recordAttachment   Attachment                       → {}
+//	POST /v1/releaseAttachment  AttachmentKey                    → Released
+//	GET  /v1/config                                              → ConfigStatus
+//	GET  /healthz                                                → 200
+//
+// The POST methods are those of the plugin's service: ResolveTenant reads the
+// annotation keys of the agent's configuration, so the plugin needs neither a
+// kubeconfig nor the keys, and the attachment methods maintain the agent's table of
+// attachments.
+// Config reports the configuration the agent runs with (see SetConfig).
+//
+// Failures are answered with a non-2xx status and an Error body. The API is specified
+// in api/openapi.yaml, and its wire types are those of pkg/client.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/agentclient"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/client"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/logging"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// DefaultSocket is where tenant-routingd serves the CNI plugin's RPC service by default
+const DefaultSocket = agentclient.DefaultSocket
+
+// Error kinds, so the client can restore errors the CNI plugin classifies (see reason)
+const (
+	KindNotFound       = client.KindNotFound
+	KindInvalidFwmark  = client.KindInvalidFwmark
+	KindInvalidGateway = client.KindInvalidGateway
+	KindUnavailable    = client.KindUnavailable
+)
+
+// Wire types of the API
+type (
+	Annotations = client.Annotations
+	Strict      = client.Strict
+	Error       = client.Error
+	Tenant      = client.Tenant
+	Attachment  = client.Attachment
+
+	ConfigStatus = client.ConfigStatus
+	ConfigImpact = client.ConfigImpact
+)
+
+// Resolver answers lookups; *k8s.Informers implements it
+// Lookups run under the context of the request, so they end when the plugin hangs up.
+type Resolver interface {
+	RoutingAnnotations(ctx context.Context, podName, podNamespace string, fwmarkKeys []string, gatewayKey string,
+		timeout time.Duration) (k8s.RoutingAnnotations, error)
+	StrictOverride(ctx context.Context, namespace string, timeout time.Duration) (*bool, error)
+}
+
+var log = logging.Component("agent")
+
+// Options configures the RPC methods of a Server
+type Options struct {
+	// FwmarkKeys (in priority order) and GatewayKey are the annotation keys
+	// ResolveTenant reads; without FwmarkKeys, ResolveTenant is rejected
+	FwmarkKeys []string
+	GatewayKey string
+
+	// Attachments is the table the attachment methods maintain; nil starts an empty one
+	Attachments *Attachments
+
+	// ConfigHash is the fingerprint of the configuration the agent starts with
+	ConfigHash string
+}
+
+// Server answers lookups of CNI invocations from a Resolver
+type Server struct {
+	resolver Resolver
+	timeout  time.Duration
+	opts     Options
+
+	// mu guards the annotation keys of opts and config, which change on reloads
+	mu     sync.Mutex
+	config ConfigStatus
+}
+
+// NewServer returns a server answering from resolver
+// timeout bounds the API calls resolver makes on a cache miss.
+func NewServer(resolver Resolver, timeout time.Duration, opts Options) *Server {
+	if opts.Attachments == nil {
+		opts.Attachments = NewAttachments()
+	}
+	return &Server{resolver: resolver, timeout: timeout, opts: opts, config: ConfigStatus{Applied: opts.ConfigHash}}
+}
+
+// SetConfig replaces the annotation keys ResolveTenant reads and the status Config
+// answers, after the agent reloaded its configuration or held a reload back
+func (s *Server) SetConfig(fwmarkKeys []string, gatewayKey string, status ConfigStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.FwmarkKeys, s.opts.GatewayKey = fwmarkKeys, gatewayKey
+	s.config = status
+}
+
+// annotationKeys returns the keys ResolveTenant reads
+func (s *Server) annotationKeys() ([]string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.FwmarkKeys, s.opts.GatewayKey
+}
+
+// Handler returns the HTTP handler of the protocol
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+client.APIVersion+"/annotations", s.annotations)
+	mux.HandleFunc("/"+client.APIVersion+"/strict", s.strict)
+	mux.HandleFunc("/"+client.APIVersion+"/resolveTenant", s.resolveTenant)
+	mux.HandleFunc("/"+client.APIVersion+"/recordAttachment", s.recordAttachment)
+	mux.HandleFunc("/"+client.APIVersion+"/releaseAttachment", s.releaseAttachment)
+	mux.HandleFunc("/"+client.APIVersion+"/config", s.configStatus)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// Listen creates the unix socket at path, replacing a stale one
+// The socket is only accessible to root: the answers steer tenant traffic.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (s *Server) annotations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	namespace, pod := q.Get("namespace"), q.Get("pod")
+	if namespace == "" || pod == "" || q.Get("fwmarkKey") == "" {
+		writeJSON(w, http.StatusBadRequest, Error{Message: "namespace, pod and fwmarkKey are required"})
+		return
+	}
+
+	annotations, err := s.resolver.RoutingAnnotations(r.Context(), pod, namespace, q["fwmarkKey"], q.Get("gatewayKey"), s.timeout)
+	if err != nil {
+		log.Debugf("lookup of pod %s/%s failed: %v", namespace, pod, err)
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toAnswer(annotations))
+}
+
+// toAnswer returns the wire form of annotations
+func toAnswer(annotations k8s.RoutingAnnotations) Annotations {
+	answer := Annotations{Fwmark: annotations.Fwmark, Gateway: annotations.Gateway, Tenant: annotations.TenantName,
+		Table: annotations.Table, Source: string(annotations.Source), PodUID: annotations.PodUID,
+		BypassUntil: annotations.BypassUntil, Excluded: annotations.Excluded, Terminated: annotations.Terminated,
+		TerminatedAt: annotations.TerminatedAt}
+	if annotations.BypassError != nil {
+		answer.BypassError = annotations.BypassError.Error()
+	}
+	if annotations.FallbackError != nil {
+		answer.FallbackError = annotations.FallbackError.Error()
+	}
+	return answer
+}
+
+func (s *Server) strict(w http.ResponseWriter, r *http.Request) {
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		writeJSON(w, http.StatusBadRequest, Error{Message: "namespace is required"})
+		return
+	}
+	strict, err := s.resolver.StrictOverride(r.Context(), namespace, s.timeout)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Strict{Strict: strict})
+}
+
+func (s *Server) resolveTenant(w http.ResponseWriter, r *http.Request) {
+	var req client.ResolveTenantRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Namespace == "" || req.Pod == "" {
+		writeJSON(w, http.StatusBadRequest, Error{Message: "namespace and pod are required"})
+		return
+	}
+	annotations, strict, err := s.resolve(r.Context(), req.Namespace, req.Pod)
+	if errors.Is(err, errNoKeys) {
+		writeJSON(w, http.StatusBadRequest, Error{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Tenant{Annotations: toAnswer(annotations), Strict: strict})
+}
+
+// errNoKeys rejects ResolveTenant while the agent has no annotation keys
+var errNoKeys = errors.New("the agent has no annotation keys configured")
+
+// resolve answers ResolveTenant: the annotations of a pod, read with the agent's keys,
+// and the strict-mode override of its namespace
+func (s *Server) resolve(ctx context.Context, namespace, pod string) (k8s.RoutingAnnotations, *bool, error) {
+	fwmarkKeys, gatewayKey := s.annotationKeys()
+	if len(fwmarkKeys) == 0 {
+		return k8s.RoutingAnnotations{}, nil, errNoKeys
+	}
+
+	annotations, err := s.resolver.RoutingAnnotations(ctx, pod, namespace, fwmarkKeys, gatewayKey, s.timeout)
+	if err != nil {
+		log.Debugf("lookup of pod %s/%s failed: %v", namespace, pod, err)
+		return k8s.RoutingAnnotations{}, nil, err
+	}
+	// Without the override the plugin's configuration decides, as if it had failed to read it
+	strict, err := s.resolver.StrictOverride(ctx, namespace, s.timeout)
+	if err != nil {
+		log.Warnf("strict mode of namespace %s unknown: %v", namespace, err)
+	}
+	return annotations, strict, nil
+}
+
+func (s *Server) recordAttachment(w http.ResponseWriter, r *http.Request) {
+	var attachment Attachment
+	if !decode(w, r, &attachment) {
+		return
+	}
+	if !validKey(w, attachment.AttachmentKey) {
+		return
+	}
+	s.opts.Attachments.Record(attachment)
+	log.Debugf("recorded attachment %s/%s of pod %s/%s", attachment.ContainerID, attachment.IfName,
+		attachment.Namespace, attachment.Pod)
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) releaseAttachment(w http.ResponseWriter, r *http.Request) {
+	var key client.AttachmentKey
+	if !decode(w, r, &key) {
+		return
+	}
+	if !validKey(w, key) {
+		return
+	}
+	var released client.Released
+	if attachment, ok := s.opts.Attachments.Release(key); ok {
+		released.Attachment = &attachment
+	}
+	writeJSON(w, http.StatusOK, released)
+}
+
+func (s *Server) configStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	status := s.config
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, status)
+}
+
+// decode reads the JSON body of an RPC into v, answering malformed requests
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, Error{Message: "use POST"})
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, Error{Message: "invalid request: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// maxRequestBytes bounds RPC bodies; an attachment is a few hundred bytes
+const maxRequestBytes = 64 << 10
+
+// validKey answers a request for an incomplete attachment key
+func validKey(w http.ResponseWriter, key client.AttachmentKey) bool {
+	if !complete(key) {
+		writeJSON(w, http.StatusBadRequest, Error{Message: "network, containerID and ifName are required"})
+		return false
+	}
+	return true
+}
+
+// complete reports whether key identifies an attachment
+func complete(key client.AttachmentKey) bool {
+	return key.Network != "" && key.ContainerID != "" && key.IfName != ""
+}
+
+// writeError answers err with its kind
+func writeError(w http.ResponseWriter, err error) {
+	kind, status := errorKind(err), http.StatusBadGateway
+	switch kind {
+	case KindInvalidFwmark, KindInvalidGateway:
+		status = http.StatusUnprocessableEntity
+	case KindNotFound:
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, Error{Kind: kind, Message: err.Error()})
+}
+
+// errorKind returns the kind of a failed lookup; KindUnavailable if the API server failed
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, k8s.ErrInvalidFwmark):
+		return KindInvalidFwmark
+	case errors.Is(err, k8s.ErrInvalidGateway):
+		return KindInvalidGateway
+	case errors.Is(err, tenant.ErrNotFound):
+		return KindNotFound
+	default:
+		return KindUnavailable
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
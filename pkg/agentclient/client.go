@@ -0,0 +1,225 @@
+// Package agentclient is the CNI plugin's client of the node agent.
+//
+// tenant-routingd serves the plugin the ttrpc service of api/agent/v1 on agentSocket.
+// This package calls it with the plugin's types and restores the errors the plugin
+// classifies (see pkg/tenant); it links the protobuf runtime and ttrpc, not the
+// Kubernetes libraries:
+//
+//	c := agentclient.New(conf.AgentSocket)
+//	annotations, strict, err := c.ResolveTenant(ctx, "web", "team-a", podUID)
+//	if errors.Is(err, agentclient.ErrUnavailable) {
+//		// ask the API server instead
+//	}
+package agentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	agentv1 "github.com/azalio/kubeCon-cni-wrapper/api/agent/v1"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// DefaultSocket is where tenant-routingd serves the plugin by default
+const DefaultSocket = "/run/tenant-routing/agent.sock"
+
+// ErrUnavailable is returned (use errors.Is) when the agent cannot be reached or does
+// not serve the method; the caller should then look the pod up itself
+var ErrUnavailable = errors.New("node agent unavailable")
+
+// remoteError carries the message of a failed call and unwraps to its kind
+type remoteError struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.kind }
+
+// Client calls the agent listening on one socket
+// Every call dials the socket: a CNI invocation makes one or two calls.
+type Client struct {
+	socket string
+}
+
+// New returns a client of the agent listening on socket
+func New(socket string) *Client {
+	return &Client{socket: socket}
+}
+
+// call runs fn with a service client on a new connection
+func (c *Client) call(ctx context.Context, fn func(agentv1.AgentService) error) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.socket)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	client := ttrpc.NewClient(conn)
+	defer client.Close()
+	return fn(agentv1.NewAgentClient(client))
+}
+
+// ResolveTenant asks the agent for the routing annotations of a pod, read with the
+// agent's annotation keys, and the strict-mode override of its namespace
+// Errors match the sentinels of pkg/tenant (not found, invalid fwmark or gateway); an
+// unreachable agent returns ErrUnavailable.
+func (c *Client) ResolveTenant(ctx context.Context, podName, podNamespace, podUID string) (tenant.RoutingAnnotations, *bool, error) {
+	var resp *agentv1.ResolveTenantResponse
+	err := c.call(ctx, func(agent agentv1.AgentService) (err error) {
+		resp, err = agent.ResolveTenant(ctx, &agentv1.ResolveTenantRequest{Namespace: podNamespace, Pod: podName, PodUid: podUID})
+		return err
+	})
+	if err != nil {
+		return tenant.RoutingAnnotations{}, nil, classify(err)
+	}
+	return FromAnnotations(resp.Annotations), resp.Strict, nil
+}
+
+// StrictOverride asks the agent for the strict-mode annotation of a namespace
+func (c *Client) StrictOverride(ctx context.Context, namespace string) (*bool, error) {
+	var resp *agentv1.StrictOverrideResponse
+	err := c.call(ctx, func(agent agentv1.AgentService) (err error) {
+		resp, err = agent.StrictOverride(ctx, &agentv1.StrictOverrideRequest{Namespace: namespace})
+		return err
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+	return resp.Strict, nil
+}
+
+// RecordAttachment reports what ADD set up for an attachment
+func (c *Client) RecordAttachment(ctx context.Context, rec *state.Record) error {
+	err := c.call(ctx, func(agent agentv1.AgentService) error {
+		_, err := agent.RecordAttachment(ctx, &agentv1.RecordAttachmentRequest{Attachment: FromRecord(rec)})
+		return err
+	})
+	return classify(err)
+}
+
+// ReleaseAttachment reports the DEL of an attachment and returns what the agent had
+// recorded for it, or nil if nothing
+func (c *Client) ReleaseAttachment(ctx context.Context, network, containerID, ifName string) (*state.Record, error) {
+	var resp *agentv1.ReleaseAttachmentResponse
+	err := c.call(ctx, func(agent agentv1.AgentService) (err error) {
+		resp, err = agent.ReleaseAttachment(ctx, &agentv1.ReleaseAttachmentRequest{
+			Key: &agentv1.AttachmentKey{Network: network, ContainerId: containerID, IfName: ifName}})
+		return err
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+	if resp.Attachment == nil {
+		return nil, nil
+	}
+	return ToRecord(resp.Attachment), nil
+}
+
+// classify restores the sentinel a failed call is classified by
+func classify(err error) error {
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	if errors.Is(err, ttrpc.ErrClosed) {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if st.Code() == codes.Unimplemented {
+		// An agent older than the method
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	var kind error
+	for _, detail := range st.Details() {
+		if detail, ok := detail.(*agentv1.ErrorDetail); ok {
+			switch detail.Kind {
+			case agentv1.ErrorKind_ERROR_KIND_NOT_FOUND:
+				kind = tenant.ErrNotFound
+			case agentv1.ErrorKind_ERROR_KIND_INVALID_FWMARK:
+				kind = tenant.ErrInvalidFwmark
+			case agentv1.ErrorKind_ERROR_KIND_INVALID_GATEWAY:
+				kind = tenant.ErrInvalidGateway
+			}
+		}
+	}
+	return &remoteError{msg: st.Message(), kind: kind}
+}
+
+// FromAnnotations returns the routing annotations of their wire form
+func FromAnnotations(answer *agentv1.Annotations) tenant.RoutingAnnotations {
+	annotations := tenant.RoutingAnnotations{Fwmark: answer.GetFwmark(), Gateway: answer.GetGateway(),
+		TenantName: answer.GetTenant(), Table: int(answer.GetTable()), Source: tenant.Source(answer.GetSource()),
+		PodUID: answer.GetPodUid(), Excluded: answer.GetExcluded(), Terminated: answer.GetTerminated()}
+	if answer.GetBypassUntil() != nil {
+		annotations.BypassUntil = answer.GetBypassUntil().AsTime()
+	}
+	if answer.GetBypassError() != "" {
+		annotations.BypassError = errors.New(answer.GetBypassError())
+	}
+	if answer.GetTerminatedAt() != nil {
+		annotations.TerminatedAt = answer.GetTerminatedAt().AsTime()
+	}
+	if answer.GetFallbackError() != "" {
+		annotations.FallbackError = errors.New(answer.GetFallbackError())
+	}
+	return annotations
+}
+
+// ToAnnotations returns the wire form of annotations
+func ToAnnotations(annotations tenant.RoutingAnnotations) *agentv1.Annotations {
+	answer := &agentv1.Annotations{Fwmark: annotations.Fwmark, Gateway: annotations.Gateway,
+		Tenant: annotations.TenantName, Table: int32(annotations.Table), Source: string(annotations.Source),
+		PodUid: annotations.PodUID, Excluded: annotations.Excluded, Terminated: annotations.Terminated}
+	if !annotations.BypassUntil.IsZero() {
+		answer.BypassUntil = timestamppb.New(annotations.BypassUntil)
+	}
+	if annotations.BypassError != nil {
+		answer.BypassError = annotations.BypassError.Error()
+	}
+	if !annotations.TerminatedAt.IsZero() {
+		answer.TerminatedAt = timestamppb.New(annotations.TerminatedAt)
+	}
+	if annotations.FallbackError != nil {
+		answer.FallbackError = annotations.FallbackError.Error()
+	}
+	return answer
+}
+
+// FromRecord returns the wire form of the attachment a state record describes
+func FromRecord(rec *state.Record) *agentv1.Attachment {
+	return &agentv1.Attachment{
+		Key:       &agentv1.AttachmentKey{Network: rec.Network, ContainerId: rec.ContainerID, IfName: rec.IfName},
+		Namespace: rec.Namespace,
+		Pod:       rec.Pod,
+		PodUid:    rec.PodUID,
+		Ips:       rec.IPs,
+		Fwmark:    rec.Fwmark,
+		Gateway:   rec.Gateway,
+	}
+}
+
+// ToRecord returns a state record of attachment with the fields the agent keeps
+func ToRecord(attachment *agentv1.Attachment) *state.Record {
+	key := attachment.GetKey()
+	return &state.Record{
+		Network:     key.GetNetwork(),
+		ContainerID: key.GetContainerId(),
+		IfName:      key.GetIfName(),
+		Namespace:   attachment.GetNamespace(),
+		Pod:         attachment.GetPod(),
+		PodUID:      attachment.GetPodUid(),
+		IPs:         attachment.GetIps(),
+		Fwmark:      attachment.GetFwmark(),
+		Gateway:     attachment.GetGateway(),
+	}
+}
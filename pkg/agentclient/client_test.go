@@ -0,0 +1,46 @@
+package agentclient
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// TestClient_Unavailable verifies a missing agent is reported as ErrUnavailable
+func TestClient_Unavailable(t *testing.T) {
+	client, ctx := New(filepath.Join(t.TempDir(), "missing.sock")), context.Background()
+	if _, _, err := client.ResolveTenant(ctx, "web", "team-a", ""); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("ResolveTenant() error = %v, want ErrUnavailable", err)
+	}
+	if err := client.RecordAttachment(ctx, &state.Record{Network: "tenant-net"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("RecordAttachment() error = %v, want ErrUnavailable", err)
+	}
+}
+
+// TestAnnotations verifies annotations survive their wire form
+func TestAnnotations(t *testing.T) {
+	annotations := tenant.RoutingAnnotations{Fwmark: "0x10", Gateway: "10.10.10.131", TenantName: "tenant-a", Table: 100,
+		Source: tenant.SourceNamespace, PodUID: "3f1c0b7e-web", BypassUntil: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		BypassError: errors.New("bypass-until value 'soon' is not an RFC3339 timestamp"), Terminated: true,
+		TerminatedAt: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC), FallbackError: errors.New("Kubernetes API unavailable")}
+	got := FromAnnotations(ToAnnotations(annotations))
+	if got.BypassError == nil || got.BypassError.Error() != annotations.BypassError.Error() {
+		t.Errorf("BypassError = %v, want %v", got.BypassError, annotations.BypassError)
+	}
+	if got.FallbackError == nil || got.FallbackError.Error() != annotations.FallbackError.Error() {
+		t.Errorf("FallbackError = %v, want %v", got.FallbackError, annotations.FallbackError)
+	}
+	got.BypassError, annotations.BypassError = nil, nil
+	got.FallbackError, annotations.FallbackError = nil, nil
+	if got != annotations {
+		t.Errorf("FromAnnotations(ToAnnotations()) = %+v, want %+v", got, annotations)
+	}
+	if got := FromAnnotations(ToAnnotations(tenant.RoutingAnnotations{})); !got.BypassUntil.IsZero() || !got.TerminatedAt.IsZero() {
+		t.Errorf("zero BypassUntil and TerminatedAt became %v and %v", got.BypassUntil, got.TerminatedAt)
+	}
+}
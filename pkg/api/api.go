@@ -0,0 +1,87 @@
+// Package api is the stable contract between the tenant routing plugin and the
+// controllers, admission webhooks and tools that annotate pods and namespaces for it.
+//
+// It holds the annotation keys the plugin reads and writes, their values, the reason
+// codes of its skips and failures, the reasons of the events it records, and helpers
+// that parse annotation values exactly as the plugin does. It depends on the standard
+// library only, so integrators do not pull in the plugin's internals:
+//
+//	pod.Annotations[api.FwmarkAnnotationKey] = api.FormatFwmark(0x10)
+//
+//	if _, err := api.ParseFwmark(value); errors.Is(err, api.ErrInvalidFwmark) {
+//		// reject the pod before the plugin skips it with api.ReasonInvalidFwmark
+//	}
+//
+// The plugin packages take their constants from here; a change to a value in this
+// package is a change of the contract.
+package api
+
+import "time"
+
+// Annotation keys of pods and namespaces
+// The fwmark and gateway keys are defaults; the network configuration may rename them
+// (annotationKey, gatewayAnnotationKey).
+const (
+	// FwmarkAnnotationKey holds the tenant fwmark of a pod, or of every pod of a
+	// namespace that does not set its own (see ParseFwmark)
+	FwmarkAnnotationKey = "tenant.routing/fwmark"
+
+	// GatewayAnnotationKey holds the egress gateway of a pod or namespace, overriding
+	// the configured one of its tenant (see ParseGateway)
+	GatewayAnnotationKey = "tenant.routing/gateway"
+
+	// TenantNameAnnotationKey names the TenantRoute of a pod or namespace, as an
+	// alternative to annotating the raw fwmark
+	TenantNameAnnotationKey = "tenant.routing/name"
+
+	// BypassAnnotationKey exempts a pod from marking until an RFC3339 time at most
+	// MaxBypassDuration ahead (see ParseBypassUntil)
+	BypassAnnotationKey = "tenant.routing/bypass-until"
+
+	// StrictAnnotationKey overrides the configured strict mode of a namespace; only
+	// read from namespaces, so a pod cannot opt itself out (see ParseStrict)
+	StrictAnnotationKey = "tenant.routing/strict"
+)
+
+// Annotation keys of nodes
+const (
+	// ConfigHashAnnotationKey holds the fingerprint of the configuration last applied
+	// on the node
+	ConfigHashAnnotationKey = "tenant.routing/config-hash"
+
+	// ConfirmConfigAnnotationKey approves a destructive configuration reload of the
+	// node agent: it must hold the fingerprint of the new configuration
+	ConfirmConfigAnnotationKey = "tenant.routing/confirm-config"
+)
+
+// TenantRoutingReadyCondition is the node condition reporting whether tenant routing
+// works on the node
+const TenantRoutingReadyCondition = "TenantRoutingReady"
+
+// MaxBypassDuration bounds how far in the future a bypass may end
+// Longer bypasses are rejected so a forgotten annotation cannot exempt a pod indefinitely
+const MaxBypassDuration = 24 * time.Hour
+
+// TenantMask covers the mark bits of tenant fwmarks
+const TenantMask uint32 = 0xff
+
+// ValidFwmarkValues are the fwmark annotation values the plugin accepts
+var ValidFwmarkValues = map[string]bool{
+	"0x10": true, // Tenant A
+	"0x20": true, // Tenant B
+}
+
+// Reasons of the events the plugin records on pods
+const (
+	// MarkAppliedEventReason: a running pod got its tenant mark after the fact (Normal)
+	MarkAppliedEventReason = "TenantMarkApplied"
+
+	// RoutingFailedEventReason: a rule or route of the pod could not be installed (Warning)
+	RoutingFailedEventReason = "TenantRoutingFailed"
+
+	// InvalidFwmarkEventReason: the fwmark annotation of the pod or its namespace is not allowed (Warning)
+	InvalidFwmarkEventReason = "InvalidFwmarkAnnotation"
+
+	// InvalidGatewayEventReason: the gateway annotation of the pod or its namespace is not usable (Warning)
+	InvalidGatewayEventReason = "InvalidGatewayAnnotation"
+)
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Sentinels classifying invalid annotation values (use errors.Is)
+var (
+	ErrInvalidFwmark  = errors.New("invalid fwmark annotation")
+	ErrInvalidGateway = errors.New("invalid gateway annotation")
+)
+
+// InvalidError is an invalid annotation or TenantRoute value: it keeps the descriptive
+// message while unwrapping to the sentinel Kind (ErrInvalidFwmark or ErrInvalidGateway)
+type InvalidError struct {
+	Kind error
+	Msg  string
+}
+
+func (e *InvalidError) Error() string { return e.Msg }
+func (e *InvalidError) Unwrap() error { return e.Kind }
+
+// ParseFwmark parses a fwmark annotation value as the plugin does: it must be one of
+// ValidFwmarkValues, written exactly as listed there
+func ParseFwmark(value string) (uint32, error) {
+	if !ValidFwmarkValues[value] {
+		return 0, &InvalidError{Kind: ErrInvalidFwmark, Msg: fmt.Sprintf("fwmark value '%s' not in allowed set (0x10, 0x20)", value)}
+	}
+	mark, err := strconv.ParseUint(value, 0, 32)
+	if err != nil || mark == 0 {
+		return 0, &InvalidError{Kind: ErrInvalidFwmark, Msg: fmt.Sprintf("fwmark value '%s' is not a mark", value)}
+	}
+	return uint32(mark), nil
+}
+
+// FormatFwmark returns the annotation value of fwmark, e.g. "0x10"
+func FormatFwmark(fwmark uint32) string {
+	return fmt.Sprintf("0x%x", fwmark)
+}
+
+// ParseGateway parses a gateway annotation value: an IPv4 unicast address
+func ParseGateway(value string) (net.IP, error) {
+	ip := net.ParseIP(value).To4()
+	if ip == nil {
+		return nil, &InvalidError{Kind: ErrInvalidGateway, Msg: fmt.Sprintf("gateway value '%s' is not an IPv4 address", value)}
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return nil, &InvalidError{Kind: ErrInvalidGateway, Msg: fmt.Sprintf("gateway value '%s' is not a unicast address", value)}
+	}
+	return ip, nil
+}
+
+// ParseBypassUntil parses a bypass-until annotation value at time now
+// Expired timestamps are valid (the bypass simply ended); ones more than
+// MaxBypassDuration ahead are rejected.
+func ParseBypassUntil(value string, now time.Time) (time.Time, error) {
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bypass-until value '%s' is not an RFC3339 timestamp", value)
+	}
+	if until.Sub(now) > MaxBypassDuration {
+		return time.Time{}, fmt.Errorf("bypass-until value '%s' is more than %s in the future", value, MaxBypassDuration)
+	}
+	return until, nil
+}
+
+// FormatBypassUntil returns the bypass-until annotation value ending a bypass at until
+func FormatBypassUntil(until time.Time) string {
+	return until.UTC().Format(time.RFC3339)
+}
+
+// ParseStrict parses a strict annotation value ("true" or "false", as strconv.ParseBool)
+func ParseStrict(value string) (bool, error) {
+	strict, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("strict value '%s' is not a boolean", value)
+	}
+	return strict, nil
+}
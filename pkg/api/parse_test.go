@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestParseFwmark verifies only the allowed values parse, typed by ErrInvalidFwmark otherwise
+func TestParseFwmark(t *testing.T) {
+	if mark, err := ParseFwmark("0x20"); err != nil || mark != 0x20 {
+		t.Errorf("ParseFwmark(0x20) = 0x%x, %v", mark, err)
+	}
+	for _, value := range []string{"", "0x30", "32", "tenant-a", " 0x10"} {
+		if _, err := ParseFwmark(value); !errors.Is(err, ErrInvalidFwmark) {
+			t.Errorf("ParseFwmark(%q) error = %v, want ErrInvalidFwmark", value, err)
+		}
+	}
+	if got := FormatFwmark(0x10); !ValidFwmarkValues[got] {
+		t.Errorf("FormatFwmark(0x10) = %q, not a valid value", got)
+	}
+}
+
+// TestParseGateway verifies gateways must be IPv4 unicast addresses
+func TestParseGateway(t *testing.T) {
+	if ip, err := ParseGateway("10.10.10.131"); err != nil || ip.String() != "10.10.10.131" {
+		t.Errorf("ParseGateway() = %v, %v", ip, err)
+	}
+	for _, value := range []string{"", "fd00::1", "0.0.0.0", "127.0.0.1", "224.0.0.1", "255.255.255.255", "gw"} {
+		if _, err := ParseGateway(value); !errors.Is(err, ErrInvalidGateway) {
+			t.Errorf("ParseGateway(%q) error = %v, want ErrInvalidGateway", value, err)
+		}
+	}
+}
+
+// TestParseBypassUntil verifies the format round-trips and far-future bypasses are rejected
+func TestParseBypassUntil(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	until := now.Add(time.Hour)
+	if got, err := ParseBypassUntil(FormatBypassUntil(until), now); err != nil || !got.Equal(until) {
+		t.Errorf("ParseBypassUntil() = %v, %v; want %v", got, err, until)
+	}
+	if _, err := ParseBypassUntil(FormatBypassUntil(now.Add(-time.Hour)), now); err != nil {
+		t.Errorf("ParseBypassUntil() of an ended bypass error = %v", err)
+	}
+	for _, value := range []string{FormatBypassUntil(now.Add(MaxBypassDuration + time.Minute)), "tomorrow"} {
+		if _, err := ParseBypassUntil(value, now); err == nil {
+			t.Errorf("ParseBypassUntil(%q) expected error", value)
+		}
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	if strict, err := ParseStrict("true"); err != nil || !strict {
+		t.Errorf("ParseStrict(true) = %t, %v", strict, err)
+	}
+	if _, err := ParseStrict("yes"); err == nil {
+		t.Error("ParseStrict(yes) expected error")
+	}
+}
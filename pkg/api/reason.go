@@ -0,0 +1,71 @@
+package api
+
+// Reason categorizes why tenant routing was not (fully) applied to a pod
+// Skips in permissive mode and failures in strict mode carry exactly one, as
+// "reason=<code>" in the log line or error and as a metric label.
+type Reason string
+
+const (
+	// ReasonNoPodIP: the delegate assigned no address (L2-only delegate), nothing to mark
+	ReasonNoPodIP Reason = "NO_POD_IP"
+
+	// ReasonNoAnnotation: neither the pod nor its namespace carries a fwmark annotation
+	ReasonNoAnnotation Reason = "NO_ANNOTATION"
+
+	// ReasonK8sUnreachable: the Kubernetes client could not be created or the API lookup failed
+	ReasonK8sUnreachable Reason = "K8S_UNREACHABLE"
+
+	// ReasonPodNotFound: the API server does not know the pod (or its namespace)
+	ReasonPodNotFound Reason = "POD_NOT_FOUND"
+
+	// ReasonInvalidFwmark: the fwmark annotation value is not in the allowed set
+	ReasonInvalidFwmark Reason = "INVALID_FWMARK"
+
+	// ReasonInvalidGateway: the gateway annotation value is not a usable IPv4 unicast address
+	ReasonInvalidGateway Reason = "INVALID_GATEWAY"
+
+	// ReasonBypassed: the pod carries an active bypass-until annotation
+	ReasonBypassed Reason = "BYPASSED"
+
+	// ReasonExcluded: the pod's namespace or labels exclude it from tenant routing
+	ReasonExcluded Reason = "EXCLUDED"
+
+	// ReasonUnsafeSource: the pod IP is a node, loopback or link-local address
+	ReasonUnsafeSource Reason = "UNSAFE_SOURCE"
+
+	// ReasonIptablesFailed: programming a MARK, CONNMARK or OUTPUT rule failed
+	ReasonIptablesFailed Reason = "IPTABLES_FAILED"
+
+	// ReasonIptablesLocked: the xtables lock was held past the lock timeout; the rules
+	// are queued and installed later by GC
+	ReasonIptablesLocked Reason = "IPTABLES_LOCKED"
+
+	// ReasonNodeLocked: other invocations held the node lock past the lock timeout; the
+	// rules are queued and installed later by GC
+	ReasonNodeLocked Reason = "NODE_LOCKED"
+
+	// ReasonRoutingFailed: tenant policy routing could not be configured
+	ReasonRoutingFailed Reason = "ROUTING_FAILED"
+)
+
+// Reasons lists every reason code
+var Reasons = []Reason{
+	ReasonNoPodIP,
+	ReasonNoAnnotation,
+	ReasonK8sUnreachable,
+	ReasonPodNotFound,
+	ReasonInvalidFwmark,
+	ReasonInvalidGateway,
+	ReasonBypassed,
+	ReasonExcluded,
+	ReasonUnsafeSource,
+	ReasonIptablesFailed,
+	ReasonIptablesLocked,
+	ReasonNodeLocked,
+	ReasonRoutingFailed,
+}
+
+// String returns the code as written to logs and metric labels
+func (r Reason) String() string {
+	return string(r)
+}
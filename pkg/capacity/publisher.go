@@ -0,0 +1,201 @@
+// Package capacity publishes per-node tenant slot capacity to the Kubernetes scheduler.
+//
+// Tenants with hard gateway bandwidth limits can only host a bounded number of pods
+// per node. The Publisher exposes that bound in two complementary ways:
+//
+//   - Extended resource tenant.routing/<tenant>-slots in node status capacity/allocatable.
+//     Pods request one slot; the scheduler enforces the hard limit.
+//   - Node label tenant.routing/<tenant>-slots-free with the remaining slot count
+//     derived from the pods currently marked on the node. Workloads can use it
+//     with nodeAffinity (operator Gt) to spread before the hard limit is hit.
+//
+// Node updates use optimistic concurrency: on a resourceVersion conflict the node
+// is re-read and the change re-applied (kubelet updates node status concurrently).
+package capacity
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/logging"
+)
+
+const (
+	// ResourceDomain is the prefix for extended resources and labels owned by tenant routing
+	ResourceDomain = "tenant.routing"
+
+	// DefaultUpdateInterval is how often Run republishes utilization when no interval is given
+	DefaultUpdateInterval = 30 * time.Second
+)
+
+// logger tags the publisher's log lines
+var logger = logging.Component("capacity")
+
+// tenantNamePattern restricts tenant names to characters valid in both
+// extended resource names and label keys (DNS-1123 label, lowercase)
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Counter returns the number of pods per tenant currently placed on the node
+type Counter func() (map[string]int64, error)
+
+// Publisher maintains tenant slot resources and labels on a single node
+type Publisher struct {
+	client   kubernetes.Interface
+	nodeName string
+	limits   map[string]int64
+}
+
+// NewPublisher creates a Publisher for nodeName with per-tenant slot limits
+//
+// Returns error if node name is empty, a tenant name is not a valid DNS-1123 label,
+// or a limit is negative
+func NewPublisher(client kubernetes.Interface, nodeName string, limits map[string]int64) (*Publisher, error) {
+	if client == nil {
+		return nil, fmt.Errorf("kubernetes client is required")
+	}
+	if nodeName == "" {
+		return nil, fmt.Errorf("node name is required")
+	}
+
+	copied := make(map[string]int64, len(limits))
+	for tenant, limit := range limits {
+		if !tenantNamePattern.MatchString(tenant) {
+			return nil, fmt.Errorf("invalid tenant name %q: must be a lowercase DNS-1123 label", tenant)
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("invalid slot limit %d for tenant %q: must be >= 0", limit, tenant)
+		}
+		copied[tenant] = limit
+	}
+
+	return &Publisher{client: client, nodeName: nodeName, limits: copied}, nil
+}
+
+// ResourceName returns the extended resource name for a tenant (e.g. tenant.routing/tenant-a-slots)
+func ResourceName(tenant string) corev1.ResourceName {
+	return corev1.ResourceName(fmt.Sprintf("%s/%s-slots", ResourceDomain, tenant))
+}
+
+// FreeSlotsLabel returns the node label key holding remaining slots for a tenant
+func FreeSlotsLabel(tenant string) string {
+	return fmt.Sprintf("%s/%s-slots-free", ResourceDomain, tenant)
+}
+
+// Publish updates node capacity and free-slot labels from the given per-tenant pod counts
+// Tenants without a configured limit are ignored; missing counts are treated as zero
+func (p *Publisher) Publish(ctx context.Context, used map[string]int64) error {
+	// Status and metadata are separate subresources, so they are updated independently.
+	// Each update is retried on conflict against a freshly read node object.
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := p.client.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !p.applyCapacity(node) {
+			return nil
+		}
+		_, err = p.client.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{})
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to update capacity for node %s: %w", p.nodeName, err)
+	}
+
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := p.client.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !p.applyLabels(node, used) {
+			return nil
+		}
+		_, err = p.client.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to update slot labels for node %s: %w", p.nodeName, err)
+	}
+
+	return nil
+}
+
+// Run republishes utilization every interval until ctx is cancelled
+// Errors are logged and retried on the next tick; a single failure never stops the loop
+func (p *Publisher) Run(ctx context.Context, interval time.Duration, count Counter) {
+	if interval <= 0 {
+		interval = DefaultUpdateInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		used, err := count()
+		if err != nil {
+			logger.Warnf("failed to count tenant pods on node %s: %v", p.nodeName, err)
+		} else if err := p.Publish(ctx, used); err != nil {
+			logger.Warnf("failed to publish tenant capacity: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyCapacity sets the extended resources on node status
+// Returns true if the node was modified
+func (p *Publisher) applyCapacity(node *corev1.Node) bool {
+	if node.Status.Capacity == nil {
+		node.Status.Capacity = corev1.ResourceList{}
+	}
+	if node.Status.Allocatable == nil {
+		node.Status.Allocatable = corev1.ResourceList{}
+	}
+
+	changed := false
+	for tenant, limit := range p.limits {
+		name := ResourceName(tenant)
+		want := *resource.NewQuantity(limit, resource.DecimalSI)
+		for _, list := range []corev1.ResourceList{node.Status.Capacity, node.Status.Allocatable} {
+			if have, ok := list[name]; !ok || have.Cmp(want) != 0 {
+				list[name] = want
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// applyLabels sets the free-slot label for every configured tenant
+// Returns true if the node was modified
+func (p *Publisher) applyLabels(node *corev1.Node, used map[string]int64) bool {
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+
+	changed := false
+	for tenant, limit := range p.limits {
+		free := limit - used[tenant]
+		if free < 0 {
+			// Over-committed (e.g. limit lowered while pods are running) - never publish negative
+			free = 0
+		}
+		key := FreeSlotsLabel(tenant)
+		value := strconv.FormatInt(free, 10)
+		if node.Labels[key] != value {
+			node.Labels[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
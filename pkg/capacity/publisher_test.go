@@ -0,0 +1,154 @@
+package capacity
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newNode(name string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+// TestNewPublisher_Validation verifies constructor input validation
+func TestNewPublisher_Validation(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	tests := []struct {
+		name     string
+		nodeName string
+		limits   map[string]int64
+		errMsg   string
+	}{
+		{name: "empty node name", nodeName: "", limits: nil, errMsg: "node name is required"},
+		{name: "uppercase tenant", nodeName: "node1", limits: map[string]int64{"TenantA": 1}, errMsg: "invalid tenant name"},
+		{name: "tenant with slash", nodeName: "node1", limits: map[string]int64{"a/b": 1}, errMsg: "invalid tenant name"},
+		{name: "negative limit", nodeName: "node1", limits: map[string]int64{"tenant-a": -1}, errMsg: "invalid slot limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPublisher(client, tt.nodeName, tt.limits)
+			if err == nil {
+				t.Fatal("expected error but got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %q, want to contain %q", err.Error(), tt.errMsg)
+			}
+		})
+	}
+}
+
+// TestPublish_SetsCapacityAndLabels verifies extended resources and free-slot labels
+func TestPublish_SetsCapacityAndLabels(t *testing.T) {
+	client := fake.NewSimpleClientset(newNode("node1"))
+	pub, err := NewPublisher(client, "node1", map[string]int64{"tenant-a": 10, "tenant-b": 2})
+	if err != nil {
+		t.Fatalf("NewPublisher() error: %v", err)
+	}
+
+	// tenant-b is over-committed: free slots must clamp at zero
+	if err := pub.Publish(context.Background(), map[string]int64{"tenant-a": 3, "tenant-b": 5, "unknown": 7}); err != nil {
+		t.Fatalf("Publish() error: %v", err)
+	}
+
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get node: %v", err)
+	}
+
+	capA := node.Status.Capacity[ResourceName("tenant-a")]
+	if capA.Value() != 10 {
+		t.Errorf("capacity tenant-a = %d, want 10", capA.Value())
+	}
+	allocB := node.Status.Allocatable[ResourceName("tenant-b")]
+	if allocB.Value() != 2 {
+		t.Errorf("allocatable tenant-b = %d, want 2", allocB.Value())
+	}
+	if got := node.Labels[FreeSlotsLabel("tenant-a")]; got != "7" {
+		t.Errorf("free slots tenant-a = %q, want \"7\"", got)
+	}
+	if got := node.Labels[FreeSlotsLabel("tenant-b")]; got != "0" {
+		t.Errorf("free slots tenant-b = %q, want \"0\"", got)
+	}
+	if _, ok := node.Labels[FreeSlotsLabel("unknown")]; ok {
+		t.Error("label published for tenant without configured limit")
+	}
+}
+
+// TestPublish_RetriesOnConflict verifies optimistic concurrency conflicts are retried
+func TestPublish_RetriesOnConflict(t *testing.T) {
+	client := fake.NewSimpleClientset(newNode("node1"))
+
+	conflicts := 0
+	client.PrependReactor("update", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts < 2 {
+			conflicts++
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "node1", nil)
+		}
+		return false, nil, nil
+	})
+
+	pub, err := NewPublisher(client, "node1", map[string]int64{"tenant-a": 4})
+	if err != nil {
+		t.Fatalf("NewPublisher() error: %v", err)
+	}
+
+	if err := pub.Publish(context.Background(), map[string]int64{"tenant-a": 1}); err != nil {
+		t.Fatalf("Publish() should succeed after conflicts, got: %v", err)
+	}
+	if conflicts != 2 {
+		t.Errorf("conflicts = %d, want 2", conflicts)
+	}
+
+	node, _ := client.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	if got := node.Labels[FreeSlotsLabel("tenant-a")]; got != "3" {
+		t.Errorf("free slots tenant-a = %q, want \"3\"", got)
+	}
+}
+
+// TestPublish_MissingNode verifies errors are surfaced when the node does not exist
+func TestPublish_MissingNode(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	pub, err := NewPublisher(client, "node1", map[string]int64{"tenant-a": 1})
+	if err != nil {
+		t.Fatalf("NewPublisher() error: %v", err)
+	}
+
+	if err := pub.Publish(context.Background(), nil); err == nil {
+		t.Fatal("expected error for missing node")
+	}
+}
+
+// TestRun_StopsOnCancel verifies the update loop publishes and exits on context cancellation
+func TestRun_StopsOnCancel(t *testing.T) {
+	client := fake.NewSimpleClientset(newNode("node1"))
+	pub, err := NewPublisher(client, "node1", map[string]int64{"tenant-a": 5})
+	if err != nil {
+		t.Fatalf("NewPublisher() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	pub.Run(ctx, 0, func() (map[string]int64, error) {
+		calls++
+		cancel()
+		return map[string]int64{"tenant-a": 2}, nil
+	})
+
+	if calls != 1 {
+		t.Errorf("counter calls = %d, want 1", calls)
+	}
+	node, _ := client.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	if got := node.Labels[FreeSlotsLabel("tenant-a")]; got != "3" {
+		t.Errorf("free slots tenant-a = %q, want \"3\"", got)
+	}
+}
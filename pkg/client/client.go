@@ -0,0 +1,294 @@
+// Package client is the Go client of the tenant-routingd API.
+//
+// The API is specified in api/openapi.yaml; this package defines its wire types and
+// calls it over the agent's unix socket. It has no dependency on the plugin's
+// internals, so tools outside the CNI path can query the agent with it:
+//
+//	c := client.New(client.DefaultSocket)
+//	annotations, err := c.Annotations(ctx, client.AnnotationsRequest{
+//		Namespace: "team-a", Pod: "web", FwmarkKeys: []string{"tenant.routing/fwmark"}})
+//
+// Besides the lookups, the API serves the methods of the CNI plugin's ttrpc service
+// (see pkg/agentclient): ResolveTenant answers the annotations and strict mode of a
+// pod in one round-trip with the agent's own annotation keys, and RecordAttachment and
+// ReleaseAttachment keep the agent's table of the attachments the plugin set up.
+// Config reports the configuration the agent runs with and the impact preview of its
+// last reload.
+//
+// Failed calls return an *APIError; an unreachable agent returns ErrUnavailable.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// APIVersion is the version of the API this package speaks, the prefix of its paths
+const APIVersion = "v1"
+
+// DefaultSocket is where tenant-routingd serves the API by default
+const DefaultSocket = "/run/tenant-routing/api.sock"
+
+// Error kinds of an APIError
+const (
+	KindNotFound       = "NotFound"
+	KindInvalidFwmark  = "InvalidFwmark"
+	KindInvalidGateway = "InvalidGateway"
+	KindUnavailable    = "Unavailable"
+)
+
+// Annotations is the answer to an annotation lookup
+// Tenant and Table are set if the fwmark was resolved from a tenant name (TenantRoute);
+// Source is where the fwmark was found: "pod", "namespace" or "namespace-labels".
+type Annotations struct {
+	Fwmark      string    `json:"fwmark,omitempty"`
+	Gateway     string    `json:"gateway,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Table       int       `json:"table,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	PodUID      string    `json:"podUID,omitempty"`
+	BypassUntil time.Time `json:"bypassUntil,omitempty"`
+	BypassError string    `json:"bypassError,omitempty"`
+	Excluded    bool      `json:"excluded,omitempty"`
+
+	Terminated    bool      `json:"terminated,omitempty"`
+	TerminatedAt  time.Time `json:"terminatedAt,omitempty"`
+	FallbackError string    `json:"fallbackError,omitempty"`
+}
+
+// Strict is the answer to a strict-mode lookup; Strict is nil if the namespace does not set it
+type Strict struct {
+	Strict *bool `json:"strict,omitempty"`
+}
+
+// Error is the body of a failed lookup
+type Error struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// ResolveTenantRequest selects the pod of a ResolveTenant call
+// PodUID is optional; the agent reports the UID of the pod it found in Tenant.
+type ResolveTenantRequest struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	PodUID    string `json:"podUID,omitempty"`
+}
+
+// Tenant is the answer to ResolveTenant: the annotations of the pod and the
+// strict-mode override of its namespace (nil if the namespace does not set it)
+type Tenant struct {
+	Annotations
+	Strict *bool `json:"strict,omitempty"`
+}
+
+// AttachmentKey identifies an attachment the way the runtime does
+type AttachmentKey struct {
+	Network     string `json:"network"`
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName"`
+}
+
+// Attachment is what the CNI plugin set up for one attachment
+type Attachment struct {
+	AttachmentKey
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod"`
+	PodUID    string   `json:"podUID,omitempty"`
+	IPs       []string `json:"ips"`
+	Fwmark    string   `json:"fwmark,omitempty"`
+	Gateway   string   `json:"gateway,omitempty"`
+}
+
+// Released is the answer to ReleaseAttachment; Attachment is nil if the agent did not know it
+type Released struct {
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+// ConfigImpact previews what a configuration reload of the agent changes on the node
+// The rule counts are per-pod MARK, CONNMARK and OUTPUT rules; tenants are fwmarks
+// whose policy routing table is added, removed or changed; Unmarked lists the pods
+// (namespace/name) losing their MARK rule.
+type ConfigImpact struct {
+	From           string   `json:"from"`
+	To             string   `json:"to"`
+	RulesAdded     int      `json:"rulesAdded"`
+	RulesRemoved   int      `json:"rulesRemoved"`
+	TenantsAdded   []string `json:"tenantsAdded,omitempty"`
+	TenantsRemoved []string `json:"tenantsRemoved,omitempty"`
+	TenantsChanged []string `json:"tenantsChanged,omitempty"`
+	Unmarked       []string `json:"unmarked,omitempty"`
+	Destructive    bool     `json:"destructive"`
+}
+
+// ConfigStatus is the answer to Config: the fingerprint of the applied configuration
+// and of one held for confirmation, if any. Impact is that of the pending reload, else
+// of the last applied one (nil before the first reload).
+type ConfigStatus struct {
+	Applied string        `json:"applied"`
+	Pending string        `json:"pending,omitempty"`
+	Impact  *ConfigImpact `json:"impact,omitempty"`
+}
+
+// AnnotationsRequest selects the pod and annotation keys of an annotation lookup
+// GatewayKey may be empty to disable gateways.
+type AnnotationsRequest struct {
+	Namespace  string
+	Pod        string
+	FwmarkKeys []string
+	GatewayKey string
+}
+
+// ErrUnavailable is returned (use errors.Is) when the agent cannot be reached or
+// answers outside the API
+var ErrUnavailable = errors.New("node agent unavailable")
+
+// APIError is a lookup the agent answered with an Error
+type APIError struct {
+	Status  int // HTTP status
+	Kind    string
+	Message string
+}
+
+func (e *APIError) Error() string { return e.Message }
+
+// Kind returns the error kind of err if it is an APIError, else ""
+func Kind(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return ""
+}
+
+// Client calls the API of one agent
+type Client struct {
+	http *http.Client
+}
+
+// New returns a client of the agent listening on socket
+func New(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport}}
+}
+
+// Annotations looks up the routing annotations of a pod (getAnnotations)
+func (c *Client) Annotations(ctx context.Context, req AnnotationsRequest) (*Annotations, error) {
+	query := url.Values{"namespace": {req.Namespace}, "pod": {req.Pod}, "fwmarkKey": req.FwmarkKeys,
+		"gatewayKey": {req.GatewayKey}}
+	var answer Annotations
+	if err := c.get(ctx, "/"+APIVersion+"/annotations", query, &answer); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// Strict looks up the strict-mode override of a namespace (getStrict)
+func (c *Client) Strict(ctx context.Context, namespace string) (*Strict, error) {
+	var answer Strict
+	if err := c.get(ctx, "/"+APIVersion+"/strict", url.Values{"namespace": {namespace}}, &answer); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// Config returns the configuration status of the agent and the impact of its last reload (getConfig)
+func (c *Client) Config(ctx context.Context) (*ConfigStatus, error) {
+	var answer ConfigStatus
+	if err := c.get(ctx, "/"+APIVersion+"/config", nil, &answer); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// Health returns nil if the agent is serving (getHealth)
+func (c *Client) Health(ctx context.Context) error {
+	return c.get(ctx, "/healthz", nil, nil)
+}
+
+// ResolveTenant looks up the annotations and strict mode of a pod (resolveTenant)
+func (c *Client) ResolveTenant(ctx context.Context, req ResolveTenantRequest) (*Tenant, error) {
+	var answer Tenant
+	if err := c.post(ctx, "/"+APIVersion+"/resolveTenant", req, &answer); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// RecordAttachment adds or replaces an attachment in the agent's table (recordAttachment)
+func (c *Client) RecordAttachment(ctx context.Context, attachment Attachment) error {
+	return c.post(ctx, "/"+APIVersion+"/recordAttachment", attachment, nil)
+}
+
+// ReleaseAttachment removes an attachment from the agent's table and returns what was
+// recorded for it, or nil if nothing was (releaseAttachment)
+func (c *Client) ReleaseAttachment(ctx context.Context, key AttachmentKey) (*Attachment, error) {
+	var answer Released
+	if err := c.post(ctx, "/"+APIVersion+"/releaseAttachment", key, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Attachment, nil
+}
+
+// get decodes the answer to a lookup into answer, unless answer is nil
+func (c *Client) get(ctx context.Context, path string, query url.Values, answer any) error {
+	target := "http://agent" + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, answer)
+}
+
+// post sends body as JSON and decodes the answer into answer, unless answer is nil
+func (c *Client) post(ctx context.Context, path string, body, answer any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://agent"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, answer)
+}
+
+// do sends req and decodes a successful answer into answer, unless answer is nil
+func (c *Client) do(req *http.Request, answer any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure Error
+		if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil {
+			return fmt.Errorf("%w: %s", ErrUnavailable, resp.Status)
+		}
+		return &APIError{Status: resp.StatusCode, Kind: failure.Kind, Message: failure.Message}
+	}
+	if answer == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(answer); err != nil {
+		return fmt.Errorf("%w: invalid answer: %v", ErrUnavailable, err)
+	}
+	return nil
+}
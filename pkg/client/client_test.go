@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+// spec is the part of api/openapi.yaml the tests check
+type spec struct {
+	Info  struct{ Version string } `json:"info"`
+	Paths map[string]map[string]struct {
+		OperationID string `json:"operationId"`
+		Parameters  []struct {
+			Name     string `json:"name"`
+			Required bool   `json:"required"`
+		} `json:"parameters"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) spec {
+	t.Helper()
+	data, err := os.ReadFile("../../api/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		t.Fatalf("api/openapi.yaml: %v", err)
+	}
+	return s
+}
+
+// jsonFields returns the JSON names of the fields of typ, including embedded ones
+func jsonFields(typ reflect.Type) []string {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		if field := typ.Field(i); field.Anonymous {
+			names = append(names, jsonFields(field.Type)...)
+		} else {
+			names = append(names, strings.Split(field.Tag.Get("json"), ",")[0])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestSpec verifies api/openapi.yaml describes the paths and wire types of this package
+func TestSpec(t *testing.T) {
+	s := loadSpec(t)
+	if s.Info.Version != APIVersion {
+		t.Errorf("spec version = %q, want %q", s.Info.Version, APIVersion)
+	}
+
+	operations := map[string][2]string{
+		"/" + APIVersion + "/annotations":       {"get", "getAnnotations"},
+		"/" + APIVersion + "/strict":            {"get", "getStrict"},
+		"/" + APIVersion + "/resolveTenant":     {"post", "resolveTenant"},
+		"/" + APIVersion + "/recordAttachment":  {"post", "recordAttachment"},
+		"/" + APIVersion + "/releaseAttachment": {"post", "releaseAttachment"},
+		"/" + APIVersion + "/config":            {"get", "getConfig"},
+		"/healthz":                              {"get", "getHealth"},
+	}
+	if len(s.Paths) != len(operations) {
+		t.Errorf("spec has %d paths, want %d", len(s.Paths), len(operations))
+	}
+	for path, op := range operations {
+		if got := s.Paths[path][op[0]].OperationID; got != op[1] {
+			t.Errorf("%s %s operationId = %q, want %q", op[0], path, got, op[1])
+		}
+	}
+	var params []string
+	for _, p := range s.Paths["/"+APIVersion+"/annotations"]["get"].Parameters {
+		params = append(params, p.Name)
+	}
+	sort.Strings(params)
+	if want := []string{"fwmarkKey", "gatewayKey", "namespace", "pod"}; !reflect.DeepEqual(params, want) {
+		t.Errorf("getAnnotations parameters = %v, want %v", params, want)
+	}
+
+	for name, v := range map[string]any{"Annotations": Annotations{}, "Strict": Strict{}, "Error": Error{},
+		"ResolveTenantRequest": ResolveTenantRequest{}, "Tenant": Tenant{}, "AttachmentKey": AttachmentKey{},
+		"Attachment": Attachment{}, "Released": Released{}, "ConfigImpact": ConfigImpact{}, "ConfigStatus": ConfigStatus{}} {
+		var props []string
+		for prop := range s.Components.Schemas[name].Properties {
+			props = append(props, prop)
+		}
+		sort.Strings(props)
+		if want := jsonFields(reflect.TypeOf(v)); !reflect.DeepEqual(props, want) {
+			t.Errorf("schema %s properties = %v, want %v", name, props, want)
+		}
+	}
+	kinds := s.Components.Schemas["Error"].Properties["kind"].Enum
+	sort.Strings(kinds)
+	if want := []string{"", KindInvalidFwmark, KindInvalidGateway, KindNotFound, KindUnavailable}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("error kinds = %v, want %v", kinds, want)
+	}
+}
+
+// TestClient verifies requests, answers and errors over a unix socket
+func TestClient(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/annotations", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("pod") != "web" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"NotFound","message":"pod team-a/` + q.Get("pod") + ` not found"}`))
+			return
+		}
+		w.Write([]byte(`{"fwmark":"0x10","gateway":"` + q.Get("gatewayKey") + `"}`))
+	})
+	mux.HandleFunc("/v1/strict", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"strict":true}`))
+	})
+	mux.HandleFunc("/healthz", func(http.ResponseWriter, *http.Request) {})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	c, ctx := New(socket), context.Background()
+	annotations, err := c.Annotations(ctx, AnnotationsRequest{Namespace: "team-a", Pod: "web", FwmarkKeys: []string{"f"}, GatewayKey: "g"})
+	if err != nil || annotations.Fwmark != "0x10" || annotations.Gateway != "g" {
+		t.Errorf("Annotations() = %+v, %v", annotations, err)
+	}
+	_, err = c.Annotations(ctx, AnnotationsRequest{Namespace: "team-a", Pod: "gone", FwmarkKeys: []string{"f"}})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound || Kind(err) != KindNotFound {
+		t.Errorf("Annotations() of a missing pod error = %#v, want a NotFound APIError", err)
+	}
+	if strict, err := c.Strict(ctx, "team-a"); err != nil || strict.Strict == nil || !*strict.Strict {
+		t.Errorf("Strict() = %+v, %v; want true", strict, err)
+	}
+	if err := c.Health(ctx); err != nil {
+		t.Errorf("Health() error = %v", err)
+	}
+
+	if err := New(filepath.Join(t.TempDir(), "missing.sock")).Health(ctx); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Health() of a missing agent error = %v, want ErrUnavailable", err)
+	}
+}
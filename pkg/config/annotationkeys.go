@@ -0,0 +1,53 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	k8svalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// AnnotationKeys are the annotation keys holding the fwmark, in priority order
+// In the configuration it is a single key or a list; a pod or namespace is assigned
+// the value of the first key set on it. The list lets a cluster honor a legacy key
+// while it migrates annotation schemes.
+type AnnotationKeys []string
+
+// UnmarshalJSON accepts a single key or a list of keys
+func (k *AnnotationKeys) UnmarshalJSON(data []byte) error {
+	var key string
+	if err := json.Unmarshal(data, &key); err == nil {
+		*k = AnnotationKeys{key}
+		return nil
+	}
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("annotationKey must be a string or a list of strings")
+	}
+	*k = keys
+	return nil
+}
+
+// MarshalJSON encodes a single key as a string, like it is usually configured
+func (k AnnotationKeys) MarshalJSON() ([]byte, error) {
+	if len(k) == 1 {
+		return json.Marshal(k[0])
+	}
+	return json.Marshal([]string(k))
+}
+
+// validateAnnotationKeys checks that every key is a distinct annotation key
+func validateAnnotationKeys(v *validation, keys AnnotationKeys) {
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if errs := k8svalidation.IsQualifiedName(key); len(errs) > 0 {
+			v.addf(pointer("annotationKey", i), "%q is not a valid annotation key: %s", key, strings.Join(errs, "; "))
+			continue
+		}
+		if seen[key] {
+			v.addf(pointer("annotationKey", i), "duplicate annotation key %q", key)
+		}
+		seen[key] = true
+	}
+}
@@ -1,17 +1,91 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/create"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/safepath"
 )
 
 const (
 	// DefaultAnnotationKey is the default Kubernetes annotation key for fwmark values
-	DefaultAnnotationKey = "tenant.routing/fwmark"
+	DefaultAnnotationKey = api.FwmarkAnnotationKey
+
+	// DefaultGatewayAnnotationKey is the default Kubernetes annotation key for tenant gateways
+	DefaultGatewayAnnotationKey = api.GatewayAnnotationKey
+
+	// DefaultStateDir is where per-container state records are kept by default
+	DefaultStateDir = "/var/lib/cni/tenant-routing"
+
+	// DefaultCNICacheDir is where libcni, and so containerd and CRI-O, caches ADD results
+	DefaultCNICacheDir = "/var/lib/cni"
+
+	// DefaultLockFile is the node lock serializing rule changes of concurrent invocations
+	DefaultLockFile = "/run/tenant-routing.lock"
+
+	// DefaultLockTimeout is how many seconds an invocation waits for the node lock by default
+	DefaultLockTimeout = 10
+
+	// MaxK8sRetryAttempts bounds k8sRetryAttempts; more attempts only delay the pod
+	MaxK8sRetryAttempts = 10
+
+	// MaxAnnotationCacheTTL bounds annotationCacheTTL and negativeAnnotationCacheTTL;
+	// annotation changes take up to the TTL to be seen, so longer TTLs trade correctness
+	// for little API load
+	MaxAnnotationCacheTTL = 300
+)
+
+// DefaultSecurePathRoots are the directories securePaths accepts paths below by default,
+// including the usual CNI_PATH the delegate binaries are found in
+var DefaultSecurePathRoots = []string{"/etc", "/var/lib", "/run", "/opt/cni/bin"}
+
+// NoIPs policies (see PluginConf.NoIPs)
+const (
+	// NoIPsSkip returns the delegate result unchanged without marking (permissive, default)
+	NoIPsSkip = "skip"
+
+	// NoIPsFail fails the ADD (strict)
+	NoIPsFail = "fail"
+)
+
+// prevResult policies (see PluginConf.PrevResultPolicy)
+const (
+	// PrevResultRequire fails CHECK and DEL invocations without a prevResult
+	PrevResultRequire = "require"
+
+	// PrevResultStateFallback takes the pod IP from the state record or the libcni
+	// result cache instead (default)
+	PrevResultStateFallback = "stateFallback"
+
+	// PrevResultSkip skips verification and cleanup without logging a warning
+	PrevResultSkip = "skip"
+)
+
+// ResultVersions are the CNI versions results can be printed in (see PluginConf.ResultVersion)
+var ResultVersions = []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0", "1.1.0"}
+
+// Log formats (see PluginConf.LogFormat)
+const (
+	// LogFormatText writes key=value lines (default)
+	LogFormatText = "text"
+
+	// LogFormatJSON writes one JSON object per line, for Loki/Elastic shippers
+	LogFormatJSON = "json"
 )
 
 // PluginConf represents the CNI plugin configuration
@@ -19,17 +93,276 @@ const (
 type PluginConf struct {
 	types.NetConf
 
+	// RuntimeConfig is the runtimeConfig block of this invocation; nil unless the runtime
+	// passed one, which libcni only does for the capabilities the wrapper declares
+	// (types.NetConf.Capabilities). Passed on to the delegate (see delegate.DelegateAdd)
+	RuntimeConfig *RuntimeConfig `json:"runtimeConfig,omitempty"`
+
 	// Kubeconfig path to Kubernetes API server credentials
 	// MUST be an absolute path (security: prevent path traversal)
+	// Optional with Tenants: without it the plugin never asks the API server
 	Kubeconfig string `json:"kubeconfig"`
 
-	// AnnotationKey specifies which pod annotation contains the fwmark value
+	// AnnotationKey specifies which pod annotations contain the fwmark value, a single
+	// key or a list in priority order (see AnnotationKeys)
 	// Defaults to DefaultAnnotationKey if not specified
-	AnnotationKey string `json:"annotationKey,omitempty"`
+	AnnotationKey AnnotationKeys `json:"annotationKey,omitempty"`
+
+	// GatewayAnnotationKey specifies which pod/namespace annotation contains the tenant gateway
+	// Only used when plugin-managed routing is enabled for the tenant fwmark
+	// Defaults to DefaultGatewayAnnotationKey if not specified
+	GatewayAnnotationKey string `json:"gatewayAnnotationKey,omitempty"`
+
+	// NamespaceLabels assigns tenant fwmarks by namespace label when neither a pod nor
+	// its namespace annotates the tenant; the first rule whose selector matches wins
+	NamespaceLabels []NamespaceLabelConf `json:"namespaceLabels,omitempty"`
+
+	// Tenants maps namespace names to their tenant, for clusters without tenant
+	// annotations: the pods of a listed namespace get its tenant when neither
+	// annotations nor NamespaceLabels assign one, and while the API server cannot be
+	// reached
+	Tenants map[string]TenantConf `json:"tenants,omitempty"`
+
+	// ExcludeNamespaces lists namespaces of infrastructure pods (e.g. kube-system) that
+	// are never looked up nor marked, whatever their annotations
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+
+	// ExcludePodLabelSelector is a Kubernetes label selector of infrastructure pods
+	// (e.g. "k8s-app in (cilium,kube-dns)") that are never marked; their namespace and
+	// TenantRoute are not looked up
+	ExcludePodLabelSelector string `json:"excludePodLabelSelector,omitempty"`
 
 	// Delegate contains the configuration for the next CNI plugin in the chain
 	// This is preserved as raw JSON to pass through unchanged
-	Delegate json.RawMessage `json:"delegate"`
+	// A JSON array runs several plugins in sequence, like a conflist (see delegate.IsChain)
+	// If omitted the wrapper runs as a chained plugin in a conflist (see Chained)
+	Delegate json.RawMessage `json:"delegate,omitempty"`
+
+	// DelegateFile is a CNI configuration file (.conf or .conflist) holding the delegate,
+	// e.g. the base network configuration an installer manages; ParseConfig loads it
+	// into Delegate. Mutually exclusive with Delegate
+	// MUST be an absolute path (same rules as Kubeconfig)
+	DelegateFile string `json:"delegateFile,omitempty"`
+
+	// SecurePaths refuses a kubeconfig, stateDir, delegateFile or delegate plugin binary
+	// that resolves outside SecurePathRoots or that a user other than root could have
+	// written (see safepath.Check), and uses the resolved path of one it accepts
+	SecurePaths bool `json:"securePaths,omitempty"`
+
+	// SecurePathRoots are the directories SecurePaths accepts paths below
+	// Defaults to DefaultSecurePathRoots; MUST be absolute paths
+	SecurePathRoots []string `json:"securePathRoots,omitempty"`
+
+	// AllowUnsafeSources disables the refusal to mark node, loopback and link-local sources
+	// Only intended for lab setups; a misreporting delegate could otherwise reroute node traffic
+	AllowUnsafeSources bool `json:"allowUnsafeSources,omitempty"`
+
+	// Connmark also installs CONNMARK save/restore rules so replies and host-originated
+	// packets of a marked connection keep the tenant mark for the connection lifetime
+	Connmark bool `json:"connmark,omitempty"`
+
+	// PodUIDComments tags every MARK rule with the UID of its pod (comment "pod-uid:<uid>")
+	// so rules of reused StatefulSet pod names can be told apart in iptables-save output
+	PodUIDComments bool `json:"podUIDComments,omitempty"`
+
+	// MarkHostTraffic also marks host-originated traffic to tenant pods (mangle/OUTPUT, -d podIP)
+	// so kubelet probes and hostNetwork clients are classified per tenant
+	MarkHostTraffic bool `json:"markHostTraffic,omitempty"`
+
+	// MarkHooks maps tenant fwmarks to the hook their MARK rules are installed at:
+	// "prerouting" (the default), "output", "forward" or "postrouting" (see
+	// iptables.AtHook). "postrouting" suits delegates that SNAT pod traffic, whose
+	// masquerade rules match the tenant mark. A mark set at forward or postrouting no
+	// longer selects a route, so those hooks exclude routing.tables for the fwmark,
+	// and any hook but prerouting excludes connmark
+	MarkHooks map[string]string `json:"markHooks,omitempty"`
+
+	// FlushConntrack flushes conntrack entries of the pod IP whenever its MARK rule is
+	// added or removed, so flows of a reused IP or changed tenant do not keep a stale mark
+	FlushConntrack bool `json:"flushConntrack,omitempty"`
+
+	// MarkNewConnectionsOnly applies the MARK rule to new connections only (conntrack
+	// state NEW); the pod's established connections get their saved mark back, so they
+	// keep their path when the pod moves to another tenant or gateway
+	// Requires connmark; excludes flushConntrack, which would drop those connections
+	MarkNewConnectionsOnly bool `json:"markNewConnectionsOnly,omitempty"`
+
+	// MetricsFile is the node_exporter textfile collector file receiving per-tenant
+	// ADD-to-routing-effective latency histograms and skip counters; empty disables metrics
+	// MUST be an absolute path (same rules as Kubeconfig)
+	MetricsFile string `json:"metricsFile,omitempty"`
+
+	// OperationTimeout is the budget in seconds the runtime grants a CNI invocation
+	// When set, Kubernetes API lookups get a timeout derived from the time remaining
+	// in that budget instead of the fixed k8s.K8sAPITimeout, and delegate plugins and
+	// iptables calls are not started or waited for past its end
+	OperationTimeout int `json:"operationTimeout,omitempty"`
+
+	// K8sRetryAttempts is how often a Kubernetes API read is tried before ADD gives up on
+	// the pod's annotations (first try included; 1 disables retries)
+	// Transient failures only (429, 5xx, refused connections); all attempts share the
+	// API timeout. Defaults to k8s.DefaultRetryAttempts if not specified
+	K8sRetryAttempts int `json:"k8sRetryAttempts,omitempty"`
+
+	// K8sRetryBackoff is the delay in milliseconds before the first retry, doubled per
+	// retry (at most 2s). Defaults to k8s.DefaultRetryBackoff if not specified
+	K8sRetryBackoff int `json:"k8sRetryBackoff,omitempty"`
+
+	// AgentSocket is the unix socket of the tenant-routingd node agent; when set, ADD,
+	// DEL and CHECK ask the agent for annotations and only load the kubeconfig if it
+	// cannot be reached. Empty disables the agent
+	// MUST be an absolute path (same rules as Kubeconfig)
+	AgentSocket string `json:"agentSocket,omitempty"`
+
+	// AnnotationCacheTTL is how many seconds resolved routing annotations are cached on
+	// disk (under StateDir), sparing ADD/DEL/CHECK the pod and namespace GETs during
+	// pod churn; annotation changes take up to the TTL to apply. 0 disables the cache
+	AnnotationCacheTTL int `json:"annotationCacheTTL,omitempty"`
+
+	// NegativeAnnotationCacheTTL is how many seconds lookups that found no routing
+	// annotation are cached instead, sparing the CHECKs of non-tenant pods the API calls;
+	// a pod annotated meanwhile takes up to this TTL to apply (tenant-routingd drops the
+	// entries of pods and namespaces whose annotations change). 0 caches them for
+	// AnnotationCacheTTL like the others
+	NegativeAnnotationCacheTTL int `json:"negativeAnnotationCacheTTL,omitempty"`
+
+	// IptablesLockTimeout bounds in seconds how long ADD waits for the xtables lock
+	// In permissive mode a pod whose rules hit the timeout starts unmarked and its
+	// rules are queued in the state record for GC to install; 0 waits indefinitely
+	IptablesLockTimeout int `json:"iptablesLockTimeout,omitempty"`
+
+	// LockFile is the file every invocation flocks around its rule changes, so
+	// concurrent ADD/DEL/GC do not race between checking and changing rules
+	// Defaults to DefaultLockFile; MUST be an absolute path (same rules as Kubeconfig)
+	LockFile string `json:"lockFile,omitempty"`
+
+	// LockTimeout bounds in seconds how long an invocation waits for LockFile
+	// Defaults to DefaultLockTimeout if not specified
+	LockTimeout int `json:"lockTimeout,omitempty"`
+
+	// Strict fails the ADD (after rolling back the delegate) when routing setup fails,
+	// instead of starting the pod unmarked; the tenant.routing/strict namespace
+	// annotation overrides it per namespace
+	Strict bool `json:"strict,omitempty"`
+
+	// StateDir is the directory of per-container records written by ADD and read by
+	// DEL/CHECK, so teardown does not depend on the Kubernetes API
+	// Defaults to DefaultStateDir; MUST be an absolute path (same rules as Kubeconfig)
+	StateDir string `json:"stateDir,omitempty"`
+
+	// NoIPs selects the ADD behavior when the delegate assigns no address at all, as
+	// L2-only delegates (macvlan/ipvlan without IPAM) do: NoIPsSkip or NoIPsFail
+	// Defaults to NoIPsSkip if not specified
+	NoIPs string `json:"noIPs,omitempty"`
+
+	// PrevResultPolicy selects the CHECK and DEL behavior when the runtime passes no
+	// prevResult: PrevResultRequire, PrevResultStateFallback or PrevResultSkip
+	// Defaults to PrevResultStateFallback if not specified
+	PrevResultPolicy string `json:"prevResultPolicy,omitempty"`
+
+	// ResultVersion is the CNI version ADD prints its result in, converted from
+	// whatever the delegate returned, for runtimes that only accept an older version
+	// than cniVersion (one of ResultVersions). Defaults to cniVersion.
+	ResultVersion string `json:"resultVersion,omitempty"`
+
+	// CNICacheDir is the libcni cache directory PrevResultStateFallback reads results
+	// from when there is no state record (<dir>/results/<network>-<container>-<ifname>)
+	// Defaults to DefaultCNICacheDir; MUST be an absolute path (same rules as Kubeconfig)
+	CNICacheDir string `json:"cniCacheDir,omitempty"`
+
+	// Routing enables plugin-managed policy routing (ip rule / ip route)
+	// When nil, routing tables are expected to be set up out-of-band
+	Routing *RoutingConf `json:"routing,omitempty"`
+
+	// LogFormat selects LogFormatText or LogFormatJSON
+	// Defaults to LogFormatText if not specified
+	LogFormat string `json:"logFormat,omitempty"`
+
+	// LogLevel is the least severe level logged: "debug", "info" (default), "warn" or "error"
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// LogFile receives the logs (appended) instead of stderr
+	// MUST be an absolute path (same rules as Kubeconfig)
+	LogFile string `json:"logFile,omitempty"`
+
+	// configuredPaths holds the kubeconfig, stateDir and delegateFile as configured,
+	// by field name, where SecurePaths replaced them by their resolved paths
+	configuredPaths map[string]string
+}
+
+// NamespaceLabelConf assigns a tenant fwmark to the namespaces a label selector matches
+type NamespaceLabelConf struct {
+	// Selector is a Kubernetes label selector, e.g. "tenant=a" or "team in (payments,billing)"
+	Selector string `json:"selector"`
+
+	// Fwmark is the tenant fwmark (e.g. "0x10") of the matching namespaces
+	Fwmark string `json:"fwmark"`
+}
+
+// TenantConf is the tenant the configuration assigns to a namespace (see PluginConf.Tenants)
+type TenantConf struct {
+	// Fwmark is the tenant fwmark (e.g. "0x10") of the namespace's pods
+	Fwmark string `json:"fwmark"`
+
+	// Table is the routing table of Fwmark; if set, it must match routing.tables
+	Table int `json:"table,omitempty"`
+
+	// Gateway is the tenant egress gateway of the namespace's pods, like a gateway
+	// annotation; empty uses the one configured for Fwmark
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Policy routing strategies of RoutingConf.Strategy
+const (
+	// RoutingStrategyTable gives every tenant its own routing table (the default)
+	RoutingStrategyTable = "table"
+
+	// RoutingStrategyRealm routes all tenants through one shared table and tells them
+	// apart by the realm of their ip rule
+	RoutingStrategyRealm = "realm"
+)
+
+// RoutingConf configures per-tenant policy routing managed by the plugin
+type RoutingConf struct {
+	// Strategy is RoutingStrategyTable or RoutingStrategyRealm
+	// Defaults to RoutingStrategyTable if not specified
+	Strategy string `json:"strategy,omitempty"`
+
+	// RulePriority is the ip rule priority for tenant rules (default 50)
+	RulePriority int `json:"rulePriority,omitempty"`
+
+	// Tables maps fwmark (e.g. "0x10") to the tenant routing table settings
+	Tables map[string]RouteTableConf `json:"tables"`
+
+	// RelaxRPFilter sets loose rp_filter and src_valid_mark on the interface routing to
+	// each tenant gateway, so unmarked ICMP (PMTUD) replies from the tenant path are not
+	// dropped by strict reverse-path filtering
+	RelaxRPFilter bool `json:"relaxRPFilter,omitempty"`
+}
+
+// RouteTableConf describes the routing table used for one tenant fwmark
+type RouteTableConf struct {
+	// Table is the routing table ID (must not be a reserved kernel table)
+	Table int `json:"table"`
+
+	// Gateway is the tenant egress gateway; if empty only the ip rule is managed
+	Gateway string `json:"gateway,omitempty"`
+
+	// Realm tags the tenant's ip rule (realms 1-65535) for per-tenant accounting
+	// Required with the realm strategy, which shares Table and Gateway between tenants
+	Realm int `json:"realm,omitempty"`
+
+	// Enforce drops forwarded traffic to Gateway and Destinations that does not carry
+	// this tenant's fwmark, so unmarked pods and other tenants cannot use the gateway
+	Enforce bool `json:"enforce,omitempty"`
+
+	// Destinations are tenant IPv4 addresses or CIDRs protected in addition to Gateway
+	// Only used with Enforce
+	Destinations []string `json:"destinations,omitempty"`
+
+	// MaxConnections caps the concurrent forwarded connections of all this tenant's
+	// pods on the node (connlimit); further new connections are rejected. 0 means no limit
+	MaxConnections int `json:"maxConnections,omitempty"`
 }
 
 // ParseConfig parses CNI configuration from stdin data
@@ -42,35 +375,616 @@ func ParseConfig(stdin []byte) (*PluginConf, error) {
 		return nil, fmt.Errorf("failed to parse network configuration: %w", err)
 	}
 
-	// Validate delegate configuration exists
-	if len(conf.Delegate) == 0 {
-		return nil, fmt.Errorf("delegate plugin configuration is required")
+	// prevResult is set by the runtime for chained plugins (ADD/CHECK/DEL)
+	if err := parsePrevResult(&conf.NetConf); err != nil {
+		return nil, fmt.Errorf("failed to parse prevResult: %w", err)
 	}
 
-	// Validate kubeconfig path is provided
-	if conf.Kubeconfig == "" {
-		return nil, fmt.Errorf("kubeconfig path is required")
+	v := &validation{}
+	if conf.SecurePaths && len(conf.SecurePathRoots) == 0 {
+		conf.SecurePathRoots = slices.Clone(DefaultSecurePathRoots)
+	}
+	for i, root := range conf.SecurePathRoots {
+		if !filepath.IsAbs(root) {
+			v.addf(pointer("securePathRoots", i), "securePathRoots must be absolute paths, got: %s", root)
+		}
 	}
 
-	// Security: Enforce absolute path to prevent path traversal attacks
-	// Relative paths could be manipulated to access arbitrary files
-	if !filepath.IsAbs(conf.Kubeconfig) {
-		return nil, fmt.Errorf("kubeconfig path must be absolute, got: %s", conf.Kubeconfig)
+	if conf.DelegateFile != "" {
+		valid := validatePath(v, "delegateFile", conf.DelegateFile)
+		if len(conf.Delegate) > 0 {
+			v.addf("/delegateFile", "delegateFile and delegate are mutually exclusive")
+		} else if valid && checkSecurePath(v, conf, "delegateFile", &conf.DelegateFile) {
+			delegate, err := loadDelegateFile(conf.DelegateFile)
+			if err != nil {
+				v.addf("/delegateFile", "cannot load delegate: %v", err)
+			}
+			conf.Delegate = delegate
+		}
 	}
+	validateDelegateList(v, conf.Delegate)
 
+	// Validate kubeconfig path is provided
+	// Security: Enforce absolute path to prevent path traversal attacks
+	// Relative paths could be manipulated to access arbitrary files
 	// Security: Reject paths with '..' components (defense in depth)
-	if strings.Contains(conf.Kubeconfig, "..") {
-		return nil, fmt.Errorf("kubeconfig path cannot contain '..' components: %s", conf.Kubeconfig)
+	if conf.Kubeconfig == "" {
+		if len(conf.Tenants) == 0 {
+			v.addf("/kubeconfig", "kubeconfig path is required")
+		}
+	} else if validatePath(v, "kubeconfig", conf.Kubeconfig) {
+		checkSecurePath(v, conf, "kubeconfig", &conf.Kubeconfig)
 	}
 
 	// Apply default annotation key if not specified
-	if conf.AnnotationKey == "" {
-		conf.AnnotationKey = DefaultAnnotationKey
+	if len(conf.AnnotationKey) == 0 {
+		conf.AnnotationKey = AnnotationKeys{DefaultAnnotationKey}
+	}
+	validateAnnotationKeys(v, conf.AnnotationKey)
+	if conf.GatewayAnnotationKey == "" {
+		conf.GatewayAnnotationKey = DefaultGatewayAnnotationKey
+	}
+
+	if conf.MetricsFile != "" {
+		validatePath(v, "metricsFile", conf.MetricsFile)
+	}
+
+	if conf.StateDir == "" {
+		conf.StateDir = DefaultStateDir
+	}
+	if validatePath(v, "stateDir", conf.StateDir) {
+		checkSecurePath(v, conf, "stateDir", &conf.StateDir)
+	}
+
+	if conf.OperationTimeout < 0 {
+		v.addf("/operationTimeout", "operationTimeout must not be negative, got: %d", conf.OperationTimeout)
+	}
+	if conf.K8sRetryAttempts < 0 || conf.K8sRetryAttempts > MaxK8sRetryAttempts {
+		v.addf("/k8sRetryAttempts", "k8sRetryAttempts must be between 1 and %d, got: %d", MaxK8sRetryAttempts,
+			conf.K8sRetryAttempts)
+	}
+	if conf.K8sRetryBackoff < 0 {
+		v.addf("/k8sRetryBackoff", "k8sRetryBackoff must not be negative, got: %d", conf.K8sRetryBackoff)
+	}
+	if conf.AgentSocket != "" {
+		validatePath(v, "agentSocket", conf.AgentSocket)
+	}
+	if conf.AnnotationCacheTTL < 0 || conf.AnnotationCacheTTL > MaxAnnotationCacheTTL {
+		v.addf("/annotationCacheTTL", "annotationCacheTTL must be between 0 and %d, got: %d", MaxAnnotationCacheTTL,
+			conf.AnnotationCacheTTL)
+	}
+	if conf.NegativeAnnotationCacheTTL < 0 || conf.NegativeAnnotationCacheTTL > MaxAnnotationCacheTTL {
+		v.addf("/negativeAnnotationCacheTTL", "negativeAnnotationCacheTTL must be between 0 and %d, got: %d",
+			MaxAnnotationCacheTTL, conf.NegativeAnnotationCacheTTL)
+	}
+	if conf.IptablesLockTimeout < 0 {
+		v.addf("/iptablesLockTimeout", "iptablesLockTimeout must not be negative, got: %d", conf.IptablesLockTimeout)
+	}
+	if conf.MarkNewConnectionsOnly {
+		if !conf.Connmark {
+			v.addf("/markNewConnectionsOnly", "markNewConnectionsOnly requires connmark")
+		}
+		if conf.FlushConntrack {
+			v.addf("/markNewConnectionsOnly", "markNewConnectionsOnly and flushConntrack are mutually exclusive")
+		}
+	}
+
+	if conf.LockFile == "" {
+		conf.LockFile = DefaultLockFile
+	}
+	validatePath(v, "lockFile", conf.LockFile)
+	switch {
+	case conf.LockTimeout < 0:
+		v.addf("/lockTimeout", "lockTimeout must not be negative, got: %d", conf.LockTimeout)
+	case conf.LockTimeout == 0:
+		conf.LockTimeout = DefaultLockTimeout
+	}
+
+	switch conf.NoIPs {
+	case "":
+		conf.NoIPs = NoIPsSkip
+	case NoIPsSkip, NoIPsFail:
+	default:
+		v.addf("/noIPs", "noIPs must be %q or %q, got: %q", NoIPsSkip, NoIPsFail, conf.NoIPs)
+	}
+
+	switch conf.PrevResultPolicy {
+	case "":
+		conf.PrevResultPolicy = PrevResultStateFallback
+	case PrevResultRequire, PrevResultStateFallback, PrevResultSkip:
+	default:
+		v.addf("/prevResultPolicy", "prevResultPolicy must be %q, %q or %q, got: %q",
+			PrevResultRequire, PrevResultStateFallback, PrevResultSkip, conf.PrevResultPolicy)
+	}
+
+	if conf.ResultVersion != "" && !slices.Contains(ResultVersions, conf.ResultVersion) {
+		v.addf("/resultVersion", "resultVersion must be one of %s, got: %q", strings.Join(ResultVersions, ", "),
+			conf.ResultVersion)
+	}
+
+	if conf.CNICacheDir == "" {
+		conf.CNICacheDir = DefaultCNICacheDir
+	}
+	validatePath(v, "cniCacheDir", conf.CNICacheDir)
+
+	switch conf.LogFormat {
+	case "":
+		conf.LogFormat = LogFormatText
+	case LogFormatText, LogFormatJSON:
+	default:
+		v.addf("/logFormat", "logFormat must be %q or %q, got: %q", LogFormatText, LogFormatJSON, conf.LogFormat)
+	}
+	switch conf.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		v.addf("/logLevel", "logLevel must be one of debug, info, warn, error, got: %q", conf.LogLevel)
+	}
+	if conf.LogFile != "" {
+		validatePath(v, "logFile", conf.LogFile)
 	}
 
+	for i, rule := range conf.NamespaceLabels {
+		selector, err := labels.Parse(rule.Selector)
+		if err != nil || selector.Empty() {
+			v.addf(pointer("namespaceLabels", i, "selector"), "selector %q must be a non-empty label selector",
+				rule.Selector)
+		}
+		if _, err := api.ParseFwmark(rule.Fwmark); err != nil {
+			v.addf(pointer("namespaceLabels", i, "fwmark"), "%v", err)
+		}
+	}
+
+	validateTenants(v, conf)
+	validateMarkHooks(v, conf)
+
+	for i, namespace := range conf.ExcludeNamespaces {
+		if namespace == "" {
+			v.addf(pointer("excludeNamespaces", i), "excludeNamespaces must be namespace names")
+		}
+	}
+	if conf.ExcludePodLabelSelector != "" {
+		if selector, err := labels.Parse(conf.ExcludePodLabelSelector); err != nil || selector.Empty() {
+			v.addf("/excludePodLabelSelector", "excludePodLabelSelector %q must be a non-empty label selector",
+				conf.ExcludePodLabelSelector)
+		}
+	}
+
+	if conf.Routing != nil {
+		validateRouting(v, conf.Routing)
+	}
+
+	if err := v.err(); err != nil {
+		return nil, err
+	}
 	return conf, nil
 }
 
+// validatePath checks that the path in field is absolute and has no '..' components
+// Returns whether it is valid.
+func validatePath(v *validation, field, path string) bool {
+	if !filepath.IsAbs(path) {
+		v.addf(pointer(field), "%s path must be absolute, got: %s", field, path)
+		return false
+	}
+	if strings.Contains(path, "..") {
+		v.addf(pointer(field), "%s path cannot contain '..' components: %s", field, path)
+		return false
+	}
+	return true
+}
+
+// checkSecurePath checks the path in field with safepath.Check if conf sets securePaths
+// and replaces it by the resolved path, so the file used is the one checked rather than
+// whatever a symlink points to by then. The configured path is kept for Fingerprint, so
+// the fingerprint does not depend on the symlinks of the node. Returns whether it passed.
+func checkSecurePath(v *validation, conf *PluginConf, field string, path *string) bool {
+	if !conf.SecurePaths {
+		return true
+	}
+	resolved, err := safepath.Check(*path, conf.SecurePathRoots)
+	if err != nil {
+		v.addf(pointer(field), "%v", err)
+		return false
+	}
+	if resolved != *path {
+		if conf.configuredPaths == nil {
+			conf.configuredPaths = map[string]string{}
+		}
+		conf.configuredPaths[field] = *path
+		*path = resolved
+	}
+	return true
+}
+
+// parsePrevResult decodes the raw prevResult of conf in the version it was written in
+// and converts it to the cniVersion of conf, so CHECK and DEL find the pod IP whichever
+// plugin of the chain wrote it. version.ParsePrevResult decodes it as the cniVersion of
+// conf, which loses the addresses of a result in an older format (0.2.0 "ip4").
+// A prevResult naming no cniVersion is taken to be in that of conf.
+func parsePrevResult(conf *types.NetConf) error {
+	if conf.RawPrevResult == nil {
+		return nil
+	}
+	resultVersion, _ := conf.RawPrevResult["cniVersion"].(string)
+	if resultVersion == "" {
+		resultVersion = conf.CNIVersion
+		if resultVersion == "" {
+			resultVersion = "0.1.0"
+		}
+		conf.RawPrevResult["cniVersion"] = resultVersion
+	}
+	data, err := json.Marshal(conf.RawPrevResult)
+	if err != nil {
+		return fmt.Errorf("could not serialize prevResult: %w", err)
+	}
+
+	prevResult, err := create.Create(resultVersion, data)
+	if err != nil {
+		return fmt.Errorf("could not parse prevResult: %w", err)
+	}
+	if conf.CNIVersion != "" && conf.CNIVersion != resultVersion {
+		if prevResult, err = prevResult.GetAsVersion(conf.CNIVersion); err != nil {
+			return fmt.Errorf("could not convert prevResult from %s: %w", resultVersion, err)
+		}
+	}
+	conf.RawPrevResult = nil
+	conf.PrevResult = prevResult
+	return nil
+}
+
+// validateDelegateList checks a delegate list (JSON array) names at least one plugin,
+// each with a type; a single delegate object is validated when it runs
+func validateDelegateList(v *validation, delegate json.RawMessage) {
+	trimmed := bytes.TrimSpace(delegate)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return
+	}
+	var plugins []struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(trimmed, &plugins); err != nil {
+		v.addf("/delegate", "invalid delegate list: %v", err)
+		return
+	}
+	if len(plugins) == 0 {
+		v.addf("/delegate", "delegate list is empty")
+	}
+	for i, plugin := range plugins {
+		if plugin.Type == "" {
+			v.addf(pointer("delegate", i, "type"), "delegate %d of %d is missing required 'type' field", i+1, len(plugins))
+		}
+	}
+}
+
+// validateRouting checks fwmark keys, table IDs and gateways of the routing block
+// Tables are checked in fwmark order, so the errors are the same on every run.
+func validateRouting(v *validation, r *RoutingConf) {
+	if r.RulePriority < 0 || r.RulePriority > 32765 {
+		v.addf("/routing/rulePriority", "rulePriority %d out of range (1-32765)", r.RulePriority)
+	}
+	switch r.Strategy {
+	case "", RoutingStrategyTable, RoutingStrategyRealm:
+	default:
+		v.addf("/routing/strategy", "strategy %q must be %q or %q", r.Strategy, RoutingStrategyTable,
+			RoutingStrategyRealm)
+	}
+
+	protected := map[string]string{}
+	for _, fwmark := range sortedFwmarks(r) {
+		table := r.Tables[fwmark]
+		path := pointer("routing", "tables", fwmark)
+		if mark, err := strconv.ParseUint(fwmark, 0, 32); err != nil || mark == 0 {
+			v.addf(path, "tables key %q is not a valid non-zero fwmark", fwmark)
+		}
+		// 0 (unspec), 253 (default), 254 (main) and 255 (local) belong to the kernel
+		switch {
+		case table.Table == 0 || table.Table == 253 || table.Table == 254 || table.Table == 255:
+			v.addf(path+"/table", "table %d for fwmark %s is reserved", table.Table, fwmark)
+		case table.Table < 0:
+			v.addf(path+"/table", "table %d for fwmark %s out of range", table.Table, fwmark)
+		}
+		if table.Gateway != "" {
+			ip := net.ParseIP(table.Gateway)
+			if ip == nil || ip.To4() == nil {
+				v.addf(path+"/gateway", "gateway %q for fwmark %s must be an IPv4 address", table.Gateway, fwmark)
+			}
+		}
+		if table.MaxConnections < 0 {
+			v.addf(path+"/maxConnections", "maxConnections %d for fwmark %s must be non-negative",
+				table.MaxConnections, fwmark)
+		}
+		validateEnforcement(v, path, fwmark, table, protected)
+	}
+
+	validateRealms(v, r)
+}
+
+// validateTenants checks the static tenants like the annotations they stand in for, and
+// that their tables are the ones routing assigns to their fwmarks
+func validateTenants(v *validation, conf *PluginConf) {
+	namespaces := make([]string, 0, len(conf.Tenants))
+	for namespace := range conf.Tenants {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		tenant := conf.Tenants[namespace]
+		path := pointer("tenants", namespace)
+		if namespace == "" {
+			v.addf(path, "tenants key must be a namespace name")
+		}
+		mark, err := api.ParseFwmark(tenant.Fwmark)
+		if err != nil {
+			v.addf(path+"/fwmark", "%v", err)
+		}
+		if tenant.Gateway != "" {
+			if _, err := api.ParseGateway(tenant.Gateway); err != nil {
+				v.addf(path+"/gateway", "%v", err)
+			}
+		}
+		if tenant.Table == 0 || err != nil {
+			continue
+		}
+		table, ok := conf.RouteTableOf(mark)
+		switch {
+		case conf.Routing == nil:
+			v.addf(path+"/table", "table %d of namespace %s requires routing", tenant.Table, namespace)
+		case !ok:
+			v.addf(path+"/table", "table %d of namespace %s: no routing table configured for fwmark %s",
+				tenant.Table, namespace, tenant.Fwmark)
+		case table.Table != tenant.Table:
+			v.addf(path+"/table", "table %d of namespace %s: fwmark %s is configured with table %d",
+				tenant.Table, namespace, tenant.Fwmark, table.Table)
+		}
+	}
+}
+
+// validateMarkHooks checks the hook of every fwmark against the settings it must combine with
+func validateMarkHooks(v *validation, conf *PluginConf) {
+	fwmarks := make([]string, 0, len(conf.MarkHooks))
+	for fwmark := range conf.MarkHooks {
+		fwmarks = append(fwmarks, fwmark)
+	}
+	sort.Strings(fwmarks)
+
+	for _, fwmark := range fwmarks {
+		hook := conf.MarkHooks[fwmark]
+		path := pointer("markHooks", fwmark)
+		mark, err := api.ParseFwmark(fwmark)
+		if err != nil {
+			v.addf(path, "%v", err)
+			continue
+		}
+		if _, err := iptables.HookChain(hook); err != nil || hook == "" {
+			v.addf(path, "hook %q for fwmark %s must be one of prerouting, output, forward, postrouting", hook, fwmark)
+			continue
+		}
+		if hook == iptables.HookPrerouting {
+			continue
+		}
+		if conf.Connmark {
+			v.addf(path, "hook %q for fwmark %s excludes connmark: the mark would be saved before it is set",
+				hook, fwmark)
+		}
+		if _, ok := conf.RouteTableOf(mark); ok && (hook == iptables.HookForward || hook == iptables.HookPostrouting) {
+			v.addf(path, "hook %q for fwmark %s is past the routing decision, so routing.tables cannot route it",
+				hook, fwmark)
+		}
+	}
+}
+
+// MarkHook returns the hook configured for a fwmark ("" for the default, prerouting)
+// Lookup is case-insensitive on the hex prefix/digits like RouteTable
+func (c *PluginConf) MarkHook(fwmark string) string {
+	want, err := strconv.ParseUint(strings.TrimSpace(fwmark), 0, 32)
+	if err != nil {
+		return ""
+	}
+	for key, hook := range c.MarkHooks {
+		if mark, err := strconv.ParseUint(key, 0, 32); err == nil && mark == want && hook != iptables.HookPrerouting {
+			return hook
+		}
+	}
+	return ""
+}
+
+// sortedFwmarks returns the keys of the routing tables in order
+func sortedFwmarks(r *RoutingConf) []string {
+	fwmarks := make([]string, 0, len(r.Tables))
+	for fwmark := range r.Tables {
+		fwmarks = append(fwmarks, fwmark)
+	}
+	sort.Strings(fwmarks)
+	return fwmarks
+}
+
+// validateRealms checks the realm of every table against the routing strategy
+// Realm tenants share one table, so its default route must be the same for all of
+// them, and their realms must differ to be told apart.
+func validateRealms(v *validation, r *RoutingConf) {
+	fwmarks := sortedFwmarks(r)
+	if r.Strategy != RoutingStrategyRealm {
+		for _, fwmark := range fwmarks {
+			if r.Tables[fwmark].Realm != 0 {
+				v.addf(pointer("routing", "tables", fwmark, "realm"), "realm for fwmark %s requires strategy %q",
+					fwmark, RoutingStrategyRealm)
+			}
+		}
+		return
+	}
+
+	realms := map[int]string{}
+	var first RouteTableConf
+	for i, fwmark := range fwmarks {
+		table := r.Tables[fwmark]
+		path := pointer("routing", "tables", fwmark)
+		if table.Realm < 1 || table.Realm > 65535 {
+			v.addf(path+"/realm", "realm %d for fwmark %s out of range (1-65535)", table.Realm, fwmark)
+		} else if other, ok := realms[table.Realm]; ok {
+			v.addf(path+"/realm", "realm %d is used by both fwmark %s and %s", table.Realm, other, fwmark)
+		} else {
+			realms[table.Realm] = fwmark
+		}
+		if i == 0 {
+			first = table
+			continue
+		}
+		if table.Table != first.Table {
+			v.addf(path+"/table", "strategy %q needs one table for all tenants, fwmark %s uses %d and %s uses %d",
+				RoutingStrategyRealm, fwmarks[0], first.Table, fwmark, table.Table)
+		}
+		if table.Gateway != first.Gateway {
+			v.addf(path+"/gateway", "strategy %q needs one gateway for all tenants, fwmark %s uses %q and %s uses %q",
+				RoutingStrategyRealm, fwmarks[0], first.Gateway, fwmark, table.Gateway)
+		}
+	}
+}
+
+// validateEnforcement checks the enforcement settings of one table at path
+// protected collects destinations across tables: a destination enforced for two
+// tenants would drop the traffic of both.
+func validateEnforcement(v *validation, path, fwmark string, table RouteTableConf, protected map[string]string) {
+	if !table.Enforce {
+		if len(table.Destinations) > 0 {
+			v.addf(path+"/destinations", "destinations for fwmark %s require enforce", fwmark)
+		}
+		return
+	}
+	if table.Gateway == "" && len(table.Destinations) == 0 {
+		v.addf(path+"/enforce", "enforce for fwmark %s needs a gateway or destinations", fwmark)
+		return
+	}
+
+	// The same CIDRs as EnforcedDestinations, each with the path it is configured at
+	paths, dsts := []string{path + "/gateway"}, []string{table.Gateway}
+	for i, dst := range table.Destinations {
+		paths, dsts = append(paths, fmt.Sprintf("%s/destinations/%d", path, i)), append(dsts, dst)
+	}
+	for i, dst := range dsts {
+		if dst == "" {
+			continue
+		}
+		if !strings.Contains(dst, "/") {
+			dst += "/32"
+		}
+		_, ipnet, err := net.ParseCIDR(dst)
+		if err != nil || ipnet.IP.To4() == nil {
+			v.addf(paths[i], "destination %q for fwmark %s must be an IPv4 address or CIDR", dst, fwmark)
+			continue
+		}
+		if other, ok := protected[ipnet.String()]; ok {
+			v.addf(paths[i], "destination %s is enforced for both fwmark %s and %s", ipnet, other, fwmark)
+			continue
+		}
+		protected[ipnet.String()] = fwmark
+	}
+}
+
+// EnforcedDestinations returns Gateway and Destinations as CIDRs (hosts become /32)
+// Empty unless Enforce is set
+func (t RouteTableConf) EnforcedDestinations() []string {
+	if !t.Enforce {
+		return nil
+	}
+	var dsts []string
+	for _, dst := range append([]string{t.Gateway}, t.Destinations...) {
+		if dst == "" {
+			continue
+		}
+		if !strings.Contains(dst, "/") {
+			dst += "/32"
+		}
+		dsts = append(dsts, dst)
+	}
+	return dsts
+}
+
+// RouteTable returns the routing table settings for a fwmark, if plugin-managed routing is enabled
+// Lookup is case-insensitive on the hex prefix/digits (0x10 == 0X10)
+func (c *PluginConf) RouteTable(fwmark string) (RouteTableConf, bool) {
+	want, err := strconv.ParseUint(strings.TrimSpace(fwmark), 0, 32)
+	if err != nil {
+		return RouteTableConf{}, false
+	}
+	return c.RouteTableOf(uint32(want))
+}
+
+// RouteTableOf is RouteTable for a parsed fwmark
+func (c *PluginConf) RouteTableOf(fwmark uint32) (RouteTableConf, bool) {
+	if c.Routing == nil {
+		return RouteTableConf{}, false
+	}
+	for key, table := range c.Routing.Tables {
+		if mark, err := strconv.ParseUint(key, 0, 32); err == nil && mark == uint64(fwmark) {
+			return table, true
+		}
+	}
+	return RouteTableConf{}, false
+}
+
+// ResultCNIVersion returns the CNI version ADD prints its result in
+func (c *PluginConf) ResultCNIVersion() string {
+	if c.ResultVersion != "" {
+		return c.ResultVersion
+	}
+	return c.CNIVersion
+}
+
+// Chained reports whether the wrapper runs as a chained plugin in a conflist
+// Without a delegate block the interface is set up by the previous plugin: ADD marks
+// the pod IP from prevResult and passes prevResult through, and DEL, CHECK, GC and
+// STATUS have no delegate to call.
+func (c *PluginConf) Chained() bool {
+	return len(c.Delegate) == 0
+}
+
+// Fingerprint returns a short hash of the effective configuration
+// Defaults are applied and per-invocation input (prevResult, runtimeConfig, GC
+// attachments), logging settings and unknown fields are left out, so every invocation
+// with the same network configuration yields the same value, whatever runtime passed it.
+func (c *PluginConf) Fingerprint() string {
+	effective := *c
+	effective.RawPrevResult = nil
+	effective.PrevResult = nil
+	effective.ValidAttachments = nil
+	effective.RuntimeConfig = nil
+	// Logging does not change what is applied to the node
+	effective.LogFormat, effective.LogLevel, effective.LogFile = "", "", ""
+	// Nor does where the annotations it is derived from are read, or how CHECK/DEL find the pod IP
+	effective.AnnotationCacheTTL, effective.NegativeAnnotationCacheTTL, effective.AgentSocket = 0, 0, ""
+	effective.PrevResultPolicy, effective.CNICacheDir = "", ""
+	// Nor what version results are printed in, or how its paths are vetted
+	effective.ResultVersion = ""
+	effective.SecurePaths, effective.SecurePathRoots = false, nil
+	if path, ok := c.configuredPaths["kubeconfig"]; ok {
+		effective.Kubeconfig = path
+	}
+	if path, ok := c.configuredPaths["stateDir"]; ok {
+		effective.StateDir = path
+	}
+	if path, ok := c.configuredPaths["delegateFile"]; ok {
+		effective.DelegateFile = path
+	}
+
+	// Marshal sorts map keys and compacts the raw delegate block
+	data, err := json.Marshal(fingerprintConf{PluginConf: &effective})
+	if err != nil {
+		// Only reachable with a delegate block that is not valid JSON
+		data = append([]byte(c.Name), c.Delegate...)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// fingerprintConf encodes every field of a PluginConf
+// The MarshalJSON method promoted from types.NetConf would encode only the standard
+// fields; the field of the same name at a shallower depth hides it.
+type fingerprintConf struct {
+	*PluginConf
+	MarshalJSON struct{} `json:"-"`
+}
+
 // GetDelegateConfig returns the delegate plugin configuration as raw JSON
 // This allows the wrapper to pass the configuration unchanged to the next plugin
 func (c *PluginConf) GetDelegateConfig() []byte {
@@ -2,7 +2,17 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/safepath"
 )
 
 func TestParseConfig_ValidConfig(t *testing.T) {
@@ -39,8 +49,8 @@ func TestParseConfig_ValidConfig(t *testing.T) {
 	if conf.Kubeconfig != "/etc/cni/net.d/tenant-routing.kubeconfig" {
 		t.Errorf("Expected Kubeconfig '/etc/cni/net.d/tenant-routing.kubeconfig', got '%s'", conf.Kubeconfig)
 	}
-	if conf.AnnotationKey != "custom.tenant/fwmark" {
-		t.Errorf("Expected AnnotationKey 'custom.tenant/fwmark', got '%s'", conf.AnnotationKey)
+	if !reflect.DeepEqual(conf.AnnotationKey, AnnotationKeys{"custom.tenant/fwmark"}) {
+		t.Errorf("Expected AnnotationKey 'custom.tenant/fwmark', got %q", conf.AnnotationKey)
 	}
 
 	// Validate delegate is preserved
@@ -75,27 +85,79 @@ func TestParseConfig_DefaultAnnotationKey(t *testing.T) {
 	}
 
 	// Verify default annotation key is applied
-	if conf.AnnotationKey != DefaultAnnotationKey {
-		t.Errorf("Expected default AnnotationKey '%s', got '%s'", DefaultAnnotationKey, conf.AnnotationKey)
+	if !reflect.DeepEqual(conf.AnnotationKey, AnnotationKeys{DefaultAnnotationKey}) {
+		t.Errorf("Expected default AnnotationKey '%s', got %q", DefaultAnnotationKey, conf.AnnotationKey)
+	}
+	if conf.GatewayAnnotationKey != DefaultGatewayAnnotationKey {
+		t.Errorf("Expected default GatewayAnnotationKey '%s', got '%s'", DefaultGatewayAnnotationKey, conf.GatewayAnnotationKey)
 	}
 }
 
-func TestParseConfig_MissingDelegate(t *testing.T) {
+func TestParseConfig_Chained(t *testing.T) {
 	input := `{
 		"cniVersion": "1.0.0",
 		"name": "tenant-routing",
 		"type": "tenant-routing-wrapper",
-		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig"
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"prevResult": {
+			"cniVersion": "1.0.0",
+			"ips": [{"address": "10.200.1.5/24"}]
+		}
 	}`
 
-	_, err := ParseConfig([]byte(input))
-	if err == nil {
-		t.Fatal("Expected error for missing delegate, got nil")
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if !conf.Chained() {
+		t.Error("Chained() = false without delegate block")
+	}
+	if conf.PrevResult == nil {
+		t.Fatal("PrevResult not parsed")
+	}
+	res, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil || len(res.IPs) != 1 || res.IPs[0].Address.String() != "10.200.1.5/24" {
+		t.Errorf("PrevResult = %v, %v; want 10.200.1.5/24", conf.PrevResult, err)
 	}
 
-	expected := "delegate plugin configuration is required"
-	if err.Error() != expected {
-		t.Errorf("Expected error '%s', got '%s'", expected, err.Error())
+	// A delegate block selects delegation; an unparsable prevResult is rejected
+	conf, err = ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "n", "type": "t",
+		"kubeconfig": "/etc/kubeconfig", "delegate": {"type": "ptp"}}`))
+	if err != nil || conf.Chained() {
+		t.Errorf("ParseConfig() with delegate = %v, %v; want not chained", conf, err)
+	}
+	if _, err := ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "n", "type": "t",
+		"kubeconfig": "/etc/kubeconfig", "prevResult": {"ips": "bogus"}}`)); err == nil {
+		t.Error("ParseConfig() expected error for invalid prevResult")
+	}
+}
+
+// TestParseConfig_PrevResultVersions verifies a prevResult is decoded in its own version
+// and converted to the cniVersion of the configuration
+func TestParseConfig_PrevResultVersions(t *testing.T) {
+	for _, tt := range []struct {
+		name, confVersion, prevResult string
+	}{
+		{name: "0.2.0 result", confVersion: "1.0.0", prevResult: `{"cniVersion": "0.2.0", "ip4": {"ip": "10.200.1.5/24"}}`},
+		{name: "0.3.1 result", confVersion: "1.0.0",
+			prevResult: `{"cniVersion": "0.3.1", "ips": [{"version": "4", "address": "10.200.1.5/24"}]}`},
+		{name: "no version", confVersion: "0.4.0", prevResult: `{"ips": [{"version": "4", "address": "10.200.1.5/24"}]}`},
+		{name: "newer result", confVersion: "0.4.0", prevResult: `{"cniVersion": "1.0.0", "ips": [{"address": "10.200.1.5/24"}]}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := ParseConfig([]byte(`{"cniVersion": "` + tt.confVersion + `", "name": "n", "type": "t",
+				"kubeconfig": "/etc/kubeconfig", "prevResult": ` + tt.prevResult + `}`))
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+			if conf.PrevResult == nil || conf.PrevResult.Version() != tt.confVersion {
+				t.Fatalf("PrevResult = %#v, want a %s result", conf.PrevResult, tt.confVersion)
+			}
+			res, err := current.NewResultFromResult(conf.PrevResult)
+			if err != nil || len(res.IPs) != 1 || res.IPs[0].Address.String() != "10.200.1.5/24" {
+				t.Errorf("PrevResult = %v, %v; want 10.200.1.5/24", conf.PrevResult, err)
+			}
+		})
 	}
 }
 
@@ -114,7 +176,7 @@ func TestParseConfig_MissingKubeconfig(t *testing.T) {
 		t.Fatal("Expected error for missing kubeconfig, got nil")
 	}
 
-	expected := "kubeconfig path is required"
+	expected := "invalid configuration: /kubeconfig: kubeconfig path is required"
 	if err.Error() != expected {
 		t.Errorf("Expected error '%s', got '%s'", expected, err.Error())
 	}
@@ -283,8 +345,1162 @@ func TestParseConfig_PathTraversalAttempt(t *testing.T) {
 	}
 
 	// Should fail because path is not absolute
-	expected := "kubeconfig path must be absolute"
+	expected := "invalid configuration: /kubeconfig: kubeconfig path must be absolute"
 	if err.Error()[:len(expected)] != expected {
 		t.Errorf("Expected error starting with '%s', got '%s'", expected, err.Error())
 	}
 }
+
+func TestParseConfig_Routing(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"type": "tenant-routing-wrapper",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"delegate": {"type": "ptp"},
+		"routing": {
+			"rulePriority": 60,
+			"relaxRPFilter": true,
+			"tables": {
+				"0x10": {"table": 100, "gateway": "10.10.10.131", "maxConnections": 500},
+				"0x20": {"table": 200}
+			}
+		}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+
+	if conf.Routing == nil || conf.Routing.RulePriority != 60 || !conf.Routing.RelaxRPFilter {
+		t.Fatalf("Expected routing with rulePriority 60 and relaxRPFilter, got %+v", conf.Routing)
+	}
+
+	table, ok := conf.RouteTable("0X10")
+	if !ok {
+		t.Fatal("Expected table for fwmark 0X10 (case-insensitive lookup)")
+	}
+	if table.Table != 100 || table.Gateway != "10.10.10.131" || table.MaxConnections != 500 {
+		t.Errorf("Unexpected table for 0x10: %+v", table)
+	}
+
+	if _, ok := conf.RouteTable("0x30"); ok {
+		t.Error("Expected no table for unconfigured fwmark 0x30")
+	}
+}
+
+func TestParseConfig_RealmStrategy(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"delegate": {"type": "ptp"},
+		"routing": {
+			"strategy": "realm",
+			"tables": {
+				"0x10": {"table": 100, "realm": 1, "gateway": "10.10.10.131"},
+				"0x20": {"table": 100, "realm": 2, "gateway": "10.10.10.131"}
+			}
+		}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if conf.Routing.Strategy != RoutingStrategyRealm {
+		t.Errorf("Expected strategy %q, got %q", RoutingStrategyRealm, conf.Routing.Strategy)
+	}
+	if table, _ := conf.RouteTable("0x20"); table.Realm != 2 {
+		t.Errorf("Unexpected table for 0x20: %+v", table)
+	}
+}
+
+func TestRouteTableConf_EnforcedDestinations(t *testing.T) {
+	table := RouteTableConf{Table: 100, Gateway: "10.10.10.131", Enforce: true, Destinations: []string{"172.16.0.0/16", "192.0.2.7"}}
+	want := []string{"10.10.10.131/32", "172.16.0.0/16", "192.0.2.7/32"}
+	if got := table.EnforcedDestinations(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("EnforcedDestinations() = %v, want %v", got, want)
+	}
+
+	table.Enforce = false
+	if got := table.EnforcedDestinations(); len(got) != 0 {
+		t.Errorf("EnforcedDestinations() without enforce = %v, want none", got)
+	}
+}
+
+func TestParseConfig_RoutingDisabled(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if _, ok := conf.RouteTable("0x10"); ok {
+		t.Error("Expected no route table when routing block is absent")
+	}
+}
+
+func TestParseConfig_InvalidRouting(t *testing.T) {
+	tests := []struct {
+		name    string
+		routing string
+		errMsg  string
+	}{
+		{
+			name:    "reserved main table",
+			routing: `{"tables": {"0x10": {"table": 254}}}`,
+			errMsg:  "reserved",
+		},
+		{
+			name:    "invalid fwmark key",
+			routing: `{"tables": {"tenant-a": {"table": 100}}}`,
+			errMsg:  "not a valid non-zero fwmark",
+		},
+		{
+			name:    "ipv6 gateway",
+			routing: `{"tables": {"0x10": {"table": 100, "gateway": "fd00::1"}}}`,
+			errMsg:  "must be an IPv4 address",
+		},
+		{
+			name:    "priority out of range",
+			routing: `{"rulePriority": 40000, "tables": {}}`,
+			errMsg:  "rulePriority",
+		},
+		{
+			name:    "destinations without enforce",
+			routing: `{"tables": {"0x10": {"table": 100, "destinations": ["172.16.0.0/16"]}}}`,
+			errMsg:  "require enforce",
+		},
+		{
+			name:    "enforce without destinations",
+			routing: `{"tables": {"0x10": {"table": 100, "enforce": true}}}`,
+			errMsg:  "needs a gateway or destinations",
+		},
+		{
+			name:    "invalid destination",
+			routing: `{"tables": {"0x10": {"table": 100, "enforce": true, "destinations": ["fd00::/64"]}}}`,
+			errMsg:  "IPv4 address or CIDR",
+		},
+		{
+			name: "destination enforced for two tenants",
+			routing: `{"tables": {
+				"0x10": {"table": 100, "enforce": true, "destinations": ["172.16.0.0/16"]},
+				"0x20": {"table": 200, "enforce": true, "destinations": ["172.16.1.1/16"]}}}`,
+			errMsg: "enforced for both",
+		},
+		{
+			name:    "negative maxConnections",
+			routing: `{"tables": {"0x10": {"table": 100, "maxConnections": -1}}}`,
+			errMsg:  "must be non-negative",
+		},
+		{
+			name:    "unknown strategy",
+			routing: `{"strategy": "metric", "tables": {}}`,
+			errMsg:  "strategy \"metric\"",
+		},
+		{
+			name:    "realm without realm strategy",
+			routing: `{"tables": {"0x10": {"table": 100, "realm": 1}}}`,
+			errMsg:  "requires strategy",
+		},
+		{
+			name:    "realm strategy without realm",
+			routing: `{"strategy": "realm", "tables": {"0x10": {"table": 100}}}`,
+			errMsg:  "realm 0 for fwmark 0x10 out of range",
+		},
+		{
+			name: "realm used twice",
+			routing: `{"strategy": "realm", "tables": {
+				"0x10": {"table": 100, "realm": 1},
+				"0x20": {"table": 100, "realm": 1}}}`,
+			errMsg: "realm 1 is used by both",
+		},
+		{
+			name: "realm strategy with two tables",
+			routing: `{"strategy": "realm", "tables": {
+				"0x10": {"table": 100, "realm": 1},
+				"0x20": {"table": 200, "realm": 2}}}`,
+			errMsg: "needs one table",
+		},
+		{
+			name: "realm strategy with two gateways",
+			routing: `{"strategy": "realm", "tables": {
+				"0x10": {"table": 100, "realm": 1, "gateway": "10.10.10.131"},
+				"0x20": {"table": 100, "realm": 2, "gateway": "10.10.10.184"}}}`,
+			errMsg: "needs one gateway",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := `{
+				"cniVersion": "1.0.0",
+				"name": "tenant-routing",
+				"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+				"delegate": {"type": "ptp"},
+				"routing": ` + tt.routing + `
+			}`
+
+			_, err := ParseConfig([]byte(input))
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected error containing %q, got %q", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestParseConfig_Connmark(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"connmark": true,
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if !conf.Connmark {
+		t.Error("Expected Connmark to be enabled")
+	}
+}
+
+func TestParseConfig_Strict(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"strict": true,
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if !conf.Strict {
+		t.Error("Expected Strict to be enabled")
+	}
+}
+
+func TestParseConfig_AllowUnsafeSourcesDefault(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if conf.AllowUnsafeSources {
+		t.Error("Expected AllowUnsafeSources to default to false")
+	}
+}
+
+func TestParseConfig_MarkHostTraffic(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"markHostTraffic": true,
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if !conf.MarkHostTraffic {
+		t.Error("Expected MarkHostTraffic to be enabled")
+	}
+}
+
+func TestParseConfig_OperationTimeout(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"operationTimeout": 60,
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if conf.OperationTimeout != 60 {
+		t.Errorf("Expected OperationTimeout 60, got %d", conf.OperationTimeout)
+	}
+
+	negative := strings.Replace(input, `"operationTimeout": 60`, `"operationTimeout": -1`, 1)
+	if _, err := ParseConfig([]byte(negative)); err == nil {
+		t.Error("Expected error for negative operationTimeout")
+	}
+}
+
+func TestParseConfig_IptablesLockTimeout(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"iptablesLockTimeout": 3,
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if conf.IptablesLockTimeout != 3 {
+		t.Errorf("Expected IptablesLockTimeout 3, got %d", conf.IptablesLockTimeout)
+	}
+
+	negative := strings.Replace(input, `"iptablesLockTimeout": 3`, `"iptablesLockTimeout": -1`, 1)
+	if _, err := ParseConfig([]byte(negative)); err == nil {
+		t.Error("Expected error for negative iptablesLockTimeout")
+	}
+}
+
+func TestParseConfig_FlushConntrack(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"flushConntrack": true,
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if !conf.FlushConntrack {
+		t.Error("Expected FlushConntrack to be enabled")
+	}
+}
+
+func TestParseConfig_MarkNewConnectionsOnly(t *testing.T) {
+	input := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+		"markNewConnectionsOnly": true,
+		"connmark": true,
+		"delegate": {"type": "ptp"}
+	}`
+
+	conf, err := ParseConfig([]byte(input))
+	if err != nil {
+		t.Fatalf("Expected successful parse, got error: %v", err)
+	}
+	if !conf.MarkNewConnectionsOnly {
+		t.Error("Expected MarkNewConnectionsOnly to be enabled")
+	}
+
+	withoutConnmark := strings.Replace(input, `"connmark": true`, `"connmark": false`, 1)
+	if _, err := ParseConfig([]byte(withoutConnmark)); err == nil || !strings.Contains(err.Error(), "requires connmark") {
+		t.Errorf("Expected error for markNewConnectionsOnly without connmark, got %v", err)
+	}
+	withFlush := strings.Replace(input, `"connmark": true`, `"connmark": true, "flushConntrack": true`, 1)
+	if _, err := ParseConfig([]byte(withFlush)); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("Expected error for markNewConnectionsOnly with flushConntrack, got %v", err)
+	}
+}
+
+func TestParseConfig_MetricsFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		metricsFile string
+		wantErr     bool
+	}{
+		{name: "absolute path", metricsFile: "/var/lib/node_exporter/textfile/tenant_routing.prom"},
+		{name: "relative path", metricsFile: "tenant_routing.prom", wantErr: true},
+		{name: "path traversal", metricsFile: "/var/lib/../etc/passwd", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := `{
+				"cniVersion": "1.0.0",
+				"name": "tenant-routing",
+				"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+				"metricsFile": "` + tt.metricsFile + `",
+				"delegate": {"type": "ptp"}
+			}`
+
+			conf, err := ParseConfig([]byte(input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && conf.MetricsFile != tt.metricsFile {
+				t.Errorf("Expected MetricsFile %q, got %q", tt.metricsFile, conf.MetricsFile)
+			}
+		})
+	}
+}
+
+func TestParseConfig_StateDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		stateDir string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", stateDir: "", want: DefaultStateDir},
+		{name: "absolute path", stateDir: "/run/tenant-routing", want: "/run/tenant-routing"},
+		{name: "relative path", stateDir: "state", wantErr: true},
+		{name: "path traversal", stateDir: "/var/lib/../etc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := `{
+				"cniVersion": "1.0.0",
+				"name": "tenant-routing",
+				"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+				"stateDir": "` + tt.stateDir + `",
+				"delegate": {"type": "ptp"}
+			}`
+
+			conf, err := ParseConfig([]byte(input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && conf.StateDir != tt.want {
+				t.Errorf("Expected StateDir %q, got %q", tt.want, conf.StateDir)
+			}
+		})
+	}
+}
+
+func TestParseConfig_NoIPs(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   string
+		errMsg string
+	}{
+		{name: "default", value: "", want: NoIPsSkip},
+		{name: "skip", value: `"noIPs": "skip",`, want: NoIPsSkip},
+		{name: "fail", value: `"noIPs": "fail",`, want: NoIPsFail},
+		{name: "invalid", value: `"noIPs": "ignore",`, errMsg: "noIPs must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := `{
+				"cniVersion": "1.0.0",
+				"name": "tenant-routing",
+				"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+				` + tt.value + `
+				"delegate": {"type": "macvlan"}
+			}`
+
+			conf, err := ParseConfig([]byte(input))
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("Expected error containing %q, got: %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected successful parse, got error: %v", err)
+			}
+			if conf.NoIPs != tt.want {
+				t.Errorf("Expected NoIPs %q, got %q", tt.want, conf.NoIPs)
+			}
+		})
+	}
+}
+
+// TestParseConfig_PrevResultPolicy verifies the prevResult policy and libcni cache dir
+func TestParseConfig_PrevResultPolicy(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.PrevResultPolicy != PrevResultStateFallback || conf.CNICacheDir != DefaultCNICacheDir {
+		t.Errorf("defaults = %q, %q; want %q, %q", conf.PrevResultPolicy, conf.CNICacheDir,
+			PrevResultStateFallback, DefaultCNICacheDir)
+	}
+
+	for _, policy := range []string{PrevResultRequire, PrevResultStateFallback, PrevResultSkip} {
+		conf, err := ParseConfig([]byte(`{` + base + `, "prevResultPolicy": "` + policy + `"}`))
+		if err != nil || conf.PrevResultPolicy != policy {
+			t.Errorf("ParseConfig(%s) = %v, %v; want policy %q", policy, conf, err, policy)
+		}
+	}
+
+	for value, errMsg := range map[string]string{
+		`"prevResultPolicy": "ignore"`: "prevResultPolicy must be",
+		`"cniCacheDir": "var/lib/cni"`: "cniCacheDir path must be absolute",
+		`"cniCacheDir": "/var/../tmp"`: "cannot contain '..'",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_ResultVersion verifies the result version override and its default
+func TestParseConfig_ResultVersion(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `}`))
+	if err != nil || conf.ResultCNIVersion() != "1.0.0" {
+		t.Fatalf("ParseConfig() = %v, %v; want results in cniVersion 1.0.0", conf, err)
+	}
+	fingerprint := conf.Fingerprint()
+
+	conf, err = ParseConfig([]byte(`{` + base + `, "resultVersion": "0.3.1"}`))
+	if err != nil || conf.ResultCNIVersion() != "0.3.1" {
+		t.Errorf("ParseConfig() = %v, %v; want results in 0.3.1", conf, err)
+	}
+	if err == nil && conf.Fingerprint() != fingerprint {
+		t.Error("resultVersion changed the fingerprint")
+	}
+
+	if _, err := ParseConfig([]byte(`{` + base + `, "resultVersion": "0.2.0"}`)); err == nil ||
+		!strings.Contains(err.Error(), "resultVersion must be one of") {
+		t.Errorf("ParseConfig() error = %v, want unsupported resultVersion", err)
+	}
+}
+
+// TestParseConfig_NodeLock verifies node lock defaults and validation
+func TestParseConfig_NodeLock(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.LockFile != DefaultLockFile || conf.LockTimeout != DefaultLockTimeout {
+		t.Errorf("defaults = %q, %d; want %q, %d", conf.LockFile, conf.LockTimeout, DefaultLockFile, DefaultLockTimeout)
+	}
+
+	for value, errMsg := range map[string]string{
+		`"lockFile": "tenant-routing.lock"`: "lockFile path must be absolute",
+		`"lockFile": "/run/../tmp/x.lock"`:  "cannot contain '..'",
+		`"lockTimeout": -1`:                 "lockTimeout must not be negative",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_K8sRetries verifies the API retry settings are bounded
+func TestParseConfig_K8sRetries(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `, "k8sRetryAttempts": 5, "k8sRetryBackoff": 500}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.K8sRetryAttempts != 5 || conf.K8sRetryBackoff != 500 {
+		t.Errorf("retries = %d, %dms; want 5, 500ms", conf.K8sRetryAttempts, conf.K8sRetryBackoff)
+	}
+
+	for value, errMsg := range map[string]string{
+		`"k8sRetryAttempts": -1`: "k8sRetryAttempts must be between 1 and 10",
+		`"k8sRetryAttempts": 11`: "k8sRetryAttempts must be between 1 and 10",
+		`"k8sRetryBackoff": -1`:  "k8sRetryBackoff must not be negative",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_NamespaceLabels verifies namespace label rules need a selector and a fwmark
+func TestParseConfig_NamespaceLabels(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `, "namespaceLabels": [
+		{"selector": "tenant=a", "fwmark": "0x10"}, {"selector": "team in (payments,billing)", "fwmark": "0x20"}]}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if len(conf.NamespaceLabels) != 2 || conf.NamespaceLabels[1].Fwmark != "0x20" {
+		t.Errorf("NamespaceLabels = %+v, want 2 rules", conf.NamespaceLabels)
+	}
+
+	for value, errMsg := range map[string]string{
+		`"namespaceLabels": [{"selector": "", "fwmark": "0x10"}]`:                                   "/namespaceLabels/0/selector: selector",
+		`"namespaceLabels": [{"selector": "tenant in (", "fwmark": "0x10"}]`:                        "/namespaceLabels/0/selector: selector",
+		`"namespaceLabels": [{"selector": "tenant=a", "fwmark": "tenant-a"}]`:                       "/namespaceLabels/0/fwmark: fwmark value 'tenant-a' not in allowed set",
+		`"namespaceLabels": [{"selector": "tenant=a", "fwmark": "0x30"}]`:                           "/namespaceLabels/0/fwmark: fwmark value '0x30' not in allowed set",
+		`"namespaceLabels": [{"selector": "tenant=a", "fwmark": "0x10"}, {"selector": "tenant=b"}]`: "/namespaceLabels/1/fwmark",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_Tenants verifies static tenants are validated like annotations and make
+// the kubeconfig optional
+func TestParseConfig_Tenants(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing", "delegate": {"type": "macvlan"},
+		"routing": {"tables": {"0x10": {"table": 100}}}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `, "tenants": {
+		"team-a": {"fwmark": "0x10", "table": 100, "gateway": "10.10.10.131"}, "team-b": {"fwmark": "0x20"}}}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.Kubeconfig != "" || conf.Tenants["team-a"].Gateway != "10.10.10.131" || conf.Tenants["team-b"].Fwmark != "0x20" {
+		t.Errorf("Tenants = %+v, want 2 tenants and no kubeconfig", conf.Tenants)
+	}
+
+	for value, errMsg := range map[string]string{
+		`"tenants": {}`: "/kubeconfig: kubeconfig path is required",
+		`"tenants": {"team-a": {"fwmark": "0x99"}}`:                 "/tenants/team-a/fwmark: fwmark value '0x99' not in allowed set",
+		`"tenants": {"team-a": {"fwmark": "0x10", "gateway": "x"}}`: "/tenants/team-a/gateway: gateway value 'x'",
+		`"tenants": {"": {"fwmark": "0x10"}}`:                       "/tenants/: tenants key must be a namespace name",
+		`"tenants": {"team-a": {"fwmark": "0x10", "table": 200}}`:   "/tenants/team-a/table: table 200 of namespace team-a: fwmark 0x10 is configured with table 100",
+		`"tenants": {"team-a": {"fwmark": "0x20", "table": 200}}`:   "no routing table configured for fwmark 0x20",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_MarkHooks verifies hooks and the settings they must not be combined with
+func TestParseConfig_MarkHooks(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "ptp"},
+		"routing": {"tables": {"0x10": {"table": 100}}}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `, "markHooks": {"0x10": "output", "0x20": "postrouting"}}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if got := conf.MarkHook("0x10"); got != "output" {
+		t.Errorf("MarkHook(0x10) = %q, want output", got)
+	}
+	if got := conf.MarkHook("0x20"); got != "postrouting" {
+		t.Errorf("MarkHook(0x20) = %q, want postrouting", got)
+	}
+	if got := conf.MarkHook("0x30"); got != "" {
+		t.Errorf("MarkHook(0x30) = %q, want \"\"", got)
+	}
+
+	for value, errMsg := range map[string]string{
+		`"markHooks": {"0x99": "output"}`:                   "/markHooks/0x99: fwmark value '0x99' not in allowed set",
+		`"markHooks": {"0x20": "input"}`:                    `/markHooks/0x20: hook "input" for fwmark 0x20 must be one of`,
+		`"markHooks": {"0x20": ""}`:                         `hook "" for fwmark 0x20 must be one of`,
+		`"markHooks": {"0x10": "postrouting"}`:              "past the routing decision",
+		`"markHooks": {"0x10": "forward"}`:                  "past the routing decision",
+		`"markHooks": {"0x20": "output"}, "connmark": true`: "excludes connmark",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+	if _, err := ParseConfig([]byte(`{` + base + `, "markHooks": {"0x20": "prerouting"}, "connmark": true}`)); err != nil {
+		t.Errorf("ParseConfig() with hook prerouting and connmark error = %v", err)
+	}
+}
+
+// TestParseConfig_Exclusions verifies excluded namespaces and the excluded pod selector
+func TestParseConfig_Exclusions(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `, "excludeNamespaces": ["kube-system", "cilium"],
+		"excludePodLabelSelector": "k8s-app in (cilium,kube-dns)"}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if len(conf.ExcludeNamespaces) != 2 || conf.ExcludePodLabelSelector != "k8s-app in (cilium,kube-dns)" {
+		t.Errorf("exclusions = %v, %q", conf.ExcludeNamespaces, conf.ExcludePodLabelSelector)
+	}
+
+	for value, errMsg := range map[string]string{
+		`"excludeNamespaces": ["kube-system", ""]`:  "/excludeNamespaces/1: excludeNamespaces must be namespace names",
+		`"excludePodLabelSelector": "k8s-app in ("`: "/excludePodLabelSelector: excludePodLabelSelector",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_AnnotationKeys verifies annotationKey takes a key or a list in priority order
+func TestParseConfig_AnnotationKeys(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `, "annotationKey": ["tenant.routing/fwmark", "net.example.com/mark"]}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if got := conf.AnnotationKey; !reflect.DeepEqual(got, AnnotationKeys{"tenant.routing/fwmark", "net.example.com/mark"}) {
+		t.Errorf("AnnotationKey = %q", got)
+	}
+	data, err := json.Marshal(conf.AnnotationKey)
+	if err != nil || string(data) != `["tenant.routing/fwmark","net.example.com/mark"]` {
+		t.Errorf("Marshal() = %s, %v", data, err)
+	}
+
+	// A single key is written back as a string, so fingerprints stay the same
+	single, err := ParseConfig([]byte(`{` + base + `, "annotationKey": ["tenant.routing/fwmark"]}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	def, err := ParseConfig([]byte(`{` + base + `}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if single.Fingerprint() != def.Fingerprint() {
+		t.Error("Fingerprint() of a one-key list differs from the default key")
+	}
+
+	for value, errMsg := range map[string]string{
+		`"annotationKey": ["tenant.routing/fwmark", "tenant.routing/fwmark"]`: "/annotationKey/1: duplicate annotation key",
+		`"annotationKey": ["tenant.routing/fwmark", "a,b"]`:                   "/annotationKey/1: \"a,b\" is not a valid annotation key",
+		`"annotationKey": [""]`: "/annotationKey/0:",
+		`"annotationKey": 16`:   "annotationKey must be a string or a list of strings",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_AgentSocket verifies the node agent socket is optional and absolute
+func TestParseConfig_AgentSocket(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `, "agentSocket": "/run/tenant-routing/agent.sock"}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.AgentSocket != "/run/tenant-routing/agent.sock" {
+		t.Errorf("AgentSocket = %q, want /run/tenant-routing/agent.sock", conf.AgentSocket)
+	}
+
+	for value, errMsg := range map[string]string{
+		`"agentSocket": "agent.sock"`:         "agentSocket path must be absolute",
+		`"agentSocket": "/run/../tmp/a.sock"`: "cannot contain '..'",
+	} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil || !strings.Contains(err.Error(), errMsg) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", value, err, errMsg)
+		}
+	}
+}
+
+// TestParseConfig_AnnotationCacheTTL verifies the annotation cache is opt-in and bounded
+func TestParseConfig_AnnotationCacheTTL(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "macvlan"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.AnnotationCacheTTL != 0 {
+		t.Errorf("default AnnotationCacheTTL = %d, want 0 (disabled)", conf.AnnotationCacheTTL)
+	}
+
+	conf, err = ParseConfig([]byte(`{` + base + `, "annotationCacheTTL": 30}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.AnnotationCacheTTL != 30 {
+		t.Errorf("AnnotationCacheTTL = %d, want 30", conf.AnnotationCacheTTL)
+	}
+
+	for _, value := range []string{`"annotationCacheTTL": -1`, `"annotationCacheTTL": 301`} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil ||
+			!strings.Contains(err.Error(), "annotationCacheTTL must be between 0 and 300") {
+			t.Errorf("ParseConfig(%s) error = %v, want annotationCacheTTL bound error", value, err)
+		}
+	}
+
+	conf, err = ParseConfig([]byte(`{` + base + `, "negativeAnnotationCacheTTL": 5}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if conf.NegativeAnnotationCacheTTL != 5 {
+		t.Errorf("NegativeAnnotationCacheTTL = %d, want 5", conf.NegativeAnnotationCacheTTL)
+	}
+	for _, value := range []string{`"negativeAnnotationCacheTTL": -1`, `"negativeAnnotationCacheTTL": 301`} {
+		if _, err := ParseConfig([]byte(`{` + base + `, ` + value + `}`)); err == nil ||
+			!strings.Contains(err.Error(), "negativeAnnotationCacheTTL must be between 0 and 300") {
+			t.Errorf("ParseConfig(%s) error = %v, want negativeAnnotationCacheTTL bound error", value, err)
+		}
+	}
+}
+
+// TestParseConfig_RuntimeConfig verifies the runtimeConfig block is exposed with all its entries
+func TestParseConfig_RuntimeConfig(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing",
+		"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig", "delegate": {"type": "ptp"}`
+
+	conf, err := ParseConfig([]byte(`{` + base + `}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	if !conf.RuntimeConfig.Empty() {
+		t.Errorf("RuntimeConfig = %+v without a runtimeConfig block, want empty", conf.RuntimeConfig)
+	}
+
+	conf, err = ParseConfig([]byte(`{` + base + `, "capabilities": {"portMappings": true, "bandwidth": true},
+		"runtimeConfig": {"portMappings": [{"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}],
+			"bandwidth": {"ingressRate": 1000000, "egressRate": 2000000},
+			"ipRanges": [[{"subnet": "10.200.1.0/24", "gateway": "10.200.1.1"}]],
+			"cgroupPath": "/kubepods/pod1"}}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	rc := conf.RuntimeConfig
+	if rc.Empty() || len(rc.PortMappings) != 1 || rc.PortMappings[0] != (PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}) {
+		t.Fatalf("PortMappings = %+v", rc)
+	}
+	if rc.Bandwidth == nil || rc.Bandwidth.IngressRate != 1000000 || rc.Bandwidth.EgressRate != 2000000 {
+		t.Errorf("Bandwidth = %+v", rc.Bandwidth)
+	}
+	if len(rc.IPRanges) != 1 || rc.IPRanges[0][0].Gateway != "10.200.1.1" {
+		t.Errorf("IPRanges = %+v", rc.IPRanges)
+	}
+	if string(rc.Raw["cgroupPath"]) != `"/kubepods/pod1"` {
+		t.Errorf("Raw = %s, want the cgroupPath entry", rc.Raw)
+	}
+	if !conf.Capabilities["portMappings"] || !conf.Capabilities["bandwidth"] {
+		t.Errorf("Capabilities = %v", conf.Capabilities)
+	}
+
+	// The block round-trips with the entries of other capabilities
+	data, err := json.Marshal(rc)
+	if err != nil || !strings.Contains(string(data), `"cgroupPath":"/kubepods/pod1"`) {
+		t.Errorf("Marshal(RuntimeConfig) = %s, %v", data, err)
+	}
+
+	if _, err := ParseConfig([]byte(`{` + base + `, "runtimeConfig": {"portMappings": {}}}`)); err == nil ||
+		!strings.Contains(err.Error(), "invalid runtimeConfig") {
+		t.Errorf("ParseConfig() error = %v, want invalid runtimeConfig", err)
+	}
+}
+
+// TestParseConfig_Logging verifies log format, level and file validation
+func TestParseConfig_Logging(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		wantFormat string
+		errMsg     string
+	}{
+		{name: "default", value: "", wantFormat: LogFormatText},
+		{name: "json", value: `"logFormat": "json", "logLevel": "debug", "logFile": "/var/log/tenant-routing.log",`, wantFormat: LogFormatJSON},
+		{name: "invalid format", value: `"logFormat": "logfmt",`, errMsg: "logFormat must be"},
+		{name: "invalid level", value: `"logLevel": "trace",`, errMsg: "logLevel must be"},
+		{name: "relative file", value: `"logFile": "tenant-routing.log",`, errMsg: "logFile path must be absolute"},
+		{name: "traversal", value: `"logFile": "/var/log/../tmp/x.log",`, errMsg: "cannot contain '..'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := `{
+				"cniVersion": "1.0.0",
+				"name": "tenant-routing",
+				"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+				` + tt.value + `
+				"delegate": {"type": "macvlan"}
+			}`
+
+			conf, err := ParseConfig([]byte(input))
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("Expected error containing %q, got: %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected successful parse, got error: %v", err)
+			}
+			if conf.LogFormat != tt.wantFormat {
+				t.Errorf("Expected LogFormat %q, got %q", tt.wantFormat, conf.LogFormat)
+			}
+		})
+	}
+}
+
+// TestParseConflist verifies the wrapper entry is found and inherits name and cniVersion
+func TestParseConflist(t *testing.T) {
+	conflist := `{
+		"cniVersion": "1.0.0",
+		"name": "tenant-net",
+		"plugins": [
+			{"type": "portmap"},
+			{"type": "tenant-routing-wrapper", "kubeconfig": "/etc/kubernetes/kubelet.conf", "delegate": {"type": "ptp"}}
+		]
+	}`
+
+	conf, err := ParseConflist([]byte(conflist))
+	if err != nil {
+		t.Fatalf("ParseConflist() error = %v", err)
+	}
+	if conf.Name != "tenant-net" || conf.CNIVersion != "1.0.0" {
+		t.Errorf("Name/CNIVersion = %q/%q, want tenant-net/1.0.0", conf.Name, conf.CNIVersion)
+	}
+
+	_, err = ParseConflist([]byte(`{"name":"n","plugins":[{"type":"bridge"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "has no tenant-routing-wrapper plugin") {
+		t.Errorf("error = %v, want missing plugin error", err)
+	}
+}
+
+func TestPluginConf_Fingerprint(t *testing.T) {
+	base := `"cniVersion": "1.0.0", "name": "tenant-routing", "type": "tenant-routing-wrapper",
+		"kubeconfig": "/etc/kubeconfig", "delegate": {"type": "ptp"}`
+	parse := func(extra string) string {
+		t.Helper()
+		conf, err := ParseConfig([]byte(`{` + base + extra + `}`))
+		if err != nil {
+			t.Fatalf("ParseConfig() error = %v", err)
+		}
+		return conf.Fingerprint()
+	}
+
+	want := parse("")
+	if len(want) != 16 {
+		t.Errorf("Fingerprint() = %q, want 16 hex digits", want)
+	}
+
+	// Defaults, per-invocation input and unknown fields do not change the fingerprint
+	for name, extra := range map[string]string{
+		"explicit default": `, "annotationKey": "tenant.routing/fwmark"`,
+		"prevResult":       `, "prevResult": {"cniVersion": "1.0.0", "ips": [{"address": "10.200.1.5/24"}]}`,
+		"runtimeConfig":    `, "runtimeConfig": {"portMappings": []}`,
+	} {
+		if got := parse(extra); got != want {
+			t.Errorf("%s: Fingerprint() = %q, want %q", name, got, want)
+		}
+	}
+
+	// Effective changes do
+	for name, extra := range map[string]string{
+		"strict":  `, "strict": true`,
+		"routing": `, "routing": {"tables": {"0x10": {"table": 100}}}`,
+	} {
+		if got := parse(extra); got == want {
+			t.Errorf("%s: Fingerprint() unchanged", name)
+		}
+	}
+}
+
+func TestParseConfig_DelegateList(t *testing.T) {
+	parse := func(delegate string) error {
+		_, err := ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "tenant-routing", "type": "tenant-routing-wrapper",
+			"kubeconfig": "/etc/kubeconfig", "delegate": ` + delegate + `}`))
+		return err
+	}
+
+	if err := parse(`[{"type": "ptp"}, {"type": "tuning"}]`); err != nil {
+		t.Errorf("ParseConfig() with delegate list error = %v", err)
+	}
+	for _, invalid := range []string{`[]`, `[{"type": "ptp"}, {"mtu": 1400}]`, `["ptp"]`} {
+		if err := parse(invalid); err == nil {
+			t.Errorf("ParseConfig() expected error for delegate %s", invalid)
+		}
+	}
+}
+
+// TestParseConfig_DelegateFile verifies the delegate is loaded from a .conf or the plugins
+// of a .conflist, reloaded when the file changes and exclusive with an inline delegate
+func TestParseConfig_DelegateFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "10-base.conf")
+	parse := func(extra string) (*PluginConf, error) {
+		return ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "tenant-routing", "type": "tenant-routing-wrapper",
+			"kubeconfig": "/etc/kubeconfig", "delegateFile": "` + path + `"` + extra + `}`))
+	}
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Now().Add(-time.Hour)
+
+	write(`{"cniVersion": "0.4.0", "name": "base", "type": "ptp", "ipam": {"type": "host-local"}}`, modTime)
+	conf, err := parse("")
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	var delegate map[string]any
+	if err := json.Unmarshal(conf.Delegate, &delegate); err != nil || delegate["type"] != "ptp" {
+		t.Errorf("Delegate = %s, want the ptp plugin", conf.Delegate)
+	}
+	if conf.Chained() {
+		t.Error("Chained() = true with a delegate file")
+	}
+
+	write(`{"cniVersion": "1.0.0", "name": "base", "plugins": [{"type": "bridge"}, {"type": "tuning"}]}`, modTime.Add(time.Minute))
+	if conf, err = parse(""); err != nil {
+		t.Fatalf("ParseConfig() of conflist error = %v", err)
+	}
+	var plugins []map[string]any
+	if err := json.Unmarshal(conf.Delegate, &plugins); err != nil || len(plugins) != 2 || plugins[0]["type"] != "bridge" {
+		t.Errorf("Delegate = %s, want the plugins of the conflist", conf.Delegate)
+	}
+
+	if _, err := parse(`, "delegate": {"type": "ptp"}`); err == nil || !strings.Contains(err.Error(), "/delegateFile") {
+		t.Errorf("inline delegate: error = %v, want a /delegateFile error", err)
+	}
+	write(`{"cniVersion": "1.0.0", "name": "base"}`, modTime.Add(2*time.Minute))
+	if _, err := parse(""); err == nil || !strings.Contains(err.Error(), "/delegateFile") {
+		t.Errorf("delegate file without type: error = %v, want a /delegateFile error", err)
+	}
+	path = filepath.Join(dir, "missing.conf")
+	if _, err := parse(""); err == nil || !strings.Contains(err.Error(), "/delegateFile") {
+		t.Errorf("missing delegate file: error = %v, want a /delegateFile error", err)
+	}
+}
+
+// TestParseConfig_SecurePaths verifies securePaths refuses a kubeconfig redirected
+// outside the trusted roots, uses the resolved path of one within them and is off by
+// default
+func TestParseConfig_SecurePaths(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("securePaths needs files owned by root")
+	}
+	root, outside := t.TempDir(), t.TempDir()
+	kubeconfig := filepath.Join(root, "kubelet.conf")
+	if err := os.WriteFile(filepath.Join(outside, "kubelet.conf"), []byte("apiVersion: v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "kubelet.conf"), kubeconfig); err != nil {
+		t.Fatal(err)
+	}
+	parse := func(extra string) error {
+		_, err := ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "tenant-routing", "type": "tenant-routing-wrapper",
+			"kubeconfig": "` + kubeconfig + `", "stateDir": "` + filepath.Join(root, "state") + `",
+			"delegate": {"type": "ptp"}` + extra + `}`))
+		return err
+	}
+
+	if err := parse(""); err != nil {
+		t.Errorf("ParseConfig() without securePaths error = %v", err)
+	}
+	err := parse(`, "securePaths": true, "securePathRoots": ["` + root + `"]`)
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Path != "/kubeconfig" ||
+		!strings.Contains(fieldErr.Msg, safepath.ErrUnsafe.Error()) {
+		t.Errorf("ParseConfig() with a kubeconfig outside the root error = %v, want an unsafe /kubeconfig", err)
+	}
+	if err := parse(`, "securePaths": true, "securePathRoots": ["` + root + `", "` + outside + `"]`); err != nil {
+		t.Errorf("ParseConfig() with both roots trusted error = %v", err)
+	}
+	if err := parse(`, "securePaths": true, "securePathRoots": ["etc"]`); err == nil {
+		t.Error("ParseConfig() with a relative root succeeded")
+	}
+
+	// A symlink within the root is replaced by its target; the fingerprint keeps the link
+	target := filepath.Join(root, "kubernetes", "kubelet.conf")
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("apiVersion: v1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(kubeconfig); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, kubeconfig); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "tenant-routing", "type": "tenant-routing-wrapper",
+		"kubeconfig": "` + kubeconfig + `", "delegate": {"type": "ptp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secure, err := ParseConfig([]byte(`{"cniVersion": "1.0.0", "name": "tenant-routing", "type": "tenant-routing-wrapper",
+		"kubeconfig": "` + kubeconfig + `", "delegate": {"type": "ptp"}, "securePaths": true,
+		"securePathRoots": ["` + root + `", "/var/lib"]}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() with a symlink within the root error = %v", err)
+	}
+	if secure.Kubeconfig != target {
+		t.Errorf("Kubeconfig = %s, want the resolved %s", secure.Kubeconfig, target)
+	}
+	if secure.Fingerprint() != plain.Fingerprint() {
+		t.Error("resolving the kubeconfig changed the fingerprint")
+	}
+}
+
+// TestParseConfig_ValidationError verifies every invalid value is reported at its JSON
+// pointer, relative to the plugin config or to the conflist
+func TestParseConfig_ValidationError(t *testing.T) {
+	plugin := `{"type": "tenant-routing-wrapper", "kubeconfig": "kubelet.conf", "delegate": {"type": "ptp"},
+		"noIPs": "ignore", "routing": {"tables": {
+			"0x10": {"table": 100, "gateway": "fd00::1"},
+			"0x20/0xff": {"table": 254, "enforce": true, "destinations": ["10.0.0.0/8", "tenant-b"]}
+		}}}`
+	want := []string{
+		"/kubeconfig",
+		"/noIPs",
+		"/routing/tables/0x10/gateway",
+		"/routing/tables/0x20~10xff",
+		"/routing/tables/0x20~10xff/table",
+		"/routing/tables/0x20~10xff/destinations/1",
+	}
+
+	paths := func(err error) []string {
+		t.Helper()
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			t.Fatalf("error = %v, want a *ValidationError", err)
+		}
+		var got []string
+		for _, fieldErr := range invalid.Errors {
+			got = append(got, fieldErr.Path)
+		}
+		return got
+	}
+
+	_, err := ParseConfig([]byte(plugin))
+	if got := paths(err); !reflect.DeepEqual(got, want) {
+		t.Errorf("paths = %q, want %q", got, want)
+	}
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Error() != "/kubeconfig: kubeconfig path must be absolute, got: kubelet.conf" {
+		t.Errorf("first field error = %v", fieldErr)
+	}
+
+	_, err = ParseConflist([]byte(`{"cniVersion": "1.0.0", "name": "tenant-net", "plugins": [{"type": "portmap"}, ` +
+		plugin + `]}`))
+	got := paths(err)
+	if len(got) != len(want) || got[0] != "/plugins/1/kubeconfig" || got[2] != "/plugins/1/routing/tables/0x10/gateway" {
+		t.Errorf("conflist paths = %q, want them below /plugins/1", got)
+	}
+}
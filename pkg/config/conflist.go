@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/conflist"
+)
+
+// PluginType is the CNI type of the wrapper inside a conflist
+const PluginType = "tenant-routing-wrapper"
+
+// ExtractPluginConfig returns the wrapper's plugin config from a conflist
+// A plain plugin config (no "plugins" array) is returned unchanged. For a conflist,
+// the top-level name and cniVersion are injected like libcni does.
+func ExtractPluginConfig(data []byte) ([]byte, error) {
+	if !conflist.IsList(data) {
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("failed to parse conflist: invalid JSON")
+		}
+		return data, nil
+	}
+
+	list, err := conflist.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return list.PluginConfig(PluginType)
+}
+
+// ParseConflist parses and validates the wrapper's configuration from a conflist
+// (or a single plugin config), as used by invocations outside the CNI runtime
+// The paths of a *ValidationError point into data, e.g. "/plugins/1/routing/tables/0x10".
+func ParseConflist(data []byte) (*PluginConf, error) {
+	pluginConfig, err := ExtractPluginConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := ParseConfig(pluginConfig)
+	if err != nil {
+		var invalid *ValidationError
+		if errors.As(err, &invalid) && conflist.IsList(data) {
+			// ExtractPluginConfig has found the wrapper in the list
+			list, _ := conflist.Parse(data)
+			err = invalid.within(pointer("plugins", list.Index(PluginType)))
+		}
+		return nil, fmt.Errorf("invalid plugin configuration: %w", err)
+	}
+	return conf, nil
+}
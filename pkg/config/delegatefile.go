@@ -0,0 +1,78 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// delegateFiles caches the delegate blocks read by loadDelegateFile, by path
+// An entry is used while the file keeps its modification time and size, so the agent
+// does not re-read and re-validate it on every reload.
+var delegateFiles = struct {
+	sync.Mutex
+	entries map[string]delegateFileEntry
+}{entries: map[string]delegateFileEntry{}}
+
+// delegateFileEntry is the delegate block of a file as of its modification time and size
+type delegateFileEntry struct {
+	modTime  time.Time
+	size     int64
+	delegate json.RawMessage
+}
+
+// loadDelegateFile returns the delegate block of the CNI configuration file at path
+// A plugin configuration (.conf) is the delegate as it is; the plugins of a conflist
+// become a delegate list. Name and cniVersion are replaced by those of the wrapper
+// when the delegate runs.
+func loadDelegateFile(path string) (json.RawMessage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	delegateFiles.Lock()
+	defer delegateFiles.Unlock()
+	if entry, ok := delegateFiles.entries[path]; ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.delegate, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	delegate, err := parseDelegateFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	delegateFiles.entries[path] = delegateFileEntry{modTime: info.ModTime(), size: info.Size(), delegate: delegate}
+	return delegate, nil
+}
+
+// parseDelegateFile returns the delegate block of a plugin configuration or conflist
+func parseDelegateFile(data []byte) (json.RawMessage, error) {
+	var file struct {
+		Type    string          `json:"type"`
+		Plugins json.RawMessage `json:"plugins"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid delegate file: %w", err)
+	}
+	switch {
+	case len(file.Plugins) > 0:
+		if !bytes.HasPrefix(bytes.TrimSpace(file.Plugins), []byte("[")) {
+			return nil, errors.New("plugins of the delegate file must be a list")
+		}
+		return file.Plugins, nil
+	case file.Type == "":
+		return nil, errors.New("delegate file is missing required 'type' field")
+	}
+	return bytes.TrimSpace(data), nil
+}
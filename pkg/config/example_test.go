@@ -3,6 +3,7 @@ package config_test
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
 )
@@ -34,7 +35,7 @@ func ExampleParseConfig() {
 	fmt.Printf("CNI Version: %s\n", conf.CNIVersion)
 	fmt.Printf("Plugin Name: %s\n", conf.Name)
 	fmt.Printf("Kubeconfig: %s\n", conf.Kubeconfig)
-	fmt.Printf("Annotation Key: %s\n", conf.AnnotationKey)
+	fmt.Printf("Annotation Keys: %s\n", strings.Join(conf.AnnotationKey, ", "))
 
 	// Get delegate config to pass to next plugin
 	delegateConfig := conf.GetDelegateConfig()
@@ -44,6 +45,6 @@ func ExampleParseConfig() {
 	// CNI Version: 1.0.0
 	// Plugin Name: tenant-routing
 	// Kubeconfig: /etc/cni/net.d/tenant-routing.kubeconfig
-	// Annotation Key: tenant.routing/fwmark
+	// Annotation Keys: tenant.routing/fwmark
 	// Delegate config length: 97 bytes
 }
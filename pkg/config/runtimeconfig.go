@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RuntimeConfig is the runtimeConfig block the runtime injects for the capabilities a
+// plugin declares (see the CNI conventions), e.g. the port mappings and bandwidth
+// limits kubelet derives from the pod spec
+// The wrapper only reads it; the delegate receives the entries of the capabilities it
+// enables unchanged from stdin (see delegate.DelegateAdd).
+type RuntimeConfig struct {
+	// PortMappings are the hostPorts of the pod (capability "portMappings")
+	PortMappings []PortMapping `json:"portMappings,omitempty"`
+
+	// Bandwidth limits the pod's traffic (capability "bandwidth")
+	Bandwidth *BandwidthEntry `json:"bandwidth,omitempty"`
+
+	// IPRanges overrides the IPAM ranges, one range set per address family
+	// (capability "ipRanges")
+	IPRanges [][]IPRange `json:"ipRanges,omitempty"`
+
+	// Raw holds every entry as passed, those of other capabilities (dns, ips, mac,
+	// cgroupPath...) included
+	Raw map[string]json.RawMessage `json:"-"`
+}
+
+// PortMapping is a hostPort of the pod
+type PortMapping struct {
+	HostPort      int    `json:"hostPort"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol"`
+	HostIP        string `json:"hostIP,omitempty"`
+}
+
+// BandwidthEntry is the traffic shaping of a pod, rates in bits per second and bursts in bits
+type BandwidthEntry struct {
+	IngressRate  uint64 `json:"ingressRate,omitempty"`
+	IngressBurst uint64 `json:"ingressBurst,omitempty"`
+	EgressRate   uint64 `json:"egressRate,omitempty"`
+	EgressBurst  uint64 `json:"egressBurst,omitempty"`
+}
+
+// IPRange is an IPAM range of the ipRanges capability
+type IPRange struct {
+	Subnet     string `json:"subnet"`
+	RangeStart string `json:"rangeStart,omitempty"`
+	RangeEnd   string `json:"rangeEnd,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
+}
+
+// UnmarshalJSON decodes the well-known entries and keeps all of them in Raw
+func (r *RuntimeConfig) UnmarshalJSON(data []byte) error {
+	type known RuntimeConfig
+	var decoded known
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("invalid runtimeConfig: %w", err)
+	}
+	if err := json.Unmarshal(data, &decoded.Raw); err != nil {
+		return fmt.Errorf("invalid runtimeConfig: %w", err)
+	}
+	*r = RuntimeConfig(decoded)
+	return nil
+}
+
+// MarshalJSON encodes Raw, so the block round-trips with the entries of other capabilities
+func (r RuntimeConfig) MarshalJSON() ([]byte, error) {
+	if r.Raw != nil {
+		return json.Marshal(r.Raw)
+	}
+	type known RuntimeConfig
+	return json.Marshal(known(r))
+}
+
+// Empty reports whether the runtime passed no runtimeConfig entries (true for nil)
+func (r *RuntimeConfig) Empty() bool {
+	return r == nil || len(r.Raw) == 0 && len(r.PortMappings) == 0 && r.Bandwidth == nil && len(r.IPRanges) == 0
+}
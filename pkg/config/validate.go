@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pointerEscaper escapes a JSON pointer reference token
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// FieldError is one invalid value of a network configuration
+type FieldError struct {
+	// Path is the JSON pointer (RFC 6901) of the value, e.g. "/routing/tables/0x10/gateway"
+	Path string
+
+	// Msg says what is wrong with the value
+	Msg string
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Msg
+}
+
+// ValidationError lists every invalid value ParseConfig found, in the order checked
+// Use errors.As to get at the paths, e.g. to annotate the lines of a conflist change.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		msgs[i] = fieldErr.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the field errors, so errors.As finds the first *FieldError
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		errs[i] = fieldErr
+	}
+	return errs
+}
+
+// within returns e with every path moved below the JSON pointer prefix, e.g. "/plugins/1"
+func (e *ValidationError) within(prefix string) *ValidationError {
+	moved := &ValidationError{Errors: make([]*FieldError, len(e.Errors))}
+	for i, fieldErr := range e.Errors {
+		moved.Errors[i] = &FieldError{Path: prefix + fieldErr.Path, Msg: fieldErr.Msg}
+	}
+	return moved
+}
+
+// validation collects the field errors of one configuration
+type validation struct {
+	errs []*FieldError
+}
+
+// addf records that the value at path is invalid
+func (v *validation) addf(path, format string, args ...any) {
+	v.errs = append(v.errs, &FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// err returns the collected errors as a *ValidationError, nil if there are none
+func (v *validation) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// pointer joins reference tokens to a JSON pointer, escaping "~" and "/" in them
+// ("0x10/0xff" becomes "0x10~10xff")
+func pointer(tokens ...any) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(pointerEscaper.Replace(fmt.Sprint(token)))
+	}
+	return b.String()
+}
@@ -0,0 +1,253 @@
+// Package conflist builds and edits CNI .conflist documents.
+//
+// The installer, the simulator and tests need to put the wrapper into an existing
+// network configuration without losing anything they do not understand. Documents
+// are kept as raw JSON per field, so unknown top-level and per-plugin fields
+// round-trip unchanged (key order is normalized on output):
+//
+//	list, err := conflist.Parse(data)
+//	wrapper := conflist.NewPlugin("tenant-routing-wrapper")
+//	_ = wrapper.Set("kubeconfig", "/etc/kubernetes/kubelet.conf")
+//	changed, err := list.Wrap("ptp", wrapper) // ptp becomes wrapper.delegate
+//	out, err := list.Marshal()
+package conflist
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Plugin is one entry of the plugins array
+type Plugin struct {
+	fields map[string]json.RawMessage
+}
+
+// NewPlugin returns a plugin configuration with only its type set
+func NewPlugin(pluginType string) *Plugin {
+	p := &Plugin{fields: map[string]json.RawMessage{}}
+	_ = p.Set("type", pluginType)
+	return p
+}
+
+// Type returns the CNI type of the plugin (empty if missing or not a string)
+func (p *Plugin) Type() string {
+	var t string
+	_, _ = p.Get("type", &t)
+	return t
+}
+
+// Get decodes field key into v; reports whether the field exists
+func (p *Plugin) Get(key string, v any) (bool, error) {
+	return get(p.fields, key, v)
+}
+
+// Set encodes v into field key
+func (p *Plugin) Set(key string, v any) error {
+	return set(p.fields, key, v)
+}
+
+// Delete removes field key
+func (p *Plugin) Delete(key string) {
+	delete(p.fields, key)
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *Plugin) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.fields)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *Plugin) UnmarshalJSON(data []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	p.fields = fields
+	return nil
+}
+
+// List is a parsed .conflist document
+type List struct {
+	// fields holds every top-level field except plugins
+	fields map[string]json.RawMessage
+
+	// Plugins in chain order
+	Plugins []*Plugin
+}
+
+// New returns an empty conflist
+func New(name, cniVersion string) *List {
+	l := &List{fields: map[string]json.RawMessage{}}
+	_ = l.Set("name", name)
+	_ = l.Set("cniVersion", cniVersion)
+	return l
+}
+
+// Parse parses a .conflist document
+func Parse(data []byte) (*List, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse conflist: %w", err)
+	}
+
+	l := &List{fields: fields}
+	if raw, ok := fields["plugins"]; ok {
+		if err := json.Unmarshal(raw, &l.Plugins); err != nil {
+			return nil, fmt.Errorf("failed to parse conflist plugins: %w", err)
+		}
+		delete(fields, "plugins")
+	}
+	for i, p := range l.Plugins {
+		if p == nil {
+			return nil, fmt.Errorf("conflist plugin %d is null", i)
+		}
+	}
+	return l, nil
+}
+
+// IsList reports whether data is a conflist (has a plugins array) rather than a single plugin config
+func IsList(data []byte) bool {
+	var probe struct {
+		Plugins json.RawMessage `json:"plugins"`
+	}
+	return json.Unmarshal(data, &probe) == nil && len(probe.Plugins) > 0 && !bytes.Equal(probe.Plugins, []byte("null"))
+}
+
+// Name returns the network name
+func (l *List) Name() string {
+	var name string
+	_, _ = l.Get("name", &name)
+	return name
+}
+
+// CNIVersion returns the top-level cniVersion
+func (l *List) CNIVersion() string {
+	var v string
+	_, _ = l.Get("cniVersion", &v)
+	return v
+}
+
+// Get decodes top-level field key into v; reports whether the field exists
+func (l *List) Get(key string, v any) (bool, error) {
+	return get(l.fields, key, v)
+}
+
+// Set encodes v into top-level field key ("plugins" is managed through Plugins)
+func (l *List) Set(key string, v any) error {
+	if key == "plugins" {
+		return fmt.Errorf("plugins must be edited through List.Plugins")
+	}
+	return set(l.fields, key, v)
+}
+
+// Index returns the position of the first plugin of pluginType, or -1
+func (l *List) Index(pluginType string) int {
+	for i, p := range l.Plugins {
+		if p.Type() == pluginType {
+			return i
+		}
+	}
+	return -1
+}
+
+// Insert places p at position i (0 ≤ i ≤ len(Plugins))
+func (l *List) Insert(i int, p *Plugin) error {
+	if i < 0 || i > len(l.Plugins) {
+		return fmt.Errorf("plugin position %d out of range (0-%d)", i, len(l.Plugins))
+	}
+	l.Plugins = append(l.Plugins, nil)
+	copy(l.Plugins[i+1:], l.Plugins[i:])
+	l.Plugins[i] = p
+	return nil
+}
+
+// Remove deletes the plugin at position i
+func (l *List) Remove(i int) error {
+	if i < 0 || i >= len(l.Plugins) {
+		return fmt.Errorf("plugin position %d out of range (0-%d)", i, len(l.Plugins)-1)
+	}
+	l.Plugins = append(l.Plugins[:i], l.Plugins[i+1:]...)
+	return nil
+}
+
+// Wrap replaces the first plugin of delegateType with wrapper and moves it into
+// wrapper's "delegate" field. Idempotent: returns false if the list already contains
+// a plugin of wrapper's type.
+func (l *List) Wrap(delegateType string, wrapper *Plugin) (bool, error) {
+	if l.Index(wrapper.Type()) >= 0 {
+		return false, nil
+	}
+
+	i := l.Index(delegateType)
+	if i < 0 {
+		return false, fmt.Errorf("conflist %q has no %s plugin to wrap", l.Name(), delegateType)
+	}
+
+	if err := wrapper.Set("delegate", l.Plugins[i]); err != nil {
+		return false, err
+	}
+	l.Plugins[i] = wrapper
+	return true, nil
+}
+
+// PluginConfig returns the configuration libcni would pass to the first plugin of
+// pluginType: the plugin's fields plus the list's name and cniVersion
+func (l *List) PluginConfig(pluginType string) ([]byte, error) {
+	i := l.Index(pluginType)
+	if i < 0 {
+		return nil, fmt.Errorf("conflist %q has no %s plugin", l.Name(), pluginType)
+	}
+
+	fields := make(map[string]json.RawMessage, len(l.Plugins[i].fields)+2)
+	for k, v := range l.Plugins[i].fields {
+		fields[k] = v
+	}
+	for _, key := range []string{"name", "cniVersion"} {
+		if v, ok := l.fields[key]; ok {
+			fields[key] = v
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// MarshalJSON implements json.Marshaler
+func (l *List) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]any, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	plugins := l.Plugins
+	if plugins == nil {
+		plugins = []*Plugin{}
+	}
+	fields["plugins"] = plugins
+	return json.Marshal(fields)
+}
+
+// Marshal renders the document indented for writing to /etc/cni/net.d
+func (l *List) Marshal() ([]byte, error) {
+	return json.MarshalIndent(l, "", "  ")
+}
+
+// get decodes fields[key] into v
+func get(fields map[string]json.RawMessage, key string, v any) (bool, error) {
+	raw, ok := fields[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("failed to decode field %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// set encodes v into fields[key]
+func set(fields map[string]json.RawMessage, key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode field %q: %w", key, err)
+	}
+	fields[key] = raw
+	return nil
+}
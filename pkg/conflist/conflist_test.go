@@ -0,0 +1,149 @@
+package conflist
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConflist = `{
+	"cniVersion": "1.0.0",
+	"name": "tenant-net",
+	"disableCheck": false,
+	"x-vendor": {"keep": [1, 2, 3]},
+	"plugins": [
+		{"type": "ptp", "ipMasq": true, "ipam": {"type": "host-local", "subnet": "10.200.0.0/24"}},
+		{"type": "portmap", "capabilities": {"portMappings": true}}
+	]
+}`
+
+// decode unmarshals data into a generic value for comparisons independent of key order
+func decode(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return v
+}
+
+// TestParse_RoundTrip verifies unknown fields survive parse and marshal
+func TestParse_RoundTrip(t *testing.T) {
+	list, err := Parse([]byte(testConflist))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if list.Name() != "tenant-net" || list.CNIVersion() != "1.0.0" || len(list.Plugins) != 2 {
+		t.Fatalf("Name/CNIVersion/plugins = %q/%q/%d", list.Name(), list.CNIVersion(), len(list.Plugins))
+	}
+
+	out, err := list.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if got, want := decode(t, out), decode(t, []byte(testConflist)); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed the document:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+// TestWrap verifies the delegate moves into the wrapper and wrapping is idempotent
+func TestWrap(t *testing.T) {
+	list, err := Parse([]byte(testConflist))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	wrapper := NewPlugin("tenant-routing-wrapper")
+	if err := wrapper.Set("kubeconfig", "/etc/kubernetes/kubelet.conf"); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := list.Wrap("ptp", wrapper)
+	if err != nil || !changed {
+		t.Fatalf("Wrap() = %v, %v, want true, nil", changed, err)
+	}
+	if got := []string{list.Plugins[0].Type(), list.Plugins[1].Type()}; !reflect.DeepEqual(got, []string{"tenant-routing-wrapper", "portmap"}) {
+		t.Errorf("plugin chain = %v", got)
+	}
+
+	var delegate map[string]any
+	if ok, err := list.Plugins[0].Get("delegate", &delegate); !ok || err != nil {
+		t.Fatalf("delegate missing: %v", err)
+	}
+	if delegate["type"] != "ptp" || delegate["ipMasq"] != true {
+		t.Errorf("delegate = %v, want the original ptp config", delegate)
+	}
+
+	changed, err = list.Wrap("ptp", NewPlugin("tenant-routing-wrapper"))
+	if err != nil || changed {
+		t.Errorf("second Wrap() = %v, %v, want false, nil", changed, err)
+	}
+
+	if _, err := New("n", "1.0.0").Wrap("bridge", NewPlugin("tenant-routing-wrapper")); err == nil ||
+		!strings.Contains(err.Error(), "has no bridge plugin") {
+		t.Errorf("Wrap() on list without delegate error = %v", err)
+	}
+}
+
+// TestPluginConfig verifies libcni-style injection of name and cniVersion
+func TestPluginConfig(t *testing.T) {
+	list, err := Parse([]byte(testConflist))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	data, err := list.PluginConfig("portmap")
+	if err != nil {
+		t.Fatalf("PluginConfig() error = %v", err)
+	}
+	got := decode(t, data)
+	if got["name"] != "tenant-net" || got["cniVersion"] != "1.0.0" || got["type"] != "portmap" {
+		t.Errorf("PluginConfig() = %v", got)
+	}
+
+	if _, err := list.PluginConfig("bridge"); err == nil {
+		t.Error("expected error for missing plugin type")
+	}
+}
+
+// TestInsertRemove verifies positional edits and their bounds
+func TestInsertRemove(t *testing.T) {
+	list := New("tenant-net", "1.1.0")
+	if err := list.Insert(0, NewPlugin("ptp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Insert(1, NewPlugin("portmap")); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Insert(1, NewPlugin("bandwidth")); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Insert(5, NewPlugin("tuning")); err == nil {
+		t.Error("expected out of range error")
+	}
+	if err := list.Remove(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := list.Remove(2); err == nil {
+		t.Error("expected out of range error")
+	}
+	if list.Index("bandwidth") != 0 || list.Index("portmap") != 1 || list.Index("ptp") != -1 {
+		t.Errorf("unexpected chain after edits")
+	}
+	if err := list.Set("plugins", nil); err == nil {
+		t.Error("expected error setting plugins directly")
+	}
+}
+
+// TestIsList distinguishes conflists from single plugin configs
+func TestIsList(t *testing.T) {
+	if !IsList([]byte(testConflist)) {
+		t.Error("IsList(conflist) = false")
+	}
+	for _, data := range []string{`{"type":"ptp"}`, `{"plugins":null}`, `{`} {
+		if IsList([]byte(data)) {
+			t.Errorf("IsList(%s) = true", data)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Package conntrack flushes connection tracking entries of a pod IP.
+//
+// With CONNMARK or policy routing in place, established flows keep the mark they were
+// classified with. When a pod IP is reused or a tenant annotation changes, those stale
+// entries would keep routing traffic with the old tenant mark until they expire.
+// Flushing every entry whose original source or destination is the pod IP forces new
+// flows through the freshly programmed MARK rules.
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// flusher abstracts the kernel conntrack API so logic can be unit-tested without root
+type flusher interface {
+	// flushIP deletes entries with ip as original source or destination, returning the count
+	flushIP(ip net.IP) (uint, error)
+}
+
+// fl is the active flusher implementation (netlink on Linux)
+var fl flusher = newFlusher()
+
+// FlushPodIP deletes all conntrack entries originating from or destined to podIP
+// Returns the number of deleted entries; flushing an IP without entries is not an error
+func FlushPodIP(podIP string) (uint, error) {
+	if strings.TrimSpace(podIP) == "" {
+		return 0, fmt.Errorf("podIP cannot be empty")
+	}
+	ip := net.ParseIP(podIP)
+	if ip == nil {
+		return 0, fmt.Errorf("invalid IP address format: %s", podIP)
+	}
+
+	deleted, err := fl.flushIP(ip)
+	if err != nil {
+		return 0, fmt.Errorf("failed to flush conntrack entries for %s: %w", podIP, err)
+	}
+	return deleted, nil
+}
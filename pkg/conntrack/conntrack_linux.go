@@ -0,0 +1,35 @@
+//go:build linux
+
+package conntrack
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// netlinkFlusher deletes entries through ctnetlink (no exec of conntrack(8))
+type netlinkFlusher struct{}
+
+func newFlusher() flusher {
+	return netlinkFlusher{}
+}
+
+func (netlinkFlusher) flushIP(ip net.IP) (uint, error) {
+	family := netlink.FAMILY_V4
+	if ip.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	// Filters are OR-ed: an entry matching either direction is deleted
+	src := &netlink.ConntrackFilter{}
+	if err := src.AddIP(netlink.ConntrackOrigSrcIP, ip); err != nil {
+		return 0, err
+	}
+	dst := &netlink.ConntrackFilter{}
+	if err := dst.AddIP(netlink.ConntrackOrigDstIP, ip); err != nil {
+		return 0, err
+	}
+
+	return netlink.ConntrackDeleteFilters(netlink.ConntrackTable, netlink.InetFamily(family), src, dst)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// unsupportedFlusher is used on non-Linux platforms where conntrack is unavailable
+type unsupportedFlusher struct{}
+
+func newFlusher() flusher {
+	return unsupportedFlusher{}
+}
+
+func (unsupportedFlusher) flushIP(net.IP) (uint, error) {
+	return 0, fmt.Errorf("conntrack flush is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,73 @@
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeFlusher records flushed IPs in memory
+type fakeFlusher struct {
+	flushed []string
+	err     error
+}
+
+func (f *fakeFlusher) flushIP(ip net.IP) (uint, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.flushed = append(f.flushed, ip.String())
+	return 2, nil
+}
+
+// useFakeFlusher swaps the package flusher for the duration of a test
+func useFakeFlusher(t *testing.T) *fakeFlusher {
+	t.Helper()
+	fake := &fakeFlusher{}
+	orig := fl
+	fl = fake
+	t.Cleanup(func() { fl = orig })
+	return fake
+}
+
+// TestFlushPodIP verifies validation and delegation to the flusher
+func TestFlushPodIP(t *testing.T) {
+	fake := useFakeFlusher(t)
+
+	deleted, err := FlushPodIP("10.200.0.5")
+	if err != nil {
+		t.Fatalf("FlushPodIP() error = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted = %d, want 2", deleted)
+	}
+	if len(fake.flushed) != 1 || fake.flushed[0] != "10.200.0.5" {
+		t.Errorf("flushed = %v, want [10.200.0.5]", fake.flushed)
+	}
+}
+
+// TestFlushPodIP_InvalidInput verifies bad IPs never reach the kernel
+func TestFlushPodIP_InvalidInput(t *testing.T) {
+	fake := useFakeFlusher(t)
+
+	for _, podIP := range []string{"", "  ", "not-an-ip", "10.0.0.1; rm -rf /"} {
+		if _, err := FlushPodIP(podIP); err == nil {
+			t.Errorf("FlushPodIP(%q) expected error", podIP)
+		}
+	}
+	if len(fake.flushed) != 0 {
+		t.Errorf("flusher called for invalid input: %v", fake.flushed)
+	}
+}
+
+// TestFlushPodIP_Error verifies kernel errors are wrapped with the pod IP
+func TestFlushPodIP_Error(t *testing.T) {
+	fake := useFakeFlusher(t)
+	fake.err = fmt.Errorf("operation not permitted")
+
+	_, err := FlushPodIP("10.200.0.5")
+	if err == nil || !strings.Contains(err.Error(), "10.200.0.5") {
+		t.Errorf("error = %v, want wrapped error mentioning pod IP", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package cri reads pod sandboxes from the container runtime (CRI) through crictl.
+//
+// The runtime sometimes garbage-collects sandboxes after a crash without ever
+// calling CNI DEL, and the API server may still list the pod for a while. The
+// sandboxes the runtime reports are the ground truth for which pod IPs are still
+// plumbed on the node, so they are an alternative liveness source for pkg/gc.
+package cri
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// StartupGrace is how long a not-ready sandbox counts as starting (CNI ADD may be
+// running) rather than dead
+const StartupGrace = 2 * time.Minute
+
+// Sandbox is a pod sandbox as reported by the runtime
+type Sandbox struct {
+	ID        string
+	Namespace string
+	Name      string
+
+	// Ready is false while the sandbox is being set up and after it was stopped
+	Ready     bool
+	CreatedAt time.Time
+
+	// HostNetwork sandboxes share the node addresses and are never marked
+	HostNetwork bool
+
+	// IPs assigned to the sandbox network (empty until CNI ADD completed)
+	IPs []string
+}
+
+// runFunc runs crictl against endpoint with args and returns stdout; replaced in tests to avoid exec
+var runFunc = runCrictl
+
+// ListSandboxes returns every sandbox the runtime knows, with the IPs of ready ones
+// endpoint is the CRI socket ("unix:///run/containerd/containerd.sock"); empty uses crictl's config
+func ListSandboxes(ctx context.Context, endpoint string) ([]Sandbox, error) {
+	out, err := runFunc(ctx, endpoint, "pods", "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pod sandboxes: %w", err)
+	}
+
+	var list struct {
+		Items []struct {
+			ID       string `json:"id"`
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			State     string `json:"state"`
+			CreatedAt string `json:"createdAt"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse crictl pods output: %w", err)
+	}
+
+	var sandboxes []Sandbox
+	var ready []string
+	for _, item := range list.Items {
+		sb := Sandbox{
+			ID:        item.ID,
+			Namespace: item.Metadata.Namespace,
+			Name:      item.Metadata.Name,
+			Ready:     item.State == "SANDBOX_READY",
+			CreatedAt: parseNanos(item.CreatedAt),
+		}
+		sandboxes = append(sandboxes, sb)
+		if sb.Ready {
+			ready = append(ready, sb.ID)
+		}
+	}
+	if len(ready) == 0 {
+		return sandboxes, nil
+	}
+
+	statuses, err := inspect(ctx, endpoint, ready)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sandboxes {
+		if st, ok := statuses[sandboxes[i].ID]; ok {
+			sandboxes[i].IPs = st.ips
+			sandboxes[i].HostNetwork = st.hostNetwork
+		}
+	}
+	return sandboxes, nil
+}
+
+// sandboxNetwork is the network part of a sandbox status
+type sandboxNetwork struct {
+	ips         []string
+	hostNetwork bool
+}
+
+// inspect reads the network status of sandboxes (crictl inspectp prints one JSON document per ID)
+func inspect(ctx context.Context, endpoint string, ids []string) (map[string]sandboxNetwork, error) {
+	out, err := runFunc(ctx, endpoint, append([]string{"inspectp", "-o", "json"}, ids...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect pod sandboxes: %w", err)
+	}
+
+	statuses := map[string]sandboxNetwork{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var doc struct {
+			Status struct {
+				ID      string `json:"id"`
+				Network struct {
+					IP            string `json:"ip"`
+					AdditionalIPs []struct {
+						IP string `json:"ip"`
+					} `json:"additionalIps"`
+				} `json:"network"`
+				Linux struct {
+					Namespaces struct {
+						Options struct {
+							Network string `json:"network"`
+						} `json:"options"`
+					} `json:"namespaces"`
+				} `json:"linux"`
+			} `json:"status"`
+		}
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse crictl inspectp output: %w", err)
+		}
+
+		st := sandboxNetwork{hostNetwork: doc.Status.Linux.Namespaces.Options.Network == "NODE"}
+		if doc.Status.Network.IP != "" {
+			st.ips = append(st.ips, doc.Status.Network.IP)
+		}
+		for _, ip := range doc.Status.Network.AdditionalIPs {
+			st.ips = append(st.ips, ip.IP)
+		}
+		statuses[doc.Status.ID] = st
+	}
+	return statuses, nil
+}
+
+// LivePods converts sandboxes into the liveness view used by pkg/gc
+// Ready sandboxes contribute their IPs. Sandboxes still being set up (ready without
+// an IP, or not ready and younger than StartupGrace) are pending; older not-ready
+// sandboxes are dead and their rules may be collected.
+func LivePods(sandboxes []Sandbox, now time.Time) tenant.LivePods {
+	live := tenant.LivePods{IPs: map[string]bool{}}
+	for _, sb := range sandboxes {
+		name := sb.Namespace + "/" + sb.Name
+		switch {
+		case sb.HostNetwork:
+		case sb.Ready && len(sb.IPs) > 0:
+			for _, ip := range sb.IPs {
+				live.IPs[ip] = true
+			}
+		case sb.Ready, now.Sub(sb.CreatedAt) < StartupGrace:
+			live.Pending = append(live.Pending, name)
+		}
+	}
+	return live
+}
+
+// parseNanos parses crictl's createdAt (Unix nanoseconds as a string)
+func parseNanos(s string) time.Time {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// runCrictl executes crictl
+func runCrictl(ctx context.Context, endpoint string, args ...string) ([]byte, error) {
+	if endpoint != "" {
+		args = append([]string{"--runtime-endpoint", endpoint}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "crictl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useCrictl replaces crictl with canned outputs keyed by subcommand
+func useCrictl(t *testing.T, outputs map[string]string) *[][]string {
+	t.Helper()
+	var calls [][]string
+	orig := runFunc
+	runFunc = func(_ context.Context, endpoint string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{endpoint}, args...))
+		out, ok := outputs[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("unexpected crictl %v", args)
+		}
+		return []byte(out), nil
+	}
+	t.Cleanup(func() { runFunc = orig })
+	return &calls
+}
+
+// TestListSandboxes verifies pods and inspectp outputs are merged
+func TestListSandboxes(t *testing.T) {
+	created := strconv.FormatInt(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano(), 10)
+	calls := useCrictl(t, map[string]string{
+		"pods": `{"items": [
+			{"id": "aaa", "metadata": {"name": "web", "namespace": "team-a"}, "state": "SANDBOX_READY", "createdAt": "` + created + `"},
+			{"id": "bbb", "metadata": {"name": "old", "namespace": "team-b"}, "state": "SANDBOX_NOTREADY", "createdAt": "` + created + `"},
+			{"id": "ccc", "metadata": {"name": "agent", "namespace": "kube-system"}, "state": "SANDBOX_READY", "createdAt": "` + created + `"}
+		]}`,
+		"inspectp": `{"status": {"id": "aaa", "network": {"ip": "10.200.0.2", "additionalIps": [{"ip": "fd00::2"}]}}}
+{"status": {"id": "ccc", "network": {"ip": "192.168.0.10"}, "linux": {"namespaces": {"options": {"network": "NODE"}}}}}`,
+	})
+
+	sandboxes, err := ListSandboxes(context.Background(), "unix:///run/containerd/containerd.sock")
+	if err != nil {
+		t.Fatalf("ListSandboxes() error = %v", err)
+	}
+
+	want := []Sandbox{
+		{ID: "aaa", Namespace: "team-a", Name: "web", Ready: true, IPs: []string{"10.200.0.2", "fd00::2"}},
+		{ID: "bbb", Namespace: "team-b", Name: "old"},
+		{ID: "ccc", Namespace: "kube-system", Name: "agent", Ready: true, HostNetwork: true, IPs: []string{"192.168.0.10"}},
+	}
+	for i := range sandboxes {
+		if !sandboxes[i].CreatedAt.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+			t.Errorf("sandbox %s CreatedAt = %v", sandboxes[i].ID, sandboxes[i].CreatedAt)
+		}
+		sandboxes[i].CreatedAt = time.Time{}
+	}
+	if !reflect.DeepEqual(sandboxes, want) {
+		t.Errorf("ListSandboxes() =\n%+v\nwant\n%+v", sandboxes, want)
+	}
+
+	// Only ready sandboxes are inspected
+	if last := (*calls)[len(*calls)-1]; strings.Join(last, " ") != "unix:///run/containerd/containerd.sock inspectp -o json aaa ccc" {
+		t.Errorf("inspect call = %v", last)
+	}
+}
+
+// TestLivePods verifies ready, starting and dead sandboxes are classified
+func TestLivePods(t *testing.T) {
+	now := time.Now()
+	live := LivePods([]Sandbox{
+		{Namespace: "team-a", Name: "web", Ready: true, IPs: []string{"10.200.0.2"}},
+		{Namespace: "team-a", Name: "plumbing", Ready: true},
+		{Namespace: "team-a", Name: "starting", CreatedAt: now.Add(-10 * time.Second)},
+		{Namespace: "team-a", Name: "dead", CreatedAt: now.Add(-time.Hour)},
+		{Namespace: "kube-system", Name: "agent", Ready: true, HostNetwork: true, IPs: []string{"192.168.0.10"}},
+	}, now)
+
+	if !reflect.DeepEqual(live.IPs, map[string]bool{"10.200.0.2": true}) {
+		t.Errorf("IPs = %v", live.IPs)
+	}
+	if !reflect.DeepEqual(live.Pending, []string{"team-a/plumbing", "team-a/starting"}) {
+		t.Errorf("Pending = %v", live.Pending)
+	}
+}
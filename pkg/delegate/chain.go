@@ -0,0 +1,133 @@
+package delegate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// IsChain reports whether delegateConfig is a JSON array of plugin configs
+// Every Delegate* function runs such a chain plugin by plugin, like a conflist.
+func IsChain(delegateConfig json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(delegateConfig)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// Plugins returns the plugin configs of a delegate block in execution order
+// A single object is a chain of one.
+func Plugins(delegateConfig json.RawMessage) ([]json.RawMessage, error) {
+	if !IsChain(delegateConfig) {
+		return []json.RawMessage{delegateConfig}, nil
+	}
+	var plugins []json.RawMessage
+	if err := json.Unmarshal(delegateConfig, &plugins); err != nil {
+		return nil, fmt.Errorf("failed to parse delegate list: %w", err)
+	}
+	if len(plugins) == 0 {
+		return nil, fmt.Errorf("delegate list is empty")
+	}
+	return plugins, nil
+}
+
+// chainAdd runs ADD on each plugin in order, passing each the previous result as prevResult
+// The list's cniVersion is injected into every plugin, as libcni does for a conflist.
+// If a plugin fails, those that already ran get DEL in reverse order with the last
+// result as prevResult, so a failed chain leaves nothing behind.
+func chainAdd(ctx context.Context, plugins []json.RawMessage, networkName string, stdin []byte) (types.Result, error) {
+	cniVersion := stdinVersion(stdin)
+	var prev types.Result
+	for i, plugin := range plugins {
+		conf, err := chainPluginConfig(plugin, cniVersion, prev)
+		if err != nil {
+			return nil, rollbackChain(ctx, plugins[:i], networkName, cniVersion, prev,
+				fmt.Errorf("delegate %d of %d: %w", i+1, len(plugins), err))
+		}
+		res, err := DelegateAdd(ctx, conf, networkName, stdin)
+		if err != nil {
+			return nil, rollbackChain(ctx, plugins[:i], networkName, cniVersion, prev,
+				fmt.Errorf("delegate %d of %d: %w", i+1, len(plugins), err))
+		}
+		prev = res
+	}
+	return prev, nil
+}
+
+// forEachPlugin calls fn for every plugin of a delegate list in order, stopping at the first error
+func forEachPlugin(delegateConfig json.RawMessage, fn func(json.RawMessage) error) error {
+	plugins, err := Plugins(delegateConfig)
+	if err != nil {
+		return err
+	}
+	for _, plugin := range plugins {
+		if err := fn(plugin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackChain runs DEL on plugins (those that completed ADD), last first, and returns cause
+// joined with any DEL failure. The DELs run even if ctx was cancelled or its deadline passed.
+func rollbackChain(ctx context.Context, plugins []json.RawMessage, networkName, cniVersion string, prev types.Result, cause error) error {
+	if len(plugins) == 0 {
+		return cause
+	}
+	stdin := map[string]any{"cniVersion": cniVersion}
+	if prev != nil {
+		stdin["prevResult"] = prev
+	}
+	data, err := json.Marshal(stdin)
+	if err != nil {
+		return errors.Join(cause, fmt.Errorf("rollback failed: %w", err))
+	}
+	if err := chainDel(context.WithoutCancel(ctx), plugins, networkName, data); err != nil {
+		return errors.Join(cause, fmt.Errorf("rollback failed: %w", err))
+	}
+	return cause
+}
+
+// chainDel runs DEL on every plugin in reverse order; a failure does not stop the others
+func chainDel(ctx context.Context, plugins []json.RawMessage, networkName string, stdin []byte) error {
+	var errs []error
+	for i := len(plugins) - 1; i >= 0; i-- {
+		if err := DelegateDel(ctx, plugins[i], networkName, stdin); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chainPluginConfig returns plugin with cniVersion and prevResult injected
+func chainPluginConfig(plugin json.RawMessage, cniVersion string, prev types.Result) (json.RawMessage, error) {
+	var conf map[string]any
+	if err := json.Unmarshal(plugin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse delegate config: %w", err)
+	}
+	if cniVersion != "" {
+		conf["cniVersion"] = cniVersion
+	}
+	if prev != nil {
+		if cniVersion != "" {
+			converted, err := prev.GetAsVersion(cniVersion)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert previous result to %s: %w", cniVersion, err)
+			}
+			prev = converted
+		}
+		conf["prevResult"] = prev
+	}
+	return json.Marshal(conf)
+}
+
+// stdinVersion returns the cniVersion of the wrapper's stdin ("" if unset or unparsable)
+func stdinVersion(stdin []byte) string {
+	var conf struct {
+		CNIVersion string `json:"cniVersion"`
+	}
+	_ = json.Unmarshal(stdin, &conf)
+	return conf.CNIVersion
+}
@@ -0,0 +1,124 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// writeChainStubs installs stub plugins that log "<name> <command>" and keep their stdin
+// plug-a assigns 10.200.1.5, plug-b adds an interface to it, plug-fail fails ADD
+func writeChainStubs(t *testing.T) (dir string) {
+	t.Helper()
+	dir = t.TempDir()
+	stubs := map[string]string{
+		"plug-a":    `echo '{"cniVersion":"1.0.0","ips":[{"address":"10.200.1.5/24"}]}'`,
+		"plug-b":    `echo '{"cniVersion":"1.0.0","interfaces":[{"name":"eth0"}],"ips":[{"address":"10.200.1.5/24"}]}'`,
+		"plug-fail": `echo '{"cniVersion":"1.0.0","code":100,"msg":"boom"}'; exit 1`,
+	}
+	for name, add := range stubs {
+		script := "#!/bin/sh\necho \"" + name + " $CNI_COMMAND\" >> " + dir + "/log\n" +
+			"cat > " + dir + "/" + name + ".$CNI_COMMAND\n" +
+			"[ \"$CNI_COMMAND\" = ADD ] || exit 0\n" + add + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CNI_PATH", dir)
+	return dir
+}
+
+// readLog returns the "<name> <command>" lines written by the stubs
+func readLog(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Join(strings.Split(strings.TrimSpace(string(data)), "\n"), ", ")
+}
+
+// TestDelegateAdd_Chain verifies plugins run in order, each seeing the previous result
+func TestDelegateAdd_Chain(t *testing.T) {
+	dir := writeChainStubs(t)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	res, err := DelegateAdd(context.Background(), json.RawMessage(`[{"type": "plug-a"}, {"type": "plug-b", "cniVersion": "0.4.0"}]`),
+		"tenant-net", []byte(`{"cniVersion": "1.0.0"}`))
+	if err != nil {
+		t.Fatalf("DelegateAdd() error = %v", err)
+	}
+	result, err := current.NewResultFromResult(res)
+	if err != nil || len(result.IPs) != 1 || len(result.Interfaces) != 1 {
+		t.Errorf("DelegateAdd() = %v, %v; want the result of plug-b", res, err)
+	}
+	if got := readLog(t, dir); got != "plug-a ADD, plug-b ADD" {
+		t.Errorf("invocations = %q", got)
+	}
+
+	var stdin map[string]any
+	data, err := os.ReadFile(filepath.Join(dir, "plug-b.ADD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &stdin); err != nil {
+		t.Fatalf("plug-b stdin is not JSON: %v", err)
+	}
+	if stdin["cniVersion"] != "1.0.0" || stdin["name"] != "tenant-net" || stdin["prevResult"] == nil {
+		t.Errorf("plug-b stdin = %s, want list cniVersion, name and prevResult", data)
+	}
+}
+
+// TestDelegateAdd_ChainRollback verifies a failing plugin makes the completed ones get DEL
+func TestDelegateAdd_ChainRollback(t *testing.T) {
+	dir := writeChainStubs(t)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	_, err := DelegateAdd(context.Background(), json.RawMessage(`[{"type": "plug-a"}, {"type": "plug-fail"}]`),
+		"tenant-net", []byte(`{"cniVersion": "1.0.0"}`))
+	if err == nil || !strings.Contains(err.Error(), "delegate 2 of 2") {
+		t.Fatalf("DelegateAdd() error = %v, want failure of delegate 2", err)
+	}
+	if got := readLog(t, dir); got != "plug-a ADD, plug-fail ADD, plug-a DEL" {
+		t.Errorf("invocations = %q", got)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "plug-a.DEL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "10.200.1.5/24") {
+		t.Errorf("rollback DEL stdin = %s, want prevResult with plug-a's result", data)
+	}
+}
+
+// TestDelegateDel_Chain verifies DEL runs in reverse order and does not stop at a failure
+func TestDelegateDel_Chain(t *testing.T) {
+	dir := writeChainStubs(t)
+	t.Setenv("CNI_COMMAND", "DEL")
+
+	err := DelegateDel(context.Background(), json.RawMessage(`[{"type": "plug-a"}, {"type": "missing"}, {"type": "plug-b"}]`),
+		"tenant-net", []byte(`{"cniVersion": "1.0.0"}`))
+	if err == nil {
+		t.Error("DelegateDel() expected error for the missing plugin")
+	}
+	if got := readLog(t, dir); got != "plug-b DEL, plug-a DEL" {
+		t.Errorf("invocations = %q", got)
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	if plugins, err := Plugins(json.RawMessage(`{"type": "ptp"}`)); err != nil || len(plugins) != 1 {
+		t.Errorf("Plugins(object) = %d plugins, %v; want 1", len(plugins), err)
+	}
+	if plugins, err := Plugins(json.RawMessage(` [{"type": "ptp"}, {"type": "tuning"}]`)); err != nil || len(plugins) != 2 {
+		t.Errorf("Plugins(list) = %d plugins, %v; want 2", len(plugins), err)
+	}
+	if _, err := Plugins(json.RawMessage(`[]`)); err == nil {
+		t.Error("Plugins() expected error for an empty list")
+	}
+}
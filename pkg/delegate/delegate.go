@@ -3,6 +3,7 @@ package delegate
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,20 +11,81 @@ import (
 
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/safepath"
 )
 
 // ExecutionTimeout is the maximum time allowed for delegate plugin execution
 // Prevents hanging CNI operations that would block container creation
 const ExecutionTimeout = 30 * time.Second
 
+// validAttachmentsKey is the GC config field listing attachments the runtime still knows
+const validAttachmentsKey = "cni.dev/valid-attachments"
+
+// pluginExec runs delegate plugin binaries; replaced in tests through SetExec
+var pluginExec invoke.Exec = &invoke.DefaultExec{
+	RawExec: &invoke.RawExec{Stderr: os.Stderr},
+}
+
+// SetExec replaces the executor every Delegate* function runs plugins with, e.g. with a
+// FakeExec so tests need no plugin binaries. Returns a function restoring the previous one.
+func SetExec(exec invoke.Exec) (restore func()) {
+	prev := pluginExec
+	pluginExec = exec
+	return func() { pluginExec = prev }
+}
+
+// securePathRoots are the directories delegate binaries must be below; nil unless set
+// through SetSecurePaths
+var securePathRoots []string
+
+// SetSecurePaths makes every Delegate* function and GetPluginPath refuse a plugin binary
+// found in CNI_PATH that safepath.Check rejects for roots, and run the resolved path
+// otherwise. A nil roots turns the check off.
+func SetSecurePaths(roots []string) {
+	securePathRoots = roots
+}
+
+// executor returns the executor to run plugins with: pluginExec, checking the binaries
+// it finds if SetSecurePaths set roots
+func executor() invoke.Exec {
+	if securePathRoots == nil {
+		return pluginExec
+	}
+	return &secureExec{Exec: pluginExec, roots: securePathRoots}
+}
+
+// secureExec checks the plugin binaries its Exec finds with safepath.Check
+type secureExec struct {
+	invoke.Exec
+	roots []string
+}
+
+// FindInPath returns the resolved path of plugin in paths, or an error matching
+// safepath.ErrUnsafe if a user other than root could have put it there
+func (e *secureExec) FindInPath(plugin string, paths []string) (string, error) {
+	pluginPath, err := e.Exec.FindInPath(plugin, paths)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := safepath.Check(pluginPath, e.roots)
+	if err != nil {
+		return "", fmt.Errorf("delegate plugin %q: %w", plugin, err)
+	}
+	return resolved, nil
+}
+
 // DelegateAdd executes the delegate CNI plugin for ADD command
-// Passes through all CNI environment variables and stdin unchanged
+// Passes through all CNI environment variables; cniVersion, prevResult, runtimeConfig and
+// capabilities from stdin are added to the delegate config (see inheritParentConfig)
 // Returns the delegate's CNI Result on success
 //
 // Parameters:
+//   - ctx: Cancels the plugin; its deadline applies if earlier than ExecutionTimeout
 //   - delegateConfig: Raw JSON configuration for the delegate plugin (from PluginConf.Delegate)
 //   - networkName: Name of the network (from parent config) - required by CNI spec
-//   - stdin: Original CNI stdin data (may differ from delegateConfig if wrapper added fields)
+//   - stdin: Original CNI stdin data (source of cniVersion, prevResult, runtimeConfig and capabilities)
 //
 // Environment variables propagated from current process:
 //   - CNI_COMMAND (should be "ADD")
@@ -36,7 +98,18 @@ const ExecutionTimeout = 30 * time.Second
 // Returns:
 //   - types.Result: Parsed CNI result from delegate plugin
 //   - error: Non-nil if delegation fails or delegate returns error
-func DelegateAdd(delegateConfig json.RawMessage, networkName string, stdin []byte) (types.Result, error) {
+//
+// A delegate list (JSON array) runs like a conflist, see IsChain; the result is the last plugin's
+// A delegate whose IPAM ran out of addresses fails with an *IPAMExhaustedError.
+func DelegateAdd(ctx context.Context, delegateConfig json.RawMessage, networkName string, stdin []byte) (types.Result, error) {
+	if IsChain(delegateConfig) {
+		plugins, err := Plugins(delegateConfig)
+		if err != nil {
+			return nil, err
+		}
+		return chainAdd(ctx, plugins, networkName, stdin)
+	}
+
 	// Parse delegate config to extract plugin type (required for execution)
 	var delegateConf map[string]any
 	if err := json.Unmarshal(delegateConfig, &delegateConf); err != nil {
@@ -52,15 +125,23 @@ func DelegateAdd(delegateConfig json.RawMessage, networkName string, stdin []byt
 	// The name field must be present in the config passed to delegate plugins
 	delegateConf["name"] = networkName
 
-	// Re-marshal the config with injected name
+	// Pass on cniVersion, prevResult, runtimeConfig and capabilities from the wrapper's stdin
+	inheritParentConfig(delegateConf, stdin)
+
+	// Re-marshal the config with injected fields
 	delegateConfigWithName, err := json.Marshal(delegateConf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal delegate config: %w", err)
 	}
 
+	// The noop delegate is answered here, without CNI_PATH or a plugin binary
+	if pluginType == NoopType {
+		return noopAdd(delegateConfigWithName)
+	}
+
 	// Create execution context with timeout
 	// Prevents indefinite hangs if delegate plugin is unresponsive
-	ctx, cancel := context.WithTimeout(context.Background(), ExecutionTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ExecutionTimeout)
 	defer cancel()
 
 	// Get CNI_PATH from environment (required for plugin discovery)
@@ -69,12 +150,9 @@ func DelegateAdd(delegateConfig json.RawMessage, networkName string, stdin []byt
 		return nil, fmt.Errorf("CNI_PATH environment variable not set")
 	}
 
-	// Create DefaultExec instance for plugin execution
-	// DefaultExec implements invoke.Exec interface with actual command execution and version handling
+	// Plugin executor (invoke.DefaultExec unless replaced with SetExec, checked per SetSecurePaths)
 	// Environment variables (CNI_COMMAND, CNI_CONTAINERID, etc.) are inherited from current process
-	exec := &invoke.DefaultExec{
-		RawExec: &invoke.RawExec{Stderr: os.Stderr},
-	}
+	exec := executor()
 
 	// Execute delegate plugin using CNI invoke package
 	// invoke.DelegateAdd handles:
@@ -87,8 +165,8 @@ func DelegateAdd(delegateConfig json.RawMessage, networkName string, stdin []byt
 
 	if err != nil {
 		// Preserve delegate error message exactly
-		// Include delegate plugin name for debugging
-		return nil, fmt.Errorf("delegate plugin %q failed: %w", pluginType, err)
+		// Include delegate plugin name for debugging; IPAM exhaustion gets a typed error
+		return nil, classifyAddError(pluginType, err)
 	}
 
 	// Result is already parsed by invoke.DelegateAdd
@@ -99,15 +177,24 @@ func DelegateAdd(delegateConfig json.RawMessage, networkName string, stdin []byt
 // Used to clean up network configuration when container is deleted
 //
 // Parameters:
+//   - ctx: Cancels the plugin; its deadline applies if earlier than ExecutionTimeout
 //   - delegateConfig: Raw JSON configuration for the delegate plugin
 //   - networkName: Name of the network (from parent config) - required by CNI spec
-//   - stdin: Original CNI stdin data (used to extract cniVersion and prevResult)
+//   - stdin: Original CNI stdin data (source of cniVersion, prevResult, runtimeConfig and capabilities)
 //
 // Returns:
 //   - error: Non-nil if delegation fails (non-zero exit code or execution error)
 //
 // Note: DEL should be idempotent - multiple calls with same args should succeed
-func DelegateDel(delegateConfig json.RawMessage, networkName string, stdin []byte) error {
+func DelegateDel(ctx context.Context, delegateConfig json.RawMessage, networkName string, stdin []byte) error {
+	if IsChain(delegateConfig) {
+		plugins, err := Plugins(delegateConfig)
+		if err != nil {
+			return err
+		}
+		return chainDel(ctx, plugins, networkName, stdin)
+	}
+
 	// Parse delegate config to extract plugin type
 	var delegateConf map[string]any
 	if err := json.Unmarshal(delegateConfig, &delegateConf); err != nil {
@@ -119,22 +206,17 @@ func DelegateDel(delegateConfig json.RawMessage, networkName string, stdin []byt
 		return fmt.Errorf("delegate config missing required 'type' field")
 	}
 
+	// The noop delegate has nothing to clean up, check or collect
+	if pluginType == NoopType {
+		return nil
+	}
+
 	// Inject network name into delegate config
 	delegateConf["name"] = networkName
 
-	// Parse original stdin to extract CNI fields needed by delegate
-	// DEL operations may need prevResult for proper cleanup
-	var stdinConf map[string]any
-	if err := json.Unmarshal(stdin, &stdinConf); err == nil {
-		// Inject cniVersion from original config (required by CNI spec)
-		if cniVersion, ok := stdinConf["cniVersion"].(string); ok && cniVersion != "" {
-			delegateConf["cniVersion"] = cniVersion
-		}
-		// Inject prevResult if present (needed for DEL cleanup)
-		if prevResult, ok := stdinConf["prevResult"]; ok && prevResult != nil {
-			delegateConf["prevResult"] = prevResult
-		}
-	}
+	// Pass on CNI fields from the original stdin
+	// DEL operations may need prevResult (and runtimeConfig, e.g. portMappings) for proper cleanup
+	inheritParentConfig(delegateConf, stdin)
 
 	// Re-marshal the config with injected fields
 	delegateConfigWithName, err := json.Marshal(delegateConf)
@@ -143,7 +225,7 @@ func DelegateDel(delegateConfig json.RawMessage, networkName string, stdin []byt
 	}
 
 	// Create execution context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), ExecutionTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ExecutionTimeout)
 	defer cancel()
 
 	// Get CNI_PATH from environment
@@ -151,10 +233,8 @@ func DelegateDel(delegateConfig json.RawMessage, networkName string, stdin []byt
 		return fmt.Errorf("CNI_PATH environment variable not set")
 	}
 
-	// Create DefaultExec instance for plugin execution
-	exec := &invoke.DefaultExec{
-		RawExec: &invoke.RawExec{Stderr: os.Stderr},
-	}
+	// Plugin executor (invoke.DefaultExec unless replaced with SetExec, checked per SetSecurePaths)
+	exec := executor()
 
 	// Execute delegate plugin DEL
 	// DEL operations should clean up resources created by ADD
@@ -162,7 +242,7 @@ func DelegateDel(delegateConfig json.RawMessage, networkName string, stdin []byt
 
 	if err != nil {
 		// Preserve delegate error message exactly
-		return fmt.Errorf("delegate plugin %q DEL failed: %w", pluginType, err)
+		return pluginFailed(fmt.Errorf("delegate plugin %q DEL failed: %w", pluginType, err))
 	}
 
 	return nil
@@ -172,15 +252,22 @@ func DelegateDel(delegateConfig json.RawMessage, networkName string, stdin []byt
 // Verifies that network configuration is still valid
 //
 // Parameters:
+//   - ctx: Cancels the plugin; its deadline applies if earlier than ExecutionTimeout
 //   - delegateConfig: Raw JSON configuration for the delegate plugin
 //   - networkName: Name of the network (from parent config) - required by CNI spec
-//   - stdin: Original CNI stdin data (used to extract cniVersion and prevResult)
+//   - stdin: Original CNI stdin data (source of cniVersion, prevResult, runtimeConfig and capabilities)
 //
 // Returns:
 //   - error: Non-nil if check fails (configuration not as expected)
 //
 // Note: CHECK requires prevResult to be present per CNI spec
-func DelegateCheck(delegateConfig json.RawMessage, networkName string, stdin []byte) error {
+func DelegateCheck(ctx context.Context, delegateConfig json.RawMessage, networkName string, stdin []byte) error {
+	if IsChain(delegateConfig) {
+		return forEachPlugin(delegateConfig, func(plugin json.RawMessage) error {
+			return DelegateCheck(ctx, plugin, networkName, stdin)
+		})
+	}
+
 	// Parse delegate config to extract plugin type
 	var delegateConf map[string]any
 	if err := json.Unmarshal(delegateConfig, &delegateConf); err != nil {
@@ -192,20 +279,105 @@ func DelegateCheck(delegateConfig json.RawMessage, networkName string, stdin []b
 		return fmt.Errorf("delegate config missing required 'type' field")
 	}
 
+	// The noop delegate has nothing to clean up, check or collect
+	if pluginType == NoopType {
+		return nil
+	}
+
 	// Inject network name into delegate config
 	delegateConf["name"] = networkName
 
-	// Parse original stdin to extract CNI fields needed by delegate
+	// Pass on CNI fields from the original stdin
 	// CHECK operations REQUIRE prevResult per CNI spec
+	inheritParentConfig(delegateConf, stdin)
+
+	// Re-marshal the config with injected fields
+	delegateConfigWithName, err := json.Marshal(delegateConf)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delegate config: %w", err)
+	}
+
+	// Create execution context with timeout
+	ctx, cancel := context.WithTimeout(ctx, ExecutionTimeout)
+	defer cancel()
+
+	// Get CNI_PATH from environment
+	if os.Getenv("CNI_PATH") == "" {
+		return fmt.Errorf("CNI_PATH environment variable not set")
+	}
+
+	// Plugin executor (invoke.DefaultExec unless replaced with SetExec, checked per SetSecurePaths)
+	exec := executor()
+
+	// Execute delegate plugin CHECK
+	// CHECK verifies configuration matches expected state
+	err = invoke.DelegateCheck(ctx, pluginType, delegateConfigWithName, exec)
+
+	if err != nil {
+		// Preserve delegate error message exactly
+		return pluginFailed(fmt.Errorf("delegate plugin %q CHECK failed: %w", pluginType, err))
+	}
+
+	return nil
+}
+
+// DelegateGC executes the delegate CNI plugin for GC command (CNI spec 1.1)
+// Lets the delegate release resources (e.g. IPAM leases) of attachments the runtime no longer knows
+//
+// Parameters:
+//   - ctx: Cancels the plugin; its deadline applies if earlier than ExecutionTimeout
+//   - delegateConfig: Raw JSON configuration for the delegate plugin
+//   - networkName: Name of the network (from parent config) - required by CNI spec
+//   - stdin: Original CNI stdin data (used to extract cniVersion and cni.dev/valid-attachments)
+//
+// Returns:
+//   - error: Non-nil if delegation fails (non-zero exit code or execution error)
+func DelegateGC(ctx context.Context, delegateConfig json.RawMessage, networkName string, stdin []byte) error {
+	if IsChain(delegateConfig) {
+		plugins, err := Plugins(delegateConfig)
+		if err != nil {
+			return err
+		}
+		// Every plugin gets to collect, whatever the others report
+		var errs []error
+		for _, plugin := range plugins {
+			if err := DelegateGC(ctx, plugin, networkName, stdin); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	// Parse delegate config to extract plugin type
+	var delegateConf map[string]any
+	if err := json.Unmarshal(delegateConfig, &delegateConf); err != nil {
+		return fmt.Errorf("failed to parse delegate config: %w", err)
+	}
+
+	pluginType, ok := delegateConf["type"].(string)
+	if !ok || pluginType == "" {
+		return fmt.Errorf("delegate config missing required 'type' field")
+	}
+
+	// The noop delegate has nothing to clean up, check or collect
+	if pluginType == NoopType {
+		return nil
+	}
+
+	// Inject network name into delegate config
+	delegateConf["name"] = networkName
+
+	// Parse original stdin to extract CNI fields needed by delegate
+	// GC operations need the list of attachments that are still valid
 	var stdinConf map[string]any
 	if err := json.Unmarshal(stdin, &stdinConf); err == nil {
 		// Inject cniVersion from original config (required by CNI spec)
 		if cniVersion, ok := stdinConf["cniVersion"].(string); ok && cniVersion != "" {
 			delegateConf["cniVersion"] = cniVersion
 		}
-		// Inject prevResult (required for CHECK per CNI spec)
-		if prevResult, ok := stdinConf["prevResult"]; ok && prevResult != nil {
-			delegateConf["prevResult"] = prevResult
+		// Inject valid attachments (everything else may be collected)
+		if attachments, ok := stdinConf[validAttachmentsKey]; ok && attachments != nil {
+			delegateConf[validAttachmentsKey] = attachments
 		}
 	}
 
@@ -216,7 +388,7 @@ func DelegateCheck(delegateConfig json.RawMessage, networkName string, stdin []b
 	}
 
 	// Create execution context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), ExecutionTimeout)
+	ctx, cancel := context.WithTimeout(ctx, ExecutionTimeout)
 	defer cancel()
 
 	// Get CNI_PATH from environment
@@ -224,23 +396,151 @@ func DelegateCheck(delegateConfig json.RawMessage, networkName string, stdin []b
 		return fmt.Errorf("CNI_PATH environment variable not set")
 	}
 
-	// Create DefaultExec instance for plugin execution
-	exec := &invoke.DefaultExec{
-		RawExec: &invoke.RawExec{Stderr: os.Stderr},
-	}
+	// Plugin executor (invoke.DefaultExec unless replaced with SetExec, checked per SetSecurePaths)
+	exec := executor()
 
-	// Execute delegate plugin CHECK
-	// CHECK verifies configuration matches expected state
-	err = invoke.DelegateCheck(ctx, pluginType, delegateConfigWithName, exec)
+	// Execute delegate plugin GC
+	err = invoke.DelegateGC(ctx, pluginType, delegateConfigWithName, exec)
 
 	if err != nil {
 		// Preserve delegate error message exactly
-		return fmt.Errorf("delegate plugin %q CHECK failed: %w", pluginType, err)
+		return pluginFailed(fmt.Errorf("delegate plugin %q GC failed: %w", pluginType, err))
+	}
+
+	return nil
+}
+
+// DelegateStatus asks the delegate CNI plugin whether it can serve ADDs (CNI spec 1.1 STATUS)
+// Delegates that predate STATUS (1.1.0 missing from their VERSION answer) are considered
+// ready once they answer VERSION. A delegate whose VERSION answer lacks the cniVersion
+// of stdin is not ready (ErrUnsupportedVersion): every ADD would fail.
+//
+// Parameters:
+//   - ctx: Cancels the plugin; its deadline applies if earlier than ExecutionTimeout
+//   - delegateConfig: Raw JSON configuration for the delegate plugin
+//   - networkName: Name of the network (from parent config) - required by CNI spec
+//   - stdin: Original CNI stdin data (used to extract cniVersion)
+//
+// Returns:
+//   - error: Non-nil if the delegate cannot be executed or reports it is not ready
+//     (the delegate's *types.Error is preserved in the chain)
+func DelegateStatus(ctx context.Context, delegateConfig json.RawMessage, networkName string, stdin []byte) error {
+	if IsChain(delegateConfig) {
+		return forEachPlugin(delegateConfig, func(plugin json.RawMessage) error {
+			return DelegateStatus(ctx, plugin, networkName, stdin)
+		})
+	}
+
+	// Parse delegate config to extract plugin type
+	var delegateConf map[string]any
+	if err := json.Unmarshal(delegateConfig, &delegateConf); err != nil {
+		return fmt.Errorf("failed to parse delegate config: %w", err)
+	}
+
+	pluginType, ok := delegateConf["type"].(string)
+	if !ok || pluginType == "" {
+		return fmt.Errorf("delegate config missing required 'type' field")
+	}
+
+	// The noop delegate has nothing to clean up, check or collect
+	if pluginType == NoopType {
+		return nil
+	}
+
+	// Inject network name into delegate config
+	delegateConf["name"] = networkName
+
+	// Inject cniVersion from original config (required by CNI spec)
+	var stdinConf map[string]any
+	if err := json.Unmarshal(stdin, &stdinConf); err == nil {
+		if cniVersion, ok := stdinConf["cniVersion"].(string); ok && cniVersion != "" {
+			delegateConf["cniVersion"] = cniVersion
+		}
+	}
+
+	// Re-marshal the config with injected fields
+	delegateConfigWithName, err := json.Marshal(delegateConf)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delegate config: %w", err)
+	}
+
+	// VERSION first: it proves the binary runs, supports the configured cniVersion (an
+	// older binary would fail every ADD) and tells whether it knows STATUS
+	info, err := pluginVersion(ctx, pluginType, stdinVersion(stdin))
+	if err != nil {
+		return err
+	}
+	supportsStatus := false
+	for _, v := range info.SupportedVersions {
+		if ok, err := version.GreaterThanOrEqualTo(v, "1.1.0"); err == nil && ok {
+			supportsStatus = true
+			break
+		}
+	}
+	if !supportsStatus {
+		return nil
+	}
+
+	// Create execution context with timeout
+	ctx, cancel := context.WithTimeout(ctx, ExecutionTimeout)
+	defer cancel()
+
+	// Plugin executor (invoke.DefaultExec unless replaced with SetExec, checked per SetSecurePaths)
+	exec := executor()
+
+	if err := invoke.DelegateStatus(ctx, pluginType, delegateConfigWithName, exec); err != nil {
+		// Preserve delegate error (and its STATUS error code) in the chain
+		return pluginFailed(fmt.Errorf("delegate plugin %q STATUS failed: %w", pluginType, err))
 	}
 
 	return nil
 }
 
+// inheritParentConfig copies the CNI fields a delegating plugin passes on from its own stdin:
+//   - cniVersion, always (required by CNI spec)
+//   - prevResult and capabilities, unless the delegate config sets its own
+//   - runtimeConfig entries for the capabilities the delegate has enabled, as libcni
+//     does for each plugin of a conflist; keys set in the delegate config are kept
+//
+// A delegate without its own capabilities inherits the wrapper's, so declaring e.g.
+// portMappings on the wrapper is enough.
+func inheritParentConfig(delegateConf map[string]any, stdin []byte) {
+	var stdinConf map[string]any
+	if err := json.Unmarshal(stdin, &stdinConf); err != nil {
+		return
+	}
+
+	if cniVersion, ok := stdinConf["cniVersion"].(string); ok && cniVersion != "" {
+		delegateConf["cniVersion"] = cniVersion
+	}
+	for _, key := range []string{"prevResult", "capabilities"} {
+		if _, set := delegateConf[key]; !set && stdinConf[key] != nil {
+			delegateConf[key] = stdinConf[key]
+		}
+	}
+
+	parentRuntimeConfig, _ := stdinConf["runtimeConfig"].(map[string]any)
+	if len(parentRuntimeConfig) == 0 {
+		return
+	}
+	capabilities, _ := delegateConf["capabilities"].(map[string]any)
+	runtimeConfig, _ := delegateConf["runtimeConfig"].(map[string]any)
+	if runtimeConfig == nil {
+		runtimeConfig = map[string]any{}
+	}
+	for capability, value := range parentRuntimeConfig {
+		if enabled, _ := capabilities[capability].(bool); !enabled {
+			continue
+		}
+		if _, set := runtimeConfig[capability]; !set {
+			runtimeConfig[capability] = value
+		}
+	}
+	if len(runtimeConfig) > 0 {
+		delegateConf["runtimeConfig"] = runtimeConfig
+	}
+}
+
 // GetPluginPath finds the full path to a CNI plugin binary
 // Searches in directories specified by CNI_PATH environment variable
 //
@@ -254,11 +554,10 @@ func GetPluginPath(pluginType string) (string, error) {
 	// Split CNI_PATH into individual directories
 	paths := strings.Split(cniPath, ":")
 
-	// Use RawExec to find plugin in path
-	exec := &invoke.RawExec{}
-	pluginPath, err := exec.FindInPath(pluginType, paths)
+	// Use the plugin executor to find plugin in path
+	pluginPath, err := executor().FindInPath(pluginType, paths)
 	if err != nil {
-		return "", fmt.Errorf("plugin %q not found in CNI_PATH: %w", pluginType, err)
+		return "", pluginFailed(fmt.Errorf("plugin %q not found in CNI_PATH: %w", pluginType, err))
 	}
 
 	return pluginPath, nil
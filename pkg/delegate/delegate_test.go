@@ -1,10 +1,18 @@
 package delegate
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/safepath"
 )
 
 // TestDelegateAdd_MissingType verifies error handling when delegate config lacks 'type' field
@@ -13,7 +21,7 @@ func TestDelegateAdd_MissingType(t *testing.T) {
 	delegateConfig := json.RawMessage(`{"cniVersion": "1.0.0"}`)
 	stdin := []byte(`{}`)
 
-	_, err := DelegateAdd(delegateConfig, "test-network", stdin)
+	_, err := DelegateAdd(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when delegate config missing 'type' field")
 	}
@@ -28,7 +36,7 @@ func TestDelegateAdd_InvalidJSON(t *testing.T) {
 	delegateConfig := json.RawMessage(`{invalid json}`)
 	stdin := []byte(`{}`)
 
-	_, err := DelegateAdd(delegateConfig, "test-network", stdin)
+	_, err := DelegateAdd(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when delegate config is invalid JSON")
 	}
@@ -54,7 +62,7 @@ func TestDelegateAdd_MissingCNIPath(t *testing.T) {
 	delegateConfig := json.RawMessage(`{"type": "ptp", "cniVersion": "1.0.0"}`)
 	stdin := []byte(`{}`)
 
-	_, err := DelegateAdd(delegateConfig, "test-network", stdin)
+	_, err := DelegateAdd(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when CNI_PATH not set")
 	}
@@ -69,7 +77,7 @@ func TestDelegateDel_MissingType(t *testing.T) {
 	delegateConfig := json.RawMessage(`{"cniVersion": "1.0.0"}`)
 	stdin := []byte(`{}`)
 
-	err := DelegateDel(delegateConfig, "test-network", stdin)
+	err := DelegateDel(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when delegate config missing 'type' field")
 	}
@@ -84,7 +92,7 @@ func TestDelegateDel_InvalidJSON(t *testing.T) {
 	delegateConfig := json.RawMessage(`{invalid json}`)
 	stdin := []byte(`{}`)
 
-	err := DelegateDel(delegateConfig, "test-network", stdin)
+	err := DelegateDel(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when delegate config is invalid JSON")
 	}
@@ -110,7 +118,7 @@ func TestDelegateDel_MissingCNIPath(t *testing.T) {
 	delegateConfig := json.RawMessage(`{"type": "ptp", "cniVersion": "1.0.0"}`)
 	stdin := []byte(`{}`)
 
-	err := DelegateDel(delegateConfig, "test-network", stdin)
+	err := DelegateDel(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when CNI_PATH not set")
 	}
@@ -125,7 +133,7 @@ func TestDelegateCheck_MissingType(t *testing.T) {
 	delegateConfig := json.RawMessage(`{"cniVersion": "1.0.0"}`)
 	stdin := []byte(`{}`)
 
-	err := DelegateCheck(delegateConfig, "test-network", stdin)
+	err := DelegateCheck(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when delegate config missing 'type' field")
 	}
@@ -140,7 +148,7 @@ func TestDelegateCheck_InvalidJSON(t *testing.T) {
 	delegateConfig := json.RawMessage(`{invalid json}`)
 	stdin := []byte(`{}`)
 
-	err := DelegateCheck(delegateConfig, "test-network", stdin)
+	err := DelegateCheck(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when delegate config is invalid JSON")
 	}
@@ -166,7 +174,7 @@ func TestDelegateCheck_MissingCNIPath(t *testing.T) {
 	delegateConfig := json.RawMessage(`{"type": "ptp", "cniVersion": "1.0.0"}`)
 	stdin := []byte(`{}`)
 
-	err := DelegateCheck(delegateConfig, "test-network", stdin)
+	err := DelegateCheck(context.Background(), delegateConfig, "test-network", stdin)
 	if err == nil {
 		t.Fatal("Expected error when CNI_PATH not set")
 	}
@@ -176,6 +184,180 @@ func TestDelegateCheck_MissingCNIPath(t *testing.T) {
 	}
 }
 
+// TestDelegateGC_MissingType verifies error handling when delegate config lacks 'type' field
+func TestDelegateGC_MissingType(t *testing.T) {
+	delegateConfig := json.RawMessage(`{"cniVersion": "1.1.0"}`)
+	stdin := []byte(`{}`)
+
+	err := DelegateGC(context.Background(), delegateConfig, "test-network", stdin)
+	if err == nil {
+		t.Fatal("Expected error when delegate config missing 'type' field")
+	}
+
+	if !strings.Contains(err.Error(), "missing required 'type' field") {
+		t.Errorf("Expected error about missing 'type', got: %v", err)
+	}
+}
+
+// TestDelegateGC_PassesValidAttachments verifies the delegate receives the GC verb and attachment list
+func TestDelegateGC_PassesValidAttachments(t *testing.T) {
+	dir := t.TempDir()
+	captured := filepath.Join(dir, "stdin.json")
+	script := "#!/bin/sh\necho \"$CNI_COMMAND\" > " + captured + ".cmd\ncat > " + captured + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "fake-ptp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+
+	delegateConfig := json.RawMessage(`{"type": "fake-ptp"}`)
+	stdin := []byte(`{"cniVersion": "1.1.0", "name": "tenant-net",
+		"cni.dev/valid-attachments": [{"containerID": "abc", "ifname": "eth0"}]}`)
+
+	if err := DelegateGC(context.Background(), delegateConfig, "tenant-net", stdin); err != nil {
+		t.Fatalf("DelegateGC() error = %v", err)
+	}
+
+	command, err := os.ReadFile(captured + ".cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(command)) != "GC" {
+		t.Errorf("CNI_COMMAND = %q, want GC", command)
+	}
+
+	var got map[string]any
+	data, err := os.ReadFile(captured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("delegate stdin is not JSON: %v", err)
+	}
+	if got["name"] != "tenant-net" || got["cniVersion"] != "1.1.0" {
+		t.Errorf("delegate config = %v, want name and cniVersion injected", got)
+	}
+	attachments, ok := got["cni.dev/valid-attachments"].([]any)
+	if !ok || len(attachments) != 1 {
+		t.Errorf("valid attachments = %v, want one entry", got["cni.dev/valid-attachments"])
+	}
+}
+
+// TestDelegateAdd_InheritsParentConfig verifies prevResult, capabilities and the matching
+// runtimeConfig entries reach the delegate
+func TestDelegateAdd_InheritsParentConfig(t *testing.T) {
+	dir := t.TempDir()
+	captured := filepath.Join(dir, "stdin.json")
+	script := "#!/bin/sh\ncat > " + captured + "\necho '{\"cniVersion\":\"1.0.0\",\"ips\":[{\"address\":\"10.200.1.5/24\"}]}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "fake-ptp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CNI_PATH", dir)
+	t.Setenv("CNI_COMMAND", "ADD")
+
+	stdin := []byte(`{"cniVersion": "1.0.0", "name": "tenant-net",
+		"capabilities": {"portMappings": true, "bandwidth": true},
+		"runtimeConfig": {"portMappings": [{"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}],
+			"bandwidth": {"ingressRate": 1000}},
+		"prevResult": {"cniVersion": "1.0.0", "interfaces": [{"name": "eth0"}]}}`)
+
+	tests := []struct {
+		name            string
+		delegate        string
+		wantRuntimeKeys []string
+	}{
+		{"inherits wrapper capabilities", `{"type": "fake-ptp"}`, []string{"bandwidth", "portMappings"}},
+		{"own capabilities narrow runtimeConfig", `{"type": "fake-ptp", "capabilities": {"portMappings": true}}`, []string{"portMappings"}},
+		{"no capabilities enabled", `{"type": "fake-ptp", "capabilities": {}}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DelegateAdd(context.Background(), json.RawMessage(tt.delegate), "tenant-net", stdin); err != nil {
+				t.Fatalf("DelegateAdd() error = %v", err)
+			}
+			var got map[string]any
+			data, err := os.ReadFile(captured)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("delegate stdin is not JSON: %v", err)
+			}
+			if got["cniVersion"] != "1.0.0" || got["prevResult"] == nil || got["capabilities"] == nil {
+				t.Errorf("delegate config = %s, want cniVersion, prevResult and capabilities", data)
+			}
+			runtimeConfig, _ := got["runtimeConfig"].(map[string]any)
+			var keys []string
+			for key := range runtimeConfig {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if strings.Join(keys, ",") != strings.Join(tt.wantRuntimeKeys, ",") {
+				t.Errorf("runtimeConfig keys = %v, want %v", keys, tt.wantRuntimeKeys)
+			}
+		})
+	}
+}
+
+// TestDelegateStatus verifies VERSION fallback and STATUS error code passthrough
+func TestDelegateStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		cniVersion string
+		versions   string
+		status     string
+		wantCode   uint
+		wantErr    error
+	}{
+		{name: "pre-1.1 delegate answering VERSION", cniVersion: "1.0.0", versions: `["0.4.0","1.0.0"]`},
+		{name: "1.1 delegate ready", cniVersion: "1.1.0", versions: `["1.0.0","1.1.0"]`, status: "exit 0"},
+		{
+			name:       "1.1 delegate not available",
+			cniVersion: "1.1.0",
+			versions:   `["1.0.0","1.1.0"]`,
+			status:     `echo '{"cniVersion":"1.1.0","code":50,"msg":"no uplink"}'; exit 1`,
+			wantCode:   50,
+		},
+		{name: "delegate older than the config", cniVersion: "1.1.0", versions: `["0.3.1","0.4.0"]`, wantErr: ErrUnsupportedVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			script := "#!/bin/sh\n" +
+				"if [ \"$CNI_COMMAND\" = VERSION ]; then\n" +
+				"  echo '{\"cniVersion\":\"1.0.0\",\"supportedVersions\":" + tt.versions + "}'\n" +
+				"  exit 0\n" +
+				"fi\n" +
+				"cat >/dev/null\n" +
+				tt.status + "\n"
+			if err := os.WriteFile(filepath.Join(dir, "fake-ptp"), []byte(script), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CNI_PATH", dir)
+
+			stdin := []byte(`{"cniVersion": "` + tt.cniVersion + `"}`)
+			err := DelegateStatus(context.Background(), json.RawMessage(`{"type": "fake-ptp"}`), "tenant-net", stdin)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !errors.Is(err, ErrDelegateFailed) {
+					t.Errorf("DelegateStatus() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("DelegateStatus() error = %v", err)
+				}
+				return
+			}
+
+			var cniErr *types.Error
+			if !errors.As(err, &cniErr) || cniErr.Code != tt.wantCode {
+				t.Errorf("DelegateStatus() error = %v, want CNI error code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
 // TestGetPluginPath_Success verifies plugin path resolution
 func TestGetPluginPath_Success(t *testing.T) {
 	// Save and restore CNI_PATH
@@ -217,3 +399,44 @@ func TestGetPluginPath_MissingCNIPath(t *testing.T) {
 		t.Errorf("Expected CNI_PATH error, got: %v", err)
 	}
 }
+
+// TestSetSecurePaths verifies plugin binaries found in CNI_PATH are checked and run from
+// their resolved path, and that one in a directory other users can write is refused
+func TestSetSecurePaths(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("ownership checks need files owned by root")
+	}
+	root := t.TempDir()
+	binDir, sharedDir := filepath.Join(root, "bin"), filepath.Join(root, "shared")
+	for _, dir := range []string{binDir, sharedDir} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "ptp"), []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(sharedDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(binDir, "ptp"), filepath.Join(binDir, "bridge")); err != nil {
+		t.Fatal(err)
+	}
+	SetSecurePaths([]string{root})
+	defer SetSecurePaths(nil)
+
+	t.Setenv("CNI_PATH", binDir)
+	if got, err := GetPluginPath("bridge"); err != nil || got != filepath.Join(binDir, "ptp") {
+		t.Errorf("GetPluginPath(bridge) = %q, %v; want the resolved ptp", got, err)
+	}
+
+	t.Setenv("CNI_PATH", sharedDir)
+	if _, err := GetPluginPath("ptp"); !errors.Is(err, safepath.ErrUnsafe) {
+		t.Errorf("GetPluginPath() in a writable directory error = %v, want ErrUnsafe", err)
+	}
+	t.Setenv("CNI_COMMAND", "ADD")
+	_, err := DelegateAdd(context.Background(), []byte(`{"type": "ptp"}`), "tenant-net", nil)
+	if !errors.Is(err, safepath.ErrUnsafe) {
+		t.Errorf("DelegateAdd() of a binary in a writable directory error = %v, want ErrUnsafe", err)
+	}
+}
@@ -0,0 +1,40 @@
+package delegate
+
+import (
+	"errors"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// ErrDelegateFailed is matched (errors.Is) by the errors of delegate plugins that could
+// not be run or reported a failure; errors.As still finds the plugin's own *types.Error
+// Invalid delegate configurations and a missing CNI_PATH do not match it.
+var ErrDelegateFailed = errors.New("delegate plugin failed")
+
+// failedError marks err as a failure of the delegate plugin, keeping its message
+type failedError struct {
+	err error
+}
+
+func (e *failedError) Error() string { return e.err.Error() }
+
+func (e *failedError) Unwrap() error { return e.err }
+
+func (e *failedError) Is(target error) bool { return target == ErrDelegateFailed }
+
+// pluginFailed returns err, which running a delegate plugin returned, as a match of ErrDelegateFailed
+func pluginFailed(err error) error {
+	return &failedError{err: err}
+}
+
+// ReportedError returns the error the delegate plugin itself printed for the failure
+// err, with code, message and details as the plugin wrote them, so it can be passed on
+// unmodified; nil if err is no delegate failure or the plugin reported no error code
+// (it crashed, timed out, printed no error or was not found)
+func ReportedError(err error) *types.Error {
+	var cniErr *types.Error
+	if !errors.Is(err, ErrDelegateFailed) || !errors.As(err, &cniErr) || cniErr.Code == 0 {
+		return nil
+	}
+	return cniErr
+}
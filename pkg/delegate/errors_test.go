@@ -0,0 +1,67 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// TestErrDelegateFailed verifies plugin failures match ErrDelegateFailed and keep the
+// plugin's error code, while configuration errors do not match
+func TestErrDelegateFailed(t *testing.T) {
+	fake := useFakeExec(t)
+	fake.SetError("bridge", "ADD", types.NewError(types.ErrIOFailure, "no bridge", ""))
+	fake.SetError("bridge", "DEL", errors.New("busy"))
+	conf := json.RawMessage(`{"type": "bridge"}`)
+	stdin := []byte(`{"cniVersion": "1.0.0"}`)
+
+	_, err := DelegateAdd(context.Background(), conf, "tenant-net", stdin)
+	var cniErr *types.Error
+	if !errors.Is(err, ErrDelegateFailed) || !errors.As(err, &cniErr) || cniErr.Code != types.ErrIOFailure {
+		t.Errorf("DelegateAdd() error = %v, want ErrDelegateFailed with code %d", err, types.ErrIOFailure)
+	}
+	if !strings.HasPrefix(err.Error(), `delegate plugin "bridge" failed: no bridge`) {
+		t.Errorf("DelegateAdd() error = %q, want the plugin's message", err)
+	}
+	if err := DelegateDel(context.Background(), conf, "tenant-net", stdin); !errors.Is(err, ErrDelegateFailed) {
+		t.Errorf("DelegateDel() error = %v, want ErrDelegateFailed", err)
+	}
+
+	fake.SetError("bridge", "ADD", errors.New("no IP addresses available in network: tenant-net"))
+	if _, err := DelegateAdd(context.Background(), conf, "tenant-net", stdin); !errors.Is(err, ErrDelegateFailed) {
+		t.Errorf("DelegateAdd() IPAM error = %v, want ErrDelegateFailed", err)
+	}
+
+	if _, err := DelegateAdd(context.Background(), json.RawMessage(`{}`), "tenant-net", stdin); err == nil ||
+		errors.Is(err, ErrDelegateFailed) {
+		t.Errorf("DelegateAdd() of a config without type error = %v, want a configuration error", err)
+	}
+}
+
+// TestReportedError verifies the error a plugin printed is found unmodified, and only that
+func TestReportedError(t *testing.T) {
+	fake := useFakeExec(t)
+	reported := types.NewError(types.ErrTryAgainLater, "bridge busy", "lock held by pid 42")
+	fake.SetError("bridge", "ADD", reported)
+	conf := json.RawMessage(`{"type": "bridge"}`)
+	stdin := []byte(`{"cniVersion": "1.0.0"}`)
+
+	_, err := DelegateAdd(context.Background(), conf, "tenant-net", stdin)
+	if got := ReportedError(err); got != reported {
+		t.Errorf("ReportedError() = %#v, want %#v", got, reported)
+	}
+
+	fake.SetError("bridge", "ADD", &types.Error{Msg: "netplugin failed with no error message: signal: killed"})
+	_, err = DelegateAdd(context.Background(), conf, "tenant-net", stdin)
+	if got := ReportedError(err); got != nil {
+		t.Errorf("ReportedError() of a crashed plugin = %#v, want nil", got)
+	}
+	if got := ReportedError(fmt.Errorf("config: %w", reported)); got != nil {
+		t.Errorf("ReportedError() of no delegate failure = %#v, want nil", got)
+	}
+}
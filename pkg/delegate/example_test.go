@@ -27,7 +27,7 @@ func Example_delegationUsage() {
 	fmt.Println("Step 1: Wrapper configures fwmark and routing")
 
 	// 3. Delegate to next CNI plugin (in real code):
-	//    result, err := delegate.DelegateAdd(delegateConfig, stdin)
+	//    result, err := delegate.DelegateAdd(ctx, delegateConfig, stdin)
 	//    This executes the ptp plugin which:
 	//    - Creates veth pair
 	//    - Calls IPAM plugin (host-local) to allocate IP
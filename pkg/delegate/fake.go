@@ -0,0 +1,120 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// fakePluginDir is the directory FakeExec pretends every plugin is installed in
+const fakePluginDir = "/opt/cni/bin"
+
+// FakeCall is one plugin invocation recorded by FakeExec
+type FakeCall struct {
+	// Plugin is the plugin type ("ptp")
+	Plugin string
+
+	// Command is the CNI_COMMAND the plugin was run with ("ADD")
+	Command string
+
+	// Stdin is the network configuration the plugin received
+	Stdin []byte
+}
+
+// FakeExec is an invoke.Exec for unit tests that answers plugin calls with canned
+// results instead of running binaries (install it with SetExec)
+// ADD returns the result set with SetResult (an empty result by default), VERSION
+// reports every spec version, and the other commands succeed unless SetError
+// says otherwise. A call with a done context fails with its error, as a killed plugin
+// would, and is not recorded. Safe for concurrent use.
+type FakeExec struct {
+	// PluginDecoder parses VERSION answers, as in invoke.DefaultExec
+	version.PluginDecoder
+
+	mu      sync.Mutex
+	results map[string]types.Result
+	errors  map[string]error
+	calls   []FakeCall
+}
+
+// NewFakeExec returns a FakeExec with no canned results
+func NewFakeExec() *FakeExec {
+	return &FakeExec{results: map[string]types.Result{}, errors: map[string]error{}}
+}
+
+// SetResult makes ADD of plugin return res
+func (f *FakeExec) SetResult(plugin string, res types.Result) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[plugin] = res
+}
+
+// SetError makes command ("ADD", "DEL", ...) of plugin fail with err
+// A *types.Error keeps its code, as if the plugin had printed it.
+func (f *FakeExec) SetError(plugin, command string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[plugin+" "+command] = err
+}
+
+// Calls returns the recorded invocations in order
+func (f *FakeExec) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// ExecPlugin answers one plugin invocation from the canned results
+func (f *FakeExec) ExecPlugin(ctx context.Context, pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	plugin := filepath.Base(pluginPath)
+	command := ""
+	for _, env := range environ {
+		if value, ok := strings.CutPrefix(env, "CNI_COMMAND="); ok {
+			command = value
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, FakeCall{Plugin: plugin, Command: command, Stdin: append([]byte(nil), stdinData...)})
+	res := f.results[plugin]
+	err := f.errors[plugin+" "+command]
+	f.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	switch command {
+	case "ADD":
+		cniVersion := stdinVersion(stdinData)
+		if res == nil {
+			res = &current.Result{CNIVersion: current.ImplementedSpecVersion}
+		}
+		if cniVersion != "" {
+			converted, err := res.GetAsVersion(cniVersion)
+			if err != nil {
+				return nil, fmt.Errorf("fake %s: %w", plugin, err)
+			}
+			res = converted
+		}
+		return json.Marshal(res)
+	case "VERSION":
+		return json.Marshal(version.All)
+	default:
+		return nil, nil
+	}
+}
+
+// FindInPath resolves every plugin, without looking at paths
+func (f *FakeExec) FindInPath(plugin string, _ []string) (string, error) {
+	return filepath.Join(fakePluginDir, plugin), nil
+}
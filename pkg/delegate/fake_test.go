@@ -0,0 +1,116 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// useFakeExec installs a FakeExec for the duration of a test
+func useFakeExec(t *testing.T) *FakeExec {
+	t.Helper()
+	fake := NewFakeExec()
+	t.Cleanup(SetExec(fake))
+	t.Setenv("CNI_PATH", fakePluginDir)
+	return fake
+}
+
+// TestFakeExec_Add verifies the canned result is returned in the requested version
+func TestFakeExec_Add(t *testing.T) {
+	fake := useFakeExec(t)
+	_, ipnet, _ := net.ParseCIDR("10.200.1.5/24")
+	ipnet.IP = net.ParseIP("10.200.1.5")
+	fake.SetResult("ptp", &current.Result{CNIVersion: "1.0.0", IPs: []*current.IPConfig{{Address: *ipnet}}})
+
+	res, err := DelegateAdd(context.Background(), json.RawMessage(`{"type": "ptp"}`), "tenant-net", []byte(`{"cniVersion": "0.4.0"}`))
+	if err != nil {
+		t.Fatalf("DelegateAdd() error = %v", err)
+	}
+	if res.Version() != "0.4.0" {
+		t.Errorf("result version = %s, want 0.4.0", res.Version())
+	}
+	result, err := current.NewResultFromResult(res)
+	if err != nil || len(result.IPs) != 1 || result.IPs[0].Address.String() != "10.200.1.5/24" {
+		t.Errorf("DelegateAdd() = %v, %v; want 10.200.1.5/24", res, err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 1 || calls[0].Plugin != "ptp" || calls[0].Command != "ADD" {
+		t.Fatalf("calls = %+v, want one ptp ADD", calls)
+	}
+	var stdin map[string]any
+	if err := json.Unmarshal(calls[0].Stdin, &stdin); err != nil || stdin["name"] != "tenant-net" {
+		t.Errorf("delegate stdin = %s, want name injected", calls[0].Stdin)
+	}
+}
+
+// TestFakeExec_Errors verifies canned failures, including CNI error codes, reach the caller
+func TestFakeExec_Errors(t *testing.T) {
+	fake := useFakeExec(t)
+	fake.SetError("bridge", "ADD", errors.New("no bridge"))
+	fake.SetError("bridge", "STATUS", types.NewError(50, "no uplink", ""))
+
+	if _, err := DelegateAdd(context.Background(), json.RawMessage(`[{"type": "ptp"}, {"type": "bridge"}]`), "tenant-net",
+		[]byte(`{"cniVersion": "1.1.0"}`)); err == nil {
+		t.Error("DelegateAdd() expected error")
+	}
+	var got []string
+	for _, call := range fake.Calls() {
+		got = append(got, call.Plugin+" "+call.Command)
+	}
+	if want := []string{"ptp ADD", "bridge ADD", "ptp DEL"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+
+	err := DelegateStatus(context.Background(), json.RawMessage(`{"type": "bridge"}`), "tenant-net", []byte(`{"cniVersion": "1.1.0"}`))
+	var cniErr *types.Error
+	if !errors.As(err, &cniErr) || cniErr.Code != 50 {
+		t.Errorf("DelegateStatus() error = %v, want code 50", err)
+	}
+	if err := DelegateDel(context.Background(), json.RawMessage(`{"type": "bridge"}`), "tenant-net", []byte(`{"cniVersion": "1.1.0"}`)); err != nil {
+		t.Errorf("DelegateDel() error = %v", err)
+	}
+}
+
+// cancelAfterAdd cancels a context once plugin completed ADD
+type cancelAfterAdd struct {
+	*FakeExec
+	plugin string
+	cancel context.CancelFunc
+}
+
+func (c cancelAfterAdd) ExecPlugin(ctx context.Context, pluginPath string, stdinData []byte, environ []string) ([]byte, error) {
+	out, err := c.FakeExec.ExecPlugin(ctx, pluginPath, stdinData, environ)
+	if filepath.Base(pluginPath) == c.plugin && slices.Contains(environ, "CNI_COMMAND=ADD") {
+		c.cancel()
+	}
+	return out, err
+}
+
+// TestDelegateAdd_Cancelled verifies a cancelled invocation stops the chain and still rolls back
+func TestDelegateAdd_Cancelled(t *testing.T) {
+	fake := useFakeExec(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t.Cleanup(SetExec(cancelAfterAdd{FakeExec: fake, plugin: "ptp", cancel: cancel}))
+
+	_, err := DelegateAdd(ctx, json.RawMessage(`[{"type": "ptp"}, {"type": "bridge"}]`), "tenant-net",
+		[]byte(`{"cniVersion": "1.1.0"}`))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DelegateAdd() error = %v, want context.Canceled", err)
+	}
+	var got []string
+	for _, call := range fake.Calls() {
+		got = append(got, call.Plugin+" "+call.Command)
+	}
+	if want := []string{"ptp ADD", "ptp DEL"}; !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package delegate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrIPAMExhausted is matched (errors.Is) by delegate errors whose IPAM ran out of addresses
+var ErrIPAMExhausted = errors.New("IPAM address pool exhausted")
+
+// IPAMExhaustedError is a failed delegate ADD whose IPAM had no address left to lease
+type IPAMExhaustedError struct {
+	// Plugin is the delegate type that failed, e.g. "bridge"
+	Plugin string
+
+	// Range is the exhausted range or subnet as the IPAM reported it ("" if it did not)
+	Range string
+
+	// Err is the delegate's own error
+	Err error
+}
+
+func (e *IPAMExhaustedError) Error() string {
+	pool := "its address pool"
+	if e.Range != "" {
+		pool = e.Range
+	}
+	return fmt.Sprintf("delegate plugin %q failed: IPAM has no free address in %s (%v); "+
+		"release the leases of deleted pods (host-local keeps them under /var/lib/cni/networks/<network>) "+
+		"or widen the range, and check the node's pod count against its subnet", e.Plugin, pool, e.Err)
+}
+
+func (e *IPAMExhaustedError) Unwrap() error { return e.Err }
+
+func (e *IPAMExhaustedError) Is(target error) bool {
+	return target == ErrIPAMExhausted || target == ErrDelegateFailed
+}
+
+// ipamExhaustion matches the messages of IPAM plugins that ran out of addresses
+// The first submatch, if any, names the exhausted range.
+var ipamExhaustion = []*regexp.Regexp{
+	// host-local: "no IP addresses available in range set: 10.22.0.2-10.22.0.254"
+	regexp.MustCompile(`no IP addresses available in range set: ([^\s";]+)`),
+	// host-local before range sets: "no IP addresses available in network: mynet"
+	regexp.MustCompile(`no IP addresses available in network: ([^\s";]+)`),
+	// whereabouts: "Could not allocate IP in range: ip: 10.0.0.1 / - 10.0.0.5 / range: 10.0.0.0/29 / ..."
+	regexp.MustCompile(`Could not allocate IP in range: .*?range: ([^\s";]+)`),
+}
+
+// classifyAddError returns the error of a failed delegate ADD of pluginType
+// IPAM exhaustion becomes an IPAMExhaustedError; other errors are wrapped as they are.
+// Both match ErrDelegateFailed.
+func classifyAddError(pluginType string, err error) error {
+	msg := err.Error()
+	for _, pattern := range ipamExhaustion {
+		if m := pattern.FindStringSubmatch(msg); m != nil {
+			return &IPAMExhaustedError{Plugin: pluginType, Range: m[1], Err: err}
+		}
+	}
+	return pluginFailed(fmt.Errorf("delegate plugin %q failed: %w", pluginType, err))
+}
@@ -0,0 +1,57 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// TestDelegateAdd_IPAMExhausted verifies known exhaustion messages become IPAMExhaustedError
+func TestDelegateAdd_IPAMExhausted(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantRange string
+	}{
+		{"host-local", types.NewError(types.ErrInternal,
+			"failed to allocate for range 0: no IP addresses available in range set: 10.22.0.2-10.22.0.254", ""), "10.22.0.2-10.22.0.254"},
+		{"host-local network", errors.New("no IP addresses available in network: tenant-net"), "tenant-net"},
+		{"whereabouts", errors.New(`netplugin failed: "Could not allocate IP in range: ip: 10.0.0.1 / - 10.0.0.6 / range: 10.0.0.0/29 / excludeRanges: []"`),
+			"10.0.0.0/29"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeExec(t)
+			fake.SetError("bridge", "ADD", tt.err)
+
+			_, err := DelegateAdd(context.Background(), json.RawMessage(`{"type": "bridge"}`), "tenant-net", []byte(`{"cniVersion": "1.0.0"}`))
+			var exhausted *IPAMExhaustedError
+			if !errors.As(err, &exhausted) || !errors.Is(err, ErrIPAMExhausted) {
+				t.Fatalf("DelegateAdd() error = %v, want IPAMExhaustedError", err)
+			}
+			if exhausted.Plugin != "bridge" || exhausted.Range != tt.wantRange {
+				t.Errorf("IPAMExhaustedError = {Plugin: %q, Range: %q}, want bridge, %q", exhausted.Plugin, exhausted.Range, tt.wantRange)
+			}
+			if !strings.Contains(err.Error(), tt.wantRange) || !strings.Contains(err.Error(), "widen the range") {
+				t.Errorf("error %q does not name the range and a remedy", err)
+			}
+		})
+	}
+
+	// Other failures keep their plain error, also inside a delegate list
+	fake := useFakeExec(t)
+	fake.SetError("bridge", "ADD", errors.New("no bridge"))
+	_, err := DelegateAdd(context.Background(), json.RawMessage(`{"type": "bridge"}`), "tenant-net", []byte(`{"cniVersion": "1.0.0"}`))
+	if err == nil || errors.Is(err, ErrIPAMExhausted) {
+		t.Errorf("DelegateAdd() error = %v, want a plain failure", err)
+	}
+	fake.SetError("bridge", "ADD", errors.New("no IP addresses available in range set: 10.22.0.2-10.22.0.254"))
+	_, err = DelegateAdd(context.Background(), json.RawMessage(`[{"type": "ptp"}, {"type": "bridge"}]`), "tenant-net", []byte(`{"cniVersion": "1.0.0"}`))
+	if !errors.Is(err, ErrIPAMExhausted) {
+		t.Errorf("DelegateAdd() of a list error = %v, want ErrIPAMExhausted", err)
+	}
+}
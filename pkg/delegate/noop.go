@@ -0,0 +1,112 @@
+package delegate
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// NoopType is the delegate type the wrapper answers itself, without running a plugin
+// It lets CI and load benchmarks run the full pipeline on nodes without CNI plugins:
+//
+//	"delegate": {"type": "noop", "subnet": "10.128.0.0/9", "interfaces": ["eth0", "net1"]}
+//
+// ADD fabricates a result with one address per interface; DEL, CHECK, GC and STATUS
+// succeed. Nothing is configured in the network namespace.
+const NoopType = "noop"
+
+// defaultNoopSubnet is the subnet of the noop delegate's addresses when it sets none
+const defaultNoopSubnet = "10.128.0.0/9"
+
+// noopConf is the configuration of the noop delegate
+type noopConf struct {
+	// Subnet the addresses are taken from
+	Subnet string `json:"subnet"`
+
+	// Interfaces are the container interfaces of the result (CNI_IFNAME if none)
+	Interfaces []string `json:"interfaces"`
+}
+
+// noopAdd returns the result the noop delegate fabricates for the container in CNI_CONTAINERID
+// Every process runs one invocation, so there is no allocation state: an address is
+// derived from the container ID and interface name and is the same on every ADD.
+// Containers may collide, the likelier the smaller the subnet.
+func noopAdd(delegateConfig []byte) (types.Result, error) {
+	var conf noopConf
+	if err := json.Unmarshal(delegateConfig, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse %s delegate config: %w", NoopType, err)
+	}
+	if conf.Subnet == "" {
+		conf.Subnet = defaultNoopSubnet
+	}
+	_, subnet, err := net.ParseCIDR(conf.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s delegate subnet %q: %w", NoopType, conf.Subnet, err)
+	}
+	ones, bits := subnet.Mask.Size()
+	if bits-ones < 2 {
+		return nil, fmt.Errorf("%s delegate subnet %s has no host addresses", NoopType, subnet)
+	}
+	if len(conf.Interfaces) == 0 {
+		ifName := os.Getenv("CNI_IFNAME")
+		if ifName == "" {
+			ifName = "eth0"
+		}
+		conf.Interfaces = []string{ifName}
+	}
+
+	containerID := os.Getenv("CNI_CONTAINERID")
+	gateway := hostAddress(subnet, big.NewInt(1))
+	res := &current.Result{CNIVersion: current.ImplementedSpecVersion}
+	for i, ifName := range conf.Interfaces {
+		index := i
+		res.Interfaces = append(res.Interfaces, &current.Interface{Name: ifName, Sandbox: os.Getenv("CNI_NETNS")})
+		res.IPs = append(res.IPs, &current.IPConfig{
+			Interface: &index,
+			Address:   net.IPNet{IP: noopAddress(subnet, containerID+"/"+ifName), Mask: subnet.Mask},
+			Gateway:   gateway,
+		})
+	}
+
+	// Answer in the version the runtime asked for, as a real plugin would
+	var versioned struct {
+		CNIVersion string `json:"cniVersion"`
+	}
+	if err := json.Unmarshal(delegateConfig, &versioned); err == nil && versioned.CNIVersion != "" {
+		converted, err := res.GetAsVersion(versioned.CNIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("%s delegate: %w", NoopType, err)
+		}
+		return converted, nil
+	}
+	return res, nil
+}
+
+// noopAddress derives the host address of key in subnet, skipping the network address,
+// the gateway (.1) and the last (broadcast) address
+func noopAddress(subnet *net.IPNet, key string) net.IP {
+	ones, bits := subnet.Mask.Size()
+	hosts := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)), big.NewInt(3))
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	offset := new(big.Int).SetUint64(h.Sum64())
+	offset.Mod(offset, hosts)
+	return hostAddress(subnet, offset.Add(offset, big.NewInt(2)))
+}
+
+// hostAddress returns the address offset addresses into subnet
+func hostAddress(subnet *net.IPNet, offset *big.Int) net.IP {
+	base := subnet.IP.To4()
+	if base == nil {
+		base = subnet.IP.To16()
+	}
+	ip := make(net.IP, len(base))
+	new(big.Int).Add(new(big.Int).SetBytes(base), offset).FillBytes(ip)
+	return ip
+}
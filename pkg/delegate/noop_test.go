@@ -0,0 +1,73 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// TestNoop verifies the noop delegate answers every command without CNI_PATH or a plugin
+func TestNoop(t *testing.T) {
+	fake := NewFakeExec()
+	t.Cleanup(SetExec(fake))
+	t.Setenv("CNI_PATH", "")
+	t.Setenv("CNI_CONTAINERID", "c0ffee")
+	t.Setenv("CNI_NETNS", "/var/run/netns/pod")
+	t.Setenv("CNI_IFNAME", "eth0")
+	ctx, stdin := context.Background(), []byte(`{"cniVersion": "1.0.0"}`)
+	conf := json.RawMessage(`{"type": "noop", "subnet": "10.99.0.0/16", "interfaces": ["eth0", "net1"]}`)
+
+	res, err := DelegateAdd(ctx, conf, "tenant-net", stdin)
+	if err != nil {
+		t.Fatalf("DelegateAdd() error = %v", err)
+	}
+	result, err := current.NewResultFromResult(res)
+	if err != nil || len(result.Interfaces) != 2 || len(result.IPs) != 2 {
+		t.Fatalf("DelegateAdd() = %v, %v; want two interfaces with an address each", res, err)
+	}
+	_, subnet, _ := net.ParseCIDR("10.99.0.0/16")
+	for i, ipConfig := range result.IPs {
+		ip := ipConfig.Address.IP
+		if !subnet.Contains(ip) || ip.Equal(net.ParseIP("10.99.0.1")) || *ipConfig.Interface != i ||
+			result.Interfaces[i].Sandbox != "/var/run/netns/pod" {
+			t.Errorf("IPs[%d] = %+v, want a host address of %s on %s", i, ipConfig, subnet, result.Interfaces[i].Name)
+		}
+	}
+	if result.IPs[0].Address.IP.Equal(result.IPs[1].Address.IP) {
+		t.Errorf("both interfaces got %s", result.IPs[0].Address.IP)
+	}
+
+	// The same container gets the same address, others most likely not
+	again, _ := DelegateAdd(ctx, conf, "tenant-net", stdin)
+	if againResult, _ := current.NewResultFromResult(again); !againResult.IPs[0].Address.IP.Equal(result.IPs[0].Address.IP) {
+		t.Errorf("second ADD got %s, want %s again", againResult.IPs[0].Address.IP, result.IPs[0].Address.IP)
+	}
+	t.Setenv("CNI_CONTAINERID", "beef")
+	other, _ := DelegateAdd(ctx, conf, "tenant-net", stdin)
+	if otherResult, _ := current.NewResultFromResult(other); otherResult.IPs[0].Address.IP.Equal(result.IPs[0].Address.IP) {
+		t.Errorf("another container got the same address %s", result.IPs[0].Address.IP)
+	}
+
+	// Defaults: CNI_IFNAME, the default subnet and the runtime's version
+	res, err = DelegateAdd(ctx, json.RawMessage(`{"type": "noop"}`), "tenant-net", []byte(`{"cniVersion": "0.4.0"}`))
+	if err != nil || res.Version() != "0.4.0" {
+		t.Fatalf("DelegateAdd() of the defaults = %v, %v; want a 0.4.0 result", res, err)
+	}
+	if _, err := DelegateAdd(ctx, json.RawMessage(`{"type": "noop", "subnet": "10.99.0.0/31"}`), "tenant-net", stdin); err == nil {
+		t.Error("DelegateAdd() with a /31 subnet succeeded, want an error")
+	}
+
+	for name, fn := range map[string]func(context.Context, json.RawMessage, string, []byte) error{
+		"DEL": DelegateDel, "CHECK": DelegateCheck, "GC": DelegateGC, "STATUS": DelegateStatus,
+	} {
+		if err := fn(ctx, conf, "tenant-net", stdin); err != nil {
+			t.Errorf("%s error = %v", name, err)
+		}
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("plugin calls = %+v, want none", calls)
+	}
+}
@@ -0,0 +1,94 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// ErrUnsupportedVersion is matched (errors.Is) by DelegateVersion and DelegateStatus
+// errors of a delegate plugin that does not support the cniVersion of the configuration,
+// e.g. a binary older than the configuration, which would fail every ADD
+var ErrUnsupportedVersion = errors.New("delegate plugin does not support the CNI version")
+
+// PluginVersion is the answer of a delegate plugin to VERSION
+// CNI plugins report the spec versions they support only; the build of a binary is
+// not part of the protocol.
+type PluginVersion struct {
+	// Type is the plugin type ("ptp")
+	Type string
+
+	// Path is the binary that answered ("" for the built-in noop delegate)
+	Path string
+
+	// SupportedVersions are the CNI spec versions the plugin supports
+	SupportedVersions []string
+}
+
+func (v PluginVersion) String() string {
+	path := v.Path
+	if path == "" {
+		path = "built-in"
+	}
+	return fmt.Sprintf("%s (%s) supports CNI %s", v.Type, path, strings.Join(v.SupportedVersions, ", "))
+}
+
+// DelegateVersion runs VERSION on every plugin of a delegate block, in execution order,
+// and checks each supports cniVersion ("" skips the check)
+// Returns the answers of the plugins queried before the first failure, if any; a plugin
+// that cannot be run or is older than cniVersion fails with ErrDelegateFailed, the latter
+// also with ErrUnsupportedVersion.
+func DelegateVersion(ctx context.Context, delegateConfig json.RawMessage, cniVersion string) ([]PluginVersion, error) {
+	var versions []PluginVersion
+	err := forEachPlugin(delegateConfig, func(plugin json.RawMessage) error {
+		var conf struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(plugin, &conf); err != nil {
+			return fmt.Errorf("failed to parse delegate config: %w", err)
+		}
+		if conf.Type == "" {
+			return fmt.Errorf("delegate config missing required 'type' field")
+		}
+		info, err := pluginVersion(ctx, conf.Type, cniVersion)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, info)
+		return nil
+	})
+	return versions, err
+}
+
+// pluginVersion runs VERSION on the plugin of pluginType and checks it supports cniVersion
+func pluginVersion(ctx context.Context, pluginType, cniVersion string) (PluginVersion, error) {
+	info := PluginVersion{Type: pluginType}
+	if pluginType == NoopType {
+		info.SupportedVersions = version.All.SupportedVersions()
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, ExecutionTimeout)
+		defer cancel()
+
+		pluginPath, err := GetPluginPath(pluginType)
+		if err != nil {
+			return info, err
+		}
+		info.Path = pluginPath
+		answer, err := invoke.GetVersionInfo(ctx, pluginPath, executor())
+		if err != nil {
+			return info, pluginFailed(fmt.Errorf("delegate plugin %q VERSION failed: %w", pluginType, err))
+		}
+		info.SupportedVersions = answer.SupportedVersions()
+	}
+
+	if cniVersion != "" && !slices.Contains(info.SupportedVersions, cniVersion) {
+		return info, pluginFailed(fmt.Errorf("%w: %s, not %s", ErrUnsupportedVersion, info, cniVersion))
+	}
+	return info, nil
+}
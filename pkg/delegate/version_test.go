@@ -0,0 +1,39 @@
+package delegate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestDelegateVersion verifies every plugin of a chain is asked for VERSION and one
+// older than the configuration is reported
+func TestDelegateVersion(t *testing.T) {
+	fake := useFakeExec(t)
+	chain := json.RawMessage(`[{"type": "ptp"}, {"type": "noop"}]`)
+
+	versions, err := DelegateVersion(context.Background(), chain, "1.1.0")
+	if err != nil {
+		t.Fatalf("DelegateVersion() error = %v", err)
+	}
+	if len(versions) != 2 || versions[0].Type != "ptp" || versions[0].Path != "/opt/cni/bin/ptp" ||
+		versions[1].Type != NoopType || versions[1].Path != "" || len(versions[1].SupportedVersions) == 0 {
+		t.Fatalf("DelegateVersion() = %+v", versions)
+	}
+	if got := versions[1].String(); !strings.HasPrefix(got, "noop (built-in) supports CNI ") {
+		t.Errorf("String() = %q", got)
+	}
+
+	if _, err := DelegateVersion(context.Background(), chain, "9.9.9"); !errors.Is(err, ErrUnsupportedVersion) ||
+		!errors.Is(err, ErrDelegateFailed) {
+		t.Errorf("DelegateVersion() with a newer cniVersion error = %v, want ErrUnsupportedVersion", err)
+	}
+
+	fake.SetError("ptp", "VERSION", errors.New("exec format error"))
+	versions, err = DelegateVersion(context.Background(), chain, "")
+	if !errors.Is(err, ErrDelegateFailed) || errors.Is(err, ErrUnsupportedVersion) || len(versions) != 0 {
+		t.Errorf("DelegateVersion() of a broken binary = %+v, %v; want ErrDelegateFailed", versions, err)
+	}
+}
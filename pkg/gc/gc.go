@@ -0,0 +1,118 @@
+// Package gc removes MARK rules left behind by pods that no longer exist.
+//
+// DEL is the only path that removes per-pod rules, and it is skipped when a node
+// crashes or the kubelet restarts mid-teardown. Collect cross-references the rules
+// programmed on the node (iptables.ListManagedRules) with the live pods reported by
+// the API server (k8s.ListNodePods) and deletes the rules whose pod IP is gone.
+//
+// Pods that exist but have no IP yet may be between CNI ADD and the kubelet status
+// update; while any such pod exists on the node, orphans are reported but kept.
+package gc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// Options controls a collection run
+type Options struct {
+	// Connmark also removes the CONNMARK save/restore rules of orphaned pod IPs
+	Connmark bool
+
+	// DryRun reports orphans without deleting anything
+	DryRun bool
+}
+
+// Result reports what a collection run found and did
+type Result struct {
+	// Orphans are the managed rules whose pod IP belongs to no live pod
+	Orphans []iptables.ManagedRule
+
+	// Removed are the orphans actually deleted (empty on dry runs or when deferred)
+	Removed []iptables.ManagedRule
+
+	// Kept is the number of managed rules that belong to live pods
+	Kept int
+
+	// DeferredBy lists pods without an IP that prevented deletion
+	DeferredBy []string
+}
+
+// OrphanIPs returns the distinct pod IPs of r.Orphans in rule order
+func (r *Result) OrphanIPs() []string {
+	var ips []string
+	seen := map[string]bool{}
+	for _, rule := range r.Orphans {
+		if !seen[rule.PodIP] {
+			seen[rule.PodIP] = true
+			ips = append(ips, rule.PodIP)
+		}
+	}
+	return ips
+}
+
+// Replaced in tests to avoid exec
+var (
+	listRulesFunc        = iptables.ListManagedRules
+	deleteMarkRuleFunc   = iptables.DeleteMarkRule
+	deleteOutputRuleFunc = iptables.DeleteOutputMarkRule
+	deleteConnmarkFunc   = iptables.DeleteConnmarkRules
+)
+
+// Collect deletes managed rules whose pod IP is not in live.IPs
+// Deletion failures do not stop the run; the first one is returned with the partial result.
+func Collect(ctx context.Context, live tenant.LivePods, opts Options) (*Result, error) {
+	rules, err := listRulesFunc(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list managed rules: %w", err)
+	}
+
+	result := &Result{}
+	for _, rule := range rules {
+		if live.IPs[rule.PodIP] {
+			result.Kept++
+			continue
+		}
+		result.Orphans = append(result.Orphans, rule)
+	}
+
+	if opts.DryRun || len(result.Orphans) == 0 {
+		return result, nil
+	}
+	if len(live.Pending) > 0 {
+		result.DeferredBy = live.Pending
+		return result, nil
+	}
+
+	var firstErr error
+	for _, rule := range result.Orphans {
+		if err := deleteRule(ctx, rule); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		result.Removed = append(result.Removed, rule)
+	}
+
+	if opts.Connmark {
+		for _, ip := range result.OrphanIPs() {
+			if err := deleteConnmarkFunc(ctx, ip); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return result, firstErr
+}
+
+// deleteRule removes one managed rule through the matching iptables helper
+func deleteRule(ctx context.Context, rule iptables.ManagedRule) error {
+	if rule.Chain == "OUTPUT" && rule.Hook == "" {
+		return deleteOutputRuleFunc(ctx, rule.PodIP, rule.Fwmark)
+	}
+	return deleteMarkRuleFunc(ctx, rule.PodIP, rule.Fwmark)
+}
@@ -0,0 +1,128 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+)
+
+// fakeNode replaces the iptables hooks with an in-memory rule set
+type fakeNode struct {
+	rules     []iptables.ManagedRule
+	deleted   []string
+	connmarks []string
+	failIP    string
+}
+
+func useFakeNode(t *testing.T, node *fakeNode) {
+	t.Helper()
+	origList, origMark, origOutput, origConnmark := listRulesFunc, deleteMarkRuleFunc, deleteOutputRuleFunc, deleteConnmarkFunc
+
+	listRulesFunc = func(context.Context) ([]iptables.ManagedRule, error) { return node.rules, nil }
+	deleteMarkRuleFunc = func(_ context.Context, podIP, fwmark string) error {
+		if podIP == node.failIP {
+			return fmt.Errorf("iptables busy")
+		}
+		node.deleted = append(node.deleted, "PREROUTING "+podIP+" "+fwmark)
+		return nil
+	}
+	deleteOutputRuleFunc = func(_ context.Context, podIP, fwmark string) error {
+		node.deleted = append(node.deleted, "OUTPUT "+podIP+" "+fwmark)
+		return nil
+	}
+	deleteConnmarkFunc = func(_ context.Context, podIP string) error {
+		node.connmarks = append(node.connmarks, podIP)
+		return nil
+	}
+
+	t.Cleanup(func() {
+		listRulesFunc, deleteMarkRuleFunc, deleteOutputRuleFunc, deleteConnmarkFunc = origList, origMark, origOutput, origConnmark
+	})
+}
+
+func testRules() []iptables.ManagedRule {
+	return []iptables.ManagedRule{
+		{Chain: "PREROUTING", PodIP: "10.200.0.2", Fwmark: "0x10"},
+		{Chain: "PREROUTING", PodIP: "10.200.0.3", Fwmark: "0x20"},
+		{Chain: "OUTPUT", PodIP: "10.200.0.3", Fwmark: "0x20"},
+	}
+}
+
+// TestCollect_RemovesOrphans verifies only rules of vanished pods are deleted
+func TestCollect_RemovesOrphans(t *testing.T) {
+	node := &fakeNode{rules: testRules()}
+	useFakeNode(t, node)
+
+	result, err := Collect(context.Background(), k8s.LivePods{IPs: map[string]bool{"10.200.0.2": true}}, Options{Connmark: true})
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	if result.Kept != 1 || len(result.Orphans) != 2 || len(result.Removed) != 2 {
+		t.Errorf("Kept=%d Orphans=%d Removed=%d, want 1/2/2", result.Kept, len(result.Orphans), len(result.Removed))
+	}
+	wantDeleted := []string{"PREROUTING 10.200.0.3 0x20", "OUTPUT 10.200.0.3 0x20"}
+	if !reflect.DeepEqual(node.deleted, wantDeleted) {
+		t.Errorf("deleted = %v, want %v", node.deleted, wantDeleted)
+	}
+	if !reflect.DeepEqual(node.connmarks, []string{"10.200.0.3"}) {
+		t.Errorf("CONNMARK cleanup = %v, want [10.200.0.3]", node.connmarks)
+	}
+}
+
+// TestCollect_DryRunAndDeferred verifies nothing is deleted on dry runs or while pods await an IP
+func TestCollect_DryRunAndDeferred(t *testing.T) {
+	tests := []struct {
+		name         string
+		live         k8s.LivePods
+		opts         Options
+		wantDeferred []string
+	}{
+		{name: "dry run", live: k8s.LivePods{IPs: map[string]bool{}}, opts: Options{DryRun: true}},
+		{
+			name:         "pending pod",
+			live:         k8s.LivePods{IPs: map[string]bool{}, Pending: []string{"team-a/starting"}},
+			wantDeferred: []string{"team-a/starting"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &fakeNode{rules: testRules()}
+			useFakeNode(t, node)
+
+			result, err := Collect(context.Background(), tt.live, tt.opts)
+			if err != nil {
+				t.Fatalf("Collect() error = %v", err)
+			}
+			if len(result.Orphans) != 3 || len(node.deleted) != 0 || len(result.Removed) != 0 {
+				t.Errorf("Orphans=%d deleted=%v Removed=%v, want 3 orphans and no deletions",
+					len(result.Orphans), node.deleted, result.Removed)
+			}
+			if !reflect.DeepEqual(result.DeferredBy, tt.wantDeferred) {
+				t.Errorf("DeferredBy = %v, want %v", result.DeferredBy, tt.wantDeferred)
+			}
+		})
+	}
+}
+
+// TestCollect_PartialFailure verifies a failed deletion does not stop the run
+func TestCollect_PartialFailure(t *testing.T) {
+	node := &fakeNode{rules: testRules(), failIP: "10.200.0.2"}
+	useFakeNode(t, node)
+
+	result, err := Collect(context.Background(), k8s.LivePods{IPs: map[string]bool{}}, Options{})
+	if err == nil {
+		t.Fatal("expected deletion error")
+	}
+	if len(result.Removed) != 2 {
+		t.Errorf("Removed = %v, want the two rules of 10.200.0.3", result.Removed)
+	}
+	if got := result.OrphanIPs(); !reflect.DeepEqual(got, []string{"10.200.0.2", "10.200.0.3"}) {
+		t.Errorf("OrphanIPs() = %v", got)
+	}
+}
@@ -0,0 +1,205 @@
+package iptables
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// restoreFunc applies an iptables-restore payload; replaced in tests to avoid exec
+var restoreFunc = runRestore
+
+// ApplyRules makes the per-pod MARK rules in mangle/PREROUTING equal to rules
+// A rule with a Hook is installed in that hook's chain (see AtHook); a managed rule
+// at the wrong hook is moved.
+//
+// The difference to the current chain is rendered as a single iptables-restore
+// payload (--noflush, so rules of other agents are untouched) and applied in one
+// atomic transaction: missing rules are appended, managed rules not in rules are
+// deleted. Only rules setting an allowed fwmark are ever deleted.
+//
+// Intended for node reboot and daemon reconcile, where invoking iptables once per
+// rule is slow and racy. Validation (and source safety unless AllowUnsafeSources
+// is passed) covers every rule before anything is applied. With KeepUnlisted only
+// the missing rules are appended. IPv4 only.
+func ApplyRules(ctx context.Context, rules []MarkRule, opts ...MarkOption) error {
+	return defaultPath.applyRules(ctx, rules, opts...)
+}
+
+// KeepUnlisted makes ApplyRules append the missing rules only, leaving installed
+// rules not in rules alone
+// For callers that hold part of the rules, e.g. re-adding the rules of recorded pods.
+func KeepUnlisted() MarkOption {
+	return func(o *markOptions) {
+		o.keepUnlisted = true
+	}
+}
+
+// applyRules implements ApplyRules in the datapath's chain
+// A user-defined chain is created first: declaring it in the payload would flush it.
+func (d *datapath) applyRules(ctx context.Context, rules []MarkRule, opts ...MarkOption) error {
+	var options markOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	options.allowUnsafeSources = options.allowUnsafeSources || d.allowUnsafeSources
+
+	desired, err := d.normalize(rules, options)
+	if err != nil {
+		return err
+	}
+
+	current, err := d.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload := d.renderRestore(current, desired, options)
+	if payload == nil {
+		return nil
+	}
+
+	if !builtinChains[d.chain] {
+		mgr, err := newHandle(ctx)
+		if err != nil {
+			return err
+		}
+		if err := d.ensureChain(mgr); err != nil {
+			return err
+		}
+	}
+
+	defer mutationGeneration.Add(1)
+
+	if err := restoreFunc(ctx, payload); err != nil {
+		return fmt.Errorf("failed to apply %d-line iptables-restore payload: %w", bytes.Count(payload, []byte("\n")), err)
+	}
+	return nil
+}
+
+// normalizeRules validates rules and returns them as deduplicated entries, each with
+// the comment it is added with
+func normalizeRules(rules []MarkRule, options markOptions) (map[markEntry]string, error) {
+	return defaultPath.normalize(rules, options)
+}
+
+// normalize implements normalizeRules for the datapath's fwmarks and tag
+func (d *datapath) normalize(rules []MarkRule, options markOptions) (map[markEntry]string, error) {
+	desired := make(map[markEntry]string, len(rules))
+	for _, rule := range rules {
+		if err := validatePodIP(rule.PodIP); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(rule.PodIP)
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("batch apply supports IPv4 pod IPs only, got: %s", rule.PodIP)
+		}
+		if err := d.validateMark(rule.Fwmark); err != nil {
+			return nil, err
+		}
+		if !options.allowUnsafeSources {
+			if err := CheckSourceSafety(rule.PodIP); err != nil {
+				return nil, err
+			}
+		}
+		if rule.PodUID != "" && !podUIDPattern.MatchString(rule.PodUID) {
+			return nil, fmt.Errorf("invalid pod UID %q", rule.PodUID)
+		}
+		comment := d.ruleComment(rule.PodUID)
+		if comment != "" && !commentPattern.MatchString(comment) {
+			return nil, fmt.Errorf("rule tag %q exceeds 256 characters", comment)
+		}
+		chain, err := d.hookChain(rule.Hook)
+		if err != nil {
+			return nil, err
+		}
+		entry := markEntry{IP: ip.String(), Mark: mustParseMark(rule.Fwmark)}
+		if chain != d.chain {
+			entry.Chain = chain
+		}
+		desired[entry] = comment
+	}
+	return desired, nil
+}
+
+// renderRestore returns the iptables-restore payload turning current into desired
+// Returns nil if the chain is already in the desired state
+//
+//	*mangle
+//	-D PREROUTING -s 10.200.1.9 -j MARK --set-mark 0x20
+//	-A PREROUTING -s 10.200.1.5 -j MARK --set-mark 0x10
+//	COMMIT
+func renderRestore(current []markEntry, desired map[markEntry]string) []byte {
+	return defaultPath.renderRestore(current, desired, markOptions{})
+}
+
+// renderRestore implements renderRestore for the datapath's table and chain
+// Appended rules carry the comment desired maps them to and mark new connections only
+// with NewConnectionsOnly; KeepUnlisted deletes nothing.
+func (d *datapath) renderRestore(current []markEntry, desired map[markEntry]string, options markOptions) []byte {
+	// Installed rules are matched with or without comment; deletes must name it
+	installed := make(map[markEntry]struct{}, len(current))
+	var deletes, appends []markEntry
+	for _, entry := range current {
+		installed[entry.placement()] = struct{}{}
+		if _, ok := desired[entry.placement()]; !ok && !options.keepUnlisted && d.validateMark(formatMark(entry.Mark)) == nil {
+			deletes = append(deletes, entry)
+		}
+	}
+	for entry, comment := range desired {
+		if _, ok := installed[entry]; !ok {
+			entry.Comment = comment
+			appends = append(appends, entry)
+		}
+	}
+	if len(deletes) == 0 && len(appends) == 0 {
+		return nil
+	}
+	sortEntries(deletes)
+	sortEntries(appends)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%s\n", d.table)
+	for _, entry := range deletes {
+		fmt.Fprintf(&b, "-D %s %s\n", d.entryChain(entry), strings.Join(d.rulespec(entry.IP, formatMark(entry.Mark), entry.Comment, entry.NewOnly), " "))
+	}
+	for _, entry := range appends {
+		fmt.Fprintf(&b, "-A %s %s\n", d.entryChain(entry), strings.Join(d.rulespec(entry.IP, formatMark(entry.Mark), entry.Comment, options.newConnectionsOnly), " "))
+	}
+	b.WriteString("COMMIT\n")
+	return b.Bytes()
+}
+
+// sortEntries orders entries by IP, then mark, then chain, then comment, so payloads
+// are deterministic
+func sortEntries(entries []markEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IP != entries[j].IP {
+			return entries[i].IP < entries[j].IP
+		}
+		if entries[i].Mark != entries[j].Mark {
+			return entries[i].Mark < entries[j].Mark
+		}
+		if entries[i].Chain != entries[j].Chain {
+			return entries[i].Chain < entries[j].Chain
+		}
+		return entries[i].Comment < entries[j].Comment
+	})
+}
+
+// runRestore feeds payload to iptables-restore without flushing existing rules
+// --wait takes the xtables lock like the per-rule path does (see SetLockTimeout)
+func runRestore(ctx context.Context, payload []byte) error {
+	cmd := exec.CommandContext(ctx, "iptables-restore", append([]string{"--noflush"}, waitArgs(ctx)...)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
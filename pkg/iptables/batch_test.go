@@ -0,0 +1,246 @@
+package iptables
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// useFakeRestore captures iptables-restore payloads for the duration of a test
+func useFakeRestore(t *testing.T, err error) *[]string {
+	t.Helper()
+	var payloads []string
+	orig := restoreFunc
+	restoreFunc = func(_ context.Context, payload []byte) error {
+		payloads = append(payloads, string(payload))
+		return err
+	}
+	t.Cleanup(func() { restoreFunc = orig })
+	return &payloads
+}
+
+// TestApplyRules_RendersDiff verifies missing rules are appended and stale managed rules deleted
+func TestApplyRules_RendersDiff(t *testing.T) {
+	useFakeLister(t,
+		markEntry{IP: "10.200.1.5", Mark: 0x10}, // kept
+		markEntry{IP: "10.200.1.9", Mark: 0x20}, // stale
+		markEntry{IP: "10.0.0.1", Mark: 0xe00},  // foreign mark, never touched
+	)
+	payloads := useFakeRestore(t, nil)
+
+	err := ApplyRules(context.Background(), []MarkRule{
+		{PodIP: "10.200.1.5", Fwmark: "0x10"},
+		{PodIP: "10.200.1.6", Fwmark: "0X20"},
+		{PodIP: "10.200.1.6", Fwmark: "0x20"}, // duplicate
+	}, AllowUnsafeSources())
+	if err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+
+	want := "*mangle\n" +
+		"-D PREROUTING -s 10.200.1.9 -j MARK --set-mark 0x20\n" +
+		"-A PREROUTING -s 10.200.1.6 -j MARK --set-mark 0x20\n" +
+		"COMMIT\n"
+	if len(*payloads) != 1 || (*payloads)[0] != want {
+		t.Errorf("payloads = %q, want [%q]", *payloads, want)
+	}
+}
+
+// TestApplyRules_PodUIDTagged verifies rules tagged with a pod UID match untagged desired rules
+func TestApplyRules_PodUIDTagged(t *testing.T) {
+	useFakeLister(t,
+		markEntry{IP: "10.200.1.5", Mark: 0x10, Comment: "pod-uid:3f1c"}, // kept
+		markEntry{IP: "10.200.1.9", Mark: 0x20, Comment: "pod-uid:77ab"}, // stale
+	)
+	payloads := useFakeRestore(t, nil)
+
+	if err := ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}}, AllowUnsafeSources()); err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+	want := "*mangle\n" +
+		"-D PREROUTING -s 10.200.1.9 -m comment --comment pod-uid:77ab -j MARK --set-mark 0x20\n" +
+		"COMMIT\n"
+	if len(*payloads) != 1 || (*payloads)[0] != want {
+		t.Errorf("payloads = %q, want [%q]", *payloads, want)
+	}
+}
+
+// TestApplyRules_NewConnectionsOnly verifies rules are appended with the conntrack match
+// and rules listed with it are deleted with it
+func TestApplyRules_NewConnectionsOnly(t *testing.T) {
+	useFakeLister(t,
+		markEntry{IP: "10.200.1.5", Mark: 0x10},                // kept, whatever the option
+		markEntry{IP: "10.200.1.9", Mark: 0x20, NewOnly: true}, // stale
+	)
+	payloads := useFakeRestore(t, nil)
+
+	err := ApplyRules(context.Background(), []MarkRule{
+		{PodIP: "10.200.1.5", Fwmark: "0x10"},
+		{PodIP: "10.200.1.6", Fwmark: "0x20"},
+	}, AllowUnsafeSources(), NewConnectionsOnly())
+	if err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+	want := "*mangle\n" +
+		"-D PREROUTING -s 10.200.1.9 -m conntrack --ctstate NEW -j MARK --set-mark 0x20\n" +
+		"-A PREROUTING -s 10.200.1.6 -m conntrack --ctstate NEW -j MARK --set-mark 0x20\n" +
+		"COMMIT\n"
+	if len(*payloads) != 1 || (*payloads)[0] != want {
+		t.Errorf("payloads = %q, want [%q]", *payloads, want)
+	}
+}
+
+// TestApplyRules_Hooks verifies rules are appended in their hook's chain and managed
+// rules at the wrong hook are moved
+func TestApplyRules_Hooks(t *testing.T) {
+	useFakeLister(t,
+		markEntry{IP: "10.200.1.5", Mark: 0x10},                   // moved to POSTROUTING
+		markEntry{IP: "10.200.1.6", Mark: 0x20, Chain: "OUTPUT"},  // kept
+		markEntry{IP: "10.200.1.9", Mark: 0x20, Chain: "FORWARD"}, // stale
+	)
+	payloads := useFakeRestore(t, nil)
+
+	err := ApplyRules(context.Background(), []MarkRule{
+		{PodIP: "10.200.1.5", Fwmark: "0x10", Hook: HookPostrouting},
+		{PodIP: "10.200.1.6", Fwmark: "0x20", Hook: HookOutput},
+		{PodIP: "10.200.1.7", Fwmark: "0x20", Hook: HookPrerouting},
+	}, AllowUnsafeSources())
+	if err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+	want := "*mangle\n" +
+		"-D PREROUTING -s 10.200.1.5 -j MARK --set-mark 0x10\n" +
+		"-D FORWARD -s 10.200.1.9 -j MARK --set-mark 0x20\n" +
+		"-A POSTROUTING -s 10.200.1.5 -j MARK --set-mark 0x10\n" +
+		"-A PREROUTING -s 10.200.1.7 -j MARK --set-mark 0x20\n" +
+		"COMMIT\n"
+	if len(*payloads) != 1 || (*payloads)[0] != want {
+		t.Errorf("payloads = %q, want [%q]", *payloads, want)
+	}
+
+	if err := ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10", Hook: "input"}},
+		AllowUnsafeSources()); err == nil {
+		t.Error("ApplyRules() accepted an invalid hook")
+	}
+}
+
+// TestApplyRules_KeepUnlisted verifies only missing rules are appended, with their pod UID
+func TestApplyRules_KeepUnlisted(t *testing.T) {
+	useFakeLister(t,
+		markEntry{IP: "10.200.1.5", Mark: 0x10}, // listed, kept
+		markEntry{IP: "10.200.1.9", Mark: 0x20}, // unlisted, kept too
+	)
+	payloads := useFakeRestore(t, nil)
+
+	err := ApplyRules(context.Background(), []MarkRule{
+		{PodIP: "10.200.1.5", Fwmark: "0x10"},
+		{PodIP: "10.200.1.6", Fwmark: "0x20", PodUID: "3f1c"},
+	}, AllowUnsafeSources(), KeepUnlisted())
+	if err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+	want := "*mangle\n" +
+		"-A PREROUTING -s 10.200.1.6 -m comment --comment pod-uid:3f1c -j MARK --set-mark 0x20\n" +
+		"COMMIT\n"
+	if len(*payloads) != 1 || (*payloads)[0] != want {
+		t.Errorf("payloads = %q, want [%q]", *payloads, want)
+	}
+
+	if err := ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.6", Fwmark: "0x20", PodUID: "uid with spaces"}},
+		AllowUnsafeSources(), KeepUnlisted()); err == nil {
+		t.Error("ApplyRules() accepted an invalid pod UID")
+	}
+}
+
+// TestApplyRules_NoChange verifies nothing is executed when the chain is in sync
+func TestApplyRules_NoChange(t *testing.T) {
+	useFakeLister(t, markEntry{IP: "10.200.1.5", Mark: 0x10})
+	payloads := useFakeRestore(t, nil)
+
+	if err := ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}}, AllowUnsafeSources()); err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+	if len(*payloads) != 0 {
+		t.Errorf("expected no restore, got %q", *payloads)
+	}
+}
+
+// TestApplyRules_ValidationIsAllOrNothing verifies one bad rule prevents any change
+func TestApplyRules_ValidationIsAllOrNothing(t *testing.T) {
+	useFakeLister(t)
+	payloads := useFakeRestore(t, nil)
+
+	tests := []struct {
+		name   string
+		rule   MarkRule
+		errMsg string
+	}{
+		{name: "bad fwmark", rule: MarkRule{PodIP: "10.200.1.6", Fwmark: "0x99"}, errMsg: "invalid fwmark"},
+		{name: "bad IP", rule: MarkRule{PodIP: "10.200.1.6; -F", Fwmark: "0x10"}, errMsg: "invalid IP address format"},
+		{name: "ipv6", rule: MarkRule{PodIP: "fd00::6", Fwmark: "0x10"}, errMsg: "IPv4 pod IPs only"},
+		{name: "unsafe source", rule: MarkRule{PodIP: "127.0.0.1", Fwmark: "0x10"}, errMsg: "loopback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}, tt.rule})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want containing %q", err, tt.errMsg)
+			}
+		})
+	}
+	if len(*payloads) != 0 {
+		t.Errorf("restore executed despite validation errors: %q", *payloads)
+	}
+}
+
+// TestApplyRules_RestoreError verifies restore failures are surfaced and invalidate caches
+func TestApplyRules_RestoreError(t *testing.T) {
+	useFakeLister(t)
+	useFakeRestore(t, errors.New("iptables-restore: line 2 failed"))
+	before := mutationGeneration.Load()
+
+	err := ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}}, AllowUnsafeSources())
+	if err == nil || !strings.Contains(err.Error(), "line 2 failed") {
+		t.Errorf("error = %v, want restore error", err)
+	}
+	if mutationGeneration.Load() == before {
+		t.Error("mutation generation not bumped after restore attempt")
+	}
+}
+
+// TestFakeManager_ApplyRules verifies the fake replaces its rule set
+func TestFakeManager_ApplyRules(t *testing.T) {
+	mgr := NewFakeManager()
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.9", "0x20"); err != nil {
+		t.Fatalf("AddMarkRule() error = %v", err)
+	}
+
+	if err := mgr.ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}}); err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+	rules, _ := mgr.List(context.Background())
+	if len(rules) != 1 || rules[0] != (MarkRule{PodIP: "10.200.1.5", Fwmark: "0x10"}) {
+		t.Errorf("List() = %v, want only 10.200.1.5/0x10", rules)
+	}
+}
+
+// TestFakeManager_ApplyRulesKeepUnlisted verifies the fake adds to its rule set with KeepUnlisted
+func TestFakeManager_ApplyRulesKeepUnlisted(t *testing.T) {
+	mgr := NewFakeManager()
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.9", "0x20"); err != nil {
+		t.Fatalf("AddMarkRule() error = %v", err)
+	}
+
+	rules := []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10", PodUID: "3f1c"}}
+	if err := mgr.ApplyRules(context.Background(), rules, KeepUnlisted()); err != nil {
+		t.Fatalf("ApplyRules() error = %v", err)
+	}
+	if got, _ := mgr.List(context.Background()); len(got) != 2 {
+		t.Errorf("List() = %v, want both rules", got)
+	}
+	if got := mgr.RulePodUID("10.200.1.5", "0x10"); got != "3f1c" {
+		t.Errorf("RulePodUID() = %q, want 3f1c", got)
+	}
+}
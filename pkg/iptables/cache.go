@@ -0,0 +1,253 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCacheTTL bounds how long a snapshot is trusted before it is re-read
+// Short enough that external changes (iptables-restore, firewalld) are noticed quickly
+const DefaultCacheTTL = 10 * time.Second
+
+// mutationGeneration is bumped by every rule mutation made through this package
+// Caches compare it against their snapshot generation to detect stale data
+var mutationGeneration atomic.Uint64
+
+// markEntry is a MARK rule parsed from the managed chain
+type markEntry struct {
+	IP   string
+	Mark uint64
+
+	// Comment is the rule's --comment ("" if none); not part of the rule's identity,
+	// use key() when comparing entries
+	Comment string
+
+	// NewOnly is set for a rule that marks new connections only (see NewConnectionsOnly);
+	// not part of the rule's identity either
+	NewOnly bool
+
+	// Chain is the mangle chain of a rule installed at a hook (see AtHook); "" for the
+	// datapath's own chain. Not part of the rule's identity either
+	Chain string
+}
+
+// key returns the entry without its comment
+func (e markEntry) key() markEntry {
+	return markEntry{IP: e.IP, Mark: e.Mark}
+}
+
+// placement returns the entry's key and chain, which batch apply reconciles
+func (e markEntry) placement() markEntry {
+	return markEntry{IP: e.IP, Mark: e.Mark, Chain: e.Chain}
+}
+
+// listMarkRulesFunc lists the managed chain; replaced in tests to avoid exec
+var listMarkRulesFunc = listMarkRules
+
+// RuleCache is a read-through snapshot of the MARK rules in mangle/PREROUTING
+//
+// Read-only callers (CHECK, GC) query the snapshot instead of initializing iptables
+// and executing one check per rule. The snapshot is refreshed when:
+//   - it is older than the TTL
+//   - any rule was added or deleted through this package since it was taken
+//   - a lookup misses (the chain is re-read once before reporting absence,
+//     so an external change never produces a false drift report)
+//
+// Safe for concurrent use.
+type RuleCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	rules      map[markEntry]struct{}
+	loadedAt   time.Time
+	generation uint64
+	valid      bool
+	now        func() time.Time
+}
+
+// NewRuleCache creates an empty cache; ttl <= 0 selects DefaultCacheTTL
+func NewRuleCache(ttl time.Duration) *RuleCache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &RuleCache{ttl: ttl, now: time.Now}
+}
+
+// Invalidate drops the current snapshot; the next query re-reads the chain
+func (c *RuleCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.valid = false
+}
+
+// RuleExists reports whether a MARK rule for podIP with fwmark is installed
+// Same contract as the package-level RuleExists, answered from the snapshot
+func (c *RuleCache) RuleExists(ctx context.Context, podIP, fwmark string) (bool, error) {
+	if strings.TrimSpace(podIP) == "" {
+		return false, fmt.Errorf("podIP cannot be empty")
+	}
+	ip := net.ParseIP(podIP)
+	if ip == nil {
+		return false, fmt.Errorf("invalid IP address format: %s", podIP)
+	}
+	if err := validateFwmark(fwmark); err != nil {
+		return false, err
+	}
+
+	key := markEntry{IP: ip.String(), Mark: mustParseMark(fwmark)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refreshed := false
+	if c.stale() {
+		if err := c.refresh(ctx); err != nil {
+			return false, err
+		}
+		refreshed = true
+	}
+
+	if _, ok := c.rules[key]; ok {
+		return true, nil
+	}
+
+	// Miss on a snapshot we did not just load: confirm before reporting drift
+	if !refreshed {
+		if err := c.refresh(ctx); err != nil {
+			return false, err
+		}
+		_, ok := c.rules[key]
+		return ok, nil
+	}
+
+	return false, nil
+}
+
+// CountMarkRules returns the number of cached rules setting fwmark
+func (c *RuleCache) CountMarkRules(ctx context.Context, fwmark string) (int, error) {
+	if err := validateFwmark(fwmark); err != nil {
+		return 0, err
+	}
+	mark := mustParseMark(fwmark)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stale() {
+		if err := c.refresh(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for entry := range c.rules {
+		if entry.Mark == mark {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// stale reports whether the snapshot must be re-read (caller holds c.mu)
+func (c *RuleCache) stale() bool {
+	return !c.valid ||
+		c.generation != mutationGeneration.Load() ||
+		c.now().Sub(c.loadedAt) >= c.ttl
+}
+
+// refresh re-reads the managed chain (caller holds c.mu)
+func (c *RuleCache) refresh(ctx context.Context) error {
+	generation := mutationGeneration.Load()
+
+	entries, err := listMarkRulesFunc(ctx)
+	if err != nil {
+		c.valid = false
+		return err
+	}
+
+	rules := make(map[markEntry]struct{}, len(entries))
+	for _, entry := range entries {
+		rules[entry.key()] = struct{}{}
+	}
+
+	c.rules = rules
+	c.generation = generation
+	c.loadedAt = c.now()
+	c.valid = true
+	return nil
+}
+
+// listMarkRules reads mangle/PREROUTING and the other hook chains (see AtHook) and
+// returns all single-source MARK rules
+func listMarkRules(ctx context.Context) ([]markEntry, error) {
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []markEntry
+	for _, chain := range markChains {
+		rules, err := mgr.ipt.List(tableNameMangle, chain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s/%s rules: %w", tableNameMangle, chain, err)
+		}
+		for _, rule := range rules {
+			if entry, ok := parseMarkEntry(rule); ok {
+				if chain != chainPrerouting {
+					entry.Chain = chain
+				}
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// parseMarkEntry parses "-A PREROUTING -s 10.200.1.5/32 -j MARK --set-xmark 0x10/0xffffffff"
+func parseMarkEntry(rule string) (markEntry, bool) {
+	mark, ok := parseMarkTarget(rule)
+	if !ok {
+		return markEntry{}, false
+	}
+
+	fields := strings.Fields(rule)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] != "-s" {
+			continue
+		}
+		source := fields[i+1]
+		// iptables lists host sources as /32; anything wider is not a per-pod rule
+		if ip, ipnet, err := net.ParseCIDR(source); err == nil {
+			if ones, bits := ipnet.Mask.Size(); ones != bits {
+				return markEntry{}, false
+			}
+			return markEntry{IP: ip.String(), Mark: mark, Comment: ruleComment(rule), NewOnly: isNewOnly(rule)}, true
+		}
+		if ip := net.ParseIP(source); ip != nil {
+			return markEntry{IP: ip.String(), Mark: mark, Comment: ruleComment(rule), NewOnly: isNewOnly(rule)}, true
+		}
+		return markEntry{}, false
+	}
+	return markEntry{}, false
+}
+
+// ruleComment returns the --comment value of an iptables-save style rule ("" if none)
+func ruleComment(rule string) string {
+	fields := splitQuoted(rule)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "--comment" {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// mustParseMark parses a fwmark that already passed validateFwmark
+func mustParseMark(fwmark string) uint64 {
+	mark, _ := parseMark(fwmark)
+	return mark
+}
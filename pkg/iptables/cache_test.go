@@ -0,0 +1,198 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeLister serves canned rules and counts how often the chain is read
+type fakeLister struct {
+	entries []markEntry
+	err     error
+	calls   int
+}
+
+func (f *fakeLister) list(context.Context) ([]markEntry, error) {
+	f.calls++
+	return f.entries, f.err
+}
+
+// useFakeLister swaps listMarkRulesFunc for the duration of a test
+func useFakeLister(t *testing.T, entries ...markEntry) *fakeLister {
+	t.Helper()
+	fake := &fakeLister{entries: entries}
+	orig := listMarkRulesFunc
+	listMarkRulesFunc = fake.list
+	t.Cleanup(func() { listMarkRulesFunc = orig })
+	return fake
+}
+
+// TestRuleCache_HitDoesNotRelist verifies repeated lookups are served from the snapshot
+func TestRuleCache_HitDoesNotRelist(t *testing.T) {
+	fake := useFakeLister(t, markEntry{IP: "10.200.1.5", Mark: 0x10})
+	cache := NewRuleCache(time.Minute)
+
+	for i := 0; i < 5; i++ {
+		exists, err := cache.RuleExists(context.Background(), "10.200.1.5", "0x10")
+		if err != nil {
+			t.Fatalf("RuleExists() error: %v", err)
+		}
+		if !exists {
+			t.Fatal("expected rule to exist")
+		}
+	}
+
+	if fake.calls != 1 {
+		t.Errorf("chain listed %d times, want 1", fake.calls)
+	}
+}
+
+// TestRuleCache_MissRevalidates verifies a miss re-reads the chain before reporting absence
+func TestRuleCache_MissRevalidates(t *testing.T) {
+	fake := useFakeLister(t)
+	cache := NewRuleCache(time.Minute)
+
+	// Prime an empty snapshot
+	if exists, _ := cache.RuleExists(context.Background(), "10.200.1.5", "0x10"); exists {
+		t.Fatal("expected rule to be absent")
+	}
+
+	// Rule installed externally (e.g. by another CNI invocation)
+	fake.entries = []markEntry{{IP: "10.200.1.5", Mark: 0x10}}
+
+	exists, err := cache.RuleExists(context.Background(), "10.200.1.5", "0x10")
+	if err != nil {
+		t.Fatalf("RuleExists() error: %v", err)
+	}
+	if !exists {
+		t.Error("expected miss to revalidate and find externally added rule")
+	}
+}
+
+// TestRuleCache_ExpiresAfterTTL verifies external deletions are noticed after the TTL
+func TestRuleCache_ExpiresAfterTTL(t *testing.T) {
+	fake := useFakeLister(t, markEntry{IP: "10.200.1.5", Mark: 0x10})
+	cache := NewRuleCache(10 * time.Second)
+	now := time.Unix(1000, 0)
+	cache.now = func() time.Time { return now }
+
+	if exists, _ := cache.RuleExists(context.Background(), "10.200.1.5", "0x10"); !exists {
+		t.Fatal("expected rule to exist")
+	}
+
+	// External wipe (iptables-restore, firewalld reload)
+	fake.entries = nil
+
+	now = now.Add(5 * time.Second)
+	if exists, _ := cache.RuleExists(context.Background(), "10.200.1.5", "0x10"); !exists {
+		t.Error("expected cached positive answer within TTL")
+	}
+
+	now = now.Add(10 * time.Second)
+	if exists, _ := cache.RuleExists(context.Background(), "10.200.1.5", "0x10"); exists {
+		t.Error("expected expired snapshot to be re-read")
+	}
+}
+
+// TestRuleCache_InvalidatedByMutation verifies package mutations invalidate snapshots
+func TestRuleCache_InvalidatedByMutation(t *testing.T) {
+	fake := useFakeLister(t, markEntry{IP: "10.200.1.5", Mark: 0x10})
+	cache := NewRuleCache(time.Minute)
+
+	if _, err := cache.CountMarkRules(context.Background(), "0x10"); err != nil {
+		t.Fatalf("CountMarkRules() error: %v", err)
+	}
+	mutationGeneration.Add(1)
+	if _, err := cache.CountMarkRules(context.Background(), "0x10"); err != nil {
+		t.Fatalf("CountMarkRules() error: %v", err)
+	}
+
+	if fake.calls != 2 {
+		t.Errorf("chain listed %d times, want 2 (mutation must invalidate)", fake.calls)
+	}
+
+	cache.Invalidate()
+	if _, err := cache.CountMarkRules(context.Background(), "0x10"); err != nil {
+		t.Fatalf("CountMarkRules() error: %v", err)
+	}
+	if fake.calls != 3 {
+		t.Errorf("chain listed %d times, want 3 (Invalidate must drop snapshot)", fake.calls)
+	}
+}
+
+// TestRuleCache_ListError verifies list failures are surfaced and not cached
+func TestRuleCache_ListError(t *testing.T) {
+	fake := useFakeLister(t)
+	fake.err = fmt.Errorf("xtables lock held")
+	cache := NewRuleCache(time.Minute)
+
+	if _, err := cache.RuleExists(context.Background(), "10.200.1.5", "0x10"); err == nil {
+		t.Fatal("expected list error to be returned")
+	}
+
+	fake.err = nil
+	fake.entries = []markEntry{{IP: "10.200.1.5", Mark: 0x10}}
+	exists, err := cache.RuleExists(context.Background(), "10.200.1.5", "0x10")
+	if err != nil || !exists {
+		t.Errorf("RuleExists() = %v, %v; want true, nil after recovery", exists, err)
+	}
+}
+
+// TestCountMarkRules_Counts verifies per-fwmark counting across tenants
+func TestCountMarkRules_Counts(t *testing.T) {
+	useFakeLister(t,
+		markEntry{IP: "10.200.1.5", Mark: 0x10},
+		markEntry{IP: "10.200.1.6", Mark: 0x10},
+		markEntry{IP: "10.200.1.7", Mark: 0x20},
+	)
+
+	count, err := CountMarkRules(context.Background(), "0X10")
+	if err != nil {
+		t.Fatalf("CountMarkRules() error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("CountMarkRules(0x10) = %d, want 2", count)
+	}
+}
+
+// TestParseMarkEntry tests parsing of source and mark from listed rules
+func TestParseMarkEntry(t *testing.T) {
+	tests := []struct {
+		rule   string
+		want   markEntry
+		wantOK bool
+	}{
+		{
+			rule:   "-A PREROUTING -s 10.200.1.5/32 -j MARK --set-xmark 0x10/0xffffffff",
+			want:   markEntry{IP: "10.200.1.5", Mark: 0x10},
+			wantOK: true,
+		},
+		{
+			rule:   `-A PREROUTING -s 10.200.1.5/32 -m comment --comment "pod-uid:3f1c" -j MARK --set-xmark 0x10/0xffffffff`,
+			want:   markEntry{IP: "10.200.1.5", Mark: 0x10, Comment: "pod-uid:3f1c"},
+			wantOK: true,
+		},
+		{
+			rule:   "-A PREROUTING -s 10.200.1.5/32 -m conntrack --ctstate NEW -j MARK --set-xmark 0x10/0xffffffff",
+			want:   markEntry{IP: "10.200.1.5", Mark: 0x10, NewOnly: true},
+			wantOK: true,
+		},
+		{
+			rule:   "-A PREROUTING -s 10.200.0.0/16 -j MARK --set-xmark 0x10/0xffffffff",
+			wantOK: false,
+		},
+		{
+			rule:   "-A PREROUTING -j MARK --set-xmark 0x10/0xffffffff",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseMarkEntry(tt.rule)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("parseMarkEntry(%q) = %+v, %v; want %+v, %v", tt.rule, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
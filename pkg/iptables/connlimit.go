@@ -0,0 +1,151 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ConnLimitChain holds the per-tenant connection limit rules; filter/FORWARD jumps to it
+const ConnLimitChain = "TENANT-ROUTING-CONNLIMIT"
+
+// ConnLimitRule rejects new forwarded connections of a tenant above MaxConnections
+//
+//	-A TENANT-ROUTING-CONNLIMIT -m mark --mark <fwmark>/0xff -m conntrack --ctstate NEW
+//	   -m connlimit --connlimit-above <max> --connlimit-mask 0 -j REJECT
+//
+// With --connlimit-mask 0 every source shares one counter, so the limit applies to all
+// of the tenant's pods on the node together. Only the tenant mark bits are compared.
+type ConnLimitRule struct {
+	// Fwmark is the tenant whose connections are counted ("0x10")
+	Fwmark string
+
+	// MaxConnections is the number of concurrent connections the tenant may hold
+	MaxConnections int
+}
+
+// String renders the rule in iptables(8) append syntax
+func (r ConnLimitRule) String() string {
+	return Rule{Table: tableNameFilter, Chain: ConnLimitChain, Rulespec: r.rulespec()}.String()
+}
+
+// rulespec returns the REJECT rule for r
+func (r ConnLimitRule) rulespec() []string {
+	return []string{
+		"-m", "mark", "--mark", r.Fwmark + "/" + connmarkMask,
+		"-m", "conntrack", "--ctstate", "NEW",
+		"-m", "connlimit", "--connlimit-above", strconv.Itoa(r.MaxConnections), "--connlimit-mask", "0",
+		"-j", "REJECT",
+	}
+}
+
+// validate checks the fwmark and the limit
+func (r ConnLimitRule) validate() error {
+	if err := validateFwmark(r.Fwmark); err != nil {
+		return err
+	}
+	if r.MaxConnections <= 0 {
+		return fmt.Errorf("invalid connection limit %d for fwmark %s: must be positive", r.MaxConnections, r.Fwmark)
+	}
+	return nil
+}
+
+// EnsureConnLimits makes ConnLimitChain contain exactly rules and hooks it into filter/FORWARD
+// Same lifecycle as EnsureEnforcement: limits removed from the configuration are
+// deleted, an empty set leaves an empty chain. Idempotent.
+func EnsureConnLimits(ctx context.Context, rules []ConnLimitRule) error {
+	rulespecs := make([][]string, len(rules))
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		rulespecs[i] = rule.rulespec()
+	}
+	return syncForwardChain(ctx, ConnLimitChain, rulespecs, func(lines []string) [][]string {
+		return staleConnLimitRules(lines, rules)
+	})
+}
+
+// MissingConnLimits returns the rules of the set that are not installed (CHECK)
+func MissingConnLimits(ctx context.Context, rules []ConnLimitRule) ([]ConnLimitRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rulespecs := make([][]string, len(rules))
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		rulespecs[i] = rule.rulespec()
+	}
+	missingIdx, err := missingForwardRules(ctx, ConnLimitChain, rulespecs)
+	if err != nil {
+		return nil, err
+	}
+	var missing []ConnLimitRule
+	for _, i := range missingIdx {
+		missing = append(missing, rules[i])
+	}
+	return missing, nil
+}
+
+// staleConnLimitRules returns the rulespecs of chain lines (iptables-save syntax) that
+// are not in want; every rule of the chain is ours, so unparsable ones are stale too
+func staleConnLimitRules(lines []string, want []ConnLimitRule) [][]string {
+	keep := map[ConnLimitRule]bool{}
+	for _, rule := range want {
+		keep[rule] = true
+	}
+
+	var stale [][]string
+	for _, line := range lines {
+		fields := splitQuoted(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != ConnLimitChain {
+			continue // -N line
+		}
+		if rule, ok := parseConnLimitRule(fields[2:]); ok && keep[rule] {
+			continue
+		}
+		stale = append(stale, fields[2:])
+	}
+	return stale
+}
+
+// parseConnLimitRule parses the rulespec of an installed connection limit rule
+//
+//	-m mark --mark 0x10/0xff -m conntrack --ctstate NEW -m connlimit --connlimit-above 500
+//	   --connlimit-mask 0 --connlimit-saddr -j REJECT --reject-with icmp-port-unreachable
+func parseConnLimitRule(rulespec []string) (ConnLimitRule, bool) {
+	var rule ConnLimitRule
+	sharedCounter := false
+	for i := 0; i < len(rulespec)-1; i++ {
+		switch rulespec[i] {
+		case "--mark":
+			mark, mask, ok := parseMarkValue(rulespec[i+1])
+			if !ok || mask != 0xff {
+				return ConnLimitRule{}, false
+			}
+			rule.Fwmark = formatMark(mark)
+		case "--connlimit-above":
+			limit, err := strconv.Atoi(rulespec[i+1])
+			if err != nil {
+				return ConnLimitRule{}, false
+			}
+			rule.MaxConnections = limit
+		case "--connlimit-mask":
+			sharedCounter = rulespec[i+1] == "0"
+		}
+	}
+	if !sharedCounter || rule.validate() != nil {
+		return ConnLimitRule{}, false
+	}
+	return rule, true
+}
+
+// SortConnLimitRules orders rules by fwmark (stable chain layout)
+func SortConnLimitRules(rules []ConnLimitRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Fwmark < rules[j].Fwmark
+	})
+}
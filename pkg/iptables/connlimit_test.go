@@ -0,0 +1,58 @@
+package iptables
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestConnLimitRule_String verifies all tenant sources share one connlimit counter
+func TestConnLimitRule_String(t *testing.T) {
+	rule := ConnLimitRule{Fwmark: "0x10", MaxConnections: 500}
+	want := "-t filter -A TENANT-ROUTING-CONNLIMIT -m mark --mark 0x10/0xff -m conntrack --ctstate NEW " +
+		"-m connlimit --connlimit-above 500 --connlimit-mask 0 -j REJECT"
+	if got := rule.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// TestStaleConnLimitRules verifies changed limits and foreign rules are deleted
+func TestStaleConnLimitRules(t *testing.T) {
+	lines := []string{
+		"-N TENANT-ROUTING-CONNLIMIT",
+		"-A TENANT-ROUTING-CONNLIMIT -m mark --mark 0x10/0xff -m conntrack --ctstate NEW -m connlimit --connlimit-above 500 --connlimit-mask 0 --connlimit-saddr -j REJECT --reject-with icmp-port-unreachable",
+		"-A TENANT-ROUTING-CONNLIMIT -m mark --mark 0x20/0xff -m conntrack --ctstate NEW -m connlimit --connlimit-above 100 --connlimit-mask 0 --connlimit-saddr -j REJECT --reject-with icmp-port-unreachable",
+		"-A TENANT-ROUTING-CONNLIMIT -m mark --mark 0x30/0xff -m connlimit --connlimit-above 10 --connlimit-mask 32 --connlimit-saddr -j REJECT",
+	}
+	want := []ConnLimitRule{
+		{Fwmark: "0x10", MaxConnections: 500},
+		{Fwmark: "0x20", MaxConnections: 200},
+	}
+
+	got := staleConnLimitRules(lines, want)
+	expected := [][]string{
+		{"-m", "mark", "--mark", "0x20/0xff", "-m", "conntrack", "--ctstate", "NEW", "-m", "connlimit",
+			"--connlimit-above", "100", "--connlimit-mask", "0", "--connlimit-saddr", "-j", "REJECT",
+			"--reject-with", "icmp-port-unreachable"},
+		{"-m", "mark", "--mark", "0x30/0xff", "-m", "connlimit", "--connlimit-above", "10",
+			"--connlimit-mask", "32", "--connlimit-saddr", "-j", "REJECT"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("staleConnLimitRules() = %v, want %v", got, expected)
+	}
+}
+
+// TestConnLimits_Validation verifies invalid rules are rejected before iptables initialization
+func TestConnLimits_Validation(t *testing.T) {
+	for _, invalid := range []ConnLimitRule{{Fwmark: "0x10", MaxConnections: 0}, {Fwmark: "0x30", MaxConnections: 10}} {
+		if err := EnsureConnLimits(context.Background(), []ConnLimitRule{invalid}); err == nil {
+			t.Errorf("EnsureConnLimits(%v) expected validation error", invalid)
+		}
+		if _, err := MissingConnLimits(context.Background(), []ConnLimitRule{invalid}); err == nil {
+			t.Errorf("MissingConnLimits(%v) expected validation error", invalid)
+		}
+	}
+	if missing, err := MissingConnLimits(context.Background(), nil); err != nil || missing != nil {
+		t.Errorf("MissingConnLimits(nil) = %v, %v; want nothing", missing, err)
+	}
+}
@@ -0,0 +1,161 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	chainOutput = "OUTPUT"
+
+	// connmarkMask limits CONNMARK save/restore to the tenant mark bits
+	// Cilium owns bits in 0x0f00; they must never be copied into or out of conntrack by us
+	connmarkMask = "0xff"
+)
+
+// connmarkRule is one CONNMARK rule installed per pod
+type connmarkRule struct {
+	chain    string
+	rulespec []string
+}
+
+// connmarkRules returns the CONNMARK rules that keep a pod's tenant mark for the connection lifetime
+//
+//	PREROUTING -s podIP -j CONNMARK --save-mark     (after MARK: remember the tenant mark on the flow)
+//	PREROUTING -d podIP -j CONNMARK --restore-mark  (replies entering the node toward the pod)
+//	OUTPUT     -d podIP -j CONNMARK --restore-mark  (host-originated packets of the same flow)
+//
+// With newOnly (MARK rule added with NewConnectionsOnly) the mark is saved for new
+// connections only, and the pod's packets of established ones get the saved mark back:
+//
+//	PREROUTING -s podIP -m conntrack --ctstate NEW -j CONNMARK --save-mark
+//	PREROUTING -s podIP -m conntrack --ctstate ESTABLISHED,RELATED -j CONNMARK --restore-mark
+func connmarkRules(podIP string, newOnly bool) []connmarkRule {
+	if !newOnly {
+		return []connmarkRule{
+			{chain: chainPrerouting, rulespec: []string{"-s", podIP, "-j", "CONNMARK", "--save-mark", "--mask", connmarkMask}},
+			{chain: chainPrerouting, rulespec: []string{"-d", podIP, "-j", "CONNMARK", "--restore-mark", "--mask", connmarkMask}},
+			{chain: chainOutput, rulespec: []string{"-d", podIP, "-j", "CONNMARK", "--restore-mark", "--mask", connmarkMask}},
+		}
+	}
+	save := append([]string{"-s", podIP}, ctstateNew...)
+	return []connmarkRule{
+		{chain: chainPrerouting, rulespec: append(save, "-j", "CONNMARK", "--save-mark", "--mask", connmarkMask)},
+		{chain: chainPrerouting, rulespec: []string{"-s", podIP, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "CONNMARK", "--restore-mark", "--mask", connmarkMask}},
+		{chain: chainPrerouting, rulespec: []string{"-d", podIP, "-j", "CONNMARK", "--restore-mark", "--mask", connmarkMask}},
+		{chain: chainOutput, rulespec: []string{"-d", podIP, "-j", "CONNMARK", "--restore-mark", "--mask", connmarkMask}},
+	}
+}
+
+// validatePodIP performs the shared pod IP validation (before iptables initialization)
+func validatePodIP(podIP string) error {
+	if strings.TrimSpace(podIP) == "" {
+		return fmt.Errorf("podIP cannot be empty")
+	}
+	if net.ParseIP(podIP) == nil {
+		return fmt.Errorf("invalid IP address format: %s", podIP)
+	}
+	return nil
+}
+
+// AddConnmarkRules installs CONNMARK save/restore rules for podIP
+// Must be called after AddMarkRule so --save-mark sees the tenant mark
+// Idempotent: succeeds if rules already exist
+func AddConnmarkRules(ctx context.Context, podIP string) error {
+	return addConnmarkRules(ctx, podIP, false)
+}
+
+// AddNewConnectionConnmarkRules installs the CONNMARK rules for a pod whose MARK rule
+// was added with NewConnectionsOnly: the mark is saved for new connections and
+// restored for the pod's packets of established ones, which keep their path when
+// the pod's mark changes
+// Must be called after AddMarkRule; idempotent. DeleteConnmarkRules removes them.
+func AddNewConnectionConnmarkRules(ctx context.Context, podIP string) error {
+	return addConnmarkRules(ctx, podIP, true)
+}
+
+// addConnmarkRules installs the CONNMARK rules of connmarkRules(podIP, newOnly)
+func addConnmarkRules(ctx context.Context, podIP string, newOnly bool) error {
+	if err := validatePodIP(podIP); err != nil {
+		return err
+	}
+
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer mutationGeneration.Add(1)
+
+	for _, rule := range connmarkRules(podIP, newOnly) {
+		if err := mgr.ipt.AppendUnique(tableNameMangle, rule.chain, rule.rulespec...); err != nil {
+			return fmt.Errorf("failed to add CONNMARK rule in %s for podIP %s: %w", rule.chain, podIP, err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteConnmarkRules removes CONNMARK save/restore rules for podIP, including those of
+// AddNewConnectionConnmarkRules
+// Idempotent: succeeds even if rules do not exist; attempts every rule before returning an error
+func DeleteConnmarkRules(ctx context.Context, podIP string) error {
+	if err := validatePodIP(podIP); err != nil {
+		return err
+	}
+
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer mutationGeneration.Add(1)
+
+	// Both rule sets: the option may have changed since the rules were added
+	// (the shared -d rules are deleted twice, the second time a no-op)
+	var firstErr error
+	for _, rule := range append(connmarkRules(podIP, false), connmarkRules(podIP, true)...) {
+		if err := mgr.ipt.DeleteIfExists(tableNameMangle, rule.chain, rule.rulespec...); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to delete CONNMARK rule in %s for podIP %s: %w", rule.chain, podIP, err)
+		}
+	}
+
+	return firstErr
+}
+
+// ConnmarkRulesExist reports whether all CONNMARK rules for podIP are installed
+func ConnmarkRulesExist(ctx context.Context, podIP string) (bool, error) {
+	return connmarkRulesExist(ctx, podIP, false)
+}
+
+// NewConnectionConnmarkRulesExist reports whether all CONNMARK rules of
+// AddNewConnectionConnmarkRules for podIP are installed
+func NewConnectionConnmarkRulesExist(ctx context.Context, podIP string) (bool, error) {
+	return connmarkRulesExist(ctx, podIP, true)
+}
+
+// connmarkRulesExist reports whether all rules of connmarkRules(podIP, newOnly) are installed
+func connmarkRulesExist(ctx context.Context, podIP string, newOnly bool) (bool, error) {
+	if err := validatePodIP(podIP); err != nil {
+		return false, err
+	}
+
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rule := range connmarkRules(podIP, newOnly) {
+		exists, err := mgr.ipt.Exists(tableNameMangle, rule.chain, rule.rulespec...)
+		if err != nil {
+			return false, fmt.Errorf("failed to check CONNMARK rule in %s for podIP %s: %w", rule.chain, podIP, err)
+		}
+		if !exists {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,83 @@
+package iptables
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestConnmarkRules verifies the CONNMARK rule sets for a pod
+func TestConnmarkRules(t *testing.T) {
+	type rule struct {
+		chain string
+		spec  string
+	}
+	tests := []struct {
+		name    string
+		newOnly bool
+		want    []rule
+	}{
+		{
+			name: "every packet",
+			want: []rule{
+				{chain: "PREROUTING", spec: "-s 10.200.1.5 -j CONNMARK --save-mark --mask 0xff"},
+				{chain: "PREROUTING", spec: "-d 10.200.1.5 -j CONNMARK --restore-mark --mask 0xff"},
+				{chain: "OUTPUT", spec: "-d 10.200.1.5 -j CONNMARK --restore-mark --mask 0xff"},
+			},
+		},
+		{
+			name:    "new connections only",
+			newOnly: true,
+			want: []rule{
+				{chain: "PREROUTING", spec: "-s 10.200.1.5 -m conntrack --ctstate NEW -j CONNMARK --save-mark --mask 0xff"},
+				{chain: "PREROUTING", spec: "-s 10.200.1.5 -m conntrack --ctstate ESTABLISHED,RELATED -j CONNMARK --restore-mark --mask 0xff"},
+				{chain: "PREROUTING", spec: "-d 10.200.1.5 -j CONNMARK --restore-mark --mask 0xff"},
+				{chain: "OUTPUT", spec: "-d 10.200.1.5 -j CONNMARK --restore-mark --mask 0xff"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := connmarkRules("10.200.1.5", tt.newOnly)
+			if len(rules) != len(tt.want) {
+				t.Fatalf("got %d rules, want %d", len(rules), len(tt.want))
+			}
+			for i, rule := range rules {
+				if rule.chain != tt.want[i].chain {
+					t.Errorf("rule %d chain = %s, want %s", i, rule.chain, tt.want[i].chain)
+				}
+				if got := strings.Join(rule.rulespec, " "); got != tt.want[i].spec {
+					t.Errorf("rule %d spec = %q, want %q", i, got, tt.want[i].spec)
+				}
+			}
+		})
+	}
+}
+
+// TestConnmarkRules_Validation tests input validation before iptables initialization
+func TestConnmarkRules_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		podIP  string
+		errMsg string
+	}{
+		{name: "empty pod IP", podIP: "", errMsg: "podIP cannot be empty"},
+		{name: "invalid IP", podIP: "not-an-ip", errMsg: "invalid IP address format"},
+		{name: "injection attempt", podIP: "10.0.0.1 -j ACCEPT", errMsg: "invalid IP address format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := AddConnmarkRules(context.Background(), tt.podIP); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("AddConnmarkRules() error = %v, want %q", err, tt.errMsg)
+			}
+			if err := DeleteConnmarkRules(context.Background(), tt.podIP); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("DeleteConnmarkRules() error = %v, want %q", err, tt.errMsg)
+			}
+			if _, err := ConnmarkRulesExist(context.Background(), tt.podIP); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("ConnmarkRulesExist() error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
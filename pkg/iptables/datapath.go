@@ -0,0 +1,264 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Options selects where and how a Manager programs the per-pod MARK rules
+//
+// Other CNI plugins can embed the marking datapath without the wrapper's annotations
+// and configuration (see NewManagerWithOptions). The zero value is the wrapper's own
+// datapath: untagged rules in mangle/PREROUTING setting FwmarkTenantA or FwmarkTenantB.
+type Options struct {
+	// Table is the iptables table holding the rules (default "mangle")
+	Table string
+
+	// Chain receives the rules (default "PREROUTING"). A user-defined chain is created
+	// on first use; jumping to it from a built-in chain is up to the embedder.
+	Chain string
+
+	// Fwmarks are the marks rules may set (default FwmarkTenantA and FwmarkTenantB)
+	// Other marks are refused on add and never touched in the chain.
+	Fwmarks []string
+
+	// CommentPrefix and Owner tag every rule with the iptables comment
+	// CommentPrefix+Owner ("managed-by:" + "billing-cni"). A tagged Manager only lists,
+	// replaces and deletes rules carrying its own tag, so several plugins can share
+	// a chain. Without a tag any rule setting an allowed mark in Chain is managed.
+	CommentPrefix string
+	Owner         string
+
+	// AllowUnsafeSources disables the source safety checks for every rule
+	// (see CheckSourceSafety and the AllowUnsafeSources MarkOption)
+	AllowUnsafeSources bool
+}
+
+// Defaults of Options
+const (
+	DefaultTable = tableNameMangle
+	DefaultChain = chainPrerouting
+)
+
+var (
+	// namePattern restricts table and chain names (iptables allows 28 characters)
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,28}$`)
+
+	// commentPattern restricts rule tags to what iptables-save prints unquoted
+	// (the comment match allows 256 characters)
+	commentPattern = regexp.MustCompile(`^[A-Za-z0-9_.:/=-]{1,256}$`)
+
+	// builtinChains need no creation
+	builtinChains = map[string]bool{"PREROUTING": true, "INPUT": true, "FORWARD": true, "OUTPUT": true, "POSTROUTING": true}
+)
+
+// datapath is a validated Options
+type datapath struct {
+	table, chain string
+
+	// marks maps allowed mark values to their canonical spelling; nil is the tenant set
+	marks map[uint64]string
+
+	// comment is the owner tag; empty for untagged rules
+	comment string
+
+	allowUnsafeSources bool
+
+	// list reads the chain; the default datapath goes through listMarkRulesFunc
+	list func(ctx context.Context) ([]markEntry, error)
+}
+
+// defaultPath is the wrapper's own datapath used by the package-level functions
+var defaultPath = &datapath{
+	table: DefaultTable,
+	chain: DefaultChain,
+	list:  func(ctx context.Context) ([]markEntry, error) { return listMarkRulesFunc(ctx) },
+}
+
+// newDatapath validates opts and applies the defaults
+func newDatapath(opts Options) (*datapath, error) {
+	d := &datapath{
+		table:              opts.Table,
+		chain:              opts.Chain,
+		comment:            opts.CommentPrefix + opts.Owner,
+		allowUnsafeSources: opts.AllowUnsafeSources,
+	}
+	if d.table == "" {
+		d.table = DefaultTable
+	}
+	if d.chain == "" {
+		d.chain = DefaultChain
+	}
+	if !namePattern.MatchString(d.table) {
+		return nil, fmt.Errorf("invalid iptables table %q", d.table)
+	}
+	if !namePattern.MatchString(d.chain) {
+		return nil, fmt.Errorf("invalid iptables chain %q", d.chain)
+	}
+	if d.comment != "" && !commentPattern.MatchString(d.comment) {
+		return nil, fmt.Errorf("invalid rule tag %q: letters, digits and _.:/=- only, at most 256 characters", d.comment)
+	}
+
+	if len(opts.Fwmarks) > 0 {
+		d.marks = make(map[uint64]string, len(opts.Fwmarks))
+		for _, fwmark := range opts.Fwmarks {
+			mark, err := parseMark(fwmark)
+			if err != nil || mark == 0 {
+				return nil, fmt.Errorf("invalid fwmark %q in options: must be a non-zero 32-bit value", fwmark)
+			}
+			d.marks[mark] = formatMark(mark)
+		}
+	}
+
+	if d.hooks() {
+		d.list = defaultPath.list
+	} else {
+		d.list = d.listChain
+	}
+	return d, nil
+}
+
+// validateMark checks fwmark against the allowed marks
+func (d *datapath) validateMark(fwmark string) error {
+	if d.marks == nil {
+		return validateFwmark(fwmark)
+	}
+	mark, err := parseMark(fwmark)
+	if _, ok := d.marks[mark]; err != nil || !ok {
+		allowed := make([]string, 0, len(d.marks))
+		for _, m := range d.marks {
+			allowed = append(allowed, m)
+		}
+		sort.Strings(allowed)
+		return fmt.Errorf("invalid fwmark %q: must be one of %s", fwmark, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// validateRule checks a rule before anything touches iptables
+func (d *datapath) validateRule(podIP, fwmark string, allowUnsafeSources bool) error {
+	if err := validatePodIP(podIP); err != nil {
+		return err
+	}
+	if err := d.validateMark(fwmark); err != nil {
+		return err
+	}
+	if !allowUnsafeSources && !d.allowUnsafeSources {
+		return CheckSourceSafety(podIP)
+	}
+	return nil
+}
+
+// ruleComment returns the comment of a rule added for the pod with podUID
+// The owner tag, extended with "/pod-uid:<uid>" if podUID is set (see PodUID)
+func (d *datapath) ruleComment(podUID string) string {
+	if podUID == "" {
+		return d.comment
+	}
+	if d.comment == "" {
+		return podUIDCommentPrefix + podUID
+	}
+	return d.comment + "/" + podUIDCommentPrefix + podUID
+}
+
+// podUIDTagged reports whether comment is the owner tag extended with a pod UID
+func (d *datapath) podUIDTagged(comment string) bool {
+	prefix := podUIDCommentPrefix
+	if d.comment != "" {
+		prefix = d.comment + "/" + podUIDCommentPrefix
+	}
+	return strings.HasPrefix(comment, prefix)
+}
+
+// rulespec returns the MARK rule for podIP with comment ("" for none), for new
+// connections only if newOnly is set
+func (d *datapath) rulespec(podIP, fwmark, comment string, newOnly bool) []string {
+	if comment == "" {
+		return markRulespec(podIP, fwmark, newOnly)
+	}
+	rulespec := []string{"-s", podIP}
+	if newOnly {
+		rulespec = append(rulespec, ctstateNew...)
+	}
+	return append(rulespec,
+		"-m", "comment", "--comment", comment,
+		"-j", "MARK",
+		"--set-mark", fwmark,
+	)
+}
+
+// owns reports whether a rule listed from the chain is one of the datapath's rules
+func (d *datapath) owns(entry markEntry) bool {
+	if d.marks == nil {
+		if validateFwmark(formatMark(entry.Mark)) != nil {
+			return false
+		}
+	} else if _, ok := d.marks[entry.Mark]; !ok {
+		return false
+	}
+	return d.comment == "" || entry.Comment == d.comment || d.podUIDTagged(entry.Comment)
+}
+
+// ensureChain creates a user-defined chain if it does not exist yet
+func (d *datapath) ensureChain(h *handle) error {
+	if builtinChains[d.chain] {
+		return nil
+	}
+	exists, err := h.ipt.ChainExists(d.table, d.chain)
+	if err != nil {
+		return fmt.Errorf("failed to check chain %s/%s: %w", d.table, d.chain, err)
+	}
+	if exists {
+		return nil
+	}
+	if err := h.ipt.NewChain(d.table, d.chain); err != nil {
+		return fmt.Errorf("failed to create chain %s/%s: %w", d.table, d.chain, err)
+	}
+	return nil
+}
+
+// listChain returns the datapath's rules in the chain; a missing chain has none
+func (d *datapath) listChain(ctx context.Context) ([]markEntry, error) {
+	h, err := newHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !builtinChains[d.chain] {
+		exists, err := h.ipt.ChainExists(d.table, d.chain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check chain %s/%s: %w", d.table, d.chain, err)
+		}
+		if !exists {
+			return nil, nil
+		}
+	}
+
+	lines, err := h.ipt.List(d.table, d.chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s/%s rules: %w", d.table, d.chain, err)
+	}
+	var entries []markEntry
+	for _, line := range lines {
+		if entry, ok := parseMarkEntry(line); ok && d.owns(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Rule renders the MARK rule a Manager with these options installs for podIP
+// Validates like AddMarkRule, without touching iptables, e.g. to preview changes.
+func (o Options) Rule(podIP, fwmark string) (Rule, error) {
+	d, err := newDatapath(o)
+	if err != nil {
+		return Rule{}, err
+	}
+	if err := d.validateRule(podIP, fwmark, false); err != nil {
+		return Rule{}, err
+	}
+	return Rule{Table: d.table, Chain: d.chain, Rulespec: d.rulespec(net.ParseIP(podIP).String(), fwmark, d.comment, false)}, nil
+}
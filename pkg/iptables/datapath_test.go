@@ -0,0 +1,92 @@
+package iptables
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestDatapath_Options verifies defaults and validation of Options
+func TestDatapath_Options(t *testing.T) {
+	d, err := newDatapath(Options{})
+	if err != nil {
+		t.Fatalf("newDatapath(Options{}) error = %v", err)
+	}
+	if d.table != "mangle" || d.chain != "PREROUTING" || d.marks != nil || d.comment != "" {
+		t.Errorf("default datapath = %+v", d)
+	}
+
+	for _, opts := range []Options{
+		{Table: "mangle;"},
+		{Chain: strings.Repeat("X", 29)},
+		{Fwmarks: []string{"0"}},
+		{Fwmarks: []string{"0x1ffffffff"}},
+		{Owner: "billing cni"},
+		{CommentPrefix: `"`, Owner: "x"},
+	} {
+		if _, err := newDatapath(opts); err == nil {
+			t.Errorf("newDatapath(%+v) expected error", opts)
+		}
+	}
+}
+
+// TestDatapath_Owns verifies a tagged datapath only manages its own rules
+func TestDatapath_Owns(t *testing.T) {
+	d, err := newDatapath(Options{Chain: "BILLING-MARK", Fwmarks: []string{"0x100"}, CommentPrefix: "managed-by:", Owner: "billing-cni"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{`-A BILLING-MARK -s 10.200.1.5/32 -m comment --comment managed-by:billing-cni -j MARK --set-xmark 0x100/0xffffffff`, true},
+		{`-A BILLING-MARK -s 10.200.1.5/32 -m comment --comment "managed-by:billing-cni" -j MARK --set-xmark 0x100/0xffffffff`, true},
+		{`-A BILLING-MARK -s 10.200.1.5/32 -m comment --comment managed-by:other -j MARK --set-xmark 0x100/0xffffffff`, false},
+		{`-A BILLING-MARK -s 10.200.1.5/32 -j MARK --set-xmark 0x100/0xffffffff`, false},
+		{`-A BILLING-MARK -s 10.200.1.5/32 -m comment --comment managed-by:billing-cni -j MARK --set-xmark 0x10/0xffffffff`, false},
+		{`-A BILLING-MARK -s 10.200.1.5/32 -m comment --comment managed-by:billing-cni/pod-uid:3f1c -j MARK --set-xmark 0x100/0xffffffff`, true},
+		{`-A BILLING-MARK -s 10.200.1.5/32 -m comment --comment pod-uid:3f1c -j MARK --set-xmark 0x100/0xffffffff`, false},
+	}
+	for _, tt := range tests {
+		entry, ok := parseMarkEntry(tt.line)
+		if !ok {
+			t.Fatalf("parseMarkEntry(%q) failed", tt.line)
+		}
+		if got := d.owns(entry); got != tt.want {
+			t.Errorf("owns(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestDatapath_ApplyRules verifies the restore payload targets the configured chain and tag
+func TestDatapath_ApplyRules(t *testing.T) {
+	d, err := newDatapath(Options{Table: "raw", Chain: "PREROUTING", Fwmarks: []string{"0x100"}, Owner: "billing-cni", AllowUnsafeSources: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.list = func(context.Context) ([]markEntry, error) {
+		return []markEntry{
+			{IP: "10.200.1.9", Mark: 0x100, Comment: "billing-cni"},
+			{IP: "10.200.1.7", Mark: 0x100, Comment: "billing-cni/pod-uid:3f1c"},
+		}, nil
+	}
+	payloads := useFakeRestore(t, nil)
+
+	if err := d.applyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x100"}}); err != nil {
+		t.Fatalf("applyRules() error = %v", err)
+	}
+	want := "*raw\n" +
+		"-D PREROUTING -s 10.200.1.7 -m comment --comment billing-cni/pod-uid:3f1c -j MARK --set-mark 0x100\n" +
+		"-D PREROUTING -s 10.200.1.9 -m comment --comment billing-cni -j MARK --set-mark 0x100\n" +
+		"-A PREROUTING -s 10.200.1.5 -m comment --comment billing-cni -j MARK --set-mark 0x100\n" +
+		"COMMIT\n"
+	if len(*payloads) != 1 || (*payloads)[0] != want {
+		t.Errorf("payloads = %q, want [%q]", *payloads, want)
+	}
+
+	if err := d.applyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}}); err == nil {
+		t.Error("applyRules() accepted a mark outside Options.Fwmarks")
+	}
+}
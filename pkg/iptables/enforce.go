@@ -0,0 +1,270 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	tableNameFilter = "filter"
+	chainForward    = "FORWARD"
+
+	// EnforceChain holds the tenant enforcement rules; filter/FORWARD jumps to it first
+	EnforceChain = "TENANT-ROUTING-ENFORCE"
+)
+
+// EnforceRule drops forwarded traffic to a tenant destination that does not carry the tenant mark
+//
+//	-A TENANT-ROUTING-ENFORCE -d <destination> -m mark ! --mark <fwmark>/0xff -j DROP
+//
+// Only the tenant mark bits are compared (same mask as CONNMARK), so marks other
+// agents set in the upper bits do not matter.
+type EnforceRule struct {
+	// Fwmark is the tenant mark allowed to reach Destination ("0x10")
+	Fwmark string
+
+	// Destination is the protected tenant gateway or CIDR ("10.10.10.131/32")
+	Destination string
+}
+
+// String renders the rule in iptables(8) append syntax
+func (r EnforceRule) String() string {
+	return Rule{Table: tableNameFilter, Chain: EnforceChain, Rulespec: r.rulespec()}.String()
+}
+
+// rulespec returns the DROP rule for r
+func (r EnforceRule) rulespec() []string {
+	return []string{
+		"-d", r.Destination,
+		"-m", "mark", "!", "--mark", r.Fwmark + "/" + connmarkMask,
+		"-j", "DROP",
+	}
+}
+
+// validate checks the fwmark and normalizes Destination to a CIDR
+func (r *EnforceRule) validate() error {
+	if err := validateFwmark(r.Fwmark); err != nil {
+		return err
+	}
+	dst := r.Destination
+	if ip := net.ParseIP(dst); ip != nil {
+		dst += "/32"
+	}
+	_, ipnet, err := net.ParseCIDR(dst)
+	if err != nil || ipnet.IP.To4() == nil {
+		return fmt.Errorf("invalid enforcement destination %q: must be an IPv4 address or CIDR", r.Destination)
+	}
+	r.Destination = ipnet.String()
+	return nil
+}
+
+// EnsureEnforcement makes EnforceChain contain exactly rules and hooks it into filter/FORWARD
+// Rules missing from the chain are appended and rules not in the set are deleted, so
+// tenants or destinations removed from the configuration stop being enforced. An empty
+// set leaves an empty chain. Idempotent.
+func EnsureEnforcement(ctx context.Context, rules []EnforceRule) error {
+	want := make([]EnforceRule, len(rules))
+	copy(want, rules)
+	for i := range want {
+		if err := want[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	rulespecs := make([][]string, len(want))
+	for i, rule := range want {
+		rulespecs[i] = rule.rulespec()
+	}
+	return syncForwardChain(ctx, EnforceChain, rulespecs, func(lines []string) [][]string {
+		return staleEnforceRules(lines, want)
+	})
+}
+
+// syncForwardChain makes filter chain contain rulespecs and hooks it into filter/FORWARD
+// Missing rules are appended and the lines stale picks from the chain listing are deleted.
+// The jump goes first in FORWARD: later ACCEPT rules of the CNI must not bypass it.
+func syncForwardChain(ctx context.Context, chain string, rulespecs [][]string, stale func(lines []string) [][]string) error {
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer mutationGeneration.Add(1)
+
+	exists, err := mgr.ipt.ChainExists(tableNameFilter, chain)
+	if err != nil {
+		return fmt.Errorf("failed to check chain %s: %w", chain, err)
+	}
+	if !exists {
+		if err := mgr.ipt.NewChain(tableNameFilter, chain); err != nil {
+			return fmt.Errorf("failed to create chain %s: %w", chain, err)
+		}
+	}
+
+	for _, rulespec := range rulespecs {
+		if err := mgr.ipt.AppendUnique(tableNameFilter, chain, rulespec...); err != nil {
+			return fmt.Errorf("failed to add rule %v to %s: %w", rulespec, chain, err)
+		}
+	}
+
+	lines, err := mgr.ipt.List(tableNameFilter, chain)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", chain, err)
+	}
+	for _, rulespec := range stale(lines) {
+		if err := mgr.ipt.Delete(tableNameFilter, chain, rulespec...); err != nil {
+			return fmt.Errorf("failed to delete stale rule %v from %s: %w", rulespec, chain, err)
+		}
+	}
+
+	if err := mgr.ipt.InsertUnique(tableNameFilter, chainForward, 1, "-j", chain); err != nil {
+		return fmt.Errorf("failed to hook %s into %s: %w", chain, chainForward, err)
+	}
+	return nil
+}
+
+// MissingEnforcement returns the rules of the set that are not installed (CHECK)
+// A missing FORWARD jump makes every rule ineffective, so all of them are returned.
+func MissingEnforcement(ctx context.Context, rules []EnforceRule) ([]EnforceRule, error) {
+	want := make([]EnforceRule, len(rules))
+	copy(want, rules)
+	for i := range want {
+		if err := want[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	if len(want) == 0 {
+		return nil, nil
+	}
+
+	rulespecs := make([][]string, len(want))
+	for i, rule := range want {
+		rulespecs[i] = rule.rulespec()
+	}
+	missingIdx, err := missingForwardRules(ctx, EnforceChain, rulespecs)
+	if err != nil {
+		return nil, err
+	}
+	var missing []EnforceRule
+	for _, i := range missingIdx {
+		missing = append(missing, want[i])
+	}
+	return missing, nil
+}
+
+// missingForwardRules returns the indexes of rulespecs not installed in filter chain
+// A missing chain or FORWARD jump makes every rule ineffective, so all of them are returned.
+func missingForwardRules(ctx context.Context, chain string, rulespecs [][]string) ([]int, error) {
+	all := make([]int, len(rulespecs))
+	for i := range rulespecs {
+		all[i] = i
+	}
+
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := mgr.ipt.ChainExists(tableNameFilter, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check chain %s: %w", chain, err)
+	}
+	if !exists {
+		return all, nil
+	}
+	hooked, err := mgr.ipt.Exists(tableNameFilter, chainForward, "-j", chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s jump: %w", chain, err)
+	}
+	if !hooked {
+		return all, nil
+	}
+
+	var missing []int
+	for i, rulespec := range rulespecs {
+		exists, err := mgr.ipt.Exists(tableNameFilter, chain, rulespec...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check rule %v in %s: %w", rulespec, chain, err)
+		}
+		if !exists {
+			missing = append(missing, i)
+		}
+	}
+	return missing, nil
+}
+
+// staleEnforceRules returns the rulespecs of chain lines (iptables-save syntax) that
+// are not in want; every rule of the chain is ours, so unparsable ones are stale too
+func staleEnforceRules(lines []string, want []EnforceRule) [][]string {
+	keep := map[EnforceRule]bool{}
+	for _, rule := range want {
+		keep[rule] = true
+	}
+
+	var stale [][]string
+	for _, line := range lines {
+		fields := splitQuoted(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != EnforceChain {
+			continue // -N line
+		}
+		if rule, ok := parseEnforceRule(fields[2:]); ok && keep[rule] {
+			continue
+		}
+		stale = append(stale, fields[2:])
+	}
+	return stale
+}
+
+// parseEnforceRule parses the rulespec of an installed enforcement rule
+//
+//	-d 10.10.10.131/32 -m mark ! --mark 0x10/0xff -j DROP
+func parseEnforceRule(rulespec []string) (EnforceRule, bool) {
+	var rule EnforceRule
+	for i := 0; i < len(rulespec)-1; i++ {
+		switch rulespec[i] {
+		case "-d":
+			rule.Destination = rulespec[i+1]
+		case "--mark":
+			mark, mask, ok := parseMarkValue(rulespec[i+1])
+			if !ok || mask != 0xff {
+				return EnforceRule{}, false
+			}
+			rule.Fwmark = formatMark(mark)
+		}
+	}
+	if rule.validate() != nil {
+		return EnforceRule{}, false
+	}
+	return rule, true
+}
+
+// parseMarkValue parses a "value/mask" mark match (mask 0xffffffff if omitted)
+func parseMarkValue(value string) (mark, mask uint64, ok bool) {
+	mask = 0xffffffff
+	if slash := strings.IndexByte(value, '/'); slash >= 0 {
+		m, err := strconv.ParseUint(value[slash+1:], 0, 32)
+		if err != nil {
+			return 0, 0, false
+		}
+		mask, value = m, value[:slash]
+	}
+	mark, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return mark, mask, true
+}
+
+// SortEnforceRules orders rules by fwmark, then destination (stable chain layout)
+func SortEnforceRules(rules []EnforceRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Fwmark != rules[j].Fwmark {
+			return rules[i].Fwmark < rules[j].Fwmark
+		}
+		return rules[i].Destination < rules[j].Destination
+	})
+}
@@ -0,0 +1,83 @@
+package iptables
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestEnforceRule_String verifies the rulespec matches only the tenant mark bits
+func TestEnforceRule_String(t *testing.T) {
+	rule := EnforceRule{Fwmark: "0x10", Destination: "10.10.10.131/32"}
+	want := "-t filter -A TENANT-ROUTING-ENFORCE -d 10.10.10.131/32 -m mark ! --mark 0x10/0xff -j DROP"
+	if got := rule.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// TestEnforceRule_Validate verifies destinations are normalized and fwmarks checked
+func TestEnforceRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    EnforceRule
+		wantDst string
+		wantErr bool
+	}{
+		{name: "host address", rule: EnforceRule{Fwmark: "0x10", Destination: "10.10.10.131"}, wantDst: "10.10.10.131/32"},
+		{name: "CIDR", rule: EnforceRule{Fwmark: "0x20", Destination: "172.16.5.7/16"}, wantDst: "172.16.0.0/16"},
+		{name: "IPv6", rule: EnforceRule{Fwmark: "0x10", Destination: "fd00::/64"}, wantErr: true},
+		{name: "garbage", rule: EnforceRule{Fwmark: "0x10", Destination: "gateway"}, wantErr: true},
+		{name: "invalid fwmark", rule: EnforceRule{Fwmark: "0x30", Destination: "10.0.0.1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			err := rule.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && rule.Destination != tt.wantDst {
+				t.Errorf("Destination = %q, want %q", rule.Destination, tt.wantDst)
+			}
+		})
+	}
+}
+
+// TestStaleEnforceRules verifies only rules outside the wanted set are deleted
+func TestStaleEnforceRules(t *testing.T) {
+	lines := []string{
+		"-N TENANT-ROUTING-ENFORCE",
+		"-A TENANT-ROUTING-ENFORCE -d 10.10.10.131/32 -m mark ! --mark 0x10/0xff -j DROP",
+		"-A TENANT-ROUTING-ENFORCE -d 10.10.10.184/32 -m mark ! --mark 0x20/0xff -j DROP",
+		"-A TENANT-ROUTING-ENFORCE -d 172.16.0.0/16 -m mark ! --mark 0x20/0xff -j DROP",
+		`-A TENANT-ROUTING-ENFORCE -s 10.0.0.1/32 -m comment --comment "hand made" -j ACCEPT`,
+	}
+	want := []EnforceRule{
+		{Fwmark: "0x10", Destination: "10.10.10.131/32"},
+		{Fwmark: "0x20", Destination: "172.16.0.0/16"},
+	}
+
+	got := staleEnforceRules(lines, want)
+	expected := [][]string{
+		{"-d", "10.10.10.184/32", "-m", "mark", "!", "--mark", "0x20/0xff", "-j", "DROP"},
+		{"-s", "10.0.0.1/32", "-m", "comment", "--comment", "hand made", "-j", "ACCEPT"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("staleEnforceRules() = %v, want %v", got, expected)
+	}
+}
+
+// TestEnforcement_Validation verifies invalid rules are rejected before iptables initialization
+func TestEnforcement_Validation(t *testing.T) {
+	invalid := []EnforceRule{{Fwmark: "0x10", Destination: "not-an-ip"}}
+	if err := EnsureEnforcement(context.Background(), invalid); err == nil {
+		t.Error("EnsureEnforcement() expected validation error")
+	}
+	if _, err := MissingEnforcement(context.Background(), invalid); err == nil {
+		t.Error("MissingEnforcement() expected validation error")
+	}
+	if missing, err := MissingEnforcement(context.Background(), nil); err != nil || missing != nil {
+		t.Errorf("MissingEnforcement(nil) = %v, %v; want nothing", missing, err)
+	}
+}
@@ -1,6 +1,7 @@
 package iptables_test
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
@@ -9,7 +10,7 @@ import (
 // ExampleAddMarkRule_invalidFwmark demonstrates fwmark validation
 func ExampleAddMarkRule_invalidFwmark() {
 	// Attempt to use invalid fwmark (prevents Cilium conflicts)
-	err := iptables.AddMarkRule("10.200.1.5", "0x99")
+	err := iptables.AddMarkRule(context.Background(), "10.200.1.5", "0x99")
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 	}
@@ -19,9 +20,52 @@ func ExampleAddMarkRule_invalidFwmark() {
 // ExampleAddMarkRule_emptyIP demonstrates IP validation
 func ExampleAddMarkRule_emptyIP() {
 	// Attempt to add rule with empty IP
-	err := iptables.AddMarkRule("", "0x10")
+	err := iptables.AddMarkRule(context.Background(), "", "0x10")
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 	}
 	// Output: Error: podIP cannot be empty
 }
+
+// ExampleNewManagerWithOptions demonstrates embedding the datapath in another CNI plugin
+// with its own chain, mark and owner tag
+func ExampleNewManagerWithOptions() {
+	opts := iptables.Options{
+		Chain:         "BILLING-MARK",
+		Fwmarks:       []string{"0x100"},
+		CommentPrefix: "managed-by:",
+		Owner:         "billing-cni",
+	}
+	mgr, err := iptables.NewManagerWithOptions(opts)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	_ = mgr // mgr.AddMarkRule(ctx, "10.200.1.5", "0x100") installs:
+
+	rule, err := opts.Rule("10.200.1.5", "0x100")
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Println(rule)
+	// Output: -t mangle -A BILLING-MARK -s 10.200.1.5 -m comment --comment managed-by:billing-cni -j MARK --set-mark 0x100
+}
+
+// ExampleOptions_Rule demonstrates that embedders get the same validation, restricted to their marks
+func ExampleOptions_Rule() {
+	opts := iptables.Options{Fwmarks: []string{"0x100", "0x200"}}
+	if _, err := opts.Rule("10.200.1.5", "0x10"); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	// Output: Error: invalid fwmark "0x10": must be one of 0x100, 0x200
+}
+
+// ExampleNewManagerWithOptions_invalid demonstrates option validation
+func ExampleNewManagerWithOptions_invalid() {
+	_, err := iptables.NewManagerWithOptions(iptables.Options{Chain: "BILLING MARK"})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	// Output: Error: invalid iptables chain "BILLING MARK"
+}
@@ -0,0 +1,216 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// FakeManager is an in-memory Manager for unit tests
+// Applies the same input validation as the production Manager but never touches
+// the kernel; source safety (node addresses) is not checked. Safe for concurrent use.
+type FakeManager struct {
+	mu    sync.Mutex
+	rules map[markEntry]struct{}
+
+	// uids holds the PodUID option of rules added with one
+	uids map[markEntry]string
+
+	// hooks holds the hook of rules added at one other than HookPrerouting
+	hooks map[markEntry]string
+
+	// Err, if set, is returned by every method instead of touching the rule set
+	Err error
+}
+
+// failure is the error a method called with ctx fails with: that of a done ctx, then
+// Err (caller holds f.mu)
+func (f *FakeManager) failure(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Err
+}
+
+// NewFakeManager returns an empty FakeManager
+func NewFakeManager() *FakeManager {
+	return &FakeManager{rules: map[markEntry]struct{}{}, uids: map[markEntry]string{}, hooks: map[markEntry]string{}}
+}
+
+// AddMarkRule records the rule and its PodUID and AtHook options; idempotent
+func (f *FakeManager) AddMarkRule(ctx context.Context, podIP, fwmark string, opts ...MarkOption) error {
+	key, err := fakeKey(podIP, fwmark)
+	if err != nil {
+		return err
+	}
+	var options markOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.podUID != "" && !podUIDPattern.MatchString(options.podUID) {
+		return fmt.Errorf("invalid pod UID %q", options.podUID)
+	}
+	chain, err := HookChain(options.hook)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure(ctx); err != nil {
+		return err
+	}
+	if _, ok := f.rules[key]; !ok {
+		if options.podUID != "" {
+			f.uids[key] = options.podUID
+		}
+		if hook := chainHook(chain); hook != "" {
+			f.hooks[key] = hook
+		}
+	}
+	f.rules[key] = struct{}{}
+	return nil
+}
+
+// DeleteMarkRule forgets the rule; idempotent
+func (f *FakeManager) DeleteMarkRule(ctx context.Context, podIP, fwmark string) error {
+	key, err := fakeKey(podIP, fwmark)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure(ctx); err != nil {
+		return err
+	}
+	delete(f.rules, key)
+	delete(f.uids, key)
+	delete(f.hooks, key)
+	return nil
+}
+
+// RulePodUID returns the pod UID the rule was tagged with ("" if none or not recorded)
+func (f *FakeManager) RulePodUID(podIP, fwmark string) string {
+	key, err := fakeKey(podIP, fwmark)
+	if err != nil {
+		return ""
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.uids[key]
+}
+
+// RuleHook returns the hook the rule was added at ("" for HookPrerouting or if not recorded)
+func (f *FakeManager) RuleHook(podIP, fwmark string) string {
+	key, err := fakeKey(podIP, fwmark)
+	if err != nil {
+		return ""
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hooks[key]
+}
+
+// RuleExists reports whether the rule was recorded
+func (f *FakeManager) RuleExists(ctx context.Context, podIP, fwmark string) (bool, error) {
+	key, err := fakeKey(podIP, fwmark)
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure(ctx); err != nil {
+		return false, err
+	}
+	_, ok := f.rules[key]
+	return ok, nil
+}
+
+// List returns the recorded rules sorted by pod IP, then fwmark
+func (f *FakeManager) List(ctx context.Context) ([]MarkRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure(ctx); err != nil {
+		return nil, err
+	}
+
+	entries := make([]markEntry, 0, len(f.rules))
+	for entry := range f.rules {
+		entries = append(entries, entry)
+	}
+	sortEntries(entries)
+
+	rules := make([]MarkRule, 0, len(entries))
+	for _, entry := range entries {
+		rules = append(rules, MarkRule{PodIP: entry.IP, Fwmark: formatMark(entry.Mark), Hook: f.hooks[entry]})
+	}
+	return rules, nil
+}
+
+// ApplyRules replaces the recorded rules with rules, or adds the missing ones with
+// KeepUnlisted (same validation as production, source safety excepted); nothing
+// changes if any rule is invalid
+func (f *FakeManager) ApplyRules(ctx context.Context, rules []MarkRule, opts ...MarkOption) error {
+	var options markOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	desired, err := normalizeRules(rules, markOptions{allowUnsafeSources: true})
+	if err != nil {
+		return err
+	}
+	uids := make(map[markEntry]string)
+	for _, rule := range rules {
+		if rule.PodUID != "" {
+			key, _ := fakeKey(rule.PodIP, rule.Fwmark)
+			uids[key] = rule.PodUID
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failure(ctx); err != nil {
+		return err
+	}
+	recorded := make(map[markEntry]struct{}, len(desired))
+	hooks := make(map[markEntry]string)
+	if options.keepUnlisted {
+		recorded, hooks = f.rules, f.hooks
+	}
+	for entry := range desired {
+		key := entry.key()
+		if _, ok := recorded[key]; ok && options.keepUnlisted {
+			continue
+		}
+		recorded[key] = struct{}{}
+		if entry.Chain != "" {
+			hooks[key] = chainHook(entry.Chain)
+		}
+		if uid, ok := uids[key]; ok {
+			f.uids[key] = uid
+		}
+	}
+	for key := range f.uids {
+		if _, ok := recorded[key]; !ok {
+			delete(f.uids, key)
+		}
+	}
+	f.rules, f.hooks = recorded, hooks
+	return nil
+}
+
+// fakeKey validates input like the production Manager and normalizes it
+func fakeKey(podIP, fwmark string) (markEntry, error) {
+	if err := validatePodIP(podIP); err != nil {
+		return markEntry{}, err
+	}
+	if err := validateFwmark(fwmark); err != nil {
+		return markEntry{}, err
+	}
+	return markEntry{IP: net.ParseIP(podIP).String(), Mark: mustParseMark(fwmark)}, nil
+}
@@ -0,0 +1,133 @@
+package iptables
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestFakeManager_Lifecycle verifies add/exists/list/delete on the in-memory fake
+func TestFakeManager_Lifecycle(t *testing.T) {
+	var mgr Manager = NewFakeManager()
+
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.5", "0x10"); err != nil {
+		t.Fatalf("AddMarkRule() error = %v", err)
+	}
+	// Idempotent, and fwmark spelling is normalized
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.5", "0X10"); err != nil {
+		t.Fatalf("repeated AddMarkRule() error = %v", err)
+	}
+
+	exists, err := mgr.RuleExists(context.Background(), "10.200.1.5", "0x10")
+	if err != nil || !exists {
+		t.Errorf("RuleExists() = %v, %v; want true, nil", exists, err)
+	}
+
+	rules, err := mgr.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(rules) != 1 || rules[0] != (MarkRule{PodIP: "10.200.1.5", Fwmark: "0x10"}) {
+		t.Errorf("List() = %v, want one rule for 10.200.1.5/0x10", rules)
+	}
+
+	if err := mgr.DeleteMarkRule(context.Background(), "10.200.1.5", "0x10"); err != nil {
+		t.Fatalf("DeleteMarkRule() error = %v", err)
+	}
+	if err := mgr.DeleteMarkRule(context.Background(), "10.200.1.5", "0x10"); err != nil {
+		t.Fatalf("repeated DeleteMarkRule() error = %v", err)
+	}
+	if exists, _ := mgr.RuleExists(context.Background(), "10.200.1.5", "0x10"); exists {
+		t.Error("rule still exists after delete")
+	}
+}
+
+// TestFakeManager_Validation verifies the fake rejects what production rejects
+func TestFakeManager_Validation(t *testing.T) {
+	mgr := NewFakeManager()
+
+	if err := mgr.AddMarkRule(context.Background(), "", "0x10"); err == nil {
+		t.Error("expected error for empty pod IP")
+	}
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.5", "0x99"); err == nil {
+		t.Error("expected error for disallowed fwmark")
+	}
+	if rules, _ := mgr.List(context.Background()); len(rules) != 0 {
+		t.Errorf("invalid input was recorded: %v", rules)
+	}
+}
+
+// TestFakeManager_Err verifies injected errors are returned
+func TestFakeManager_Err(t *testing.T) {
+	mgr := NewFakeManager()
+	mgr.Err = errors.New("iptables unavailable")
+
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.5", "0x10"); !errors.Is(err, mgr.Err) {
+		t.Errorf("AddMarkRule() error = %v, want injected error", err)
+	}
+	if _, err := mgr.List(context.Background()); !errors.Is(err, mgr.Err) {
+		t.Errorf("List() error = %v, want injected error", err)
+	}
+}
+
+// TestFakeManager_Cancelled verifies a done context fails calls like the production Manager
+func TestFakeManager_Cancelled(t *testing.T) {
+	mgr := NewFakeManager()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := mgr.AddMarkRule(ctx, "10.200.1.5", "0x10"); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddMarkRule() error = %v, want context.Canceled", err)
+	}
+	if exists, _ := mgr.RuleExists(context.Background(), "10.200.1.5", "0x10"); exists {
+		t.Error("rule recorded despite the cancelled context")
+	}
+}
+
+// TestFakeManager_Hook verifies the AtHook option is validated, recorded and listed
+func TestFakeManager_Hook(t *testing.T) {
+	mgr := NewFakeManager()
+
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.5", "0x10", AtHook(HookPostrouting)); err != nil {
+		t.Fatalf("AddMarkRule() error = %v", err)
+	}
+	if got := mgr.RuleHook("10.200.1.5", "0x10"); got != HookPostrouting {
+		t.Errorf("RuleHook() = %q", got)
+	}
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.6", "0x10", AtHook("input")); err == nil {
+		t.Error("AddMarkRule() accepted an invalid hook")
+	}
+	rules, err := mgr.List(context.Background())
+	if err != nil || len(rules) != 1 || rules[0].Hook != HookPostrouting {
+		t.Errorf("List() = %+v, %v", rules, err)
+	}
+
+	if err := mgr.ApplyRules(context.Background(), []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := mgr.RuleHook("10.200.1.5", "0x10"); got != "" {
+		t.Errorf("RuleHook() after ApplyRules = %q", got)
+	}
+}
+
+// TestFakeManager_PodUID verifies the PodUID option is validated and recorded
+func TestFakeManager_PodUID(t *testing.T) {
+	mgr := NewFakeManager()
+
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.5", "0x10", PodUID("3f1c0b7e-1a2b-4c3d-8e9f-0a1b2c3d4e5f")); err != nil {
+		t.Fatalf("AddMarkRule() error = %v", err)
+	}
+	if got := mgr.RulePodUID("10.200.1.5", "0x10"); got != "3f1c0b7e-1a2b-4c3d-8e9f-0a1b2c3d4e5f" {
+		t.Errorf("RulePodUID() = %q", got)
+	}
+	if err := mgr.AddMarkRule(context.Background(), "10.200.1.6", "0x10", PodUID("uid with spaces")); err == nil {
+		t.Error("AddMarkRule() accepted an invalid pod UID")
+	}
+
+	if err := mgr.DeleteMarkRule(context.Background(), "10.200.1.5", "0x10"); err != nil {
+		t.Fatal(err)
+	}
+	if got := mgr.RulePodUID("10.200.1.5", "0x10"); got != "" {
+		t.Errorf("RulePodUID() after delete = %q", got)
+	}
+}
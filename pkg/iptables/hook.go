@@ -0,0 +1,99 @@
+package iptables
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Hooks at which the per-pod MARK rule can be installed (see AtHook)
+//
+// The rule always matches the pod as source; the hook decides which mangle chain
+// sees it:
+//   - prerouting (the default): forwarded pod traffic, before the routing decision
+//   - output: pod traffic the node itself emits, e.g. through a delegate that proxies
+//     or originates it locally; a mark set in OUTPUT reroutes the packet
+//   - forward, postrouting: after the routing decision, so the mark no longer selects
+//     a route. It is still seen by filter/FORWARD and by nat/POSTROUTING, which runs
+//     after mangle/POSTROUTING: SNAT or masquerade rules matching the tenant mark
+//     see it before the source is rewritten.
+const (
+	HookPrerouting  = "prerouting"
+	HookOutput      = "output"
+	HookForward     = "forward"
+	HookPostrouting = "postrouting"
+)
+
+const chainPostrouting = "POSTROUTING"
+
+// hookChains maps hooks to the mangle chain holding their MARK rules
+var hookChains = map[string]string{
+	HookPrerouting:  chainPrerouting,
+	HookOutput:      chainOutput,
+	HookForward:     chainForward,
+	HookPostrouting: chainPostrouting,
+}
+
+// markChains are the mangle chains the default datapath reads MARK rules from,
+// its own chain first
+var markChains = []string{chainPrerouting, chainForward, chainPostrouting, chainOutput}
+
+// HookChain returns the mangle chain of hook ("" is HookPrerouting)
+func HookChain(hook string) (string, error) {
+	if hook == "" {
+		return chainPrerouting, nil
+	}
+	chain, ok := hookChains[hook]
+	if !ok {
+		hooks := make([]string, 0, len(hookChains))
+		for h := range hookChains {
+			hooks = append(hooks, h)
+		}
+		sort.Strings(hooks)
+		return "", fmt.Errorf("invalid hook %q: must be one of %s", hook, strings.Join(hooks, ", "))
+	}
+	return chain, nil
+}
+
+// chainHook returns the hook of a mangle chain holding MARK rules ("" for PREROUTING)
+func chainHook(chain string) string {
+	for hook, c := range hookChains {
+		if c == chain && hook != HookPrerouting {
+			return hook
+		}
+	}
+	return ""
+}
+
+// AtHook installs the MARK rule at hook instead of mangle/PREROUTING (see HookPrerouting)
+// Only the default datapath supports hooks; a Manager with custom Options refuses them.
+// Existence checks, deletion and listing find the rule at any hook.
+func AtHook(hook string) MarkOption {
+	return func(o *markOptions) {
+		o.hook = hook
+	}
+}
+
+// hookChain returns the chain the datapath installs a rule with hook in
+func (d *datapath) hookChain(hook string) (string, error) {
+	if hook == "" || hook == HookPrerouting {
+		return d.chain, nil
+	}
+	if !d.hooks() {
+		return "", fmt.Errorf("hook %q needs the default datapath (mangle/PREROUTING, untagged, tenant marks)", hook)
+	}
+	return HookChain(hook)
+}
+
+// hooks reports whether the datapath is the default one, which reads every hook chain
+func (d *datapath) hooks() bool {
+	return d.table == DefaultTable && d.chain == DefaultChain && d.comment == "" && d.marks == nil
+}
+
+// entryChain returns the chain an entry listed by the datapath is installed in
+func (d *datapath) entryChain(entry markEntry) string {
+	if entry.Chain == "" {
+		return d.chain
+	}
+	return entry.Chain
+}
@@ -0,0 +1,79 @@
+package iptables
+
+import (
+	"context"
+	"testing"
+)
+
+// TestHookChain verifies every hook maps to its mangle chain and back
+func TestHookChain(t *testing.T) {
+	tests := []struct {
+		hook  string
+		chain string
+	}{
+		{"", "PREROUTING"},
+		{HookPrerouting, "PREROUTING"},
+		{HookOutput, "OUTPUT"},
+		{HookForward, "FORWARD"},
+		{HookPostrouting, "POSTROUTING"},
+	}
+	for _, tt := range tests {
+		chain, err := HookChain(tt.hook)
+		if err != nil || chain != tt.chain {
+			t.Errorf("HookChain(%q) = %q, %v, want %q", tt.hook, chain, err, tt.chain)
+		}
+		if want := tt.hook; want == HookPrerouting {
+			if got := chainHook(chain); got != "" {
+				t.Errorf("chainHook(%q) = %q, want \"\"", chain, got)
+			}
+		} else if got := chainHook(chain); got != want {
+			t.Errorf("chainHook(%q) = %q, want %q", chain, got, want)
+		}
+	}
+
+	if _, err := HookChain("input"); err == nil {
+		t.Error("HookChain(\"input\") expected error")
+	}
+}
+
+// TestDatapath_HooksNeedDefault verifies a datapath with custom Options refuses hooks
+func TestDatapath_HooksNeedDefault(t *testing.T) {
+	d, err := newDatapath(Options{Chain: "BILLING-MARK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain, err := d.hookChain(""); err != nil || chain != "BILLING-MARK" {
+		t.Errorf("hookChain(\"\") = %q, %v", chain, err)
+	}
+	if _, err := d.hookChain(HookPostrouting); err == nil {
+		t.Error("hookChain(postrouting) expected error for a custom chain")
+	}
+	if _, err := defaultPath.hookChain("input"); err == nil {
+		t.Error("hookChain(\"input\") expected error")
+	}
+}
+
+// TestManager_ListHooks verifies rules listed from hook chains report their hook
+func TestManager_ListHooks(t *testing.T) {
+	useFakeLister(t,
+		markEntry{IP: "10.200.1.5", Mark: 0x10},
+		markEntry{IP: "10.200.1.6", Mark: 0x20, Chain: "POSTROUTING"},
+	)
+
+	rules, err := NewManager().List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []MarkRule{
+		{PodIP: "10.200.1.5", Fwmark: "0x10"},
+		{PodIP: "10.200.1.6", Fwmark: "0x20", Hook: HookPostrouting},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("List() = %+v, want %+v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ManagedRule is a MARK rule programmed by this plugin, parsed back from the kernel
+type ManagedRule struct {
+	// Chain is PREROUTING (pod traffic, matched by source) or OUTPUT
+	// (host-originated traffic to the pod, matched by destination); FORWARD,
+	// POSTROUTING and OUTPUT also hold source rules installed at a hook
+	Chain string
+
+	// Hook is the hook of a source rule installed outside PREROUTING (see AtHook)
+	Hook string
+
+	// PodIP is the pod address the rule matches
+	PodIP string
+
+	// Fwmark is the tenant mark the rule sets ("0x10")
+	Fwmark string
+
+	// Comment is the iptables comment (pod identity) if the rule carries one
+	Comment string
+
+	// NewOnly is set if the rule marks new connections only (see NewConnectionsOnly)
+	NewOnly bool
+}
+
+// String renders the rule in iptables(8) append syntax
+func (r ManagedRule) String() string {
+	match := "-s"
+	if r.Chain == chainOutput && r.Hook == "" {
+		match = "-d"
+	}
+	s := fmt.Sprintf("-t %s -A %s %s %s", tableNameMangle, r.Chain, match, r.PodIP)
+	if r.NewOnly {
+		s += " " + strings.Join(ctstateNew, " ")
+	}
+	if r.Comment != "" {
+		s += fmt.Sprintf(" -m comment --comment %q", r.Comment)
+	}
+	return s + " -j MARK --set-mark " + r.Fwmark
+}
+
+// listChainFunc lists one chain in iptables-save syntax; replaced in tests to avoid exec
+var listChainFunc = listChain
+
+// ListManagedRules enumerates every MARK rule this plugin manages in mangle/PREROUTING,
+// mangle/OUTPUT and the other hook chains (see AtHook). Rules of other agents (other
+// marks, masked marks, CIDR matches) are skipped. Sorted by chain (PREROUTING first),
+// pod IP, then fwmark.
+func ListManagedRules(ctx context.Context) ([]ManagedRule, error) {
+	var rules []ManagedRule
+	for _, chain := range markChains {
+		lines, err := listChainFunc(ctx, tableNameMangle, chain)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if rule, ok := parseManagedRule(chain, line); ok {
+				rules = append(rules, rule)
+			}
+			// OUTPUT holds host-traffic rules and source rules installed at HookOutput
+			if chain != chainOutput {
+				continue
+			}
+			if rule, ok := parseHookRule(line); ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Chain != rules[j].Chain {
+			return rules[i].Chain == chainPrerouting ||
+				rules[j].Chain != chainPrerouting && rules[i].Chain < rules[j].Chain
+		}
+		if rules[i].Hook != rules[j].Hook {
+			return rules[i].Hook < rules[j].Hook
+		}
+		if rules[i].PodIP != rules[j].PodIP {
+			return rules[i].PodIP < rules[j].PodIP
+		}
+		return rules[i].Fwmark < rules[j].Fwmark
+	})
+	return rules, nil
+}
+
+// listChain reads a chain through go-iptables
+func listChain(ctx context.Context, table, chain string) ([]string, error) {
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := mgr.ipt.List(table, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s/%s rules: %w", table, chain, err)
+	}
+	return rules, nil
+}
+
+// parseManagedRule parses one iptables-save line of chain into a ManagedRule
+//
+//	-A PREROUTING -s 10.200.1.5/32 -m comment --comment "team-a/web" -j MARK --set-xmark 0x10/0xffffffff
+func parseManagedRule(chain, line string) (ManagedRule, bool) {
+	if chain == chainOutput {
+		return parseRule(ManagedRule{Chain: chain}, "-d", line)
+	}
+	return parseRule(ManagedRule{Chain: chain, Hook: chainHook(chain)}, "-s", line)
+}
+
+// parseHookRule parses one iptables-save line of mangle/OUTPUT installed at HookOutput
+//
+//	-A OUTPUT -s 10.200.1.5/32 -j MARK --set-xmark 0x10/0xffffffff
+func parseHookRule(line string) (ManagedRule, bool) {
+	return parseRule(ManagedRule{Chain: chainOutput, Hook: HookOutput}, "-s", line)
+}
+
+// parseRule completes rule from line, matching the pod address with match
+func parseRule(rule ManagedRule, match, line string) (ManagedRule, bool) {
+	mark, ok := parseMarkTarget(line)
+	if !ok || validateFwmark(formatMark(mark)) != nil {
+		return ManagedRule{}, false
+	}
+
+	rule.Fwmark, rule.NewOnly = formatMark(mark), isNewOnly(line)
+	fields := splitQuoted(line)
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case match:
+			ip, ok := hostAddress(fields[i+1])
+			if !ok {
+				return ManagedRule{}, false
+			}
+			rule.PodIP = ip
+		case "--comment":
+			rule.Comment = fields[i+1]
+		}
+	}
+	if rule.PodIP == "" {
+		return ManagedRule{}, false
+	}
+	return rule, true
+}
+
+// hostAddress returns the address of a single-host match ("10.200.1.5" or "10.200.1.5/32")
+func hostAddress(value string) (string, bool) {
+	if ip, ipnet, err := net.ParseCIDR(value); err == nil {
+		if ones, bits := ipnet.Mask.Size(); ones != bits {
+			return "", false
+		}
+		return ip.String(), true
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		return ip.String(), true
+	}
+	return "", false
+}
+
+// splitQuoted splits an iptables-save line on spaces, keeping double-quoted values together
+func splitQuoted(line string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes, escaped, hasField := false, false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && inQuotes:
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			hasField = true
+		case r == ' ' && !inQuotes:
+			if hasField {
+				fields = append(fields, current.String())
+				current.Reset()
+				hasField = false
+			}
+		default:
+			current.WriteRune(r)
+			hasField = true
+		}
+	}
+	if hasField {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
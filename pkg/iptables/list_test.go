@@ -0,0 +1,122 @@
+package iptables
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// useFakeChains serves canned iptables-save lines per chain for the duration of a test
+func useFakeChains(t *testing.T, chains map[string][]string, err error) {
+	t.Helper()
+	orig := listChainFunc
+	listChainFunc = func(_ context.Context, table, chain string) ([]string, error) {
+		return chains[chain], err
+	}
+	t.Cleanup(func() { listChainFunc = orig })
+}
+
+// TestListManagedRules verifies structured parsing of both managed chains
+func TestListManagedRules(t *testing.T) {
+	useFakeChains(t, map[string][]string{
+		chainPrerouting: {
+			"-P PREROUTING ACCEPT",
+			"-A PREROUTING -s 10.200.1.6/32 -m conntrack --ctstate NEW -j MARK --set-xmark 0x20/0xffffffff",
+			`-A PREROUTING -s 10.200.1.5/32 -m comment --comment "team-a/web pod" -j MARK --set-xmark 0x10/0xffffffff`,
+			"-A PREROUTING -s 10.200.1.5/32 -j CONNMARK --save-mark --nfmask 0xff --ctmask 0xff",
+			"-A PREROUTING -s 10.0.0.0/8 -j MARK --set-xmark 0x10/0xffffffff",
+			"-A PREROUTING -s 10.200.1.7/32 -j MARK --set-xmark 0xe00/0xf00",
+			"-A PREROUTING -s 10.200.1.8/32 -j MARK --set-xmark 0x99/0xffffffff",
+		},
+		chainOutput: {
+			"-A OUTPUT -d 10.200.1.5/32 -j MARK --set-xmark 0x10/0xffffffff",
+		},
+	}, nil)
+
+	rules, err := ListManagedRules(context.Background())
+	if err != nil {
+		t.Fatalf("ListManagedRules(context.Background()) error = %v", err)
+	}
+
+	want := []ManagedRule{
+		{Chain: chainPrerouting, PodIP: "10.200.1.5", Fwmark: "0x10", Comment: "team-a/web pod"},
+		{Chain: chainPrerouting, PodIP: "10.200.1.6", Fwmark: "0x20", NewOnly: true},
+		{Chain: chainOutput, PodIP: "10.200.1.5", Fwmark: "0x10"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ListManagedRules(context.Background()) = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	if got := rules[0].String(); got != `-t mangle -A PREROUTING -s 10.200.1.5 -m comment --comment "team-a/web pod" -j MARK --set-mark 0x10` {
+		t.Errorf("String() = %q", got)
+	}
+	if got := rules[1].String(); got != "-t mangle -A PREROUTING -s 10.200.1.6 -m conntrack --ctstate NEW -j MARK --set-mark 0x20" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := rules[2].String(); got != "-t mangle -A OUTPUT -d 10.200.1.5 -j MARK --set-mark 0x10" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// TestListManagedRules_Hooks verifies source rules of the hook chains, OUTPUT included
+func TestListManagedRules_Hooks(t *testing.T) {
+	useFakeChains(t, map[string][]string{
+		chainOutput: {
+			"-A OUTPUT -d 10.200.1.5/32 -j MARK --set-xmark 0x10/0xffffffff",
+			"-A OUTPUT -s 10.200.1.6/32 -j MARK --set-xmark 0x20/0xffffffff",
+		},
+		chainPostrouting: {
+			"-A POSTROUTING -s 10.200.1.5/32 -j MARK --set-xmark 0x10/0xffffffff",
+			"-A POSTROUTING -d 10.200.1.7/32 -j MARK --set-xmark 0x10/0xffffffff",
+		},
+	}, nil)
+
+	rules, err := ListManagedRules(context.Background())
+	if err != nil {
+		t.Fatalf("ListManagedRules(context.Background()) error = %v", err)
+	}
+	want := []ManagedRule{
+		{Chain: chainOutput, PodIP: "10.200.1.5", Fwmark: "0x10"},
+		{Chain: chainOutput, Hook: HookOutput, PodIP: "10.200.1.6", Fwmark: "0x20"},
+		{Chain: chainPostrouting, Hook: HookPostrouting, PodIP: "10.200.1.5", Fwmark: "0x10"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("ListManagedRules(context.Background()) = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+	if got := rules[1].String(); got != "-t mangle -A OUTPUT -s 10.200.1.6 -j MARK --set-mark 0x20" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// TestListManagedRules_Error verifies list failures are surfaced
+func TestListManagedRules_Error(t *testing.T) {
+	useFakeChains(t, nil, errors.New("permission denied"))
+
+	if _, err := ListManagedRules(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// TestSplitQuoted verifies quoted comments stay in one field
+func TestSplitQuoted(t *testing.T) {
+	got := splitQuoted(`-m comment --comment "a \"b\" c"  -j MARK`)
+	want := []string{"-m", "comment", "--comment", `a "b" c`, "-j", "MARK"}
+	if len(got) != len(want) {
+		t.Fatalf("splitQuoted() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("field %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package iptables
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// lockHeldMessage is what iptables prints when it gave up waiting for the xtables lock
+// "Another app is currently holding the xtables lock. Stopped waiting after 5s."
+const lockHeldMessage = "holding the xtables lock"
+
+// lockTimeout bounds the wait for the xtables lock; 0 waits indefinitely
+var lockTimeout time.Duration
+
+// SetLockTimeout bounds how long every following iptables call of the process waits
+// for the xtables lock (rounded up to whole seconds); 0 restores the indefinite wait
+// A call whose context has a deadline waits at most until then. A call that gives up
+// fails with an error for which IsLocked reports true.
+func SetLockTimeout(d time.Duration) {
+	lockTimeout = d
+}
+
+// IsLocked reports whether err is an iptables failure caused by another process
+// holding the xtables lock for longer than the lock timeout
+func IsLocked(err error) bool {
+	return err != nil && strings.Contains(err.Error(), lockHeldMessage)
+}
+
+// waitArgs returns the iptables(8) arguments taking the xtables lock
+func waitArgs(ctx context.Context) []string {
+	if secs := lockWaitSeconds(ctx); secs > 0 {
+		return []string{"--wait", strconv.Itoa(secs)}
+	}
+	return []string{"--wait"}
+}
+
+// newIPTables initializes go-iptables with the lock wait of ctx
+func newIPTables(ctx context.Context) (*iptables.IPTables, error) {
+	if secs := lockWaitSeconds(ctx); secs > 0 {
+		return iptables.New(iptables.Timeout(secs))
+	}
+	return iptables.New()
+}
+
+// lockWaitSeconds returns how long a call under ctx waits for the xtables lock, in whole
+// seconds rounded up (0: indefinitely): lockTimeout, or the time left until the deadline
+// of ctx if that is shorter. A running iptables is never killed, so at least 1s is left.
+func lockWaitSeconds(ctx context.Context) int {
+	wait := lockTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); wait == 0 || left < wait {
+			wait = max(left, time.Second)
+		}
+	}
+	return int((wait + time.Second - 1) / time.Second)
+}
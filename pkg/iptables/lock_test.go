@@ -0,0 +1,69 @@
+package iptables
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsLocked(t *testing.T) {
+	locked := errors.New("running [/usr/sbin/iptables -t mangle -A PREROUTING --wait 5]: exit status 4: " +
+		"Another app is currently holding the xtables lock. Stopped waiting after 5s.")
+
+	if !IsLocked(fmt.Errorf("failed to add mark rule: %w", locked)) {
+		t.Error("IsLocked() = false for a lock timeout")
+	}
+	for _, err := range []error{nil, errors.New("exit status 1: Bad rule"), ErrUnsafeSource} {
+		if IsLocked(err) {
+			t.Errorf("IsLocked(%v) = true", err)
+		}
+	}
+}
+
+func TestWaitArgs(t *testing.T) {
+	t.Cleanup(func() { SetLockTimeout(0) })
+
+	tests := []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{0, "--wait"},
+		{5 * time.Second, "--wait 5"},
+		{1500 * time.Millisecond, "--wait 2"},
+	}
+	for _, tt := range tests {
+		SetLockTimeout(tt.timeout)
+		if got := strings.Join(waitArgs(context.Background()), " "); got != tt.want {
+			t.Errorf("waitArgs(context.Background()) with timeout %v = %q, want %q", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestWaitArgs_Deadline(t *testing.T) {
+	t.Cleanup(func() { SetLockTimeout(0) })
+
+	soon, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+	passed, cancelPassed := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelPassed()
+
+	tests := []struct {
+		timeout time.Duration
+		ctx     context.Context
+		want    string
+	}{
+		{0, soon, "--wait 3"},
+		{10 * time.Second, soon, "--wait 3"},
+		{time.Second, soon, "--wait 1"},
+		{0, passed, "--wait 1"},
+	}
+	for _, tt := range tests {
+		SetLockTimeout(tt.timeout)
+		if got := strings.Join(waitArgs(tt.ctx), " "); got != tt.want {
+			t.Errorf("waitArgs() with timeout %v = %q, want %q", tt.timeout, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package iptables
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
@@ -17,23 +19,117 @@ const (
 	// iptables configuration
 	tableNameMangle = "mangle"
 	chainPrerouting = "PREROUTING"
+
+	// MarkChain is the table/chain holding the per-pod MARK rules
+	MarkChain = tableNameMangle + "/" + chainPrerouting
 )
 
-// Manager handles iptables rules for tenant routing via fwmark
-// Provides idempotent operations for adding and removing marking rules
-type Manager struct {
+// DefaultRuleCache is the process-wide snapshot used by read-only paths (CHECK, GC)
+var DefaultRuleCache = NewRuleCache(DefaultCacheTTL)
+
+// Manager programs the per-pod MARK rules in mangle/PREROUTING (or the chain selected by Options)
+// cmdAdd/cmdDel depend on this interface so ADD/DEL logic can be unit-tested
+// without root against FakeManager. A method whose ctx is done fails with its error
+// before running iptables; the deadline of ctx bounds the xtables lock wait.
+type Manager interface {
+	// AddMarkRule installs the MARK rule for podIP; idempotent
+	AddMarkRule(ctx context.Context, podIP, fwmark string, opts ...MarkOption) error
+
+	// DeleteMarkRule removes the MARK rule for podIP; idempotent
+	DeleteMarkRule(ctx context.Context, podIP, fwmark string) error
+
+	// RuleExists reports whether the MARK rule for podIP with fwmark is installed
+	RuleExists(ctx context.Context, podIP, fwmark string) (bool, error)
+
+	// List returns every per-pod MARK rule currently installed
+	List(ctx context.Context) ([]MarkRule, error)
+
+	// ApplyRules makes the installed per-pod MARK rules equal to rules in one transaction
+	// (adds the missing ones only with KeepUnlisted)
+	ApplyRules(ctx context.Context, rules []MarkRule, opts ...MarkOption) error
+}
+
+// MarkRule is a per-pod MARK rule: -s PodIP -j MARK --set-mark Fwmark
+type MarkRule struct {
+	PodIP  string
+	Fwmark string
+
+	// Hook is where the rule is installed (see AtHook); "" is HookPrerouting
+	Hook string
+
+	// PodUID tags the rule when ApplyRules adds it (see PodUID); List leaves it empty
+	PodUID string
+}
+
+// NewManager returns the production Manager backed by the iptables binary
+// iptables is initialized per operation, so construction never fails
+func NewManager() Manager {
+	return iptablesManager{d: defaultPath}
+}
+
+// NewManagerWithOptions returns a Manager programming the MARK rules described by opts
+// For CNI plugins embedding the datapath; NewManagerWithOptions(Options{}) is NewManager().
+// Returns error if opts name an invalid table, chain, fwmark or rule tag.
+func NewManagerWithOptions(opts Options) (Manager, error) {
+	d, err := newDatapath(opts)
+	if err != nil {
+		return nil, err
+	}
+	return iptablesManager{d: d}, nil
+}
+
+// iptablesManager implements Manager on one datapath
+type iptablesManager struct {
+	d *datapath
+}
+
+func (m iptablesManager) AddMarkRule(ctx context.Context, podIP, fwmark string, opts ...MarkOption) error {
+	return m.d.addMarkRule(ctx, podIP, fwmark, opts...)
+}
+
+func (m iptablesManager) DeleteMarkRule(ctx context.Context, podIP, fwmark string) error {
+	return m.d.deleteMarkRule(ctx, podIP, fwmark)
+}
+
+func (m iptablesManager) RuleExists(ctx context.Context, podIP, fwmark string) (bool, error) {
+	return m.d.ruleExists(ctx, podIP, fwmark)
+}
+
+func (m iptablesManager) List(ctx context.Context) ([]MarkRule, error) {
+	entries, err := m.d.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]MarkRule, 0, len(entries))
+	for _, entry := range entries {
+		rules = append(rules, MarkRule{PodIP: entry.IP, Fwmark: formatMark(entry.Mark), Hook: chainHook(entry.Chain)})
+	}
+	return rules, nil
+}
+
+func (m iptablesManager) ApplyRules(ctx context.Context, rules []MarkRule, opts ...MarkOption) error {
+	return m.d.applyRules(ctx, rules, opts...)
+}
+
+// handle wraps an initialized iptables instance for a single operation
+type handle struct {
 	ipt *iptables.IPTables
 }
 
-// NewManager creates a new iptables manager instance
-// Returns error if iptables initialization fails (requires root/CAP_NET_ADMIN)
-func NewManager() (*Manager, error) {
-	ipt, err := iptables.New()
+// newHandle initializes iptables for one operation under ctx (see lockWaitSeconds)
+// Returns ctx's error if it is done, or an error if iptables initialization fails
+// (requires root/CAP_NET_ADMIN)
+func newHandle(ctx context.Context) (*handle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ipt, err := newIPTables(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize iptables: %w", err)
 	}
 
-	return &Manager{ipt: ipt}, nil
+	return &handle{ipt: ipt}, nil
 }
 
 // validateFwmark ensures fwmark value is allowed (prevents Cilium conflicts)
@@ -58,39 +154,56 @@ func validateFwmark(fwmark string) error {
 //
 //	err := mgr.AddMarkRule("10.200.1.5", "0x10")
 //	// Creates: iptables -t mangle -A PREROUTING -s 10.200.1.5 -j MARK --set-mark 0x10
-func AddMarkRule(podIP, fwmark string) error {
-	// Validate pod IP is not empty (before iptables initialization)
-	if strings.TrimSpace(podIP) == "" {
-		return fmt.Errorf("podIP cannot be empty")
-	}
+//
+// Sources that are node addresses, loopback or link-local are refused
+// (see CheckSourceSafety) unless AllowUnsafeSources is passed
+func AddMarkRule(ctx context.Context, podIP, fwmark string, opts ...MarkOption) error {
+	return defaultPath.addMarkRule(ctx, podIP, fwmark, opts...)
+}
 
-	// Security: Validate IP format to prevent injection attacks
-	if net.ParseIP(podIP) == nil {
-		return fmt.Errorf("invalid IP address format: %s", podIP)
+// addMarkRule implements AddMarkRule in the datapath's chain
+func (d *datapath) addMarkRule(ctx context.Context, podIP, fwmark string, opts ...MarkOption) error {
+	var options markOptions
+	for _, opt := range opts {
+		opt(&options)
 	}
 
-	// Security: Validate fwmark to prevent conflicts with Cilium (before iptables initialization)
-	if err := validateFwmark(fwmark); err != nil {
+	// Security: Validate IP format and fwmark (prevents injection and Cilium conflicts)
+	// and never mark node, loopback or link-local sources unless explicitly allowed
+	if err := d.validateRule(podIP, fwmark, options.allowUnsafeSources); err != nil {
+		return err
+	}
+	if options.podUID != "" && !podUIDPattern.MatchString(options.podUID) {
+		return fmt.Errorf("invalid pod UID %q", options.podUID)
+	}
+	comment := d.ruleComment(options.podUID)
+	if comment != "" && !commentPattern.MatchString(comment) {
+		return fmt.Errorf("rule tag %q exceeds 256 characters", comment)
+	}
+	chain, err := d.hookChain(options.hook)
+	if err != nil {
 		return err
 	}
 
-	// Initialize iptables manager (requires iptables binary and CAP_NET_ADMIN)
-	mgr, err := NewManager()
+	// Initialize iptables (requires iptables binary and CAP_NET_ADMIN)
+	mgr, err := newHandle(ctx)
 	if err != nil {
 		return err
 	}
+	if err := d.ensureChain(mgr); err != nil {
+		return err
+	}
 
 	// Build rule specification
-	rulespec := []string{
-		"-s", podIP,
-		"-j", "MARK",
-		"--set-mark", fwmark,
-	}
+	rulespec := d.rulespec(podIP, fwmark, comment, options.newConnectionsOnly)
+
+	// Invalidate cached snapshots whatever the outcome
+	defer mutationGeneration.Add(1)
 
 	// Use AppendUnique for atomic idempotent operation
 	// This avoids TOCTOU race between Exists() and Append() calls
 	// AppendUnique checks and appends atomically - succeeds if rule already exists
-	if err := mgr.ipt.AppendUnique(tableNameMangle, chainPrerouting, rulespec...); err != nil {
+	if err := mgr.ipt.AppendUnique(d.table, chain, rulespec...); err != nil {
 		return fmt.Errorf("failed to add mark rule for podIP %s with fwmark %s: %w", podIP, fwmark, err)
 	}
 
@@ -104,42 +217,43 @@ func AddMarkRule(podIP, fwmark string) error {
 //   - true, nil: Rule exists
 //   - false, nil: Rule does not exist
 //   - false, err: Error checking rule existence
-func RuleExists(podIP, fwmark string) (bool, error) {
-	// Validate pod IP is not empty
-	if strings.TrimSpace(podIP) == "" {
-		return false, fmt.Errorf("podIP cannot be empty")
-	}
+func RuleExists(ctx context.Context, podIP, fwmark string) (bool, error) {
+	return defaultPath.ruleExists(ctx, podIP, fwmark)
+}
 
-	// Security: Validate IP format
-	if net.ParseIP(podIP) == nil {
-		return false, fmt.Errorf("invalid IP address format: %s", podIP)
+// ruleExists implements RuleExists in the datapath's chain
+func (d *datapath) ruleExists(ctx context.Context, podIP, fwmark string) (bool, error) {
+	// Security: Validate IP format and fwmark
+	if err := validatePodIP(podIP); err != nil {
+		return false, err
 	}
-
-	// Security: Validate fwmark
-	if err := validateFwmark(fwmark); err != nil {
+	if err := d.validateMark(fwmark); err != nil {
 		return false, err
 	}
 
-	// Initialize iptables manager
-	mgr, err := NewManager()
+	// Initialize iptables
+	mgr, err := newHandle(ctx)
 	if err != nil {
 		return false, err
 	}
 
-	// Build rule specification
-	rulespec := []string{
-		"-s", podIP,
-		"-j", "MARK",
-		"--set-mark", fwmark,
+	// Check if the rule exists, marking every packet or new connections only
+	for _, newOnly := range []bool{false, true} {
+		exists, err := mgr.ipt.Exists(d.table, d.chain, d.rulespec(podIP, fwmark, d.comment, newOnly)...)
+		if err != nil {
+			return false, fmt.Errorf("failed to check if rule exists for podIP %s: %w", podIP, err)
+		}
+		if exists {
+			return true, nil
+		}
 	}
 
-	// Check if rule exists
-	exists, err := mgr.ipt.Exists(tableNameMangle, chainPrerouting, rulespec...)
+	// A rule tagged with a pod UID or installed at another hook does not match the spec
+	listed, err := d.listedRules(ctx, podIP, fwmark)
 	if err != nil {
-		return false, fmt.Errorf("failed to check if rule exists for podIP %s: %w", podIP, err)
+		return false, err
 	}
-
-	return exists, nil
+	return len(listed) > 0, nil
 }
 
 // DeleteMarkRule removes iptables rule that marks packets from podIP with fwmark
@@ -150,41 +264,134 @@ func RuleExists(podIP, fwmark string) (bool, error) {
 //
 //	err := mgr.DeleteMarkRule("10.200.1.5", "0x10")
 //	// Removes: iptables -t mangle -D PREROUTING -s 10.200.1.5 -j MARK --set-mark 0x10
-func DeleteMarkRule(podIP, fwmark string) error {
-	// Validate pod IP is not empty (before iptables initialization)
-	if strings.TrimSpace(podIP) == "" {
-		return fmt.Errorf("podIP cannot be empty")
-	}
+func DeleteMarkRule(ctx context.Context, podIP, fwmark string) error {
+	return defaultPath.deleteMarkRule(ctx, podIP, fwmark)
+}
 
-	// Security: Validate IP format to prevent injection attacks
-	if net.ParseIP(podIP) == nil {
-		return fmt.Errorf("invalid IP address format: %s", podIP)
+// deleteMarkRule implements DeleteMarkRule in the datapath's chain
+func (d *datapath) deleteMarkRule(ctx context.Context, podIP, fwmark string) error {
+	// Security: Validate IP format and fwmark to prevent accidental deletion of
+	// system rules (before iptables initialization)
+	if err := validatePodIP(podIP); err != nil {
+		return err
 	}
-
-	// Security: Validate fwmark to prevent accidental deletion of system rules (before iptables initialization)
-	if err := validateFwmark(fwmark); err != nil {
+	if err := d.validateMark(fwmark); err != nil {
 		return err
 	}
 
-	// Initialize iptables manager (requires iptables binary and CAP_NET_ADMIN)
-	mgr, err := NewManager()
+	// Initialize iptables (requires iptables binary and CAP_NET_ADMIN)
+	mgr, err := newHandle(ctx)
 	if err != nil {
 		return err
 	}
 
-	// Build rule specification
-	rulespec := []string{
-		"-s", podIP,
-		"-j", "MARK",
-		"--set-mark", fwmark,
+	// A user-defined chain that was never created holds nothing to delete
+	if !builtinChains[d.chain] {
+		if exists, err := mgr.ipt.ChainExists(d.table, d.chain); err == nil && !exists {
+			return nil
+		}
 	}
 
+	// Invalidate cached snapshots whatever the outcome
+	defer mutationGeneration.Add(1)
+
 	// Delete the rule directly without checking existence first
 	// This avoids TOCTOU race between Exists() and Delete() calls
-	// DeleteIfExists handles "rule not found" gracefully (idempotent behavior)
-	if err := mgr.ipt.DeleteIfExists(tableNameMangle, chainPrerouting, rulespec...); err != nil {
-		return fmt.Errorf("failed to delete mark rule for podIP %s with fwmark %s: %w", podIP, fwmark, err)
+	// DeleteIfExists handles "rule not found" gracefully (idempotent behavior);
+	// the rule may mark every packet or new connections only
+	for _, newOnly := range []bool{false, true} {
+		if err := mgr.ipt.DeleteIfExists(d.table, d.chain, d.rulespec(podIP, fwmark, d.comment, newOnly)...); err != nil {
+			return fmt.Errorf("failed to delete mark rule for podIP %s with fwmark %s: %w", podIP, fwmark, err)
+		}
+	}
+
+	// Rules tagged with a pod UID are deleted whatever the UID, and rules at other hooks
+	// whatever the hook: DEL and GC may not know either
+	listed, err := d.listedRules(ctx, podIP, fwmark)
+	if err != nil {
+		return err
+	}
+	for _, entry := range listed {
+		if err := mgr.ipt.DeleteIfExists(d.table, d.entryChain(entry), d.rulespec(entry.IP, fwmark, entry.Comment, entry.NewOnly)...); err != nil {
+			return fmt.Errorf("failed to delete mark rule for podIP %s with fwmark %s: %w", podIP, fwmark, err)
+		}
 	}
 
 	return nil
 }
+
+// listedRules returns the rules for podIP with fwmark that the spec of the datapath's
+// chain does not match: tagged with a pod UID, or installed at another hook
+func (d *datapath) listedRules(ctx context.Context, podIP, fwmark string) ([]markEntry, error) {
+	entries, err := d.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	want := markEntry{IP: net.ParseIP(podIP).String(), Mark: mustParseMark(fwmark)}
+	var listed []markEntry
+	for _, entry := range entries {
+		if entry.key() == want && (d.podUIDTagged(entry.Comment) || entry.Chain != "") {
+			listed = append(listed, entry)
+		}
+	}
+	return listed, nil
+}
+
+// CountMarkRules returns the number of MARK rules that set fwmark, at any hook (see AtHook)
+// Used to detect when the last pod of a tenant leaves the node so shared
+// per-tenant state (policy routing) can be removed
+func CountMarkRules(ctx context.Context, fwmark string) (int, error) {
+	if err := validateFwmark(fwmark); err != nil {
+		return 0, err
+	}
+
+	entries, err := listMarkRulesFunc(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	want := mustParseMark(fwmark)
+	count := 0
+	for _, entry := range entries {
+		if entry.Mark == want {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// parseMark parses a fwmark string ("0x10", "0X10", "16") into its numeric value
+func parseMark(fwmark string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSpace(fwmark), 0, 32)
+}
+
+// formatMark renders a mark value the way annotations spell it ("0x10")
+func formatMark(mark uint64) string {
+	return fmt.Sprintf("%#x", mark)
+}
+
+// parseMarkTarget extracts the mark value from an iptables-save style rule
+// iptables normalizes "--set-mark 0x10" to "--set-xmark 0x10/0xffffffff" when listing
+func parseMarkTarget(rule string) (uint64, bool) {
+	fields := strings.Fields(rule)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] != "--set-xmark" && fields[i] != "--set-mark" {
+			continue
+		}
+		value := fields[i+1]
+		if slash := strings.IndexByte(value, '/'); slash >= 0 {
+			// Only full-mask marks are ours; masked marks belong to other agents
+			if mask, err := strconv.ParseUint(value[slash+1:], 0, 32); err != nil || mask != 0xffffffff {
+				return 0, false
+			}
+			value = value[:slash]
+		}
+		mark, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return 0, false
+		}
+		return mark, true
+	}
+	return 0, false
+}
@@ -1,6 +1,7 @@
 package iptables
 
 import (
+	"context"
 	"testing"
 )
 
@@ -125,7 +126,7 @@ func TestAddMarkRule_Validation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := AddMarkRule(tt.podIP, tt.fwmark)
+			err := AddMarkRule(context.Background(), tt.podIP, tt.fwmark)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AddMarkRule(%q, %q) error = %v, wantErr %v", tt.podIP, tt.fwmark, err, tt.wantErr)
 				return
@@ -175,7 +176,7 @@ func TestRuleExists_Validation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := RuleExists(tt.podIP, tt.fwmark)
+			_, err := RuleExists(context.Background(), tt.podIP, tt.fwmark)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("RuleExists(%q, %q) error = %v, wantErr %v", tt.podIP, tt.fwmark, err, tt.wantErr)
 				return
@@ -238,7 +239,7 @@ func TestDeleteMarkRule_Validation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := DeleteMarkRule(tt.podIP, tt.fwmark)
+			err := DeleteMarkRule(context.Background(), tt.podIP, tt.fwmark)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DeleteMarkRule(%q, %q) error = %v, wantErr %v", tt.podIP, tt.fwmark, err, tt.wantErr)
 				return
@@ -254,6 +255,56 @@ func TestDeleteMarkRule_Validation(t *testing.T) {
 	}
 }
 
+// TestParseMarkTarget tests parsing mark values from iptables-save style rules
+func TestParseMarkTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		want   uint64
+		wantOK bool
+	}{
+		{
+			name:   "normalized xmark",
+			rule:   "-A PREROUTING -s 10.200.1.5/32 -j MARK --set-xmark 0x10/0xffffffff",
+			want:   0x10,
+			wantOK: true,
+		},
+		{
+			name:   "legacy set-mark",
+			rule:   "-A PREROUTING -s 10.200.1.6/32 -j MARK --set-mark 0x20",
+			want:   0x20,
+			wantOK: true,
+		},
+		{
+			name:   "masked mark from another agent",
+			rule:   "-A PREROUTING -j MARK --set-xmark 0x200/0xf00",
+			wantOK: false,
+		},
+		{
+			name:   "non-mark rule",
+			rule:   "-A PREROUTING -s 10.200.1.5/32 -j ACCEPT",
+			wantOK: false,
+		},
+		{
+			name:   "chain policy line",
+			rule:   "-P PREROUTING ACCEPT",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseMarkTarget(tt.rule)
+			if ok != tt.wantOK {
+				t.Fatalf("parseMarkTarget(%q) ok = %v, want %v", tt.rule, ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("parseMarkTarget(%q) = %#x, want %#x", tt.rule, got, tt.want)
+			}
+		})
+	}
+}
+
 // contains checks if s contains substr (case-sensitive)
 func contains(s, substr string) bool {
 	return len(substr) > 0 && len(s) >= len(substr) && (s == substr || len(s) > len(substr) && containsHelper(s, substr))
@@ -268,6 +319,25 @@ func containsHelper(s, substr string) bool {
 	return false
 }
 
+// TestManager_List verifies the production Manager renders listed rules
+func TestManager_List(t *testing.T) {
+	useFakeLister(t, markEntry{IP: "10.200.1.5", Mark: 0x10}, markEntry{IP: "10.200.1.6", Mark: 0x20})
+
+	rules, err := NewManager().List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []MarkRule{{PodIP: "10.200.1.5", Fwmark: "0x10"}, {PodIP: "10.200.1.6", Fwmark: "0x20"}}
+	if len(rules) != len(want) {
+		t.Fatalf("List() = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("List()[%d] = %v, want %v", i, rules[i], want[i])
+		}
+	}
+}
+
 // Note: Integration tests for actual iptables operations require:
 // 1. Root/CAP_NET_ADMIN permissions
 // 2. Network namespace isolation
@@ -287,7 +357,7 @@ func containsHelper(s, substr string) bool {
 //     defer ns.Close()
 //
 //     // Test 1: Add rule first time
-//     err = AddMarkRule("10.200.1.5", "0x10")
+//     err = AddMarkRule(context.Background(), "10.200.1.5", "0x10")
 //     if err != nil {
 //         t.Fatalf("First AddMarkRule failed: %v", err)
 //     }
@@ -296,7 +366,7 @@ func containsHelper(s, substr string) bool {
 //     // iptables -t mangle -C PREROUTING -s 10.200.1.5 -j MARK --set-mark 0x10
 //
 //     // Test 2: Add same rule again (idempotency)
-//     err = AddMarkRule("10.200.1.5", "0x10")
+//     err = AddMarkRule(context.Background(), "10.200.1.5", "0x10")
 //     if err != nil {
 //         t.Errorf("Second AddMarkRule (idempotent) failed: %v", err)
 //     }
@@ -305,7 +375,7 @@ func containsHelper(s, substr string) bool {
 //     // iptables -t mangle -S PREROUTING | grep "10.200.1.5" | wc -l should be 1
 //
 //     // Test 3: Delete rule
-//     err = DeleteMarkRule("10.200.1.5", "0x10")
+//     err = DeleteMarkRule(context.Background(), "10.200.1.5", "0x10")
 //     if err != nil {
 //         t.Errorf("DeleteMarkRule failed: %v", err)
 //     }
@@ -314,7 +384,7 @@ func containsHelper(s, substr string) bool {
 //     // iptables -t mangle -C PREROUTING -s 10.200.1.5 -j MARK --set-mark 0x10 should fail
 //
 //     // Test 4: Delete non-existent rule (idempotency)
-//     err = DeleteMarkRule("10.200.1.5", "0x10")
+//     err = DeleteMarkRule(context.Background(), "10.200.1.5", "0x10")
 //     if err != nil {
 //         t.Errorf("DeleteMarkRule (idempotent) failed: %v", err)
 //     }
@@ -330,18 +400,18 @@ func containsHelper(s, substr string) bool {
 //     defer ns.Close()
 //
 //     // Add rules for both tenants
-//     AddMarkRule("10.200.1.5", "0x10")  // Tenant A
-//     AddMarkRule("10.200.1.6", "0x20")  // Tenant B
+//     AddMarkRule(context.Background(), "10.200.1.5", "0x10")  // Tenant A
+//     AddMarkRule(context.Background(), "10.200.1.6", "0x20")  // Tenant B
 //
 //     // Verify both rules exist independently
 //     // iptables -t mangle -S PREROUTING should show both
 //
 //     // Delete Tenant A rule
-//     DeleteMarkRule("10.200.1.5", "0x10")
+//     DeleteMarkRule(context.Background(), "10.200.1.5", "0x10")
 //
 //     // Verify Tenant B rule still exists
 //     // iptables -t mangle -C PREROUTING -s 10.200.1.6 -j MARK --set-mark 0x20 should succeed
 //
 //     // Cleanup
-//     DeleteMarkRule("10.200.1.6", "0x20")
+//     DeleteMarkRule(context.Background(), "10.200.1.6", "0x20")
 // }
@@ -0,0 +1,88 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+)
+
+// outputRulespec returns the destination-based MARK rule for host-originated traffic to a pod
+func outputRulespec(podIP, fwmark string) []string {
+	return []string{
+		"-d", podIP,
+		"-j", "MARK",
+		"--set-mark", fwmark,
+	}
+}
+
+// AddOutputMarkRule marks host-originated traffic toward podIP with fwmark
+// Node-generated packets (kubelet probes, hostNetwork clients) never traverse
+// PREROUTING, so they are classified in mangle/OUTPUT by destination instead
+// Idempotent: succeeds if rule already exists
+// Rule format: iptables -t mangle -A OUTPUT -d podIP -j MARK --set-mark fwmark
+func AddOutputMarkRule(ctx context.Context, podIP, fwmark string) error {
+	if err := validatePodIP(podIP); err != nil {
+		return err
+	}
+	if err := validateFwmark(fwmark); err != nil {
+		return err
+	}
+
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer mutationGeneration.Add(1)
+
+	if err := mgr.ipt.AppendUnique(tableNameMangle, chainOutput, outputRulespec(podIP, fwmark)...); err != nil {
+		return fmt.Errorf("failed to add output mark rule for podIP %s with fwmark %s: %w", podIP, fwmark, err)
+	}
+
+	return nil
+}
+
+// DeleteOutputMarkRule removes the host-originated traffic rule for podIP
+// Idempotent: succeeds even if rule does not exist
+func DeleteOutputMarkRule(ctx context.Context, podIP, fwmark string) error {
+	if err := validatePodIP(podIP); err != nil {
+		return err
+	}
+	if err := validateFwmark(fwmark); err != nil {
+		return err
+	}
+
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer mutationGeneration.Add(1)
+
+	if err := mgr.ipt.DeleteIfExists(tableNameMangle, chainOutput, outputRulespec(podIP, fwmark)...); err != nil {
+		return fmt.Errorf("failed to delete output mark rule for podIP %s with fwmark %s: %w", podIP, fwmark, err)
+	}
+
+	return nil
+}
+
+// OutputMarkRuleExists checks whether the host-originated traffic rule for podIP is installed
+func OutputMarkRuleExists(ctx context.Context, podIP, fwmark string) (bool, error) {
+	if err := validatePodIP(podIP); err != nil {
+		return false, err
+	}
+	if err := validateFwmark(fwmark); err != nil {
+		return false, err
+	}
+
+	mgr, err := newHandle(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	exists, err := mgr.ipt.Exists(tableNameMangle, chainOutput, outputRulespec(podIP, fwmark)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to check output mark rule for podIP %s: %w", podIP, err)
+	}
+
+	return exists, nil
+}
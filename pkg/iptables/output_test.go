@@ -0,0 +1,44 @@
+package iptables
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestOutputRulespec verifies the destination-based rule format
+func TestOutputRulespec(t *testing.T) {
+	got := strings.Join(outputRulespec("10.200.1.5", "0x10"), " ")
+	want := "-d 10.200.1.5 -j MARK --set-mark 0x10"
+	if got != want {
+		t.Errorf("outputRulespec() = %q, want %q", got, want)
+	}
+}
+
+// TestOutputMarkRule_Validation tests input validation before iptables initialization
+func TestOutputMarkRule_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		podIP  string
+		fwmark string
+		errMsg string
+	}{
+		{name: "empty pod IP", podIP: "", fwmark: "0x10", errMsg: "podIP cannot be empty"},
+		{name: "invalid IP", podIP: "10.0.0.1; rm -rf /", fwmark: "0x10", errMsg: "invalid IP address format"},
+		{name: "invalid fwmark", podIP: "10.200.1.5", fwmark: "0x99", errMsg: "invalid fwmark"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := AddOutputMarkRule(context.Background(), tt.podIP, tt.fwmark); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("AddOutputMarkRule() error = %v, want %q", err, tt.errMsg)
+			}
+			if err := DeleteOutputMarkRule(context.Background(), tt.podIP, tt.fwmark); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("DeleteOutputMarkRule() error = %v, want %q", err, tt.errMsg)
+			}
+			if _, err := OutputMarkRuleExists(context.Background(), tt.podIP, tt.fwmark); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("OutputMarkRuleExists() error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package iptables
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Rule is a declarative description of one iptables rule managed for a pod
+type Rule struct {
+	Table    string
+	Chain    string
+	Rulespec []string
+}
+
+// String renders the rule in iptables(8) append syntax
+func (r Rule) String() string {
+	return "-t " + r.Table + " -A " + r.Chain + " " + strings.Join(r.Rulespec, " ")
+}
+
+// PodRuleOptions selects the optional rule sets installed for a pod
+type PodRuleOptions struct {
+	// Connmark adds CONNMARK save/restore rules (see AddConnmarkRules)
+	Connmark bool
+
+	// MarkHostTraffic adds the mangle/OUTPUT destination rule (see AddOutputMarkRule)
+	MarkHostTraffic bool
+
+	// NewConnectionsOnly marks only new connections (see NewConnectionsOnly); with
+	// Connmark the CONNMARK rules are those of AddNewConnectionConnmarkRules
+	NewConnectionsOnly bool
+
+	// Hook selects the chain of the MARK rule (see AtHook); "" is HookPrerouting.
+	// An unknown hook, which AddMarkRule would refuse, renders as HookPrerouting.
+	Hook string
+}
+
+// PodRules returns every rule the plugin installs for a pod, in installation order
+// The specs are identical to what AddMarkRule, AddConnmarkRules and AddOutputMarkRule program
+func PodRules(podIP, fwmark string, opts PodRuleOptions) []Rule {
+	chain, err := HookChain(opts.Hook)
+	if err != nil {
+		chain = chainPrerouting
+	}
+	rules := []Rule{
+		{Table: tableNameMangle, Chain: chain, Rulespec: markRulespec(podIP, fwmark, opts.NewConnectionsOnly)},
+	}
+	if opts.Connmark {
+		for _, rule := range connmarkRules(podIP, opts.NewConnectionsOnly) {
+			rules = append(rules, Rule{Table: tableNameMangle, Chain: rule.chain, Rulespec: rule.rulespec})
+		}
+	}
+	if opts.MarkHostTraffic {
+		rules = append(rules, Rule{Table: tableNameMangle, Chain: chainOutput, Rulespec: outputRulespec(podIP, fwmark)})
+	}
+	return rules
+}
+
+// podUIDCommentPrefix starts the rule comment naming the pod a MARK rule was added for
+const podUIDCommentPrefix = "pod-uid:"
+
+// podUIDPattern restricts pod UIDs (RFC 4122 UUIDs as assigned by the API server)
+var podUIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+// PodUID tags the MARK rule with the comment "pod-uid:<uid>"
+// StatefulSet pods reuse names; the UID tells which incarnation a rule was added for.
+// Deletion, listing and batch apply match rules with or without the tag, so tagged
+// and untagged rules can coexist. An empty uid adds no tag.
+func PodUID(uid string) MarkOption {
+	return func(o *markOptions) {
+		o.podUID = uid
+	}
+}
+
+// NewConnectionsOnly restricts the MARK rule to packets opening a connection
+// (-m conntrack --ctstate NEW). Together with the CONNMARK rules of
+// AddNewConnectionConnmarkRules, established connections keep the mark they were
+// opened with, so moving a pod to another tenant or gateway reroutes new
+// connections only. Deletion, existence checks and listing match rules with or
+// without the restriction.
+func NewConnectionsOnly() MarkOption {
+	return func(o *markOptions) {
+		o.newConnectionsOnly = true
+	}
+}
+
+// ctstateNew matches the first packet of a connection
+var ctstateNew = []string{"-m", "conntrack", "--ctstate", "NEW"}
+
+// markRulespec returns the source-based PREROUTING MARK rule, for new connections
+// only if newOnly is set
+func markRulespec(podIP, fwmark string, newOnly bool) []string {
+	rulespec := []string{"-s", podIP}
+	if newOnly {
+		rulespec = append(rulespec, ctstateNew...)
+	}
+	return append(rulespec, "-j", "MARK", "--set-mark", fwmark)
+}
+
+// isNewOnly reports whether an iptables-save style rule matches new connections only
+func isNewOnly(rule string) bool {
+	fields := strings.Fields(rule)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "--ctstate" {
+			return fields[i+1] == "NEW"
+		}
+	}
+	return false
+}
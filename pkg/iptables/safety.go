@@ -0,0 +1,89 @@
+package iptables
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// MarkOption customizes AddMarkRule behavior
+type MarkOption func(*markOptions)
+
+type markOptions struct {
+	allowUnsafeSources bool
+	podUID             string
+	newConnectionsOnly bool
+	hook               string
+	keepUnlisted       bool
+}
+
+// AllowUnsafeSources disables the source safety checks in AddMarkRule
+// Only for lab setups that intentionally mark node, loopback or link-local traffic
+func AllowUnsafeSources() MarkOption {
+	return func(o *markOptions) {
+		o.allowUnsafeSources = true
+	}
+}
+
+// ErrUnsafeSource classifies CheckSourceSafety refusals (use errors.Is)
+var ErrUnsafeSource = errors.New("unsafe source address")
+
+// unsafeSourceError keeps the descriptive message while unwrapping to ErrUnsafeSource
+type unsafeSourceError struct {
+	msg string
+}
+
+func (e *unsafeSourceError) Error() string { return e.msg }
+func (e *unsafeSourceError) Unwrap() error { return ErrUnsafeSource }
+
+// refuse builds an unsafe source error
+func refuse(format string, args ...interface{}) error {
+	return &unsafeSourceError{msg: fmt.Sprintf(format, args...)}
+}
+
+// nodeAddrsFunc returns the addresses configured on the node; replaced in tests
+var nodeAddrsFunc = net.InterfaceAddrs
+
+// CheckSourceSafety refuses source addresses that must never be tenant-marked:
+// unspecified, loopback, link-local (169.254.0.0/16, fe80::/10), multicast,
+// and any address configured on the node itself.
+//
+// A buggy annotation combined with a delegate misreporting its result must not be
+// able to reroute node or metadata-service (169.254.169.254) traffic.
+func CheckSourceSafety(podIP string) error {
+	ip := net.ParseIP(podIP)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address format: %s", podIP)
+	}
+
+	switch {
+	case ip.IsUnspecified():
+		return refuse("refusing to mark unspecified source %s", podIP)
+	case ip.IsLoopback():
+		return refuse("refusing to mark loopback source %s", podIP)
+	case ip.IsLinkLocalUnicast():
+		return refuse("refusing to mark link-local source %s", podIP)
+	case ip.IsMulticast(), ip.Equal(net.IPv4bcast):
+		return refuse("refusing to mark multicast/broadcast source %s", podIP)
+	}
+
+	addrs, err := nodeAddrsFunc()
+	if err != nil {
+		// Fail closed: without the node address list we cannot prove the source is safe
+		return fmt.Errorf("cannot verify %s is not a node address: %w", podIP, err)
+	}
+	for _, addr := range addrs {
+		var nodeIP net.IP
+		switch a := addr.(type) {
+		case *net.IPNet:
+			nodeIP = a.IP
+		case *net.IPAddr:
+			nodeIP = a.IP
+		}
+		if nodeIP != nil && nodeIP.Equal(ip) {
+			return refuse("refusing to mark node address %s", podIP)
+		}
+	}
+
+	return nil
+}
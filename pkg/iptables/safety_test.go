@@ -0,0 +1,97 @@
+package iptables
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// useNodeAddrs swaps the node address lookup for the duration of a test
+func useNodeAddrs(t *testing.T, addrs []net.Addr, err error) {
+	t.Helper()
+	orig := nodeAddrsFunc
+	nodeAddrsFunc = func() ([]net.Addr, error) { return addrs, err }
+	t.Cleanup(func() { nodeAddrsFunc = orig })
+}
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %v", cidr, err)
+	}
+	ipnet.IP = ip
+	return ipnet
+}
+
+// TestCheckSourceSafety tests refusal of node, loopback and link-local sources
+func TestCheckSourceSafety(t *testing.T) {
+	useNodeAddrs(t, []net.Addr{
+		mustCIDR(t, "127.0.0.1/8"),
+		mustCIDR(t, "10.10.10.5/24"),
+		mustCIDR(t, "10.200.0.1/32"),
+	}, nil)
+
+	tests := []struct {
+		name   string
+		podIP  string
+		errMsg string
+	}{
+		{name: "regular pod IP", podIP: "10.200.1.5"},
+		{name: "ipv6 pod IP", podIP: "fd00:200::5"},
+		{name: "metadata service", podIP: "169.254.169.254", errMsg: "link-local"},
+		{name: "ipv6 link-local", podIP: "fe80::1", errMsg: "link-local"},
+		{name: "loopback", podIP: "127.0.0.1", errMsg: "loopback"},
+		{name: "unspecified", podIP: "0.0.0.0", errMsg: "unspecified"},
+		{name: "multicast", podIP: "224.0.0.5", errMsg: "multicast"},
+		{name: "node address", podIP: "10.10.10.5", errMsg: "node address"},
+		{name: "ptp host-side gateway", podIP: "10.200.0.1", errMsg: "node address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSourceSafety(tt.podIP)
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("CheckSourceSafety(%q) error = %v, want %q", tt.podIP, err, tt.errMsg)
+			}
+			if !errors.Is(err, ErrUnsafeSource) {
+				t.Errorf("CheckSourceSafety(%q) error = %v, want errors.Is ErrUnsafeSource", tt.podIP, err)
+			}
+		})
+	}
+}
+
+// TestCheckSourceSafety_FailsClosed verifies an address lookup failure refuses the source
+func TestCheckSourceSafety_FailsClosed(t *testing.T) {
+	useNodeAddrs(t, nil, fmt.Errorf("netlink unavailable"))
+
+	err := CheckSourceSafety("10.200.1.5")
+	if err == nil || !strings.Contains(err.Error(), "cannot verify") {
+		t.Errorf("expected fail-closed error, got: %v", err)
+	}
+}
+
+// TestAddMarkRule_UnsafeSource verifies AddMarkRule refuses unsafe sources before iptables initialization
+func TestAddMarkRule_UnsafeSource(t *testing.T) {
+	useNodeAddrs(t, nil, nil)
+
+	err := AddMarkRule(context.Background(), "169.254.169.254", "0x10")
+	if err == nil || !strings.Contains(err.Error(), "refusing to mark link-local source") {
+		t.Errorf("expected link-local refusal, got: %v", err)
+	}
+
+	// With the override the safety check is skipped; any error now comes from iptables itself
+	err = AddMarkRule(context.Background(), "169.254.169.254", "0x10", AllowUnsafeSources())
+	if err != nil && strings.Contains(err.Error(), "refusing to mark") {
+		t.Errorf("AllowUnsafeSources did not bypass safety check: %v", err)
+	}
+}
@@ -5,76 +5,347 @@ import (
 	"fmt"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
 )
 
 // K8sAPITimeout is the maximum time allowed for Kubernetes API calls
 // CNI operations are time-sensitive; prevents hanging if API is slow/unreachable
-const K8sAPITimeout = 5 * time.Second
+const K8sAPITimeout = tenant.DefaultAPITimeout
+
+// BypassAnnotationKey is the pod annotation that temporarily exempts a pod from marking
+// Value is an RFC3339 timestamp; the MARK rule is removed until then and re-applied afterwards
+const BypassAnnotationKey = api.BypassAnnotationKey
+
+// MaxBypassDuration bounds how far in the future a bypass may end
+// Longer bypasses are rejected so a forgotten annotation cannot exempt a pod indefinitely
+const MaxBypassDuration = api.MaxBypassDuration
+
+// StrictAnnotationKey is the namespace annotation overriding the configured strict mode
+// Value "true" or "false"; only read from the namespace so a pod cannot opt itself out
+const StrictAnnotationKey = api.StrictAnnotationKey
+
+// nowFunc returns the current time; replaced in tests
+var nowFunc = time.Now
+
+// Sentinels classifying annotation validation failures (use errors.Is)
+var (
+	ErrInvalidFwmark  = api.ErrInvalidFwmark
+	ErrInvalidGateway = api.ErrInvalidGateway
+)
 
 // ValidFwmarkValues defines the allowed fwmark values for tenant routing
-var ValidFwmarkValues = map[string]bool{
-	"0x10": true, // Tenant A
-	"0x20": true, // Tenant B
-}
+var ValidFwmarkValues = api.ValidFwmarkValues
 
 // GetFwmark retrieves the fwmark annotation value with pod → namespace fallback.
+// Callers that go on to program rules or routes should use ResolveTenant instead.
 //
 // Resolution order:
-//  1. Check pod.Annotations[annotationKey]
-//  2. If not found, check namespace.Annotations[annotationKey]
+//  1. Check the pod annotations, for each of annotationKeys in order
+//  2. If not found, check the namespace annotations the same way
 //  3. If still not found, return empty string (valid no-op case)
 //
 // Returns:
-//   - fwmark value ('0x10', '0x20', or '') on success
+//   - fwmark value ("0x10", "0x20" or "") on success
 //   - error if pod/namespace API calls fail or fwmark value is invalid
-func GetFwmark(clientset kubernetes.Interface, podName, podNamespace, annotationKey string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), K8sAPITimeout)
+func GetFwmark(ctx context.Context, clientset kubernetes.Interface, podName, podNamespace string,
+	annotationKeys []string) (string, error) {
+	annotations, err := GetRoutingAnnotations(ctx, clientset, podName, podNamespace, annotationKeys, "")
+	if err != nil {
+		return "", err
+	}
+	return annotations.Fwmark, nil
+}
+
+// RoutingAnnotations holds the tenant routing annotations resolved for a pod (see
+// tenant.RoutingAnnotations)
+type RoutingAnnotations = tenant.RoutingAnnotations
+
+// GetRoutingAnnotations resolves the fwmark and gateway annotations with pod → namespace fallback.
+//
+// Each key is resolved independently (pod first, then namespace), so a namespace can
+// set the tenant fwmark while a single pod overrides only the gateway.
+// The namespace is fetched only if at least one key is missing on the pod.
+// An empty gatewayKey disables gateway resolution. fwmarkKeys are in priority order;
+// on each object the first key set wins (see fwmarkAnnotation).
+//
+// Instead of the fwmark, a pod or namespace may name its tenant with
+// TenantNameAnnotationKey; the fwmark and, unless annotated on the same object, the
+// gateway then come from the tenant's TenantRoute. A fwmark annotation wins over a
+// tenant name on the same object. Without either, the namespace labels may select the
+// fwmark (see SetNamespaceLabelRules), and then the plugin configuration (see
+// SetStaticTenants), which also answers for namespaces it lists while the API server
+// cannot be reached.
+//
+// The lookups end when ctx is done or after K8sAPITimeout, whichever comes first.
+// Returns error if pod/namespace API calls fail or an annotation value is invalid
+func GetRoutingAnnotations(ctx context.Context, clientset kubernetes.Interface, podName, podNamespace string,
+	fwmarkKeys []string, gatewayKey string) (RoutingAnnotations, error) {
+	return GetRoutingAnnotationsWithTimeout(ctx, clientset, podName, podNamespace, fwmarkKeys, gatewayKey, K8sAPITimeout)
+}
+
+// GetRoutingAnnotationsWithTimeout is GetRoutingAnnotations with an explicit timeout
+// covering both the pod and the namespace lookup (see APITimeout)
+func GetRoutingAnnotationsWithTimeout(ctx context.Context, clientset kubernetes.Interface, podName, podNamespace string,
+	fwmarkKeys []string, gatewayKey string, timeout time.Duration) (RoutingAnnotations, error) {
+	return GetRoutingAnnotationsCached(ctx, clientset, nil, podName, podNamespace, "", fwmarkKeys, gatewayKey, timeout)
+}
+
+// GetRoutingAnnotationsCached is GetRoutingAnnotationsWithTimeout consulting cache first
+// A fresh pod entry (of podUID, if set) answers without any API call; on a miss the pod
+// is fetched and only the namespace may still come from the cache. Successful lookups
+// are stored, errors never are. A nil cache always goes to the API server.
+func GetRoutingAnnotationsCached(ctx context.Context, clientset kubernetes.Interface, cache *AnnotationCache, podName, podNamespace, podUID string,
+	fwmarkKeys []string, gatewayKey string, timeout time.Duration) (RoutingAnnotations, error) {
+	if ExcludedNamespace(podNamespace) {
+		return RoutingAnnotations{Excluded: true}, nil
+	}
+	if cached, ok := cache.Pod(podNamespace, podName, podUID, fwmarkKeys, gatewayKey); ok {
+		return cached, nil
+	}
+	result, err := resolveRoutingAnnotations(ctx, clientset, cache, podName, podNamespace, fwmarkKeys, gatewayKey, timeout)
+	if err != nil {
+		if static, ok := staticFallback(podNamespace, err); ok {
+			return static, nil
+		}
+		return result, err
+	}
+	cache.StorePod(podNamespace, podName, fwmarkKeys, gatewayKey, result)
+	return result, nil
+}
+
+// resolveRoutingAnnotations fetches the pod and, if needed, the namespace annotations
+func resolveRoutingAnnotations(ctx context.Context, clientset kubernetes.Interface, cache *AnnotationCache, podName, podNamespace string,
+	fwmarkKeys []string, gatewayKey string, timeout time.Duration) (RoutingAnnotations, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	// Fetch pod
-	pod, err := clientset.CoreV1().Pods(podNamespace).Get(ctx, podName, metav1.GetOptions{})
+	pod, err := getPod(ctx, clientset, podName, podNamespace)
+	if err != nil {
+		return RoutingAnnotations{}, err
+	}
+	return routingAnnotationsOf(pod, func() (map[string]string, error) {
+		return namespaceAnnotations(ctx, clientset, cache, podNamespace, fwmarkKeys, gatewayKey)
+	}, func(name string) (*TenantRoute, error) {
+		return GetTenantRoute(ctx, clientset, name)
+	}, fwmarkKeys, gatewayKey)
+}
+
+// getPod fetches a pod (transient failures are retried within ctx, see SetRetryPolicy)
+func getPod(ctx context.Context, clientset kubernetes.Interface, podName, podNamespace string) (*corev1.Pod, error) {
+	var pod *corev1.Pod
+	err := withRetry(ctx, func(ctx context.Context) (err error) {
+		pod, err = clientset.CoreV1().Pods(podNamespace).Get(ctx, podName, metav1.GetOptions{})
+		return err
+	})
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return "", fmt.Errorf("pod %s/%s not found: %w", podNamespace, podName, err)
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("pod %s/%s not found: %w", podNamespace, podName, err)
 		}
-		return "", fmt.Errorf("failed to get pod %s/%s: %w", podNamespace, podName, err)
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", podNamespace, podName, err)
+	}
+	return pod, nil
+}
+
+// routingAnnotationsOf resolves the annotations of pod, with namespaceAnnotations
+// called for the fallback only if a key is missing on the pod and tenantRoute for
+// a tenant name annotation
+func routingAnnotationsOf(pod *corev1.Pod, namespaceAnnotations func() (map[string]string, error),
+	tenantRoute func(name string) (*TenantRoute, error), fwmarkKeys []string, gatewayKey string) (RoutingAnnotations, error) {
+	var result RoutingAnnotations
+	result.PodUID = string(pod.UID)
+	result.TerminatedAt, result.Terminated = terminatedAt(pod)
+	if excludedPod(pod) {
+		result.Excluded = true
+		return result, nil
+	}
+
+	// Bypass is pod-only and never fails the lookup
+	if value, ok := pod.Annotations[BypassAnnotationKey]; ok {
+		result.BypassUntil, result.BypassError = api.ParseBypassUntil(value, nowFunc())
 	}
 
-	// Check pod annotation first
-	if fwmark, ok := pod.Annotations[annotationKey]; ok {
+	// Check pod annotations first
+	fwmarkFound, gatewayFound := false, gatewayKey == ""
+	if fwmark, ok := fwmarkAnnotation(pod.Annotations, fwmarkKeys); ok {
 		if err := validateFwmark(fwmark); err != nil {
-			return "", fmt.Errorf("invalid fwmark in pod annotation: %w", err)
+			return result, fmt.Errorf("invalid fwmark in pod annotation: %w", err)
+		}
+		result.Fwmark, result.Source, fwmarkFound = fwmark, TenantSourcePod, true
+	}
+	if !gatewayFound {
+		if gateway, ok := pod.Annotations[gatewayKey]; ok {
+			if err := validateGateway(gateway); err != nil {
+				return result, fmt.Errorf("invalid gateway in pod annotation: %w", err)
+			}
+			result.Gateway, gatewayFound = gateway, true
+		}
+	}
+	if !fwmarkFound {
+		if name, ok := pod.Annotations[TenantNameAnnotationKey]; ok {
+			if err := applyTenantRoute(&result, tenantRoute, name, &gatewayFound, gatewayKey); err != nil {
+				return result, fmt.Errorf("pod annotation: %w", err)
+			}
+			result.Source, fwmarkFound = TenantSourcePod, true
+		}
+	}
+	if fwmarkFound && gatewayFound {
+		return result, nil
+	}
+
+	// Fallback to namespace annotations
+	nsAnnotations, err := namespaceAnnotations()
+	if err != nil {
+		return result, err
+	}
+
+	if !fwmarkFound {
+		if fwmark, ok := fwmarkAnnotation(nsAnnotations, fwmarkKeys); ok {
+			if err := validateFwmark(fwmark); err != nil {
+				return result, fmt.Errorf("invalid fwmark in namespace annotation: %w", err)
+			}
+			result.Fwmark, result.Source, fwmarkFound = fwmark, TenantSourceNamespace, true
 		}
-		return fwmark, nil
+	}
+	if !gatewayFound {
+		if gateway, ok := nsAnnotations[gatewayKey]; ok {
+			if err := validateGateway(gateway); err != nil {
+				return result, fmt.Errorf("invalid gateway in namespace annotation: %w", err)
+			}
+			result.Gateway, gatewayFound = gateway, true
+		}
+	}
+	if !fwmarkFound {
+		if name, ok := nsAnnotations[TenantNameAnnotationKey]; ok {
+			if err := applyTenantRoute(&result, tenantRoute, name, &gatewayFound, gatewayKey); err != nil {
+				return result, fmt.Errorf("namespace annotation: %w", err)
+			}
+			result.Source, fwmarkFound = TenantSourceNamespace, true
+		}
+	}
+	if !fwmarkFound {
+		if fwmark, ok := nsAnnotations[labelFwmarkKey]; ok {
+			if err := validateFwmark(fwmark); err != nil {
+				return result, fmt.Errorf("invalid fwmark selected by namespace labels: %w", err)
+			}
+			result.Fwmark, result.Source, fwmarkFound = fwmark, TenantSourceNamespaceLabels, true
+		}
+	}
+	if !fwmarkFound {
+		return withStaticTenant(result, pod.Namespace, gatewayFound)
 	}
 
-	// Fallback to namespace annotation
-	ns, err := clientset.CoreV1().Namespaces().Get(ctx, podNamespace, metav1.GetOptions{})
+	// Missing annotations are a valid no-op case
+	return result, nil
+}
+
+// applyTenantRoute sets the fwmark of result, and its gateway unless found already, from
+// the TenantRoute of a tenant name
+func applyTenantRoute(result *RoutingAnnotations, tenantRoute func(name string) (*TenantRoute, error), name string,
+	gatewayFound *bool, gatewayKey string) error {
+	route, err := tenantRoute(name)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return "", fmt.Errorf("namespace %s not found: %w", podNamespace, err)
+		return err
+	}
+	result.Fwmark, result.TenantName, result.Table = route.Fwmark, route.Name, route.Table
+	if !*gatewayFound && gatewayKey != "" && route.Gateway != "" {
+		result.Gateway, *gatewayFound = route.Gateway, true
+	}
+	return nil
+}
+
+// namespaceAnnotations returns the routing annotations set on a namespace, cached or fetched
+// Only the fwmark, gateway and tenant name values, and the fwmark the labels select, are
+// kept; they are validated by the caller, so an invalid cached value fails exactly like
+// a fetched one.
+func namespaceAnnotations(ctx context.Context, clientset kubernetes.Interface, cache *AnnotationCache,
+	namespace string, fwmarkKeys []string, gatewayKey string) (map[string]string, error) {
+	if values, ok := cache.Namespace(namespace, fwmarkKeys, gatewayKey); ok {
+		return values, nil
+	}
+
+	var ns *corev1.Namespace
+	err := withRetry(ctx, func(ctx context.Context) (err error) {
+		ns, err = clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("namespace %s not found: %w", namespace, err)
 		}
-		return "", fmt.Errorf("failed to get namespace %s: %w", podNamespace, err)
+		return nil, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
 	}
 
-	if fwmark, ok := ns.Annotations[annotationKey]; ok {
-		if err := validateFwmark(fwmark); err != nil {
-			return "", fmt.Errorf("invalid fwmark in namespace annotation: %w", err)
+	values := map[string]string{}
+	keys := append(append([]string(nil), fwmarkKeys...), gatewayKey, TenantNameAnnotationKey)
+	for _, key := range keys {
+		if value, ok := ns.Annotations[key]; ok && key != "" {
+			values[key] = value
+		}
+	}
+	if fwmark, ok := labelFwmark(ns.Labels); ok {
+		values[labelFwmarkKey] = fwmark
+	}
+	cache.StoreNamespace(namespace, fwmarkKeys, gatewayKey, values)
+	return values, nil
+}
+
+// fwmarkAnnotation returns the value of the first of fwmarkKeys set in annotations
+// A pod carrying only a legacy key keeps its tenant while a cluster migrates to a new
+// key, and one carrying both is assigned by the key listed first.
+func fwmarkAnnotation(annotations map[string]string, fwmarkKeys []string) (string, bool) {
+	for _, key := range fwmarkKeys {
+		if value, ok := annotations[key]; ok && key != "" {
+			return value, true
 		}
-		return fwmark, nil
 	}
+	return "", false
+}
 
-	// Both annotations missing - valid no-op case
-	return "", nil
+// validateGateway checks that a gateway annotation is a usable IPv4 unicast address
+func validateGateway(gateway string) error {
+	_, err := api.ParseGateway(gateway)
+	return err
 }
 
 // validateFwmark checks if the fwmark value is in the allowed set
 func validateFwmark(fwmark string) error {
-	if !ValidFwmarkValues[fwmark] {
-		return fmt.Errorf("fwmark value '%s' not in allowed set (0x10, 0x20)", fwmark)
+	_, err := api.ParseFwmark(fwmark)
+	return err
+}
+
+// GetStrictOverride reads the strict-mode annotation of a namespace
+// Returns nil if the namespace does not set it
+func GetStrictOverride(ctx context.Context, clientset kubernetes.Interface, namespace string,
+	timeout time.Duration) (*bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var ns *corev1.Namespace
+	err := withRetry(ctx, func(ctx context.Context) (err error) {
+		ns, err = clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %w", namespace, err)
 	}
-	return nil
+	return strictOverrideOf(ns)
+}
+
+// strictOverrideOf parses the strict-mode annotation of ns (nil if not set)
+func strictOverrideOf(ns *corev1.Namespace) (*bool, error) {
+	value, ok := ns.Annotations[StrictAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	strict, err := api.ParseStrict(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value '%s' on namespace %s", StrictAnnotationKey, value, ns.Name)
+	}
+	return &strict, nil
 }
@@ -0,0 +1,277 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testFwmarkKey  = "tenant.routing/fwmark"
+	testGatewayKey = "tenant.routing/gateway"
+)
+
+var testFwmarkKeys = []string{testFwmarkKey}
+
+func testPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a", UID: "3f1c0b7e-web",
+		Annotations: annotations}}
+}
+
+func testNamespace(annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: annotations}}
+}
+
+// TestGetRoutingAnnotations_KeyPriority verifies that listed fwmark keys are checked in
+// order on the pod, then on the namespace
+func TestGetRoutingAnnotations_KeyPriority(t *testing.T) {
+	const legacyKey = "net.example.com/mark"
+	keys := []string{testFwmarkKey, legacyKey}
+
+	tests := []struct {
+		name       string
+		pod        map[string]string
+		ns         map[string]string
+		wantFwmark string
+		wantSource TenantSource
+	}{
+		{name: "legacy key on pod", pod: map[string]string{legacyKey: "0x20"}, wantFwmark: "0x20", wantSource: TenantSourcePod},
+		{name: "first key wins", pod: map[string]string{legacyKey: "0x20", testFwmarkKey: "0x10"}, wantFwmark: "0x10",
+			wantSource: TenantSourcePod},
+		{name: "legacy key on pod beats namespace", pod: map[string]string{legacyKey: "0x20"}, ns: map[string]string{testFwmarkKey: "0x10"},
+			wantFwmark: "0x20", wantSource: TenantSourcePod},
+		{name: "legacy key on namespace", ns: map[string]string{legacyKey: "0x20"}, wantFwmark: "0x20", wantSource: TenantSourceNamespace},
+		{name: "neither key", pod: map[string]string{"other/mark": "0x10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(testPod(tt.pod), testNamespace(tt.ns))
+
+			got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", keys, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Fwmark != tt.wantFwmark || got.Source != tt.wantSource {
+				t.Errorf("Fwmark, Source = %q, %q, want %q, %q", got.Fwmark, got.Source, tt.wantFwmark, tt.wantSource)
+			}
+		})
+	}
+}
+
+// TestGetRoutingAnnotations_Resolution verifies independent pod → namespace fallback per key
+func TestGetRoutingAnnotations_Resolution(t *testing.T) {
+	tests := []struct {
+		name        string
+		pod         map[string]string
+		ns          map[string]string
+		wantFwmark  string
+		wantGateway string
+	}{
+		{
+			name:        "both on pod",
+			pod:         map[string]string{testFwmarkKey: "0x10", testGatewayKey: "10.10.10.131"},
+			ns:          map[string]string{testFwmarkKey: "0x20", testGatewayKey: "10.10.10.184"},
+			wantFwmark:  "0x10",
+			wantGateway: "10.10.10.131",
+		},
+		{
+			name:        "both on namespace",
+			ns:          map[string]string{testFwmarkKey: "0x20", testGatewayKey: "10.10.10.184"},
+			wantFwmark:  "0x20",
+			wantGateway: "10.10.10.184",
+		},
+		{
+			name:        "fwmark from namespace, gateway from pod",
+			pod:         map[string]string{testGatewayKey: "10.10.10.131"},
+			ns:          map[string]string{testFwmarkKey: "0x20"},
+			wantFwmark:  "0x20",
+			wantGateway: "10.10.10.131",
+		},
+		{
+			name:       "no gateway anywhere",
+			pod:        map[string]string{testFwmarkKey: "0x10"},
+			wantFwmark: "0x10",
+		},
+		{
+			name: "no annotations",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(testPod(tt.pod), testNamespace(tt.ns))
+
+			got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Fwmark != tt.wantFwmark {
+				t.Errorf("Fwmark = %q, want %q", got.Fwmark, tt.wantFwmark)
+			}
+			if got.Gateway != tt.wantGateway {
+				t.Errorf("Gateway = %q, want %q", got.Gateway, tt.wantGateway)
+			}
+			if got.PodUID != "3f1c0b7e-web" {
+				t.Errorf("PodUID = %q, want the UID of the pod object", got.PodUID)
+			}
+		})
+	}
+}
+
+// TestGetRoutingAnnotations_InvalidGateway verifies gateway validation
+func TestGetRoutingAnnotations_InvalidGateway(t *testing.T) {
+	tests := []struct {
+		name    string
+		gateway string
+		errMsg  string
+	}{
+		{name: "not an IP", gateway: "gateway-a", errMsg: "not an IPv4 address"},
+		{name: "ipv6", gateway: "fd00::1", errMsg: "not an IPv4 address"},
+		{name: "loopback", gateway: "127.0.0.1", errMsg: "not a unicast address"},
+		{name: "unspecified", gateway: "0.0.0.0", errMsg: "not a unicast address"},
+		{name: "multicast", gateway: "224.0.0.1", errMsg: "not a unicast address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(
+				testPod(map[string]string{testFwmarkKey: "0x10", testGatewayKey: tt.gateway}),
+				testNamespace(nil),
+			)
+
+			_, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+			if err == nil {
+				t.Fatal("expected error but got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %q, want to contain %q", err.Error(), tt.errMsg)
+			}
+			if !errors.Is(err, ErrInvalidGateway) {
+				t.Errorf("error = %v, want errors.Is ErrInvalidGateway", err)
+			}
+		})
+	}
+}
+
+// TestGetRoutingAnnotations_InvalidFwmark verifies the error is classified as ErrInvalidFwmark
+func TestGetRoutingAnnotations_InvalidFwmark(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testPod(nil), testNamespace(map[string]string{testFwmarkKey: "0x99"}))
+
+	_, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+	if !errors.Is(err, ErrInvalidFwmark) {
+		t.Errorf("error = %v, want errors.Is ErrInvalidFwmark", err)
+	}
+	if errors.Is(err, ErrInvalidGateway) {
+		t.Errorf("error = %v classified as invalid gateway", err)
+	}
+}
+
+// TestGetRoutingAnnotations_Bypass verifies bypass parsing and that invalid values fail closed
+func TestGetRoutingAnnotations_Bypass(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	orig := nowFunc
+	nowFunc = func() time.Time { return now }
+	t.Cleanup(func() { nowFunc = orig })
+
+	tests := []struct {
+		name       string
+		pod        map[string]string
+		ns         map[string]string
+		wantActive bool
+		wantErr    bool
+	}{
+		{name: "no annotation", pod: map[string]string{testFwmarkKey: "0x10"}},
+		{name: "active", pod: map[string]string{testFwmarkKey: "0x10", BypassAnnotationKey: "2026-01-01T13:00:00Z"}, wantActive: true},
+		{name: "expired", pod: map[string]string{testFwmarkKey: "0x10", BypassAnnotationKey: "2026-01-01T11:00:00Z"}},
+		{name: "not RFC3339", pod: map[string]string{testFwmarkKey: "0x10", BypassAnnotationKey: "tomorrow"}, wantErr: true},
+		{name: "too far ahead", pod: map[string]string{testFwmarkKey: "0x10", BypassAnnotationKey: "2026-01-03T12:00:00Z"}, wantErr: true},
+		{name: "namespace ignored", ns: map[string]string{testFwmarkKey: "0x10", BypassAnnotationKey: "2026-01-01T13:00:00Z"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(testPod(tt.pod), testNamespace(tt.ns))
+
+			got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Fwmark != "0x10" {
+				t.Errorf("Fwmark = %q, want 0x10 (bypass must not affect resolution)", got.Fwmark)
+			}
+			if active := got.BypassActive(now); active != tt.wantActive {
+				t.Errorf("BypassActive() = %v, want %v", active, tt.wantActive)
+			}
+			if (got.BypassError != nil) != tt.wantErr {
+				t.Errorf("BypassError = %v, wantErr %v", got.BypassError, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestGetFwmark_PodNotFound verifies API errors are surfaced
+func TestGetFwmark_PodNotFound(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testNamespace(nil))
+
+	_, err := GetFwmark(context.Background(), clientset, "web", "team-a", testFwmarkKeys)
+	if err == nil {
+		t.Fatal("expected error for missing pod")
+	}
+	if !strings.Contains(err.Error(), "pod team-a/web not found") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// TestGetFwmark_NamespaceFallback verifies the original fwmark-only lookup is unchanged
+func TestGetFwmark_NamespaceFallback(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testPod(nil), testNamespace(map[string]string{testFwmarkKey: "0x20"}))
+
+	fwmark, err := GetFwmark(context.Background(), clientset, "web", "team-a", testFwmarkKeys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fwmark != "0x20" {
+		t.Errorf("fwmark = %q, want %q", fwmark, "0x20")
+	}
+}
+
+// TestGetStrictOverride verifies the namespace annotation is optional and validated
+func TestGetStrictOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		ns      map[string]string
+		want    *bool
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "opt in", ns: map[string]string{StrictAnnotationKey: "true"}, want: boolPtr(true)},
+		{name: "opt out", ns: map[string]string{StrictAnnotationKey: "false"}, want: boolPtr(false)},
+		{name: "invalid", ns: map[string]string{StrictAnnotationKey: "yes please"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(testNamespace(tt.ns))
+			got, err := GetStrictOverride(context.Background(), clientset, "team-a", time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetStrictOverride() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("GetStrictOverride() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := GetStrictOverride(context.Background(), fake.NewSimpleClientset(), "missing", time.Second); err == nil {
+		t.Error("GetStrictOverride() expected error for missing namespace")
+	}
+}
+
+func boolPtr(b bool) *bool { return &b }
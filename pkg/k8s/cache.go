@@ -0,0 +1,18 @@
+package k8s
+
+import (
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// AnnotationCacheDir is the directory below the plugin's stateDir holding the cache
+const AnnotationCacheDir = tenant.AnnotationCacheDir
+
+// AnnotationCache keeps resolved routing annotations on disk for a TTL (see tenant.AnnotationCache)
+type AnnotationCache = tenant.AnnotationCache
+
+// NewAnnotationCache returns a cache under dir (see tenant.NewAnnotationCache)
+func NewAnnotationCache(dir string, ttl, negativeTTL time.Duration) *AnnotationCache {
+	return tenant.NewAnnotationCache(dir, ttl, negativeTTL)
+}
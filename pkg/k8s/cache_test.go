@@ -0,0 +1,86 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testCache returns a cache in a temporary directory with a settable clock
+func testCache(t *testing.T, ttl time.Duration) (*AnnotationCache, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewAnnotationCache(t.TempDir(), ttl, 0)
+	cache.SetClock(func() time.Time { return now })
+	return cache, &now
+}
+
+// countGets returns how many GETs the fake clientset served
+func countGets(clientset *fake.Clientset) int {
+	gets := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "get" {
+			gets++
+		}
+	}
+	return gets
+}
+
+// TestGetRoutingAnnotationsCached verifies hits skip the API and expired entries do not
+func TestGetRoutingAnnotationsCached(t *testing.T) {
+	cache, now := testCache(t, 30*time.Second)
+	clientset := fake.NewSimpleClientset(testPod(map[string]string{testGatewayKey: "10.10.10.131"}),
+		testNamespace(map[string]string{testFwmarkKey: "0x20"}))
+
+	lookup := func() RoutingAnnotations {
+		t.Helper()
+		annotations, err := GetRoutingAnnotationsCached(context.Background(), clientset, cache, "web", "team-a", "", testFwmarkKeys,
+			testGatewayKey, K8sAPITimeout)
+		if err != nil {
+			t.Fatalf("GetRoutingAnnotationsCached() error = %v", err)
+		}
+		if annotations.Fwmark != "0x20" || annotations.Gateway != "10.10.10.131" || annotations.PodUID != "3f1c0b7e-web" {
+			t.Fatalf("annotations = %+v, want fwmark 0x20, gateway 10.10.10.131, UID 3f1c0b7e-web", annotations)
+		}
+		return annotations
+	}
+
+	lookup()
+	if gets := countGets(clientset); gets != 2 {
+		t.Fatalf("first lookup made %d GETs, want 2 (pod + namespace)", gets)
+	}
+	lookup()
+	if gets := countGets(clientset); gets != 2 {
+		t.Errorf("cached lookup made %d more GETs, want 0", gets-2)
+	}
+
+	// Once the pod entry expired, a fresh namespace entry still spares its GET
+	cache.Invalidate("team-a", "web")
+	lookup()
+	if gets := countGets(clientset); gets != 3 {
+		t.Errorf("lookup after Invalidate made %d more GETs, want 1 (pod only)", gets-2)
+	}
+
+	*now = now.Add(30 * time.Second)
+	lookup()
+	if gets := countGets(clientset); gets != 5 {
+		t.Errorf("lookup after TTL made %d more GETs, want 2", gets-3)
+	}
+}
+
+// TestGetRoutingAnnotationsCached_ErrorsNotCached verifies failed lookups are retried
+func TestGetRoutingAnnotationsCached_ErrorsNotCached(t *testing.T) {
+	cache, _ := testCache(t, time.Minute)
+	clientset := fake.NewSimpleClientset(testPod(map[string]string{testFwmarkKey: "0x99"}))
+
+	for i := 0; i < 2; i++ {
+		if _, err := GetRoutingAnnotationsCached(context.Background(), clientset, cache, "web", "team-a", "", testFwmarkKeys, "",
+			K8sAPITimeout); err == nil {
+			t.Fatal("GetRoutingAnnotationsCached() expected error for invalid fwmark")
+		}
+	}
+	if gets := countGets(clientset); gets != 2 {
+		t.Errorf("failed lookups made %d GETs, want 2 (errors are not cached)", gets)
+	}
+}
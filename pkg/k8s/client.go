@@ -26,6 +26,22 @@ import (
 //   - *kubernetes.Clientset: Configured client ready for API operations
 //   - error: Validation or configuration errors with context
 func NewClient(kubeconfigPath string) (*kubernetes.Clientset, error) {
+	config, err := buildConfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create clientset from validated config
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	return clientset, nil
+}
+
+// buildConfig loads the rest.Config of kubeconfigPath, in-cluster if it is empty (see NewClient)
+func buildConfig(kubeconfigPath string) (*rest.Config, error) {
 	var config *rest.Config
 	var err error
 
@@ -60,11 +76,5 @@ func NewClient(kubeconfigPath string) (*kubernetes.Clientset, error) {
 		}
 	}
 
-	// Create clientset from validated config
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
-	}
-
-	return clientset, nil
+	return config, nil
 }
@@ -0,0 +1,193 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Reasons a ClientManager rebuilds its client
+const (
+	// RebuildUnauthorized follows a 401 answer, e.g. to a token rotated in the kubeconfig
+	RebuildUnauthorized = "unauthorized"
+
+	// RebuildHealthCheck follows a failed Check, e.g. after the kubeconfig moved to
+	// another API server
+	RebuildHealthCheck = "health-check"
+)
+
+// MinRebuildInterval is the least time between two rebuilds, so credentials the API
+// server keeps rejecting do not re-read the kubeconfig on every request
+const MinRebuildInterval = 10 * time.Second
+
+// ClientManager owns the Kubernetes client of a long-running process
+//
+// The kubeconfig is loaded once and the client built from it is handed out for the
+// life of the process: informers and every request forwarded by a CNI invocation
+// share it instead of re-reading the kubeconfig. The client's requests go through
+// the transport of the latest build, so a rebuild reaches informers and callers
+// holding the client alike. A rebuild re-reads the kubeconfig and is triggered by a
+// 401 answer to any request or a failed Check; it runs on the next request, at most
+// once per MinRebuildInterval. A failed rebuild keeps the previous transport.
+//
+// Safe for concurrent use.
+type ClientManager struct {
+	kubeconfig string
+	onRebuild  func(reason string, err error)
+	client     kubernetes.Interface
+
+	mu         sync.Mutex
+	transport  http.RoundTripper
+	server     *url.URL
+	generation uint64
+	attempted  time.Time
+	stale      string // reason of the pending rebuild, "" if none
+
+	// now is the clock; replaced in tests
+	now func() time.Time
+}
+
+// NewClientManager loads kubeconfig (in-cluster if empty, see NewClient) and builds the
+// client. onRebuild, if not nil, is called after every rebuild with its reason and
+// error; it must not use the client.
+func NewClientManager(kubeconfig string, onRebuild func(reason string, err error)) (*ClientManager, error) {
+	m := &ClientManager{kubeconfig: kubeconfig, onRebuild: onRebuild, now: time.Now}
+	config, err := m.build()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the settings that outlive a rebuild; credentials and TLS live in the transport
+	client, err := kubernetes.NewForConfig(&rest.Config{
+		Host:      config.Host,
+		APIPath:   config.APIPath,
+		UserAgent: config.UserAgent,
+		QPS:       config.QPS,
+		Burst:     config.Burst,
+		Timeout:   config.Timeout,
+		Transport: managedTransport{m: m},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+	m.client = client
+	return m, nil
+}
+
+// Client returns the client; it stays the same across rebuilds
+func (m *ClientManager) Client() kubernetes.Interface {
+	return m.client
+}
+
+// Check asks the API server for its version. A failure triggers a rebuild, which runs
+// before a second try unless the last one was less than MinRebuildInterval ago.
+// Returns the error of the second try.
+func (m *ClientManager) Check(ctx context.Context) error {
+	m.mu.Lock()
+	generation := m.generation
+	m.mu.Unlock()
+
+	if err := m.ping(ctx); err == nil {
+		return nil
+	}
+	m.invalidate(generation, RebuildHealthCheck)
+	if err := m.ping(ctx); err != nil {
+		return fmt.Errorf("version check of the Kubernetes API failed: %w", err)
+	}
+	return nil
+}
+
+// ping reads the version of the API server
+func (m *ClientManager) ping(ctx context.Context) error {
+	return m.client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+// build loads the kubeconfig and makes its transport the current one (caller holds m.mu,
+// or m is not shared yet)
+func (m *ClientManager) build() (*rest.Config, error) {
+	m.attempted = m.now()
+	config, err := buildConfig(m.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	server, err := serverURL(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes transport: %w", err)
+	}
+
+	m.transport, m.server, m.stale = transport, server, ""
+	m.generation++
+	return config, nil
+}
+
+// current returns the transport of the latest build and its generation, rebuilding
+// first if a rebuild is pending and due
+func (m *ClientManager) current() (http.RoundTripper, *url.URL, uint64) {
+	m.mu.Lock()
+	reason := m.stale
+	if reason == "" || m.now().Sub(m.attempted) < MinRebuildInterval {
+		defer m.mu.Unlock()
+		return m.transport, m.server, m.generation
+	}
+	_, err := m.build()
+	transport, server, generation := m.transport, m.server, m.generation
+	m.mu.Unlock()
+
+	if m.onRebuild != nil {
+		m.onRebuild(reason, err)
+	}
+	return transport, server, generation
+}
+
+// invalidate schedules a rebuild for reason, unless the transport of generation was
+// replaced already or a rebuild is pending
+func (m *ClientManager) invalidate(generation uint64, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if generation == m.generation && m.stale == "" {
+		m.stale = reason
+	}
+}
+
+// managedTransport sends the client's requests through the current transport of m
+type managedTransport struct {
+	m *ClientManager
+}
+
+// RoundTrip implements http.RoundTripper
+func (t managedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, server, generation := t.m.current()
+	if req.URL.Scheme != server.Scheme || req.URL.Host != server.Host {
+		// The kubeconfig names another server than the one the client was built for
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host, req.Host = server.Scheme, server.Host, ""
+	}
+	resp, err := transport.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.m.invalidate(generation, RebuildUnauthorized)
+	}
+	return resp, err
+}
+
+// serverURL parses the host of a rest.Config ("https://10.0.0.1:6443" or "10.0.0.1:6443")
+func serverURL(host string) (*url.URL, error) {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	server, err := url.Parse(host)
+	if err != nil || server.Host == "" {
+		return nil, fmt.Errorf("invalid Kubernetes API server %q", host)
+	}
+	return server, nil
+}
@@ -0,0 +1,199 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAPIServer answers /version to requests carrying its current token
+// It serves TLS: kubeconfig credentials are only sent to https servers.
+type fakeAPIServer struct {
+	*httptest.Server
+	token    atomic.Value
+	requests atomic.Int32
+}
+
+// newFakeAPIServer starts a fakeAPIServer accepting token
+func newFakeAPIServer(t *testing.T, token string) *fakeAPIServer {
+	t.Helper()
+	s := &fakeAPIServer{}
+	s.token.Store(token)
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer "+s.token.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major": "1", "minor": "30", "gitVersion": "v1.30.0"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// writeKubeconfig writes a kubeconfig for server and token to path
+func writeKubeconfig(t *testing.T, path, server, token string) {
+	t.Helper()
+	kubeconfig := `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: ` + server + `
+    insecure-skip-tls-verify: true
+  name: test-cluster
+contexts:
+- context:
+    cluster: test-cluster
+    user: test-user
+  name: test-context
+current-context: test-context
+users:
+- name: test-user
+  user:
+    token: ` + token + `
+`
+	if err := os.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rebuildLog records the rebuilds of a ClientManager
+type rebuildLog struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (l *rebuildLog) observe(reason string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		reason += ": " + err.Error()
+	}
+	l.reasons = append(l.reasons, reason)
+}
+
+func (l *rebuildLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.reasons...)
+}
+
+// TestClientManager_RebuildsOnUnauthorized verifies a rotated token is picked up after a
+// 401, at most once per MinRebuildInterval
+func TestClientManager_RebuildsOnUnauthorized(t *testing.T) {
+	server := newFakeAPIServer(t, "token-a")
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	writeKubeconfig(t, path, server.URL, "token-a")
+
+	var rebuilds rebuildLog
+	m, err := NewClientManager(path, rebuilds.observe)
+	if err != nil {
+		t.Fatalf("NewClientManager() error = %v", err)
+	}
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	client := m.Client()
+
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	// The token is rotated: the kubeconfig is not re-read within MinRebuildInterval
+	server.token.Store("token-b")
+	writeKubeconfig(t, path, server.URL, "token-b")
+	if err := m.Check(context.Background()); err == nil {
+		t.Fatal("Check() succeeded with the old token")
+	}
+	if got := rebuilds.get(); len(got) != 0 {
+		t.Errorf("rebuilds = %v, want none within MinRebuildInterval", got)
+	}
+
+	now = now.Add(MinRebuildInterval)
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatalf("Check() after MinRebuildInterval error = %v", err)
+	}
+	if got := rebuilds.get(); len(got) != 1 || got[0] != RebuildUnauthorized {
+		t.Errorf("rebuilds = %v, want [%s]", got, RebuildUnauthorized)
+	}
+	if m.Client() != client {
+		t.Error("Client() changed across a rebuild")
+	}
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		t.Errorf("client built before the rebuild: %v", err)
+	}
+}
+
+// TestClientManager_RebuildsOnFailedCheck verifies a kubeconfig naming another server is
+// picked up by Check and requests of the client follow it
+func TestClientManager_RebuildsOnFailedCheck(t *testing.T) {
+	old := newFakeAPIServer(t, "token")
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	writeKubeconfig(t, path, old.URL, "token")
+
+	var rebuilds rebuildLog
+	m, err := NewClientManager(path, rebuilds.observe)
+	if err != nil {
+		t.Fatalf("NewClientManager() error = %v", err)
+	}
+	now := time.Now()
+	m.now = func() time.Time { return now.Add(MinRebuildInterval) }
+
+	moved := newFakeAPIServer(t, "token")
+	writeKubeconfig(t, path, moved.URL, "token")
+	old.Close()
+
+	if err := m.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got := rebuilds.get(); len(got) != 1 || got[0] != RebuildHealthCheck {
+		t.Errorf("rebuilds = %v, want [%s]", got, RebuildHealthCheck)
+	}
+	if moved.requests.Load() != 1 {
+		t.Errorf("moved server got %d requests, want 1", moved.requests.Load())
+	}
+}
+
+// TestClientManager_FailedRebuild verifies a kubeconfig that no longer loads keeps the
+// current transport and reports the error
+func TestClientManager_FailedRebuild(t *testing.T) {
+	server := newFakeAPIServer(t, "token")
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	writeKubeconfig(t, path, server.URL, "token")
+
+	var rebuilds rebuildLog
+	m, err := NewClientManager(path, rebuilds.observe)
+	if err != nil {
+		t.Fatalf("NewClientManager() error = %v", err)
+	}
+	m.now = func() time.Time { return time.Now().Add(MinRebuildInterval) }
+
+	server.token.Store("token-b")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(context.Background()); err == nil {
+		t.Error("Check() succeeded with a rejected token")
+	}
+	if got := rebuilds.get(); len(got) != 1 || got[0] == RebuildUnauthorized {
+		t.Errorf("rebuilds = %v, want one failed rebuild", got)
+	}
+
+	server.token.Store("token")
+	if err := m.Check(context.Background()); err != nil {
+		t.Errorf("Check() with the previous transport error = %v", err)
+	}
+}
+
+// TestNewClientManager_Errors verifies kubeconfig errors are returned
+func TestNewClientManager_Errors(t *testing.T) {
+	if _, err := NewClientManager("/nonexistent/kubeconfig", nil); err == nil {
+		t.Error("NewClientManager() expected error for a missing kubeconfig")
+	}
+}
@@ -0,0 +1,85 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+)
+
+// EventSource is the component name tenant-routing events are reported under
+const EventSource = "tenant-routing-wrapper"
+
+// MarkAppliedEventReason is the reason of the event recorded when a running pod gets
+// its tenant mark after the fact (see the migrate command)
+const MarkAppliedEventReason = api.MarkAppliedEventReason
+
+// Reasons of the Warning events recorded when routing setup of a pod fails
+const (
+	// RoutingFailedEventReason: a rule or route of the pod could not be installed
+	RoutingFailedEventReason = api.RoutingFailedEventReason
+
+	// InvalidFwmarkEventReason: the fwmark annotation of the pod or its namespace is not allowed
+	InvalidFwmarkEventReason = api.InvalidFwmarkEventReason
+
+	// InvalidGatewayEventReason: the gateway annotation of the pod or its namespace is not usable
+	InvalidGatewayEventReason = api.InvalidGatewayEventReason
+)
+
+// EventRecorder records events about the pods of one node
+type EventRecorder struct {
+	clientset kubernetes.Interface
+	host      string
+	timeout   time.Duration
+}
+
+// NewEventRecorder returns a recorder reporting from host (the node name)
+// timeout bounds every event creation.
+func NewEventRecorder(clientset kubernetes.Interface, host string, timeout time.Duration) *EventRecorder {
+	return &EventRecorder{clientset: clientset, host: host, timeout: timeout}
+}
+
+// Warningf records a Warning event about a pod
+func (r *EventRecorder) Warningf(ctx context.Context, podNamespace, podName, reason, format string, args ...interface{}) error {
+	return RecordPodEvent(ctx, r.clientset, podNamespace, podName, r.host, corev1.EventTypeWarning, reason,
+		fmt.Sprintf(format, args...), r.timeout)
+}
+
+// RecordPodEvent creates an event about a pod, visible in `kubectl describe pod`
+// eventType is corev1.EventTypeNormal or corev1.EventTypeWarning; host is the node name.
+func RecordPodEvent(ctx context.Context, clientset kubernetes.Interface, podNamespace, podName, host, eventType, reason, message string,
+	timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Same naming scheme as client-go's event recorder
+			Name:      fmt.Sprintf("%s.%x", podName, now.UnixNano()),
+			Namespace: podNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  podNamespace,
+			Name:       podName,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: EventSource, Host: host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := clientset.CoreV1().Events(podNamespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to record event for pod %s/%s: %w", podNamespace, podName, err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordPodEvent(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	if err := RecordPodEvent(context.Background(), clientset, "team-a", "web-0", "node-1", corev1.EventTypeNormal,
+		MarkAppliedEventReason, "fwmark 0x10 applied", time.Second); err != nil {
+		t.Fatalf("RecordPodEvent() error = %v", err)
+	}
+
+	events, err := clientset.CoreV1().Events("team-a").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("events = %d, want 1", len(events.Items))
+	}
+	event := events.Items[0]
+	if event.InvolvedObject.Kind != "Pod" || event.InvolvedObject.Name != "web-0" ||
+		event.Reason != MarkAppliedEventReason || event.Source.Host != "node-1" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestEventRecorder(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewEventRecorder(clientset, "node-1", time.Second)
+
+	if err := recorder.Warningf(context.Background(), "team-a", "web-0", InvalidFwmarkEventReason, "fwmark %q is not allowed", "0x99"); err != nil {
+		t.Fatalf("Warningf() error = %v", err)
+	}
+	events, err := clientset.CoreV1().Events("team-a").List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(events.Items) != 1 {
+		t.Fatalf("events = %v, %v; want 1", events, err)
+	}
+	event := events.Items[0]
+	if event.Type != corev1.EventTypeWarning || event.Reason != InvalidFwmarkEventReason ||
+		event.Message != `fwmark "0x99" is not allowed` || event.Source.Host != "node-1" {
+		t.Errorf("event = %+v", event)
+	}
+}
@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+var (
+	exclusionsMu sync.RWMutex
+	excludedPods labels.Selector
+)
+
+// SetExclusions excludes infrastructure pods from tenant routing in every following
+// lookup: the pods of namespaces, answered without any API call, and the pods whose
+// labels match podSelector, answered without the namespace or TenantRoute lookups.
+// Excluded pods resolve to no tenant (see RoutingAnnotations.Excluded); an empty
+// podSelector excludes no pod by label.
+func SetExclusions(namespaces []string, podSelector string) error {
+	var selector labels.Selector
+	if podSelector != "" {
+		var err error
+		if selector, err = labels.Parse(podSelector); err != nil {
+			return fmt.Errorf("invalid pod label selector %q: %w", podSelector, err)
+		}
+	}
+	tenant.SetExcludedNamespaces(namespaces)
+	exclusionsMu.Lock()
+	defer exclusionsMu.Unlock()
+	excludedPods = selector
+	return nil
+}
+
+// ExcludedNamespace reports whether the pods of namespace are excluded from tenant routing
+func ExcludedNamespace(namespace string) bool {
+	return tenant.ExcludedNamespace(namespace)
+}
+
+// excludedPod reports whether the labels of pod exclude it from tenant routing
+func excludedPod(pod *corev1.Pod) bool {
+	exclusionsMu.RLock()
+	defer exclusionsMu.RUnlock()
+	return excludedPods != nil && excludedPods.Matches(labels.Set(pod.Labels))
+}
@@ -0,0 +1,58 @@
+package k8s
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestSetExclusions verifies excluded namespaces are answered without API calls and
+// excluded pods without the namespace lookup, whatever their annotations
+func TestSetExclusions(t *testing.T) {
+	if err := SetExclusions(nil, "k8s-app in ("); err == nil {
+		t.Error("SetExclusions() accepted an invalid selector")
+	}
+	if err := SetExclusions([]string{"kube-system"}, "k8s-app in (cilium,kube-dns)"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetExclusions(nil, "") })
+
+	// An excluded namespace needs no API call, not even for the pod
+	clientset := fake.NewSimpleClientset()
+	got, err := GetRoutingAnnotations(context.Background(), clientset, "coredns", "kube-system", testFwmarkKeys, testGatewayKey)
+	if err != nil || !got.Excluded || got.Fwmark != "" {
+		t.Errorf("GetRoutingAnnotations() in an excluded namespace = %+v, %v; want excluded", got, err)
+	}
+	if actions := clientset.Actions(); len(actions) != 0 {
+		t.Errorf("excluded namespace cost %d API calls", len(actions))
+	}
+
+	// An excluded pod is not marked even if annotated, and its namespace is not fetched
+	pod := testPod(map[string]string{testFwmarkKey: "0x10"})
+	pod.Labels = map[string]string{"k8s-app": "cilium"}
+	clientset = fake.NewSimpleClientset(pod, testNamespace(map[string]string{testGatewayKey: "10.10.10.131"}))
+	cache := NewAnnotationCache(filepath.Join(t.TempDir(), AnnotationCacheDir), time.Minute, 0)
+	for _, source := range []string{"API", "cache"} {
+		got, err := GetRoutingAnnotationsCached(context.Background(), clientset, cache, "web", "team-a", "", testFwmarkKeys,
+			testGatewayKey, K8sAPITimeout)
+		if err != nil || !got.Excluded || got.Fwmark != "" || got.Gateway != "" || got.PodUID != string(pod.UID) {
+			t.Errorf("excluded pod from the %s = %+v, %v; want excluded", source, got, err)
+		}
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource != "pods" {
+			t.Errorf("excluded pod looked up %s", action.GetResource().Resource)
+		}
+	}
+
+	// Other pods resolve as usual
+	pod.Labels = map[string]string{"k8s-app": "web"}
+	clientset = fake.NewSimpleClientset(pod, testNamespace(nil))
+	if got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey); err != nil ||
+		got.Excluded || got.Fwmark != "0x10" {
+		t.Errorf("GetRoutingAnnotations() of a pod not excluded = %+v, %v; want fwmark 0x10", got, err)
+	}
+}
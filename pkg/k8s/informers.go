@@ -0,0 +1,251 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultResync is how often informers replay their whole cache to handlers by default
+const DefaultResync = 10 * time.Minute
+
+// Informers resolves routing annotations from watched pods and namespaces
+//
+// Only the pods scheduled to one node are watched, so the memory use follows the
+// node's pod count rather than the cluster's. A pod missing from the cache (its
+// watch event may trail the CNI ADD by a few milliseconds) is fetched from the API
+// server instead.
+//
+// Namespaces are listed once and watched. With a namespace selector only the matching
+// ones are; another namespace is fetched on its first lookup and its object kept for
+// the resync period, so a full reconcile of a dense node still costs one GET per
+// namespace at most instead of one per pod. TenantRoutes are not watched: one is
+// fetched on its first lookup and kept for the resync period as well.
+type Informers struct {
+	clientset  kubernetes.Interface
+	podFactory informers.SharedInformerFactory
+	nsFactory  informers.SharedInformerFactory
+	pods       corelisters.PodLister
+	namespaces corelisters.NamespaceLister
+	informers  []cache.SharedIndexInformer
+	synced     []cache.InformerSynced
+
+	// selector selects the watched namespaces; nil watches all
+	selector labels.Selector
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	unselected map[string]fetchedNamespace
+	routes     map[string]fetchedRoute
+}
+
+// fetchedNamespace is a namespace outside the selector, as fetched at a time
+type fetchedNamespace struct {
+	ns      *corev1.Namespace
+	fetched time.Time
+}
+
+// fetchedRoute is a TenantRoute as fetched at a time
+type fetchedRoute struct {
+	route   *TenantRoute
+	fetched time.Time
+}
+
+// NewInformers returns informers for the pods of nodeName and the namespaces matching
+// namespaceSelector, a label selector ("" selects all). They do nothing until Start is called.
+func NewInformers(clientset kubernetes.Interface, nodeName, namespaceSelector string,
+	resync time.Duration) (*Informers, error) {
+	var selector labels.Selector
+	if namespaceSelector != "" {
+		var err error
+		if selector, err = labels.Parse(namespaceSelector); err != nil {
+			return nil, fmt.Errorf("invalid namespace selector %q: %w", namespaceSelector, err)
+		}
+	}
+	ttl := resync
+	if ttl <= 0 {
+		ttl = DefaultResync
+	}
+
+	podFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resync,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+	nsFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resync,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = namespaceSelector
+		}))
+
+	pods := podFactory.Core().V1().Pods()
+	namespaces := nsFactory.Core().V1().Namespaces()
+	return &Informers{
+		clientset:  clientset,
+		podFactory: podFactory,
+		nsFactory:  nsFactory,
+		pods:       pods.Lister(),
+		namespaces: namespaces.Lister(),
+		informers:  []cache.SharedIndexInformer{pods.Informer(), namespaces.Informer()},
+		synced:     []cache.InformerSynced{pods.Informer().HasSynced, namespaces.Informer().HasSynced},
+		selector:   selector,
+		ttl:        ttl,
+		now:        time.Now,
+		unselected: map[string]fetchedNamespace{},
+		routes:     map[string]fetchedRoute{},
+	}, nil
+}
+
+// Start starts the watches and waits until the caches are filled or ctx is done
+func (i *Informers) Start(ctx context.Context) error {
+	i.podFactory.Start(ctx.Done())
+	i.nsFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), i.synced...) {
+		return fmt.Errorf("failed to sync pod and namespace informers: %w", ctx.Err())
+	}
+	return nil
+}
+
+// OnAnnotationChange calls handler for every watched pod or namespace whose annotations change
+// A pod is reported with its namespace and name, a namespace with its name and an empty
+// pod; namespaces are also reported when their labels change, which namespace label
+// rules read (see SetNamespaceLabelRules). Resyncs and other updates are not reported.
+// Handlers registered before Start also see the changes made while the caches fill.
+func (i *Informers) OnAnnotationChange(handler func(namespace, pod string)) error {
+	for _, informer := range i.informers {
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldMeta, ok1 := oldObj.(metav1.Object)
+				newMeta, ok2 := newObj.(metav1.Object)
+				if !ok1 || !ok2 {
+					return
+				}
+				annotated := !reflect.DeepEqual(oldMeta.GetAnnotations(), newMeta.GetAnnotations())
+				switch newObj.(type) {
+				case *corev1.Pod:
+					if annotated {
+						handler(newMeta.GetNamespace(), newMeta.GetName())
+					}
+				case *corev1.Namespace:
+					if annotated || !reflect.DeepEqual(oldMeta.GetLabels(), newMeta.GetLabels()) {
+						handler(newMeta.GetName(), "")
+					}
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to watch annotation changes: %w", err)
+		}
+	}
+	return nil
+}
+
+// RoutingAnnotations resolves the routing annotations of a pod like GetRoutingAnnotations
+// API calls are only made for a pod or namespace the informers have not seen yet.
+func (i *Informers) RoutingAnnotations(ctx context.Context, podName, podNamespace string, fwmarkKeys []string, gatewayKey string,
+	timeout time.Duration) (RoutingAnnotations, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pod, err := i.pods.Pods(podNamespace).Get(podName)
+	if apierrors.IsNotFound(err) {
+		pod, err = getPod(ctx, i.clientset, podName, podNamespace)
+	}
+	if err != nil {
+		return RoutingAnnotations{}, err
+	}
+	return routingAnnotationsOf(pod, func() (map[string]string, error) {
+		ns, err := i.namespace(ctx, podNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return withLabelFwmark(ns.Annotations, ns.Labels), nil
+	}, func(name string) (*TenantRoute, error) {
+		return i.tenantRoute(ctx, name)
+	}, fwmarkKeys, gatewayKey)
+}
+
+// StrictOverride reads the strict-mode annotation of a namespace like GetStrictOverride
+func (i *Informers) StrictOverride(ctx context.Context, namespace string, timeout time.Duration) (*bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ns, err := i.namespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return strictOverrideOf(ns)
+}
+
+// namespace returns a namespace from the informer cache, or the API server on a miss
+// Fetched namespaces outside the selector are kept for the resync period.
+func (i *Informers) namespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	ns, err := i.namespaces.Get(name)
+	if apierrors.IsNotFound(err) {
+		if cached, ok := i.fetched(name); ok {
+			return cached, nil
+		}
+		err = withRetry(ctx, func(ctx context.Context) (err error) {
+			ns, err = i.clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+			return err
+		})
+		if err == nil && i.selector != nil && !i.selector.Matches(labels.Set(ns.Labels)) {
+			i.mu.Lock()
+			i.unselected[name] = fetchedNamespace{ns: ns, fetched: i.now()}
+			i.mu.Unlock()
+		}
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("namespace %s not found: %w", name, err)
+		}
+		return nil, fmt.Errorf("failed to get namespace %s: %w", name, err)
+	}
+	return ns, nil
+}
+
+// fetched returns a namespace outside the selector fetched less than the resync period ago
+func (i *Informers) fetched(name string) (*corev1.Namespace, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	entry, ok := i.unselected[name]
+	if !ok {
+		return nil, false
+	}
+	if i.now().Sub(entry.fetched) >= i.ttl {
+		delete(i.unselected, name)
+		return nil, false
+	}
+	return entry.ns, true
+}
+
+// tenantRoute returns a TenantRoute fetched less than the resync period ago, or fetches it
+// Failed lookups are not kept.
+func (i *Informers) tenantRoute(ctx context.Context, name string) (*TenantRoute, error) {
+	i.mu.Lock()
+	entry, ok := i.routes[name]
+	i.mu.Unlock()
+	if ok && i.now().Sub(entry.fetched) < i.ttl {
+		return entry.route, nil
+	}
+
+	route, err := GetTenantRoute(ctx, i.clientset, name)
+	if err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	i.routes[name] = fetchedRoute{route: route, fetched: i.now()}
+	i.mu.Unlock()
+	return route, nil
+}
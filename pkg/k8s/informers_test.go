@@ -0,0 +1,159 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestInformers_RoutingAnnotations verifies lookups are answered from the watch caches
+func TestInformers_RoutingAnnotations(t *testing.T) {
+	pod := testPod(map[string]string{testGatewayKey: "10.10.10.131"})
+	pod.Spec.NodeName = "node-1"
+	strict := testNamespace(map[string]string{testFwmarkKey: "0x20", StrictAnnotationKey: "true"})
+	clientset := fake.NewSimpleClientset(pod, strict)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informers, err := NewInformers(clientset, "node-1", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := informers.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	clientset.ClearActions()
+
+	annotations, err := informers.RoutingAnnotations(context.Background(), "web", "team-a", testFwmarkKeys, testGatewayKey, time.Second)
+	if err != nil {
+		t.Fatalf("RoutingAnnotations() error = %v", err)
+	}
+	if annotations.Fwmark != "0x20" || annotations.Gateway != "10.10.10.131" || annotations.PodUID != "3f1c0b7e-web" {
+		t.Errorf("RoutingAnnotations() = %+v, want fwmark 0x20, gateway 10.10.10.131", annotations)
+	}
+	if override, err := informers.StrictOverride(context.Background(), "team-a", time.Second); err != nil || override == nil || !*override {
+		t.Errorf("StrictOverride() = %v, %v; want true", override, err)
+	}
+	if actions := clientset.Actions(); len(actions) != 0 {
+		t.Errorf("cached lookups made %d API calls, want 0", len(actions))
+	}
+
+	// A pod the watch has not delivered is fetched from the API server
+	_, err = informers.RoutingAnnotations(context.Background(), "late", "team-a", testFwmarkKeys, "", time.Second)
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("RoutingAnnotations() of a missing pod error = %v, want NotFound", err)
+	}
+	if gets := countGets(clientset); gets != 1 {
+		t.Errorf("cache miss made %d GETs, want 1", gets)
+	}
+}
+
+// TestInformers_OnAnnotationChange verifies only annotation updates are reported
+func TestInformers_OnAnnotationChange(t *testing.T) {
+	pod := testPod(nil)
+	pod.Spec.NodeName = "node-1"
+	ns := testNamespace(nil)
+	clientset := fake.NewSimpleClientset(pod, ns)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informers, err := NewInformers(clientset, "node-1", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan string, 10)
+	if err := informers.OnAnnotationChange(func(namespace, pod string) { changes <- namespace + "/" + pod }); err != nil {
+		t.Fatalf("OnAnnotationChange() error = %v", err)
+	}
+	if err := informers.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// A label change is not reported, the annotation changes are
+	pod.Labels = map[string]string{"app": "web"}
+	if _, err := clientset.CoreV1().Pods(pod.Namespace).Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pod = pod.DeepCopy()
+	pod.Annotations = map[string]string{testFwmarkKey: "0x10"}
+	if _, err := clientset.CoreV1().Pods(pod.Namespace).Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ns.Annotations = map[string]string{testFwmarkKey: "0x20"}
+	if _, err := clientset.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pod and namespace informers deliver independently, in any order
+	want := map[string]bool{"team-a/web": true, "team-a/": true}
+	for len(want) > 0 {
+		select {
+		case got := <-changes:
+			if !want[got] {
+				t.Errorf("unexpected change %q", got)
+			}
+			delete(want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("changes %v not reported", want)
+		}
+	}
+	select {
+	case got := <-changes:
+		t.Errorf("unexpected change %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestInformers_NamespaceSelector verifies namespaces outside the selector cost one GET per resync period
+func TestInformers_NamespaceSelector(t *testing.T) {
+	selected := testNamespace(map[string]string{StrictAnnotationKey: "true"})
+	selected.Labels = map[string]string{"tenant.routing/managed": "true"}
+	other := testNamespace(map[string]string{StrictAnnotationKey: "false"})
+	other.Name = "team-b"
+	clientset := fake.NewSimpleClientset(selected, other)
+
+	if _, err := NewInformers(clientset, "node-1", "tenant.routing/managed in (", time.Minute); err == nil {
+		t.Error("NewInformers() accepted an invalid selector")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informers, err := NewInformers(clientset, "node-1", "tenant.routing/managed=true", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	informers.now = func() time.Time { return now }
+	if err := informers.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	clientset.ClearActions()
+
+	if override, err := informers.StrictOverride(context.Background(), "team-a", time.Second); err != nil || override == nil || !*override {
+		t.Errorf("StrictOverride(team-a) = %v, %v; want true", override, err)
+	}
+	if gets := countGets(clientset); gets != 0 {
+		t.Errorf("selected namespace made %d GETs, want 0", gets)
+	}
+
+	for i := 0; i < 3; i++ {
+		if override, err := informers.StrictOverride(context.Background(), "team-b", time.Second); err != nil || override == nil || *override {
+			t.Errorf("StrictOverride(team-b) = %v, %v; want false", override, err)
+		}
+	}
+	if gets := countGets(clientset); gets != 1 {
+		t.Errorf("repeated lookups of an unselected namespace made %d GETs, want 1", gets)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := informers.StrictOverride(context.Background(), "team-b", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if gets := countGets(clientset); gets != 2 {
+		t.Errorf("lookup after the resync period made %d GETs, want 2", gets)
+	}
+}
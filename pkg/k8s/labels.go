@@ -0,0 +1,80 @@
+package k8s
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// NamespaceLabelRule assigns a tenant fwmark to the namespaces whose labels match Selector
+type NamespaceLabelRule struct {
+	// Selector is a label selector, e.g. "tenant=a" or "team in (payments,billing)"
+	Selector string
+
+	// Fwmark is the tenant fwmark of the matching namespaces
+	Fwmark string
+}
+
+// labelFwmarkKey carries the fwmark selected by namespace labels next to the namespace
+// annotations (see tenant.LabelFwmarkKey)
+const labelFwmarkKey = tenant.LabelFwmarkKey
+
+// compiledRule is a NamespaceLabelRule with its selector parsed
+type compiledRule struct {
+	selector labels.Selector
+	fwmark   string
+}
+
+var (
+	labelRulesMu sync.RWMutex
+	labelRules   []compiledRule
+)
+
+// SetNamespaceLabelRules sets the rules every following lookup falls back to when
+// neither the pod nor its namespace annotates the tenant: the first rule whose selector
+// matches the namespace labels assigns its fwmark. nil disables the fallback.
+// The fwmark is validated like an annotation when a pod resolves to it.
+func SetNamespaceLabelRules(rules []NamespaceLabelRule) error {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		selector, err := labels.Parse(rule.Selector)
+		if err != nil {
+			return fmt.Errorf("invalid namespace label selector %q: %w", rule.Selector, err)
+		}
+		compiled = append(compiled, compiledRule{selector: selector, fwmark: rule.Fwmark})
+	}
+	labelRulesMu.Lock()
+	defer labelRulesMu.Unlock()
+	labelRules = compiled
+	return nil
+}
+
+// labelFwmark returns the fwmark the first matching rule assigns to namespace labels
+func labelFwmark(nsLabels map[string]string) (string, bool) {
+	labelRulesMu.RLock()
+	defer labelRulesMu.RUnlock()
+	for _, rule := range labelRules {
+		if rule.selector.Matches(labels.Set(nsLabels)) {
+			return rule.fwmark, true
+		}
+	}
+	return "", false
+}
+
+// withLabelFwmark returns the annotations of a namespace, plus the fwmark its labels
+// select under labelFwmarkKey
+func withLabelFwmark(annotations, nsLabels map[string]string) map[string]string {
+	fwmark, ok := labelFwmark(nsLabels)
+	if !ok {
+		return annotations
+	}
+	values := make(map[string]string, len(annotations)+1)
+	for key, value := range annotations {
+		values[key] = value
+	}
+	values[labelFwmarkKey] = fwmark
+	return values
+}
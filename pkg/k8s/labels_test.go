@@ -0,0 +1,64 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestSetNamespaceLabelRules_Resolution verifies namespace labels are the last fallback
+func TestSetNamespaceLabelRules_Resolution(t *testing.T) {
+	if err := SetNamespaceLabelRules([]NamespaceLabelRule{{Selector: "tenant in (", Fwmark: "0x10"}}); err == nil {
+		t.Error("SetNamespaceLabelRules() accepted an invalid selector")
+	}
+	err := SetNamespaceLabelRules([]NamespaceLabelRule{
+		{Selector: "tenant=a", Fwmark: "0x10"},
+		{Selector: "team", Fwmark: "0x20"},
+		{Selector: "tenant=legacy", Fwmark: "0x99"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetNamespaceLabelRules(nil) })
+
+	tests := []struct {
+		name       string
+		pod        map[string]string
+		ns         map[string]string
+		labels     map[string]string
+		wantFwmark string
+		wantErr    error
+	}{
+		{name: "first matching rule", labels: map[string]string{"tenant": "a", "team": "payments"}, wantFwmark: "0x10"},
+		{name: "existence selector", labels: map[string]string{"team": "payments"}, wantFwmark: "0x20"},
+		{name: "no matching rule", labels: map[string]string{"tenant": "b"}},
+		{name: "namespace annotation wins", ns: map[string]string{testFwmarkKey: "0x20"},
+			labels: map[string]string{"tenant": "a"}, wantFwmark: "0x20"},
+		{name: "pod annotation wins", pod: map[string]string{testFwmarkKey: "0x20"},
+			labels: map[string]string{"tenant": "a"}, wantFwmark: "0x20"},
+		{name: "invalid fwmark of a rule", labels: map[string]string{"tenant": "legacy"}, wantErr: ErrInvalidFwmark},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := testNamespace(tt.ns)
+			ns.Labels = tt.labels
+			clientset := fake.NewSimpleClientset(testPod(tt.pod), ns)
+
+			got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Fwmark != tt.wantFwmark {
+				t.Errorf("Fwmark = %q, want %q", got.Fwmark, tt.wantFwmark)
+			}
+		})
+	}
+}
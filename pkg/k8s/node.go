@@ -0,0 +1,92 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+)
+
+// ConfigHashAnnotationKey is the Node annotation holding the fingerprint of the
+// tenant-routing configuration last applied on the node
+const ConfigHashAnnotationKey = api.ConfigHashAnnotationKey
+
+// ConfirmConfigAnnotationKey is the Node annotation approving a destructive configuration
+// reload of tenant-routingd: it must hold the fingerprint of the configuration
+const ConfirmConfigAnnotationKey = api.ConfirmConfigAnnotationKey
+
+// TenantRoutingReadyCondition is the Node condition reporting whether tenant routing
+// works on the node (published by the health command)
+const TenantRoutingReadyCondition corev1.NodeConditionType = api.TenantRoutingReadyCondition
+
+// SetNodeAnnotation sets one annotation on a Node with a merge patch
+// Other annotations are left alone, so concurrent writers do not conflict.
+func SetNodeAnnotation(ctx context.Context, clientset kubernetes.Interface, nodeName, key, value string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": map[string]string{key: value}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build node patch: %w", err)
+	}
+	if _, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, apitypes.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate node %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+// GetNodeAnnotation returns one annotation of a Node, "" if it is not set
+func GetNodeAnnotation(ctx context.Context, clientset kubernetes.Interface, nodeName, key string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	return node.Annotations[key], nil
+}
+
+// SetNodeCondition sets one condition in a Node's status with a strategic merge patch
+// The heartbeat time is always refreshed; the transition time only changes with the
+// status. Other conditions are left alone.
+func SetNodeCondition(ctx context.Context, clientset kubernetes.Interface, nodeName string, cond corev1.NodeCondition,
+	timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+
+	now := metav1.Now()
+	cond.LastHeartbeatTime = now
+	cond.LastTransitionTime = now
+	for _, existing := range node.Status.Conditions {
+		if existing.Type == cond.Type && existing.Status == cond.Status {
+			cond.LastTransitionTime = existing.LastTransitionTime
+		}
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"status": map[string]any{"conditions": []corev1.NodeCondition{cond}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build node status patch: %w", err)
+	}
+	if _, err := clientset.CoreV1().Nodes().Patch(ctx, nodeName, apitypes.StrategicMergePatchType, patch,
+		metav1.PatchOptions{}, "status"); err != nil {
+		return fmt.Errorf("failed to set condition %s on node %s: %w", cond.Type, nodeName, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSetNodeAnnotation(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-1",
+		Annotations: map[string]string{"other": "kept"},
+	}})
+
+	for _, hash := range []string{"0123456789abcdef", "fedcba9876543210"} {
+		if err := SetNodeAnnotation(context.Background(), clientset, "node-1", ConfigHashAnnotationKey, hash, time.Second); err != nil {
+			t.Fatalf("SetNodeAnnotation() error = %v", err)
+		}
+	}
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Annotations[ConfigHashAnnotationKey]; got != "fedcba9876543210" {
+		t.Errorf("%s = %q, want fedcba9876543210", ConfigHashAnnotationKey, got)
+	}
+	if node.Annotations["other"] != "kept" {
+		t.Errorf("other annotations not preserved: %v", node.Annotations)
+	}
+
+	if err := SetNodeAnnotation(context.Background(), clientset, "missing", ConfigHashAnnotationKey, "x", time.Second); err == nil {
+		t.Error("SetNodeAnnotation() expected error for missing node")
+	}
+
+	if got, err := GetNodeAnnotation(context.Background(), clientset, "node-1", ConfigHashAnnotationKey, time.Second); err != nil || got != "fedcba9876543210" {
+		t.Errorf("GetNodeAnnotation() = %q, %v; want fedcba9876543210", got, err)
+	}
+	if got, err := GetNodeAnnotation(context.Background(), clientset, "node-1", ConfirmConfigAnnotationKey, time.Second); err != nil || got != "" {
+		t.Errorf("GetNodeAnnotation() of an unset key = %q, %v; want empty", got, err)
+	}
+	if _, err := GetNodeAnnotation(context.Background(), clientset, "missing", ConfigHashAnnotationKey, time.Second); err == nil {
+		t.Error("GetNodeAnnotation() expected error for missing node")
+	}
+}
+
+func TestSetNodeCondition(t *testing.T) {
+	transition := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	clientset := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			{Type: TenantRoutingReadyCondition, Status: corev1.ConditionTrue, LastTransitionTime: transition},
+		}},
+	})
+	get := func() map[corev1.NodeConditionType]corev1.NodeCondition {
+		t.Helper()
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conds := map[corev1.NodeConditionType]corev1.NodeCondition{}
+		for _, c := range node.Status.Conditions {
+			conds[c.Type] = c
+		}
+		return conds
+	}
+
+	ready := corev1.NodeCondition{Type: TenantRoutingReadyCondition, Status: corev1.ConditionTrue, Reason: "AllChecksPassed"}
+	if err := SetNodeCondition(context.Background(), clientset, "node-1", ready, time.Second); err != nil {
+		t.Fatalf("SetNodeCondition() error = %v", err)
+	}
+	conds := get()
+	if got := conds[TenantRoutingReadyCondition]; !got.LastTransitionTime.Equal(&transition) || got.Reason != "AllChecksPassed" {
+		t.Errorf("unchanged status: condition = %+v, want transition time kept", got)
+	}
+	if _, ok := conds[corev1.NodeReady]; !ok {
+		t.Errorf("other conditions not preserved: %v", conds)
+	}
+
+	notReady := corev1.NodeCondition{Type: TenantRoutingReadyCondition, Status: corev1.ConditionFalse, Reason: "GatewayUnreachable"}
+	if err := SetNodeCondition(context.Background(), clientset, "node-1", notReady, time.Second); err != nil {
+		t.Fatalf("SetNodeCondition() error = %v", err)
+	}
+	if got := get()[TenantRoutingReadyCondition]; got.Status != corev1.ConditionFalse || got.LastTransitionTime.Equal(&transition) {
+		t.Errorf("changed status: condition = %+v, want new transition time", got)
+	}
+
+	if err := SetNodeCondition(context.Background(), clientset, "missing", ready, time.Second); err == nil {
+		t.Error("SetNodeCondition() expected error for missing node")
+	}
+}
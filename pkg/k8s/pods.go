@@ -0,0 +1,86 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// LivePods is the pod network state of a node (see tenant.LivePods)
+type LivePods = tenant.LivePods
+
+// ListNodePods returns the IPs of live pods scheduled to nodeName ("" lists all nodes)
+// Host-network pods are ignored: the plugin never marks node addresses.
+func ListNodePods(ctx context.Context, clientset kubernetes.Interface, nodeName string, timeout time.Duration) (LivePods, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var opts metav1.ListOptions
+	if nodeName != "" {
+		opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+	}
+
+	var pods *corev1.PodList
+	err := withRetry(ctx, func(ctx context.Context) (err error) {
+		pods, err = clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return LivePods{}, fmt.Errorf("failed to list pods on node %q: %w", nodeName, err)
+	}
+
+	live := LivePods{IPs: map[string]bool{}}
+	for _, pod := range pods.Items {
+		// Field selectors are best-effort for some clients; filter again
+		if nodeName != "" && pod.Spec.NodeName != nodeName {
+			continue
+		}
+		if pod.Spec.HostNetwork || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		if len(pod.Status.PodIPs) == 0 && pod.Status.PodIP == "" {
+			live.Pending = append(live.Pending, pod.Namespace+"/"+pod.Name)
+			continue
+		}
+		if pod.Status.PodIP != "" {
+			live.IPs[pod.Status.PodIP] = true
+		}
+		for _, ip := range pod.Status.PodIPs {
+			live.IPs[ip.IP] = true
+		}
+	}
+	sort.Strings(live.Pending)
+
+	return live, nil
+}
+
+// terminatedAt reports whether pod reached phase Succeeded or Failed, and when: the
+// last time one of its containers finished, else when its Ready condition last changed
+func terminatedAt(pod *corev1.Pod) (time.Time, bool) {
+	if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		return time.Time{}, false
+	}
+	var at time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if t := status.State.Terminated; t != nil && t.FinishedAt.Time.After(at) {
+			at = t.FinishedAt.Time
+		}
+	}
+	if at.IsZero() {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady {
+				at = cond.LastTransitionTime.Time
+			}
+		}
+	}
+	return at, true
+}
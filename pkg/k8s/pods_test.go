@@ -0,0 +1,72 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func nodePod(name, node, ip string, phase corev1.PodPhase, hostNetwork bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+		Spec:       corev1.PodSpec{NodeName: node, HostNetwork: hostNetwork},
+		Status:     corev1.PodStatus{Phase: phase, PodIP: ip},
+	}
+	if ip != "" {
+		pod.Status.PodIPs = []corev1.PodIP{{IP: ip}}
+	}
+	return pod
+}
+
+// TestListNodePods verifies live IPs, pending pods and the ignored pod kinds
+func TestListNodePods(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		nodePod("running", "node-1", "10.200.0.2", corev1.PodRunning, false),
+		nodePod("starting", "node-1", "", corev1.PodPending, false),
+		nodePod("done", "node-1", "10.200.0.3", corev1.PodSucceeded, false),
+		nodePod("host", "node-1", "192.168.0.10", corev1.PodRunning, true),
+		nodePod("elsewhere", "node-2", "10.200.1.2", corev1.PodRunning, false),
+	)
+
+	live, err := ListNodePods(context.Background(), clientset, "node-1", K8sAPITimeout)
+	if err != nil {
+		t.Fatalf("ListNodePods() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(live.IPs, map[string]bool{"10.200.0.2": true}) {
+		t.Errorf("IPs = %v, want only 10.200.0.2", live.IPs)
+	}
+	if !reflect.DeepEqual(live.Pending, []string{"team-a/starting"}) {
+		t.Errorf("Pending = %v, want [team-a/starting]", live.Pending)
+	}
+}
+
+// TestTerminatedAt verifies the phases that count as terminated and the time taken
+func TestTerminatedAt(t *testing.T) {
+	finished := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ready := finished.Add(time.Second)
+
+	if _, terminated := terminatedAt(nodePod("web", "node-1", "10.200.0.2", corev1.PodRunning, false)); terminated {
+		t.Error("running pod reported terminated")
+	}
+
+	job := nodePod("job", "node-1", "10.200.0.3", corev1.PodSucceeded, false)
+	job.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, LastTransitionTime: metav1.NewTime(ready)}}
+	if at, terminated := terminatedAt(job); !terminated || !at.Equal(ready) {
+		t.Errorf("terminatedAt() without container statuses = %v, %t; want %v from the Ready condition", at, terminated, ready)
+	}
+
+	job.Status.Phase = corev1.PodFailed
+	job.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finished)}}},
+		{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finished.Add(-time.Minute))}}},
+	}
+	if at, terminated := terminatedAt(job); !terminated || !at.Equal(finished) {
+		t.Errorf("terminatedAt() = %v, %t; want %v, the last container finish", at, terminated, finished)
+	}
+}
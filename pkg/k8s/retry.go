@@ -0,0 +1,155 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+const (
+	// DefaultRetryAttempts is how often an API read is tried by default (first try included)
+	DefaultRetryAttempts = 3
+
+	// DefaultRetryBackoff is the default delay before the first retry; it doubles per retry
+	DefaultRetryBackoff = 200 * time.Millisecond
+
+	// MaxRetryBackoff caps the delay between two attempts, including server-suggested ones
+	MaxRetryBackoff = 2 * time.Second
+)
+
+// RetryPolicy bounds how API reads are retried after transient failures
+type RetryPolicy struct {
+	// Attempts is the number of tries including the first; 1 disables retries
+	Attempts int
+
+	// Backoff is the delay before the first retry; it doubles per retry up to MaxRetryBackoff
+	// A longer Retry-After suggested by the API server (429) is honored within the cap.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy is used until SetRetryPolicy is called
+var DefaultRetryPolicy = RetryPolicy{Attempts: DefaultRetryAttempts, Backoff: DefaultRetryBackoff}
+
+// retryPolicy applies to every API read of the process
+var retryPolicy = DefaultRetryPolicy
+
+// SetRetryPolicy sets how every following API read of the process is retried
+// Zero fields select the defaults. Retries never outlast the timeout of the call.
+func SetRetryPolicy(p RetryPolicy) {
+	if p.Attempts <= 0 {
+		p.Attempts = DefaultRetryAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = DefaultRetryBackoff
+	}
+	retryPolicy = p
+}
+
+// IsTransient reports whether err is an API failure worth retrying: throttling (429),
+// server-side timeouts and unavailability (5xx), or a dropped or refused connection
+// Definitive answers (not found, forbidden, invalid) and expired contexts are not.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if apierrors.IsTooManyRequests(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) || apierrors.IsUnexpectedServerError(err) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// sleepFunc waits d or until ctx is done; replaced in tests
+var sleepFunc = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ErrK8sUnavailable is matched (errors.Is) by API failures that outlasted the retries:
+// transient failures (see IsTransient) and calls that ran out of time
+// Definitive answers such as not found or forbidden do not match it.
+var ErrK8sUnavailable = tenant.ErrAPIUnavailable
+
+// unavailableError marks err as a match of ErrK8sUnavailable, keeping its message
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string { return e.err.Error() }
+
+func (e *unavailableError) Unwrap() error { return e.err }
+
+func (e *unavailableError) Is(target error) bool { return target == ErrK8sUnavailable }
+
+// notFoundError marks a NotFound answer as a match of tenant.ErrNotFound, keeping the
+// API status for apierrors.IsNotFound
+type notFoundError struct {
+	err error
+}
+
+func (e *notFoundError) Error() string { return e.err.Error() }
+
+func (e *notFoundError) Unwrap() error { return e.err }
+
+func (e *notFoundError) Is(target error) bool { return target == tenant.ErrNotFound }
+
+// withRetry calls fn until it succeeds, fails permanently (see IsTransient) or the
+// attempts of the retry policy are used up, backing off exponentially in between
+// Gives up early, returning the last error, if the next attempt would start after the
+// deadline of ctx. A transient last error or an expired deadline matches ErrK8sUnavailable,
+// a NotFound answer tenant.ErrNotFound.
+func withRetry(ctx context.Context, fn func(context.Context) error) error {
+	err := retry(ctx, fn)
+	if IsTransient(err) || errors.Is(err, context.DeadlineExceeded) {
+		return &unavailableError{err: err}
+	}
+	if apierrors.IsNotFound(err) {
+		return &notFoundError{err: err}
+	}
+	return err
+}
+
+// retry is withRetry without the marking of the last error
+func retry(ctx context.Context, fn func(context.Context) error) error {
+	policy := retryPolicy
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= policy.Attempts || !IsTransient(err) {
+			return err
+		}
+
+		delay := backoff
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > delay {
+			delay = time.Duration(seconds) * time.Second
+		}
+		if delay > MaxRetryBackoff {
+			delay = MaxRetryBackoff
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return err
+		}
+		if sleepFunc(ctx, delay) != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
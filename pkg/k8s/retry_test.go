@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// useRetryPolicy sets policy and records backoff delays instead of sleeping
+func useRetryPolicy(t *testing.T, policy RetryPolicy) *[]time.Duration {
+	t.Helper()
+	origPolicy, origSleep := retryPolicy, sleepFunc
+	t.Cleanup(func() { retryPolicy, sleepFunc = origPolicy, origSleep })
+	SetRetryPolicy(policy)
+	var delays []time.Duration
+	sleepFunc = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return &delays
+}
+
+// TestGetRoutingAnnotations_Retry verifies transient pod lookup failures are retried with backoff
+func TestGetRoutingAnnotations_Retry(t *testing.T) {
+	delays := useRetryPolicy(t, RetryPolicy{Attempts: 4, Backoff: 100 * time.Millisecond})
+
+	clientset := fake.NewSimpleClientset(testPod(map[string]string{testFwmarkKey: "0x10"}), testNamespace(nil))
+	failures := []error{
+		apierrors.NewTooManyRequests("slow down", 1),
+		fmt.Errorf("dial tcp 10.96.0.1:443: %w", syscall.ECONNREFUSED),
+	}
+	clientset.PrependReactor("get", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if len(failures) == 0 {
+			return false, nil, nil
+		}
+		err := failures[0]
+		failures = failures[1:]
+		return true, nil, err
+	})
+
+	got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+	if err != nil {
+		t.Fatalf("GetRoutingAnnotations() error = %v", err)
+	}
+	if got.Fwmark != "0x10" {
+		t.Errorf("Fwmark = %q, want 0x10", got.Fwmark)
+	}
+	// The 429 suggests 1s, more than the 100ms backoff; the second retry doubles the backoff
+	if len(*delays) != 2 || (*delays)[0] != time.Second || (*delays)[1] != 200*time.Millisecond {
+		t.Errorf("delays = %v, want [1s 200ms]", *delays)
+	}
+}
+
+// TestGetRoutingAnnotations_NoRetry verifies definitive failures and exhausted attempts are
+// returned, only the latter as ErrK8sUnavailable
+func TestGetRoutingAnnotations_NoRetry(t *testing.T) {
+	delays := useRetryPolicy(t, RetryPolicy{Attempts: 3, Backoff: 100 * time.Millisecond})
+
+	clientset := fake.NewSimpleClientset(testNamespace(nil))
+	if _, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey); !apierrors.IsNotFound(err) ||
+		errors.Is(err, ErrK8sUnavailable) {
+		t.Errorf("error = %v, want not found", err)
+	}
+	if len(*delays) != 0 {
+		t.Errorf("not found retried: delays = %v", *delays)
+	}
+
+	calls := 0
+	clientset.PrependReactor("get", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		return true, nil, apierrors.NewServiceUnavailable("apiserver overloaded")
+	})
+	if _, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey); !apierrors.IsServiceUnavailable(err) ||
+		!errors.Is(err, ErrK8sUnavailable) {
+		t.Errorf("error = %v, want service unavailable matching ErrK8sUnavailable", err)
+	}
+	if calls != 3 {
+		t.Errorf("pod fetched %d times, want 3", calls)
+	}
+}
+
+// TestWithRetry_Deadline verifies no retry starts after the deadline of the call
+func TestWithRetry_Deadline(t *testing.T) {
+	delays := useRetryPolicy(t, RetryPolicy{Attempts: 5, Backoff: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := withRetry(ctx, func(context.Context) error {
+		calls++
+		return apierrors.NewInternalError(errors.New("etcd leader changed"))
+	})
+	if !apierrors.IsInternalError(err) || calls != 1 || len(*delays) != 0 {
+		t.Errorf("withRetry() = %v after %d calls, delays %v; want the first error without retry", err, calls, *delays)
+	}
+}
+
+// TestIsTransient classifies API and connection errors
+func TestIsTransient(t *testing.T) {
+	gr := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{apierrors.NewTooManyRequests("", 0), true},
+		{apierrors.NewServerTimeout(gr, "get", 1), true},
+		{apierrors.NewInternalError(errors.New("boom")), true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{apierrors.NewNotFound(gr, "web"), false},
+		{apierrors.NewForbidden(gr, "web", errors.New("rbac")), false},
+		{context.DeadlineExceeded, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
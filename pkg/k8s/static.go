@@ -0,0 +1,53 @@
+package k8s
+
+import (
+	"errors"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// StaticTenant is the tenant the plugin configuration assigns to a namespace (see
+// tenant.StaticTenant)
+type StaticTenant = tenant.StaticTenant
+
+// SetStaticTenants sets the tenants of namespaces every following lookup falls back to
+// (see tenant.SetStaticTenants)
+func SetStaticTenants(tenants map[string]StaticTenant) {
+	tenant.SetStaticTenants(tenants)
+}
+
+// StaticRoutingAnnotations returns the routing annotations the static tenant of namespace
+// assigns (see tenant.StaticRoutingAnnotations)
+func StaticRoutingAnnotations(namespace string) (annotations RoutingAnnotations, ok bool, err error) {
+	return tenant.StaticRoutingAnnotations(namespace)
+}
+
+// withStaticTenant completes result with the static tenant of namespace if no
+// annotation or label assigned a fwmark; the gateway comes along unless annotated
+func withStaticTenant(result RoutingAnnotations, namespace string, gatewayFound bool) (RoutingAnnotations, error) {
+	static, ok, err := StaticRoutingAnnotations(namespace)
+	if !ok || err != nil {
+		return result, err
+	}
+	result.Fwmark, result.Table, result.Source = static.Fwmark, static.Table, static.Source
+	if !gatewayFound {
+		result.Gateway = static.Gateway
+	}
+	return result, nil
+}
+
+// staticFallback answers a lookup that failed because the API server could not be asked
+// with the static tenant of namespace, keeping the failure in FallbackError; lookups that
+// failed on an invalid annotation or a missing pod are not answered, nor are namespaces
+// without a valid static tenant
+func staticFallback(namespace string, lookupErr error) (RoutingAnnotations, bool) {
+	if errors.Is(lookupErr, ErrInvalidFwmark) || errors.Is(lookupErr, ErrInvalidGateway) || errors.Is(lookupErr, tenant.ErrNotFound) {
+		return RoutingAnnotations{}, false
+	}
+	static, ok, err := StaticRoutingAnnotations(namespace)
+	if !ok || err != nil {
+		return RoutingAnnotations{}, false
+	}
+	static.FallbackError = lookupErr
+	return static, true
+}
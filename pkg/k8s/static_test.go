@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// TestSetStaticTenants_Resolution verifies static tenants are the last fallback and
+// answer for their namespace while the API server is down
+func TestSetStaticTenants_Resolution(t *testing.T) {
+	SetStaticTenants(map[string]StaticTenant{
+		"team-a": {Fwmark: "0x10", Table: 100, Gateway: "10.10.10.131"},
+		"team-b": {Fwmark: "0x99"},
+	})
+	t.Cleanup(func() { SetStaticTenants(nil) })
+	if err := SetNamespaceLabelRules([]NamespaceLabelRule{{Selector: "tenant=b", Fwmark: "0x20"}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetNamespaceLabelRules(nil) })
+
+	tests := []struct {
+		name        string
+		pod         map[string]string
+		labels      map[string]string
+		wantFwmark  string
+		wantGateway string
+		wantSource  TenantSource
+	}{
+		{name: "static tenant", wantFwmark: "0x10", wantGateway: "10.10.10.131", wantSource: TenantSourceStatic},
+		{name: "annotated gateway wins", pod: map[string]string{testGatewayKey: "10.10.10.132"},
+			wantFwmark: "0x10", wantGateway: "10.10.10.132", wantSource: TenantSourceStatic},
+		{name: "pod annotation wins", pod: map[string]string{testFwmarkKey: "0x20"},
+			wantFwmark: "0x20", wantSource: TenantSourcePod},
+		{name: "namespace labels win", labels: map[string]string{"tenant": "b"},
+			wantFwmark: "0x20", wantSource: TenantSourceNamespaceLabels},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := testNamespace(nil)
+			ns.Labels = tt.labels
+			clientset := fake.NewSimpleClientset(testPod(tt.pod), ns)
+
+			got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Fwmark != tt.wantFwmark || got.Gateway != tt.wantGateway || got.Source != tt.wantSource {
+				t.Errorf("got fwmark %q gateway %q from %q, want %q %q from %q", got.Fwmark, got.Gateway, got.Source,
+					tt.wantFwmark, tt.wantGateway, tt.wantSource)
+			}
+			if got.FallbackError != nil {
+				t.Errorf("FallbackError = %v with the API server up", got.FallbackError)
+			}
+		})
+	}
+
+	// The API server is down: listed namespaces get their static tenant
+	useRetryPolicy(t, RetryPolicy{Attempts: 1})
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("get", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("dial tcp 10.96.0.1:443: %w", syscall.ECONNREFUSED)
+	})
+	fwmark, err := GetFwmark(context.Background(), clientset, "web", "team-a", testFwmarkKeys)
+	if err != nil || fwmark != "0x10" {
+		t.Errorf("GetFwmark() with the API server down = %q, %v; want the static 0x10", fwmark, err)
+	}
+	got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+	if err != nil || got.Table != 100 || !errors.Is(got.FallbackError, syscall.ECONNREFUSED) {
+		t.Errorf("GetRoutingAnnotations() with the API server down = %+v, %v; want table 100 and the API error",
+			got, err)
+	}
+	if _, err := GetFwmark(context.Background(), clientset, "web", "team-c", testFwmarkKeys); err == nil {
+		t.Error("GetFwmark() answered an unlisted namespace with the API server down")
+	}
+	// An invalid static tenant fails like an invalid annotation; it is not a fallback
+	if _, err := GetFwmark(context.Background(), clientset, "web", "team-b", testFwmarkKeys); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("GetFwmark() of an invalid static tenant with the API server down: error = %v, want the API error", err)
+	}
+	if _, _, err := StaticRoutingAnnotations("team-b"); !errors.Is(err, ErrInvalidFwmark) {
+		t.Errorf("StaticRoutingAnnotations() of an invalid fwmark: error = %v, want ErrInvalidFwmark", err)
+	}
+	// A missing pod is not the API server being down
+	if _, err := GetFwmark(context.Background(), fake.NewSimpleClientset(), "web", "team-a", testFwmarkKeys); err == nil {
+		t.Error("GetFwmark() answered a missing pod from the static tenant")
+	}
+}
@@ -0,0 +1,39 @@
+package k8s
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// TenantSource tells where the tenant of a pod was found (see tenant.Source)
+type TenantSource = tenant.Source
+
+const (
+	TenantSourcePod             = tenant.SourcePod
+	TenantSourceNamespace       = tenant.SourceNamespace
+	TenantSourceNamespaceLabels = tenant.SourceNamespaceLabels
+	TenantSourceStatic          = tenant.SourceStatic
+)
+
+// TenantMask covers the mark bits of tenant fwmarks; CONNMARK save/restore and the
+// tenant mark matches of the enforcement and connection limit chains use it
+const TenantMask = api.TenantMask
+
+// Tenant is the typed routing identity of a pod (see tenant.Tenant)
+type Tenant = tenant.Tenant
+
+// ResolveTenant resolves the tenant of a pod like GetRoutingAnnotationsWithTimeout and
+// returns it typed; nil (and no error) if the pod has no tenant
+func ResolveTenant(ctx context.Context, clientset kubernetes.Interface, podName, podNamespace string, fwmarkKeys []string, gatewayKey string,
+	timeout time.Duration) (*Tenant, error) {
+	annotations, err := GetRoutingAnnotationsWithTimeout(ctx, clientset, podName, podNamespace, fwmarkKeys, gatewayKey, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return annotations.Tenant()
+}
@@ -0,0 +1,73 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestResolveTenant verifies the typed tenant and where it was found
+func TestResolveTenant(t *testing.T) {
+	tests := []struct {
+		name        string
+		pod         map[string]string
+		ns          map[string]string
+		wantNil     bool
+		wantFwmark  uint32
+		wantGateway string
+		wantSource  TenantSource
+	}{
+		{
+			name:        "pod",
+			pod:         map[string]string{testFwmarkKey: "0x10", testGatewayKey: "10.10.10.131"},
+			ns:          map[string]string{testFwmarkKey: "0x20"},
+			wantFwmark:  0x10,
+			wantGateway: "10.10.10.131",
+			wantSource:  TenantSourcePod,
+		},
+		{
+			name:       "namespace",
+			ns:         map[string]string{testFwmarkKey: "0x20"},
+			wantFwmark: 0x20,
+			wantSource: TenantSourceNamespace,
+		},
+		{
+			name:    "no tenant",
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(testPod(tt.pod), testNamespace(tt.ns))
+
+			tenant, err := ResolveTenant(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantNil {
+				if tenant != nil {
+					t.Fatalf("tenant = %v, want none", tenant)
+				}
+				return
+			}
+			if tenant == nil {
+				t.Fatal("tenant = nil")
+			}
+			if tenant.Fwmark != tt.wantFwmark || tenant.Mask != TenantMask {
+				t.Errorf("mark = 0x%x/0x%x, want 0x%x/0x%x", tenant.Fwmark, tenant.Mask, tt.wantFwmark, TenantMask)
+			}
+			if got := tenant.Gateway.String(); tt.wantGateway != "" && got != tt.wantGateway {
+				t.Errorf("Gateway = %s, want %s", got, tt.wantGateway)
+			}
+			if tt.wantGateway == "" && tenant.Gateway != nil {
+				t.Errorf("Gateway = %s, want none", tenant.Gateway)
+			}
+			if tenant.Source != tt.wantSource {
+				t.Errorf("Source = %q, want %q", tenant.Source, tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+)
+
+// TenantNameAnnotationKey is the pod or namespace annotation naming the TenantRoute of
+// the pod, as an alternative to annotating the raw fwmark
+const TenantNameAnnotationKey = api.TenantNameAnnotationKey
+
+// TenantRouteResource is the cluster-scoped TenantRoute custom resource (api/tenantroute-crd.yaml)
+var TenantRouteResource = schema.GroupVersionResource{Group: "tenant.routing", Version: "v1alpha1", Resource: "tenantroutes"}
+
+// TenantRoute is the routing spec of a named tenant
+type TenantRoute struct {
+	// Name is the tenant name pods and namespaces annotate
+	Name string
+
+	// Fwmark is the validated fwmark of the tenant's pods
+	Fwmark string
+
+	// Gateway is the validated egress gateway of the tenant ('' for the configured one)
+	Gateway string
+
+	// Table is the routing table the tenant expects the plugin configuration to
+	// assign to Fwmark (0 if the TenantRoute does not say)
+	Table int
+}
+
+// tenantRouteObject is the part of a TenantRoute object the plugin reads
+type tenantRouteObject struct {
+	Spec struct {
+		Fwmark  string `json:"fwmark"`
+		Gateway string `json:"gateway,omitempty"`
+		Table   int    `json:"table,omitempty"`
+	} `json:"spec"`
+}
+
+// getTenantRouteFunc fetches the raw TenantRoute object of a tenant; replaced in tests
+var getTenantRouteFunc = func(ctx context.Context, clientset kubernetes.Interface, name string) ([]byte, error) {
+	rc := clientset.Discovery().RESTClient()
+	if rc == nil {
+		return nil, errors.New("client has no REST access to custom resources")
+	}
+	return rc.Get().AbsPath("/apis", TenantRouteResource.Group, TenantRouteResource.Version,
+		TenantRouteResource.Resource, name).Do(ctx).Raw()
+}
+
+// GetTenantRoute resolves a tenant name to its validated TenantRoute
+// A tenant without a TenantRoute, or whose TenantRoute has an invalid fwmark, fails
+// like an invalid fwmark annotation (ErrInvalidFwmark); an invalid gateway fails like
+// an invalid gateway annotation.
+func GetTenantRoute(ctx context.Context, clientset kubernetes.Interface, name string) (*TenantRoute, error) {
+	var data []byte
+	err := withRetry(ctx, func(ctx context.Context) (err error) {
+		data, err = getTenantRouteFunc(ctx, clientset, name)
+		return err
+	})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, &api.InvalidError{Kind: ErrInvalidFwmark, Msg: fmt.Sprintf("tenant '%s' has no TenantRoute", name)}
+		}
+		return nil, fmt.Errorf("failed to get TenantRoute %s: %w", name, err)
+	}
+
+	var obj tenantRouteObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("failed to decode TenantRoute %s: %w", name, err)
+	}
+	if err := validateFwmark(obj.Spec.Fwmark); err != nil {
+		return nil, fmt.Errorf("invalid fwmark in TenantRoute %s: %w", name, err)
+	}
+	if obj.Spec.Gateway != "" {
+		if err := validateGateway(obj.Spec.Gateway); err != nil {
+			return nil, fmt.Errorf("invalid gateway in TenantRoute %s: %w", name, err)
+		}
+	}
+	if obj.Spec.Table < 0 {
+		return nil, &api.InvalidError{Kind: ErrInvalidFwmark,
+			Msg: fmt.Sprintf("invalid table %d in TenantRoute %s", obj.Spec.Table, name)}
+	}
+	return &TenantRoute{Name: name, Fwmark: obj.Spec.Fwmark, Gateway: obj.Spec.Gateway, Table: obj.Spec.Table}, nil
+}
@@ -0,0 +1,115 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+)
+
+// fakeTenantRoutes serves TenantRoute objects by name for the duration of a test
+func fakeTenantRoutes(t *testing.T, objects map[string]string) {
+	orig := getTenantRouteFunc
+	getTenantRouteFunc = func(_ context.Context, _ kubernetes.Interface, name string) ([]byte, error) {
+		obj, ok := objects[name]
+		if !ok {
+			return nil, apierrors.NewNotFound(TenantRouteResource.GroupResource(), name)
+		}
+		return []byte(obj), nil
+	}
+	t.Cleanup(func() { getTenantRouteFunc = orig })
+}
+
+// TestGetRoutingAnnotations_TenantName verifies tenant names resolve through TenantRoutes
+func TestGetRoutingAnnotations_TenantName(t *testing.T) {
+	fakeTenantRoutes(t, map[string]string{
+		"tenant-a": `{"spec": {"fwmark": "0x10", "table": 100, "gateway": "10.10.10.131"}}`,
+		"tenant-b": `{"spec": {"fwmark": "0x20"}}`,
+		"bad-mark": `{"spec": {"fwmark": "0x99"}}`,
+		"bad-gw":   `{"spec": {"fwmark": "0x10", "gateway": "224.0.0.1"}}`,
+	})
+
+	tests := []struct {
+		name        string
+		pod         map[string]string
+		ns          map[string]string
+		wantFwmark  string
+		wantGateway string
+		wantTenant  string
+		wantTable   int
+		wantErr     error
+	}{
+		{
+			name:        "pod tenant",
+			pod:         map[string]string{TenantNameAnnotationKey: "tenant-a"},
+			wantFwmark:  "0x10",
+			wantGateway: "10.10.10.131",
+			wantTenant:  "tenant-a",
+			wantTable:   100,
+		},
+		{
+			name:        "namespace tenant, gateway annotated on the pod",
+			pod:         map[string]string{testGatewayKey: "10.10.10.184"},
+			ns:          map[string]string{TenantNameAnnotationKey: "tenant-a"},
+			wantFwmark:  "0x10",
+			wantGateway: "10.10.10.184",
+			wantTenant:  "tenant-a",
+			wantTable:   100,
+		},
+		{
+			name:        "pod tenant over namespace fwmark",
+			pod:         map[string]string{TenantNameAnnotationKey: "tenant-b"},
+			ns:          map[string]string{testFwmarkKey: "0x10", testGatewayKey: "10.10.10.184"},
+			wantFwmark:  "0x20",
+			wantGateway: "10.10.10.184",
+			wantTenant:  "tenant-b",
+		},
+		{
+			name:       "fwmark annotation over tenant on the same object",
+			ns:         map[string]string{testFwmarkKey: "0x20", TenantNameAnnotationKey: "tenant-a"},
+			wantFwmark: "0x20",
+		},
+		{
+			name:    "unknown tenant",
+			pod:     map[string]string{TenantNameAnnotationKey: "tenant-c"},
+			wantErr: ErrInvalidFwmark,
+		},
+		{
+			name:    "invalid fwmark in TenantRoute",
+			ns:      map[string]string{TenantNameAnnotationKey: "bad-mark"},
+			wantErr: ErrInvalidFwmark,
+		},
+		{
+			name:    "invalid gateway in TenantRoute",
+			pod:     map[string]string{TenantNameAnnotationKey: "bad-gw"},
+			wantErr: ErrInvalidGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(testPod(tt.pod), testNamespace(tt.ns))
+
+			got, err := GetRoutingAnnotations(context.Background(), clientset, "web", "team-a", testFwmarkKeys, testGatewayKey)
+			if tt.wantErr != nil {
+				var invalid *api.InvalidError
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &invalid) || apierrors.IsNotFound(err) {
+					t.Fatalf("error = %v, want an api.InvalidError of %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Fwmark != tt.wantFwmark || got.Gateway != tt.wantGateway || got.TenantName != tt.wantTenant ||
+				got.Table != tt.wantTable {
+				t.Errorf("GetRoutingAnnotations() = %+v, want fwmark %q, gateway %q, tenant %q, table %d",
+					got, tt.wantFwmark, tt.wantGateway, tt.wantTenant, tt.wantTable)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TenantNodeStatus is what one node reports about the pods of a tenant
+type TenantNodeStatus struct {
+	// Pods is the number of the tenant's pods attached on the node
+	Pods int
+
+	// VerifiedPods and DriftedPods are what the node agent's last full reconcile pass
+	// verified and found missing a rule
+	VerifiedPods int
+	DriftedPods  int
+}
+
+// tenantRouteStatus is the status subresource of a TenantRoute: the node reports and
+// their cluster-wide sums
+type tenantRouteStatus struct {
+	Pods         int                     `json:"pods"`
+	VerifiedPods int                     `json:"verifiedPods"`
+	DriftedPods  int                     `json:"driftedPods"`
+	Nodes        []tenantRouteNodeStatus `json:"nodes,omitempty"`
+}
+
+// tenantRouteNodeStatus is the report of one node in a TenantRoute status
+type tenantRouteNodeStatus struct {
+	Node         string    `json:"node"`
+	Pods         int       `json:"pods"`
+	VerifiedPods int       `json:"verifiedPods"`
+	DriftedPods  int       `json:"driftedPods"`
+	ReportTime   time.Time `json:"reportTime"`
+}
+
+// maxStatusConflicts bounds how often a status update is retried after another node
+// updated the same TenantRoute first
+const maxStatusConflicts = 5
+
+// listTenantRoutesFunc fetches the raw TenantRoute list; replaced in tests
+var listTenantRoutesFunc = func(ctx context.Context, clientset kubernetes.Interface) ([]byte, error) {
+	rc := clientset.Discovery().RESTClient()
+	if rc == nil {
+		return nil, errors.New("client has no REST access to custom resources")
+	}
+	return rc.Get().AbsPath("/apis", TenantRouteResource.Group, TenantRouteResource.Version,
+		TenantRouteResource.Resource).Do(ctx).Raw()
+}
+
+// updateTenantRouteStatusFunc replaces the status of a TenantRoute with obj; replaced in tests
+// The resourceVersion of obj makes the update fail with a conflict if the object changed.
+var updateTenantRouteStatusFunc = func(ctx context.Context, clientset kubernetes.Interface, name string, obj []byte) error {
+	rc := clientset.Discovery().RESTClient()
+	if rc == nil {
+		return errors.New("client has no REST access to custom resources")
+	}
+	return rc.Put().AbsPath("/apis", TenantRouteResource.Group, TenantRouteResource.Version,
+		TenantRouteResource.Resource, name, "status").Body(obj).Do(ctx).Error()
+}
+
+// ReportTenantStatus publishes the per-tenant reports of node in the status of every
+// TenantRoute, keyed by fwmark, and updates the cluster-wide sums next to them
+//
+// Each node owns its entry in status.nodes; the sums always cover the entries of the
+// same update, and conflicting updates of other nodes are retried on the fresh object.
+// Entries of other nodes that did not report for staleAfter are dropped (0 keeps
+// them), so deleted nodes leave the sums. A TenantRoute is only written when the
+// report of node changed or its entry is half way to stale, and a node without pods
+// of the tenant removes its entry instead of reporting zeros.
+func ReportTenantStatus(ctx context.Context, clientset kubernetes.Interface, node string, reports map[string]TenantNodeStatus,
+	staleAfter, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var data []byte
+	err := withRetry(ctx, func(ctx context.Context) (err error) {
+		data, err = listTenantRoutesFunc(ctx, clientset)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list TenantRoutes: %w", err)
+	}
+	var list struct {
+		Items []map[string]any `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to decode TenantRoutes: %w", err)
+	}
+
+	var errs []error
+	for _, obj := range list.Items {
+		if err := reportTenantRoute(ctx, clientset, obj, node, reports, staleAfter); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reportTenantRoute writes the report of node into the status of one TenantRoute object
+func reportTenantRoute(ctx context.Context, clientset kubernetes.Interface, obj map[string]any, node string,
+	reports map[string]TenantNodeStatus, staleAfter time.Duration) error {
+	meta, _ := obj["metadata"].(map[string]any)
+	name, _ := meta["name"].(string)
+	for attempt := 1; ; attempt++ {
+		spec, _ := obj["spec"].(map[string]any)
+		fwmark, _ := spec["fwmark"].(string)
+		status, err := decodeTenantRouteStatus(obj)
+		if err != nil {
+			return fmt.Errorf("TenantRoute %s: %w", name, err)
+		}
+		if !mergeNodeStatus(status, node, reports[fwmark], nowFunc(), staleAfter) {
+			return nil
+		}
+		obj["status"] = status
+		body, err := json.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode TenantRoute %s: %w", name, err)
+		}
+		err = updateTenantRouteStatusFunc(ctx, clientset, name, body)
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsConflict(err) || attempt >= maxStatusConflicts {
+			return fmt.Errorf("failed to update the status of TenantRoute %s: %w", name, err)
+		}
+		// Another node reported first: merge into its update
+		var fresh []byte
+		err = withRetry(ctx, func(ctx context.Context) (err error) {
+			fresh, err = getTenantRouteFunc(ctx, clientset, name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get TenantRoute %s: %w", name, err)
+		}
+		obj = map[string]any{}
+		if err := json.Unmarshal(fresh, &obj); err != nil {
+			return fmt.Errorf("failed to decode TenantRoute %s: %w", name, err)
+		}
+	}
+}
+
+// decodeTenantRouteStatus returns the status of a decoded TenantRoute object
+func decodeTenantRouteStatus(obj map[string]any) (*tenantRouteStatus, error) {
+	status := &tenantRouteStatus{}
+	raw, ok := obj["status"]
+	if !ok {
+		return status, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, fmt.Errorf("invalid status: %w", err)
+	}
+	return status, nil
+}
+
+// mergeNodeStatus sets the entry of node in status to report at now, drops the
+// entries stale at now and recomputes the sums; it reports whether status must be written
+func mergeNodeStatus(status *tenantRouteStatus, node string, report TenantNodeStatus, now time.Time,
+	staleAfter time.Duration) bool {
+	changed := false
+	nodes := status.Nodes[:0:0]
+	var own *tenantRouteNodeStatus
+	for i := range status.Nodes {
+		entry := status.Nodes[i]
+		switch {
+		case entry.Node == node:
+			own = &status.Nodes[i]
+		case staleAfter > 0 && now.Sub(entry.ReportTime) > staleAfter:
+			changed = true
+			continue
+		}
+		if entry.Node != node {
+			nodes = append(nodes, entry)
+		}
+	}
+
+	entry := tenantRouteNodeStatus{Node: node, Pods: report.Pods, VerifiedPods: report.VerifiedPods,
+		DriftedPods: report.DriftedPods, ReportTime: now}
+	switch {
+	case report == (TenantNodeStatus{}):
+		changed = changed || own != nil
+	case own == nil || own.Pods != entry.Pods || own.VerifiedPods != entry.VerifiedPods || own.DriftedPods != entry.DriftedPods ||
+		staleAfter > 0 && now.Sub(own.ReportTime) > staleAfter/2:
+		nodes = append(nodes, entry)
+		changed = true
+	default:
+		nodes = append(nodes, *own)
+	}
+	if !changed {
+		return false
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	*status = tenantRouteStatus{Nodes: nodes}
+	for _, n := range nodes {
+		status.Pods += n.Pods
+		status.VerifiedPods += n.VerifiedPods
+		status.DriftedPods += n.DriftedPods
+	}
+	return true
+}
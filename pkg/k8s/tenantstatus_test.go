@@ -0,0 +1,98 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestMergeNodeStatus verifies node entries are replaced, expired and summed
+func TestMergeNodeStatus(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	const staleAfter = 5 * time.Minute
+	status := &tenantRouteStatus{Nodes: []tenantRouteNodeStatus{
+		{Node: "node-b", Pods: 3, VerifiedPods: 3, ReportTime: now.Add(-time.Minute)},
+		{Node: "node-c", Pods: 7, ReportTime: now.Add(-time.Hour)},
+	}}
+
+	if !mergeNodeStatus(status, "node-a", TenantNodeStatus{Pods: 2, VerifiedPods: 2, DriftedPods: 1}, now, staleAfter) {
+		t.Fatal("new report not written")
+	}
+	if len(status.Nodes) != 2 || status.Nodes[0].Node != "node-a" || status.Nodes[1].Node != "node-b" {
+		t.Fatalf("nodes = %+v; want node-a and node-b without the stale node-c", status.Nodes)
+	}
+	if status.Pods != 5 || status.VerifiedPods != 5 || status.DriftedPods != 1 {
+		t.Errorf("sums = %d/%d/%d; want 5/5/1", status.Pods, status.VerifiedPods, status.DriftedPods)
+	}
+
+	// An unchanged report is only rewritten once its entry is half way to stale
+	report := TenantNodeStatus{Pods: 2, VerifiedPods: 2, DriftedPods: 1}
+	if mergeNodeStatus(status, "node-a", report, now.Add(time.Minute), staleAfter) {
+		t.Error("unchanged report written")
+	}
+	if !mergeNodeStatus(status, "node-a", report, now.Add(3*time.Minute), staleAfter) {
+		t.Error("unchanged report not refreshed")
+	}
+
+	// A node without pods of the tenant removes its entry
+	if !mergeNodeStatus(status, "node-a", TenantNodeStatus{}, now.Add(3*time.Minute), staleAfter) {
+		t.Fatal("empty report not written")
+	}
+	if len(status.Nodes) != 1 || status.Pods != 3 || status.DriftedPods != 0 {
+		t.Errorf("status = %+v; want only node-b", status)
+	}
+	if mergeNodeStatus(status, "node-a", TenantNodeStatus{}, now.Add(3*time.Minute), staleAfter) {
+		t.Error("empty report written without an entry")
+	}
+}
+
+// TestReportTenantStatus verifies reports are written per fwmark and conflicts retried
+func TestReportTenantStatus(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	origNow, origList, origUpdate := nowFunc, listTenantRoutesFunc, updateTenantRouteStatusFunc
+	nowFunc = func() time.Time { return now }
+	listTenantRoutesFunc = func(context.Context, kubernetes.Interface) ([]byte, error) {
+		return []byte(`{"items": [
+			{"metadata": {"name": "tenant-a", "resourceVersion": "1"}, "spec": {"fwmark": "0x10"}},
+			{"metadata": {"name": "tenant-b", "resourceVersion": "1"}, "spec": {"fwmark": "0x20"}}]}`), nil
+	}
+	// tenant-a was updated by node-b in the meantime
+	fakeTenantRoutes(t, map[string]string{"tenant-a": `{"metadata": {"name": "tenant-a", "resourceVersion": "2"},
+		"spec": {"fwmark": "0x10"}, "status": {"pods": 4, "verifiedPods": 4, "driftedPods": 0,
+		"nodes": [{"node": "node-b", "pods": 4, "verifiedPods": 4, "driftedPods": 0, "reportTime": "2026-01-02T03:04:00Z"}]}}`})
+	written := map[string]tenantRouteStatus{}
+	updateTenantRouteStatusFunc = func(_ context.Context, _ kubernetes.Interface, name string, body []byte) error {
+		var obj struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+			Status tenantRouteStatus `json:"status"`
+		}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			t.Fatal(err)
+		}
+		if name == "tenant-a" && obj.Metadata.ResourceVersion == "1" {
+			return apierrors.NewConflict(TenantRouteResource.GroupResource(), name, nil)
+		}
+		written[name] = obj.Status
+		return nil
+	}
+	t.Cleanup(func() { nowFunc, listTenantRoutesFunc, updateTenantRouteStatusFunc = origNow, origList, origUpdate })
+
+	reports := map[string]TenantNodeStatus{"0x10": {Pods: 2, VerifiedPods: 2, DriftedPods: 1}}
+	if err := ReportTenantStatus(context.Background(), fake.NewSimpleClientset(), "node-a", reports, time.Hour, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := written["tenant-a"]
+	if !ok || len(got.Nodes) != 2 || got.Pods != 6 || got.VerifiedPods != 6 || got.DriftedPods != 1 {
+		t.Errorf("tenant-a status = %+v; want node-a merged with node-b", got)
+	}
+	if _, ok := written["tenant-b"]; ok {
+		t.Error("tenant-b written without pods on the node")
+	}
+}
@@ -0,0 +1,239 @@
+// Package kuberest is a minimal Kubernetes API client for the agent-only build of the
+// CNI plugin: it reads pods and namespaces over HTTPS with the standard library.
+//
+// The plugin normally asks tenant-routingd for the tenant of a pod. While the agent
+// cannot be reached, the agent-only build resolves the pod from the API server with
+// this package instead of pkg/k8s, which links client-go:
+//
+//	c, err := kuberest.NewClient(conf.Kubeconfig)
+//	annotations, err := c.RoutingAnnotations(ctx, cache, "web", "team-a", podUID,
+//		conf.AnnotationKey, conf.GatewayAnnotationKey, timeout)
+//
+// The resolution follows pkg/k8s for everything stored on pods and namespaces. Tenant
+// name annotations need the TenantRoute API and fail the lookup (see ErrTenantName).
+package kuberest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+const (
+	// DefaultRetryAttempts is how often a read is tried by default (first try included)
+	DefaultRetryAttempts = 3
+
+	// DefaultRetryBackoff is the default delay before the first retry; it doubles per retry
+	DefaultRetryBackoff = 200 * time.Millisecond
+
+	// MaxRetryBackoff caps the delay between two attempts
+	MaxRetryBackoff = 2 * time.Second
+
+	// maxResponseSize bounds the body read of one object; pods are a few KiB
+	maxResponseSize = 4 << 20
+)
+
+// Client reads pods and namespaces from one API server
+type Client struct {
+	server string
+	token  string
+	http   *http.Client
+
+	// Attempts and Backoff bound how reads are retried after transient failures, as
+	// k8s.RetryPolicy does; zero values select the defaults
+	Attempts int
+	Backoff  time.Duration
+}
+
+// ObjectMeta is the metadata the resolution reads
+type ObjectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	UID         string            `json:"uid"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Pod is the part of a pod the resolution reads
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Status   struct {
+		Phase             string `json:"phase"`
+		ContainerStatuses []struct {
+			State struct {
+				Terminated *struct {
+					FinishedAt time.Time `json:"finishedAt"`
+				} `json:"terminated"`
+			} `json:"state"`
+		} `json:"containerStatuses"`
+		Conditions []struct {
+			Type               string    `json:"type"`
+			LastTransitionTime time.Time `json:"lastTransitionTime"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// Namespace is the part of a namespace the resolution reads
+type Namespace struct {
+	Metadata ObjectMeta `json:"metadata"`
+}
+
+// statusError is a failed answer of the API server
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.msg, e.code)
+}
+
+func (e *statusError) Is(target error) bool {
+	return target == tenant.ErrNotFound && e.code == http.StatusNotFound
+}
+
+// unavailableError marks err as a match of tenant.ErrAPIUnavailable, keeping its message
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string { return e.err.Error() }
+
+func (e *unavailableError) Unwrap() error { return e.err }
+
+func (e *unavailableError) Is(target error) bool { return target == tenant.ErrAPIUnavailable }
+
+// GetPod fetches a pod (transient failures are retried within ctx)
+// A missing pod matches tenant.ErrNotFound, a failure that outlasted the retries
+// tenant.ErrAPIUnavailable.
+func (c *Client) GetPod(ctx context.Context, namespace, name string) (*Pod, error) {
+	var pod Pod
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(name)
+	if err := c.get(ctx, path, &pod); err != nil {
+		if errors.Is(err, tenant.ErrNotFound) {
+			return nil, fmt.Errorf("pod %s/%s not found: %w", namespace, name, err)
+		}
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", namespace, name, err)
+	}
+	return &pod, nil
+}
+
+// GetNamespace fetches a namespace, failing like GetPod
+func (c *Client) GetNamespace(ctx context.Context, name string) (*Namespace, error) {
+	var ns Namespace
+	if err := c.get(ctx, "/api/v1/namespaces/"+url.PathEscape(name), &ns); err != nil {
+		if errors.Is(err, tenant.ErrNotFound) {
+			return nil, fmt.Errorf("namespace %s not found: %w", name, err)
+		}
+		return nil, fmt.Errorf("failed to get namespace %s: %w", name, err)
+	}
+	return &ns, nil
+}
+
+// sleepFunc waits d or until ctx is done; replaced in tests
+var sleepFunc = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// get decodes the object at path into obj, retrying transient failures with
+// exponential backoff while the next attempt starts before the deadline of ctx
+func (c *Client) get(ctx context.Context, path string, obj any) error {
+	attempts, backoff := c.Attempts, c.Backoff
+	if attempts <= 0 {
+		attempts = DefaultRetryAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.getOnce(ctx, path, obj)
+		if err == nil {
+			return nil
+		}
+		if !isTransient(err) {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return &unavailableError{err: err}
+			}
+			return err
+		}
+		delay := min(backoff, MaxRetryBackoff)
+		if deadline, ok := ctx.Deadline(); attempt >= attempts || ok && time.Until(deadline) <= delay {
+			return &unavailableError{err: err}
+		}
+		if sleepFunc(ctx, delay) != nil {
+			return &unavailableError{err: err}
+		}
+		backoff *= 2
+	}
+}
+
+// getOnce is one attempt of get
+func (c *Client) getOnce(ctx context.Context, path string, obj any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Failures carry a Status object; its message is the one kubectl prints
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &status) != nil || status.Message == "" {
+			status.Message = http.StatusText(resp.StatusCode)
+		}
+		return &statusError{code: resp.StatusCode, msg: status.Message}
+	}
+	if err := json.Unmarshal(body, obj); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// isTransient reports whether err is a failure worth retrying: throttling (429),
+// server-side unavailability (5xx), or a dropped, refused or timed out connection
+// Definitive answers (not found, forbidden, invalid) and expired contexts are not.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,161 @@
+package kuberest
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// fakeAPIServer serves the objects of a test by path over TLS
+// A path mapped to an int answers that HTTP status instead.
+type fakeAPIServer struct {
+	*httptest.Server
+	objects map[string]any
+	calls   map[string]int
+	token   string
+}
+
+func newFakeAPIServer(t *testing.T, objects map[string]any) *fakeAPIServer {
+	t.Helper()
+	s := &fakeAPIServer{objects: objects, calls: map[string]int{}, token: "secret"}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls[r.URL.Path]++
+		if r.Header.Get("Authorization") != "Bearer "+s.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"kind":"Status","message":"Unauthorized"}`)
+			return
+		}
+		obj, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"kind":"Status","message":"%s not found"}`, r.URL.Path)
+			return
+		}
+		if code, ok := obj.(int); ok {
+			w.WriteHeader(code)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(obj)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// writeKubeconfig writes a kubeconfig trusting s and authenticating with token
+func writeKubeconfig(t *testing.T, server, token string, ca []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	conf := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: node
+contexts:
+- name: node
+  context:
+    cluster: local
+    user: kubelet
+clusters:
+- name: local
+  cluster:
+    server: %s
+    certificate-authority: ca.crt
+users:
+- name: kubelet
+  user:
+    tokenFile: token
+`, server)
+	path := filepath.Join(dir, "kubeconfig")
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestClient returns a client of s, trying each read once
+func newTestClient(t *testing.T, s *fakeAPIServer) *Client {
+	t.Helper()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	c, err := NewClient(writeKubeconfig(t, s.URL, s.token, ca))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.Attempts = 1
+	return c
+}
+
+// TestNewClient verifies the kubeconfig is read with its relative files and that
+// unusable ones are rejected
+func TestNewClient(t *testing.T) {
+	s := newFakeAPIServer(t, map[string]any{
+		"/api/v1/namespaces/team-a": Namespace{Metadata: ObjectMeta{Name: "team-a"}},
+	})
+	c := newTestClient(t, s)
+	if ns, err := c.GetNamespace(context.Background(), "team-a"); err != nil || ns.Metadata.Name != "team-a" {
+		t.Errorf("GetNamespace() = %+v, %v; want team-a", ns, err)
+	}
+
+	if _, err := NewClient(filepath.Join(t.TempDir(), "missing")); err == nil ||
+		!strings.Contains(err.Error(), "kubeconfig file does not exist") {
+		t.Errorf("NewClient(missing) error = %v, want does not exist", err)
+	}
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte("current-context: other\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(path); err == nil || !strings.Contains(err.Error(), `context "other" not found`) {
+		t.Errorf("NewClient(unknown context) error = %v, want context not found", err)
+	}
+}
+
+// TestGet_Errors verifies how failed reads are classified and retried
+func TestGet_Errors(t *testing.T) {
+	s := newFakeAPIServer(t, map[string]any{
+		"/api/v1/namespaces/busy": http.StatusServiceUnavailable,
+	})
+	c := newTestClient(t, s)
+	orig := sleepFunc
+	sleepFunc = func(context.Context, time.Duration) error { return nil }
+	t.Cleanup(func() { sleepFunc = orig })
+
+	_, err := c.GetPod(context.Background(), "team-a", "web")
+	if !errors.Is(err, tenant.ErrNotFound) || errors.Is(err, tenant.ErrAPIUnavailable) {
+		t.Errorf("GetPod(missing) error = %v, want ErrNotFound", err)
+	}
+
+	c.Attempts = 3
+	_, err = c.GetNamespace(context.Background(), "busy")
+	if !errors.Is(err, tenant.ErrAPIUnavailable) {
+		t.Errorf("GetNamespace(503) error = %v, want ErrAPIUnavailable", err)
+	}
+	if got := s.calls["/api/v1/namespaces/busy"]; got != 3 {
+		t.Errorf("503 tried %d times, want 3", got)
+	}
+
+	s.token = "rotated"
+	_, err = c.GetNamespace(context.Background(), "team-a")
+	if err == nil || errors.Is(err, tenant.ErrAPIUnavailable) || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("GetNamespace(401) error = %v, want a definitive Unauthorized", err)
+	}
+
+	s.Close()
+	_, err = c.GetNamespace(context.Background(), "team-a")
+	if !errors.Is(err, tenant.ErrAPIUnavailable) {
+		t.Errorf("GetNamespace(closed server) error = %v, want ErrAPIUnavailable", err)
+	}
+}
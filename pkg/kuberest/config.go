@@ -0,0 +1,172 @@
+package kuberest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// kubeconfig is the part of a kubeconfig file the client reads
+type kubeconfig struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData []byte `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData []byte `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         []byte `json:"client-key-data"`
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+		} `json:"user"`
+	} `json:"users"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+}
+
+// NewClient creates a client from the current context of the kubeconfig file at path
+//
+// The server, its CA, client certificates and bearer tokens (inline or tokenFile) are
+// read; exec and auth-provider plugins are not supported. Relative file references are
+// resolved against the directory of the kubeconfig, as kubectl does.
+func NewClient(path string) (*Client, error) {
+	// Same messages as pkg/k8s: the file is checked before it is parsed
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("kubeconfig file does not exist: %s", path)
+		}
+		return nil, fmt.Errorf("kubeconfig file is not readable: %s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig file is not readable: %s: %w", path, err)
+	}
+	var conf kubeconfig
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig %s: %w", path, err)
+	}
+	client, err := newClient(&conf, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build config from kubeconfig %s: %w", path, err)
+	}
+	return client, nil
+}
+
+// newClient creates a client from the current context of conf; dir is the directory
+// relative file references are resolved against
+func newClient(conf *kubeconfig, dir string) (*Client, error) {
+	if conf.CurrentContext == "" {
+		return nil, fmt.Errorf("no current-context")
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range conf.Contexts {
+		if c.Name == conf.CurrentContext {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found", conf.CurrentContext)
+	}
+
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	var server string
+	found = false
+	for _, c := range conf.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca := c.Cluster.CertificateAuthorityData
+		if file := resolve(c.Cluster.CertificateAuthority); file != "" {
+			var err error
+			if ca, err = os.ReadFile(file); err != nil {
+				return nil, fmt.Errorf("failed to read certificate authority: %w", err)
+			}
+		}
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificate found in the certificate authority of cluster %q", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cluster %q not found", clusterName)
+	}
+	if server == "" {
+		return nil, fmt.Errorf("cluster %q has no server", clusterName)
+	}
+
+	var token string
+	for _, u := range conf.Users {
+		if u.Name != userName {
+			continue
+		}
+		cert, key := u.User.ClientCertificateData, u.User.ClientKeyData
+		var err error
+		if file := resolve(u.User.ClientCertificate); file != "" {
+			if cert, err = os.ReadFile(file); err != nil {
+				return nil, fmt.Errorf("failed to read client certificate: %w", err)
+			}
+		}
+		if file := resolve(u.User.ClientKey); file != "" {
+			if key, err = os.ReadFile(file); err != nil {
+				return nil, fmt.Errorf("failed to read client key: %w", err)
+			}
+		}
+		if len(cert) > 0 || len(key) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate of user %q: %w", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		token = u.User.Token
+		if file := resolve(u.User.TokenFile); token == "" && file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token file: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		Proxy:               http.ProxyFromEnvironment,
+	}
+	return &Client{server: server, token: token, http: &http.Client{Transport: transport}}, nil
+}
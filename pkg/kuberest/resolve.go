@@ -0,0 +1,305 @@
+package kuberest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// ErrTenantName is matched (errors.Is) by lookups of a pod whose tenant is named by
+// api.TenantNameAnnotationKey: its TenantRoute can only be read by tenant-routingd or
+// the full build of the plugin
+var ErrTenantName = errors.New("tenant name annotations are resolved by tenant-routingd")
+
+// nowFunc returns the current time; replaced in tests
+var nowFunc = time.Now
+
+// NamespaceLabelRule assigns a tenant fwmark to the namespaces whose labels match Selector
+// (see k8s.NamespaceLabelRule)
+type NamespaceLabelRule struct {
+	Selector string
+	Fwmark   string
+}
+
+// compiledRule is a NamespaceLabelRule with its selector parsed
+type compiledRule struct {
+	selector labels.Selector
+	fwmark   string
+}
+
+var (
+	selectorsMu  sync.RWMutex
+	labelRules   []compiledRule
+	excludedPods labels.Selector
+)
+
+// SetNamespaceLabelRules sets the rules every following lookup falls back to when
+// neither the pod nor its namespace annotates the tenant, as k8s.SetNamespaceLabelRules
+func SetNamespaceLabelRules(rules []NamespaceLabelRule) error {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		selector, err := labels.Parse(rule.Selector)
+		if err != nil {
+			return fmt.Errorf("invalid namespace label selector %q: %w", rule.Selector, err)
+		}
+		compiled = append(compiled, compiledRule{selector: selector, fwmark: rule.Fwmark})
+	}
+	selectorsMu.Lock()
+	defer selectorsMu.Unlock()
+	labelRules = compiled
+	return nil
+}
+
+// SetExclusions excludes the pods of namespaces and the pods whose labels match
+// podSelector from tenant routing in every following lookup, as k8s.SetExclusions
+func SetExclusions(namespaces []string, podSelector string) error {
+	var selector labels.Selector
+	if podSelector != "" {
+		var err error
+		if selector, err = labels.Parse(podSelector); err != nil {
+			return fmt.Errorf("invalid pod label selector %q: %w", podSelector, err)
+		}
+	}
+	tenant.SetExcludedNamespaces(namespaces)
+	selectorsMu.Lock()
+	defer selectorsMu.Unlock()
+	excludedPods = selector
+	return nil
+}
+
+// labelFwmark returns the fwmark the first matching rule assigns to namespace labels
+func labelFwmark(nsLabels map[string]string) (string, bool) {
+	selectorsMu.RLock()
+	defer selectorsMu.RUnlock()
+	for _, rule := range labelRules {
+		if rule.selector.Matches(labels.Set(nsLabels)) {
+			return rule.fwmark, true
+		}
+	}
+	return "", false
+}
+
+// excludedPod reports whether the labels of pod exclude it from tenant routing
+func excludedPod(pod *Pod) bool {
+	selectorsMu.RLock()
+	defer selectorsMu.RUnlock()
+	return excludedPods != nil && excludedPods.Matches(labels.Set(pod.Metadata.Labels))
+}
+
+// RoutingAnnotations resolves the fwmark and gateway of a pod with pod → namespace →
+// namespace labels → static tenant fallback, as k8s.GetRoutingAnnotationsCached does:
+// a fresh cache entry answers without any API call, successful lookups are stored and
+// a lookup the API server could not answer is answered by the static tenant of the
+// namespace. The lookups end when ctx is done or after timeout.
+func (c *Client) RoutingAnnotations(ctx context.Context, cache *tenant.AnnotationCache, podName, podNamespace, podUID string,
+	fwmarkKeys []string, gatewayKey string, timeout time.Duration) (tenant.RoutingAnnotations, error) {
+	if tenant.ExcludedNamespace(podNamespace) {
+		return tenant.RoutingAnnotations{Excluded: true}, nil
+	}
+	if cached, ok := cache.Pod(podNamespace, podName, podUID, fwmarkKeys, gatewayKey); ok {
+		return cached, nil
+	}
+	result, err := c.resolve(ctx, cache, podName, podNamespace, fwmarkKeys, gatewayKey, timeout)
+	if err != nil {
+		if static, ok := staticFallback(podNamespace, err); ok {
+			return static, nil
+		}
+		return result, err
+	}
+	cache.StorePod(podNamespace, podName, fwmarkKeys, gatewayKey, result)
+	return result, nil
+}
+
+// resolve fetches the pod and, if needed, the namespace annotations
+func (c *Client) resolve(ctx context.Context, cache *tenant.AnnotationCache, podName, podNamespace string,
+	fwmarkKeys []string, gatewayKey string, timeout time.Duration) (tenant.RoutingAnnotations, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pod, err := c.GetPod(ctx, podNamespace, podName)
+	if err != nil {
+		return tenant.RoutingAnnotations{}, err
+	}
+	var result tenant.RoutingAnnotations
+	result.PodUID = pod.Metadata.UID
+	result.TerminatedAt, result.Terminated = terminatedAt(pod)
+	if excludedPod(pod) {
+		result.Excluded = true
+		return result, nil
+	}
+
+	// Bypass is pod-only and never fails the lookup
+	if value, ok := pod.Metadata.Annotations[api.BypassAnnotationKey]; ok {
+		result.BypassUntil, result.BypassError = api.ParseBypassUntil(value, nowFunc())
+	}
+
+	fwmarkFound, gatewayFound, err := annotate(&result, pod.Metadata.Annotations, "pod", tenant.SourcePod,
+		fwmarkKeys, gatewayKey, false, gatewayKey == "")
+	if err != nil || fwmarkFound && gatewayFound {
+		return result, err
+	}
+
+	nsAnnotations, err := c.namespaceAnnotations(ctx, cache, podNamespace, fwmarkKeys, gatewayKey)
+	if err != nil {
+		return result, err
+	}
+	fwmarkFound, gatewayFound, err = annotate(&result, nsAnnotations, "namespace", tenant.SourceNamespace,
+		fwmarkKeys, gatewayKey, fwmarkFound, gatewayFound)
+	if err != nil {
+		return result, err
+	}
+	if !fwmarkFound {
+		if fwmark, ok := nsAnnotations[tenant.LabelFwmarkKey]; ok {
+			if _, err := api.ParseFwmark(fwmark); err != nil {
+				return result, fmt.Errorf("invalid fwmark selected by namespace labels: %w", err)
+			}
+			result.Fwmark, result.Source, fwmarkFound = fwmark, tenant.SourceNamespaceLabels, true
+		}
+	}
+	if !fwmarkFound {
+		return withStaticTenant(result, podNamespace, gatewayFound)
+	}
+	return result, nil
+}
+
+// annotate sets the fwmark and gateway of result not found yet from the annotations of
+// one object (kind "pod" or "namespace")
+func annotate(result *tenant.RoutingAnnotations, annotations map[string]string, kind string, source tenant.Source,
+	fwmarkKeys []string, gatewayKey string, fwmarkFound, gatewayFound bool) (bool, bool, error) {
+	if !fwmarkFound {
+		if fwmark, ok := fwmarkAnnotation(annotations, fwmarkKeys); ok {
+			if _, err := api.ParseFwmark(fwmark); err != nil {
+				return false, gatewayFound, fmt.Errorf("invalid fwmark in %s annotation: %w", kind, err)
+			}
+			result.Fwmark, result.Source, fwmarkFound = fwmark, source, true
+		}
+	}
+	if !gatewayFound {
+		if gateway, ok := annotations[gatewayKey]; ok {
+			if _, err := api.ParseGateway(gateway); err != nil {
+				return fwmarkFound, false, fmt.Errorf("invalid gateway in %s annotation: %w", kind, err)
+			}
+			result.Gateway, gatewayFound = gateway, true
+		}
+	}
+	if !fwmarkFound {
+		if name, ok := annotations[api.TenantNameAnnotationKey]; ok {
+			return false, gatewayFound, fmt.Errorf("%s annotation names tenant %s: %w", kind, name, ErrTenantName)
+		}
+	}
+	return fwmarkFound, gatewayFound, nil
+}
+
+// namespaceAnnotations returns the routing annotations set on a namespace, cached or
+// fetched, in the cache entry format of pkg/k8s
+func (c *Client) namespaceAnnotations(ctx context.Context, cache *tenant.AnnotationCache, namespace string,
+	fwmarkKeys []string, gatewayKey string) (map[string]string, error) {
+	if values, ok := cache.Namespace(namespace, fwmarkKeys, gatewayKey); ok {
+		return values, nil
+	}
+	ns, err := c.GetNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	keys := append(append([]string(nil), fwmarkKeys...), gatewayKey, api.TenantNameAnnotationKey)
+	for _, key := range keys {
+		if value, ok := ns.Metadata.Annotations[key]; ok && key != "" {
+			values[key] = value
+		}
+	}
+	if fwmark, ok := labelFwmark(ns.Metadata.Labels); ok {
+		values[tenant.LabelFwmarkKey] = fwmark
+	}
+	cache.StoreNamespace(namespace, fwmarkKeys, gatewayKey, values)
+	return values, nil
+}
+
+// fwmarkAnnotation returns the value of the first of fwmarkKeys set in annotations
+func fwmarkAnnotation(annotations map[string]string, fwmarkKeys []string) (string, bool) {
+	for _, key := range fwmarkKeys {
+		if value, ok := annotations[key]; ok && key != "" {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// withStaticTenant assigns the static tenant of namespace to a pod nothing else assigned
+// a tenant to; a gateway found in annotations is kept
+func withStaticTenant(result tenant.RoutingAnnotations, namespace string, gatewayFound bool) (tenant.RoutingAnnotations, error) {
+	static, ok, err := tenant.StaticRoutingAnnotations(namespace)
+	if !ok || err != nil {
+		return result, err
+	}
+	result.Fwmark, result.Table, result.Source = static.Fwmark, static.Table, static.Source
+	if !gatewayFound {
+		result.Gateway = static.Gateway
+	}
+	return result, nil
+}
+
+// staticFallback answers a lookup the API server could not answer with the static tenant
+// of namespace, keeping the failure in FallbackError; invalid annotations, missing pods
+// and tenant names are not answered
+func staticFallback(namespace string, lookupErr error) (tenant.RoutingAnnotations, bool) {
+	if errors.Is(lookupErr, tenant.ErrInvalidFwmark) || errors.Is(lookupErr, tenant.ErrInvalidGateway) ||
+		errors.Is(lookupErr, tenant.ErrNotFound) || errors.Is(lookupErr, ErrTenantName) {
+		return tenant.RoutingAnnotations{}, false
+	}
+	static, ok, err := tenant.StaticRoutingAnnotations(namespace)
+	if !ok || err != nil {
+		return tenant.RoutingAnnotations{}, false
+	}
+	static.FallbackError = lookupErr
+	return static, true
+}
+
+// terminatedAt reports whether pod reached phase Succeeded or Failed, and when: the
+// last time one of its containers finished, else when its Ready condition last changed
+func terminatedAt(pod *Pod) (time.Time, bool) {
+	if pod.Status.Phase != "Succeeded" && pod.Status.Phase != "Failed" {
+		return time.Time{}, false
+	}
+	var at time.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if t := status.State.Terminated; t != nil && t.FinishedAt.After(at) {
+			at = t.FinishedAt
+		}
+	}
+	if at.IsZero() {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == "Ready" {
+				at = cond.LastTransitionTime
+			}
+		}
+	}
+	return at, true
+}
+
+// StrictOverride reads the strict-mode annotation of a namespace, nil if not set
+func (c *Client) StrictOverride(ctx context.Context, namespace string, timeout time.Duration) (*bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ns, err := c.GetNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := ns.Metadata.Annotations[api.StrictAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	strict, err := api.ParseStrict(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value '%s' on namespace %s", api.StrictAnnotationKey, value, namespace)
+	}
+	return &strict, nil
+}
@@ -0,0 +1,171 @@
+package kuberest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+const testTimeout = 5 * time.Second
+
+var testKeys = []string{api.FwmarkAnnotationKey}
+
+// testPod returns a running pod of namespace with annotations and labels
+func testPod(namespace, name string, annotations, labels map[string]string) *Pod {
+	pod := &Pod{Metadata: ObjectMeta{Name: name, Namespace: namespace, UID: name + "-uid",
+		Annotations: annotations, Labels: labels}}
+	pod.Status.Phase = "Running"
+	return pod
+}
+
+// TestRoutingAnnotations verifies the pod → namespace → labels → static fallback order
+func TestRoutingAnnotations(t *testing.T) {
+	const gatewayKey = "tenant.routing/gateway"
+	s := newFakeAPIServer(t, map[string]any{
+		"/api/v1/namespaces/team-a/pods/own": testPod("team-a", "own", map[string]string{
+			api.FwmarkAnnotationKey: "0x20", gatewayKey: "10.0.0.1"}, nil),
+		"/api/v1/namespaces/team-a/pods/web": testPod("team-a", "web", map[string]string{gatewayKey: "10.0.0.2"}, nil),
+		"/api/v1/namespaces/team-a": Namespace{Metadata: ObjectMeta{Name: "team-a",
+			Annotations: map[string]string{api.FwmarkAnnotationKey: "0x10", gatewayKey: "10.0.0.9"}}},
+		"/api/v1/namespaces/team-b/pods/web": testPod("team-b", "web", nil, nil),
+		"/api/v1/namespaces/team-b":          Namespace{Metadata: ObjectMeta{Name: "team-b", Labels: map[string]string{"team": "b"}}},
+		"/api/v1/namespaces/team-c/pods/web": testPod("team-c", "web", nil, nil),
+		"/api/v1/namespaces/team-c":          Namespace{Metadata: ObjectMeta{Name: "team-c"}},
+		"/api/v1/namespaces/team-d/pods/web": testPod("team-d", "web",
+			map[string]string{api.TenantNameAnnotationKey: "blue"}, nil),
+		"/api/v1/namespaces/team-e/pods/web": testPod("team-e", "web",
+			map[string]string{api.FwmarkAnnotationKey: "0x99"}, nil),
+	})
+	c := newTestClient(t, s)
+	if err := SetNamespaceLabelRules([]NamespaceLabelRule{{Selector: "team=b", Fwmark: "0x20"}}); err != nil {
+		t.Fatal(err)
+	}
+	tenant.SetStaticTenants(map[string]tenant.StaticTenant{"team-c": {Fwmark: "0x10"}, "team-d": {Fwmark: "0x10"}})
+	t.Cleanup(func() {
+		_ = SetNamespaceLabelRules(nil)
+		tenant.SetStaticTenants(nil)
+	})
+
+	tests := []struct {
+		namespace, pod string
+		fwmark         string
+		gateway        string
+		source         tenant.Source
+	}{
+		{"team-a", "own", "0x20", "10.0.0.1", tenant.SourcePod},
+		{"team-a", "web", "0x10", "10.0.0.2", tenant.SourceNamespace},
+		{"team-b", "web", "0x20", "", tenant.SourceNamespaceLabels},
+		{"team-c", "web", "0x10", "", tenant.SourceStatic},
+	}
+	for _, tt := range tests {
+		got, err := c.RoutingAnnotations(context.Background(), nil, tt.pod, tt.namespace, "", testKeys, gatewayKey, testTimeout)
+		if err != nil {
+			t.Errorf("%s/%s: error = %v", tt.namespace, tt.pod, err)
+			continue
+		}
+		if got.Fwmark != tt.fwmark || got.Gateway != tt.gateway || got.Source != tt.source || got.PodUID != tt.pod+"-uid" {
+			t.Errorf("%s/%s = %+v; want fwmark %s, gateway %q from %s", tt.namespace, tt.pod, got, tt.fwmark, tt.gateway, tt.source)
+		}
+	}
+
+	// A tenant name cannot be resolved here, and the static tenant does not answer for it
+	if _, err := c.RoutingAnnotations(context.Background(), nil, "web", "team-d", "", testKeys, gatewayKey, testTimeout); !errors.Is(err, ErrTenantName) {
+		t.Errorf("tenant name error = %v, want ErrTenantName", err)
+	}
+	if _, err := c.RoutingAnnotations(context.Background(), nil, "web", "team-e", "", testKeys, gatewayKey, testTimeout); !errors.Is(err, tenant.ErrInvalidFwmark) {
+		t.Errorf("invalid fwmark error = %v, want ErrInvalidFwmark", err)
+	}
+	if _, err := c.RoutingAnnotations(context.Background(), nil, "gone", "team-a", "", testKeys, gatewayKey, testTimeout); !errors.Is(err, tenant.ErrNotFound) {
+		t.Errorf("missing pod error = %v, want ErrNotFound", err)
+	}
+}
+
+// TestRoutingAnnotations_Exclusions verifies excluded pods are answered without a tenant
+func TestRoutingAnnotations_Exclusions(t *testing.T) {
+	s := newFakeAPIServer(t, map[string]any{
+		"/api/v1/namespaces/team-a/pods/agent": testPod("team-a", "agent", map[string]string{api.FwmarkAnnotationKey: "0x10"},
+			map[string]string{"app": "node-agent"}),
+	})
+	c := newTestClient(t, s)
+	if err := SetExclusions([]string{"kube-system"}, "app=node-agent"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetExclusions(nil, "") })
+
+	got, err := c.RoutingAnnotations(context.Background(), nil, "coredns", "kube-system", "", testKeys, "", testTimeout)
+	if err != nil || !got.Excluded || len(s.calls) != 0 {
+		t.Errorf("excluded namespace = %+v, %v after %d calls; want Excluded without API calls", got, err, len(s.calls))
+	}
+	got, err = c.RoutingAnnotations(context.Background(), nil, "agent", "team-a", "", testKeys, "", testTimeout)
+	if err != nil || !got.Excluded || got.Fwmark != "" {
+		t.Errorf("excluded pod = %+v, %v; want Excluded without a fwmark", got, err)
+	}
+}
+
+// TestRoutingAnnotations_Cache verifies cached pods are answered without API calls and
+// an unreachable API server falls back to the static tenant
+func TestRoutingAnnotations_Cache(t *testing.T) {
+	s := newFakeAPIServer(t, map[string]any{
+		"/api/v1/namespaces/team-a/pods/web": testPod("team-a", "web", nil, nil),
+		"/api/v1/namespaces/team-a": Namespace{Metadata: ObjectMeta{Name: "team-a",
+			Annotations: map[string]string{api.FwmarkAnnotationKey: "0x10"}}},
+	})
+	c := newTestClient(t, s)
+	cache := tenant.NewAnnotationCache(t.TempDir(), time.Minute, 0)
+
+	for i := 0; i < 2; i++ {
+		got, err := c.RoutingAnnotations(context.Background(), cache, "web", "team-a", "", testKeys, "", testTimeout)
+		if err != nil || got.Fwmark != "0x10" {
+			t.Fatalf("lookup %d = %+v, %v; want 0x10", i, got, err)
+		}
+	}
+	if got := s.calls["/api/v1/namespaces/team-a/pods/web"]; got != 1 {
+		t.Errorf("pod fetched %d times, want 1", got)
+	}
+
+	s.Close()
+	tenant.SetStaticTenants(map[string]tenant.StaticTenant{"team-b": {Fwmark: "0x20"}})
+	t.Cleanup(func() { tenant.SetStaticTenants(nil) })
+	got, err := c.RoutingAnnotations(context.Background(), cache, "web", "team-b", "", testKeys, "", testTimeout)
+	if err != nil || got.Source != tenant.SourceStatic || !errors.Is(got.FallbackError, tenant.ErrAPIUnavailable) {
+		t.Errorf("unreachable lookup = %+v, %v; want the static tenant with the API failure", got, err)
+	}
+}
+
+// TestTerminatedAt verifies the phases that count as terminated and the time taken
+func TestTerminatedAt(t *testing.T) {
+	pod := testPod("team-a", "web", nil, nil)
+	if _, terminated := terminatedAt(pod); terminated {
+		t.Error("running pod reported terminated")
+	}
+	finished := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	pod.Status.Phase = "Succeeded"
+	pod.Status.Conditions = append(pod.Status.Conditions, struct {
+		Type               string    `json:"type"`
+		LastTransitionTime time.Time `json:"lastTransitionTime"`
+	}{Type: "Ready", LastTransitionTime: finished})
+	if at, terminated := terminatedAt(pod); !terminated || !at.Equal(finished) {
+		t.Errorf("terminatedAt() = %v, %v; want %v, true", at, terminated, finished)
+	}
+}
+
+// TestStrictOverride verifies the strict-mode annotation of a namespace is parsed
+func TestStrictOverride(t *testing.T) {
+	s := newFakeAPIServer(t, map[string]any{
+		"/api/v1/namespaces/team-a": Namespace{Metadata: ObjectMeta{Name: "team-a",
+			Annotations: map[string]string{api.StrictAnnotationKey: "false"}}},
+		"/api/v1/namespaces/team-b": Namespace{Metadata: ObjectMeta{Name: "team-b"}},
+	})
+	c := newTestClient(t, s)
+
+	if strict, err := c.StrictOverride(context.Background(), "team-a", testTimeout); err != nil || strict == nil || *strict {
+		t.Errorf("StrictOverride(team-a) = %v, %v; want false", strict, err)
+	}
+	if strict, err := c.StrictOverride(context.Background(), "team-b", testTimeout); err != nil || strict != nil {
+		t.Errorf("StrictOverride(team-b) = %v, %v; want nil", strict, err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package logging writes the plugin's leveled, component-tagged logs (log/slog) to
+// stderr or a file, as key=value text or JSON lines for Loki/Elastic shippers.
+//
+// Components log through a Logger with printf-style methods:
+//
+//	var iptLog = logging.Component("iptables")
+//	iptLog.Warnf("failed to delete CONNMARK rules for IP %s: %v", podIP, err)
+//
+// which, with Setup(Options{Format: "json"}), is written as
+//
+//	{"time":"...","level":"WARN","msg":"failed to delete CONNMARK rules ...","component":"iptables"}
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// LevelAudit records operator overrides such as bypassed pods; between info and warn,
+// so it is kept at the default level
+const LevelAudit = slog.Level(2)
+
+// Formats (see Options.Format)
+const (
+	// FormatText writes key=value lines (slog.TextHandler)
+	FormatText = "text"
+
+	// FormatJSON writes one JSON object per line (slog.JSONHandler)
+	FormatJSON = "json"
+)
+
+// levels maps the configurable level names to slog levels
+var levels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// Options describes where and how logs are written
+type Options struct {
+	// Format is FormatText (default) or FormatJSON
+	Format string
+
+	// Level is the least severe level written: "debug", "info" (default), "warn" or "error"
+	Level string
+
+	// File receives the logs, appended; empty writes to stderr
+	File string
+}
+
+// ParseLevel returns the slog level named name; empty is info
+func ParseLevel(name string) (slog.Level, error) {
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	level, ok := levels[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// Setup makes opts the default logger of the process
+// Returns a function closing the log file (and restoring the previous logger). On
+// error the previous logger is kept.
+func Setup(opts Options) (func(), error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Format != "" && opts.Format != FormatText && opts.Format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q (want %s or %s)", opts.Format, FormatText, FormatJSON)
+	}
+
+	var w io.Writer = os.Stderr
+	closeFn := func() {}
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = f
+		prev := slog.Default()
+		closeFn = func() {
+			slog.SetDefault(prev)
+			f.Close()
+		}
+	}
+
+	slog.SetDefault(slog.New(NewHandler(w, opts.Format, level)))
+	return closeFn, nil
+}
+
+// NewHandler returns the slog handler writing format to w from level up
+func NewHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// replaceLevel names LevelAudit "AUDIT" instead of "INFO+2"
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelAudit {
+			return slog.String(slog.LevelKey, "AUDIT")
+		}
+	}
+	return a
+}
+
+// Logger writes printf-style messages of one component to the default logger
+// The default logger is looked up on every call, so package-level Loggers follow Setup.
+type Logger struct {
+	component string
+}
+
+// Component returns the Logger tagging its lines with component=name
+func Component(name string) *Logger {
+	return &Logger{component: name}
+}
+
+// Debugf logs at debug level
+func (l *Logger) Debugf(format string, args ...any) { l.logf(slog.LevelDebug, format, args...) }
+
+// Infof logs at info level
+func (l *Logger) Infof(format string, args ...any) { l.logf(slog.LevelInfo, format, args...) }
+
+// Auditf logs at LevelAudit
+func (l *Logger) Auditf(format string, args ...any) { l.logf(LevelAudit, format, args...) }
+
+// Warnf logs at warn level
+func (l *Logger) Warnf(format string, args ...any) { l.logf(slog.LevelWarn, format, args...) }
+
+// Errorf logs at error level
+func (l *Logger) Errorf(format string, args ...any) { l.logf(slog.LevelError, format, args...) }
+
+// logf formats the message only if level is enabled
+func (l *Logger) logf(level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	logger := slog.Default()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, fmt.Sprintf(format, args...), "component", l.component)
+}
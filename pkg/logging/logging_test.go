@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useDefault restores the process default logger after the test
+func useDefault(t *testing.T) {
+	orig := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(orig) })
+}
+
+// TestSetup_JSONFile verifies JSON lines with level and component are appended to the file
+func TestSetup_JSONFile(t *testing.T) {
+	useDefault(t)
+	path := filepath.Join(t.TempDir(), "tenant-routing.log")
+
+	closeLog, err := Setup(Options{Format: FormatJSON, File: path})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	log := Component("iptables")
+	log.Debugf("not written at info level")
+	log.Auditf("pod %s bypassed", "team-a/web-0")
+	log.Warnf("failed: %v", "xtables lock")
+	closeLog()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), data)
+	}
+
+	var entries []map[string]any
+	for _, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line is not JSON: %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if entries[0]["level"] != "AUDIT" || entries[0]["msg"] != "pod team-a/web-0 bypassed" {
+		t.Errorf("audit entry = %v", entries[0])
+	}
+	if entries[1]["level"] != "WARN" || entries[1]["component"] != "iptables" || entries[1]["msg"] != "failed: xtables lock" {
+		t.Errorf("warn entry = %v", entries[1])
+	}
+}
+
+// TestSetup_Invalid verifies bad options keep the previous logger
+func TestSetup_Invalid(t *testing.T) {
+	useDefault(t)
+	before := slog.Default()
+
+	for _, opts := range []Options{
+		{Level: "trace"},
+		{Format: "logfmt"},
+		{File: filepath.Join(t.TempDir(), "missing", "tenant-routing.log")},
+	} {
+		if _, err := Setup(opts); err == nil {
+			t.Errorf("Setup(%+v) expected error", opts)
+		}
+	}
+	if slog.Default() != before {
+		t.Error("default logger replaced after failed Setup")
+	}
+}
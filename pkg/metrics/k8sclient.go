@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// K8sClientRebuildsMetric counts rebuilds of the node agent's Kubernetes client (see
+// k8s.ClientManager), by reason and result
+const K8sClientRebuildsMetric = "tenant_routing_k8s_client_rebuilds_total"
+
+// ObserveClientRebuild records one rebuild of the Kubernetes client for reason, failed
+// if err is set
+func (r *Recorder) ObserveClientRebuild(reason string, err error) error {
+	if !labelPattern.MatchString(reason) {
+		return fmt.Errorf("invalid rebuild reason label %q", reason)
+	}
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+
+	labels := map[string]string{"reason": reason, "result": result}
+	return r.update(func(st *state) {
+		st.counter(K8sClientRebuildsMetric, labels, 1)
+	})
+}
+
+// parseClientLine merges one line of the client metrics into st
+// Returns false if name is none of them.
+func parseClientLine(name string, labels map[string]string, value float64, st *state) bool {
+	if name != K8sClientRebuildsMetric {
+		return false
+	}
+	st.counter(name, labels, uint64(value))
+	return true
+}
+
+// writeClient writes the client metrics of st; nothing before the first rebuild
+func writeClient(b *strings.Builder, st *state) {
+	writeCounter(b, K8sClientRebuildsMetric, "Rebuilds of the node agent's Kubernetes client by reason and result",
+		st.counters[K8sClientRebuildsMetric])
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package metrics
+
+// lockFile is a no-op where flock is unavailable; concurrent writers may lose observations
+func lockFile(string) (func(), error) {
+	return func() {}, nil
+}
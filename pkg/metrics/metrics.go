@@ -0,0 +1,511 @@
+// Package metrics records the Prometheus metrics of the CNI plugin and its node agent.
+//
+// Each metric is described at its name constant below and in the files next to this one.
+//
+// The plugin is a short-lived binary, so there is no process to scrape. Instead every
+// invocation merges its observation into a file in Prometheus text format that the
+// node_exporter textfile collector exposes:
+//
+//	node_exporter --collector.textfile.directory=/var/lib/node_exporter/textfile
+//
+// Concurrent invocations are serialized with a lock file next to the metrics file and
+// the file is replaced atomically, so the collector never reads a partial write.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RoutingLatencyMetric is the histogram of time from delegate ADD completion until
+// the tenant MARK rule and policy route are verified in place
+const RoutingLatencyMetric = "tenant_routing_add_to_effective_seconds"
+
+// SkipsMetric counts permissive-mode skips by machine-readable reason (see pkg/reason)
+const SkipsMetric = "tenant_routing_skips_total"
+
+// IPAMExhaustedMetric counts ADDs that failed because the delegate's IPAM had no free
+// address, by exhausted range (see delegate.IPAMExhaustedError)
+const IPAMExhaustedMetric = "tenant_routing_ipam_exhausted_total"
+
+// ConfigInfoMetric is 1 for the fingerprint of the configuration last applied on the node
+const ConfigInfoMetric = "tenant_routing_config_info"
+
+// MigratedMetric counts running pods that received their tenant mark from `migrate`
+// rather than at ADD (their namespace or pod was annotated later)
+const MigratedMetric = "tenant_routing_migrated_pods_total"
+
+// MigrationPendingMetric is the number of running pods still waiting for `migrate`, by tenant
+const MigrationPendingMetric = "tenant_routing_migration_pending_pods"
+
+// HealthScoreMetric is the fraction (0-1) of tenant routing health checks passing on the node
+const HealthScoreMetric = "tenant_routing_health_score"
+
+// HealthCheckMetric is 1 for each passing health check and 0 for each failing one
+const HealthCheckMetric = "tenant_routing_health_check"
+
+// RoutingLatencyBuckets are the histogram upper bounds in seconds
+var RoutingLatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// labelPattern restricts label values so they never need escaping
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// rangePattern restricts IPAM range labels (10.22.0.2-10.22.0.254, 10.0.0.0/24, fd00::/64)
+var rangePattern = regexp.MustCompile(`^[A-Za-z0-9_.:/-]+$`)
+
+// histogram holds cumulative bucket counts like the exposition format
+type histogram struct {
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range h.bounds {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// state is everything stored in the metrics file
+type state struct {
+	histograms map[string]*histogram
+	skips      map[string]uint64
+	configHash string
+
+	// ipamExhausted is keyed by IPAM range
+	ipamExhausted map[string]uint64
+
+	// migrated and migrationPending are keyed by tenant (fwmark)
+	migrated         map[string]uint64
+	migrationPending map[string]uint64
+
+	// healthScore is -1 until the first SetHealth; healthChecks is keyed by check name
+	healthScore  float64
+	healthChecks map[string]uint64
+
+	// counters and durations are keyed by metric, then by rendered labels (see renderLabels)
+	counters  map[string]map[string]uint64
+	durations map[string]map[string]*histogram
+
+	// managedRules is keyed by tenant (fwmark)
+	managedRules map[string]uint64
+
+	// coverage is keyed by reconcile scan
+	coverage map[string]float64
+}
+
+// Recorder persists tenant histograms to a textfile collector file
+type Recorder struct {
+	path string
+}
+
+// NewRecorder returns a Recorder writing to path
+// Security: path must be absolute and free of '..' components (same rules as kubeconfig)
+func NewRecorder(path string) (*Recorder, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("metrics file path must be absolute, got: %s", path)
+	}
+	if strings.Contains(path, "..") {
+		return nil, fmt.Errorf("metrics file path cannot contain '..' components: %s", path)
+	}
+	return &Recorder{path: path}, nil
+}
+
+// ObserveRoutingLatency adds one ADD-to-effective observation for tenant
+func (r *Recorder) ObserveRoutingLatency(tenant string, latency time.Duration) error {
+	if !labelPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant label %q", tenant)
+	}
+
+	return r.update(func(st *state) {
+		h, ok := st.histograms[tenant]
+		if !ok {
+			h = newHistogram(RoutingLatencyBuckets)
+			st.histograms[tenant] = h
+		}
+		h.observe(latency.Seconds())
+	})
+}
+
+// ObserveSkip counts one permissive-mode skip with reason
+func (r *Recorder) ObserveSkip(reason string) error {
+	if !labelPattern.MatchString(reason) {
+		return fmt.Errorf("invalid reason label %q", reason)
+	}
+
+	return r.update(func(st *state) {
+		st.skips[reason]++
+	})
+}
+
+// ObserveIPAMExhausted counts one ADD that failed on an exhausted IPAM range
+// An unknown range is counted as "unknown".
+func (r *Recorder) ObserveIPAMExhausted(poolRange string) error {
+	if poolRange == "" {
+		poolRange = "unknown"
+	}
+	if !rangePattern.MatchString(poolRange) {
+		return fmt.Errorf("invalid range label %q", poolRange)
+	}
+
+	return r.update(func(st *state) {
+		st.ipamExhausted[poolRange]++
+	})
+}
+
+// SetConfigHash replaces the configuration fingerprint exposed by ConfigInfoMetric
+func (r *Recorder) SetConfigHash(hash string) error {
+	if !labelPattern.MatchString(hash) {
+		return fmt.Errorf("invalid config hash label %q", hash)
+	}
+
+	return r.update(func(st *state) {
+		st.configHash = hash
+	})
+}
+
+// ObserveMigration counts one running pod of tenant marked by migration
+func (r *Recorder) ObserveMigration(tenant string) error {
+	if !labelPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant label %q", tenant)
+	}
+
+	return r.update(func(st *state) {
+		st.migrated[tenant]++
+	})
+}
+
+// SetMigrationPending replaces the per-tenant number of pods waiting for migration
+// Tenants missing from pending are reported as 0 once they had pending pods.
+func (r *Recorder) SetMigrationPending(pending map[string]int) error {
+	for tenant := range pending {
+		if !labelPattern.MatchString(tenant) {
+			return fmt.Errorf("invalid tenant label %q", tenant)
+		}
+	}
+
+	return r.update(func(st *state) {
+		for tenant := range st.migrationPending {
+			st.migrationPending[tenant] = 0
+		}
+		for tenant, n := range pending {
+			st.migrationPending[tenant] = uint64(n)
+		}
+	})
+}
+
+// SetHealth replaces the health score and the per-check results
+func (r *Recorder) SetHealth(score float64, checks map[string]bool) error {
+	if score < 0 || score > 1 {
+		return fmt.Errorf("health score %v out of range (0-1)", score)
+	}
+	for check := range checks {
+		if !labelPattern.MatchString(check) {
+			return fmt.Errorf("invalid check label %q", check)
+		}
+	}
+
+	return r.update(func(st *state) {
+		st.healthScore = score
+		st.healthChecks = map[string]uint64{}
+		for check, ok := range checks {
+			if ok {
+				st.healthChecks[check] = 1
+			} else {
+				st.healthChecks[check] = 0
+			}
+		}
+	})
+}
+
+// update applies fn to the stored state under the file lock
+func (r *Recorder) update(fn func(*state)) error {
+	unlock, err := lockFile(r.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock metrics file %s: %w", r.path, err)
+	}
+	defer unlock()
+
+	st, err := r.load()
+	if err != nil {
+		return err
+	}
+	fn(st)
+	return r.store(st)
+}
+
+// load parses state previously written by store; a missing file is empty
+func (r *Recorder) load() (*state, error) {
+	st := &state{histograms: map[string]*histogram{}, skips: map[string]uint64{}, ipamExhausted: map[string]uint64{},
+		migrated: map[string]uint64{}, migrationPending: map[string]uint64{},
+		healthScore: -1, healthChecks: map[string]uint64{},
+		counters: map[string]map[string]uint64{}, durations: map[string]map[string]*histogram{},
+		managedRules: map[string]uint64{}, coverage: map[string]float64{}}
+
+	f, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics file %s: %w", r.path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parseLine(scanner.Text(), st)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metrics file %s: %w", r.path, err)
+	}
+	return st, nil
+}
+
+// parseLine merges one exposition line into st; foreign or malformed lines are skipped
+func parseLine(line string, st *state) {
+	space := strings.LastIndexByte(line, ' ')
+	if space < 0 || strings.HasPrefix(line, "#") {
+		return
+	}
+	value, err := strconv.ParseFloat(line[space+1:], 64)
+	if err != nil {
+		return
+	}
+	if line[:space] == HealthScoreMetric {
+		st.healthScore = value
+		return
+	}
+	open := strings.IndexByte(line, '{')
+	if open < 0 || open > space {
+		return
+	}
+	name := line[:open]
+	labels := parseLabels(strings.TrimSuffix(line[open+1:space], "}"))
+
+	if parseOperationLine(name, labels, value, st) || parseReconcileLine(name, labels, value, st) ||
+		parseClientLine(name, labels, value, st) {
+		return
+	}
+
+	if name == HealthCheckMetric {
+		if check, ok := labels["check"]; ok {
+			st.healthChecks[check] = uint64(value)
+		}
+		return
+	}
+
+	if name == ConfigInfoMetric {
+		st.configHash = labels["hash"]
+		return
+	}
+	if name == SkipsMetric {
+		if reason, ok := labels["reason"]; ok {
+			st.skips[reason] = uint64(value)
+		}
+		return
+	}
+	if name == IPAMExhaustedMetric {
+		if poolRange, ok := labels["range"]; ok {
+			st.ipamExhausted[poolRange] = uint64(value)
+		}
+		return
+	}
+	if name == MigratedMetric || name == MigrationPendingMetric {
+		tenant, ok := labels["tenant"]
+		if !ok {
+			return
+		}
+		if name == MigratedMetric {
+			st.migrated[tenant] = uint64(value)
+		} else {
+			st.migrationPending[tenant] = uint64(value)
+		}
+		return
+	}
+	if !strings.HasPrefix(name, RoutingLatencyMetric) {
+		return
+	}
+
+	histograms := st.histograms
+	tenant, ok := labels["tenant"]
+	if !ok {
+		return
+	}
+
+	h, ok := histograms[tenant]
+	if !ok {
+		h = newHistogram(RoutingLatencyBuckets)
+		histograms[tenant] = h
+	}
+
+	switch name[len(RoutingLatencyMetric):] {
+	case "_bucket":
+		for i, bound := range RoutingLatencyBuckets {
+			if labels["le"] == formatFloat(bound) {
+				h.buckets[i] = uint64(value)
+			}
+		}
+	case "_sum":
+		h.sum = value
+	case "_count":
+		h.count = uint64(value)
+	}
+}
+
+// parseLabels parses `a="x",b="y"` (values never contain quotes or commas, see labelPattern)
+func parseLabels(s string) map[string]string {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		labels[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+	return labels
+}
+
+// store writes the state atomically (temp file + rename in the same directory)
+func (r *Recorder) store(st *state) error {
+	histograms := st.histograms
+	tenants := make([]string, 0, len(histograms))
+	for tenant := range histograms {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s Time from delegate ADD completion to verified tenant MARK rule and policy route\n", RoutingLatencyMetric)
+	fmt.Fprintf(&b, "# TYPE %s histogram\n", RoutingLatencyMetric)
+	for _, tenant := range tenants {
+		h := histograms[tenant]
+		for i, bound := range RoutingLatencyBuckets {
+			fmt.Fprintf(&b, "%s_bucket{tenant=%q,le=%q} %d\n", RoutingLatencyMetric, tenant, formatFloat(bound), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{tenant=%q,le=\"+Inf\"} %d\n", RoutingLatencyMetric, tenant, h.count)
+		fmt.Fprintf(&b, "%s_sum{tenant=%q} %s\n", RoutingLatencyMetric, tenant, formatFloat(h.sum))
+		fmt.Fprintf(&b, "%s_count{tenant=%q} %d\n", RoutingLatencyMetric, tenant, h.count)
+	}
+
+	if len(st.skips) > 0 {
+		reasons := make([]string, 0, len(st.skips))
+		for reason := range st.skips {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+
+		fmt.Fprintf(&b, "# HELP %s Permissive-mode skips of tenant routing setup by reason\n", SkipsMetric)
+		fmt.Fprintf(&b, "# TYPE %s counter\n", SkipsMetric)
+		for _, reason := range reasons {
+			fmt.Fprintf(&b, "%s{reason=%q} %d\n", SkipsMetric, reason, st.skips[reason])
+		}
+	}
+
+	if len(st.ipamExhausted) > 0 {
+		ranges := make([]string, 0, len(st.ipamExhausted))
+		for poolRange := range st.ipamExhausted {
+			ranges = append(ranges, poolRange)
+		}
+		sort.Strings(ranges)
+
+		fmt.Fprintf(&b, "# HELP %s ADDs failed because the delegate IPAM had no free address, by range\n", IPAMExhaustedMetric)
+		fmt.Fprintf(&b, "# TYPE %s counter\n", IPAMExhaustedMetric)
+		for _, poolRange := range ranges {
+			fmt.Fprintf(&b, "%s{range=%q} %d\n", IPAMExhaustedMetric, poolRange, st.ipamExhausted[poolRange])
+		}
+	}
+
+	writeOperations(&b, st)
+	writeReconcile(&b, st)
+	writeClient(&b, st)
+
+	writeTenantSeries(&b, MigratedMetric, "counter", "Running pods marked by migration after their namespace or pod was annotated", st.migrated)
+	writeTenantSeries(&b, MigrationPendingMetric, "gauge", "Running pods waiting for migration", st.migrationPending)
+
+	if st.healthScore >= 0 {
+		fmt.Fprintf(&b, "# HELP %s Fraction of tenant routing health checks passing on the node\n", HealthScoreMetric)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", HealthScoreMetric)
+		fmt.Fprintf(&b, "%s %s\n", HealthScoreMetric, formatFloat(st.healthScore))
+	}
+	if len(st.healthChecks) > 0 {
+		checks := make([]string, 0, len(st.healthChecks))
+		for check := range st.healthChecks {
+			checks = append(checks, check)
+		}
+		sort.Strings(checks)
+
+		fmt.Fprintf(&b, "# HELP %s Tenant routing health check result (1 passing, 0 failing)\n", HealthCheckMetric)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", HealthCheckMetric)
+		for _, check := range checks {
+			fmt.Fprintf(&b, "%s{check=%q} %d\n", HealthCheckMetric, check, st.healthChecks[check])
+		}
+	}
+
+	if st.configHash != "" {
+		fmt.Fprintf(&b, "# HELP %s Fingerprint of the tenant-routing configuration last applied on the node\n", ConfigInfoMetric)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", ConfigInfoMetric)
+		fmt.Fprintf(&b, "%s{hash=%q} 1\n", ConfigInfoMetric, st.configHash)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), "."+filepath.Base(r.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file %s: %w", r.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics file %s: %w", r.path, err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics file %s: %w", r.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file %s: %w", r.path, err)
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("failed to replace metrics file %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// writeTenantSeries writes one per-tenant metric; nothing if values is empty
+func writeTenantSeries(b *strings.Builder, name, kind, help string, values map[string]uint64) {
+	if len(values) == 0 {
+		return
+	}
+	tenants := make([]string, 0, len(values))
+	for tenant := range values {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+	for _, tenant := range tenants {
+		fmt.Fprintf(b, "%s{tenant=%q} %d\n", name, tenant, values[tenant])
+	}
+}
+
+// formatFloat renders floats the way Prometheus clients do (shortest representation)
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,415 @@
+package metrics
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestNewRecorder_PathValidation verifies the metrics path is validated like kubeconfig
+func TestNewRecorder_PathValidation(t *testing.T) {
+	for _, path := range []string{"metrics.prom", "/var/lib/../etc/metrics.prom"} {
+		if _, err := NewRecorder(path); err == nil {
+			t.Errorf("NewRecorder(%q) expected error", path)
+		}
+	}
+}
+
+// TestObserveRoutingLatency_Accumulates verifies observations merge across invocations
+func TestObserveRoutingLatency_Accumulates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+
+	// Separate recorders model separate CNI invocations
+	for _, obs := range []struct {
+		tenant  string
+		latency time.Duration
+	}{
+		{"0x10", 30 * time.Millisecond},
+		{"0x10", 2 * time.Second},
+		{"0x20", 200 * time.Millisecond},
+	} {
+		r, err := NewRecorder(path)
+		if err != nil {
+			t.Fatalf("NewRecorder() error = %v", err)
+		}
+		if err := r.ObserveRoutingLatency(obs.tenant, obs.latency); err != nil {
+			t.Fatalf("ObserveRoutingLatency() error = %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+
+	for _, want := range []string{
+		"# TYPE tenant_routing_add_to_effective_seconds histogram",
+		`tenant_routing_add_to_effective_seconds_bucket{tenant="0x10",le="0.05"} 1`,
+		`tenant_routing_add_to_effective_seconds_bucket{tenant="0x10",le="2.5"} 2`,
+		`tenant_routing_add_to_effective_seconds_bucket{tenant="0x10",le="+Inf"} 2`,
+		`tenant_routing_add_to_effective_seconds_sum{tenant="0x10"} 2.03`,
+		`tenant_routing_add_to_effective_seconds_count{tenant="0x10"} 2`,
+		`tenant_routing_add_to_effective_seconds_bucket{tenant="0x20",le="0.1"} 0`,
+		`tenant_routing_add_to_effective_seconds_bucket{tenant="0x20",le="0.25"} 1`,
+		`tenant_routing_add_to_effective_seconds_count{tenant="0x20"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %q\n%s", want, out)
+		}
+	}
+}
+
+// TestObserveRoutingLatency_Concurrent verifies no observation is lost under concurrent writers
+func TestObserveRoutingLatency_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.ObserveRoutingLatency("0x10", time.Millisecond); err != nil {
+				t.Errorf("ObserveRoutingLatency() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	if !strings.Contains(string(data), `tenant_routing_add_to_effective_seconds_count{tenant="0x10"} 20`) {
+		t.Errorf("expected 20 observations:\n%s", data)
+	}
+}
+
+// TestObserveRoutingLatency_InvalidTenant verifies label values are restricted
+func TestObserveRoutingLatency_InvalidTenant(t *testing.T) {
+	r, err := NewRecorder(filepath.Join(t.TempDir(), "tenant_routing.prom"))
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	if err := r.ObserveRoutingLatency(`a"b`, time.Second); err == nil {
+		t.Error("expected error for tenant label with quote")
+	}
+}
+
+// TestObserveSkip verifies skip counters persist next to the latency histograms
+func TestObserveSkip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	for _, reason := range []string{"K8S_UNREACHABLE", "NO_ANNOTATION", "K8S_UNREACHABLE"} {
+		if err := r.ObserveSkip(reason); err != nil {
+			t.Fatalf("ObserveSkip() error = %v", err)
+		}
+	}
+	if err := r.ObserveRoutingLatency("0x10", time.Second); err != nil {
+		t.Fatalf("ObserveRoutingLatency() error = %v", err)
+	}
+	if err := r.ObserveSkip(`bad"label`); err == nil {
+		t.Error("expected error for invalid reason label")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"# TYPE tenant_routing_skips_total counter",
+		`tenant_routing_skips_total{reason="K8S_UNREACHABLE"} 2`,
+		`tenant_routing_skips_total{reason="NO_ANNOTATION"} 1`,
+		`tenant_routing_add_to_effective_seconds_count{tenant="0x10"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %q\n%s", want, out)
+		}
+	}
+}
+
+// TestObserveIPAMExhausted verifies exhaustion is counted per range and merged across writers
+func TestObserveIPAMExhausted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	for _, poolRange := range []string{"10.22.0.2-10.22.0.254", "10.0.0.0/29", "", "10.22.0.2-10.22.0.254"} {
+		if err := r.ObserveIPAMExhausted(poolRange); err != nil {
+			t.Fatalf("ObserveIPAMExhausted(%q) error = %v", poolRange, err)
+		}
+	}
+	if err := r.ObserveSkip("NO_ANNOTATION"); err != nil {
+		t.Fatalf("ObserveSkip() error = %v", err)
+	}
+	if err := r.ObserveIPAMExhausted(`10.0.0.0/29"}`); err == nil {
+		t.Error("expected error for invalid range label")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"# TYPE tenant_routing_ipam_exhausted_total counter",
+		`tenant_routing_ipam_exhausted_total{range="10.22.0.2-10.22.0.254"} 2`,
+		`tenant_routing_ipam_exhausted_total{range="10.0.0.0/29"} 1`,
+		`tenant_routing_ipam_exhausted_total{range="unknown"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %q\n%s", want, out)
+		}
+	}
+}
+
+// TestSetConfigHash verifies only the latest fingerprint is exposed and survives other updates
+func TestSetConfigHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	for _, hash := range []string{"0123456789abcdef", "fedcba9876543210"} {
+		if err := r.SetConfigHash(hash); err != nil {
+			t.Fatalf("SetConfigHash() error = %v", err)
+		}
+	}
+	if err := r.ObserveSkip("NO_ANNOTATION"); err != nil {
+		t.Fatalf("ObserveSkip() error = %v", err)
+	}
+	if err := r.SetConfigHash(`bad"label`); err == nil {
+		t.Error("expected error for invalid hash label")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	if !strings.Contains(out, `tenant_routing_config_info{hash="fedcba9876543210"} 1`) {
+		t.Errorf("metrics file missing latest config hash\n%s", out)
+	}
+	if strings.Contains(out, "0123456789abcdef") {
+		t.Errorf("metrics file still exposes the previous config hash\n%s", out)
+	}
+}
+
+func TestMigrationMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	if err := r.SetMigrationPending(map[string]int{"0x10": 3, "0x20": 1}); err != nil {
+		t.Fatalf("SetMigrationPending() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.ObserveMigration("0x10"); err != nil {
+			t.Fatalf("ObserveMigration() error = %v", err)
+		}
+	}
+	if err := r.SetMigrationPending(map[string]int{"0x10": 1}); err != nil {
+		t.Fatalf("SetMigrationPending() error = %v", err)
+	}
+	if err := r.ObserveMigration(`bad"label`); err == nil {
+		t.Error("expected error for invalid tenant label")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		`tenant_routing_migrated_pods_total{tenant="0x10"} 2`,
+		`tenant_routing_migration_pending_pods{tenant="0x10"} 1`,
+		`tenant_routing_migration_pending_pods{tenant="0x20"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %s\n%s", want, out)
+		}
+	}
+}
+
+func TestSetHealth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	if err := r.SetHealth(0.5, map[string]bool{"iptables": true, "gateways": false, "stale": true}); err != nil {
+		t.Fatalf("SetHealth() error = %v", err)
+	}
+	if err := r.SetHealth(0.75, map[string]bool{"iptables": true, "gateways": false}); err != nil {
+		t.Fatalf("SetHealth() error = %v", err)
+	}
+	// Other updates must keep the unlabeled score line
+	if err := r.ObserveSkip("NO_ANNOTATION"); err != nil {
+		t.Fatalf("ObserveSkip() error = %v", err)
+	}
+	if err := r.SetHealth(1.5, nil); err == nil {
+		t.Error("expected error for score out of range")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"tenant_routing_health_score 0.75\n",
+		`tenant_routing_health_check{check="gateways"} 0`,
+		`tenant_routing_health_check{check="iptables"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %q\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "stale") {
+		t.Errorf("metrics file still exposes a check that is no longer reported\n%s", out)
+	}
+}
+
+func TestObserveInvocation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+
+	// Separate recorders model separate CNI invocations
+	for _, inv := range []Invocation{
+		{Command: "ADD", Duration: 300 * time.Millisecond, Delegate: 200 * time.Millisecond,
+			K8sAPI:           map[string][]time.Duration{"annotations": {20 * time.Millisecond}},
+			IptablesFailures: map[string]int{"IPTABLES_LOCKED": 1}, ManagedRules: map[string]int{"0x10": 2, "0x20": 1}},
+		{Command: "ADD", Failed: true, Duration: 2 * time.Second, Delegate: time.Second},
+		{Command: "DEL", Duration: 50 * time.Millisecond, Delegate: 40 * time.Millisecond, ManagedRules: map[string]int{"0x10": 1}},
+		{Command: "CHECK", Duration: 10 * time.Millisecond},
+	} {
+		r, err := NewRecorder(path)
+		if err != nil {
+			t.Fatalf("NewRecorder() error = %v", err)
+		}
+		if err := r.ObserveInvocation(inv); err != nil {
+			t.Fatalf("ObserveInvocation() error = %v", err)
+		}
+	}
+	r, _ := NewRecorder(path)
+	if err := r.ObserveInvocation(Invocation{Command: `bad"label`}); err == nil {
+		t.Error("expected error for invalid command label")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		`tenant_routing_cni_operations_total{command="ADD",result="error"} 1`,
+		`tenant_routing_cni_operations_total{command="ADD",result="success"} 1`,
+		`tenant_routing_cni_operations_total{command="CHECK",result="success"} 1`,
+		`tenant_routing_cni_operation_duration_seconds_bucket{command="ADD",le="0.5"} 1`,
+		`tenant_routing_cni_operation_duration_seconds_bucket{command="ADD",le="+Inf"} 2`,
+		`tenant_routing_cni_operation_duration_seconds_sum{command="ADD"} 2.3`,
+		`tenant_routing_delegate_duration_seconds_count{command="ADD"} 2`,
+		`tenant_routing_delegate_duration_seconds_count{command="DEL"} 1`,
+		`tenant_routing_k8s_api_duration_seconds_bucket{operation="annotations",le="0.025"} 1`,
+		`tenant_routing_iptables_failures_total{command="ADD",reason="IPTABLES_LOCKED"} 1`,
+		`tenant_routing_managed_rules{tenant="0x10"} 1`,
+		`tenant_routing_managed_rules{tenant="0x20"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %s\n%s", want, out)
+		}
+	}
+	// CHECK did not call the delegate
+	if strings.Contains(out, `tenant_routing_delegate_duration_seconds_count{command="CHECK"}`) {
+		t.Errorf("metrics file has a delegate duration without delegate call\n%s", out)
+	}
+}
+
+func TestObserveReconcile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	for _, pass := range []struct {
+		scan             string
+		checked, repairs int
+		coverage         float64
+	}{
+		{ScanSampled, 60, 1, 0.06},
+		{ScanSampled, 50, 0, 0.05},
+		{ScanFull, 1000, 3, 1},
+	} {
+		r, _ := NewRecorder(path)
+		if err := r.ObserveReconcile(pass.scan, pass.checked, pass.repairs, pass.coverage); err != nil {
+			t.Fatalf("ObserveReconcile() error = %v", err)
+		}
+	}
+	r, _ := NewRecorder(path)
+	if err := r.ObserveReconcile("partial", 1, 0, 0.5); err == nil {
+		t.Error("expected error for unknown scan")
+	}
+	if err := r.ObserveReconcile(ScanFull, 1, 0, 2); err == nil {
+		t.Error("expected error for coverage above 1")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		`tenant_routing_reconcile_checked_pods_total{scan="sampled"} 110`,
+		`tenant_routing_reconcile_checked_pods_total{scan="full"} 1000`,
+		`tenant_routing_reconcile_repairs_total{scan="sampled"} 1`,
+		`tenant_routing_reconcile_coverage_ratio{scan="sampled"} 0.05`,
+		`tenant_routing_reconcile_coverage_ratio{scan="full"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %s\n%s", want, out)
+		}
+	}
+}
+
+// TestObserveClientRebuild verifies rebuilds are counted by reason and result across writes
+func TestObserveClientRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant_routing.prom")
+	for _, err := range []error{nil, nil, errors.New("kubeconfig file does not exist")} {
+		r, _ := NewRecorder(path)
+		if err := r.ObserveClientRebuild("unauthorized", err); err != nil {
+			t.Fatalf("ObserveClientRebuild() error = %v", err)
+		}
+	}
+	r, _ := NewRecorder(path)
+	if err := r.ObserveClientRebuild(`bad"reason`, nil); err == nil {
+		t.Error("expected error for invalid reason")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		`tenant_routing_k8s_client_rebuilds_total{reason="unauthorized",result="success"} 2`,
+		`tenant_routing_k8s_client_rebuilds_total{reason="unauthorized",result="error"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics file missing %s\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OperationsMetric counts CNI invocations by command (ADD, DEL, CHECK) and result
+const OperationsMetric = "tenant_routing_cni_operations_total"
+
+// OperationDurationMetric is the histogram of CNI invocation durations by command
+const OperationDurationMetric = "tenant_routing_cni_operation_duration_seconds"
+
+// DelegateDurationMetric is the histogram of time spent in the delegate plugin by command
+const DelegateDurationMetric = "tenant_routing_delegate_duration_seconds"
+
+// K8sAPIDurationMetric is the histogram of annotation lookups against the API server
+// by operation (annotations, strict)
+const K8sAPIDurationMetric = "tenant_routing_k8s_api_duration_seconds"
+
+// IptablesFailuresMetric counts failed iptables operations by command and reason code,
+// whether the failure was skipped (permissive) or failed the invocation (strict)
+const IptablesFailuresMetric = "tenant_routing_iptables_failures_total"
+
+// ManagedRulesMetric is the number of per-pod MARK rules installed on the node, by tenant
+const ManagedRulesMetric = "tenant_routing_managed_rules"
+
+// Results of OperationsMetric
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// DurationBuckets are the upper bounds in seconds of the operation, delegate and API
+// histograms (the Prometheus client defaults)
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// durationMetrics are the histograms using DurationBuckets
+var durationMetrics = map[string]string{
+	OperationDurationMetric: "Duration of tenant-routing CNI invocations by command",
+	DelegateDurationMetric:  "Time spent in the delegate plugin by CNI command",
+	K8sAPIDurationMetric:    "Duration of Kubernetes API annotation lookups by operation",
+}
+
+// Invocation is what one CNI invocation observed, recorded with ObserveInvocation
+type Invocation struct {
+	// Command is the CNI command: ADD, DEL or CHECK
+	Command string
+
+	// Failed is set if the invocation returned an error to the runtime
+	Failed bool
+
+	// Duration is the wall time of the whole invocation
+	Duration time.Duration
+
+	// Delegate is the time spent calling the delegate plugin; not observed if zero
+	Delegate time.Duration
+
+	// K8sAPI lists the duration of every API lookup, by operation
+	K8sAPI map[string][]time.Duration
+
+	// IptablesFailures counts failed iptables operations by reason code
+	IptablesFailures map[string]int
+
+	// ManagedRules is the number of installed MARK rules by tenant after the invocation
+	// nil leaves the gauge unchanged; tenants missing from it are reported as 0
+	ManagedRules map[string]int
+}
+
+// ObserveInvocation records everything inv observed in a single update of the file
+func (r *Recorder) ObserveInvocation(inv Invocation) error {
+	if !labelPattern.MatchString(inv.Command) {
+		return fmt.Errorf("invalid command label %q", inv.Command)
+	}
+	for operation := range inv.K8sAPI {
+		if !labelPattern.MatchString(operation) {
+			return fmt.Errorf("invalid operation label %q", operation)
+		}
+	}
+	for code := range inv.IptablesFailures {
+		if !labelPattern.MatchString(code) {
+			return fmt.Errorf("invalid reason label %q", code)
+		}
+	}
+	for tenant := range inv.ManagedRules {
+		if !labelPattern.MatchString(tenant) {
+			return fmt.Errorf("invalid tenant label %q", tenant)
+		}
+	}
+
+	result := ResultSuccess
+	if inv.Failed {
+		result = ResultError
+	}
+	return r.update(func(st *state) {
+		st.counter(OperationsMetric, map[string]string{"command": inv.Command, "result": result}, 1)
+		st.duration(OperationDurationMetric, map[string]string{"command": inv.Command}).observe(inv.Duration.Seconds())
+		if inv.Delegate > 0 {
+			st.duration(DelegateDurationMetric, map[string]string{"command": inv.Command}).observe(inv.Delegate.Seconds())
+		}
+		for operation, durations := range inv.K8sAPI {
+			h := st.duration(K8sAPIDurationMetric, map[string]string{"operation": operation})
+			for _, d := range durations {
+				h.observe(d.Seconds())
+			}
+		}
+		for code, n := range inv.IptablesFailures {
+			st.counter(IptablesFailuresMetric, map[string]string{"command": inv.Command, "reason": code}, uint64(n))
+		}
+		if inv.ManagedRules != nil {
+			for tenant := range st.managedRules {
+				st.managedRules[tenant] = 0
+			}
+			for tenant, n := range inv.ManagedRules {
+				st.managedRules[tenant] = uint64(n)
+			}
+		}
+	})
+}
+
+// counter adds n to the counter of metric with labels
+func (st *state) counter(metric string, labels map[string]string, n uint64) {
+	series, ok := st.counters[metric]
+	if !ok {
+		series = map[string]uint64{}
+		st.counters[metric] = series
+	}
+	series[renderLabels(labels)] += n
+}
+
+// duration returns the histogram of metric with labels, creating it if needed
+func (st *state) duration(metric string, labels map[string]string) *histogram {
+	series, ok := st.durations[metric]
+	if !ok {
+		series = map[string]*histogram{}
+		st.durations[metric] = series
+	}
+	key := renderLabels(labels)
+	h, ok := series[key]
+	if !ok {
+		h = newHistogram(DurationBuckets)
+		series[key] = h
+	}
+	return h
+}
+
+// parseOperationLine merges one line of the operation metrics into st
+// Returns false if name is none of them.
+func parseOperationLine(name string, labels map[string]string, value float64, st *state) bool {
+	switch name {
+	case OperationsMetric, IptablesFailuresMetric:
+		st.counter(name, labels, uint64(value))
+		return true
+	case ManagedRulesMetric:
+		if tenant, ok := labels["tenant"]; ok {
+			st.managedRules[tenant] = uint64(value)
+		}
+		return true
+	}
+
+	for metric := range durationMetrics {
+		if !strings.HasPrefix(name, metric) {
+			continue
+		}
+		suffix := name[len(metric):]
+		le := labels["le"]
+		delete(labels, "le")
+		h := st.duration(metric, labels)
+		switch suffix {
+		case "_bucket":
+			for i, bound := range h.bounds {
+				if le == formatFloat(bound) {
+					h.buckets[i] = uint64(value)
+				}
+			}
+		case "_sum":
+			h.sum = value
+		case "_count":
+			h.count = uint64(value)
+		}
+		return true
+	}
+	return false
+}
+
+// writeOperations writes the operation metrics of st; series never observed are omitted
+func writeOperations(b *strings.Builder, st *state) {
+	writeCounter(b, OperationsMetric, "Tenant-routing CNI invocations by command and result", st.counters[OperationsMetric])
+	for _, metric := range sortedKeys(durationMetrics) {
+		series := st.durations[metric]
+		if len(series) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "# HELP %s %s\n", metric, durationMetrics[metric])
+		fmt.Fprintf(b, "# TYPE %s histogram\n", metric)
+		for _, labels := range sortedKeys(series) {
+			h := series[labels]
+			for i, bound := range h.bounds {
+				fmt.Fprintf(b, "%s_bucket{%s,le=%q} %d\n", metric, labels, formatFloat(bound), h.buckets[i])
+			}
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", metric, labels, h.count)
+			fmt.Fprintf(b, "%s_sum{%s} %s\n", metric, labels, formatFloat(h.sum))
+			fmt.Fprintf(b, "%s_count{%s} %d\n", metric, labels, h.count)
+		}
+	}
+	writeCounter(b, IptablesFailuresMetric, "Failed iptables operations by CNI command and reason", st.counters[IptablesFailuresMetric])
+	writeTenantSeries(b, ManagedRulesMetric, "gauge", "Per-pod MARK rules installed on the node", st.managedRules)
+}
+
+// writeCounter writes one counter keyed by rendered labels; nothing if series is empty
+func writeCounter(b *strings.Builder, name, help string, series map[string]uint64) {
+	if len(series) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	for _, labels := range sortedKeys(series) {
+		fmt.Fprintf(b, "%s{%s} %d\n", name, labels, series[labels])
+	}
+}
+
+// renderLabels renders labels sorted by name, e.g. `command="ADD",result="success"`
+func renderLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, name := range sortedKeys(labels) {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReconcileCheckedMetric counts recorded pods whose rules the node agent verified, by
+// scan (sampled or full)
+const ReconcileCheckedMetric = "tenant_routing_reconcile_checked_pods_total"
+
+// ReconcileRepairsMetric counts rules and routes the node agent re-added, by scan
+const ReconcileRepairsMetric = "tenant_routing_reconcile_repairs_total"
+
+// ReconcileCoverageMetric is the fraction (0-1) of recorded pods the last pass of each
+// scan verified
+const ReconcileCoverageMetric = "tenant_routing_reconcile_coverage_ratio"
+
+// Scans of the reconcile metrics
+const (
+	ScanSampled = "sampled"
+	ScanFull    = "full"
+)
+
+// ObserveReconcile records one reconcile pass of scan: the pods it checked, the rules
+// and routes it repaired and the fraction of recorded pods it covered
+func (r *Recorder) ObserveReconcile(scan string, checked, repairs int, coverage float64) error {
+	if scan != ScanSampled && scan != ScanFull {
+		return fmt.Errorf("invalid scan label %q", scan)
+	}
+	if coverage < 0 || coverage > 1 {
+		return fmt.Errorf("reconcile coverage %v out of range (0-1)", coverage)
+	}
+
+	labels := map[string]string{"scan": scan}
+	return r.update(func(st *state) {
+		st.counter(ReconcileCheckedMetric, labels, uint64(checked))
+		st.counter(ReconcileRepairsMetric, labels, uint64(repairs))
+		st.coverage[scan] = coverage
+	})
+}
+
+// parseReconcileLine merges one line of the reconcile metrics into st
+// Returns false if name is none of them.
+func parseReconcileLine(name string, labels map[string]string, value float64, st *state) bool {
+	switch name {
+	case ReconcileCheckedMetric, ReconcileRepairsMetric:
+		st.counter(name, labels, uint64(value))
+		return true
+	case ReconcileCoverageMetric:
+		if scan, ok := labels["scan"]; ok {
+			st.coverage[scan] = value
+		}
+		return true
+	}
+	return false
+}
+
+// writeReconcile writes the reconcile metrics of st; nothing before the first pass
+func writeReconcile(b *strings.Builder, st *state) {
+	writeCounter(b, ReconcileCheckedMetric, "Recorded pods whose rules the node agent verified, by scan",
+		st.counters[ReconcileCheckedMetric])
+	writeCounter(b, ReconcileRepairsMetric, "Rules and routes the node agent re-added, by scan",
+		st.counters[ReconcileRepairsMetric])
+	if len(st.coverage) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s Fraction of recorded pods the last reconcile pass verified, by scan\n", ReconcileCoverageMetric)
+	fmt.Fprintf(b, "# TYPE %s gauge\n", ReconcileCoverageMetric)
+	for _, scan := range sortedKeys(st.coverage) {
+		fmt.Fprintf(b, "%s{scan=%q} %s\n", ReconcileCoverageMetric, scan, formatFloat(st.coverage[scan]))
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package nodelock
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+var errUnsupported = fmt.Errorf("node lock is not supported on %s", runtime.GOOS)
+
+func tryLock(*os.File) (bool, error) { return false, errUnsupported }
+
+func unlock(*os.File) error { return errUnsupported }
@@ -0,0 +1,99 @@
+// Package nodelock serializes tenant-routing rule changes of concurrent plugin
+// invocations on a node.
+//
+// Kubelet runs ADD and DEL of many pods at once. The xtables lock only protects single
+// iptables calls; the check-then-append steps of the plugin (MARK rules, reconciled
+// chains, "last pod of the tenant" routing cleanup) race between invocations. Every
+// invocation therefore takes an exclusive flock(2) on a shared lock file around its
+// rule mutations. The kernel drops the lock when the holder exits, so a crashed
+// invocation never leaves the node locked.
+package nodelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrTimeout is returned (wrapped) when the lock is still held after the timeout
+var ErrTimeout = errors.New("timed out waiting for the node lock")
+
+// Poll intervals while another invocation holds the lock
+const (
+	minPoll = 5 * time.Millisecond
+	maxPoll = 100 * time.Millisecond
+)
+
+// Lock is a held node lock
+type Lock struct {
+	f *os.File
+}
+
+// Acquire takes the exclusive lock on path, waiting at most timeout
+// The holder writes its PID to the file, so a timeout error names the invocation
+// holding the lock.
+// On a read-only filesystem an existing lock file is locked read-only, without the PID.
+func Acquire(path string, timeout time.Duration) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if errors.Is(err, syscall.EROFS) {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open node lock: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	poll := minPoll
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			holder := holderPID(f)
+			f.Close()
+			return nil, fmt.Errorf("%w %s after %s (held by pid %s)", ErrTimeout, path, timeout, holder)
+		}
+		time.Sleep(poll)
+		if poll *= 2; poll > maxPoll {
+			poll = maxPoll
+		}
+	}
+
+	// Best effort: the PID only improves the error of waiting invocations
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release drops the lock; safe to call more than once
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// holderPID returns the PID the current holder wrote, or "unknown"
+func holderPID(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	if pid := strings.TrimSpace(string(buf[:n])); pid != "" {
+		return pid
+	}
+	return "unknown"
+}
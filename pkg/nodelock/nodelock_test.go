@@ -0,0 +1,56 @@
+package nodelock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAcquire verifies a held lock times out other holders and is free after Release
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenant-routing.lock")
+
+	held, err := Acquire(path, time.Second)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	// flock conflicts between open files, also within one process
+	_, err = Acquire(path, 20*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("second Acquire() error = %v, want ErrTimeout", err)
+	}
+	if !strings.Contains(err.Error(), "held by pid "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("timeout error %q does not name the holder", err)
+	}
+
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		held.Release()
+		close(released)
+	}()
+	next, err := Acquire(path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Acquire() after Release error = %v", err)
+	}
+	<-released
+	if err := next.Release(); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+	if err := next.Release(); err != nil {
+		t.Errorf("second Release() error = %v", err)
+	}
+}
+
+// TestAcquire_BadPath verifies an unusable lock file is reported, not waited on
+func TestAcquire_BadPath(t *testing.T) {
+	_, err := Acquire(filepath.Join(t.TempDir(), "missing", "tenant-routing.lock"), time.Second)
+	if err == nil || errors.Is(err, ErrTimeout) {
+		t.Errorf("Acquire() error = %v, want open error", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Package reason defines machine-readable codes for permissive-mode skips.
+//
+// In permissive mode the plugin never fails pod creation because tenant routing
+// could not be set up; it logs and moves on. Downstream automation (log pipelines,
+// alerting on the skip counter, the simulator) needs to tell those skips apart
+// without parsing free-form messages, so every skip carries exactly one Code.
+package reason
+
+import (
+	"errors"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// Code categorizes why tenant routing was not (fully) applied to a pod
+// The codes are part of the public contract; they are defined in pkg/api.
+type Code = api.Reason
+
+const (
+	// NoPodIP: the delegate assigned no address (L2-only delegate), nothing to mark
+	NoPodIP = api.ReasonNoPodIP
+
+	// NoAnnotation: neither the pod nor its namespace carries a fwmark annotation
+	NoAnnotation = api.ReasonNoAnnotation
+
+	// K8sUnreachable: the Kubernetes client could not be created or the API lookup failed
+	K8sUnreachable = api.ReasonK8sUnreachable
+
+	// PodNotFound: the API server does not know the pod (or its namespace)
+	PodNotFound = api.ReasonPodNotFound
+
+	// InvalidFwmark: the fwmark annotation value is not in the allowed set
+	InvalidFwmark = api.ReasonInvalidFwmark
+
+	// InvalidGateway: the gateway annotation value is not a usable IPv4 unicast address
+	InvalidGateway = api.ReasonInvalidGateway
+
+	// Bypassed: the pod carries an active tenant.routing/bypass-until annotation
+	Bypassed = api.ReasonBypassed
+
+	// Excluded: excludeNamespaces or excludePodLabelSelector matches the pod
+	Excluded = api.ReasonExcluded
+
+	// UnsafeSource: the pod IP is a node, loopback or link-local address
+	UnsafeSource = api.ReasonUnsafeSource
+
+	// IptablesFailed: programming a MARK, CONNMARK or OUTPUT rule failed
+	IptablesFailed = api.ReasonIptablesFailed
+
+	// IptablesLocked: the xtables lock was held past the lock timeout; the rules are
+	// queued and installed later by GC
+	IptablesLocked = api.ReasonIptablesLocked
+
+	// NodeLocked: other invocations held the node lock past the lock timeout; the rules
+	// are queued and installed later by GC
+	NodeLocked = api.ReasonNodeLocked
+
+	// RoutingFailed: tenant policy routing could not be configured
+	RoutingFailed = api.ReasonRoutingFailed
+)
+
+// All lists every code, e.g. to pre-register metric series
+var All = api.Reasons
+
+// EventReason returns the reason of the Warning event a pod gets for a skip with code,
+// or "" if the code needs none: the pod is unannotated, bypassed or excluded on purpose, its
+// rules are queued for GC, or the API server is unreachable or does not know the pod
+func EventReason(code Code) string {
+	switch code {
+	case InvalidFwmark:
+		return api.InvalidFwmarkEventReason
+	case InvalidGateway:
+		return api.InvalidGatewayEventReason
+	case UnsafeSource, IptablesFailed, RoutingFailed:
+		return api.RoutingFailedEventReason
+	}
+	return ""
+}
+
+// ForAnnotationError classifies an error returned by the annotation lookup: pkg/k8s, the
+// node agent or the static tenants
+func ForAnnotationError(err error) Code {
+	switch {
+	case errors.Is(err, tenant.ErrInvalidFwmark):
+		return InvalidFwmark
+	case errors.Is(err, tenant.ErrInvalidGateway):
+		return InvalidGateway
+	case errors.Is(err, tenant.ErrNotFound):
+		return PodNotFound
+	default:
+		return K8sUnreachable
+	}
+}
+
+// ForMarkError classifies an error returned while adding the MARK rule
+func ForMarkError(err error) Code {
+	if errors.Is(err, iptables.ErrUnsafeSource) {
+		return UnsafeSource
+	}
+	return ForIptablesError(err)
+}
+
+// ForIptablesError classifies an error returned while programming any other rule
+func ForIptablesError(err error) Code {
+	if iptables.IsLocked(err) {
+		return IptablesLocked
+	}
+	return IptablesFailed
+}
@@ -0,0 +1,78 @@
+package reason
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// TestForAnnotationError verifies lookup errors map to the documented codes
+func TestForAnnotationError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{name: "pod not found", err: fmt.Errorf("pod team-a/web not found: %w", tenant.ErrNotFound), want: PodNotFound},
+		{name: "api failure", err: errors.New("connection refused"), want: K8sUnreachable},
+		{name: "invalid fwmark", err: fmt.Errorf("pod annotation invalid: %w", k8s.ErrInvalidFwmark), want: InvalidFwmark},
+		{name: "invalid gateway", err: fmt.Errorf("pod annotation invalid: %w", k8s.ErrInvalidGateway), want: InvalidGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ForAnnotationError(tt.err); got != tt.want {
+				t.Errorf("ForAnnotationError() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestForAnnotationError_RealLookup verifies the classification against GetRoutingAnnotations errors
+func TestForAnnotationError_RealLookup(t *testing.T) {
+	_, err := k8s.GetRoutingAnnotations(context.Background(), fake.NewSimpleClientset(), "web", "team-a", nil, "")
+	if err == nil {
+		t.Fatal("expected error for missing pod")
+	}
+	if got := ForAnnotationError(err); got != PodNotFound {
+		t.Errorf("ForAnnotationError(%v) = %s, want %s", err, got, PodNotFound)
+	}
+}
+
+// TestForMarkError verifies rule errors map to the documented codes
+func TestForMarkError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{fmt.Errorf("refusing to mark 10.0.0.1: %w", iptables.ErrUnsafeSource), UnsafeSource},
+		{errors.New("exit status 4: Another app is currently holding the xtables lock. Stopped waiting after 5s."), IptablesLocked},
+		{errors.New("exit status 1: Bad rule"), IptablesFailed},
+	}
+	for _, tt := range tests {
+		if got := ForMarkError(tt.err); got != tt.want {
+			t.Errorf("ForMarkError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestEventReason verifies every code either maps to an event reason or is exempt on purpose
+func TestEventReason(t *testing.T) {
+	exempt := map[Code]bool{NoPodIP: true, NoAnnotation: true, Bypassed: true, Excluded: true, K8sUnreachable: true, PodNotFound: true,
+		IptablesLocked: true, NodeLocked: true}
+	for _, code := range All {
+		if got := EventReason(code); (got == "") != exempt[code] {
+			t.Errorf("EventReason(%s) = %q", code, got)
+		}
+	}
+	if got := EventReason(InvalidFwmark); got != k8s.InvalidFwmarkEventReason {
+		t.Errorf("EventReason(%s) = %q, want %q", InvalidFwmark, got, k8s.InvalidFwmarkEventReason)
+	}
+}
@@ -0,0 +1,97 @@
+package reconcile
+
+import (
+	"context"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// Actions of a Change
+const (
+	ChangeAdd    = "add"
+	ChangeRemove = "remove"
+	ChangeMove   = "move"
+)
+
+// Change is one change a reconcile pass would make
+type Change struct {
+	// Action is ChangeAdd, ChangeRemove or ChangeMove
+	Action string
+
+	// What names the rule, route or pod the way the pass logs it
+	What string
+}
+
+func (c Change) String() string {
+	return c.Action + "\t" + c.What
+}
+
+// Diff returns what Relabel followed by Run would change on the node, without changing
+// anything: pods whose annotations were added, removed or changed since ADD have their
+// rules added, removed or moved, and the missing rules and routes of the others are
+// re-added. The checks are those the passes run before applying a change.
+// Takes no node lock, so a CNI invocation or pass running meanwhile can make the diff
+// stale. Pods that cannot be verified do not stop the diff; the first failure is
+// returned with the changes found.
+func Diff(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration) ([]Change, error) {
+	records, err := state.New(conf.StateDir).List(conf.Name)
+	if err != nil {
+		log.Warnf("diffing readable records only: %v", err)
+	}
+
+	marks, err := listMarks(ctx, ipt)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	var firstErr error
+	routes := map[string]bool{}
+	for _, rec := range records {
+		r, considered := changed(ctx, rec, conf, resolver, timeout)
+		switch {
+		case !considered:
+			continue
+		case r.rec != nil:
+			changes = append(changes, r.change())
+			continue
+		case rec.Fwmark == "":
+			// Unmarked and still unannotated: nothing to verify
+			continue
+		}
+
+		// Unchanged annotations: the desired rules are those of the record (see want)
+		d := desired{rec: rec, gateway: rec.Gateway}
+		fixes, err := missingPodRules(ctx, conf, d, marks)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if key := rec.Fwmark + "/" + rec.Gateway; !routes[key] {
+			routes[key] = true
+			routeFixes, err := missingRoute(conf, rec.Fwmark, rec.Gateway)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			fixes = append(fixes, routeFixes...)
+		}
+		for _, f := range fixes {
+			changes = append(changes, Change{Action: ChangeAdd, What: f.what})
+		}
+	}
+	return changes, firstErr
+}
+
+// change returns the move of r, an add for a pod annotated since ADD and a remove
+// for a pod whose annotation was removed
+func (r relabeling) change() Change {
+	switch {
+	case r.rec.Fwmark == "":
+		return Change{Action: ChangeAdd, What: r.String()}
+	case r.fwmark == "":
+		return Change{Action: ChangeRemove, What: r.String()}
+	}
+	return Change{Action: ChangeMove, What: r.String()}
+}
@@ -0,0 +1,63 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestDiff verifies the diff lists the adds, removes and moves of a pass and changes nothing
+func TestDiff(t *testing.T) {
+	conf := testConf(t)
+	connmarks := map[string]bool{"10.0.0.2": true, "10.0.0.3": true, "10.0.0.5": true}
+	useFakeConnmark(t, connmarks)
+	saveRecords(t, conf,
+		&state.Record{ContainerID: "wiped", Namespace: "team-a", Pod: "wiped", IPs: []string{"10.0.0.1"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "intact", Namespace: "team-a", Pod: "intact", IPs: []string{"10.0.0.2"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "moved", Namespace: "team-a", Pod: "moved", IPs: []string{"10.0.0.3"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "annotated", Namespace: "team-a", Pod: "annotated", IPs: []string{"10.0.0.4"}},
+		&state.Record{ContainerID: "unannotated", Namespace: "team-a", Pod: "unannotated", IPs: []string{"10.0.0.5"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "pending", Namespace: "team-a", Pod: "pending", IPs: []string{"10.0.0.6"}, Fwmark: "0x10", Pending: true},
+	)
+	resolver := fakeResolver{
+		"team-a/wiped":       {Fwmark: "0x10"},
+		"team-a/intact":      {Fwmark: "0x10"},
+		"team-a/moved":       {Fwmark: "0x20"},
+		"team-a/annotated":   {Fwmark: "0x10"},
+		"team-a/unannotated": {},
+		"team-a/pending":     {Fwmark: "0x10"},
+	}
+	ipt := iptables.NewFakeManager()
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.5"} {
+		if err := ipt.AddMarkRule(context.Background(), ip, "0x10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err := Diff(context.Background(), ipt, conf, resolver, time.Second)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	want := []Change{
+		{Action: ChangeAdd, What: `pod team-a/annotated (IP: 10.0.0.4) from fwmark "" gateway "" to fwmark "0x10" gateway ""`},
+		{Action: ChangeMove, What: `pod team-a/moved (IP: 10.0.0.3) from fwmark "0x10" gateway "" to fwmark "0x20" gateway ""`},
+		{Action: ChangeRemove, What: `pod team-a/unannotated (IP: 10.0.0.5) from fwmark "0x10" gateway "" to fwmark "" gateway ""`},
+		{Action: ChangeAdd, What: "MARK rule of pod team-a/wiped (IP: 10.0.0.1, fwmark: 0x10)"},
+		{Action: ChangeAdd, What: "CONNMARK rules of pod team-a/wiped (IP: 10.0.0.1)"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Diff() =\n%v\nwant\n%v", changes, want)
+	}
+
+	// Nothing was applied
+	if exists, _ := ipt.RuleExists(context.Background(), "10.0.0.1", "0x10"); exists || connmarks["10.0.0.1"] {
+		t.Error("Diff() re-added rules of team-a/wiped")
+	}
+	if rec, err := state.New(conf.StateDir).Load(conf.Name, "moved", "eth0"); err != nil || rec.Fwmark != "0x10" {
+		t.Errorf("record of team-a/moved = %+v, %v; want it unchanged", rec, err)
+	}
+}
@@ -0,0 +1,240 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/nodelock"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// connmarkRules is the number of CONNMARK rules of a pod (save, restore, restore on OUTPUT)
+// markNewConnectionsOnly adds one (restore for established connections)
+const connmarkRules = 3
+
+// Impact previews what applying a new configuration changes on the node
+type Impact struct {
+	// From and To are the fingerprints of the applied and the new configuration
+	From, To string
+
+	// RulesAdded and RulesRemoved count the per-pod MARK, CONNMARK and OUTPUT rules
+	// the new configuration adds and removes
+	RulesAdded, RulesRemoved int
+
+	// TenantsAdded, TenantsRemoved and TenantsChanged are the fwmarks whose policy
+	// routing table the new configuration adds, removes or changes
+	TenantsAdded, TenantsRemoved, TenantsChanged []string
+
+	// Unmarked lists the pods (namespace/name) that lose their MARK rule
+	Unmarked []string
+}
+
+// Destructive reports whether the change removes a tenant or unmarks pods
+func (i *Impact) Destructive() bool {
+	return len(i.TenantsRemoved) > 0 || len(i.Unmarked) > 0
+}
+
+func (i *Impact) String() string {
+	return fmt.Sprintf("%s -> %s: %d rules to add, %d to remove, tenants added %v removed %v changed %v, %d pods unmarked",
+		i.From, i.To, i.RulesAdded, i.RulesRemoved, i.TenantsAdded, i.TenantsRemoved, i.TenantsChanged, len(i.Unmarked))
+}
+
+// Preview computes the Impact of replacing old with next for the recorded pods of old
+// The fwmark of a pod is re-read with the annotation keys of next only if they
+// changed; pods whose annotations cannot be read are left out. Nothing is changed.
+func Preview(ctx context.Context, old, next *config.PluginConf, resolver Resolver, timeout time.Duration) *Impact {
+	impact := &Impact{From: old.Fingerprint(), To: next.Fingerprint()}
+
+	records, err := state.New(old.StateDir).List(old.Name)
+	if err != nil {
+		log.Warnf("previewing readable records only: %v", err)
+	}
+	rekeyed := !slices.Equal(old.AnnotationKey, next.AnnotationKey) || old.GatewayAnnotationKey != next.GatewayAnnotationKey
+	for _, rec := range records {
+		if rec.Pending || rec.PodIP() == "" {
+			continue
+		}
+		fwmark := rec.Fwmark
+		if rekeyed {
+			annotations, err := resolver.RoutingAnnotations(ctx, rec.Pod, rec.Namespace, next.AnnotationKey,
+				next.GatewayAnnotationKey, timeout)
+			if err != nil {
+				if !errors.Is(err, tenant.ErrNotFound) {
+					log.Warnf("impact on pod %s/%s unknown: %v", rec.Namespace, rec.Pod, err)
+				}
+				continue
+			}
+			fwmark = annotations.Fwmark
+		}
+
+		before, after := podRules(old, rec.Fwmark), podRules(next, fwmark)
+		if fwmark != rec.Fwmark {
+			// A new fwmark replaces every rule of the pod
+			impact.RulesRemoved += before
+			impact.RulesAdded += after
+		} else if after > before {
+			impact.RulesAdded += after - before
+		} else {
+			impact.RulesRemoved += before - after
+		}
+		if rec.Fwmark != "" && fwmark == "" {
+			impact.Unmarked = append(impact.Unmarked, rec.Namespace+"/"+rec.Pod)
+		}
+	}
+
+	oldTables, nextTables := tables(old), tables(next)
+	for fwmark, table := range nextTables {
+		previous, ok := oldTables[fwmark]
+		switch {
+		case !ok:
+			impact.TenantsAdded = append(impact.TenantsAdded, fwmark)
+		case !reflect.DeepEqual(previous, table) || strategy(old) != strategy(next) || priority(old) != priority(next):
+			impact.TenantsChanged = append(impact.TenantsChanged, fwmark)
+		}
+	}
+	for fwmark := range oldTables {
+		if _, ok := nextTables[fwmark]; !ok {
+			impact.TenantsRemoved = append(impact.TenantsRemoved, fwmark)
+		}
+	}
+	sort.Strings(impact.TenantsAdded)
+	sort.Strings(impact.TenantsRemoved)
+	sort.Strings(impact.TenantsChanged)
+	sort.Strings(impact.Unmarked)
+	return impact
+}
+
+// ApplyConfig removes what next no longer installs, before passes run with next
+// That is the policy routing of removed and changed tenants (Run re-adds the changed
+// ones) and the CONNMARK and OUTPUT rules of recorded pods if next disables them.
+// MARK rules of pods whose annotation keys changed are left to Relabel. Failures do
+// not stop the removal; the first one is returned.
+func ApplyConfig(ctx context.Context, ipt iptables.Manager, old, next *config.PluginConf, impact *Impact) error {
+	dropConnmark := old.Connmark && !next.Connmark
+	dropOutput := old.MarkHostTraffic && !next.MarkHostTraffic
+	stale := append(append([]string{}, impact.TenantsRemoved...), impact.TenantsChanged...)
+	if len(stale) == 0 && !dropConnmark && !dropOutput {
+		return nil
+	}
+
+	lock, err := nodelock.Acquire(old.LockFile, time.Duration(old.LockTimeout)*time.Second)
+	if err != nil {
+		return fmt.Errorf("configuration not applied: %w", err)
+	}
+	defer lock.Release()
+
+	records, err := state.New(old.StateDir).List(old.Name)
+	if err != nil {
+		log.Warnf("applying configuration to readable records only: %v", err)
+	}
+	var firstErr error
+	fail := func(err error) {
+		log.Warnf("%v", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, fwmark := range stale {
+		// The recorded gateway of a pod overrides the configured one, as at ADD
+		gateway := ""
+		for _, rec := range records {
+			if sameFwmark(rec.Fwmark, fwmark) && rec.Gateway != "" {
+				gateway = rec.Gateway
+				break
+			}
+		}
+		tr, ok, err := route.FromConfig(old, fwmark, gateway)
+		if err != nil || !ok {
+			continue
+		}
+		if err := removeRouteFunc(tr); err != nil {
+			fail(fmt.Errorf("failed to remove policy routing (%s): %w", tr, err))
+			continue
+		}
+		log.Infof("removed policy routing of reconfigured tenant: %s", tr)
+	}
+
+	for _, rec := range records {
+		podIP := rec.PodIP()
+		if rec.Fwmark == "" || podIP == "" {
+			continue
+		}
+		if dropConnmark {
+			if err := deleteConnmarkFunc(ctx, podIP); err != nil {
+				fail(fmt.Errorf("failed to delete CONNMARK rules of pod %s/%s: %w", rec.Namespace, rec.Pod, err))
+			}
+		}
+		if dropOutput {
+			if err := deleteOutputFunc(ctx, podIP, rec.Fwmark); err != nil {
+				fail(fmt.Errorf("failed to delete OUTPUT mark rule of pod %s/%s: %w", rec.Namespace, rec.Pod, err))
+			}
+		}
+	}
+	return firstErr
+}
+
+// podRules is the number of per-pod rules conf installs for a pod marked with fwmark
+func podRules(conf *config.PluginConf, fwmark string) int {
+	if fwmark == "" {
+		return 0
+	}
+	n := 1
+	if conf.Connmark {
+		n += connmarkRules
+		if conf.MarkNewConnectionsOnly {
+			n++
+		}
+	}
+	if conf.MarkHostTraffic {
+		n++
+	}
+	return n
+}
+
+// tables returns the routing tables of conf keyed by fwmark in canonical hex
+func tables(conf *config.PluginConf) map[string]config.RouteTableConf {
+	result := map[string]config.RouteTableConf{}
+	if conf.Routing == nil {
+		return result
+	}
+	for key, table := range conf.Routing.Tables {
+		if mark, err := strconv.ParseUint(strings.TrimSpace(key), 0, 32); err == nil {
+			result[fmt.Sprintf("0x%x", mark)] = table
+		}
+	}
+	return result
+}
+
+// strategy and priority return the routing settings every tenant shares
+func strategy(conf *config.PluginConf) string {
+	if conf.Routing == nil {
+		return ""
+	}
+	return conf.Routing.Strategy
+}
+
+func priority(conf *config.PluginConf) int {
+	if conf.Routing == nil {
+		return 0
+	}
+	return conf.Routing.RulePriority
+}
+
+// sameFwmark reports whether two fwmark strings denote the same mark
+func sameFwmark(a, b string) bool {
+	x, errA := route.ParseFwmark(a)
+	y, errB := route.ParseFwmark(b)
+	return errA == nil && errB == nil && x == y
+}
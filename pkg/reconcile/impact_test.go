@@ -0,0 +1,78 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestPreviewAndApplyConfig verifies the impact of a reload and what applying it removes
+func TestPreviewAndApplyConfig(t *testing.T) {
+	old := testConf(t)
+	old.Routing = &config.RoutingConf{Tables: map[string]config.RouteTableConf{
+		"0x10": {Table: 100, Gateway: "10.10.10.1"},
+		"0x20": {Table: 200, Gateway: "10.10.20.1"},
+		"0x30": {Table: 300},
+	}}
+	saveRecords(t, old,
+		&state.Record{ContainerID: "a", Namespace: "team-a", Pod: "a", IPs: []string{"10.0.0.1"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "b", Namespace: "team-b", Pod: "b", IPs: []string{"10.0.0.2"}, Fwmark: "0x20"},
+		&state.Record{ContainerID: "c", Namespace: "team-c", Pod: "c", IPs: []string{"10.0.0.3"}},
+		&state.Record{ContainerID: "p", Namespace: "team-a", Pod: "p", IPs: []string{"10.0.0.4"}, Fwmark: "0x10", Pending: true},
+	)
+
+	// Turning CONNMARK off only removes rules; tenant 0x20 goes, 0x30 changes, 0x40 comes
+	next := *old
+	next.Connmark = false
+	next.Routing = &config.RoutingConf{Tables: map[string]config.RouteTableConf{
+		"16":   {Table: 100, Gateway: "10.10.10.1"},
+		"0x30": {Table: 301},
+		"0x40": {Table: 400},
+	}}
+	impact := Preview(context.Background(), old, &next, fakeResolver{}, time.Second)
+	want := &Impact{From: old.Fingerprint(), To: next.Fingerprint(), RulesRemoved: 2 * connmarkRules,
+		TenantsAdded: []string{"0x40"}, TenantsRemoved: []string{"0x20"}, TenantsChanged: []string{"0x30"}}
+	if !reflect.DeepEqual(impact, want) {
+		t.Errorf("Preview() = %+v, want %+v", impact, want)
+	}
+	if !impact.Destructive() {
+		t.Error("removing a tenant is not destructive")
+	}
+
+	connmarks := map[string]bool{"10.0.0.1": true, "10.0.0.2": true}
+	useFakeConnmark(t, connmarks)
+	var removed []string
+	origRemove := removeRouteFunc
+	removeRouteFunc = func(tr route.TenantRoute) error {
+		removed = append(removed, tr.String())
+		return nil
+	}
+	t.Cleanup(func() { removeRouteFunc = origRemove })
+	if err := ApplyConfig(context.Background(), iptables.NewFakeManager(), old, &next, impact); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	if len(removed) != 2 || len(connmarks) != 0 {
+		t.Errorf("ApplyConfig() removed routes %v and left CONNMARK rules %v; want 2 routes, no rules", removed, connmarks)
+	}
+
+	// A new annotation key unmarks pods without the new annotation
+	rekeyed := *old
+	rekeyed.AnnotationKey = config.AnnotationKeys{"example.com/fwmark"}
+	resolver := fakeResolver{"team-a/a": {Fwmark: "0x10"}, "team-b/b": {}, "team-c/c": {Fwmark: "0x30"}}
+	impact = Preview(context.Background(), old, &rekeyed, resolver, time.Second)
+	if impact.RulesAdded != 1+connmarkRules || impact.RulesRemoved != 1+connmarkRules ||
+		!reflect.DeepEqual(impact.Unmarked, []string{"team-b/b"}) || !impact.Destructive() {
+		t.Errorf("Preview() of a new annotation key = %+v, want team-b/b unmarked and team-c/c marked", impact)
+	}
+
+	impact = Preview(context.Background(), old, old, fakeResolver{}, time.Second)
+	if impact.Destructive() || impact.RulesAdded+impact.RulesRemoved != 0 {
+		t.Errorf("Preview() of an unchanged configuration = %+v, want no impact", impact)
+	}
+}
@@ -0,0 +1,463 @@
+// Package reconcile re-installs the rules of recorded pods that vanished behind the
+// plugin's back.
+//
+// ADD installs the rules of a pod once, and CHECK only reports drift. A firewalld
+// reload or an iptables-restore of a foreign ruleset deletes them silently in between.
+// Run compares the state records ADD wrote with the current annotations of their pods
+// and re-adds missing MARK, CONNMARK and OUTPUT rules and tenant policy routing.
+//
+// A record is only re-asserted while its pod still runs and carries the recorded fwmark
+// and no active bypass: a changed annotation is left to Relabel, a deleted pod to GC,
+// and queued (pending) rules to the GC retry.
+//
+// Relabel applies annotations that were added, changed or removed after ADD to the
+// rules of running pods.
+//
+// ReleaseTerminated removes the rules of pods that reached phase Succeeded or Failed
+// without waiting for DEL. Run and Relabel leave such pods alone.
+//
+// Diff reports what Relabel followed by Run would change, without changing anything.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/logging"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/nodelock"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// Resolver answers the current annotations of a pod; *k8s.Informers implements it
+type Resolver interface {
+	RoutingAnnotations(ctx context.Context, podName, podNamespace string, fwmarkKeys []string, gatewayKey string,
+		timeout time.Duration) (tenant.RoutingAnnotations, error)
+}
+
+// Result reports what a reconcile pass found and did
+type Result struct {
+	// Checked is the number of records whose rules were verified
+	Checked int
+
+	// Skipped is the number of records left alone (pending, bypassed, changed or gone)
+	Skipped int
+
+	// Repaired describes every rule or route that was missing and re-added
+	Repaired []string
+
+	// Relabeled describes every pod moved to a changed annotation (Relabel only)
+	Relabeled []string
+
+	// Released describes every terminated pod whose rules were removed (ReleaseTerminated only)
+	Released []string
+
+	// Records is the number of records of the network, including those a sampled pass
+	// did not select (Run and RunSample only)
+	Records int
+
+	// Tenants holds the marking health of the verified pods by tenant (fwmark)
+	// (Run and RunSample only)
+	Tenants map[string]TenantHealth
+}
+
+// TenantHealth is what a pass found for the pods of one tenant
+type TenantHealth struct {
+	// Checked is the number of pods whose rules were verified
+	Checked int
+
+	// Drifted is the number of them that were missing a per-pod rule or could not be
+	// verified
+	Drifted int
+}
+
+// Sample selects the records a RunSample pass verifies
+type Sample struct {
+	// Size is the number of records verified at random besides the selected ones below
+	Size int
+
+	// Since selects every record ADD wrote after it (zero: none)
+	Since time.Time
+
+	// Pods selects every record of these pods ("namespace/name"), e.g. pods whose
+	// annotations changed since the last pass
+	Pods map[string]bool
+
+	// Namespaces selects every record of the pods in these namespaces
+	Namespaces map[string]bool
+}
+
+// Coverage is the fraction of the network's records a pass verified or skipped
+// deliberately (1 for a full pass, and for a network without records)
+func (r *Result) Coverage() float64 {
+	if r.Records == 0 {
+		return 1
+	}
+	return float64(r.Checked+r.Skipped) / float64(r.Records)
+}
+
+// Replaced in tests to avoid exec and netlink
+var (
+	connmarkExistsFunc = iptables.ConnmarkRulesExist
+	addConnmarkFunc    = iptables.AddConnmarkRules
+	outputExistsFunc   = iptables.OutputMarkRuleExists
+	addOutputFunc      = iptables.AddOutputMarkRule
+	verifyRouteFunc    = route.VerifyTenantRoute
+	ensureRouteFunc    = route.EnsureTenantRoute
+	verifyRPFilterFunc = route.VerifyRPFilter
+	relaxRPFilterFunc  = route.RelaxRPFilter
+
+	newConnectionConnmarkExistsFunc = iptables.NewConnectionConnmarkRulesExist
+	addNewConnectionConnmarkFunc    = iptables.AddNewConnectionConnmarkRules
+)
+
+// connmark verifies and adds the CONNMARK rules of a pod
+type connmark struct {
+	exists func(ctx context.Context, podIP string) (bool, error)
+	add    func(ctx context.Context, podIP string) error
+}
+
+// connmarkFuncs returns the CONNMARK rule functions matching the MARK rules of conf
+func connmarkFuncs(conf *config.PluginConf) connmark {
+	if conf.MarkNewConnectionsOnly {
+		return connmark{exists: newConnectionConnmarkExistsFunc, add: addNewConnectionConnmarkFunc}
+	}
+	return connmark{exists: connmarkExistsFunc, add: addConnmarkFunc}
+}
+
+var log = logging.Component("reconcile")
+
+// desired is a record whose rules should be installed, with its current gateway
+type desired struct {
+	rec     *state.Record
+	gateway string
+}
+
+// Run verifies the rules of every record of conf.Name and re-adds missing ones
+// Annotations are resolved before the node lock is taken, so a slow lookup does not
+// block CNI invocations. Failures to repair one pod do not stop the pass; the first
+// one is returned with the partial result.
+func Run(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration) (*Result, error) {
+	return run(ctx, ipt, conf, resolver, timeout, nil)
+}
+
+// RunSample is Run limited to the records sample selects
+// Verifying thousands of rules is expensive; sampled passes catch recent drift cheaply
+// and a full Run at a longer period covers the rest.
+func RunSample(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration, sample Sample) (*Result, error) {
+	return run(ctx, ipt, conf, resolver, timeout, &sample)
+}
+
+// run verifies the records sample selects, all of them if sample is nil
+func run(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration, sample *Sample) (*Result, error) {
+	records, err := state.New(conf.StateDir).List(conf.Name)
+	if err != nil {
+		log.Warnf("reconciling readable records only: %v", err)
+	}
+
+	result := &Result{Records: len(records), Tenants: map[string]TenantHealth{}}
+	if sample != nil {
+		records = sample.pick(records)
+	}
+	var pods []desired
+	for _, rec := range records {
+		if d, ok := want(ctx, rec, conf, resolver, timeout); ok {
+			pods = append(pods, d)
+		} else {
+			result.Skipped++
+		}
+	}
+	if len(pods) == 0 {
+		return result, nil
+	}
+
+	lock, err := nodelock.Acquire(conf.LockFile, time.Duration(conf.LockTimeout)*time.Second)
+	if err != nil {
+		return result, fmt.Errorf("reconcile pass skipped: %w", err)
+	}
+	defer lock.Release()
+
+	marks, err := listMarks(ctx, ipt)
+	if err != nil {
+		return result, fmt.Errorf("reconcile pass skipped: %w", err)
+	}
+	var firstErr error
+	plans := make([]podRepair, 0, len(pods))
+	var missing []iptables.MarkRule
+	for _, d := range pods {
+		fixes, err := missingPodRules(ctx, conf, d, marks)
+		plans = append(plans, podRepair{desired: d, fixes: fixes, err: err})
+		for _, f := range fixes {
+			if f.mark != nil {
+				missing = append(missing, *f.mark)
+			}
+		}
+	}
+	// Missing MARK rules are re-added in one iptables-restore transaction: after a
+	// reboot or a flush, that is every pod's
+	var markErr error
+	if len(missing) > 0 {
+		if err := ipt.ApplyRules(ctx, missing, batchOptions(conf)...); err != nil {
+			markErr = fmt.Errorf("failed to re-add %d MARK rules: %w", len(missing), err)
+		}
+	}
+
+	routes := map[string]bool{}
+	for _, p := range plans {
+		d := p.desired
+		result.Checked++
+		repairs := len(result.Repaired)
+		err := repairPod(p, markErr, result)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		health := result.Tenants[d.rec.Fwmark]
+		health.Checked++
+		if err != nil || len(result.Repaired) > repairs {
+			health.Drifted++
+		}
+		result.Tenants[d.rec.Fwmark] = health
+		key := d.rec.Fwmark + "/" + d.gateway
+		if routes[key] {
+			continue
+		}
+		routes[key] = true
+		if err := repairRoute(conf, d.rec.Fwmark, d.gateway, result); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return result, firstErr
+}
+
+// pick returns the records s selects: the recent and named ones, then Size more at random
+func (s *Sample) pick(records []*state.Record) []*state.Record {
+	var picked, rest []*state.Record
+	for _, rec := range records {
+		if !s.Since.IsZero() && rec.Created.After(s.Since) || s.Pods[rec.Namespace+"/"+rec.Pod] || s.Namespaces[rec.Namespace] {
+			picked = append(picked, rec)
+		} else {
+			rest = append(rest, rec)
+		}
+	}
+	rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	return append(picked, rest[:min(max(s.Size, 0), len(rest))]...)
+}
+
+// want reports whether the rules of rec should be installed right now
+func want(ctx context.Context, rec *state.Record, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration) (desired, bool) {
+	if rec.Pending || rec.Fwmark == "" || rec.PodIP() == "" {
+		return desired{}, false
+	}
+	annotations, err := resolver.RoutingAnnotations(ctx, rec.Pod, rec.Namespace, conf.AnnotationKey,
+		conf.GatewayAnnotationKey, timeout)
+	if err != nil {
+		if !errors.Is(err, tenant.ErrNotFound) {
+			log.Warnf("rules of pod %s/%s not verified: %v", rec.Namespace, rec.Pod, err)
+		}
+		return desired{}, false
+	}
+	switch {
+	case rec.PodUID != "" && annotations.PodUID != "" && rec.PodUID != annotations.PodUID:
+		// A newer pod of the same name; the record belongs to a pod GC collects
+		return desired{}, false
+	case annotations.Terminated:
+		return desired{}, false
+	case annotations.Fwmark != rec.Fwmark:
+		log.Debugf("pod %s/%s fwmark changed from %s to %q, left to relabel", rec.Namespace, rec.Pod,
+			rec.Fwmark, annotations.Fwmark)
+		return desired{}, false
+	case annotations.BypassError == nil && annotations.BypassActive(time.Now()):
+		return desired{}, false
+	}
+	return desired{rec: rec, gateway: annotations.Gateway}, true
+}
+
+// fix is a missing rule or route and how to re-add it
+type fix struct {
+	what  string
+	apply func() error
+
+	// mark is set instead of apply for a MARK rule, which a pass re-adds in its batch
+	mark *iptables.MarkRule
+}
+
+// podRepair is what a pass found missing for one pod
+type podRepair struct {
+	desired
+
+	// fixes are the missing rules; err is why the rest could not be verified
+	fixes []fix
+	err   error
+}
+
+// repairPod re-adds the missing per-pod rules of p; its MARK rule was re-added in the
+// pass's batch, which failed with markErr
+func repairPod(p podRepair, markErr error, result *Result) error {
+	for _, f := range p.fixes {
+		if f.mark != nil && markErr != nil {
+			return markErr
+		}
+		if f.apply != nil {
+			if err := f.apply(); err != nil {
+				return err
+			}
+		}
+		repaired(result, "%s", f.what)
+	}
+	return p.err
+}
+
+// markSet is the installed MARK rules by pod IP and fwmark
+type markSet map[string]bool
+
+// listMarks lists the installed MARK rules once for a pass
+func listMarks(ctx context.Context, ipt iptables.Manager) (markSet, error) {
+	rules, err := ipt.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list MARK rules: %w", err)
+	}
+	marks := make(markSet, len(rules))
+	for _, rule := range rules {
+		marks[markKey(rule.PodIP, rule.Fwmark)] = true
+	}
+	return marks, nil
+}
+
+// markKey returns the markSet key of the rule marking podIP with fwmark
+func markKey(podIP, fwmark string) string {
+	if ip := net.ParseIP(podIP); ip != nil {
+		podIP = ip.String()
+	}
+	return podIP + "/" + strings.ToLower(fwmark)
+}
+
+// batchOptions returns the ApplyRules options re-adding missing MARK rules of conf
+func batchOptions(conf *config.PluginConf) []iptables.MarkOption {
+	opts := []iptables.MarkOption{iptables.KeepUnlisted()}
+	if conf.AllowUnsafeSources {
+		opts = append(opts, iptables.AllowUnsafeSources())
+	}
+	if conf.MarkNewConnectionsOnly {
+		opts = append(opts, iptables.NewConnectionsOnly())
+	}
+	return opts
+}
+
+// missingPodRules returns the per-pod rules of d that are missing, in installation order
+// The MARK rule is looked up in marks. If a rule cannot be verified, the rules found
+// missing before it are returned with the error.
+func missingPodRules(ctx context.Context, conf *config.PluginConf, d desired, marks markSet) ([]fix, error) {
+	rec, podIP := d.rec, d.rec.PodIP()
+	var fixes []fix
+	if !marks[markKey(podIP, rec.Fwmark)] {
+		rule := iptables.MarkRule{PodIP: podIP, Fwmark: rec.Fwmark, Hook: conf.MarkHook(rec.Fwmark)}
+		if conf.PodUIDComments {
+			rule.PodUID = rec.PodUID
+		}
+		fixes = append(fixes, fix{
+			what: fmt.Sprintf("MARK rule of pod %s/%s (IP: %s, fwmark: %s)", rec.Namespace, rec.Pod, podIP, rec.Fwmark),
+			mark: &rule,
+		})
+	}
+
+	if conf.Connmark {
+		connmark := connmarkFuncs(conf)
+		if exists, err := connmark.exists(ctx, podIP); err != nil {
+			return fixes, fmt.Errorf("cannot verify CONNMARK rules of pod %s/%s: %w", rec.Namespace, rec.Pod, err)
+		} else if !exists {
+			fixes = append(fixes, fix{
+				what: fmt.Sprintf("CONNMARK rules of pod %s/%s (IP: %s)", rec.Namespace, rec.Pod, podIP),
+				apply: func() error {
+					if err := connmark.add(ctx, podIP); err != nil {
+						return fmt.Errorf("failed to re-add CONNMARK rules of pod %s/%s: %w", rec.Namespace, rec.Pod, err)
+					}
+					return nil
+				},
+			})
+		}
+	}
+	if conf.MarkHostTraffic {
+		if exists, err := outputExistsFunc(ctx, podIP, rec.Fwmark); err != nil {
+			return fixes, fmt.Errorf("cannot verify OUTPUT mark rule of pod %s/%s: %w", rec.Namespace, rec.Pod, err)
+		} else if !exists {
+			fixes = append(fixes, fix{
+				what: fmt.Sprintf("OUTPUT mark rule of pod %s/%s (IP: %s, fwmark: %s)", rec.Namespace, rec.Pod, podIP, rec.Fwmark),
+				apply: func() error {
+					if err := addOutputFunc(ctx, podIP, rec.Fwmark); err != nil {
+						return fmt.Errorf("failed to re-add OUTPUT mark rule of pod %s/%s: %w", rec.Namespace, rec.Pod, err)
+					}
+					return nil
+				},
+			})
+		}
+	}
+	return fixes, nil
+}
+
+// repairRoute re-ensures tenant policy routing of fwmark if it drifted
+func repairRoute(conf *config.PluginConf, fwmark, gateway string, result *Result) error {
+	fixes, err := missingRoute(conf, fwmark, gateway)
+	if err != nil {
+		return err
+	}
+	for _, f := range fixes {
+		if err := f.apply(); err != nil {
+			return err
+		}
+		repaired(result, "%s", f.what)
+	}
+	return nil
+}
+
+// missingRoute returns the drifted tenant policy routing and rp_filter setting of fwmark
+func missingRoute(conf *config.PluginConf, fwmark, gateway string) ([]fix, error) {
+	tr, ok, err := route.FromConfig(conf, fwmark, gateway)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var fixes []fix
+	if err := verifyRouteFunc(tr); err != nil {
+		fixes = append(fixes, fix{
+			what: fmt.Sprintf("policy routing (%s)", tr),
+			apply: func() error {
+				if err := ensureRouteFunc(tr); err != nil {
+					return fmt.Errorf("failed to re-ensure policy routing (%s): %w", tr, err)
+				}
+				return nil
+			},
+		})
+	}
+	if conf.Routing.RelaxRPFilter {
+		if err := verifyRPFilterFunc(tr); err != nil {
+			fixes = append(fixes, fix{
+				what: fmt.Sprintf("loose rp_filter for gateway %s", tr.Gateway),
+				apply: func() error {
+					if _, err := relaxRPFilterFunc(tr); err != nil {
+						return fmt.Errorf("failed to relax rp_filter for gateway %s: %w", tr.Gateway, err)
+					}
+					return nil
+				},
+			})
+		}
+	}
+	return fixes, nil
+}
+
+// repaired records and logs one re-added rule or route
+func repaired(result *Result, format string, args ...interface{}) {
+	what := fmt.Sprintf(format, args...)
+	result.Repaired = append(result.Repaired, what)
+	log.Warnf("re-added missing %s", what)
+}
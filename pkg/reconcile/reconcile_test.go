@@ -0,0 +1,240 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// fakeResolver answers from a fixed map; pods missing from it are not found
+type fakeResolver map[string]k8s.RoutingAnnotations
+
+func (f fakeResolver) RoutingAnnotations(_ context.Context, podName, podNamespace string, _ []string, _ string,
+	_ time.Duration) (k8s.RoutingAnnotations, error) {
+	annotations, ok := f[podNamespace+"/"+podName]
+	if !ok {
+		return k8s.RoutingAnnotations{}, fmt.Errorf("pod %s/%s not found: %w", podNamespace, podName, tenant.ErrNotFound)
+	}
+	return annotations, nil
+}
+
+// useFakeConnmark replaces the CONNMARK hooks with an in-memory set of pod IPs
+func useFakeConnmark(t *testing.T, installed map[string]bool) {
+	t.Helper()
+	origExists, origAdd, origDelete := connmarkExistsFunc, addConnmarkFunc, deleteConnmarkFunc
+	connmarkExistsFunc = func(_ context.Context, podIP string) (bool, error) { return installed[podIP], nil }
+	addConnmarkFunc = func(_ context.Context, podIP string) error {
+		installed[podIP] = true
+		return nil
+	}
+	deleteConnmarkFunc = func(_ context.Context, podIP string) error {
+		delete(installed, podIP)
+		return nil
+	}
+	t.Cleanup(func() { connmarkExistsFunc, addConnmarkFunc, deleteConnmarkFunc = origExists, origAdd, origDelete })
+}
+
+func testConf(t *testing.T) *config.PluginConf {
+	dir := t.TempDir()
+	conf := &config.PluginConf{StateDir: dir, LockFile: filepath.Join(dir, "node.lock"), LockTimeout: 1,
+		AnnotationKey: config.AnnotationKeys{"tenant.routing/fwmark"}, Connmark: true}
+	conf.Name = "tenant-net"
+	return conf
+}
+
+func saveRecords(t *testing.T, conf *config.PluginConf, records ...*state.Record) {
+	t.Helper()
+	store := state.New(conf.StateDir)
+	for _, rec := range records {
+		rec.Network = conf.Name
+		rec.IfName = "eth0"
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRun_RepairsMissingRules verifies only rules the pod should still have are re-added
+func TestRun_RepairsMissingRules(t *testing.T) {
+	conf := testConf(t)
+	connmarks := map[string]bool{"10.0.0.2": true}
+	useFakeConnmark(t, connmarks)
+	saveRecords(t, conf,
+		&state.Record{ContainerID: "wiped", Namespace: "team-a", Pod: "wiped", IPs: []string{"10.0.0.1"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "intact", Namespace: "team-a", Pod: "intact", IPs: []string{"10.0.0.2"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "bypassed", Namespace: "team-a", Pod: "bypassed", IPs: []string{"10.0.0.3"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "moved", Namespace: "team-b", Pod: "moved", IPs: []string{"10.0.0.4"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "pending", Namespace: "team-a", Pod: "pending", IPs: []string{"10.0.0.5"}, Fwmark: "0x10", Pending: true},
+		&state.Record{ContainerID: "gone", Namespace: "team-a", Pod: "gone", IPs: []string{"10.0.0.6"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "replaced", Namespace: "team-a", Pod: "replaced", PodUID: "old", IPs: []string{"10.0.0.7"}, Fwmark: "0x10"},
+	)
+	resolver := fakeResolver{
+		"team-a/wiped":    {Fwmark: "0x10"},
+		"team-a/intact":   {Fwmark: "0x10"},
+		"team-a/bypassed": {Fwmark: "0x10", BypassUntil: time.Now().Add(time.Hour)},
+		"team-b/moved":    {Fwmark: "0x20"},
+		"team-a/pending":  {Fwmark: "0x10"},
+		"team-a/replaced": {Fwmark: "0x10", PodUID: "new"},
+	}
+	ipt := iptables.NewFakeManager()
+	if err := ipt.AddMarkRule(context.Background(), "10.0.0.2", "0x10"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Run(context.Background(), ipt, conf, resolver, time.Second)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Checked != 2 || result.Skipped != 5 || len(result.Repaired) != 2 {
+		t.Errorf("Run() = %+v, want 2 checked, 5 skipped, MARK and CONNMARK of wiped repaired", result)
+	}
+	if got := result.Tenants["0x10"]; got != (TenantHealth{Checked: 2, Drifted: 1}) || len(result.Tenants) != 1 {
+		t.Errorf("Run() tenants = %+v, want 0x10 with 2 checked and 1 drifted", result.Tenants)
+	}
+	for podIP, want := range map[string]bool{"10.0.0.1": true, "10.0.0.2": true, "10.0.0.3": false, "10.0.0.4": false,
+		"10.0.0.5": false, "10.0.0.7": false} {
+		if exists, _ := ipt.RuleExists(context.Background(), podIP, "0x10"); exists != want {
+			t.Errorf("MARK rule of %s exists = %t, want %t", podIP, exists, want)
+		}
+	}
+	if !connmarks["10.0.0.1"] {
+		t.Error("CONNMARK rules of 10.0.0.1 not re-added")
+	}
+
+	// Nothing is missing any more
+	if result, err := Run(context.Background(), ipt, conf, resolver, time.Second); err != nil || len(result.Repaired) != 0 {
+		t.Errorf("second Run() = %+v, %v; want nothing repaired", result, err)
+	}
+}
+
+// batchManager counts the ApplyRules calls of a FakeManager and fails them with err
+type batchManager struct {
+	*iptables.FakeManager
+	calls int
+	err   error
+}
+
+func (m *batchManager) ApplyRules(ctx context.Context, rules []iptables.MarkRule, opts ...iptables.MarkOption) error {
+	m.calls++
+	if m.err != nil {
+		return m.err
+	}
+	return m.FakeManager.ApplyRules(ctx, rules, opts...)
+}
+
+// TestRun_BatchesMarkRules verifies missing MARK rules are re-added in one transaction
+// with their pod UIDs, and a failed one leaves the pods' other rules alone
+func TestRun_BatchesMarkRules(t *testing.T) {
+	conf := testConf(t)
+	conf.PodUIDComments = true
+	connmarks := map[string]bool{}
+	useFakeConnmark(t, connmarks)
+	saveRecords(t, conf,
+		&state.Record{ContainerID: "a", Namespace: "team-a", Pod: "a", PodUID: "uid-a", IPs: []string{"10.0.0.1"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "b", Namespace: "team-a", Pod: "b", PodUID: "uid-b", IPs: []string{"10.0.0.2"}, Fwmark: "0x10"},
+	)
+	resolver := fakeResolver{"team-a/a": {Fwmark: "0x10"}, "team-a/b": {Fwmark: "0x10"}}
+
+	ipt := &batchManager{FakeManager: iptables.NewFakeManager(), err: errors.New("iptables-restore: line 2 failed")}
+	result, err := Run(context.Background(), ipt, conf, resolver, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "failed to re-add 2 MARK rules") {
+		t.Errorf("Run() error = %v, want the failed batch", err)
+	}
+	if ipt.calls != 1 || len(result.Repaired) != 0 || len(connmarks) != 0 {
+		t.Errorf("Run() = %+v after %d batches; want one batch and nothing repaired", result, ipt.calls)
+	}
+	if got := result.Tenants["0x10"]; got != (TenantHealth{Checked: 2, Drifted: 2}) {
+		t.Errorf("Run() tenants = %+v, want 2 drifted", result.Tenants)
+	}
+
+	ipt.calls, ipt.err = 0, nil
+	if result, err := Run(context.Background(), ipt, conf, resolver, time.Second); err != nil || len(result.Repaired) != 4 {
+		t.Errorf("Run() = %+v, %v; want MARK and CONNMARK of both pods repaired", result, err)
+	}
+	if ipt.calls != 1 || ipt.RulePodUID("10.0.0.1", "0x10") != "uid-a" || ipt.RulePodUID("10.0.0.2", "0x10") != "uid-b" {
+		t.Errorf("%d batches, pod UIDs %q and %q; want one batch tagging both pods", ipt.calls,
+			ipt.RulePodUID("10.0.0.1", "0x10"), ipt.RulePodUID("10.0.0.2", "0x10"))
+	}
+}
+
+// TestRun_NewConnectionsOnly verifies the CONNMARK rules of markNewConnectionsOnly are
+// checked and re-added instead of the default ones
+func TestRun_NewConnectionsOnly(t *testing.T) {
+	conf := testConf(t)
+	conf.MarkNewConnectionsOnly = true
+	connmarks := map[string]bool{}
+	useFakeConnmark(t, connmarks)
+	newConnectionConnmarks := map[string]bool{}
+	origExists, origAdd := newConnectionConnmarkExistsFunc, addNewConnectionConnmarkFunc
+	newConnectionConnmarkExistsFunc = func(_ context.Context, podIP string) (bool, error) {
+		return newConnectionConnmarks[podIP], nil
+	}
+	addNewConnectionConnmarkFunc = func(_ context.Context, podIP string) error {
+		newConnectionConnmarks[podIP] = true
+		return nil
+	}
+	t.Cleanup(func() { newConnectionConnmarkExistsFunc, addNewConnectionConnmarkFunc = origExists, origAdd })
+	saveRecords(t, conf,
+		&state.Record{ContainerID: "wiped", Namespace: "team-a", Pod: "wiped", IPs: []string{"10.0.0.1"}, Fwmark: "0x10"})
+
+	ipt := iptables.NewFakeManager()
+	result, err := Run(context.Background(), ipt, conf, fakeResolver{"team-a/wiped": {Fwmark: "0x10"}}, time.Second)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result.Repaired) != 2 || !newConnectionConnmarks["10.0.0.1"] || connmarks["10.0.0.1"] {
+		t.Errorf("Run() = %+v, CONNMARK rules %v, default CONNMARK rules %v; want new connection rules re-added",
+			result, newConnectionConnmarks, connmarks)
+	}
+}
+
+// TestRunSample verifies a sampled pass verifies the recent and named records plus Size others
+func TestRunSample(t *testing.T) {
+	conf := testConf(t)
+	useFakeConnmark(t, map[string]bool{})
+	now := time.Now()
+	resolver := fakeResolver{}
+	var records []*state.Record
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("pod-%d", i)
+		records = append(records, &state.Record{ContainerID: name, Namespace: "team-a", Pod: name,
+			IPs: []string{fmt.Sprintf("10.0.0.%d", i+1)}, Fwmark: "0x10", Created: now.Add(-time.Hour)})
+		resolver["team-a/"+name] = k8s.RoutingAnnotations{Fwmark: "0x10"}
+	}
+	records[0].Created = now
+	records[9].Namespace = "team-b"
+	resolver["team-b/pod-9"] = k8s.RoutingAnnotations{Fwmark: "0x10"}
+	saveRecords(t, conf, records...)
+	ipt := iptables.NewFakeManager()
+
+	sample := Sample{Size: 2, Since: now.Add(-time.Minute), Pods: map[string]bool{"team-a/pod-5": true},
+		Namespaces: map[string]bool{"team-b": true}}
+	result, err := RunSample(context.Background(), ipt, conf, resolver, time.Second, sample)
+	if err != nil {
+		t.Fatalf("RunSample() error = %v", err)
+	}
+	if result.Records != 10 || result.Checked != 5 || result.Coverage() != 0.5 {
+		t.Errorf("RunSample() = %+v (coverage %v), want 5 of 10 records checked", result, result.Coverage())
+	}
+	for _, podIP := range []string{"10.0.0.1", "10.0.0.6", "10.0.0.10"} {
+		if exists, _ := ipt.RuleExists(context.Background(), podIP, "0x10"); !exists {
+			t.Errorf("MARK rule of selected pod %s not repaired", podIP)
+		}
+	}
+
+	// A full pass covers every record
+	result, err = Run(context.Background(), ipt, conf, resolver, time.Second)
+	if err != nil || result.Checked != 10 || result.Coverage() != 1 || len(result.Repaired) != 2*5 {
+		t.Errorf("Run() = %+v, %v; want MARK and CONNMARK of the 5 unsampled pods repaired", result, err)
+	}
+}
@@ -0,0 +1,249 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/conntrack"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/nodelock"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// Replaced in tests to avoid exec and netlink
+var (
+	deleteConnmarkFunc = iptables.DeleteConnmarkRules
+	deleteOutputFunc   = iptables.DeleteOutputMarkRule
+	removeRouteFunc    = route.RemoveTenantRoute
+)
+
+// relabeling is a recorded pod whose fwmark or gateway annotation changed after ADD
+type relabeling struct {
+	rec             *state.Record
+	fwmark, gateway string
+}
+
+// Relabel applies fwmark and gateway annotations that changed after ADD to the
+// recorded pods of conf.Name. A pod annotated since ADD gets its rules, a pod whose
+// fwmark changed is moved to the new one, and a pod whose annotation was removed
+// loses its rules. Pending, bypassed and terminated pods are left alone, as are pods
+// whose annotations cannot be read. Failures to relabel one pod do not stop the pass; the
+// first one is returned with the partial result, and the pod is retried next pass.
+func Relabel(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration) (*Result, error) {
+	records, err := state.New(conf.StateDir).List(conf.Name)
+	if err != nil {
+		log.Warnf("relabeling readable records only: %v", err)
+	}
+
+	result := &Result{}
+	var pods []relabeling
+	for _, rec := range records {
+		r, considered := changed(ctx, rec, conf, resolver, timeout)
+		switch {
+		case !considered:
+			result.Skipped++
+		case r.rec != nil:
+			pods = append(pods, r)
+		default:
+			result.Checked++
+		}
+	}
+	if len(pods) == 0 {
+		return result, nil
+	}
+
+	lock, err := nodelock.Acquire(conf.LockFile, time.Duration(conf.LockTimeout)*time.Second)
+	if err != nil {
+		return result, fmt.Errorf("relabel pass skipped: %w", err)
+	}
+	defer lock.Release()
+
+	var firstErr error
+	for _, r := range pods {
+		result.Checked++
+		if err := relabelPod(ctx, ipt, conf, r, result); err != nil {
+			log.Warnf("%v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return result, firstErr
+}
+
+// changed returns the relabeling rec needs, with a nil rec if its annotations did not change
+// False if rec was not compared with the annotations of its pod.
+func changed(ctx context.Context, rec *state.Record, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration) (relabeling, bool) {
+	if rec.Pending || rec.PodIP() == "" {
+		return relabeling{}, false
+	}
+	annotations, err := resolver.RoutingAnnotations(ctx, rec.Pod, rec.Namespace, conf.AnnotationKey,
+		conf.GatewayAnnotationKey, timeout)
+	if err != nil {
+		if !errors.Is(err, tenant.ErrNotFound) {
+			log.Warnf("annotations of pod %s/%s not compared: %v", rec.Namespace, rec.Pod, err)
+		}
+		return relabeling{}, false
+	}
+	switch {
+	case rec.PodUID != "" && annotations.PodUID != "" && rec.PodUID != annotations.PodUID:
+		// A newer pod of the same name; the record belongs to a pod GC collects
+		return relabeling{}, false
+	case annotations.Terminated:
+		// Its rules are left to DEL or ReleaseTerminated
+		return relabeling{}, false
+	case annotations.BypassError == nil && annotations.BypassActive(time.Now()):
+		return relabeling{}, false
+	case annotations.Fwmark == rec.Fwmark && (rec.Fwmark == "" || annotations.Gateway == rec.Gateway):
+		return relabeling{}, true
+	}
+	return relabeling{rec: rec, fwmark: annotations.Fwmark, gateway: annotations.Gateway}, true
+}
+
+// String describes the move of the pod's rules
+func (r relabeling) String() string {
+	return fmt.Sprintf("pod %s/%s (IP: %s) from fwmark %q gateway %q to fwmark %q gateway %q",
+		r.rec.Namespace, r.rec.Pod, r.rec.PodIP(), r.rec.Fwmark, r.rec.Gateway, r.fwmark, r.gateway)
+}
+
+// relabelPod moves the rules of one pod to its current annotations
+// The old MARK rule goes first: if it cannot be deleted the record is left unchanged
+// for the next pass. The record is updated before new rules are added, so a DEL
+// running afterwards removes what is installed.
+func relabelPod(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, r relabeling, result *Result) error {
+	old, podIP := *r.rec, r.rec.PodIP()
+	markChanged := old.Fwmark != r.fwmark
+
+	if markChanged && old.Fwmark != "" {
+		if err := ipt.DeleteMarkRule(ctx, podIP, old.Fwmark); err != nil {
+			return fmt.Errorf("failed to delete MARK rule of pod %s/%s (fwmark: %s): %w", old.Namespace, old.Pod, old.Fwmark, err)
+		}
+		if conf.MarkHostTraffic {
+			if err := deleteOutputFunc(ctx, podIP, old.Fwmark); err != nil {
+				log.Warnf("failed to delete OUTPUT mark rule of pod %s/%s: %v", old.Namespace, old.Pod, err)
+			}
+		}
+		if conf.Connmark && r.fwmark == "" {
+			if err := deleteConnmarkFunc(ctx, podIP); err != nil {
+				log.Warnf("failed to delete CONNMARK rules of pod %s/%s: %v", old.Namespace, old.Pod, err)
+			}
+		}
+	}
+
+	err := state.New(conf.StateDir).Update(old.Network, old.ContainerID, old.IfName, func(current *state.Record) error {
+		current.Fwmark, current.Gateway = r.fwmark, r.gateway
+		return nil
+	})
+	if errors.Is(err, state.ErrNotFound) {
+		// DEL ran since the records were listed; it removed the rules of the old fwmark
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to relabel pod %s/%s: %w", old.Namespace, old.Pod, err)
+	}
+
+	// Recorded with the record: a failure below is retried, not reverted
+	err = state.New(conf.StateDir).AppendHistory(old.Network, old.Namespace, old.Pod, state.Assignment{
+		Time: time.Now().UTC(), Cause: state.CauseRelabel, Fwmark: r.fwmark, Gateway: r.gateway,
+		PodUID: old.PodUID, ContainerID: old.ContainerID, IP: podIP,
+	})
+	if err != nil {
+		log.Warnf("failed to record relabel of pod %s/%s in its history: %v", old.Namespace, old.Pod, err)
+	}
+
+	if markChanged && r.fwmark != "" {
+		if err := ipt.AddMarkRule(ctx, podIP, r.fwmark, markOptions(conf, &old, r.fwmark)...); err != nil {
+			return fmt.Errorf("failed to add MARK rule of pod %s/%s (fwmark: %s): %w", old.Namespace, old.Pod, r.fwmark, err)
+		}
+		if conf.Connmark && old.Fwmark == "" {
+			if err := connmarkFuncs(conf).add(ctx, podIP); err != nil {
+				return fmt.Errorf("failed to add CONNMARK rules of pod %s/%s: %w", old.Namespace, old.Pod, err)
+			}
+		}
+		if conf.MarkHostTraffic {
+			if err := addOutputFunc(ctx, podIP, r.fwmark); err != nil {
+				return fmt.Errorf("failed to add OUTPUT mark rule of pod %s/%s: %w", old.Namespace, old.Pod, err)
+			}
+		}
+	}
+	if markChanged {
+		// Established connections keep the mark they were classified with otherwise
+		flushConntrack(conf, podIP)
+		if old.Fwmark != "" {
+			if err := releaseRoute(ctx, ipt, conf, old.Fwmark, old.Gateway); err != nil {
+				log.Warnf("%v", err)
+			}
+		}
+	}
+	if r.fwmark != "" {
+		if err := repairRoute(conf, r.fwmark, r.gateway, result); err != nil {
+			return err
+		}
+	}
+
+	what := r.String()
+	result.Relabeled = append(result.Relabeled, what)
+	log.Infof("relabeled %s", what)
+	return nil
+}
+
+// markOptions returns the options of the MARK rule of rec setting fwmark under conf
+func markOptions(conf *config.PluginConf, rec *state.Record, fwmark string) []iptables.MarkOption {
+	var opts []iptables.MarkOption
+	if conf.AllowUnsafeSources {
+		opts = append(opts, iptables.AllowUnsafeSources())
+	}
+	if conf.PodUIDComments {
+		opts = append(opts, iptables.PodUID(rec.PodUID))
+	}
+	if conf.MarkNewConnectionsOnly {
+		opts = append(opts, iptables.NewConnectionsOnly())
+	}
+	if hook := conf.MarkHook(fwmark); hook != "" {
+		opts = append(opts, iptables.AtHook(hook))
+	}
+	return opts
+}
+
+// releaseRoute removes tenant policy routing of fwmark once no MARK rule sets it
+func releaseRoute(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, fwmark, gateway string) error {
+	tr, ok, err := route.FromConfig(conf, fwmark, gateway)
+	if err != nil || !ok {
+		return err
+	}
+	want, err := route.ParseFwmark(fwmark)
+	if err != nil {
+		return err
+	}
+	rules, err := ipt.List(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot determine remaining pods for fwmark %s, keeping policy routing: %w", fwmark, err)
+	}
+	for _, rule := range rules {
+		if mark, err := route.ParseFwmark(rule.Fwmark); err == nil && mark == want {
+			return nil
+		}
+	}
+	if err := removeRouteFunc(tr); err != nil {
+		return fmt.Errorf("failed to remove policy routing (%s): %w", tr, err)
+	}
+	log.Infof("removed policy routing for last pod of tenant: %s", tr)
+	return nil
+}
+
+// flushConntrack drops conntrack entries of podIP if the option is enabled
+func flushConntrack(conf *config.PluginConf, podIP string) {
+	if !conf.FlushConntrack {
+		return
+	}
+	if _, err := conntrack.FlushPodIP(podIP); err != nil {
+		log.Warnf("%v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestRelabel verifies rules follow annotations added, changed and removed after ADD
+func TestRelabel(t *testing.T) {
+	conf := testConf(t)
+	connmarks := map[string]bool{"10.0.0.2": true, "10.0.0.3": true, "10.0.0.4": true}
+	useFakeConnmark(t, connmarks)
+	saveRecords(t, conf,
+		&state.Record{ContainerID: "added", Namespace: "team-a", Pod: "added", IPs: []string{"10.0.0.1"}},
+		&state.Record{ContainerID: "changed", Namespace: "team-a", Pod: "changed", IPs: []string{"10.0.0.2"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "removed", Namespace: "team-a", Pod: "removed", IPs: []string{"10.0.0.3"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "same", Namespace: "team-a", Pod: "same", IPs: []string{"10.0.0.4"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "bypassed", Namespace: "team-a", Pod: "bypassed", IPs: []string{"10.0.0.5"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "gone", Namespace: "team-a", Pod: "gone", IPs: []string{"10.0.0.6"}, Fwmark: "0x10"},
+	)
+	resolver := fakeResolver{
+		"team-a/added":    {Fwmark: "0x10"},
+		"team-a/changed":  {Fwmark: "0x20"},
+		"team-a/removed":  {},
+		"team-a/same":     {Fwmark: "0x10"},
+		"team-a/bypassed": {BypassUntil: time.Now().Add(time.Hour)},
+	}
+	ipt := iptables.NewFakeManager()
+	for _, podIP := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"} {
+		if err := ipt.AddMarkRule(context.Background(), podIP, "0x10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := Relabel(context.Background(), ipt, conf, resolver, time.Second)
+	if err != nil {
+		t.Fatalf("Relabel() error = %v", err)
+	}
+	if result.Checked != 4 || result.Skipped != 2 || len(result.Relabeled) != 3 {
+		t.Errorf("Relabel() = %+v, want 4 checked, 2 skipped, 3 relabeled", result)
+	}
+
+	want := []iptables.MarkRule{
+		{PodIP: "10.0.0.1", Fwmark: "0x10"},
+		{PodIP: "10.0.0.2", Fwmark: "0x20"},
+		{PodIP: "10.0.0.4", Fwmark: "0x10"},
+		{PodIP: "10.0.0.5", Fwmark: "0x10"},
+		{PodIP: "10.0.0.6", Fwmark: "0x10"},
+	}
+	rules, _ := ipt.List(context.Background())
+	if len(rules) != len(want) {
+		t.Fatalf("MARK rules = %v, want %v", rules, want)
+	}
+	for _, rule := range want {
+		if exists, _ := ipt.RuleExists(context.Background(), rule.PodIP, rule.Fwmark); !exists {
+			t.Errorf("MARK rule %+v missing", rule)
+		}
+	}
+	if !connmarks["10.0.0.1"] || !connmarks["10.0.0.2"] || connmarks["10.0.0.3"] {
+		t.Errorf("CONNMARK rules = %v, want added for 10.0.0.1, kept for 10.0.0.2, removed for 10.0.0.3", connmarks)
+	}
+
+	store := state.New(conf.StateDir)
+	for cid, fwmark := range map[string]string{"added": "0x10", "changed": "0x20", "removed": ""} {
+		rec, err := store.Load(conf.Name, cid, "eth0")
+		if err != nil || rec.Fwmark != fwmark {
+			t.Errorf("record of %s = %+v, %v; want fwmark %q", cid, rec, err, fwmark)
+		}
+	}
+
+	// Nothing changed since
+	if result, err := Relabel(context.Background(), ipt, conf, resolver, time.Second); err != nil || len(result.Relabeled) != 0 {
+		t.Errorf("second Relabel() = %+v, %v; want nothing relabeled", result, err)
+	}
+}
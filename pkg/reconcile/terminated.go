@@ -0,0 +1,136 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/nodelock"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/tenant"
+)
+
+// ReleaseTerminated removes the rules of recorded pods of conf.Name that reached phase
+// Succeeded or Failed at least grace ago. Completed Job pods otherwise keep their rules
+// until the kubelet gets around to DEL. The record stays, without fwmark, so DEL still
+// releases the attachment but finds no rules to remove. A pod whose termination time is
+// unknown is released right away. Failures to release one pod do not stop the pass; the
+// first one is returned with the partial result, and the pod is retried next pass.
+func ReleaseTerminated(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, resolver Resolver,
+	timeout, grace time.Duration) (*Result, error) {
+	records, err := state.New(conf.StateDir).List(conf.Name)
+	if err != nil {
+		log.Warnf("releasing readable records only: %v", err)
+	}
+
+	result := &Result{}
+	var pods []*state.Record
+	now := time.Now()
+	for _, rec := range records {
+		released, considered := terminated(ctx, rec, conf, resolver, timeout, now.Add(-grace))
+		switch {
+		case !considered:
+			result.Skipped++
+		case released:
+			pods = append(pods, rec)
+		default:
+			result.Checked++
+		}
+	}
+	if len(pods) == 0 {
+		return result, nil
+	}
+
+	lock, err := nodelock.Acquire(conf.LockFile, time.Duration(conf.LockTimeout)*time.Second)
+	if err != nil {
+		return result, fmt.Errorf("release of terminated pods skipped: %w", err)
+	}
+	defer lock.Release()
+
+	var firstErr error
+	for _, rec := range pods {
+		result.Checked++
+		if err := releasePod(ctx, ipt, conf, rec, result); err != nil {
+			log.Warnf("%v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return result, firstErr
+}
+
+// terminated reports whether the pod of rec terminated before cutoff, and whether rec
+// was considered at all: records without rules and pods that cannot be read are not.
+func terminated(ctx context.Context, rec *state.Record, conf *config.PluginConf, resolver Resolver,
+	timeout time.Duration, cutoff time.Time) (released, considered bool) {
+	if rec.Pending || rec.Fwmark == "" || rec.PodIP() == "" {
+		return false, false
+	}
+	annotations, err := resolver.RoutingAnnotations(ctx, rec.Pod, rec.Namespace, conf.AnnotationKey,
+		conf.GatewayAnnotationKey, timeout)
+	if err != nil {
+		if !errors.Is(err, tenant.ErrNotFound) {
+			log.Warnf("phase of pod %s/%s not checked: %v", rec.Namespace, rec.Pod, err)
+		}
+		return false, false
+	}
+	if rec.PodUID != "" && annotations.PodUID != "" && rec.PodUID != annotations.PodUID {
+		// A newer pod of the same name; the record belongs to a pod GC collects
+		return false, false
+	}
+	return annotations.Terminated && annotations.TerminatedAt.Before(cutoff), true
+}
+
+// releasePod removes the rules of one terminated pod like DEL would
+// The MARK rule goes first: if it cannot be deleted the record is left unchanged for
+// the next pass.
+func releasePod(ctx context.Context, ipt iptables.Manager, conf *config.PluginConf, rec *state.Record,
+	result *Result) error {
+	podIP := rec.PodIP()
+	if err := ipt.DeleteMarkRule(ctx, podIP, rec.Fwmark); err != nil {
+		return fmt.Errorf("failed to delete MARK rule of terminated pod %s/%s (fwmark: %s): %w",
+			rec.Namespace, rec.Pod, rec.Fwmark, err)
+	}
+	if conf.MarkHostTraffic {
+		if err := deleteOutputFunc(ctx, podIP, rec.Fwmark); err != nil {
+			log.Warnf("failed to delete OUTPUT mark rule of pod %s/%s: %v", rec.Namespace, rec.Pod, err)
+		}
+	}
+	if conf.Connmark {
+		if err := deleteConnmarkFunc(ctx, podIP); err != nil {
+			log.Warnf("failed to delete CONNMARK rules of pod %s/%s: %v", rec.Namespace, rec.Pod, err)
+		}
+	}
+
+	err := state.New(conf.StateDir).Update(rec.Network, rec.ContainerID, rec.IfName, func(current *state.Record) error {
+		current.Fwmark, current.Gateway = "", ""
+		return nil
+	})
+	if errors.Is(err, state.ErrNotFound) {
+		// DEL ran since the records were listed
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release terminated pod %s/%s: %w", rec.Namespace, rec.Pod, err)
+	}
+	err = state.New(conf.StateDir).AppendHistory(rec.Network, rec.Namespace, rec.Pod, state.Assignment{
+		Time: time.Now().UTC(), Cause: state.CauseTerminated, PodUID: rec.PodUID, ContainerID: rec.ContainerID, IP: podIP,
+	})
+	if err != nil {
+		log.Warnf("failed to record release of pod %s/%s in its history: %v", rec.Namespace, rec.Pod, err)
+	}
+
+	flushConntrack(conf, podIP)
+	if err := releaseRoute(ctx, ipt, conf, rec.Fwmark, rec.Gateway); err != nil {
+		log.Warnf("%v", err)
+	}
+
+	what := fmt.Sprintf("pod %s/%s (IP: %s, fwmark: %s)", rec.Namespace, rec.Pod, podIP, rec.Fwmark)
+	result.Released = append(result.Released, what)
+	log.Infof("removed rules of terminated %s", what)
+	return nil
+}
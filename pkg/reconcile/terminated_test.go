@@ -0,0 +1,64 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/state"
+)
+
+// TestReleaseTerminated verifies only pods terminated longer than the grace lose their rules
+func TestReleaseTerminated(t *testing.T) {
+	conf := testConf(t)
+	connmarks := map[string]bool{"10.0.0.1": true, "10.0.0.2": true, "10.0.0.3": true}
+	useFakeConnmark(t, connmarks)
+	saveRecords(t, conf,
+		&state.Record{ContainerID: "done", Namespace: "team-a", Pod: "done", IPs: []string{"10.0.0.1"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "recent", Namespace: "team-a", Pod: "recent", IPs: []string{"10.0.0.2"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "running", Namespace: "team-a", Pod: "running", IPs: []string{"10.0.0.3"}, Fwmark: "0x10"},
+		&state.Record{ContainerID: "unmarked", Namespace: "team-a", Pod: "unmarked", IPs: []string{"10.0.0.4"}},
+		&state.Record{ContainerID: "gone", Namespace: "team-a", Pod: "gone", IPs: []string{"10.0.0.5"}, Fwmark: "0x10"},
+	)
+	now := time.Now()
+	resolver := fakeResolver{
+		"team-a/done":     {Fwmark: "0x10", Terminated: true, TerminatedAt: now.Add(-time.Hour)},
+		"team-a/recent":   {Fwmark: "0x10", Terminated: true, TerminatedAt: now},
+		"team-a/running":  {Fwmark: "0x10"},
+		"team-a/unmarked": {Terminated: true},
+	}
+	ipt := iptables.NewFakeManager()
+	for _, podIP := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.5"} {
+		if err := ipt.AddMarkRule(context.Background(), podIP, "0x10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := ReleaseTerminated(context.Background(), ipt, conf, resolver, time.Second, time.Minute)
+	if err != nil {
+		t.Fatalf("ReleaseTerminated() error = %v", err)
+	}
+	if result.Checked != 3 || result.Skipped != 2 || len(result.Released) != 1 {
+		t.Errorf("ReleaseTerminated() = %+v, want 3 checked, 2 skipped, 1 released", result)
+	}
+	for podIP, want := range map[string]bool{"10.0.0.1": false, "10.0.0.2": true, "10.0.0.3": true, "10.0.0.5": true} {
+		if exists, _ := ipt.RuleExists(context.Background(), podIP, "0x10"); exists != want {
+			t.Errorf("MARK rule of %s exists = %t, want %t", podIP, exists, want)
+		}
+	}
+	if connmarks["10.0.0.1"] || !connmarks["10.0.0.2"] {
+		t.Errorf("CONNMARK rules = %v, want removed for 10.0.0.1 only", connmarks)
+	}
+	if rec, err := state.New(conf.StateDir).Load(conf.Name, "done", "eth0"); err != nil || rec.Fwmark != "" {
+		t.Errorf("record of done = %+v, %v; want kept without fwmark", rec, err)
+	}
+
+	// Run and Relabel leave the released pod alone
+	if result, err := Run(context.Background(), ipt, conf, resolver, time.Second); err != nil || len(result.Repaired) != 0 {
+		t.Errorf("Run() = %+v, %v; want nothing repaired", result, err)
+	}
+	if result, err := Relabel(context.Background(), ipt, conf, resolver, time.Second); err != nil || len(result.Relabeled) != 0 {
+		t.Errorf("Relabel() = %+v, %v; want nothing relabeled", result, err)
+	}
+}
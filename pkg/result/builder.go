@@ -0,0 +1,117 @@
+package result
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/containernetworking/cni/pkg/types"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// Builder derives a CNI Result with added routes or DNS settings from the result of a
+// delegate, e.g. to give the runtime tenant-specific routes
+// The delegate's result is copied and never changed; Result returns the copy in the
+// version the delegate printed, so the runtime gets the version it asked for:
+//
+//	res, err := result.Edit(delegateResult).
+//		WithRoutes(result.Route{Dst: netip.MustParsePrefix("10.96.0.0/12"), GW: gw}).
+//		WithDNS(types.DNS{Search: []string{"tenant-a.svc.cluster.local"}}).
+//		Result()
+type Builder struct {
+	version string
+	result  *types100.Result
+	err     error
+}
+
+// Edit starts a Builder from a copy of result, in any supported version
+func Edit(result types.Result) *Builder {
+	if result == nil {
+		return &Builder{err: fmt.Errorf("CNI result is nil")}
+	}
+	r100, err := toCurrent(result)
+	if err != nil {
+		return &Builder{err: err}
+	}
+	// A result of the current format is the delegate's own: copy it deeply
+	data, err := json.Marshal(r100)
+	if err != nil {
+		return &Builder{err: fmt.Errorf("failed to copy CNI result: %w", err)}
+	}
+	copied := &types100.Result{}
+	if err := json.Unmarshal(data, copied); err != nil {
+		return &Builder{err: fmt.Errorf("failed to copy CNI result: %w", err)}
+	}
+	return &Builder{version: result.Version(), result: copied}
+}
+
+// WithRoutes appends routes after those of the result; a route the result has already
+// (same destination and next hop) is not added again
+func (b *Builder) WithRoutes(routes ...Route) *Builder {
+	if b.err != nil {
+		return b
+	}
+	for _, route := range routes {
+		if !route.Dst.IsValid() {
+			b.err = fmt.Errorf("invalid route destination %v", route.Dst)
+			return b
+		}
+		dst := route.Dst.Masked()
+		added := &types.Route{Dst: net.IPNet{IP: dst.Addr().AsSlice(), Mask: net.CIDRMask(dst.Bits(), dst.Addr().BitLen())}}
+		if route.GW.IsValid() {
+			added.GW = route.GW.AsSlice()
+		}
+		if !slices.ContainsFunc(b.result.Routes, func(r *types.Route) bool { return sameRoute(r, added) }) {
+			b.result.Routes = append(b.result.Routes, added)
+		}
+	}
+	return b
+}
+
+// WithDNS adds dns to the DNS settings of the result: nameservers, search domains and
+// options are appended unless present, the domain is set if the result has none
+func (b *Builder) WithDNS(dns types.DNS) *Builder {
+	if b.err != nil {
+		return b
+	}
+	for _, nameserver := range dns.Nameservers {
+		if net.ParseIP(nameserver) == nil {
+			b.err = fmt.Errorf("invalid DNS nameserver %q", nameserver)
+			return b
+		}
+	}
+	current := &b.result.DNS
+	current.Nameservers = appendMissing(current.Nameservers, dns.Nameservers)
+	current.Search = appendMissing(current.Search, dns.Search)
+	current.Options = appendMissing(current.Options, dns.Options)
+	if current.Domain == "" {
+		current.Domain = dns.Domain
+	}
+	return b
+}
+
+// Result returns the edited result in the version of the result Edit started from
+// Routes and DNS survive the conversion to every version; a 0.2.0 result keeps routes
+// only for the families it has an address of.
+func (b *Builder) Result() (types.Result, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return ConvertResult(b.result, b.version)
+}
+
+// sameRoute reports whether a and b have the same destination and next hop
+func sameRoute(a, b *types.Route) bool {
+	return a.Dst.String() == b.Dst.String() && a.GW.Equal(b.GW)
+}
+
+// appendMissing appends the values of added that values does not have
+func appendMissing(values, added []string) []string {
+	for _, value := range added {
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
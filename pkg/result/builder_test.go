@@ -0,0 +1,75 @@
+package result
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	types040 "github.com/containernetworking/cni/pkg/types/040"
+	"github.com/containernetworking/cni/pkg/types/create"
+)
+
+// TestBuilder verifies routes and DNS are added to a copy, in the version of the
+// original, and entries present already are not duplicated
+func TestBuilder(t *testing.T) {
+	for _, stdout := range []string{
+		`{"cniVersion": "1.1.0", "interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+			"ips": [{"interface": 0, "address": "10.200.10.2/24", "gateway": "10.200.10.1"}],
+			"routes": [{"dst": "0.0.0.0/0"}], "dns": {"nameservers": ["10.96.0.10"]}}`,
+		`{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "10.200.10.2/24", "gateway": "10.200.10.1"}],
+			"routes": [{"dst": "0.0.0.0/0"}], "dns": {"nameservers": ["10.96.0.10"]}}`,
+		`{"cniVersion": "0.3.1", "ips": [{"version": "4", "address": "10.200.10.2/24"}], "routes": [{"dst": "0.0.0.0/0"}],
+			"dns": {"nameservers": ["10.96.0.10"]}}`,
+		`{"cniVersion": "0.2.0", "ip4": {"ip": "10.200.10.2/24", "routes": [{"dst": "0.0.0.0/0"}]},
+			"dns": {"nameservers": ["10.96.0.10"]}}`,
+	} {
+		orig, err := create.CreateFromBytes([]byte(stdout))
+		if err != nil {
+			t.Fatalf("CreateFromBytes(%s) error = %v", stdout, err)
+		}
+		before, _ := orig.GetAsVersion(orig.Version())
+
+		res, err := Edit(orig).
+			WithRoutes(Route{Dst: netip.MustParsePrefix("10.96.0.0/12"), GW: netip.MustParseAddr("10.200.10.254")},
+				Route{Dst: netip.MustParsePrefix("0.0.0.0/0")}).
+			WithDNS(types.DNS{Nameservers: []string{"10.96.0.10", "10.96.0.11"}, Search: []string{"tenant-a.svc"}}).
+			Result()
+		if err != nil {
+			t.Fatalf("%s: Result() error = %v", orig.Version(), err)
+		}
+		if res.Version() != orig.Version() {
+			t.Errorf("Result() version = %s, want %s", res.Version(), orig.Version())
+		}
+
+		routes, err := ExtractRoutes(res)
+		wantRoutes := []Route{
+			{Dst: netip.MustParsePrefix("0.0.0.0/0")},
+			{Dst: netip.MustParsePrefix("10.96.0.0/12"), GW: netip.MustParseAddr("10.200.10.254")},
+		}
+		if err != nil || !reflect.DeepEqual(routes, wantRoutes) {
+			t.Errorf("%s: routes = %v, %v; want %v", orig.Version(), routes, err, wantRoutes)
+		}
+		r100, err := toCurrent(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDNS := types.DNS{Nameservers: []string{"10.96.0.10", "10.96.0.11"}, Search: []string{"tenant-a.svc"}}
+		if !reflect.DeepEqual(r100.DNS, wantDNS) {
+			t.Errorf("%s: DNS = %+v, want %+v", orig.Version(), r100.DNS, wantDNS)
+		}
+		if !reflect.DeepEqual(orig, before) {
+			t.Errorf("%s: original result changed to %+v", orig.Version(), orig)
+		}
+	}
+
+	if _, err := Edit(&types040.Result{CNIVersion: "0.4.0"}).WithDNS(types.DNS{Nameservers: []string{"dns"}}).Result(); err == nil {
+		t.Error("Result() with an invalid nameserver succeeded")
+	}
+	if _, err := Edit(&types040.Result{CNIVersion: "0.4.0"}).WithRoutes(Route{}).Result(); err == nil {
+		t.Error("Result() with an invalid route succeeded")
+	}
+	if _, err := Edit(nil).Result(); err == nil {
+		t.Error("Edit(nil).Result() succeeded")
+	}
+}
@@ -0,0 +1,55 @@
+package result
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/create"
+)
+
+// cacheKindV1 marks the cache file format of libcni 0.8 and later
+const cacheKindV1 = "cniCacheV1"
+
+// cachedInfo is the part of a libcni cache file read here
+type cachedInfo struct {
+	Kind   string          `json:"kind"`
+	Result json.RawMessage `json:"result"`
+}
+
+// LoadCached reads the result libcni cached for an attachment after its ADD
+// The file is <cacheDir>/results/<network>-<containerID>-<ifName>. Files written
+// before libcni 0.8 hold the bare result and are read as well.
+func LoadCached(cacheDir, network, containerID, ifName string) (types.Result, error) {
+	for _, part := range []string{network, containerID, ifName} {
+		if part == "" || strings.ContainsAny(part, `/\`) || part == "." || part == ".." {
+			return nil, fmt.Errorf("invalid cache key %q-%q-%q", network, containerID, ifName)
+		}
+	}
+	path := filepath.Join(cacheDir, "results", fmt.Sprintf("%s-%s-%s", network, containerID, ifName))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached result: %w", err)
+	}
+
+	var info cachedInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse cached result %s: %w", path, err)
+	}
+	raw := data
+	if info.Kind == cacheKindV1 {
+		if len(info.Result) == 0 {
+			return nil, fmt.Errorf("cached result %s holds no result", path)
+		}
+		raw = info.Result
+	}
+
+	res, err := create.CreateFromBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cached result %s: %w", path, err)
+	}
+	return res, nil
+}
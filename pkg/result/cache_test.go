@@ -0,0 +1,75 @@
+package result
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadCached verifies both libcni cache file formats are read
+func TestLoadCached(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantIP  string
+		wantErr bool
+	}{
+		{
+			name: "cniCacheV1",
+			content: `{"kind": "cniCacheV1", "containerId": "abc123", "ifName": "eth0", "networkName": "tenant-routing",
+				"result": {"cniVersion": "1.0.0", "ips": [{"address": "10.200.1.5/24"}]}}`,
+			wantIP: "10.200.1.5",
+		},
+		{
+			name:    "bare result",
+			content: `{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "10.200.1.6/24"}]}`,
+			wantIP:  "10.200.1.6",
+		},
+		{
+			name:    "no result",
+			content: `{"kind": "cniCacheV1", "containerId": "abc123"}`,
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			content: `not json`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "results"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "results", "tenant-routing-abc123-eth0")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := LoadCached(dir, "tenant-routing", "abc123", "eth0")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadCached() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadCached() error = %v", err)
+			}
+			if ip, err := ExtractPodIP(res); err != nil || ip != tt.wantIP {
+				t.Errorf("ExtractPodIP() = %q, %v; want %q", ip, err, tt.wantIP)
+			}
+		})
+	}
+}
+
+// TestLoadCached_InvalidKey verifies cache keys cannot leave the results directory
+func TestLoadCached_InvalidKey(t *testing.T) {
+	for _, containerID := range []string{"", "..", "../../etc/passwd"} {
+		if _, err := LoadCached(t.TempDir(), "tenant-routing", containerID, "eth0"); err == nil {
+			t.Errorf("LoadCached(containerID %q) expected error", containerID)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package result
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// ConvertResult returns result in targetVersion, e.g. the cniVersion the runtime asked
+// for when the delegate answered in another one
+// A result already in targetVersion is returned as it is; an empty targetVersion is
+// 0.1.0, as for the CNI library. A 0.3.x or 0.4.0 result whose addresses lack the
+// "version" field is converted through the current format, which takes the family
+// from the address. Results without an address cannot be converted to 0.2.0 or
+// older, which have no place for interfaces only.
+func ConvertResult(result types.Result, targetVersion string) (types.Result, error) {
+	if result == nil {
+		return nil, fmt.Errorf("CNI result is nil")
+	}
+	if targetVersion == "" {
+		targetVersion = "0.1.0"
+	}
+	if supported := version.All.SupportedVersions(); !slices.Contains(supported, targetVersion) {
+		return nil, fmt.Errorf("cannot convert CNI result to unsupported version %q (supported: %s)", targetVersion,
+			strings.Join(supported, ", "))
+	}
+	if result.Version() == targetVersion {
+		return result, nil
+	}
+	converted, err := result.GetAsVersion(targetVersion)
+	if err != nil {
+		current, currentErr := toCurrent(result)
+		if currentErr == nil && current != result {
+			converted, err = current.GetAsVersion(targetVersion)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert CNI result from %s to %s: %w", result.Version(), targetVersion, err)
+	}
+	return converted, nil
+}
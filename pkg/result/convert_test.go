@@ -0,0 +1,72 @@
+package result
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	types040 "github.com/containernetworking/cni/pkg/types/040"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// TestConvertResult verifies results are printed in the requested version whatever the delegate answered
+func TestConvertResult(t *testing.T) {
+	_, podNet, _ := net.ParseCIDR("10.200.1.5/24")
+	podNet.IP = net.ParseIP("10.200.1.5").To4()
+	index := 0
+	r100 := &types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{{Name: "eth0", Sandbox: "/var/run/netns/pod"}},
+		IPs:        []*types100.IPConfig{{Interface: &index, Address: *podNet}},
+	}
+	// Without the "version" field of its address, as some older delegates print it
+	r040 := &types040.Result{
+		CNIVersion: "0.4.0",
+		Interfaces: []*types040.Interface{{Name: "eth0", Sandbox: "/var/run/netns/pod"}},
+		IPs:        []*types040.IPConfig{{Interface: &index, Address: *podNet}},
+	}
+
+	tests := []struct {
+		name    string
+		result  types.Result
+		target  string
+		want    string
+		wantErr string
+	}{
+		{name: "same version", result: r100, target: "1.0.0", want: "1.0.0"},
+		{name: "newer runtime", result: r040, target: "1.1.0", want: "1.1.0"},
+		{name: "older runtime", result: r100, target: "0.3.1", want: "0.3.1"},
+		{name: "legacy runtime", result: r100, target: "0.2.0", want: "0.2.0"},
+		{name: "empty version", result: r040, target: "", want: "0.1.0"},
+		{name: "unknown version", result: r100, target: "9.9.9", wantErr: `unsupported version "9.9.9"`},
+		{name: "no addresses", result: &types100.Result{CNIVersion: "1.0.0"}, target: "0.2.0", wantErr: "from 1.0.0 to 0.2.0"},
+		{name: "nil", target: "1.0.0", wantErr: "nil"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converted, err := ConvertResult(tt.result, tt.target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ConvertResult() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConvertResult() error = %v", err)
+			}
+			if converted.Version() != tt.want {
+				t.Errorf("ConvertResult() version = %s, want %s", converted.Version(), tt.want)
+			}
+			var out bytes.Buffer
+			if err := converted.PrintTo(&out); err != nil || !strings.Contains(out.String(), "10.200.1.5/24") {
+				t.Errorf("PrintTo() = %s, %v; want the pod address", out.String(), err)
+			}
+			if ip, err := ExtractPodIP(converted); err != nil || ip != "10.200.1.5" {
+				t.Errorf("ExtractPodIP(converted) = %q, %v", ip, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package result
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Drift is what changed between two results of an attachment, e.g. the result libcni
+// cached after ADD and the prevResult of a later CHECK
+// Added entries are in the current result only, removed ones in the previous one only.
+type Drift struct {
+	AddedIPs   []netip.Prefix
+	RemovedIPs []netip.Prefix
+
+	// Interfaces are matched by name, sandbox and MAC: a new MAC is a removed and an
+	// added interface
+	AddedInterfaces   []Interface
+	RemovedInterfaces []Interface
+
+	AddedRoutes   []Route
+	RemovedRoutes []Route
+}
+
+// Empty reports whether the results hold the same addresses, interfaces and routes
+func (d *Drift) Empty() bool {
+	return len(d.AddedIPs) == 0 && len(d.RemovedIPs) == 0 &&
+		len(d.AddedInterfaces) == 0 && len(d.RemovedInterfaces) == 0 &&
+		len(d.AddedRoutes) == 0 && len(d.RemovedRoutes) == 0
+}
+
+// String lists the changes, e.g. "IP 10.200.1.5/24 removed; route 0.0.0.0/0 via 10.200.1.1 added"
+// Empty is "no changes".
+func (d *Drift) String() string {
+	var parts []string
+	for _, ip := range d.RemovedIPs {
+		parts = append(parts, fmt.Sprintf("IP %s removed", ip))
+	}
+	for _, ip := range d.AddedIPs {
+		parts = append(parts, fmt.Sprintf("IP %s added", ip))
+	}
+	for _, iface := range d.RemovedInterfaces {
+		parts = append(parts, fmt.Sprintf("interface %s removed", describeInterface(iface)))
+	}
+	for _, iface := range d.AddedInterfaces {
+		parts = append(parts, fmt.Sprintf("interface %s added", describeInterface(iface)))
+	}
+	for _, r := range d.RemovedRoutes {
+		parts = append(parts, fmt.Sprintf("route %s removed", describeRoute(r)))
+	}
+	for _, r := range d.AddedRoutes {
+		parts = append(parts, fmt.Sprintf("route %s added", describeRoute(r)))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Diff returns the addresses, interfaces and routes added to or removed from prev in
+// current, in the formats ExtractPodIP supports
+// The results may be of different versions; entries keep the order of their result.
+func Diff(prev, current types.Result) (*Drift, error) {
+	if prev == nil || current == nil {
+		return nil, fmt.Errorf("CNI result is nil")
+	}
+	prevIPs, prevInterfaces, prevRoutes, err := contents(prev)
+	if err != nil {
+		return nil, err
+	}
+	curIPs, curInterfaces, curRoutes, err := contents(current)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Drift{}
+	d.AddedIPs, d.RemovedIPs = diffBy(prevIPs, curIPs, netip.Prefix.String)
+	d.AddedInterfaces, d.RemovedInterfaces = diffBy(prevInterfaces, curInterfaces, func(i Interface) string {
+		return i.Name + "\x00" + i.Sandbox + "\x00" + i.MAC.String()
+	})
+	d.AddedRoutes, d.RemovedRoutes = diffBy(prevRoutes, curRoutes, describeRoute)
+	return d, nil
+}
+
+// contents returns the addresses, interfaces and routes of result
+func contents(result types.Result) ([]netip.Prefix, []Interface, []Route, error) {
+	r100, err := toCurrent(result)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var ips []netip.Prefix
+	for _, ipConfig := range r100.IPs {
+		if ipConfig == nil {
+			continue
+		}
+		if address, ok := toPrefix(ipConfig.Address); ok {
+			ips = append(ips, address)
+		}
+	}
+	interfaces, err := ExtractInterfaces(r100)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	routes, err := extractRoutes(r100)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ips, interfaces, routes, nil
+}
+
+// diffBy returns the entries of current missing from prev and those of prev missing
+// from current, entries being equal if their keys are
+func diffBy[T any](prev, current []T, key func(T) string) (added, removed []T) {
+	missing := func(from, in []T) []T {
+		keys := make(map[string]bool, len(in))
+		for _, entry := range in {
+			keys[key(entry)] = true
+		}
+		var out []T
+		for _, entry := range from {
+			if !keys[key(entry)] {
+				out = append(out, entry)
+			}
+		}
+		return out
+	}
+	return missing(current, prev), missing(prev, current)
+}
+
+// describeInterface names i, with its sandbox for a container interface
+func describeInterface(i Interface) string {
+	if i.IsHost() {
+		return i.Name + " (host)"
+	}
+	return fmt.Sprintf("%s (sandbox %s)", i.Name, i.Sandbox)
+}
+
+// describeRoute names r as "<dst> via <gw>", or "<dst>" without a next hop
+func describeRoute(r Route) string {
+	if !r.GW.IsValid() {
+		return r.Dst.String()
+	}
+	return fmt.Sprintf("%s via %s", r.Dst, r.GW)
+}
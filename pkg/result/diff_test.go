@@ -0,0 +1,92 @@
+package result
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types"
+	types040 "github.com/containernetworking/cni/pkg/types/040"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// TestDiff verifies the addresses, interfaces and routes added and removed between two results
+func TestDiff(t *testing.T) {
+	ipNet := func(cidr string) net.IPNet {
+		ip, network, _ := net.ParseCIDR(cidr)
+		network.IP = ip
+		return *network
+	}
+	index := 0
+	defaultRoute := &types.Route{Dst: ipNet("0.0.0.0/0"), GW: net.ParseIP("10.200.1.1")}
+	prev := &types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{{Name: "eth0", Mac: "aa:bb:cc:dd:ee:01", Sandbox: "/var/run/netns/pod"}},
+		IPs:        []*types100.IPConfig{{Interface: &index, Address: ipNet("10.200.1.5/24")}},
+		Routes:     []*types.Route{defaultRoute},
+	}
+
+	tests := []struct {
+		name    string
+		current types.Result
+		want    string
+	}{
+		{name: "unchanged", current: prev, want: "no changes"},
+		{
+			name: "older version, same contents",
+			current: &types040.Result{
+				CNIVersion: "0.4.0",
+				Interfaces: []*types040.Interface{{Name: "eth0", Mac: "aa:bb:cc:dd:ee:01", Sandbox: "/var/run/netns/pod"}},
+				IPs:        []*types040.IPConfig{{Interface: &index, Version: "4", Address: ipNet("10.200.1.5/24")}},
+				Routes:     []*types.Route{defaultRoute},
+			},
+			want: "no changes",
+		},
+		{
+			name: "readdressed",
+			current: &types100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*types100.Interface{{Name: "eth0", Mac: "aa:bb:cc:dd:ee:01", Sandbox: "/var/run/netns/pod"}},
+				IPs:        []*types100.IPConfig{{Interface: &index, Address: ipNet("10.200.1.6/24")}},
+				Routes:     []*types.Route{{Dst: ipNet("10.0.0.0/8")}},
+			},
+			want: "IP 10.200.1.5/24 removed; IP 10.200.1.6/24 added; " +
+				"route 0.0.0.0/0 via 10.200.1.1 removed; route 10.0.0.0/8 added",
+		},
+		{
+			name: "new MAC",
+			current: &types100.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*types100.Interface{{Name: "eth0", Mac: "aa:bb:cc:dd:ee:02", Sandbox: "/var/run/netns/pod"}},
+				IPs:        []*types100.IPConfig{{Interface: &index, Address: ipNet("10.200.1.5/24")}},
+				Routes:     []*types.Route{defaultRoute},
+			},
+			want: "interface eth0 (sandbox /var/run/netns/pod) removed; interface eth0 (sandbox /var/run/netns/pod) added",
+		},
+		{
+			name:    "emptied",
+			current: &types100.Result{CNIVersion: "1.0.0"},
+			want: "IP 10.200.1.5/24 removed; interface eth0 (sandbox /var/run/netns/pod) removed; " +
+				"route 0.0.0.0/0 via 10.200.1.1 removed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Diff(prev, tt.current)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+			if got := d.String(); got != tt.want {
+				t.Errorf("Diff() = %q, want %q", got, tt.want)
+			}
+			if d.Empty() != (tt.want == "no changes") {
+				t.Errorf("Empty() = %v for %q", d.Empty(), tt.want)
+			}
+		})
+	}
+
+	if _, err := Diff(prev, nil); err == nil || !strings.Contains(err.Error(), "nil") {
+		t.Errorf("Diff(prev, nil) error = %v, want nil result error", err)
+	}
+}
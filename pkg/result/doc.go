@@ -12,7 +12,31 @@
 //  4. Wrapper uses this IP for iptables fwmark rules
 //  5. Policy routing directs traffic to tenant-specific gateway
 //
+// ExtractPodIPWith() picks another address instead: the first IPv6 one, one of a
+// given container interface, or one in a preferred network. ExtractGateway() and
+// ExtractRoutes() return the gateway and routes the delegate assigned, for policy routing.
+// ExtractInterfaces() lists the interfaces with their MAC, sandbox and addresses, so the
+// host-side veth of a pod can be found without entering its network namespace.
+//
+// ExtractPodAddr() and Parsed.PodAddr() return the pod IP as a netip.Addr, which keeps
+// its family; ParseAddr(), AddrFromIP() and PrefixFromIPNet() convert the strings and
+// net types of the other functions.
+//
+// Edit() starts a Builder that adds routes (WithRoutes) and DNS settings (WithDNS) to a
+// copy of a result and returns it in the version of the original.
+//
+// ConvertResult() returns a result in another CNI version, e.g. the one the runtime
+// asked for when the delegate answered in a different one.
+//
+// Diff() reports the addresses, interfaces and routes added and removed between two
+// results, e.g. the result cached after ADD and the prevResult of a CHECK.
+//
 // Supported CNI Result versions:
-//  - CNI 1.0.0 (types100.Result)
-//  - CNI 0.4.0 (types040.Result)
+//   - CNI 1.0.0 and 1.1.0 (types100.Result)
+//   - CNI 0.4.0, 0.3.1 and 0.3.0 (types040.Result)
+//   - CNI 0.2.0 and 0.1.0 (types020.Result)
+//
+// Results older than 1.0.0 are converted with the CNI library's version converters.
+// ParseResultBytes() decodes raw result JSON, such as a prevResult inside a conflist
+// chain, of any of these versions into the current format.
 package result
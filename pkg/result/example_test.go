@@ -64,3 +64,37 @@ func ExampleExtractPodIP_mixedAddresses() {
 	fmt.Printf("Pod IPv4: %s\n", podIP)
 	// Output: Pod IPv4: 10.200.2.10
 }
+
+// ExampleExtractPodIPWith demonstrates picking the IPv6 address of a container interface
+func ExampleExtractPodIPWith() {
+	eth0 := 0
+	cniResult := &types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{{Name: "eth0", Sandbox: "/var/run/netns/pod"}},
+		IPs: []*types100.IPConfig{
+			{
+				Interface: &eth0,
+				Address: net.IPNet{
+					IP:   net.ParseIP("10.200.2.10"),
+					Mask: net.CIDRMask(24, 32),
+				},
+			},
+			{
+				Interface: &eth0,
+				Address: net.IPNet{
+					IP:   net.ParseIP("2001:db8::1"),
+					Mask: net.CIDRMask(64, 128),
+				},
+			},
+		},
+	}
+
+	podIP, err := result.ExtractPodIPWith(cniResult, result.Options{Family: result.IPv6, Interface: "eth0"})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Pod IPv6: %s\n", podIP)
+	// Output: Pod IPv6: 2001:db8::1
+}
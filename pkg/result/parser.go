@@ -1,84 +1,398 @@
 package result
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 
 	"github.com/containernetworking/cni/pkg/types"
-	types040 "github.com/containernetworking/cni/pkg/types/040"
 	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/types/create"
 )
 
+// ErrNoIPs is returned (use errors.Is) when the delegate assigned no address at all,
+// e.g. an L2-only macvlan/ipvlan delegate without IPAM
+var ErrNoIPs = errors.New("CNI result contains no IP addresses")
+
+// ErrNoIPv4 is returned (use errors.Is) when the delegate assigned only IPv6 addresses
+var ErrNoIPv4 = errors.New("CNI result contains no IPv4 addresses")
+
+// ErrNoIPv6 is returned (use errors.Is) by ExtractPodIPWith for Family IPv6 when the
+// delegate assigned only IPv4 addresses
+var ErrNoIPv6 = errors.New("CNI result contains no IPv6 addresses")
+
+// ErrNoGateway is returned (use errors.Is) by ExtractGateway when the delegate assigned
+// no IPv4 gateway, neither with an address nor as next hop of a default route
+var ErrNoGateway = errors.New("CNI result contains no IPv4 gateway")
+
+// Family is the address family of the pod IP to extract
+type Family int
+
+const (
+	// IPv4 selects IPv4 addresses (the default)
+	IPv4 Family = iota
+
+	// IPv6 selects IPv6 addresses
+	IPv6
+)
+
+func (f Family) String() string {
+	if f == IPv6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// Options select the pod IP ExtractPodIPWith returns; the zero value selects the
+// first IPv4 address, like ExtractPodIP
+type Options struct {
+	// Family is the address family of the pod IP
+	Family Family
+
+	// Interface restricts the pod IP to addresses of the container interface with this
+	// name, e.g. "eth0" ("" for any); addresses without an interface never match it
+	Interface string
+
+	// Prefer is a network whose addresses are taken before any other of the family
+	// (nil for none); if none is in it, the first address of the family is taken
+	Prefer *net.IPNet
+}
+
 // ExtractPodIP extracts the first IPv4 address from a CNI Result
-// Supports both CNI 0.4.0 and CNI 1.0.0 result formats
+// Supports every CNI result format, 0.1.0 to 1.1.0 (older ones are converted)
 //
 // Parameters:
-//   - result: CNI Result interface (can be types100.Result or types040.Result)
+//   - result: CNI Result interface (types100.Result, types040.Result for 0.3.x/0.4.0
+//     or types020.Result)
 //
 // Returns:
 //   - string: IPv4 address as a plain string (e.g., "10.200.1.5")
 //   - error: Non-nil if result is nil, unsupported type, or contains no IPv4 addresses
+//     (ErrNoIPs if it contains no addresses at all, ErrNoIPv4 if only IPv6 ones)
 //
-// The function skips IPv6 addresses and returns only the first IPv4 address found
+// The function skips IPv6 addresses and addresses of host-side interfaces, and returns
+// only the first IPv4 address found
 func ExtractPodIP(result types.Result) (string, error) {
+	return ExtractPodIPWith(result, Options{})
+}
+
+// ExtractPodAddr is ExtractPodIPWith returning a netip.Addr, which keeps the family
+// of the address (IPv4 addresses are never IPv4-mapped IPv6)
+func ExtractPodAddr(result types.Result, opts Options) (netip.Addr, error) {
 	if result == nil {
-		return "", fmt.Errorf("CNI result is nil")
+		return netip.Addr{}, fmt.Errorf("CNI result is nil")
 	}
+	r100, err := toCurrent(result)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return extractPodAddr(r100, opts)
+}
 
-	// Try types100.Result first (CNI 1.0.0 format)
-	if r100, ok := result.(*types100.Result); ok {
-		return extractIPv4FromResult100(r100)
+// ExtractPodIPWith extracts the pod IP selected by opts from a CNI Result, in the
+// formats ExtractPodIP supports
+// Without addresses at all it returns ErrNoIPs; without one of the family (on the
+// interface, if opts name one) ErrNoIPv4 or ErrNoIPv6.
+func ExtractPodIPWith(result types.Result, opts Options) (string, error) {
+	addr, err := ExtractPodAddr(result, opts)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// Route is a route the delegate assigned to the pod
+type Route struct {
+	// Dst is the destination network, 0.0.0.0/0 or ::/0 for a default route
+	Dst netip.Prefix
+
+	// GW is the next hop; the zero Addr if the route names none, which leaves the
+	// choice to the delegate (usually the gateway of the address)
+	GW netip.Addr
+}
+
+// IsDefault reports whether r is a default route
+func (r Route) IsDefault() bool {
+	return r.Dst.Bits() == 0
+}
+
+// ExtractGateway returns the IPv4 gateway the delegate assigned to the pod, in the
+// formats ExtractPodIP supports
+// It is the gateway of the first IPv4 address of the pod that names one, else the
+// next hop of the IPv4 default route; ErrNoGateway if there is neither.
+func ExtractGateway(result types.Result) (netip.Addr, error) {
+	if result == nil {
+		return netip.Addr{}, fmt.Errorf("CNI result is nil")
+	}
+	r100, err := toCurrent(result)
+	if err != nil {
+		return netip.Addr{}, err
 	}
+	for i, ipConfig := range r100.IPs {
+		if ipConfig == nil {
+			return netip.Addr{}, nilIPError(i)
+		}
+		if ipConfig.Address.IP.To4() == nil || !inContainer(r100, ipConfig) {
+			continue
+		}
+		if gw, ok := toAddr(ipConfig.Gateway); ok && gw.Is4() {
+			return gw, nil
+		}
+	}
+	routes, err := extractRoutes(r100)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, route := range routes {
+		if route.IsDefault() && route.Dst.Addr().Is4() && route.GW.IsValid() {
+			return route.GW, nil
+		}
+	}
+	return netip.Addr{}, ErrNoGateway
+}
 
-	// Fallback to types040.Result (CNI 0.4.0 format)
-	if r040, ok := result.(*types040.Result); ok {
-		return extractIPv4FromResult040(r040)
+// ExtractRoutes returns the routes the delegate assigned to the pod, of both families
+// and in the order of the result, in the formats ExtractPodIP supports
+// A result without routes yields none and no error.
+func ExtractRoutes(result types.Result) ([]Route, error) {
+	if result == nil {
+		return nil, fmt.Errorf("CNI result is nil")
+	}
+	r100, err := toCurrent(result)
+	if err != nil {
+		return nil, err
 	}
+	return extractRoutes(r100)
+}
+
+// Interface is an interface the delegate reported, in the container or on the host
+type Interface struct {
+	// Index is the position of the interface in the result, which addresses refer to
+	Index int
+
+	// Name is the interface name, e.g. "eth0" in the container or the host-side veth
+	Name string
+
+	// MAC is the hardware address (nil if the delegate reported none)
+	MAC net.HardwareAddr
+
+	// Sandbox is the network namespace of a container interface, "" on the host
+	Sandbox string
 
-	// Unsupported result type
-	return "", fmt.Errorf("unsupported CNI result type: %T", result)
+	// Addresses are the addresses of the result assigned to the interface
+	Addresses []netip.Prefix
 }
 
-// extractIPv4FromResult100 extracts IPv4 from CNI 1.0.0 Result
-func extractIPv4FromResult100(result *types100.Result) (string, error) {
-	if len(result.IPs) == 0 {
-		return "", fmt.Errorf("CNI result contains no IP addresses")
+// IsHost reports whether i is on the host side, e.g. the veth peer of a ptp pod or
+// the bridge it is attached to
+func (i Interface) IsHost() bool {
+	return i.Sandbox == ""
+}
+
+// ExtractInterfaces returns the interfaces of a CNI Result in their order, in the
+// formats ExtractPodIP supports
+// Results of 0.2.0 and older have none. A MAC address that does not parse is an error.
+func ExtractInterfaces(result types.Result) ([]Interface, error) {
+	if result == nil {
+		return nil, fmt.Errorf("CNI result is nil")
+	}
+	r100, err := toCurrent(result)
+	if err != nil {
+		return nil, err
 	}
 
-	// Iterate through IPs array, return first IPv4
-	for _, ipConfig := range result.IPs {
-		if ipConfig.Address.IP == nil {
+	interfaces := make([]Interface, 0, len(r100.Interfaces))
+	for i, iface := range r100.Interfaces {
+		if iface == nil {
 			continue
 		}
+		entry := Interface{Index: i, Name: iface.Name, Sandbox: iface.Sandbox}
+		if iface.Mac != "" {
+			if entry.MAC, err = net.ParseMAC(iface.Mac); err != nil {
+				return nil, fmt.Errorf("invalid MAC address of interface %q in CNI result: %w", iface.Name, err)
+			}
+		}
+		for _, ipConfig := range r100.IPs {
+			if ipConfig == nil || ipConfig.Interface == nil || *ipConfig.Interface != i {
+				continue
+			}
+			if address, ok := toPrefix(ipConfig.Address); ok {
+				entry.Addresses = append(entry.Addresses, address)
+			}
+		}
+		interfaces = append(interfaces, entry)
+	}
+	return interfaces, nil
+}
 
-		// Check if IP is IPv4 (IP.To4() returns nil for IPv6)
-		if ipConfig.Address.IP.To4() != nil {
-			return ipConfig.Address.IP.String(), nil
+// toCurrent returns result in the current format
+func toCurrent(result types.Result) (*types100.Result, error) {
+	if r100, ok := result.(*types100.Result); ok {
+		return r100, nil
+	}
+	// CNI 0.4.0 and 0.3.x (types040.Result), 0.2.0 and 0.1.0 (types020.Result):
+	// converted by the CNI library; interface indexes are kept
+	converted, err := types100.NewResultFromResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported CNI result type: %T (version %q): %w", result, result.Version(), err)
+	}
+	return converted, nil
+}
+
+// extractRoutes returns the routes of result
+func extractRoutes(result *types100.Result) ([]Route, error) {
+	var routes []Route
+	for _, route := range result.Routes {
+		if route == nil {
+			continue
+		}
+		dst, ok := toPrefix(route.Dst)
+		if !ok {
+			return nil, fmt.Errorf("invalid route destination %s in CNI result", route.Dst.String())
 		}
+		gw, _ := toAddr(route.GW)
+		routes = append(routes, Route{Dst: dst.Masked(), GW: gw})
+	}
+	return routes, nil
+}
+
+// toPrefix converts network to a netip.Prefix; false if it has no address or a
+// non-canonical mask
+func toPrefix(network net.IPNet) (netip.Prefix, bool) {
+	addr, ok := toAddr(network.IP)
+	ones, bits := network.Mask.Size()
+	if !ok || bits == 0 {
+		return netip.Prefix{}, false
 	}
+	// An IPv4 address may come with a 16-byte mask
+	prefix := netip.PrefixFrom(addr, ones-(bits-addr.BitLen()))
+	return prefix, prefix.IsValid()
+}
+
+// toAddr converts ip, IPv4 in IPv6 form included, to a netip.Addr; false if ip is nil
+func toAddr(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
+
+// Parsed is raw result JSON of any supported version, normalized to the current format
+type Parsed struct {
+	// Version is the cniVersion the result was written in ("0.1.0" if it names none)
+	Version string
 
-	return "", fmt.Errorf("CNI result contains no IPv4 addresses (only IPv6)")
+	// Result holds the interfaces, IPs, routes and DNS of the result in the current
+	// format, with interface indexes kept
+	Result *types100.Result
 }
 
-// extractIPv4FromResult040 extracts IPv4 from CNI 0.4.0 Result
-func extractIPv4FromResult040(result *types040.Result) (string, error) {
+// ParseResultBytes decodes raw result JSON, e.g. the prevResult of a conflist plugin
+// or a delegate's stdout, whatever cniVersion it names
+func ParseResultBytes(data []byte) (*Parsed, error) {
+	cniVersion, err := create.DecodeVersion(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CNI result: %w", err)
+	}
+	res, err := create.Create(cniVersion, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CNI %s result: %w", cniVersion, err)
+	}
+	normalized, err := types100.NewResultFromResult(res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert CNI %s result: %w", cniVersion, err)
+	}
+	return &Parsed{Version: cniVersion, Result: normalized}, nil
+}
+
+// PodIP returns the pod IP of the result selected by opts, like ExtractPodIPWith
+func (p *Parsed) PodIP(opts Options) (string, error) {
+	addr, err := extractPodAddr(p.Result, opts)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// PodAddr returns the pod IP of the result selected by opts, like ExtractPodAddr
+func (p *Parsed) PodAddr(opts Options) (netip.Addr, error) {
+	return extractPodAddr(p.Result, opts)
+}
+
+// extractPodAddr returns the address of result selected by opts
+func extractPodAddr(result *types100.Result, opts Options) (netip.Addr, error) {
 	if len(result.IPs) == 0 {
-		return "", fmt.Errorf("CNI result contains no IP addresses")
+		return netip.Addr{}, ErrNoIPs
 	}
 
-	// Iterate through IPs array, return first IPv4
-	for _, ipConfig := range result.IPs {
-		if ipConfig.Address.IP == nil {
+	var first net.IP
+	otherFamily := false
+	for i, ipConfig := range result.IPs {
+		if ipConfig == nil {
+			return netip.Addr{}, nilIPError(i)
+		}
+		ip := ipConfig.Address.IP
+		if ip == nil || !inContainer(result, ipConfig) || !onInterface(result, ipConfig, opts.Interface) {
 			continue
 		}
-
-		// Check if IP is IPv4
-		if ipConfig.Address.IP.To4() != nil {
-			return ipConfig.Address.IP.String(), nil
+		if (ip.To4() != nil) != (opts.Family == IPv4) {
+			otherFamily = true
+			continue
 		}
+		if opts.Prefer != nil && opts.Prefer.Contains(ip) {
+			first = ip
+			break
+		}
+		if first == nil {
+			first = ip
+		}
+	}
+	if addr, ok := toAddr(first); ok {
+		return addr, nil
 	}
 
-	return "", fmt.Errorf("CNI result contains no IPv4 addresses (only IPv6)")
+	noFamily := ErrNoIPv4
+	if opts.Family == IPv6 {
+		noFamily = ErrNoIPv6
+	}
+	switch {
+	case opts.Interface != "":
+		return netip.Addr{}, fmt.Errorf("%w on interface %q", noFamily, opts.Interface)
+	case otherFamily && opts.Family == IPv6:
+		return netip.Addr{}, fmt.Errorf("%w (only IPv4)", noFamily)
+	case otherFamily:
+		return netip.Addr{}, fmt.Errorf("%w (only IPv6)", noFamily)
+	}
+	return netip.Addr{}, noFamily
+}
+
+// inContainer reports whether ipConfig may be an address of the pod
+// Delegates with several interfaces (e.g. bridge) also report addresses of the host
+// veth or bridge; their interface has no Sandbox. Addresses without an interface
+// index, or with one outside Interfaces, cannot be told apart and are kept.
+func inContainer(result *types100.Result, ipConfig *types100.IPConfig) bool {
+	if ipConfig.Interface == nil || *ipConfig.Interface < 0 || *ipConfig.Interface >= len(result.Interfaces) {
+		return true
+	}
+	iface := result.Interfaces[*ipConfig.Interface]
+	return iface == nil || iface.Sandbox != ""
+}
+
+// onInterface reports whether ipConfig belongs to the interface of result named name
+// (always true for name "")
+func onInterface(result *types100.Result, ipConfig *types100.IPConfig, name string) bool {
+	if name == "" {
+		return true
+	}
+	if ipConfig.Interface == nil || *ipConfig.Interface < 0 || *ipConfig.Interface >= len(result.Interfaces) {
+		return false
+	}
+	iface := result.Interfaces[*ipConfig.Interface]
+	return iface != nil && iface.Name == name
+}
+
+// nilIPError reports the nil entry i of the IPs of a CNI result
+func nilIPError(i int) error {
+	return fmt.Errorf("IP entry %d of CNI result is nil", i)
 }
 
 // IsIPv4 checks if the given IP address is IPv4
@@ -86,3 +400,25 @@ func extractIPv4FromResult040(result *types040.Result) (string, error) {
 func IsIPv4(ip net.IP) bool {
 	return ip != nil && ip.To4() != nil
 }
+
+// ParseAddr parses a pod IP as the string APIs of this package return it, e.g. from
+// ExtractPodIP or a state record, keeping its family
+func ParseAddr(ip string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// AddrFromIP converts a net.IP, IPv4 in IPv6 form included, to a netip.Addr; false if
+// ip is nil or malformed
+func AddrFromIP(ip net.IP) (netip.Addr, bool) {
+	return toAddr(ip)
+}
+
+// PrefixFromIPNet converts a net.IPNet, e.g. a Prefer network or an address of a
+// result, to a netip.Prefix; false if it has no address or a non-canonical mask
+func PrefixFromIPNet(network net.IPNet) (netip.Prefix, bool) {
+	return toPrefix(network)
+}
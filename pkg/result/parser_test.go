@@ -1,13 +1,17 @@
 package result
 
 import (
+	"errors"
 	"net"
+	"net/netip"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/containernetworking/cni/pkg/types"
 	types040 "github.com/containernetworking/cni/pkg/types/040"
 	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/types/create"
 )
 
 // TestExtractPodIP_IPv4Only verifies extraction of IPv4 from CNI 1.0.0 Result
@@ -58,6 +62,9 @@ func TestExtractPodIP_IPv6Only(t *testing.T) {
 	if !strings.Contains(err.Error(), "no IPv4 addresses") {
 		t.Errorf("Expected 'no IPv4 addresses' error, got: %v", err)
 	}
+	if !errors.Is(err, ErrNoIPv4) {
+		t.Errorf("Expected ErrNoIPv4, got: %v", err)
+	}
 }
 
 // TestExtractPodIP_MixedIPv4IPv6 verifies IPv4 is returned from mixed addresses
@@ -140,6 +147,15 @@ func TestExtractPodIP_EmptyIPs(t *testing.T) {
 	if !strings.Contains(err.Error(), "no IP addresses") {
 		t.Errorf("Expected 'no IP addresses' error, got: %v", err)
 	}
+
+	if !errors.Is(err, ErrNoIPs) {
+		t.Errorf("Expected ErrNoIPs, got: %v", err)
+	}
+
+	// CNI 0.4.0 result of an L2-only delegate
+	if _, err := ExtractPodIP(&types040.Result{CNIVersion: "0.4.0"}); !errors.Is(err, ErrNoIPs) {
+		t.Errorf("Expected ErrNoIPs for CNI 0.4.0 result, got: %v", err)
+	}
 }
 
 // TestExtractPodIP_NilResult verifies error when Result is nil
@@ -204,6 +220,9 @@ func TestExtractPodIP_CNI040IPv6Only(t *testing.T) {
 	if !strings.Contains(err.Error(), "no IPv4 addresses") {
 		t.Errorf("Expected 'no IPv4 addresses' error, got: %v", err)
 	}
+	if !errors.Is(err, ErrNoIPv4) {
+		t.Errorf("Expected ErrNoIPv4, got: %v", err)
+	}
 }
 
 // TestExtractPodIP_NilIPInConfig verifies handling of nil IP in IPConfig
@@ -237,6 +256,77 @@ func TestExtractPodIP_NilIPInConfig(t *testing.T) {
 	}
 }
 
+// TestExtractGateway_NilIPEntry verifies a nil entry in IPs is an error, not a panic
+func TestExtractGateway_NilIPEntry(t *testing.T) {
+	result := &types100.Result{CNIVersion: "1.0.0", IPs: []*types100.IPConfig{nil}}
+
+	if _, err := ExtractGateway(result); err == nil {
+		t.Error("ExtractGateway() expected error for a nil IP entry")
+	}
+}
+
+// TestExtractInterfaces_NilIPEntry verifies nil entries in IPs are skipped
+func TestExtractInterfaces_NilIPEntry(t *testing.T) {
+	idx := 0
+	result := &types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{{Name: "eth0", Sandbox: "/var/run/netns/pod"}},
+		IPs: []*types100.IPConfig{
+			nil,
+			{Interface: &idx, Address: net.IPNet{IP: net.ParseIP("10.200.3.15"), Mask: net.CIDRMask(24, 32)}},
+		},
+	}
+
+	ifaces, err := ExtractInterfaces(result)
+	if err != nil || len(ifaces) != 1 || len(ifaces[0].Addresses) != 1 {
+		t.Errorf("ExtractInterfaces() = %+v, %v; want eth0 with one address", ifaces, err)
+	}
+}
+
+// TestExtractPodIP_NilIPEntry verifies a nil entry in IPs is an error, not a panic
+func TestExtractPodIP_NilIPEntry(t *testing.T) {
+	result := &types100.Result{
+		CNIVersion: "1.0.0",
+		IPs: []*types100.IPConfig{
+			nil,
+			{Address: net.IPNet{IP: net.ParseIP("10.200.3.15"), Mask: net.CIDRMask(24, 32)}},
+		},
+	}
+
+	if _, err := ExtractPodIP(result); err == nil {
+		t.Error("ExtractPodIP() expected error for a nil IP entry")
+	}
+}
+
+// TestExtractPodIP_NilInterface verifies an address on a nil interface entry counts as
+// a container address instead of panicking
+func TestExtractPodIP_NilInterface(t *testing.T) {
+	idx := 0
+	result := &types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{nil},
+		IPs: []*types100.IPConfig{
+			{Interface: &idx, Address: net.IPNet{IP: net.ParseIP("10.200.3.15"), Mask: net.CIDRMask(24, 32)}},
+		},
+	}
+
+	if ip, err := ExtractPodIP(result); err != nil || ip != "10.200.3.15" {
+		t.Errorf("ExtractPodIP() = %q, %v; want 10.200.3.15", ip, err)
+	}
+}
+
+// TestOnInterface_NilInterface verifies a nil interface entry matches no name instead
+// of panicking
+func TestOnInterface_NilInterface(t *testing.T) {
+	idx := 0
+	result := &types100.Result{Interfaces: []*types100.Interface{nil}}
+	ipConfig := &types100.IPConfig{Interface: &idx, Address: net.IPNet{IP: net.ParseIP("10.200.3.15"), Mask: net.CIDRMask(24, 32)}}
+
+	if onInterface(result, ipConfig, "eth0") {
+		t.Error("onInterface() matched a nil interface")
+	}
+}
+
 // TestIsIPv4_Valid verifies IsIPv4 helper with valid IPv4
 func TestIsIPv4_Valid(t *testing.T) {
 	ip := net.ParseIP("192.168.1.1")
@@ -260,3 +350,287 @@ func TestIsIPv4_Nil(t *testing.T) {
 		t.Error("Expected IsIPv4 to return false for nil IP")
 	}
 }
+
+// TestExtractPodIPWith verifies the family, interface and preferred network options
+func TestExtractPodIPWith(t *testing.T) {
+	eth0, net1 := 0, 1
+	result := &types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{{Name: "eth0", Sandbox: "/var/run/netns/pod"}, {Name: "net1", Sandbox: "/var/run/netns/pod"}},
+		IPs: []*types100.IPConfig{
+			{Interface: &eth0, Address: net.IPNet{IP: net.ParseIP("10.200.1.5"), Mask: net.CIDRMask(24, 32)}},
+			{Interface: &eth0, Address: net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(64, 128)}},
+			{Interface: &net1, Address: net.IPNet{IP: net.ParseIP("172.16.0.9"), Mask: net.CIDRMask(16, 32)}},
+			{Address: net.IPNet{IP: net.ParseIP("192.168.7.2"), Mask: net.CIDRMask(24, 32)}},
+		},
+	}
+	_, storage, _ := net.ParseCIDR("172.16.0.0/16")
+	_, elsewhere, _ := net.ParseCIDR("100.64.0.0/10")
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{name: "defaults", opts: Options{}, want: "10.200.1.5"},
+		{name: "IPv6", opts: Options{Family: IPv6}, want: "fd00::5"},
+		{name: "interface", opts: Options{Interface: "net1"}, want: "172.16.0.9"},
+		{name: "preferred network", opts: Options{Prefer: storage}, want: "172.16.0.9"},
+		{name: "no address in preferred network", opts: Options{Prefer: elsewhere}, want: "10.200.1.5"},
+		{name: "preferred network on other interface", opts: Options{Interface: "eth0", Prefer: storage}, want: "10.200.1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractPodIPWith(result, tt.opts)
+			if err != nil || got != tt.want {
+				t.Errorf("ExtractPodIPWith(%+v) = %q, %v; want %q", tt.opts, got, err, tt.want)
+			}
+		})
+	}
+
+	// No address of the family on the interface
+	if _, err := ExtractPodIPWith(result, Options{Family: IPv6, Interface: "net1"}); !errors.Is(err, ErrNoIPv6) ||
+		!strings.Contains(err.Error(), `"net1"`) {
+		t.Errorf("ExtractPodIPWith(IPv6 on net1) error = %v, want ErrNoIPv6 naming net1", err)
+	}
+	if _, err := ExtractPodIPWith(result, Options{Interface: "net9"}); !errors.Is(err, ErrNoIPv4) {
+		t.Errorf("ExtractPodIPWith(unknown interface) error = %v, want ErrNoIPv4", err)
+	}
+	v4only := &types040.Result{CNIVersion: "0.4.0", IPs: []*types040.IPConfig{
+		{Address: net.IPNet{IP: net.ParseIP("10.100.5.20"), Mask: net.CIDRMask(24, 32)}},
+	}}
+	if _, err := ExtractPodIPWith(v4only, Options{Family: IPv6}); !errors.Is(err, ErrNoIPv6) ||
+		!strings.Contains(err.Error(), "only IPv4") {
+		t.Errorf("ExtractPodIPWith(IPv6) of an IPv4-only result error = %v, want ErrNoIPv6 (only IPv4)", err)
+	}
+}
+
+// TestExtractPodIP_SkipsHostInterfaces verifies addresses of interfaces outside the
+// container (no Sandbox) are never returned
+func TestExtractPodIP_SkipsHostInterfaces(t *testing.T) {
+	bridge, veth, eth0 := 0, 1, 2
+	result := &types100.Result{
+		CNIVersion: "1.0.0",
+		Interfaces: []*types100.Interface{{Name: "cni0"}, {Name: "veth1a2b3c"}, {Name: "eth0", Sandbox: "/var/run/netns/pod"}},
+		IPs: []*types100.IPConfig{
+			{Interface: &bridge, Address: net.IPNet{IP: net.ParseIP("10.200.1.1"), Mask: net.CIDRMask(24, 32)}},
+			{Interface: &veth, Address: net.IPNet{IP: net.ParseIP("169.254.1.1"), Mask: net.CIDRMask(32, 32)}},
+			{Interface: &eth0, Address: net.IPNet{IP: net.ParseIP("10.200.1.5"), Mask: net.CIDRMask(24, 32)}},
+		},
+	}
+
+	ip, err := ExtractPodIP(result)
+	if err != nil || ip != "10.200.1.5" {
+		t.Errorf("ExtractPodIP() = %q, %v; want the eth0 address 10.200.1.5", ip, err)
+	}
+	if _, err := ExtractPodIPWith(result, Options{Interface: "cni0"}); !errors.Is(err, ErrNoIPv4) {
+		t.Errorf("ExtractPodIPWith(cni0) error = %v, want ErrNoIPv4", err)
+	}
+
+	result.IPs = result.IPs[:2]
+	if _, err := ExtractPodIP(result); !errors.Is(err, ErrNoIPv4) || strings.Contains(err.Error(), "only IPv6") {
+		t.Errorf("ExtractPodIP() of host addresses only error = %v, want plain ErrNoIPv4", err)
+	}
+}
+
+// TestExtractPodIP_OlderFormats verifies 0.3.x and 0.2.0 results, as delegates print
+// them, are converted instead of rejected
+func TestExtractPodIP_OlderFormats(t *testing.T) {
+	for _, stdout := range []string{
+		`{"cniVersion": "0.3.0", "interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+			"ips": [{"version": "4", "interface": 0, "address": "10.200.4.2/24"}]}`,
+		`{"cniVersion": "0.3.1", "interfaces": [{"name": "veth0"}, {"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+			"ips": [{"version": "4", "interface": 0, "address": "10.200.4.1/24"}, {"version": "4", "interface": 1, "address": "10.200.4.2/24"}]}`,
+		`{"cniVersion": "0.2.0", "ip4": {"ip": "10.200.4.2/24"}}`,
+	} {
+		res, err := create.CreateFromBytes([]byte(stdout))
+		if err != nil {
+			t.Fatalf("CreateFromBytes(%s) error = %v", stdout, err)
+		}
+		if ip, err := ExtractPodIP(res); err != nil || ip != "10.200.4.2" {
+			t.Errorf("ExtractPodIP(%s result) = %q, %v; want 10.200.4.2", res.Version(), ip, err)
+		}
+	}
+}
+
+// TestParseResultBytes verifies raw results of every version decode to the current format
+func TestParseResultBytes(t *testing.T) {
+	tests := []struct {
+		name, raw, wantVersion string
+	}{
+		{name: "1.1.0", wantVersion: "1.1.0", raw: `{"cniVersion": "1.1.0",
+			"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+			"ips": [{"interface": 0, "address": "10.200.5.3/24", "gateway": "10.200.5.1"}]}`},
+		{name: "0.4.0", wantVersion: "0.4.0", raw: `{"cniVersion": "0.4.0",
+			"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+			"ips": [{"version": "4", "interface": 0, "address": "10.200.5.3/24"}]}`},
+		{name: "0.3.1", wantVersion: "0.3.1", raw: `{"cniVersion": "0.3.1",
+			"ips": [{"version": "6", "address": "fd00::3/64"}, {"version": "4", "address": "10.200.5.3/24"}]}`},
+		{name: "no cniVersion", wantVersion: "0.1.0", raw: `{"ip4": {"ip": "10.200.5.3/24"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseResultBytes([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("ParseResultBytes() error = %v", err)
+			}
+			if parsed.Version != tt.wantVersion || parsed.Result.CNIVersion != types100.ImplementedSpecVersion {
+				t.Errorf("ParseResultBytes() version %s as %s, want %s as %s", parsed.Version, parsed.Result.CNIVersion,
+					tt.wantVersion, types100.ImplementedSpecVersion)
+			}
+			if ip, err := parsed.PodIP(Options{}); err != nil || ip != "10.200.5.3" {
+				t.Errorf("PodIP() = %q, %v; want 10.200.5.3", ip, err)
+			}
+		})
+	}
+
+	for _, raw := range []string{`not json`, `{"cniVersion": "9.9.9", "ips": []}`} {
+		if _, err := ParseResultBytes([]byte(raw)); err == nil {
+			t.Errorf("ParseResultBytes(%s) succeeded, want an error", raw)
+		}
+	}
+}
+
+// TestExtractGatewayAndRoutes verifies the gateway and routes of results in every format
+func TestExtractGatewayAndRoutes(t *testing.T) {
+	tests := []struct {
+		name, stdout, wantGateway string
+		wantRoutes                []Route
+	}{
+		{name: "1.0.0 address gateway", wantGateway: "10.200.6.1", stdout: `{"cniVersion": "1.0.0",
+			"interfaces": [{"name": "veth0"}, {"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+			"ips": [{"interface": 0, "address": "10.200.6.254/24", "gateway": "10.200.6.254"},
+				{"interface": 1, "address": "10.200.6.2/24", "gateway": "10.200.6.1"}],
+			"routes": [{"dst": "0.0.0.0/0"}, {"dst": "fd00::/8", "gw": "fd00::1"}]}`,
+			wantRoutes: []Route{
+				{Dst: netip.MustParsePrefix("0.0.0.0/0")},
+				{Dst: netip.MustParsePrefix("fd00::/8"), GW: netip.MustParseAddr("fd00::1")},
+			}},
+		{name: "0.4.0 default route", wantGateway: "10.200.6.1", stdout: `{"cniVersion": "0.4.0",
+			"ips": [{"version": "6", "address": "fd00::2/64", "gateway": "fd00::1"}, {"version": "4", "address": "10.200.6.2/24"}],
+			"routes": [{"dst": "10.96.0.0/12", "gw": "10.200.6.254"}, {"dst": "0.0.0.0/0", "gw": "10.200.6.1"}]}`,
+			wantRoutes: []Route{
+				{Dst: netip.MustParsePrefix("10.96.0.0/12"), GW: netip.MustParseAddr("10.200.6.254")},
+				{Dst: netip.MustParsePrefix("0.0.0.0/0"), GW: netip.MustParseAddr("10.200.6.1")},
+			}},
+		{name: "0.2.0", wantGateway: "10.200.6.1", stdout: `{"cniVersion": "0.2.0",
+			"ip4": {"ip": "10.200.6.2/24", "gateway": "10.200.6.1", "routes": [{"dst": "0.0.0.0/0"}]}}`,
+			wantRoutes: []Route{{Dst: netip.MustParsePrefix("0.0.0.0/0")}}},
+		{name: "no gateway", stdout: `{"cniVersion": "1.0.0", "ips": [{"address": "10.200.6.2/24"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := create.CreateFromBytes([]byte(tt.stdout))
+			if err != nil {
+				t.Fatalf("CreateFromBytes() error = %v", err)
+			}
+
+			gw, err := ExtractGateway(res)
+			switch {
+			case tt.wantGateway == "" && !errors.Is(err, ErrNoGateway):
+				t.Errorf("ExtractGateway() = %v, %v; want ErrNoGateway", gw, err)
+			case tt.wantGateway != "" && (err != nil || gw != netip.MustParseAddr(tt.wantGateway)):
+				t.Errorf("ExtractGateway() = %v, %v; want %s", gw, err, tt.wantGateway)
+			}
+
+			routes, err := ExtractRoutes(res)
+			if err != nil || !reflect.DeepEqual(routes, tt.wantRoutes) {
+				t.Errorf("ExtractRoutes() = %v, %v; want %v", routes, err, tt.wantRoutes)
+			}
+		})
+	}
+
+	if !(Route{Dst: netip.MustParsePrefix("::/0")}).IsDefault() || (Route{Dst: netip.MustParsePrefix("10.96.0.0/12")}).IsDefault() {
+		t.Error("IsDefault() does not match default routes only")
+	}
+	if _, err := ExtractGateway(nil); err == nil {
+		t.Error("ExtractGateway(nil) succeeded, want an error")
+	}
+	if _, err := ExtractRoutes(nil); err == nil {
+		t.Error("ExtractRoutes(nil) succeeded, want an error")
+	}
+}
+
+// TestExtractInterfaces verifies the interfaces of a result, host-side ones included,
+// carry their MAC, sandbox and addresses
+func TestExtractInterfaces(t *testing.T) {
+	res, err := create.CreateFromBytes([]byte(`{"cniVersion": "0.4.0",
+		"interfaces": [{"name": "veth1a2b3c", "mac": "aa:bb:cc:00:00:01"},
+			{"name": "eth0", "mac": "aa:bb:cc:00:00:02", "sandbox": "/var/run/netns/pod"}],
+		"ips": [{"version": "4", "interface": 1, "address": "10.200.7.2/24"},
+			{"version": "6", "interface": 1, "address": "fd00::2/64"}, {"version": "4", "address": "10.200.8.2/24"}]}`))
+	if err != nil {
+		t.Fatalf("CreateFromBytes() error = %v", err)
+	}
+	interfaces, err := ExtractInterfaces(res)
+	if err != nil {
+		t.Fatalf("ExtractInterfaces() error = %v", err)
+	}
+	if len(interfaces) != 2 {
+		t.Fatalf("ExtractInterfaces() = %v, want 2 interfaces", interfaces)
+	}
+	host, pod := interfaces[0], interfaces[1]
+	if !host.IsHost() || host.Name != "veth1a2b3c" || host.MAC.String() != "aa:bb:cc:00:00:01" || len(host.Addresses) != 0 {
+		t.Errorf("host interface = %+v", host)
+	}
+	wantAddresses := []netip.Prefix{netip.MustParsePrefix("10.200.7.2/24"), netip.MustParsePrefix("fd00::2/64")}
+	if pod.IsHost() || pod.Index != 1 || pod.Sandbox != "/var/run/netns/pod" || !reflect.DeepEqual(pod.Addresses, wantAddresses) {
+		t.Errorf("pod interface = %+v, want addresses %v", pod, wantAddresses)
+	}
+
+	invalid := &types100.Result{CNIVersion: "1.0.0", Interfaces: []*types100.Interface{{Name: "eth0", Mac: "not-a-mac"}}}
+	if _, err := ExtractInterfaces(invalid); err == nil {
+		t.Error("ExtractInterfaces() with invalid MAC succeeded, want an error")
+	}
+	if interfaces, err := ExtractInterfaces(&types100.Result{CNIVersion: "1.0.0"}); err != nil || len(interfaces) != 0 {
+		t.Errorf("ExtractInterfaces() without interfaces = %v, %v", interfaces, err)
+	}
+}
+
+// TestExtractPodAddr verifies the netip API keeps the family of IPv4 addresses given in
+// IPv6 form and agrees with the string API
+func TestExtractPodAddr(t *testing.T) {
+	res := &types100.Result{CNIVersion: "1.0.0", IPs: []*types100.IPConfig{
+		{Address: net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(64, 128)}},
+		{Address: net.IPNet{IP: net.ParseIP("10.200.9.5"), Mask: net.CIDRMask(24, 32)}},
+	}}
+	addr, err := ExtractPodAddr(res, Options{})
+	if err != nil || addr != netip.MustParseAddr("10.200.9.5") || !addr.Is4() {
+		t.Errorf("ExtractPodAddr() = %v, %v; want IPv4 10.200.9.5", addr, err)
+	}
+	if ip, _ := ExtractPodIP(res); ip != addr.String() {
+		t.Errorf("ExtractPodIP() = %q, ExtractPodAddr() = %v", ip, addr)
+	}
+	if addr, err := ExtractPodAddr(res, Options{Family: IPv6}); err != nil || addr != netip.MustParseAddr("fd00::5") {
+		t.Errorf("ExtractPodAddr(IPv6) = %v, %v", addr, err)
+	}
+	if _, err := ExtractPodAddr(&types100.Result{CNIVersion: "1.0.0"}, Options{}); !errors.Is(err, ErrNoIPs) {
+		t.Errorf("ExtractPodAddr() without IPs error = %v, want ErrNoIPs", err)
+	}
+
+	parsed, err := ParseResultBytes([]byte(`{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "10.200.9.6/24"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, err := parsed.PodAddr(Options{}); err != nil || addr != netip.MustParseAddr("10.200.9.6") {
+		t.Errorf("PodAddr() = %v, %v", addr, err)
+	}
+
+	if addr, err := ParseAddr("::ffff:10.200.9.7"); err != nil || !addr.Is4() {
+		t.Errorf("ParseAddr(IPv4-mapped) = %v, %v; want IPv4", addr, err)
+	}
+	if _, err := ParseAddr("10.200.9"); err == nil {
+		t.Error("ParseAddr(invalid) succeeded")
+	}
+	if addr, ok := AddrFromIP(net.ParseIP("10.200.9.8")); !ok || !addr.Is4() {
+		t.Errorf("AddrFromIP() = %v, %v; want IPv4", addr, ok)
+	}
+	if _, ok := AddrFromIP(nil); ok {
+		t.Error("AddrFromIP(nil) succeeded")
+	}
+	_, network, _ := net.ParseCIDR("10.200.9.0/24")
+	network.IP = network.IP.To16()
+	if prefix, ok := PrefixFromIPNet(*network); !ok || prefix != netip.MustParsePrefix("10.200.9.0/24") {
+		t.Errorf("PrefixFromIPNet() = %v, %v", prefix, ok)
+	}
+}
@@ -0,0 +1,57 @@
+package route
+
+import (
+	"fmt"
+	"net"
+)
+
+// EffectiveRoute is the kernel's routing decision for one pod flow
+type EffectiveRoute struct {
+	// Table is the routing table that produced the route (254 is main)
+	Table int
+
+	// Gateway is the next hop; nil for directly connected destinations
+	Gateway net.IP
+
+	// Interface is the egress interface
+	Interface string
+
+	// Source is the preferred source address the kernel selected (may be nil)
+	Source net.IP
+}
+
+// String returns an ip(8)-like description ("via 10.10.10.131 dev eth1 table 100")
+func (r EffectiveRoute) String() string {
+	s := ""
+	if r.Gateway != nil {
+		s = fmt.Sprintf("via %s ", r.Gateway)
+	}
+	s += fmt.Sprintf("dev %s table %d", r.Interface, r.Table)
+	if r.Source != nil {
+		s += fmt.Sprintf(" src %s", r.Source)
+	}
+	return s
+}
+
+// Lookup asks the kernel where a packet from podIP to dst carrying fwmark is routed
+// (ip route get <dst> from <podIP> iif <pod link> mark <fwmark>)
+//
+// The lookup runs through the policy rules, so it answers authoritatively which
+// tenant table, gateway and interface the pod's traffic takes. Pod traffic is
+// forwarded, so the lookup enters on the host-side interface of the pod; that
+// requires IPv4 forwarding on the node, which a pod network has anyway. A zero
+// fwmark looks up unmarked traffic.
+func Lookup(podIP, dst net.IP, fwmark uint32) (EffectiveRoute, error) {
+	if podIP.To4() == nil {
+		return EffectiveRoute{}, fmt.Errorf("pod IP %q must be an IPv4 address", podIP)
+	}
+	if dst.To4() == nil {
+		return EffectiveRoute{}, fmt.Errorf("destination %q must be an IPv4 address", dst)
+	}
+
+	r, err := dp.routeGet(podIP.To4(), dst.To4(), fwmark)
+	if err != nil {
+		return EffectiveRoute{}, fmt.Errorf("route lookup from %s to %s (fwmark 0x%x) failed: %w", podIP, dst, fwmark, err)
+	}
+	return r, nil
+}
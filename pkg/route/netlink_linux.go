@@ -0,0 +1,304 @@
+//go:build linux
+
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// netlinkDataplane programs rules and routes through rtnetlink (no exec of ip(8))
+type netlinkDataplane struct{}
+
+func newDataplane() dataplane {
+	return netlinkDataplane{}
+}
+
+// defaultDst is 0.0.0.0/0; netlink treats a nil Dst as default but listing returns an explicit prefix
+var defaultDst = &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+
+func (netlinkDataplane) listRules() ([]policyRule, error) {
+	rules, err := netlink.RuleList(netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]policyRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Mark == 0 {
+			continue
+		}
+		rule := policyRule{Mark: r.Mark, Table: r.Table, Priority: r.Priority}
+		if r.Flow > 0 {
+			// FRA_FLOW holds the source realm in the upper 16 bits
+			rule.Realm = r.Flow & 0xffff
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+func (netlinkDataplane) addRule(rule policyRule) error {
+	r := netlink.NewRule()
+	r.Family = netlink.FAMILY_V4
+	r.Mark = rule.Mark
+	r.Table = rule.Table
+	r.Priority = rule.Priority
+	if rule.Realm != 0 {
+		r.Flow = rule.Realm
+	}
+
+	err := netlink.RuleAdd(r)
+	if errors.Is(err, syscall.EEXIST) {
+		return nil
+	}
+	return err
+}
+
+func (netlinkDataplane) delRule(rule policyRule) error {
+	r := netlink.NewRule()
+	r.Family = netlink.FAMILY_V4
+	r.Mark = rule.Mark
+	r.Table = rule.Table
+	r.Priority = rule.Priority
+	if rule.Realm != 0 {
+		r.Flow = rule.Realm
+	}
+
+	err := netlink.RuleDel(r)
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+func (netlinkDataplane) defaultGateway(table int) (net.IP, error) {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range routes {
+		if isDefault(r.Dst) && r.Gw != nil {
+			return r.Gw, nil
+		}
+	}
+	return nil, nil
+}
+
+func (netlinkDataplane) replaceDefaultRoute(table int, gateway net.IP) error {
+	return netlink.RouteReplace(&netlink.Route{
+		Dst:   defaultDst,
+		Gw:    gateway,
+		Table: table,
+	})
+}
+
+func (netlinkDataplane) delDefaultRoute(table int) error {
+	err := netlink.RouteDel(&netlink.Route{Dst: defaultDst, Table: table})
+	if errors.Is(err, syscall.ESRCH) || errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+func (netlinkDataplane) neighborState(gateway net.IP) (NeighborState, error) {
+	routes, err := netlink.RouteGet(gateway)
+	if err != nil {
+		return NeighborUnknown, err
+	}
+	if len(routes) == 0 {
+		return NeighborUnknown, nil
+	}
+
+	neighs, err := netlink.NeighList(routes[0].LinkIndex, netlink.FAMILY_V4)
+	if err != nil {
+		return NeighborUnknown, err
+	}
+	for _, n := range neighs {
+		if !n.IP.Equal(gateway) {
+			continue
+		}
+		switch {
+		case n.State&(netlink.NUD_FAILED|netlink.NUD_INCOMPLETE) != 0:
+			return NeighborFailed, nil
+		case n.State == netlink.NUD_NONE:
+			return NeighborUnknown, nil
+		default:
+			return NeighborReachable, nil
+		}
+	}
+	return NeighborUnknown, nil
+}
+
+func (netlinkDataplane) routeGet(src, dst net.IP, mark uint32) (EffectiveRoute, error) {
+	opts := &netlink.RouteGetOptions{SrcAddr: src, Mark: mark}
+
+	// A forwarded packet enters on the link routing to the pod (the host side of its veth);
+	// a local source has no such link and is looked up as host output
+	srcRoutes, err := netlink.RouteGet(src)
+	if err != nil {
+		return EffectiveRoute{}, fmt.Errorf("no route to source %s: %w", src, err)
+	}
+	if len(srcRoutes) > 0 && srcRoutes[0].Type != syscall.RTN_LOCAL {
+		opts.IifIndex = srcRoutes[0].LinkIndex
+	}
+
+	routes, err := netlink.RouteGetWithOptions(dst, opts)
+	if err != nil {
+		return EffectiveRoute{}, err
+	}
+	if len(routes) == 0 {
+		return EffectiveRoute{}, fmt.Errorf("no route")
+	}
+
+	r := routes[0]
+	result := EffectiveRoute{Table: r.Table, Gateway: r.Gw, Source: r.Src}
+	link, err := netlink.LinkByIndex(r.LinkIndex)
+	if err != nil {
+		return EffectiveRoute{}, fmt.Errorf("failed to resolve interface %d: %w", r.LinkIndex, err)
+	}
+	result.Interface = link.Attrs().Name
+	return result, nil
+}
+
+func (netlinkDataplane) gatewayLink(gateway net.IP) (string, error) {
+	routes, err := netlink.RouteGet(gateway)
+	if err != nil {
+		return "", err
+	}
+	if len(routes) == 0 {
+		return "", fmt.Errorf("no route")
+	}
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve interface %d: %w", routes[0].LinkIndex, err)
+	}
+	return link.Attrs().Name, nil
+}
+
+func (netlinkDataplane) readSysctl(name string) (string, error) {
+	data, err := os.ReadFile(sysctlPath(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return string(data), nil
+}
+
+func (netlinkDataplane) writeSysctl(name, value string) error {
+	if err := os.WriteFile(sysctlPath(name), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("failed to set %s=%s: %w", name, value, err)
+	}
+	return nil
+}
+
+func (netlinkDataplane) addProbeEndpoint(name string, ip net.IP) (func() error, error) {
+	// NewNamed switches the calling thread into the new namespace; switch back before
+	// the thread is released
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to get current netns: %w", err)
+	}
+	ns, err := netns.NewNamed(name)
+	setErr := netns.Set(origin)
+	origin.Close()
+	runtime.UnlockOSThread()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netns %s: %w", name, err)
+	}
+	if setErr != nil {
+		ns.Close()
+		netns.DeleteNamed(name)
+		return nil, fmt.Errorf("failed to return from netns %s: %w", name, setErr)
+	}
+
+	remove := func() error {
+		defer ns.Close()
+		if link, err := netlink.LinkByName(name); err == nil {
+			if err := netlink.LinkDel(link); err != nil {
+				return fmt.Errorf("failed to delete link %s: %w", name, err)
+			}
+		}
+		if err := netns.DeleteNamed(name); err != nil {
+			return fmt.Errorf("failed to delete netns %s: %w", name, err)
+		}
+		return nil
+	}
+	if err := setupProbeEndpoint(name, ns, ip); err != nil {
+		remove()
+		return nil, err
+	}
+	return remove, nil
+}
+
+// setupProbeEndpoint wires a veth between the host and ns the way a pod is wired:
+// ip on the namespace side, a host route to ip over the host side
+func setupProbeEndpoint(name string, ns netns.NsHandle, ip net.IP) error {
+	peerName := name + "p"
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peerName, PeerNamespace: netlink.NsFd(int(ns))}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return fmt.Errorf("failed to create veth %s: %w", name, err)
+	}
+	host, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to find link %s: %w", name, err)
+	}
+	if err := netlink.LinkSetUp(host); err != nil {
+		return fmt.Errorf("failed to set %s up: %w", name, err)
+	}
+	hostIP := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	if err := netlink.RouteAdd(&netlink.Route{LinkIndex: host.Attrs().Index, Dst: hostIP, Scope: netlink.SCOPE_LINK}); err != nil {
+		return fmt.Errorf("failed to route %s via %s: %w", ip, name, err)
+	}
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %s: %w", name, err)
+	}
+	defer h.Close()
+	peer, err := h.LinkByName(peerName)
+	if err != nil {
+		return fmt.Errorf("failed to find link %s in netns %s: %w", peerName, name, err)
+	}
+	if err := h.AddrAdd(peer, &netlink.Addr{IPNet: hostIP}); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w", ip, peerName, err)
+	}
+	for _, link := range []string{peerName, "lo"} {
+		l, err := h.LinkByName(link)
+		if err != nil {
+			return fmt.Errorf("failed to find link %s in netns %s: %w", link, name, err)
+		}
+		if err := h.LinkSetUp(l); err != nil {
+			return fmt.Errorf("failed to set %s up in netns %s: %w", link, name, err)
+		}
+	}
+	if err := h.RouteAdd(&netlink.Route{LinkIndex: peer.Attrs().Index, Dst: defaultDst, Scope: netlink.SCOPE_LINK}); err != nil {
+		return fmt.Errorf("failed to add default route in netns %s: %w", name, err)
+	}
+	return nil
+}
+
+// sysctlPath maps a dotted sysctl name to its /proc/sys file
+func sysctlPath(name string) string {
+	return filepath.Join("/proc/sys", strings.ReplaceAll(name, ".", "/"))
+}
+
+// isDefault reports whether dst is the IPv4 default prefix
+func isDefault(dst *net.IPNet) bool {
+	if dst == nil {
+		return true
+	}
+	ones, _ := dst.Mask.Size()
+	return ones == 0 && dst.IP.Equal(net.IPv4zero)
+}
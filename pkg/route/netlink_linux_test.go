@@ -0,0 +1,245 @@
+//go:build linux
+
+package route
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// withTestNetns runs the test body in a fresh network namespace with a veth uplink
+// 10.99.0.1/24 on "uplink" is the node address; gateways are picked from that subnet.
+// Skipped unless running as root (CAP_NET_ADMIN, CAP_SYS_ADMIN for unshare).
+func withTestNetns(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("netns tests require root")
+	}
+
+	// Namespaces are per thread: pin the goroutine for the whole test
+	runtime.LockOSThread()
+	origNs, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Fatalf("failed to get current netns: %v", err)
+	}
+	testNs, err := netns.New()
+	if err != nil {
+		origNs.Close()
+		runtime.UnlockOSThread()
+		t.Skipf("cannot create netns: %v", err)
+	}
+	t.Cleanup(func() {
+		netns.Set(origNs)
+		testNs.Close()
+		origNs.Close()
+		runtime.UnlockOSThread()
+	})
+
+	link := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "uplink"}, PeerName: "uplink-peer"}
+	if err := netlink.LinkAdd(link); err != nil {
+		t.Skipf("cannot create veth link (kernel without veth support?): %v", err)
+	}
+	addr, _ := netlink.ParseAddr("10.99.0.1/24")
+	if err := netlink.AddrAdd(link, addr); err != nil {
+		t.Fatalf("failed to add address: %v", err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		t.Fatalf("failed to set link up: %v", err)
+	}
+}
+
+// TestNetlinkDataplane_Lifecycle exercises ensure/verify/remove against the kernel
+func TestNetlinkDataplane_Lifecycle(t *testing.T) {
+	withTestNetns(t)
+
+	tr := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.99.0.254")}
+
+	if err := VerifyTenantRoute(tr); err == nil {
+		t.Fatal("expected drift before EnsureTenantRoute")
+	}
+	for i := 0; i < 2; i++ {
+		if err := EnsureTenantRoute(tr); err != nil {
+			t.Fatalf("EnsureTenantRoute() #%d error = %v", i+1, err)
+		}
+	}
+	if err := VerifyTenantRoute(tr); err != nil {
+		t.Fatalf("VerifyTenantRoute() error = %v", err)
+	}
+
+	rules, err := netlink.RuleList(netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("RuleList() error = %v", err)
+	}
+	count := 0
+	for _, r := range rules {
+		if r.Mark == 0x10 && r.Table == 100 && r.Priority == DefaultRulePriority {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("found %d ip rules for fwmark 0x10, want exactly 1", count)
+	}
+
+	// Gateway change replaces the default route in place
+	tr.Gateway = net.ParseIP("10.99.0.253")
+	if err := EnsureTenantRoute(tr); err != nil {
+		t.Fatalf("EnsureTenantRoute() with new gateway error = %v", err)
+	}
+	if err := VerifyTenantRoute(tr); err != nil {
+		t.Fatalf("VerifyTenantRoute() after gateway change error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := RemoveTenantRoute(tr); err != nil {
+			t.Fatalf("RemoveTenantRoute() #%d error = %v", i+1, err)
+		}
+	}
+	if err := VerifyTenantRoute(tr); err == nil {
+		t.Error("expected drift after RemoveTenantRoute")
+	}
+}
+
+// TestNetlinkDataplane_NeighborState verifies gateway neighbor classification
+func TestNetlinkDataplane_NeighborState(t *testing.T) {
+	withTestNetns(t)
+
+	link, err := netlink.LinkByName("uplink")
+	if err != nil {
+		t.Fatalf("LinkByName() error = %v", err)
+	}
+
+	tests := []struct {
+		gateway string
+		state   int // 0: no entry
+		want    NeighborState
+	}{
+		{gateway: "10.99.0.10", want: NeighborUnknown},
+		{gateway: "10.99.0.11", state: netlink.NUD_PERMANENT, want: NeighborReachable},
+		{gateway: "10.99.0.12", state: netlink.NUD_STALE, want: NeighborReachable},
+		{gateway: "10.99.0.13", state: netlink.NUD_FAILED, want: NeighborFailed},
+	}
+
+	// No t.Run: subtests run on other goroutines, outside the pinned netns thread
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.gateway)
+		if tt.state != 0 {
+			neigh := &netlink.Neigh{
+				LinkIndex:    link.Attrs().Index,
+				Family:       netlink.FAMILY_V4,
+				State:        tt.state,
+				IP:           ip,
+				HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, ip.To4()[3]},
+			}
+			if err := netlink.NeighSet(neigh); err != nil {
+				t.Fatalf("NeighSet(%s) error = %v", tt.gateway, err)
+			}
+		}
+
+		got, err := netlinkDataplane{}.neighborState(ip)
+		if err != nil {
+			t.Fatalf("neighborState(%s) error = %v", tt.gateway, err)
+		}
+		if got != tt.want {
+			t.Errorf("neighborState(%s) = %s, want %s", tt.gateway, got, tt.want)
+		}
+	}
+}
+
+// TestNetlinkDataplane_Lookup verifies marked pod traffic resolves through the tenant table
+func TestNetlinkDataplane_Lookup(t *testing.T) {
+	withTestNetns(t)
+
+	// Forwarded lookups need IPv4 forwarding (per netns)
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0o644); err != nil {
+		t.Skipf("cannot enable forwarding: %v", err)
+	}
+
+	// Pod behind the host side of its veth, like ptp sets it up
+	pod := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "pod0"}, PeerName: "pod0-peer"}
+	if err := netlink.LinkAdd(pod); err != nil {
+		t.Fatalf("failed to create pod link: %v", err)
+	}
+	if err := netlink.LinkSetUp(pod); err != nil {
+		t.Fatalf("failed to set pod link up: %v", err)
+	}
+	podIP := net.ParseIP("10.200.1.5")
+	if err := netlink.RouteAdd(&netlink.Route{
+		LinkIndex: pod.Attrs().Index,
+		Dst:       &net.IPNet{IP: podIP, Mask: net.CIDRMask(32, 32)},
+		Scope:     netlink.SCOPE_LINK,
+	}); err != nil {
+		t.Fatalf("failed to add pod route: %v", err)
+	}
+
+	tr := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.99.0.254")}
+	if err := EnsureTenantRoute(tr); err != nil {
+		t.Fatalf("EnsureTenantRoute() error = %v", err)
+	}
+
+	dst := net.ParseIP("192.0.2.10")
+	got, err := Lookup(podIP, dst, 0x10)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got.Table != 100 || !got.Gateway.Equal(tr.Gateway) || got.Interface != "uplink" {
+		t.Errorf("Lookup() = %s, want via %s dev uplink table 100", got, tr.Gateway)
+	}
+
+	// Unmarked traffic has no default route in main
+	if got, err := Lookup(podIP, dst, 0); err == nil {
+		t.Errorf("Lookup() unmarked = %s, want unreachable", got)
+	}
+
+	// Host-originated traffic from a local address is an output lookup
+	got, err = Lookup(net.ParseIP("10.99.0.1"), dst, 0x10)
+	if err != nil {
+		t.Fatalf("Lookup() from local address error = %v", err)
+	}
+	if got.Table != 100 {
+		t.Errorf("Lookup() from local address = %s, want table 100", got)
+	}
+}
+
+// TestNetlinkDataplane_ProbeEndpoint verifies lookups from a probe endpoint take the
+// tenant policy rules and that removal leaves nothing behind
+func TestNetlinkDataplane_ProbeEndpoint(t *testing.T) {
+	withTestNetns(t)
+
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0o644); err != nil {
+		t.Skipf("cannot enable forwarding: %v", err)
+	}
+	tr := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.99.0.254")}
+	if err := EnsureTenantRoute(tr); err != nil {
+		t.Fatalf("EnsureTenantRoute() error = %v", err)
+	}
+
+	probeIP := net.ParseIP("192.0.2.1")
+	remove, err := AddProbeEndpoint("trprobetest", probeIP)
+	if err != nil {
+		t.Skipf("cannot add probe endpoint: %v", err)
+	}
+	got, err := Lookup(probeIP, net.ParseIP("198.51.100.1"), 0x10)
+	if err != nil {
+		remove()
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got.Table != 100 || !got.Gateway.Equal(tr.Gateway) {
+		t.Errorf("Lookup() = %s, want via %s table 100", got, tr.Gateway)
+	}
+
+	if err := remove(); err != nil {
+		t.Fatalf("remove() error = %v", err)
+	}
+	if _, err := netlink.LinkByName("trprobetest"); err == nil {
+		t.Error("probe link still exists after remove")
+	}
+	if _, err := os.Stat("/var/run/netns/trprobetest"); !os.IsNotExist(err) {
+		t.Errorf("probe netns still exists after remove: %v", err)
+	}
+}
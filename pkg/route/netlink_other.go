@@ -0,0 +1,37 @@
+//go:build !linux
+
+package route
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// unsupportedDataplane is used on non-Linux platforms where policy routing is unavailable
+type unsupportedDataplane struct{}
+
+func newDataplane() dataplane {
+	return unsupportedDataplane{}
+}
+
+var errUnsupported = fmt.Errorf("policy routing is not supported on %s", runtime.GOOS)
+
+func (unsupportedDataplane) listRules() ([]policyRule, error)      { return nil, errUnsupported }
+func (unsupportedDataplane) addRule(policyRule) error              { return errUnsupported }
+func (unsupportedDataplane) delRule(policyRule) error              { return errUnsupported }
+func (unsupportedDataplane) defaultGateway(int) (net.IP, error)    { return nil, errUnsupported }
+func (unsupportedDataplane) replaceDefaultRoute(int, net.IP) error { return errUnsupported }
+func (unsupportedDataplane) delDefaultRoute(int) error             { return errUnsupported }
+func (unsupportedDataplane) neighborState(net.IP) (NeighborState, error) {
+	return NeighborUnknown, errUnsupported
+}
+func (unsupportedDataplane) routeGet(net.IP, net.IP, uint32) (EffectiveRoute, error) {
+	return EffectiveRoute{}, errUnsupported
+}
+func (unsupportedDataplane) gatewayLink(net.IP) (string, error) { return "", errUnsupported }
+func (unsupportedDataplane) readSysctl(string) (string, error)  { return "", errUnsupported }
+func (unsupportedDataplane) writeSysctl(string, string) error   { return errUnsupported }
+func (unsupportedDataplane) addProbeEndpoint(string, net.IP) (func() error, error) {
+	return nil, errUnsupported
+}
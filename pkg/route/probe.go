@@ -0,0 +1,30 @@
+package route
+
+import (
+	"fmt"
+	"net"
+)
+
+// maxLinkName is IFNAMSIZ without the terminating NUL
+const maxLinkName = 15
+
+// AddProbeEndpoint creates a throwaway endpoint that is wired to the node like a pod:
+// network namespace name holding ip, a veth whose host side is also called name, and a
+// host route to ip over it. Route lookups from ip then enter on a pod-like interface, so
+// they take the same policy rules as the traffic of a real pod. The returned func removes
+// the namespace, the veth and the route; call it even if the probe failed.
+func AddProbeEndpoint(name string, ip net.IP) (remove func() error, err error) {
+	// the namespace side of the veth is name + "p"
+	if name == "" || len(name)+1 > maxLinkName {
+		return nil, fmt.Errorf("probe endpoint name %q must be 1-%d characters", name, maxLinkName-1)
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("probe endpoint IP %q must be an IPv4 address", ip)
+	}
+
+	remove, err = dp.addProbeEndpoint(name, ip.To4())
+	if err != nil {
+		return nil, fmt.Errorf("failed to add probe endpoint %s (%s): %w", name, ip, err)
+	}
+	return remove, nil
+}
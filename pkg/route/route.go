@@ -0,0 +1,292 @@
+// Package route manages per-tenant policy routing (ip rule / ip route) via netlink.
+//
+// The iptables MARK rule only classifies traffic; policy routing turns the mark into a
+// routing decision. For every tenant this package maintains:
+//
+//	ip rule add fwmark <mark> table <table> priority <priority>
+//	ip route replace default via <gateway> table <table>     (only if a gateway is configured)
+//
+// With the realm strategy (see config.RoutingStrategyRealm) all tenants share one table
+// and its default route instead, and their rules carry a realm:
+//
+//	ip rule add fwmark <mark> table <table> realms <realm> priority <priority>
+//
+// Rules are installed when the first pod of a tenant is added, verified during CHECK,
+// and removed by the caller once the last pod of the tenant has left the node.
+package route
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+)
+
+const (
+	// DefaultRulePriority matches the priority used by scripts/tenant-routing-setup.sh
+	DefaultRulePriority = 50
+
+	// Highest priority usable for custom rules (32766 is "main", 32767 is "default")
+	maxRulePriority = 32765
+
+	// Realms are 16 bit; 0 means none
+	maxRealm = 65535
+)
+
+// reservedTables are kernel-managed routing tables that must never be used for tenants
+var reservedTables = map[int]string{
+	0:   "unspec",
+	253: "default",
+	254: "main",
+	255: "local",
+}
+
+// TenantRoute describes the policy routing state for one tenant
+type TenantRoute struct {
+	// Fwmark selects tenant traffic (set by the iptables MARK rule)
+	Fwmark uint32
+
+	// Table is the routing table consulted for marked traffic
+	Table int
+
+	// Gateway is the tenant egress gateway; nil means the table is managed out-of-band
+	Gateway net.IP
+
+	// Priority of the ip rule; DefaultRulePriority if zero
+	Priority int
+
+	// Strategy is config.RoutingStrategyTable (if empty) or config.RoutingStrategyRealm
+	Strategy string
+
+	// Realm tags the ip rule; set with the realm strategy only
+	Realm int
+}
+
+// policyRule is the subset of an ip rule this package cares about
+type policyRule struct {
+	Mark     uint32
+	Table    int
+	Priority int
+	// Realm is the destination realm of the rule (0 if none)
+	Realm int
+}
+
+// dataplane abstracts the kernel routing API so logic can be unit-tested without root
+type dataplane interface {
+	listRules() ([]policyRule, error)
+	addRule(rule policyRule) error
+	delRule(rule policyRule) error
+	// defaultGateway returns the gateway of the default route in table (nil if no default route)
+	defaultGateway(table int) (net.IP, error)
+	replaceDefaultRoute(table int, gateway net.IP) error
+	delDefaultRoute(table int) error
+	// neighborState returns the neighbor (ARP) state of gateway on the link routing to it
+	neighborState(gateway net.IP) (NeighborState, error)
+	// routeGet resolves the route of a packet from src to dst carrying mark
+	routeGet(src, dst net.IP, mark uint32) (EffectiveRoute, error)
+	// gatewayLink returns the name of the interface routing to gateway
+	gatewayLink(gateway net.IP) (string, error)
+	// readSysctl and writeSysctl access a sysctl by its dotted name (net.ipv4.conf.eth1.rp_filter)
+	readSysctl(name string) (string, error)
+	writeSysctl(name, value string) error
+	// addProbeEndpoint creates netns name wired to the host like a pod with address ip;
+	// the returned func removes it again
+	addProbeEndpoint(name string, ip net.IP) (func() error, error)
+}
+
+// NeighborState summarizes the kernel neighbor entry of a tenant gateway
+type NeighborState int
+
+const (
+	// NeighborUnknown means no entry exists yet (no traffic has been sent to the gateway)
+	NeighborUnknown NeighborState = iota
+	// NeighborReachable covers reachable, stale, delay, probe, permanent and noarp entries
+	NeighborReachable
+	// NeighborFailed means address resolution failed or never completed
+	NeighborFailed
+)
+
+// String returns a lowercase name used in logs
+func (s NeighborState) String() string {
+	switch s {
+	case NeighborReachable:
+		return "reachable"
+	case NeighborFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// dp is the active dataplane implementation (netlink on Linux)
+var dp dataplane = newDataplane()
+
+// ParseFwmark parses a fwmark string such as "0x10" or "16"
+func ParseFwmark(fwmark string) (uint32, error) {
+	value, err := strconv.ParseUint(strings.TrimSpace(fwmark), 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fwmark %q: %w", fwmark, err)
+	}
+	if value == 0 {
+		return 0, fmt.Errorf("invalid fwmark %q: must be non-zero", fwmark)
+	}
+	return uint32(value), nil
+}
+
+// ValidateTable ensures a routing table ID is usable for tenant routing
+func ValidateTable(table int) error {
+	if table < 0 || int64(table) > 0xFFFFFFFF {
+		return fmt.Errorf("routing table %d out of range", table)
+	}
+	if name, ok := reservedTables[table]; ok {
+		return fmt.Errorf("routing table %d is reserved (%s)", table, name)
+	}
+	return nil
+}
+
+// Validate checks that the tenant route can be programmed safely
+func (tr TenantRoute) Validate() error {
+	if tr.Fwmark == 0 {
+		return fmt.Errorf("fwmark must be non-zero")
+	}
+	if err := ValidateTable(tr.Table); err != nil {
+		return err
+	}
+	if tr.Priority < 0 || tr.Priority > maxRulePriority {
+		return fmt.Errorf("rule priority %d out of range (1-%d)", tr.Priority, maxRulePriority)
+	}
+	if tr.Gateway != nil && tr.Gateway.To4() == nil {
+		return fmt.Errorf("gateway %s must be an IPv4 address", tr.Gateway)
+	}
+	switch tr.Strategy {
+	case "", config.RoutingStrategyTable:
+		if tr.Realm != 0 {
+			return fmt.Errorf("realm %d requires strategy %q", tr.Realm, config.RoutingStrategyRealm)
+		}
+	case config.RoutingStrategyRealm:
+		if tr.Realm < 1 || tr.Realm > maxRealm {
+			return fmt.Errorf("realm %d out of range (1-%d)", tr.Realm, maxRealm)
+		}
+	default:
+		return fmt.Errorf("unknown routing strategy %q", tr.Strategy)
+	}
+	return nil
+}
+
+// priority returns the configured rule priority or the default
+func (tr TenantRoute) priority() int {
+	if tr.Priority == 0 {
+		return DefaultRulePriority
+	}
+	return tr.Priority
+}
+
+// String returns an ip(8)-like description used in logs and errors
+func (tr TenantRoute) String() string {
+	s := fmt.Sprintf("fwmark 0x%x table %d", tr.Fwmark, tr.Table)
+	if tr.Realm != 0 {
+		s += fmt.Sprintf(" realms %d", tr.Realm)
+	}
+	s += fmt.Sprintf(" priority %d", tr.priority())
+	if tr.Gateway != nil {
+		s += fmt.Sprintf(" via %s", tr.Gateway)
+	}
+	return s
+}
+
+// FromConfig builds the policy routing entry for fwmark from plugin configuration
+// A non-empty gateway (e.g. from the tenant.routing/gateway annotation) overrides the configured one,
+// except with the realm strategy: the shared table has a single default route for all tenants.
+// Returns false if plugin-managed routing is disabled or no table is configured for fwmark
+func FromConfig(conf *config.PluginConf, fwmark, gateway string) (TenantRoute, bool, error) {
+	if _, ok := conf.RouteTable(fwmark); !ok {
+		return TenantRoute{}, false, nil
+	}
+	mark, err := ParseFwmark(fwmark)
+	if err != nil {
+		return TenantRoute{}, false, err
+	}
+	var gw net.IP
+	if gateway != "" {
+		gw = net.ParseIP(gateway)
+	}
+	tr, ok := FromMark(conf, mark, gw)
+	return tr, ok, nil
+}
+
+// FromMark is FromConfig for a parsed fwmark and gateway (nil for the configured one),
+// as carried by k8s.Tenant
+func FromMark(conf *config.PluginConf, mark uint32, gateway net.IP) (TenantRoute, bool) {
+	table, ok := conf.RouteTableOf(mark)
+	if !ok {
+		return TenantRoute{}, false
+	}
+
+	tr := TenantRoute{
+		Fwmark:   mark,
+		Table:    table.Table,
+		Priority: conf.Routing.RulePriority,
+		Strategy: conf.Routing.Strategy,
+		Realm:    table.Realm,
+	}
+	if gateway == nil || tr.Strategy == config.RoutingStrategyRealm {
+		gateway = nil
+		if table.Gateway != "" {
+			gateway = net.ParseIP(table.Gateway)
+		}
+	}
+	tr.Gateway = gateway
+
+	return tr, true
+}
+
+// EnsureTenantRoute installs the policy rule and default route for a tenant
+// Idempotent: existing rules are left untouched, the default route is replaced
+func EnsureTenantRoute(tr TenantRoute) error {
+	if err := tr.Validate(); err != nil {
+		return err
+	}
+	return tr.strategy().ensure(tr)
+}
+
+// RemoveTenantRoute removes the policy rule and, if a gateway is managed, the default route
+// With the realm strategy the shared default route stays while other tenants use the table.
+// Idempotent: succeeds if nothing is installed
+func RemoveTenantRoute(tr TenantRoute) error {
+	if err := tr.Validate(); err != nil {
+		return err
+	}
+	return tr.strategy().remove(tr)
+}
+
+// VerifyTenantRoute checks that the policy rule and default route are in place
+// Returns an error describing the drift if the state does not match
+func VerifyTenantRoute(tr TenantRoute) error {
+	if err := tr.Validate(); err != nil {
+		return err
+	}
+	return tr.strategy().verify(tr)
+}
+
+// CheckGateway reports whether the tenant gateway answers address resolution
+// A missing neighbor entry is not an error: the kernel only resolves on demand.
+// Returns nil for tenant routes without a managed gateway.
+func CheckGateway(tr TenantRoute) error {
+	if tr.Gateway == nil {
+		return nil
+	}
+	if err := tr.Validate(); err != nil {
+		return err
+	}
+
+	state, err := dp.neighborState(tr.Gateway)
+	if err != nil {
+		return fmt.Errorf("failed to read neighbor state of gateway %s: %w", tr.Gateway, err)
+	}
+	if state == NeighborFailed {
+		return fmt.Errorf("gateway %s for table %d is unreachable (neighbor resolution failed)", tr.Gateway, tr.Table)
+	}
+	return nil
+}
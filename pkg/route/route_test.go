@@ -0,0 +1,403 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+)
+
+// fakeDataplane records rules and routes in memory
+type fakeDataplane struct {
+	rules     []policyRule
+	routes    map[int]net.IP
+	neighbors map[string]NeighborState
+	lookups   map[uint32]EffectiveRoute // by fwmark
+	links     map[string]string         // gateway -> interface
+	sysctls   map[string]string
+	probes    map[string]net.IP // probe endpoint name -> IP
+}
+
+func newFakeDataplane() *fakeDataplane {
+	return &fakeDataplane{routes: map[int]net.IP{}, neighbors: map[string]NeighborState{}, lookups: map[uint32]EffectiveRoute{},
+		links: map[string]string{}, sysctls: map[string]string{}, probes: map[string]net.IP{}}
+}
+
+func (f *fakeDataplane) listRules() ([]policyRule, error) {
+	return append([]policyRule(nil), f.rules...), nil
+}
+
+func (f *fakeDataplane) addRule(rule policyRule) error {
+	f.rules = append(f.rules, rule)
+	return nil
+}
+
+func (f *fakeDataplane) delRule(rule policyRule) error {
+	for i, r := range f.rules {
+		if r == rule {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *fakeDataplane) defaultGateway(table int) (net.IP, error) {
+	return f.routes[table], nil
+}
+
+func (f *fakeDataplane) replaceDefaultRoute(table int, gateway net.IP) error {
+	f.routes[table] = gateway
+	return nil
+}
+
+func (f *fakeDataplane) delDefaultRoute(table int) error {
+	delete(f.routes, table)
+	return nil
+}
+
+func (f *fakeDataplane) neighborState(gateway net.IP) (NeighborState, error) {
+	return f.neighbors[gateway.String()], nil
+}
+
+func (f *fakeDataplane) routeGet(_, _ net.IP, mark uint32) (EffectiveRoute, error) {
+	r, ok := f.lookups[mark]
+	if !ok {
+		return EffectiveRoute{}, fmt.Errorf("network is unreachable")
+	}
+	return r, nil
+}
+
+func (f *fakeDataplane) gatewayLink(gateway net.IP) (string, error) {
+	dev, ok := f.links[gateway.String()]
+	if !ok {
+		return "", fmt.Errorf("network is unreachable")
+	}
+	return dev, nil
+}
+
+func (f *fakeDataplane) readSysctl(name string) (string, error) {
+	value, ok := f.sysctls[name]
+	if !ok {
+		return "", fmt.Errorf("no such sysctl %s", name)
+	}
+	return value, nil
+}
+
+func (f *fakeDataplane) writeSysctl(name, value string) error {
+	f.sysctls[name] = value
+	return nil
+}
+
+func (f *fakeDataplane) addProbeEndpoint(name string, ip net.IP) (func() error, error) {
+	if _, ok := f.probes[name]; ok {
+		return nil, fmt.Errorf("file exists")
+	}
+	f.probes[name] = ip
+	return func() error {
+		delete(f.probes, name)
+		return nil
+	}, nil
+}
+
+// useFakeDataplane swaps the package dataplane for the duration of a test
+func useFakeDataplane(t *testing.T) *fakeDataplane {
+	t.Helper()
+	fake := newFakeDataplane()
+	orig := dp
+	dp = fake
+	t.Cleanup(func() { dp = orig })
+	return fake
+}
+
+// TestParseFwmark tests fwmark parsing in hex and decimal
+func TestParseFwmark(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint32
+		wantErr bool
+	}{
+		{input: "0x10", want: 0x10},
+		{input: "0X20", want: 0x20},
+		{input: "16", want: 16},
+		{input: " 0x10 ", want: 0x10},
+		{input: "0x0", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "tenant-a", wantErr: true},
+		{input: "0x100000000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseFwmark(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFwmark(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFwmark(%q) = %#x, want %#x", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTenantRoute_Validate tests validation of reserved tables, priorities and gateways
+func TestTenantRoute_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		tr     TenantRoute
+		errMsg string
+	}{
+		{name: "valid", tr: TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.10.10.131")}},
+		{name: "valid without gateway", tr: TenantRoute{Fwmark: 0x20, Table: 200}},
+		{name: "zero fwmark", tr: TenantRoute{Table: 100}, errMsg: "fwmark must be non-zero"},
+		{name: "main table", tr: TenantRoute{Fwmark: 0x10, Table: 254}, errMsg: "reserved (main)"},
+		{name: "local table", tr: TenantRoute{Fwmark: 0x10, Table: 255}, errMsg: "reserved (local)"},
+		{name: "unspec table", tr: TenantRoute{Fwmark: 0x10, Table: 0}, errMsg: "reserved (unspec)"},
+		{name: "priority too high", tr: TenantRoute{Fwmark: 0x10, Table: 100, Priority: 32766}, errMsg: "priority"},
+		{name: "ipv6 gateway", tr: TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("fd00::1")}, errMsg: "must be an IPv4"},
+		{name: "realm without strategy", tr: TenantRoute{Fwmark: 0x10, Table: 100, Realm: 1}, errMsg: "requires strategy"},
+		{name: "realm strategy without realm", tr: TenantRoute{Fwmark: 0x10, Table: 100, Strategy: config.RoutingStrategyRealm}, errMsg: "realm 0 out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tr.Validate()
+			if tt.errMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Validate() error = %v, want to contain %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+// TestEnsureTenantRoute_Idempotent verifies repeated Ensure calls install a single rule
+func TestEnsureTenantRoute_Idempotent(t *testing.T) {
+	fake := useFakeDataplane(t)
+	tr := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.10.10.131")}
+
+	for i := 0; i < 3; i++ {
+		if err := EnsureTenantRoute(tr); err != nil {
+			t.Fatalf("EnsureTenantRoute() error: %v", err)
+		}
+	}
+
+	if len(fake.rules) != 1 {
+		t.Fatalf("rules = %d, want 1", len(fake.rules))
+	}
+	if fake.rules[0].Priority != DefaultRulePriority {
+		t.Errorf("priority = %d, want %d", fake.rules[0].Priority, DefaultRulePriority)
+	}
+	if !fake.routes[100].Equal(tr.Gateway) {
+		t.Errorf("default route = %v, want %v", fake.routes[100], tr.Gateway)
+	}
+}
+
+// TestVerifyTenantRoute_Drift verifies missing rules and wrong gateways are reported
+func TestVerifyTenantRoute_Drift(t *testing.T) {
+	fake := useFakeDataplane(t)
+	tr := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.10.10.131")}
+
+	if err := VerifyTenantRoute(tr); err == nil || !strings.Contains(err.Error(), "ip rule fwmark 0x10 table 100 missing") {
+		t.Errorf("expected missing rule error, got: %v", err)
+	}
+
+	if err := EnsureTenantRoute(tr); err != nil {
+		t.Fatalf("EnsureTenantRoute() error: %v", err)
+	}
+	if err := VerifyTenantRoute(tr); err != nil {
+		t.Errorf("VerifyTenantRoute() after Ensure: %v", err)
+	}
+
+	fake.routes[100] = net.ParseIP("10.10.10.184")
+	if err := VerifyTenantRoute(tr); err == nil || !strings.Contains(err.Error(), "expected 10.10.10.131") {
+		t.Errorf("expected wrong gateway error, got: %v", err)
+	}
+
+	delete(fake.routes, 100)
+	if err := VerifyTenantRoute(tr); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected missing route error, got: %v", err)
+	}
+}
+
+// TestRemoveTenantRoute verifies rules and managed routes are removed, leaving other tenants intact
+func TestRemoveTenantRoute(t *testing.T) {
+	fake := useFakeDataplane(t)
+	trA := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.10.10.131")}
+	trB := TenantRoute{Fwmark: 0x20, Table: 200}
+	fake.routes[200] = net.ParseIP("10.10.10.184")
+
+	for _, tr := range []TenantRoute{trA, trB} {
+		if err := EnsureTenantRoute(tr); err != nil {
+			t.Fatalf("EnsureTenantRoute(%s) error: %v", tr, err)
+		}
+	}
+
+	if err := RemoveTenantRoute(trA); err != nil {
+		t.Fatalf("RemoveTenantRoute() error: %v", err)
+	}
+	// Second removal must be a no-op
+	if err := RemoveTenantRoute(trA); err != nil {
+		t.Fatalf("RemoveTenantRoute() second call error: %v", err)
+	}
+
+	if len(fake.rules) != 1 || fake.rules[0].Mark != 0x20 {
+		t.Errorf("remaining rules = %+v, want only tenant B", fake.rules)
+	}
+	if _, ok := fake.routes[100]; ok {
+		t.Error("tenant A default route not removed")
+	}
+	// Tenant B has no managed gateway: its out-of-band route must survive
+	if _, ok := fake.routes[200]; !ok {
+		t.Error("out-of-band route for tenant B was removed")
+	}
+}
+
+// TestRealmStrategy verifies realm tenants share one table and its default route
+func TestRealmStrategy(t *testing.T) {
+	fake := useFakeDataplane(t)
+	gateway := net.ParseIP("10.10.10.131")
+	trA := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: gateway, Strategy: config.RoutingStrategyRealm, Realm: 1}
+	trB := TenantRoute{Fwmark: 0x20, Table: 100, Gateway: gateway, Strategy: config.RoutingStrategyRealm, Realm: 2}
+
+	for _, tr := range []TenantRoute{trA, trB, trA} {
+		if err := EnsureTenantRoute(tr); err != nil {
+			t.Fatalf("EnsureTenantRoute(%s) error: %v", tr, err)
+		}
+	}
+	if len(fake.rules) != 2 || fake.rules[0].Realm != 1 || fake.rules[1].Realm != 2 {
+		t.Fatalf("rules = %+v, want one per tenant with its realm", fake.rules)
+	}
+	if err := VerifyTenantRoute(trB); err != nil {
+		t.Errorf("VerifyTenantRoute() after Ensure: %v", err)
+	}
+
+	// A changed realm is drift, and Ensure replaces the rule
+	trB.Realm = 3
+	if err := VerifyTenantRoute(trB); err == nil || !strings.Contains(err.Error(), "realms 3 missing") {
+		t.Errorf("expected missing realm rule error, got: %v", err)
+	}
+	if err := EnsureTenantRoute(trB); err != nil {
+		t.Fatalf("EnsureTenantRoute() error: %v", err)
+	}
+	if len(fake.rules) != 2 || fake.rules[1].Realm != 3 {
+		t.Errorf("rules = %+v, want the realm of tenant B replaced", fake.rules)
+	}
+
+	// The shared default route stays until the last tenant leaves
+	if err := RemoveTenantRoute(trA); err != nil {
+		t.Fatalf("RemoveTenantRoute() error: %v", err)
+	}
+	if !fake.routes[100].Equal(gateway) {
+		t.Error("shared default route removed while tenant B uses the table")
+	}
+	if err := RemoveTenantRoute(trB); err != nil {
+		t.Fatalf("RemoveTenantRoute() error: %v", err)
+	}
+	if len(fake.rules) != 0 {
+		t.Errorf("remaining rules = %+v, want none", fake.rules)
+	}
+	if _, ok := fake.routes[100]; ok {
+		t.Error("shared default route not removed with the last tenant")
+	}
+}
+
+// TestCheckGateway verifies only failed neighbor resolution is reported
+func TestCheckGateway(t *testing.T) {
+	fake := useFakeDataplane(t)
+	tr := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("192.168.1.1")}
+
+	tests := []struct {
+		name    string
+		state   NeighborState
+		wantErr bool
+	}{
+		{name: "no entry yet", state: NeighborUnknown},
+		{name: "reachable", state: NeighborReachable},
+		{name: "failed", state: NeighborFailed, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.neighbors["192.168.1.1"] = tt.state
+			err := CheckGateway(tr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckGateway() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := CheckGateway(TenantRoute{Fwmark: 0x10, Table: 100}); err != nil {
+		t.Errorf("CheckGateway() without gateway error = %v", err)
+	}
+}
+
+// TestLookup verifies address validation and that the fwmark selects the route
+func TestLookup(t *testing.T) {
+	fake := useFakeDataplane(t)
+	fake.lookups[0] = EffectiveRoute{Table: 254, Gateway: net.ParseIP("10.99.0.1"), Interface: "eth0"}
+	fake.lookups[0x10] = EffectiveRoute{Table: 100, Gateway: net.ParseIP("10.10.10.131"), Interface: "eth1"}
+
+	podIP := net.ParseIP("10.200.1.5")
+	dst := net.ParseIP("8.8.8.8")
+
+	got, err := Lookup(podIP, dst, 0x10)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got.String() != "via 10.10.10.131 dev eth1 table 100" {
+		t.Errorf("Lookup() = %q", got)
+	}
+
+	got, err = Lookup(podIP, dst, 0)
+	if err != nil || got.Table != 254 {
+		t.Errorf("Lookup() unmarked = %v, %v; want main table", got, err)
+	}
+
+	if _, err := Lookup(podIP, dst, 0x20); err == nil || !strings.Contains(err.Error(), "fwmark 0x20") {
+		t.Errorf("Lookup() unreachable error = %v", err)
+	}
+	if _, err := Lookup(net.ParseIP("fd00::1"), dst, 0x10); err == nil {
+		t.Error("Lookup() expected error for IPv6 pod IP")
+	}
+	if _, err := Lookup(podIP, nil, 0x10); err == nil {
+		t.Error("Lookup() expected error for missing destination")
+	}
+}
+
+// TestAddProbeEndpoint verifies name and address validation and removal
+func TestAddProbeEndpoint(t *testing.T) {
+	fake := useFakeDataplane(t)
+
+	remove, err := AddProbeEndpoint("trprobe10", net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatalf("AddProbeEndpoint() error = %v", err)
+	}
+	if !fake.probes["trprobe10"].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("probes = %v, want trprobe10 with 192.0.2.1", fake.probes)
+	}
+	if _, err := AddProbeEndpoint("trprobe10", net.ParseIP("192.0.2.2")); err == nil || !strings.Contains(err.Error(), "trprobe10") {
+		t.Errorf("AddProbeEndpoint() duplicate error = %v", err)
+	}
+	if err := remove(); err != nil {
+		t.Fatalf("remove() error = %v", err)
+	}
+	if len(fake.probes) != 0 {
+		t.Errorf("probes after remove = %v, want none", fake.probes)
+	}
+
+	for _, name := range []string{"", "trprobe12345678"} {
+		if _, err := AddProbeEndpoint(name, net.ParseIP("192.0.2.1")); err == nil {
+			t.Errorf("AddProbeEndpoint(%q) expected error", name)
+		}
+	}
+	if _, err := AddProbeEndpoint("trprobe10", net.ParseIP("fd00::1")); err == nil {
+		t.Error("AddProbeEndpoint() expected error for IPv6 address")
+	}
+}
@@ -0,0 +1,92 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reverse-path filtering and policy routing
+//
+// Strict rp_filter (1) checks the source of every packet against the route back to it,
+// looked up without a fwmark. ICMP errors (fragmentation needed, PMTUD) from routers on
+// the tenant path arrive on the tenant interface, but the unmarked lookup routes their
+// source through the main table and another interface, so the kernel drops them and
+// path MTU discovery for the tenant fails. RelaxRPFilter makes the tenant interface:
+//
+//	net.ipv4.conf.<dev>.rp_filter=2        (loose; only if it was strict)
+//	net.ipv4.conf.<dev>.src_valid_mark=1   (the reverse lookup uses the restored mark)
+const (
+	rpFilterStrict = "1"
+	rpFilterLoose  = "2"
+)
+
+// rpFilterKeys returns the sysctl names checked for dev
+func rpFilterKeys(dev string) (rpFilter, srcValidMark string) {
+	return "net.ipv4.conf." + dev + ".rp_filter", "net.ipv4.conf." + dev + ".src_valid_mark"
+}
+
+// RelaxRPFilter makes reverse-path filtering on the interface routing to the tenant
+// gateway accept the tenant's return traffic, see the comment on rpFilterStrict
+// Returns the interface name. A no-op for tenant routes without a managed gateway.
+// Idempotent; the sysctls are left in place when the tenant leaves the node.
+func RelaxRPFilter(tr TenantRoute) (string, error) {
+	if tr.Gateway == nil {
+		return "", nil
+	}
+	if err := tr.Validate(); err != nil {
+		return "", err
+	}
+
+	dev, err := dp.gatewayLink(tr.Gateway)
+	if err != nil {
+		return "", fmt.Errorf("failed to find interface routing to gateway %s: %w", tr.Gateway, err)
+	}
+	rpFilter, srcValidMark := rpFilterKeys(dev)
+
+	value, err := dp.readSysctl(rpFilter)
+	if err != nil {
+		return dev, err
+	}
+	// 0 (off) and 2 (loose) already accept the return path
+	if strings.TrimSpace(value) == rpFilterStrict {
+		if err := dp.writeSysctl(rpFilter, rpFilterLoose); err != nil {
+			return dev, err
+		}
+	}
+	if err := dp.writeSysctl(srcValidMark, "1"); err != nil {
+		return dev, err
+	}
+	return dev, nil
+}
+
+// VerifyRPFilter reports whether RelaxRPFilter's settings are still in place (CHECK)
+func VerifyRPFilter(tr TenantRoute) error {
+	if tr.Gateway == nil {
+		return nil
+	}
+	if err := tr.Validate(); err != nil {
+		return err
+	}
+
+	dev, err := dp.gatewayLink(tr.Gateway)
+	if err != nil {
+		return fmt.Errorf("failed to find interface routing to gateway %s: %w", tr.Gateway, err)
+	}
+	rpFilter, srcValidMark := rpFilterKeys(dev)
+
+	value, err := dp.readSysctl(rpFilter)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(value) == rpFilterStrict {
+		return fmt.Errorf("%s is strict (1), tenant ICMP replies will be dropped", rpFilter)
+	}
+	value, err = dp.readSysctl(srcValidMark)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(value) != "1" {
+		return fmt.Errorf("%s is %s, expected 1", srcValidMark, strings.TrimSpace(value))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package route
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestRelaxRPFilter verifies strict rp_filter becomes loose, other values are kept,
+// and CHECK reports a reverted setting
+func TestRelaxRPFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		rpFilter     string
+		wantRPFilter string
+	}{
+		{name: "strict becomes loose", rpFilter: "1\n", wantRPFilter: "2"},
+		{name: "loose kept", rpFilter: "2\n", wantRPFilter: "2\n"},
+		{name: "off kept", rpFilter: "0\n", wantRPFilter: "0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeDataplane(t)
+			fake.links["10.10.10.131"] = "eth1"
+			fake.sysctls["net.ipv4.conf.eth1.rp_filter"] = tt.rpFilter
+			fake.sysctls["net.ipv4.conf.eth1.src_valid_mark"] = "0\n"
+			tr := TenantRoute{Fwmark: 0x10, Table: 100, Gateway: net.ParseIP("10.10.10.131")}
+
+			if err := VerifyRPFilter(tr); err == nil {
+				t.Error("VerifyRPFilter() before RelaxRPFilter expected error")
+			}
+			dev, err := RelaxRPFilter(tr)
+			if err != nil || dev != "eth1" {
+				t.Fatalf("RelaxRPFilter() = %q, %v; want eth1", dev, err)
+			}
+			if got := fake.sysctls["net.ipv4.conf.eth1.rp_filter"]; got != tt.wantRPFilter {
+				t.Errorf("rp_filter = %q, want %q", got, tt.wantRPFilter)
+			}
+			if got := fake.sysctls["net.ipv4.conf.eth1.src_valid_mark"]; got != "1" {
+				t.Errorf("src_valid_mark = %q, want 1", got)
+			}
+			if err := VerifyRPFilter(tr); err != nil {
+				t.Errorf("VerifyRPFilter() after RelaxRPFilter: %v", err)
+			}
+
+			fake.sysctls["net.ipv4.conf.eth1.rp_filter"] = "1\n"
+			if err := VerifyRPFilter(tr); err == nil || !strings.Contains(err.Error(), "strict") {
+				t.Errorf("VerifyRPFilter() with strict rp_filter = %v, want strict error", err)
+			}
+		})
+	}
+}
+
+// TestRelaxRPFilter_NoGateway verifies out-of-band tables are left alone
+func TestRelaxRPFilter_NoGateway(t *testing.T) {
+	useFakeDataplane(t)
+	tr := TenantRoute{Fwmark: 0x10, Table: 100}
+	if dev, err := RelaxRPFilter(tr); err != nil || dev != "" {
+		t.Errorf("RelaxRPFilter() = %q, %v; want no-op", dev, err)
+	}
+	if err := VerifyRPFilter(tr); err != nil {
+		t.Errorf("VerifyRPFilter() error = %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package route
+
+import (
+	"fmt"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+)
+
+// strategy programs the policy routing of one tenant (see config.RoutingConf.Strategy)
+// Callers validate the TenantRoute first.
+type strategy interface {
+	ensure(tr TenantRoute) error
+	remove(tr TenantRoute) error
+	verify(tr TenantRoute) error
+}
+
+// strategy returns the implementation selected by tr.Strategy
+func (tr TenantRoute) strategy() strategy {
+	if tr.Strategy == config.RoutingStrategyRealm {
+		return realmStrategy{}
+	}
+	return tableStrategy{}
+}
+
+// tableStrategy gives every tenant its own table holding the tenant's default route
+type tableStrategy struct{}
+
+func (tableStrategy) ensure(tr TenantRoute) error {
+	rules, err := dp.listRules()
+	if err != nil {
+		return fmt.Errorf("failed to list ip rules: %w", err)
+	}
+
+	if len(matchingRules(rules, tr)) == 0 {
+		if err := addTenantRule(tr); err != nil {
+			return err
+		}
+	}
+	return replaceDefaultRoute(tr)
+}
+
+func (tableStrategy) remove(tr TenantRoute) error {
+	rules, err := dp.listRules()
+	if err != nil {
+		return fmt.Errorf("failed to list ip rules: %w", err)
+	}
+	if err := delRules(matchingRules(rules, tr), tr); err != nil {
+		return err
+	}
+
+	// Only remove the route if we manage it; out-of-band tables are left alone
+	return delDefaultRoute(tr)
+}
+
+func (tableStrategy) verify(tr TenantRoute) error {
+	rules, err := dp.listRules()
+	if err != nil {
+		return fmt.Errorf("failed to list ip rules: %w", err)
+	}
+	if len(matchingRules(rules, tr)) == 0 {
+		return fmt.Errorf("ip rule fwmark 0x%x table %d missing", tr.Fwmark, tr.Table)
+	}
+	return verifyDefaultRoute(tr)
+}
+
+// realmStrategy routes all tenants through one shared table with one default route
+// Tenants are told apart by the realm of their rule, which route classifiers and
+// realm accounting (rtacct) see instead of a table ID.
+type realmStrategy struct{}
+
+func (realmStrategy) ensure(tr TenantRoute) error {
+	rules, err := dp.listRules()
+	if err != nil {
+		return fmt.Errorf("failed to list ip rules: %w", err)
+	}
+
+	// A rule with a stale realm (the realm of the tenant was changed) is replaced
+	found := false
+	var stale []policyRule
+	for _, rule := range matchingRules(rules, tr) {
+		if rule.Realm == tr.Realm {
+			found = true
+		} else {
+			stale = append(stale, rule)
+		}
+	}
+	if err := delRules(stale, tr); err != nil {
+		return err
+	}
+	if !found {
+		if err := addTenantRule(tr); err != nil {
+			return err
+		}
+	}
+	return replaceDefaultRoute(tr)
+}
+
+func (realmStrategy) remove(tr TenantRoute) error {
+	rules, err := dp.listRules()
+	if err != nil {
+		return fmt.Errorf("failed to list ip rules: %w", err)
+	}
+	if err := delRules(matchingRules(rules, tr), tr); err != nil {
+		return err
+	}
+
+	// The default route is shared: it goes with the last tenant rule using the table
+	for _, rule := range rules {
+		if rule.Table == tr.Table && rule.Mark != tr.Fwmark {
+			return nil
+		}
+	}
+	return delDefaultRoute(tr)
+}
+
+func (realmStrategy) verify(tr TenantRoute) error {
+	rules, err := dp.listRules()
+	if err != nil {
+		return fmt.Errorf("failed to list ip rules: %w", err)
+	}
+	found := false
+	for _, rule := range matchingRules(rules, tr) {
+		if rule.Realm == tr.Realm {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("ip rule fwmark 0x%x table %d realms %d missing", tr.Fwmark, tr.Table, tr.Realm)
+	}
+	return verifyDefaultRoute(tr)
+}
+
+// addTenantRule adds the rule routing the tenant's fwmark to its table
+func addTenantRule(tr TenantRoute) error {
+	rule := policyRule{Mark: tr.Fwmark, Table: tr.Table, Priority: tr.priority(), Realm: tr.Realm}
+	if err := dp.addRule(rule); err != nil {
+		return fmt.Errorf("failed to add ip rule fwmark 0x%x table %d: %w", tr.Fwmark, tr.Table, err)
+	}
+	return nil
+}
+
+// delRules deletes rules of the tenant
+func delRules(rules []policyRule, tr TenantRoute) error {
+	for _, rule := range rules {
+		if err := dp.delRule(rule); err != nil {
+			return fmt.Errorf("failed to delete ip rule fwmark 0x%x table %d: %w", tr.Fwmark, tr.Table, err)
+		}
+	}
+	return nil
+}
+
+// replaceDefaultRoute points the default route of the tenant table to its gateway, if managed
+func replaceDefaultRoute(tr TenantRoute) error {
+	if tr.Gateway == nil {
+		return nil
+	}
+	if err := dp.replaceDefaultRoute(tr.Table, tr.Gateway); err != nil {
+		return fmt.Errorf("failed to set default route via %s in table %d: %w", tr.Gateway, tr.Table, err)
+	}
+	return nil
+}
+
+// delDefaultRoute removes the default route of the tenant table, if managed
+func delDefaultRoute(tr TenantRoute) error {
+	if tr.Gateway == nil {
+		return nil
+	}
+	if err := dp.delDefaultRoute(tr.Table); err != nil {
+		return fmt.Errorf("failed to delete default route in table %d: %w", tr.Table, err)
+	}
+	return nil
+}
+
+// verifyDefaultRoute checks the default route of the tenant table, if managed
+func verifyDefaultRoute(tr TenantRoute) error {
+	if tr.Gateway == nil {
+		return nil
+	}
+	gw, err := dp.defaultGateway(tr.Table)
+	if err != nil {
+		return fmt.Errorf("failed to read default route in table %d: %w", tr.Table, err)
+	}
+	if gw == nil {
+		return fmt.Errorf("default route via %s in table %d missing", tr.Gateway, tr.Table)
+	}
+	if !gw.Equal(tr.Gateway) {
+		return fmt.Errorf("default route in table %d points to %s, expected %s", tr.Table, gw, tr.Gateway)
+	}
+	return nil
+}
+
+// matchingRules returns the rules routing the tenant's fwmark to its table
+func matchingRules(rules []policyRule, tr TenantRoute) []policyRule {
+	var matches []policyRule
+	for _, rule := range rules {
+		if rule.Mark == tr.Fwmark && rule.Table == tr.Table {
+			matches = append(matches, rule)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package safepath
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// checkOwner only checks the mode where file ownership is not available
+func checkOwner(path string, info fs.FileInfo) error {
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is writable by group or others (mode %v)", path, info.Mode().Perm())
+	}
+	return nil
+}
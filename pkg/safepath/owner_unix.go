@@ -0,0 +1,21 @@
+//go:build unix
+
+package safepath
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// checkOwner fails if the file at path, described by info, is not owned by root or is
+// writable by group or others
+func checkOwner(path string, info fs.FileInfo) error {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 {
+		return fmt.Errorf("%s is owned by uid %d, not root", path, st.Uid)
+	}
+	if info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is writable by group or others (mode %v)", path, info.Mode().Perm())
+	}
+	return nil
+}
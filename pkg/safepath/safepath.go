@@ -0,0 +1,153 @@
+// Package safepath checks that files the plugin trusts can only have been put in
+// place by root.
+//
+// The wrapper runs as root and reads credentials (the kubeconfig) and state it acts on.
+// On a node shared by tenants, a symlink or a writable directory on the way to such a
+// file would let an unprivileged user redirect the read to a file of their own. Check
+// resolves the path component by component and accepts it only if it stays below a
+// trusted root and neither the file nor any directory a component or symlink target
+// is looked up in can be written by anyone but root:
+//
+//	resolved, err := safepath.Check("/etc/kubernetes/kubelet.conf", []string{"/etc", "/var/lib"})
+//	if errors.Is(err, safepath.ErrUnsafe) {
+//		// refuse to use the file
+//	}
+package safepath
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafe is matched (errors.Is) by Check errors for paths that resolve outside the
+// trusted roots or that a user other than root could have written
+var ErrUnsafe = errors.New("unsafe path")
+
+// maxHops bounds the symlinks Check follows, like the kernel's limit for path lookups
+const maxHops = 40
+
+// Check resolves path one component at a time, following symlinks, and verifies the
+// result is below one of roots, that the file is owned by root and not writable by
+// group or others, and that so is every directory a component of path or of a symlink
+// target is looked up in
+// The roots and the directories above them are trusted as configured. A path that does
+// not exist yet (a state directory before the first ADD) is checked up to its deepest
+// existing parent. Returns the resolved path.
+func Check(path string, roots []string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s: %w", path, err)
+	}
+	trusted := trustedDirs(roots)
+	check := func(name string, info fs.FileInfo) error {
+		if trusted[name] {
+			return nil
+		}
+		if info == nil {
+			var err error
+			if info, err = os.Lstat(name); err != nil {
+				return fmt.Errorf("cannot check %s: %w", name, err)
+			}
+		}
+		if err := checkOwner(name, info); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrUnsafe, path, err)
+		}
+		return nil
+	}
+
+	resolved, pending, hops := "/", components(abs), 0
+	var info fs.FileInfo
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if name == ".." {
+			resolved, info = filepath.Dir(resolved), nil
+			continue
+		}
+		// Whoever can write the directory a name is looked up in decides what it names
+		if err := check(resolved, nil); err != nil {
+			return "", err
+		}
+		next := filepath.Join(resolved, name)
+		info, err = os.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			resolved, info = filepath.Join(append([]string{next}, pending...)...), nil
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve %s: %w", path, err)
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxHops {
+			return "", fmt.Errorf("cannot resolve %s: too many symlinks", path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve %s: %w", path, err)
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		pending, info = append(components(target), pending...), nil
+	}
+
+	if _, ok := below(resolved, roots); !ok {
+		return "", fmt.Errorf("%w: %s resolves to %s, outside %s", ErrUnsafe, path, resolved, strings.Join(roots, ", "))
+	}
+	if info != nil {
+		if err := check(resolved, info); err != nil {
+			return "", err
+		}
+	}
+	return resolved, nil
+}
+
+// components splits path into its names, dropping empty ones and "."
+func components(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name != "" && name != "." {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// trustedDirs returns the roots, as configured and resolved, and the directories above
+// them, which Check does not verify
+func trustedDirs(roots []string) map[string]bool {
+	trusted := map[string]bool{}
+	add := func(dir string) {
+		for dir = filepath.Clean(dir); !trusted[dir]; dir = filepath.Dir(dir) {
+			trusted[dir] = true
+		}
+	}
+	for _, root := range roots {
+		add(root)
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			add(resolved)
+		}
+	}
+	return trusted
+}
+
+// below returns the root of roots that path is below (or equal to), after resolving
+// the symlinks of the roots themselves, e.g. /var/run to /run
+func below(path string, roots []string) (string, bool) {
+	for _, root := range roots {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return root, true
+		}
+	}
+	return "", false
+}
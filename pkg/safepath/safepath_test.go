@@ -0,0 +1,89 @@
+package safepath
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCheck verifies symlinks within the root resolve and those leaving it, foreign
+// owners and writable files or directories, including those holding a symlink, are refused
+func TestCheck(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("ownership checks need files owned by root")
+	}
+	root, outside := t.TempDir(), t.TempDir()
+	roots := []string{root}
+	write := func(path string, mode os.FileMode) string {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("apiVersion: v1\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	link := func(target, path string) string {
+		t.Helper()
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	kubeconfig := write(filepath.Join(root, "kubernetes", "kubelet.conf"), 0o600)
+	if resolved, err := Check(kubeconfig, roots); err != nil || resolved != kubeconfig {
+		t.Errorf("Check(regular file) = %q, %v", resolved, err)
+	}
+	inside := link(kubeconfig, filepath.Join(root, "kubeconfig"))
+	if resolved, err := Check(inside, roots); err != nil || resolved != kubeconfig {
+		t.Errorf("Check(symlink within the root) = %q, %v; want %s", resolved, err, kubeconfig)
+	}
+	stateDir := filepath.Join(root, "tenant-routing", "state")
+	if resolved, err := Check(stateDir, roots); err != nil || resolved != stateDir {
+		t.Errorf("Check(missing directory) = %q, %v", resolved, err)
+	}
+
+	foreign := write(filepath.Join(root, "foreign.conf"), 0o600)
+	if err := os.Chown(foreign, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	writableDir := filepath.Join(root, "shared")
+	write(filepath.Join(writableDir, "kubelet.conf"), 0o600)
+	if err := os.Chmod(writableDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	// A root-owned target reached through a directory a user can write: the user could
+	// swap the symlink for one to a file of their own
+	userDir := filepath.Join(root, "x", "userdir")
+	if err := os.MkdirAll(userDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(userDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(userDir, 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	userLink := link(kubeconfig, filepath.Join(userDir, "link"))
+	chained := link(userLink, filepath.Join(root, "chained.conf"))
+
+	for name, path := range map[string]string{
+		"symlink in a user's directory":   userLink,
+		"symlink chain through it":        chained,
+		"symlink leaving the root":        link(write(filepath.Join(outside, "kubelet.conf"), 0o600), filepath.Join(root, "escape.conf")),
+		"group-writable file":             write(filepath.Join(root, "writable.conf"), 0o664),
+		"file of another user":            foreign,
+		"in a writable directory":         filepath.Join(writableDir, "kubelet.conf"),
+		"missing in a writable directory": filepath.Join(writableDir, "state"),
+	} {
+		if _, err := Check(path, roots); !errors.Is(err, ErrUnsafe) {
+			t.Errorf("Check(%s) error = %v, want ErrUnsafe", name, err)
+		}
+	}
+}
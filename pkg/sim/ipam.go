@@ -0,0 +1,89 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/delegate"
+)
+
+// defaultSubnet is used when the delegate config carries no host-local style subnet
+const defaultSubnet = "10.244.0.0/24"
+
+// allocator hands out pod IPs from the delegate IPAM subnet, like host-local would
+type allocator struct {
+	subnet *net.IPNet
+	inUse  map[string]bool
+}
+
+// newAllocator reads ipam.subnet (or the first ipam.ranges entry) from the delegate config
+// For a delegate list the first plugin with a subnet wins
+func newAllocator(delegateConfig json.RawMessage) (*allocator, error) {
+	plugins, err := delegate.Plugins(delegateConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var subnet string
+	for _, plugin := range plugins {
+		var conf struct {
+			IPAM struct {
+				Subnet string `json:"subnet"`
+				Ranges [][]struct {
+					Subnet string `json:"subnet"`
+				} `json:"ranges"`
+			} `json:"ipam"`
+		}
+		if err := json.Unmarshal(plugin, &conf); err != nil {
+			return nil, fmt.Errorf("failed to parse delegate configuration: %w", err)
+		}
+		subnet = conf.IPAM.Subnet
+		if subnet == "" && len(conf.IPAM.Ranges) > 0 && len(conf.IPAM.Ranges[0]) > 0 {
+			subnet = conf.IPAM.Ranges[0][0].Subnet
+		}
+		if subnet != "" {
+			break
+		}
+	}
+	if subnet == "" {
+		subnet = defaultSubnet
+	}
+
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delegate IPAM subnet %q: %w", subnet, err)
+	}
+
+	return &allocator{subnet: ipnet, inUse: map[string]bool{}}, nil
+}
+
+// allocate returns the lowest free address, skipping the network address and .1 (gateway)
+func (a *allocator) allocate() (net.IP, error) {
+	base := new(big.Int).SetBytes(a.subnet.IP)
+	ones, bits := a.subnet.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+
+	for offset := big.NewInt(2); offset.Cmp(size) < 0; offset.Add(offset, big.NewInt(1)) {
+		ip := toIP(new(big.Int).Add(base, offset), len(a.subnet.IP))
+		if !a.inUse[ip.String()] {
+			a.inUse[ip.String()] = true
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("delegate IPAM subnet %s exhausted", a.subnet)
+}
+
+// release returns ip to the pool; unknown addresses are ignored
+func (a *allocator) release(ip string) {
+	delete(a.inUse, ip)
+}
+
+// toIP renders n as an address of the given byte length
+func toIP(n *big.Int, length int) net.IP {
+	ip := make(net.IP, length)
+	n.FillBytes(ip)
+	return ip
+}
@@ -0,0 +1,329 @@
+// Package sim runs the tenant-routing-wrapper ADD/DEL pipeline against in-memory fakes.
+//
+// It lets platform CI validate conflists and annotation combinations without a Linux
+// node, root privileges or real CNI plugins:
+//
+//	out, err := sim.RunAdd(conflist, sim.Pod{
+//		Name:                 "web",
+//		Namespace:            "team-a",
+//		NamespaceAnnotations: map[string]string{"tenant.routing/fwmark": "0x10"},
+//	})
+//	// out.Rules  → iptables rules the plugin would install
+//	// out.Routes → policy routes the plugin would ensure
+//
+// The simulator reuses the production building blocks: config parsing and validation
+// (pkg/config), annotation resolution against a fake API server (pkg/k8s), pod IP
+// extraction (pkg/result), rule rendering (pkg/iptables.PodRules) and route derivation
+// (pkg/route.FromConfig). Only the delegate, the API server and the kernel are faked.
+//
+// Node-specific checks (source address safety against node interfaces, xtables
+// locking) are not simulated.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/config"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/iptables"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/k8s"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/result"
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/route"
+)
+
+// Pod describes the pod (and its namespace) the simulated runtime attaches
+type Pod struct {
+	Name      string
+	Namespace string
+
+	// Annotations on the pod object
+	Annotations map[string]string
+
+	// NamespaceAnnotations on the pod's namespace
+	NamespaceAnnotations map[string]string
+
+	// IP forces the pod IP returned by the stub delegate; allocated from the
+	// delegate IPAM subnet when empty
+	IP string
+}
+
+// Outcome reports the effect of a single simulated ADD or DEL
+type Outcome struct {
+	// PodIP extracted from the (stub) delegate result
+	PodIP string
+
+	// Fwmark and Gateway resolved from annotations ('' if none)
+	Fwmark  string
+	Gateway string
+
+	// Rules installed (ADD) or removed (DEL) by this invocation
+	Rules []iptables.Rule
+
+	// Routes ensured (ADD) or removed because the tenant's last pod left (DEL)
+	Routes []route.TenantRoute
+
+	// Warnings are the permissive-mode skips the real plugin would log
+	Warnings []string
+
+	// Reasons are the machine-readable codes of the skips, in order
+	Reasons []reason.Code
+}
+
+// skip records a permissive-mode skip the way the plugin would log and count it
+func (o *Outcome) skip(code reason.Code, format string, args ...interface{}) {
+	o.Warnings = append(o.Warnings, fmt.Sprintf(format, args...))
+	o.Reasons = append(o.Reasons, code)
+}
+
+// podState is what the simulator remembers between ADD and DEL
+type podState struct {
+	ip      string
+	fwmark  string
+	gateway string
+	rules   []iptables.Rule
+}
+
+// Simulator keeps node state across simulated invocations so multi-pod scenarios
+// (shared tenant routes, last-pod cleanup) behave like on a real node
+type Simulator struct {
+	conf *config.PluginConf
+
+	ipam   *allocator
+	pods   map[string]*podState
+	rules  map[string]iptables.Rule
+	routes map[uint32]route.TenantRoute
+}
+
+// New parses a conflist (or a single plugin config) and returns an empty simulated node
+func New(conflist []byte) (*Simulator, error) {
+	conf, err := config.ParseConflist(conflist)
+	if err != nil {
+		return nil, err
+	}
+
+	ipam, err := newAllocator(conf.Delegate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Simulator{
+		conf:   conf,
+		ipam:   ipam,
+		pods:   map[string]*podState{},
+		rules:  map[string]iptables.Rule{},
+		routes: map[uint32]route.TenantRoute{},
+	}, nil
+}
+
+// RunAdd simulates a single ADD on a fresh node
+func RunAdd(conflist []byte, pod Pod) (*Outcome, error) {
+	s, err := New(conflist)
+	if err != nil {
+		return nil, err
+	}
+	return s.Add(pod)
+}
+
+// Config returns the parsed plugin configuration
+func (s *Simulator) Config() *config.PluginConf {
+	return s.conf
+}
+
+// Add simulates CNI ADD for pod
+// Errors are returned where the real plugin fails the ADD; permissive skips become Warnings
+func (s *Simulator) Add(pod Pod) (*Outcome, error) {
+	if pod.Name == "" || pod.Namespace == "" {
+		return nil, fmt.Errorf("pod name and namespace are required")
+	}
+	key := pod.Namespace + "/" + pod.Name
+	if _, exists := s.pods[key]; exists {
+		return nil, fmt.Errorf("pod %s already added", key)
+	}
+
+	// Stub delegate: fabricate a CNI 1.0.0 result
+	delegateResult, err := s.delegateAdd(pod)
+	if err != nil {
+		return nil, fmt.Errorf("delegation failed: %w", err)
+	}
+
+	podIP, err := result.ExtractPodIP(delegateResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract pod IP from delegate result: %w", err)
+	}
+
+	out := &Outcome{PodIP: podIP}
+	state := &podState{ip: podIP}
+	s.pods[key] = state
+
+	// Fake resolver: real annotation logic against an in-memory API server
+	annotations, err := k8s.GetRoutingAnnotations(context.Background(), fakeClient(pod), pod.Name, pod.Namespace,
+		s.conf.AnnotationKey, s.conf.GatewayAnnotationKey)
+	if err != nil {
+		out.skip(reason.ForAnnotationError(err), "failed to get fwmark annotation for %s: %v", key, err)
+		return out, nil
+	}
+	if annotations.Fwmark == "" {
+		out.Reasons = append(out.Reasons, reason.NoAnnotation)
+		return out, nil
+	}
+
+	out.Fwmark, out.Gateway = annotations.Fwmark, annotations.Gateway
+	state.fwmark, state.gateway = annotations.Fwmark, annotations.Gateway
+
+	if annotations.BypassError != nil {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("ignoring %s on pod %s: %v",
+			k8s.BypassAnnotationKey, key, annotations.BypassError))
+	} else if annotations.BypassActive(time.Now()) {
+		out.skip(reason.Bypassed, "pod %s bypassed until %s: MARK rule not installed",
+			key, annotations.BypassUntil.Format(time.RFC3339))
+		return out, nil
+	}
+
+	// Fake datapath: record the exact rules the plugin would program
+	state.rules = iptables.PodRules(podIP, annotations.Fwmark, iptables.PodRuleOptions{
+		Connmark:           s.conf.Connmark,
+		MarkHostTraffic:    s.conf.MarkHostTraffic,
+		NewConnectionsOnly: s.conf.MarkNewConnectionsOnly,
+		Hook:               s.conf.MarkHook(annotations.Fwmark),
+	})
+	for _, rule := range state.rules {
+		if _, exists := s.rules[rule.String()]; !exists {
+			s.rules[rule.String()] = rule
+			out.Rules = append(out.Rules, rule)
+		}
+	}
+
+	tr, ok, err := route.FromConfig(s.conf, annotations.Fwmark, annotations.Gateway)
+	switch {
+	case err != nil:
+		out.skip(reason.RoutingFailed, "invalid routing configuration for fwmark %s: %v", annotations.Fwmark, err)
+	case !ok && annotations.Gateway != "":
+		out.Warnings = append(out.Warnings, fmt.Sprintf("gateway annotation %s ignored: no routing table configured for fwmark %s",
+			annotations.Gateway, annotations.Fwmark))
+	case ok:
+		if err := tr.Validate(); err != nil {
+			out.skip(reason.RoutingFailed, "failed to ensure policy routing (%s): %v", tr, err)
+			break
+		}
+		s.routes[tr.Fwmark] = tr
+		out.Routes = append(out.Routes, tr)
+	}
+
+	return out, nil
+}
+
+// Del simulates CNI DEL for pod
+// Idempotent like the real plugin: deleting an unknown pod is not an error
+func (s *Simulator) Del(pod Pod) (*Outcome, error) {
+	key := pod.Namespace + "/" + pod.Name
+	state, ok := s.pods[key]
+	if !ok {
+		return &Outcome{}, nil
+	}
+	delete(s.pods, key)
+	s.ipam.release(state.ip)
+
+	out := &Outcome{PodIP: state.ip, Fwmark: state.fwmark, Gateway: state.gateway}
+	for _, rule := range state.rules {
+		if _, exists := s.rules[rule.String()]; exists {
+			delete(s.rules, rule.String())
+			out.Rules = append(out.Rules, rule)
+		}
+	}
+
+	if state.fwmark == "" || s.tenantPods(state.fwmark) > 0 {
+		return out, nil
+	}
+
+	// Last pod of the tenant left the node: policy routing is released
+	mark, err := route.ParseFwmark(state.fwmark)
+	if err == nil {
+		if tr, ok := s.routes[mark]; ok {
+			delete(s.routes, mark)
+			out.Routes = append(out.Routes, tr)
+		}
+	}
+
+	return out, nil
+}
+
+// Rules returns all rules currently installed on the simulated node, sorted
+func (s *Simulator) Rules() []iptables.Rule {
+	keys := make([]string, 0, len(s.rules))
+	for key := range s.rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rules := make([]iptables.Rule, 0, len(keys))
+	for _, key := range keys {
+		rules = append(rules, s.rules[key])
+	}
+	return rules
+}
+
+// Routes returns all tenant routes currently installed on the simulated node, sorted by fwmark
+func (s *Simulator) Routes() []route.TenantRoute {
+	routes := make([]route.TenantRoute, 0, len(s.routes))
+	for _, tr := range s.routes {
+		routes = append(routes, tr)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Fwmark < routes[j].Fwmark })
+	return routes
+}
+
+// tenantPods counts simulated pods carrying fwmark
+func (s *Simulator) tenantPods(fwmark string) int {
+	count := 0
+	for _, state := range s.pods {
+		if state.fwmark == fwmark {
+			count++
+		}
+	}
+	return count
+}
+
+// delegateAdd is the stub delegate: it returns a 1.0.0 result with one container interface
+func (s *Simulator) delegateAdd(pod Pod) (*types100.Result, error) {
+	var ip net.IP
+	if pod.IP != "" {
+		ip = net.ParseIP(pod.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid pod IP %q", pod.IP)
+		}
+	} else {
+		var err error
+		if ip, err = s.ipam.allocate(); err != nil {
+			return nil, err
+		}
+	}
+
+	mask := net.CIDRMask(32, 32)
+	if ip.To4() == nil {
+		mask = net.CIDRMask(128, 128)
+	}
+	iface := 0
+
+	return &types100.Result{
+		CNIVersion: types100.ImplementedSpecVersion,
+		Interfaces: []*types100.Interface{{Name: "eth0", Sandbox: "/var/run/netns/sim"}},
+		IPs:        []*types100.IPConfig{{Interface: &iface, Address: net.IPNet{IP: ip, Mask: mask}}},
+	}, nil
+}
+
+// fakeClient builds an in-memory API server holding the pod and its namespace
+func fakeClient(pod Pod) *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace, Annotations: pod.Annotations}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: pod.Namespace, Annotations: pod.NamespaceAnnotations}},
+	)
+}
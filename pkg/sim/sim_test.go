@@ -0,0 +1,211 @@
+package sim
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/reason"
+)
+
+const testConflist = `{
+	"cniVersion": "1.0.0",
+	"name": "tenant-net",
+	"plugins": [
+		{
+			"type": "tenant-routing-wrapper",
+			"kubeconfig": "/etc/cni/net.d/tenant-routing.kubeconfig",
+			"connmark": true,
+			"routing": {
+				"tables": {"0x10": {"table": 100, "gateway": "192.168.1.1"}}
+			},
+			"delegate": {
+				"type": "bridge",
+				"ipam": {"type": "host-local", "subnet": "10.200.0.0/24"}
+			}
+		}
+	]
+}`
+
+// TestRunAdd_MarkedPod verifies rules and routes for an annotated pod
+func TestRunAdd_MarkedPod(t *testing.T) {
+	out, err := RunAdd([]byte(testConflist), Pod{
+		Name:                 "web",
+		Namespace:            "team-a",
+		NamespaceAnnotations: map[string]string{"tenant.routing/fwmark": "0x10"},
+	})
+	if err != nil {
+		t.Fatalf("RunAdd() error = %v", err)
+	}
+
+	if out.PodIP != "10.200.0.2" {
+		t.Errorf("PodIP = %q, want 10.200.0.2", out.PodIP)
+	}
+	if out.Fwmark != "0x10" {
+		t.Errorf("Fwmark = %q, want 0x10", out.Fwmark)
+	}
+	// MARK + CONNMARK save + restore in PREROUTING and OUTPUT
+	if len(out.Rules) != 4 {
+		t.Fatalf("got %d rules, want 4: %v", len(out.Rules), out.Rules)
+	}
+	if got := out.Rules[0].String(); got != "-t mangle -A PREROUTING -s 10.200.0.2 -j MARK --set-mark 0x10" {
+		t.Errorf("first rule = %q", got)
+	}
+	if len(out.Routes) != 1 || out.Routes[0].Table != 100 || !out.Routes[0].Gateway.Equal([]byte{192, 168, 1, 1}) {
+		t.Errorf("Routes = %v, want table 100 via 192.168.1.1", out.Routes)
+	}
+}
+
+// TestRunAdd_UnannotatedPod verifies that pods without fwmark get no rules
+func TestRunAdd_UnannotatedPod(t *testing.T) {
+	out, err := RunAdd([]byte(testConflist), Pod{Name: "web", Namespace: "default"})
+	if err != nil {
+		t.Fatalf("RunAdd() error = %v", err)
+	}
+	if len(out.Rules) != 0 || len(out.Routes) != 0 {
+		t.Errorf("expected no rules or routes, got %v %v", out.Rules, out.Routes)
+	}
+	if !reflect.DeepEqual(out.Reasons, []reason.Code{reason.NoAnnotation}) {
+		t.Errorf("Reasons = %v, want [%s]", out.Reasons, reason.NoAnnotation)
+	}
+}
+
+// TestRunAdd_SkipReasons verifies permissive skips carry machine-readable reason codes
+func TestRunAdd_SkipReasons(t *testing.T) {
+	tests := []struct {
+		name string
+		pod  Pod
+		want reason.Code
+	}{
+		{
+			name: "invalid fwmark",
+			pod:  Pod{Name: "web", Namespace: "team-a", Annotations: map[string]string{"tenant.routing/fwmark": "0x99"}},
+			want: reason.InvalidFwmark,
+		},
+		{
+			name: "invalid gateway",
+			pod: Pod{Name: "web", Namespace: "team-a", Annotations: map[string]string{
+				"tenant.routing/fwmark":  "0x10",
+				"tenant.routing/gateway": "not-an-ip",
+			}},
+			want: reason.InvalidGateway,
+		},
+		{
+			name: "bypassed",
+			pod: Pod{Name: "web", Namespace: "team-a", Annotations: map[string]string{
+				"tenant.routing/fwmark":       "0x10",
+				"tenant.routing/bypass-until": time.Now().Add(time.Hour).Format(time.RFC3339),
+			}},
+			want: reason.Bypassed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := RunAdd([]byte(testConflist), tt.pod)
+			if err != nil {
+				t.Fatalf("RunAdd() error = %v", err)
+			}
+			if len(out.Reasons) != 1 || out.Reasons[0] != tt.want {
+				t.Errorf("Reasons = %v, want [%s]", out.Reasons, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunAdd_InvalidConfig verifies configuration errors are surfaced
+func TestRunAdd_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflist string
+		errMsg   string
+	}{
+		{name: "not json", conflist: "{", errMsg: "failed to parse conflist"},
+		{name: "no wrapper", conflist: `{"name":"n","plugins":[{"type":"bridge"}]}`, errMsg: "has no tenant-routing-wrapper plugin"},
+		{name: "relative kubeconfig", conflist: `{"type":"tenant-routing-wrapper","kubeconfig":"kc","delegate":{}}`, errMsg: "must be absolute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RunAdd([]byte(tt.conflist), Pod{Name: "p", Namespace: "ns"})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want containing %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+// TestSimulator_SharedTenantRoute verifies routes live until the tenant's last pod leaves
+func TestSimulator_SharedTenantRoute(t *testing.T) {
+	s, err := New([]byte(testConflist))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ns := map[string]string{"tenant.routing/fwmark": "0x10"}
+	a := Pod{Name: "a", Namespace: "team-a", NamespaceAnnotations: ns}
+	b := Pod{Name: "b", Namespace: "team-a", NamespaceAnnotations: ns}
+
+	if _, err := s.Add(a); err != nil {
+		t.Fatalf("Add(a) error = %v", err)
+	}
+	outB, err := s.Add(b)
+	if err != nil {
+		t.Fatalf("Add(b) error = %v", err)
+	}
+	if outB.PodIP != "10.200.0.3" {
+		t.Errorf("second pod IP = %q, want 10.200.0.3", outB.PodIP)
+	}
+	if _, err := s.Add(a); err == nil {
+		t.Error("expected error adding the same pod twice")
+	}
+
+	out, err := s.Del(a)
+	if err != nil {
+		t.Fatalf("Del(a) error = %v", err)
+	}
+	if len(out.Rules) != 4 || len(out.Routes) != 0 {
+		t.Errorf("Del(a) removed %d rules and %d routes, want 4 and 0", len(out.Rules), len(out.Routes))
+	}
+	if len(s.Routes()) != 1 {
+		t.Errorf("tenant route removed while pod b still exists")
+	}
+
+	out, err = s.Del(b)
+	if err != nil {
+		t.Fatalf("Del(b) error = %v", err)
+	}
+	if len(out.Routes) != 1 {
+		t.Errorf("Del(b) removed %d routes, want 1", len(out.Routes))
+	}
+	if len(s.Rules()) != 0 || len(s.Routes()) != 0 {
+		t.Errorf("node not clean after last DEL: %v %v", s.Rules(), s.Routes())
+	}
+
+	// DEL is idempotent
+	if _, err := s.Del(b); err != nil {
+		t.Errorf("repeated Del() error = %v", err)
+	}
+}
+
+// TestRunAdd_Bypass verifies an active bypass annotation leaves the pod unmarked
+func TestRunAdd_Bypass(t *testing.T) {
+	out, err := RunAdd([]byte(testConflist), Pod{
+		Name:      "web",
+		Namespace: "team-a",
+		Annotations: map[string]string{
+			"tenant.routing/fwmark":       "0x10",
+			"tenant.routing/bypass-until": time.Now().Add(time.Hour).Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunAdd() error = %v", err)
+	}
+	if len(out.Rules) != 0 || len(out.Routes) != 0 {
+		t.Errorf("bypassed pod got rules or routes: %v %v", out.Rules, out.Routes)
+	}
+	if len(out.Warnings) != 1 || !strings.Contains(out.Warnings[0], "bypassed until") {
+		t.Errorf("Warnings = %v, want bypass notice", out.Warnings)
+	}
+}
@@ -0,0 +1,176 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// attemptDir holds the attempts below a network's directory
+// A directory: List only reads the .json files directly below the network.
+const attemptDir = ".attempts"
+
+// Attempt is the ADD attempt of an attachment and the effects it recorded
+//
+// kubelet retries an ADD that timed out with the same container ID, and the retry
+// runs again what the first invocation may have finished: an attempt lasts from the
+// first ADD of an attachment until its DEL (or GC), so the retries share it and each
+// effect (a history entry, an audit line, a metric) is recorded once per attempt.
+type Attempt struct {
+	// Attachment the attempt belongs to
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName"`
+
+	// ID tells the attempts of an attachment apart, e.g. an ADD after a rolled back one
+	ID string `json:"id"`
+
+	// Started is when the first ADD of the attempt ran
+	Started time.Time `json:"started"`
+
+	// Effects are the effects recorded so far (see Store.Once)
+	Effects []string `json:"effects,omitempty"`
+}
+
+// IdempotencyKey returns the key of an attempt: containerID/ifName/attempt ID
+func IdempotencyKey(containerID, ifName, attemptID string) string {
+	return containerID + "/" + ifName + "/" + attemptID
+}
+
+// StartAttempt returns the attempt of an attachment, starting one if there is none
+// Retried ADDs get the attempt of the first.
+func (s *Store) StartAttempt(network, containerID, ifName string) (*Attempt, error) {
+	var attempt *Attempt
+	err := s.updateAttempt(network, containerID, ifName, func(a *Attempt) bool {
+		attempt = a
+		return false
+	})
+	return attempt, err
+}
+
+// Once records effect for the attempt of an attachment, starting one if there is none
+// Returns true if effect was not recorded yet, i.e. the caller should carry it out.
+func (s *Store) Once(network, containerID, ifName, effect string) (bool, error) {
+	first := false
+	err := s.updateAttempt(network, containerID, ifName, func(a *Attempt) bool {
+		if slices.Contains(a.Effects, effect) {
+			return false
+		}
+		a.Effects = append(a.Effects, effect)
+		first = true
+		return true
+	})
+	return first, err
+}
+
+// EndAttempt forgets the attempt of an attachment once it is torn down; idempotent
+// The next ADD of the attachment starts a new attempt.
+func (s *Store) EndAttempt(network, containerID, ifName string) error {
+	path, err := s.attemptPath(network, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete ADD attempt: %w", err)
+	}
+	return nil
+}
+
+// updateAttempt applies fn to the attempt of an attachment holding the network's lock,
+// starting one if there is none; the attempt is written if it is new or fn returns true
+func (s *Store) updateAttempt(network, containerID, ifName string, fn func(*Attempt) bool) error {
+	path, err := s.attemptPath(network, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lock(network)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	attempt, err := loadAttempt(path)
+	started := errors.Is(err, os.ErrNotExist)
+	switch {
+	case started:
+		now := time.Now().UTC()
+		attempt = &Attempt{ContainerID: containerID, IfName: ifName, ID: strconv.FormatInt(now.UnixNano(), 36),
+			Started: now}
+	case err != nil:
+		return err
+	}
+	if changed := fn(attempt); !changed && !started {
+		return nil
+	}
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return fmt.Errorf("failed to encode ADD attempt: %w", err)
+	}
+	if err := writeAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write ADD attempt: %w", err)
+	}
+	return nil
+}
+
+// PruneAttempts forgets the attempts started at least minAge ago of the attachments
+// valid does not report, e.g. those whose DEL never came; returns how many it removed
+func (s *Store) PruneAttempts(network string, valid func(containerID, ifName string) bool,
+	minAge time.Duration) (int, error) {
+	if !keyPattern.MatchString(network) {
+		return 0, fmt.Errorf("invalid network name %q for state record", network)
+	}
+	unlock, err := s.lock(network)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, network, attemptDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var errs []error
+	for _, path := range paths {
+		attempt, err := loadAttempt(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if valid(attempt.ContainerID, attempt.IfName) || time.Since(attempt.Started) < minAge {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete ADD attempt: %w", err))
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(errs...)
+}
+
+// loadAttempt reads the attempt at path; errors match os.ErrNotExist if there is none
+func loadAttempt(path string) (*Attempt, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ADD attempt: %w", err)
+	}
+	attempt := &Attempt{}
+	if err := json.Unmarshal(data, attempt); err != nil {
+		return nil, fmt.Errorf("failed to parse ADD attempt %s: %w", path, err)
+	}
+	return attempt, nil
+}
+
+// attemptPath returns the attempt file of an attachment after validating the key
+func (s *Store) attemptPath(network, containerID, ifName string) (string, error) {
+	path, err := s.path(network, containerID, ifName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), attemptDir, filepath.Base(path)), nil
+}
@@ -0,0 +1,189 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Causes of an Assignment
+const (
+	// CauseAdd is the assignment of a new pod by ADD (Fwmark empty if it is not a tenant pod)
+	CauseAdd = "add"
+
+	// CauseDelete is the end of a pod's routing by DEL
+	CauseDelete = "delete"
+
+	// CauseGC is the end of a pod's routing by GC (the pod is gone without DEL)
+	CauseGC = "gc"
+
+	// CauseMigrate is a running pod marked by `migrate`
+	CauseMigrate = "migrate"
+
+	// CauseRelabel is a running pod moved by the node agent after an annotation change
+	CauseRelabel = "relabel"
+
+	// CauseBypass is a pod left unmarked by an active bypass annotation
+	CauseBypass = "bypass"
+
+	// CauseBypassExpired is a bypassed pod marked again
+	CauseBypassExpired = "bypass-expired"
+
+	// CauseTerminated is the end of a pod's routing by the node agent after the pod
+	// reached phase Succeeded or Failed, before DEL
+	CauseTerminated = "terminated"
+)
+
+// MaxHistory is the number of assignments kept per pod; older ones are dropped
+const MaxHistory = 100
+
+// historyDir holds the histories below a network's directory
+// A directory: List only reads the .json files directly below the network.
+const historyDir = ".history"
+
+// Assignment is one change of the tenant a pod is routed as
+type Assignment struct {
+	// Time of the change
+	Time time.Time `json:"time"`
+
+	// Cause is one of the Cause constants
+	Cause string `json:"cause"`
+
+	// Fwmark and Gateway the pod is routed with from Time on (empty Fwmark: unmarked)
+	Fwmark  string `json:"fwmark,omitempty"`
+	Gateway string `json:"gateway,omitempty"`
+
+	// Pod incarnation and attachment, as far as known to the invocation
+	PodUID      string `json:"podUID,omitempty"`
+	ContainerID string `json:"containerID,omitempty"`
+	IP          string `json:"ip,omitempty"`
+
+	// Key is the idempotency key of the ADD attempt that made the change, if any
+	// (see IdempotencyKey); retried ADDs of the attempt record the change only once
+	Key string `json:"key,omitempty"`
+}
+
+// AppendHistory adds a to the history of namespace/pod, keeping the newest MaxHistory
+// Histories are kept per pod name, not per attachment, so they outlive the records.
+func (s *Store) AppendHistory(network, namespace, pod string, a Assignment) error {
+	path, err := s.historyPath(network, namespace, pod)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lock(network)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	history, err := loadHistory(path)
+	if err != nil {
+		return err
+	}
+	history = append(history, a)
+	if len(history) > MaxHistory {
+		history = history[len(history)-MaxHistory:]
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to encode assignment history: %w", err)
+	}
+	if err := writeAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write assignment history: %w", err)
+	}
+	return nil
+}
+
+// History returns the assignments of namespace/pod, oldest first (none if it has no history)
+func (s *Store) History(network, namespace, pod string) ([]Assignment, error) {
+	path, err := s.historyPath(network, namespace, pod)
+	if err != nil {
+		return nil, err
+	}
+	return loadHistory(path)
+}
+
+// PruneHistory removes the histories of pods whose last assignment is older than maxAge
+// Returns the number of histories removed.
+func (s *Store) PruneHistory(network string, maxAge time.Duration) (int, error) {
+	if !keyPattern.MatchString(network) {
+		return 0, fmt.Errorf("invalid network name %q for state record", network)
+	}
+	unlock, err := s.lock(network)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, network, historyDir, "*", "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var errs []error
+	for _, path := range paths {
+		history, err := loadHistory(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(history) > 0 && time.Since(history[len(history)-1].Time) < maxAge {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove assignment history: %w", err))
+			continue
+		}
+		removed++
+		// Only succeeds for the namespace's last history
+		os.Remove(filepath.Dir(path))
+	}
+	return removed, errors.Join(errs...)
+}
+
+// Between returns the assignments in effect at some point from from to to: the last
+// one before from, if any, and every one up to to
+func Between(history []Assignment, from, to time.Time) []Assignment {
+	var result []Assignment
+	for i, a := range history {
+		if a.Time.After(to) {
+			break
+		}
+		if a.Time.Before(from) && i+1 < len(history) && !history[i+1].Time.After(from) {
+			continue
+		}
+		result = append(result, a)
+	}
+	return result
+}
+
+// loadHistory reads the history at path; a missing file is an empty history
+func loadHistory(path string) ([]Assignment, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read assignment history: %w", err)
+	}
+	var history []Assignment
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse assignment history %s: %w", path, err)
+	}
+	return history, nil
+}
+
+// historyPath returns the history file of namespace/pod after validating the names
+func (s *Store) historyPath(network, namespace, pod string) (string, error) {
+	for _, part := range []struct{ name, value string }{
+		{"network name", network}, {"namespace", namespace}, {"pod name", pod},
+	} {
+		if !keyPattern.MatchString(part.value) {
+			return "", fmt.Errorf("invalid %s %q for assignment history", part.name, part.value)
+		}
+	}
+	return filepath.Join(s.dir, network, historyDir, namespace, pod+".json"), nil
+}
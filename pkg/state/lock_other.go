@@ -0,0 +1,8 @@
+//go:build !unix
+
+package state
+
+// lockFile is a no-op where flock is unavailable; concurrent updates may be lost
+func lockFile(string) (func(), error) {
+	return func() {}, nil
+}
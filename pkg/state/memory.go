@@ -0,0 +1,76 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// ErrReadOnly is matched (errors.Is) by Save errors of a store whose directory is on a
+// read-only filesystem (e.g. a Bottlerocket node without a writable stateDir)
+// The record is kept in memory instead: Load, List, Update and Delete of this process
+// see it, other processes do not.
+var ErrReadOnly = errors.New("state directory is read-only")
+
+// isReadOnly reports whether err is a write to a read-only filesystem
+func isReadOnly(err error) bool {
+	return errors.Is(err, syscall.EROFS)
+}
+
+// mkdirAll creates the directories of the store; replaced in tests, which run as root
+var mkdirAll = os.MkdirAll
+
+// memory holds the records of read-only stores, by record path
+var memory = struct {
+	sync.Mutex
+	records map[string]*Record
+}{records: map[string]*Record{}}
+
+// remember stores a copy of rec at path in memory
+func remember(path string, rec *Record) {
+	copied := *rec
+	memory.Lock()
+	defer memory.Unlock()
+	memory.records[path] = &copied
+}
+
+// recall returns a copy of the record at path kept in memory, or nil
+func recall(path string) *Record {
+	memory.Lock()
+	defer memory.Unlock()
+	rec, ok := memory.records[path]
+	if !ok {
+		return nil
+	}
+	copied := *rec
+	return &copied
+}
+
+// forget drops the record at path from memory
+func forget(path string) {
+	memory.Lock()
+	defer memory.Unlock()
+	delete(memory.records, path)
+}
+
+// recallAll returns copies of the records kept in memory below dir, by path
+func recallAll(dir string) []*Record {
+	memory.Lock()
+	defer memory.Unlock()
+	var paths []string
+	for path := range memory.records {
+		if filepath.Dir(path) == dir {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	records := make([]*Record, len(paths))
+	for i, path := range paths {
+		copied := *memory.records[path]
+		records[i] = &copied
+	}
+	return records
+}
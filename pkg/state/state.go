@@ -0,0 +1,340 @@
+// Package state persists what ADD did for each container on the node.
+//
+// Without a record, DEL has to ask the API server for the pod's fwmark, and once the
+// pod object is gone it can only delete rules for every known fwmark and release
+// every tenant's routing. ADD therefore writes one record per attachment (network,
+// container ID, interface) and DEL/CHECK read it back, so teardown needs neither the
+// API server nor guessing:
+//
+//	store := state.New("/var/lib/cni/tenant-routing")
+//	err := store.Save(&state.Record{Network: "tenant-net", ContainerID: id, IfName: "eth0", ...})
+//	rec, err := store.Load("tenant-net", id, "eth0") // state.ErrNotFound if ADD wrote none
+//
+// Records are written atomically (temporary file + rename), so a crash mid-ADD
+// leaves either the old record or the new one.
+//
+// ADD, DEL, GC and migrate run in separate processes that may touch the same record.
+// Writers of a network serialize on a lock file next to its records, and changes to
+// an existing record go through Update, which applies them to the record as stored at
+// that moment instead of overwriting it with a stale copy:
+//
+//	err := store.Update("tenant-net", id, "eth0", func(rec *state.Record) error {
+//		rec.Pending = true
+//		return nil
+//	}) // state.ErrNotFound if DEL removed the record meanwhile
+//
+// Next to the records, the store keeps a bounded history of the tenant each pod was
+// routed as (see Assignment). Histories are keyed by namespace and pod name and
+// outlive the records, so "which tenant was pod X routed as between T1 and T2" can
+// be answered after the pod is gone:
+//
+//	err := store.AppendHistory("tenant-net", "team-a", "web", state.Assignment{Time: now, Cause: state.CauseAdd, Fwmark: "0x10"})
+//	history, err := store.History("tenant-net", "team-a", "web")
+//	inEffect := state.Between(history, t1, t2)
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Load when no record exists for an attachment
+var ErrNotFound = errors.New("no state record")
+
+// Record is the state of one attachment as left by ADD
+type Record struct {
+	// Attachment key, as passed by the runtime
+	Network     string `json:"network"`
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName"`
+
+	// Pod the attachment belongs to
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+
+	// PodUID identifies the pod incarnation (empty if ADD could not resolve it)
+	// StatefulSet pods reuse names, so Namespace and Pod alone may name a newer pod
+	PodUID string `json:"podUID,omitempty"`
+
+	// IPs assigned by the delegate; the first one is the marked pod IP
+	IPs []string `json:"ips"`
+
+	// Fwmark and Gateway resolved from annotations (empty if the pod is not a tenant pod)
+	Fwmark  string `json:"fwmark,omitempty"`
+	Gateway string `json:"gateway,omitempty"`
+
+	// Chain is the table/chain holding the MARK rule (e.g. "mangle/PREROUTING")
+	Chain string `json:"chain,omitempty"`
+
+	// Pending marks rules ADD could not install (xtables lock timeout); GC retries them
+	Pending bool `json:"pending,omitempty"`
+
+	// ConfigHash is the fingerprint of the configuration ADD ran with (see config.PluginConf.Fingerprint)
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// Created is when ADD wrote the record
+	Created time.Time `json:"created"`
+}
+
+// PodIP returns the marked pod IP (empty if the record has none)
+func (r *Record) PodIP() string {
+	if len(r.IPs) == 0 {
+		return ""
+	}
+	return r.IPs[0]
+}
+
+// keyPattern restricts key components to what is safe as a file name
+// Matches the CNI spec rules for container IDs; network and interface names use the same set
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]*$`)
+
+// configHashFile holds the published fingerprint next to the records of a network
+// Not a record: List only reads .json files
+const configHashFile = "config-hash"
+
+// lockFileName serializes the writers of a network's records (see Store.Update)
+const lockFileName = ".lock"
+
+// Store keeps records as JSON files under <dir>/<network>/<containerID>.<ifName>.json
+type Store struct {
+	dir string
+}
+
+// New returns a store rooted at dir; the directory is created on first Save
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Save writes rec, replacing any record of the same attachment
+// On a read-only filesystem rec is kept in memory and an ErrReadOnly error returned.
+func (s *Store) Save(rec *Record) error {
+	path, err := s.path(rec.Network, rec.ContainerID, rec.IfName)
+	if err != nil {
+		return err
+	}
+	unlock, err := s.lock(rec.Network)
+	if err == nil {
+		defer unlock()
+		err = save(path, rec)
+	}
+	if err != nil && isReadOnly(err) {
+		remember(path, rec)
+		return fmt.Errorf("%w, record of container %s kept in memory only: %v", ErrReadOnly, rec.ContainerID, err)
+	}
+	return err
+}
+
+// Update applies fn to the stored record of an attachment and writes the result
+// The record is read and written holding the network's lock, so changes of concurrent
+// invocations are merged rather than lost. Returns ErrNotFound (and does not recreate
+// the record) if there is none; an error from fn leaves the record unchanged.
+func (s *Store) Update(network, containerID, ifName string, fn func(*Record) error) error {
+	path, err := s.path(network, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	if rec := recall(path); rec != nil {
+		if err := fn(rec); err != nil {
+			return err
+		}
+		remember(path, rec)
+		return nil
+	}
+	unlock, err := s.lock(network)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rec, err := load(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(rec); err != nil {
+		return err
+	}
+	return save(path, rec)
+}
+
+// Load returns the record of an attachment, or ErrNotFound
+func (s *Store) Load(network, containerID, ifName string) (*Record, error) {
+	path, err := s.path(network, containerID, ifName)
+	if err != nil {
+		return nil, err
+	}
+	if rec := recall(path); rec != nil {
+		return rec, nil
+	}
+	return load(path)
+}
+
+// load reads the record at path; readers need no lock, records are replaced atomically
+func load(path string) (*Record, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state record: %w", err)
+	}
+
+	rec := &Record{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("failed to parse state record %s: %w", path, err)
+	}
+	return rec, nil
+}
+
+// Delete removes the record of an attachment; idempotent
+func (s *Store) Delete(network, containerID, ifName string) error {
+	path, err := s.path(network, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	forget(path)
+	unlock, err := s.lock(network)
+	if err != nil {
+		// Nothing to delete on a read-only filesystem without the record
+		if _, statErr := os.Stat(path); isReadOnly(err) && errors.Is(statErr, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete state record: %w", err)
+	}
+	return nil
+}
+
+// List returns every record of network, including those kept in memory; unreadable
+// records are skipped and reported in the error
+func (s *Store) List(network string) ([]*Record, error) {
+	if !keyPattern.MatchString(network) {
+		return nil, fmt.Errorf("invalid network name %q for state record", network)
+	}
+	records := recallAll(filepath.Join(s.dir, network))
+	entries, err := os.ReadDir(filepath.Join(s.dir, network))
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return records, fmt.Errorf("failed to list state records: %w", err)
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(s.dir, network, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse state record %s: %w", path, err))
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, errors.Join(errs...)
+}
+
+// PublishedConfigHash returns the configuration fingerprint last recorded with
+// SetPublishedConfigHash for network ("" if none)
+func (s *Store) PublishedConfigHash(network string) (string, error) {
+	if !keyPattern.MatchString(network) {
+		return "", fmt.Errorf("invalid network name %q for state record", network)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, network, configHashFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read published config hash: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetPublishedConfigHash records the configuration fingerprint published for network
+func (s *Store) SetPublishedConfigHash(network, hash string) error {
+	if !keyPattern.MatchString(network) {
+		return fmt.Errorf("invalid network name %q for state record", network)
+	}
+	unlock, err := s.lock(network)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := writeAtomic(filepath.Join(s.dir, network, configHashFile), []byte(hash+"\n")); err != nil {
+		return fmt.Errorf("failed to write published config hash: %w", err)
+	}
+	return nil
+}
+
+// save writes rec to path
+func save(path string, rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode state record: %w", err)
+	}
+	if err := writeAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write state record: %w", err)
+	}
+	return nil
+}
+
+// lock takes the lock of network's records, creating its directory if needed
+func (s *Store) lock(network string) (func(), error) {
+	dir := filepath.Join(s.dir, network)
+	if err := mkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	unlock, err := lockFile(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock state records of network %s: %w", network, err)
+	}
+	return unlock, nil
+}
+
+// writeAtomic replaces path with data (temporary file + rename), creating its directory
+func writeAtomic(path string, data []byte) error {
+	if err := mkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// path returns the file of an attachment after validating every key component
+func (s *Store) path(network, containerID, ifName string) (string, error) {
+	for _, part := range []struct{ name, value string }{
+		{"network name", network}, {"container ID", containerID}, {"interface name", ifName},
+	} {
+		if !keyPattern.MatchString(part.value) {
+			return "", fmt.Errorf("invalid %s %q for state record", part.name, part.value)
+		}
+	}
+	return filepath.Join(s.dir, network, containerID+"."+ifName+".json"), nil
+}
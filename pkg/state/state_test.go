@@ -0,0 +1,341 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestStore_SaveLoadDelete verifies records round-trip and Delete is idempotent
+func TestStore_SaveLoadDelete(t *testing.T) {
+	store := New(t.TempDir())
+	rec := &Record{
+		Network:     "tenant-net",
+		ContainerID: "abc123",
+		IfName:      "eth0",
+		Namespace:   "default",
+		Pod:         "web-1",
+		IPs:         []string{"10.200.1.5"},
+		Fwmark:      "0x10",
+		Gateway:     "192.168.100.1",
+		Chain:       "mangle/PREROUTING",
+		Created:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	if _, err := store.Load("tenant-net", "abc123", "eth0"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Load() before Save error = %v, want ErrNotFound", err)
+	}
+	if err := store.Save(rec); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := store.Load("tenant-net", "abc123", "eth0")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.PodIP() != "10.200.1.5" || got.Fwmark != "0x10" || got.Gateway != rec.Gateway ||
+		got.Pod != "web-1" || got.Chain != rec.Chain || !got.Created.Equal(rec.Created) {
+		t.Errorf("Load() = %+v, want %+v", got, rec)
+	}
+
+	// Overwrite keeps a single record
+	rec.Fwmark = "0x20"
+	if err := store.Save(rec); err != nil {
+		t.Fatalf("Save() overwrite error = %v", err)
+	}
+	list, err := store.List("tenant-net")
+	if err != nil || len(list) != 1 || list[0].Fwmark != "0x20" {
+		t.Errorf("List() = %v, %v; want one record with fwmark 0x20", list, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := store.Delete("tenant-net", "abc123", "eth0"); err != nil {
+			t.Fatalf("Delete() #%d error = %v", i+1, err)
+		}
+	}
+	if _, err := store.Load("tenant-net", "abc123", "eth0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load() after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+// TestStore_InvalidKeys verifies key components cannot escape the state directory
+func TestStore_InvalidKeys(t *testing.T) {
+	store := New(t.TempDir())
+	tests := []struct {
+		name                         string
+		network, containerID, ifName string
+	}{
+		{name: "path traversal", network: "tenant-net", containerID: "../../etc/passwd", ifName: "eth0"},
+		{name: "slash in network", network: "a/b", containerID: "abc", ifName: "eth0"},
+		{name: "empty container ID", network: "tenant-net", containerID: "", ifName: "eth0"},
+		{name: "leading dot", network: "tenant-net", containerID: "abc", ifName: ".."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &Record{Network: tt.network, ContainerID: tt.containerID, IfName: tt.ifName}
+			if err := store.Save(rec); err == nil {
+				t.Error("Save() expected error")
+			}
+			if _, err := store.Load(tt.network, tt.containerID, tt.ifName); err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("Load() error = %v, want validation error", err)
+			}
+		})
+	}
+}
+
+// TestStore_List verifies other networks, temporary files and corrupt records are handled
+func TestStore_List(t *testing.T) {
+	dir := t.TempDir()
+	store := New(dir)
+
+	if list, err := store.List("tenant-net"); err != nil || len(list) != 0 {
+		t.Fatalf("List() on empty store = %v, %v", list, err)
+	}
+
+	for _, rec := range []*Record{
+		{Network: "tenant-net", ContainerID: "a", IfName: "eth0"},
+		{Network: "tenant-net", ContainerID: "b", IfName: "eth0"},
+		{Network: "other-net", ContainerID: "c", IfName: "eth0"},
+	} {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tenant-net", ".tmp-123"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tenant-net", "broken.eth0.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := store.List("tenant-net")
+	if err == nil {
+		t.Error("List() expected error for corrupt record")
+	}
+	if len(list) != 2 {
+		t.Errorf("List() returned %d records, want 2", len(list))
+	}
+}
+
+// TestStore_PublishedConfigHash verifies the published fingerprint round-trips and is not a record
+func TestStore_PublishedConfigHash(t *testing.T) {
+	store := New(t.TempDir())
+
+	if hash, err := store.PublishedConfigHash("tenant-net"); err != nil || hash != "" {
+		t.Fatalf("PublishedConfigHash() before set = %q, %v", hash, err)
+	}
+	if err := store.SetPublishedConfigHash("tenant-net", "0123456789abcdef"); err != nil {
+		t.Fatalf("SetPublishedConfigHash() error = %v", err)
+	}
+	if hash, err := store.PublishedConfigHash("tenant-net"); err != nil || hash != "0123456789abcdef" {
+		t.Errorf("PublishedConfigHash() = %q, %v; want 0123456789abcdef", hash, err)
+	}
+	if list, err := store.List("tenant-net"); err != nil || len(list) != 0 {
+		t.Errorf("List() = %v, %v; want no records", list, err)
+	}
+	if err := store.SetPublishedConfigHash("../etc", "x"); err == nil {
+		t.Error("SetPublishedConfigHash() expected error for invalid network")
+	}
+}
+
+// TestStore_Update verifies concurrent updates are merged and a deleted record stays deleted
+func TestStore_Update(t *testing.T) {
+	store := New(t.TempDir())
+	if err := store.Save(&Record{Network: "tenant-net", ContainerID: "abc123", IfName: "eth0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := store.Update("tenant-net", "abc123", "eth0", func(rec *Record) error {
+				rec.IPs = append(rec.IPs, fmt.Sprintf("10.200.1.%d", i))
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	rec, err := store.Load("tenant-net", "abc123", "eth0")
+	if err != nil || len(rec.IPs) != writers {
+		t.Fatalf("Load() = %v, %v; want %d merged IPs", rec, err, writers)
+	}
+
+	// An error from fn leaves the record unchanged
+	failed := errors.New("abort")
+	if err := store.Update("tenant-net", "abc123", "eth0", func(rec *Record) error {
+		rec.Pending = true
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Errorf("Update() error = %v, want %v", err, failed)
+	}
+	if rec, _ := store.Load("tenant-net", "abc123", "eth0"); rec.Pending {
+		t.Error("failed Update() changed the record")
+	}
+
+	if err := store.Delete("tenant-net", "abc123", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update("tenant-net", "abc123", "eth0", func(*Record) error { return nil }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update() of a deleted record error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Load("tenant-net", "abc123", "eth0"); !errors.Is(err, ErrNotFound) {
+		t.Error("Update() recreated a deleted record")
+	}
+}
+
+// TestStore_History verifies histories are bounded, queried by time and pruned by age
+func TestStore_History(t *testing.T) {
+	store := New(t.TempDir())
+	start := time.Now().Add(-time.Hour)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+
+	if history, err := store.History("tenant-net", "team-a", "web"); err != nil || history != nil {
+		t.Fatalf("History() of an unknown pod = %v, %v; want none", history, err)
+	}
+	for i := 0; i < MaxHistory+5; i++ {
+		fwmark := "0x10"
+		if i%2 == 1 {
+			fwmark = "0x20"
+		}
+		if err := store.AppendHistory("tenant-net", "team-a", "web", Assignment{Time: at(i), Cause: CauseRelabel, Fwmark: fwmark}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := store.History("tenant-net", "team-a", "web")
+	if err != nil || len(history) != MaxHistory {
+		t.Fatalf("History() = %d assignments, %v; want %d", len(history), err, MaxHistory)
+	}
+	if !history[0].Time.Equal(at(5)) {
+		t.Errorf("oldest assignment at %v, want the 5 oldest dropped", history[0].Time)
+	}
+
+	// The assignment made before from is in effect at from
+	got := Between(history, at(10).Add(time.Millisecond), at(12))
+	if len(got) != 3 || !got[0].Time.Equal(at(10)) || !got[2].Time.Equal(at(12)) {
+		t.Errorf("Between() = %+v, want the assignments at 10, 11 and 12", got)
+	}
+	if got := Between(history, at(0), at(4)); len(got) != 0 {
+		t.Errorf("Between() before the history = %+v, want none", got)
+	}
+
+	if err := store.AppendHistory("tenant-net", "team-b", "api", Assignment{Time: time.Now(), Cause: CauseAdd}); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := store.PruneHistory("tenant-net", 30*time.Minute)
+	if err != nil || removed != 1 {
+		t.Fatalf("PruneHistory() = %d, %v; want 1", removed, err)
+	}
+	if history, _ := store.History("tenant-net", "team-a", "web"); history != nil {
+		t.Error("PruneHistory() kept an expired history")
+	}
+	if history, _ := store.History("tenant-net", "team-b", "api"); len(history) != 1 {
+		t.Error("PruneHistory() removed a recent history")
+	}
+
+	// History files are not records
+	if records, err := store.List("tenant-net"); err != nil || len(records) != 0 {
+		t.Errorf("List() = %v, %v; want no records", records, err)
+	}
+	if err := store.AppendHistory("tenant-net", "../x", "web", Assignment{}); err == nil {
+		t.Error("AppendHistory() accepted an invalid namespace")
+	}
+}
+
+// TestStore_Attempt verifies retried ADDs share an attempt and its effects until DEL ends it
+func TestStore_Attempt(t *testing.T) {
+	store := New(t.TempDir())
+	first, err := store.StartAttempt("tenant-net", "abc123", "eth0")
+	if err != nil || first.ID == "" || len(first.Effects) != 0 {
+		t.Fatalf("StartAttempt() = %+v, %v; want a new attempt", first, err)
+	}
+
+	for i, want := range []bool{true, false} {
+		if got, err := store.Once("tenant-net", "abc123", "eth0", "skip/NoAnnotation"); err != nil || got != want {
+			t.Errorf("Once() call %d = %v, %v; want %v", i, got, err, want)
+		}
+	}
+	retry, err := store.StartAttempt("tenant-net", "abc123", "eth0")
+	if err != nil || retry.ID != first.ID || len(retry.Effects) != 1 {
+		t.Errorf("StartAttempt() of a retry = %+v, %v; want attempt %s with its effect", retry, err, first.ID)
+	}
+	if key := IdempotencyKey("abc123", "eth0", retry.ID); key != "abc123/eth0/"+first.ID {
+		t.Errorf("IdempotencyKey() = %q", key)
+	}
+	// Attempts are not records
+	if records, err := store.List("tenant-net"); err != nil || len(records) != 0 {
+		t.Errorf("List() = %v, %v; want no records", records, err)
+	}
+
+	if err := store.EndAttempt("tenant-net", "abc123", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EndAttempt("tenant-net", "abc123", "eth0"); err != nil {
+		t.Errorf("EndAttempt() is not idempotent: %v", err)
+	}
+	if got, err := store.Once("tenant-net", "abc123", "eth0", "skip/NoAnnotation"); err != nil || !got {
+		t.Errorf("Once() after EndAttempt() = %v, %v; want true for a new attempt", got, err)
+	}
+
+	// GC forgets the attempts of attachments the runtime no longer knows
+	if _, err := store.StartAttempt("tenant-net", "def456", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	valid := func(containerID, _ string) bool { return containerID == "def456" }
+	if removed, err := store.PruneAttempts("tenant-net", valid, time.Hour); err != nil || removed != 0 {
+		t.Errorf("PruneAttempts() of young attempts = %d, %v; want 0", removed, err)
+	}
+	if removed, err := store.PruneAttempts("tenant-net", valid, 0); err != nil || removed != 1 {
+		t.Errorf("PruneAttempts() = %d, %v; want 1", removed, err)
+	}
+	if a, err := store.StartAttempt("tenant-net", "def456", "eth0"); err != nil || time.Since(a.Started) > time.Minute {
+		t.Errorf("PruneAttempts() removed the attempt of a valid attachment: %+v, %v", a, err)
+	}
+
+	if _, err := store.Once("tenant-net", "../x", "eth0", "skip"); err == nil {
+		t.Error("Once() accepted an invalid container ID")
+	}
+}
+
+// TestStore_ReadOnly verifies records are kept in memory when the directory cannot be written
+func TestStore_ReadOnly(t *testing.T) {
+	orig := mkdirAll
+	mkdirAll = func(path string, _ os.FileMode) error {
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.EROFS}
+	}
+	t.Cleanup(func() { mkdirAll = orig })
+
+	store := New(filepath.Join(t.TempDir(), "state"))
+	rec := &Record{Network: "tenant-net", ContainerID: "abc123", IfName: "eth0", IPs: []string{"10.200.1.5"}, Fwmark: "0x10"}
+	if err := store.Save(rec); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Save() error = %v, want ErrReadOnly", err)
+	}
+	if got, err := store.Load("tenant-net", "abc123", "eth0"); err != nil || got.Fwmark != "0x10" {
+		t.Fatalf("Load() = %+v, %v; want the record kept in memory", got, err)
+	}
+	err := store.Update("tenant-net", "abc123", "eth0", func(rec *Record) error {
+		rec.Pending = true
+		return nil
+	})
+	if list, listErr := store.List("tenant-net"); err != nil || listErr != nil || len(list) != 1 || !list[0].Pending {
+		t.Errorf("List() after Update() = %+v, %v (Update: %v); want the pending record", list, listErr, err)
+	}
+	if err := store.Delete("tenant-net", "abc123", "eth0"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Load("tenant-net", "abc123", "eth0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load() after Delete() error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,234 @@
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AnnotationCacheDir is the directory below the plugin's stateDir holding the cache
+// Network names cannot start with a dot, so it never collides with a record directory.
+const AnnotationCacheDir = ".annotations"
+
+// namespaceEntry is the file holding a namespace's annotations next to its pods
+// Pod names are DNS subdomains and never start with a dot.
+const namespaceEntry = ".namespace.json"
+
+// LabelFwmarkKey carries the fwmark selected by namespace labels next to the namespace
+// annotations of a cache entry; '@' cannot appear in annotation keys, so no annotation
+// collides with it
+const LabelFwmarkKey = "@labels"
+
+// namePattern restricts cache keys to Kubernetes object names (DNS subdomains)
+var namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?$`)
+
+// AnnotationCache keeps resolved routing annotations on disk for a TTL
+//
+// Every CNI invocation is a new process, so the cache lives in files shared by all
+// invocations on the node: <dir>/<namespace>/<pod>.json for the annotations resolved
+// for a pod and <dir>/<namespace>/.namespace.json for the namespace annotations
+// the pod fallback reads. During pod churn most ADDs then need only the pod GET.
+// Errors are never cached, and entries stored for other annotation keys are misses.
+// Lookups that found no routing annotation, those of most pods, may be kept for a
+// shorter TTL of their own: they are redone on every CHECK of a non-tenant pod.
+//
+// A nil *AnnotationCache is valid and caches nothing.
+type AnnotationCache struct {
+	dir         string
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+}
+
+// cacheEntry is the file format of both entry kinds
+type cacheEntry struct {
+	Stored     time.Time `json:"stored"`
+	FwmarkKeys []string  `json:"fwmarkKeys"`
+	GatewayKey string    `json:"gatewayKey"`
+
+	// Values holds the annotation values found (namespace entries)
+	Values map[string]string `json:"values,omitempty"`
+
+	// Resolved annotations (pod entries)
+	Fwmark      string    `json:"fwmark,omitempty"`
+	Gateway     string    `json:"gateway,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Table       int       `json:"table,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	PodUID      string    `json:"podUID,omitempty"`
+	BypassUntil time.Time `json:"bypassUntil,omitempty"`
+	BypassError string    `json:"bypassError,omitempty"`
+	Excluded    bool      `json:"excluded,omitempty"`
+}
+
+// NewAnnotationCache returns a cache under dir keeping entries for ttl and pod lookups
+// that found no routing annotation for negativeTTL
+// negativeTTL <= 0 keeps those for ttl as well; ttl <= 0 caches negative lookups only.
+// Both <= 0 return nil, which disables caching.
+func NewAnnotationCache(dir string, ttl, negativeTTL time.Duration) *AnnotationCache {
+	if ttl <= 0 && negativeTTL <= 0 {
+		return nil
+	}
+	return &AnnotationCache{dir: dir, ttl: ttl, negativeTTL: negativeTTL, now: time.Now}
+}
+
+// SetClock makes the cache read the time from now; for tests
+func (c *AnnotationCache) SetClock(now func() time.Time) {
+	c.now = now
+}
+
+// Pod returns the annotations cached for a pod, if fresh and resolved with the same keys
+// A non-empty podUID must match: an entry of an earlier pod with the same name is a miss.
+func (c *AnnotationCache) Pod(podNamespace, podName, podUID string, fwmarkKeys []string, gatewayKey string) (RoutingAnnotations, bool) {
+	entry, ok := c.load(podNamespace, podName+".json", fwmarkKeys, gatewayKey)
+	if !ok || (podUID != "" && entry.PodUID != podUID) {
+		return RoutingAnnotations{}, false
+	}
+	annotations := RoutingAnnotations{Fwmark: entry.Fwmark, Gateway: entry.Gateway, TenantName: entry.Tenant,
+		Table: entry.Table, Source: Source(entry.Source), PodUID: entry.PodUID, BypassUntil: entry.BypassUntil,
+		Excluded: entry.Excluded}
+	if entry.BypassError != "" {
+		annotations.BypassError = errors.New(entry.BypassError)
+	}
+	return annotations, true
+}
+
+// StorePod caches the annotations resolved for a pod; failures only cost a later API call
+func (c *AnnotationCache) StorePod(podNamespace, podName string, fwmarkKeys []string, gatewayKey string, annotations RoutingAnnotations) {
+	entry := cacheEntry{Fwmark: annotations.Fwmark, Gateway: annotations.Gateway, Tenant: annotations.TenantName,
+		Table: annotations.Table, Source: string(annotations.Source), PodUID: annotations.PodUID,
+		BypassUntil: annotations.BypassUntil, Excluded: annotations.Excluded}
+	if annotations.BypassError != nil {
+		entry.BypassError = annotations.BypassError.Error()
+	}
+	c.store(podNamespace, podName+".json", fwmarkKeys, gatewayKey, entry)
+}
+
+// Namespace returns the cached annotation values of a namespace, for the pod fallback
+// of k8s.GetRoutingAnnotationsCached
+func (c *AnnotationCache) Namespace(namespace string, fwmarkKeys []string, gatewayKey string) (map[string]string, bool) {
+	entry, ok := c.load(namespace, namespaceEntry, fwmarkKeys, gatewayKey)
+	return entry.Values, ok
+}
+
+// StoreNamespace caches the annotation values of a namespace
+func (c *AnnotationCache) StoreNamespace(namespace string, fwmarkKeys []string, gatewayKey string, values map[string]string) {
+	c.store(namespace, namespaceEntry, fwmarkKeys, gatewayKey, cacheEntry{Values: values})
+}
+
+// Invalidate drops the entry of a pod, e.g. once DEL tore it down
+func (c *AnnotationCache) Invalidate(podNamespace, podName string) {
+	if c == nil || !namePattern.MatchString(podNamespace) || !namePattern.MatchString(podName) {
+		return
+	}
+	os.Remove(filepath.Join(c.dir, podNamespace, podName+".json"))
+}
+
+// InvalidateNamespace drops the entries of a namespace and of all its pods, e.g. once
+// a watch saw its annotations or labels change
+func (c *AnnotationCache) InvalidateNamespace(namespace string) {
+	if c == nil || !namePattern.MatchString(namespace) {
+		return
+	}
+	os.RemoveAll(filepath.Join(c.dir, namespace))
+}
+
+// Prune removes expired entries and returns how many were removed
+// Entries are only ever read while fresh, so pruning just bounds the disk usage.
+func (c *AnnotationCache) Prune() (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+	ttl := max(c.ttl, c.negativeTTL)
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*", "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || c.now().Sub(info.ModTime()) < ttl {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// load reads a fresh entry stored for the same annotation keys
+func (c *AnnotationCache) load(namespace, file string, fwmarkKeys []string, gatewayKey string) (cacheEntry, bool) {
+	if c == nil || !namePattern.MatchString(namespace) || !validEntryFile(file) {
+		return cacheEntry{}, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, namespace, file))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || !slices.Equal(entry.FwmarkKeys, fwmarkKeys) || entry.GatewayKey != gatewayKey {
+		return cacheEntry{}, false
+	}
+	if age := c.now().Sub(entry.Stored); age < 0 || age >= c.ttlOf(file, entry) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// store writes an entry atomically; errors are ignored (the next lookup misses)
+func (c *AnnotationCache) store(namespace, file string, fwmarkKeys []string, gatewayKey string, entry cacheEntry) {
+	if c == nil || !namePattern.MatchString(namespace) || !validEntryFile(file) || c.ttlOf(file, entry) <= 0 {
+		return
+	}
+	entry.Stored, entry.FwmarkKeys, entry.GatewayKey = c.now(), fwmarkKeys, gatewayKey
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	_ = writeAtomic(filepath.Join(c.dir, namespace, file), data)
+}
+
+// ttlOf returns how long entry is kept: negativeTTL, if set, for a pod entry without
+// any routing annotation, else ttl
+func (c *AnnotationCache) ttlOf(file string, entry cacheEntry) time.Duration {
+	negative := file != namespaceEntry && entry.Fwmark == "" && entry.Gateway == "" && entry.Tenant == "" &&
+		entry.BypassUntil.IsZero() && entry.BypassError == ""
+	if negative && c.negativeTTL > 0 {
+		return c.negativeTTL
+	}
+	return c.ttl
+}
+
+// validEntryFile reports whether file is the namespace entry or a pod name + ".json"
+func validEntryFile(file string) bool {
+	return file == namespaceEntry || namePattern.MatchString(strings.TrimSuffix(file, ".json"))
+}
+
+// writeAtomic replaces path with data via a temporary file and rename, so concurrent
+// readers never see a partial entry
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create annotation cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
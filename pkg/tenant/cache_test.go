@@ -0,0 +1,137 @@
+package tenant
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testGatewayKey = "tenant.routing/gateway"
+
+var testFwmarkKeys = []string{"tenant.routing/fwmark"}
+
+// testCache returns a cache in a temporary directory with a settable clock
+func testCache(t *testing.T, ttl time.Duration) (*AnnotationCache, *time.Time) {
+	return testCacheWithNegative(t, ttl, 0)
+}
+
+// testCacheWithNegative is testCache with a TTL for lookups that found no annotation
+func testCacheWithNegative(t *testing.T, ttl, negativeTTL time.Duration) (*AnnotationCache, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewAnnotationCache(t.TempDir(), ttl, negativeTTL)
+	cache.SetClock(func() time.Time { return now })
+	return cache, &now
+}
+
+// TestAnnotationCache_Misses verifies entries of other pods or annotation keys are not served
+func TestAnnotationCache_Misses(t *testing.T) {
+	cache, _ := testCache(t, time.Minute)
+	cache.StorePod("team-a", "web", testFwmarkKeys, testGatewayKey,
+		RoutingAnnotations{Fwmark: "0x10", PodUID: "3f1c0b7e-web"})
+
+	if _, ok := cache.Pod("team-a", "web", "3f1c0b7e-web", testFwmarkKeys, testGatewayKey); !ok {
+		t.Fatal("Pod() missed a fresh entry")
+	}
+	if _, ok := cache.Pod("team-a", "web", "", testFwmarkKeys, testGatewayKey); !ok {
+		t.Error("Pod() without UID missed a fresh entry")
+	}
+	if _, ok := cache.Pod("team-a", "web", "9a8b7c6d-web", testFwmarkKeys, testGatewayKey); ok {
+		t.Error("Pod() served the entry of an earlier pod with the same name")
+	}
+	if _, ok := cache.Pod("team-a", "web", "", []string{"other/fwmark"}, testGatewayKey); ok {
+		t.Error("Pod() served an entry resolved for another annotation key")
+	}
+	if _, ok := cache.Pod("../etc", "web", "", testFwmarkKeys, testGatewayKey); ok {
+		t.Error("Pod() accepted a namespace that is not an object name")
+	}
+
+	var disabled *AnnotationCache
+	disabled.StorePod("team-a", "web", testFwmarkKeys, testGatewayKey, RoutingAnnotations{Fwmark: "0x10"})
+	if _, ok := disabled.Pod("team-a", "web", "", testFwmarkKeys, testGatewayKey); ok {
+		t.Error("nil cache served an entry")
+	}
+	if NewAnnotationCache(t.TempDir(), 0, 0) != nil {
+		t.Error("NewAnnotationCache() with TTLs 0 should disable the cache")
+	}
+}
+
+// TestAnnotationCache_NegativeTTL verifies lookups without annotations expire after their own TTL
+func TestAnnotationCache_NegativeTTL(t *testing.T) {
+	tests := []struct {
+		name             string
+		ttl, negativeTTL time.Duration
+		age              time.Duration
+		wantNegative     bool
+		wantPositive     bool
+	}{
+		{name: "fresh", ttl: time.Minute, negativeTTL: 5 * time.Second, age: time.Second,
+			wantNegative: true, wantPositive: true},
+		{name: "negative expired", ttl: time.Minute, negativeTTL: 5 * time.Second, age: 5 * time.Second,
+			wantPositive: true},
+		{name: "negative only", negativeTTL: 5 * time.Second, age: time.Second, wantNegative: true},
+		{name: "no negative TTL", ttl: time.Minute, age: 30 * time.Second, wantNegative: true, wantPositive: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, now := testCacheWithNegative(t, tt.ttl, tt.negativeTTL)
+			cache.StorePod("team-a", "batch", testFwmarkKeys, testGatewayKey, RoutingAnnotations{PodUID: "3f1c0b7e-batch"})
+			cache.StorePod("team-a", "web", testFwmarkKeys, testGatewayKey, RoutingAnnotations{Fwmark: "0x10"})
+			*now = now.Add(tt.age)
+
+			if _, ok := cache.Pod("team-a", "batch", "", testFwmarkKeys, testGatewayKey); ok != tt.wantNegative {
+				t.Errorf("Pod() of unannotated pod hit = %v, want %v", ok, tt.wantNegative)
+			}
+			if _, ok := cache.Pod("team-a", "web", "", testFwmarkKeys, testGatewayKey); ok != tt.wantPositive {
+				t.Errorf("Pod() of annotated pod hit = %v, want %v", ok, tt.wantPositive)
+			}
+		})
+	}
+}
+
+// TestAnnotationCache_InvalidateNamespace verifies a namespace change drops its pods' entries
+func TestAnnotationCache_InvalidateNamespace(t *testing.T) {
+	cache, _ := testCacheWithNegative(t, time.Minute, 5*time.Second)
+	cache.StorePod("team-a", "web", testFwmarkKeys, testGatewayKey, RoutingAnnotations{})
+	cache.StorePod("team-b", "web", testFwmarkKeys, testGatewayKey, RoutingAnnotations{})
+	cache.StoreNamespace("team-a", testFwmarkKeys, testGatewayKey, map[string]string{})
+
+	cache.InvalidateNamespace("team-a")
+	if _, ok := cache.Pod("team-a", "web", "", testFwmarkKeys, testGatewayKey); ok {
+		t.Error("Pod() served an entry of an invalidated namespace")
+	}
+	if _, ok := cache.Namespace("team-a", testFwmarkKeys, testGatewayKey); ok {
+		t.Error("Namespace() served an invalidated entry")
+	}
+	if _, ok := cache.Pod("team-b", "web", "", testFwmarkKeys, testGatewayKey); !ok {
+		t.Error("InvalidateNamespace() dropped an entry of another namespace")
+	}
+	cache.InvalidateNamespace("..")
+}
+
+// TestAnnotationCache_Prune verifies only expired entries are removed
+func TestAnnotationCache_Prune(t *testing.T) {
+	cache, now := testCache(t, time.Minute)
+	cache.StorePod("team-a", "old", testFwmarkKeys, testGatewayKey, RoutingAnnotations{Fwmark: "0x10"})
+	cache.StorePod("team-a", "new", testFwmarkKeys, testGatewayKey, RoutingAnnotations{Fwmark: "0x10"})
+
+	old := filepath.Join(cache.dir, "team-a", "old.json")
+	if err := os.Chtimes(old, now.Add(-2*time.Minute), now.Add(-2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(cache.dir, "team-a", "new.json"), *now, *now); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := cache.Prune()
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune() = %d, %v; want 1, nil", removed, err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expired entry still exists: %v", err)
+	}
+	if _, ok := cache.Pod("team-a", "new", "", testFwmarkKeys, testGatewayKey); !ok {
+		t.Error("Prune() removed a fresh entry")
+	}
+}
@@ -0,0 +1,30 @@
+package tenant
+
+import (
+	"sync"
+)
+
+var (
+	excludedNamespacesMu sync.RWMutex
+	excludedNamespaces   map[string]bool
+)
+
+// SetExcludedNamespaces excludes the pods of namespaces from tenant routing in every
+// following lookup; they are answered without any API call (see k8s.SetExclusions,
+// which also excludes pods by label)
+func SetExcludedNamespaces(namespaces []string) {
+	set := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		set[namespace] = true
+	}
+	excludedNamespacesMu.Lock()
+	defer excludedNamespacesMu.Unlock()
+	excludedNamespaces = set
+}
+
+// ExcludedNamespace reports whether the pods of namespace are excluded from tenant routing
+func ExcludedNamespace(namespace string) bool {
+	excludedNamespacesMu.RLock()
+	defer excludedNamespacesMu.RUnlock()
+	return excludedNamespaces[namespace]
+}
@@ -0,0 +1,56 @@
+package tenant
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+)
+
+// StaticTenant is the tenant the plugin configuration assigns to a namespace
+type StaticTenant struct {
+	// Fwmark is the tenant fwmark of the namespace's pods
+	Fwmark string
+
+	// Table is the routing table the configuration expects for Fwmark (0 if it does not say)
+	Table int
+
+	// Gateway is the egress gateway of the namespace's pods ('' for the configured one)
+	Gateway string
+}
+
+var (
+	staticTenantsMu sync.RWMutex
+	staticTenants   map[string]StaticTenant
+)
+
+// SetStaticTenants sets the tenants of namespaces every following lookup falls back to:
+// when neither annotations nor namespace labels assign a tenant, and in place of
+// annotations the API server could not be asked for. nil disables the fallback.
+// The fwmark and gateway are validated like annotations when a pod resolves to them.
+func SetStaticTenants(tenants map[string]StaticTenant) {
+	staticTenantsMu.Lock()
+	defer staticTenantsMu.Unlock()
+	staticTenants = tenants
+}
+
+// StaticRoutingAnnotations returns the routing annotations the static tenant of namespace
+// assigns, without asking the API server; ok is false if namespace has none
+func StaticRoutingAnnotations(namespace string) (annotations RoutingAnnotations, ok bool, err error) {
+	staticTenantsMu.RLock()
+	tenant, ok := staticTenants[namespace]
+	staticTenantsMu.RUnlock()
+	if !ok {
+		return RoutingAnnotations{}, false, nil
+	}
+	if _, err := api.ParseFwmark(tenant.Fwmark); err != nil {
+		return RoutingAnnotations{}, true, fmt.Errorf("invalid fwmark of static tenant %s: %w", namespace, err)
+	}
+	if tenant.Gateway != "" {
+		if _, err := api.ParseGateway(tenant.Gateway); err != nil {
+			return RoutingAnnotations{}, true, fmt.Errorf("invalid gateway of static tenant %s: %w", namespace, err)
+		}
+	}
+	return RoutingAnnotations{Fwmark: tenant.Fwmark, Gateway: tenant.Gateway, Table: tenant.Table,
+		Source: SourceStatic}, true, nil
+}
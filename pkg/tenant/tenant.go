@@ -0,0 +1,159 @@
+// Package tenant holds what the CNI plugin knows about the tenant of a pod, without
+// the Kubernetes libraries: the resolved routing annotations, the static tenants of
+// the configuration, the live pods of a node and the errors lookups are classified by.
+//
+// pkg/k8s resolves these from the API server and re-exports them; tenant-routingd
+// serves them to the plugin. The agent-only build of the plugin (build tag agentonly)
+// links this package but not pkg/k8s or client-go.
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/azalio/kubeCon-cni-wrapper/pkg/api"
+)
+
+// Source tells where the tenant of a pod was found
+type Source string
+
+const (
+	// SourcePod: a fwmark or tenant name annotation on the pod
+	SourcePod Source = "pod"
+
+	// SourceNamespace: a fwmark or tenant name annotation on the pod's namespace
+	SourceNamespace Source = "namespace"
+
+	// SourceNamespaceLabels: a namespace label rule (see k8s.SetNamespaceLabelRules)
+	SourceNamespaceLabels Source = "namespace-labels"
+
+	// SourceStatic: the tenant the plugin configuration assigns to the pod's
+	// namespace (see SetStaticTenants)
+	SourceStatic Source = "static"
+)
+
+// Sentinels classifying failed lookups (use errors.Is)
+var (
+	ErrInvalidFwmark  = api.ErrInvalidFwmark
+	ErrInvalidGateway = api.ErrInvalidGateway
+
+	// ErrNotFound is matched by lookups of a pod or namespace that does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrAPIUnavailable is matched by API failures that outlasted the retries:
+	// transient failures and calls that ran out of time
+	// Definitive answers such as not found or forbidden do not match it.
+	ErrAPIUnavailable = errors.New("Kubernetes API unavailable")
+)
+
+// RoutingAnnotations holds the tenant routing annotations resolved for a pod
+type RoutingAnnotations struct {
+	// Fwmark is the validated fwmark value ('' if not annotated)
+	Fwmark string
+
+	// Gateway is the validated tenant gateway IPv4 address ('' if not annotated)
+	Gateway string
+
+	// TenantName is the tenant name the fwmark was resolved from ('' if annotated directly)
+	// Table is the routing table its TenantRoute expects (0 if it does not say)
+	TenantName string
+	Table      int
+
+	// Source is where the fwmark was found ('' if not annotated)
+	Source Source
+
+	// PodUID identifies the pod object the annotations were read from
+	// StatefulSet pods reuse names; the UID tells the incarnations apart
+	PodUID string
+
+	// BypassUntil is the validated end of a temporary marking bypass (zero if not annotated)
+	// Only read from the pod; a namespace cannot exempt all of its pods
+	BypassUntil time.Time
+
+	// BypassError is set if the bypass annotation is present but invalid
+	// The bypass is then ignored (fail closed: the pod stays marked)
+	BypassError error
+
+	// Terminated is set once the pod reached phase Succeeded or Failed; it sends no
+	// traffic any more. TerminatedAt is when (zero if the pod status does not say).
+	Terminated   bool
+	TerminatedAt time.Time
+
+	// Excluded is set if the pod's namespace or labels exclude it from tenant routing
+	// (see k8s.SetExclusions); nothing else is resolved then
+	Excluded bool
+
+	// FallbackError is the API failure the static tenant of the namespace answered in
+	// place of (nil if the API server answered, see SetStaticTenants)
+	FallbackError error
+}
+
+// BypassActive reports whether marking is bypassed at now
+func (a RoutingAnnotations) BypassActive(now time.Time) bool {
+	return now.Before(a.BypassUntil)
+}
+
+// Tenant is the typed routing identity of a pod, for the iptables and routing layers
+type Tenant struct {
+	// Name is the TenantRoute the tenant was resolved from ('' if the fwmark was annotated)
+	Name string
+
+	// Fwmark and Mask are the mark of the pod's packets and the bits it occupies
+	Fwmark uint32
+	Mask   uint32
+
+	// RoutingTable is the table the TenantRoute expects (0 if it does not say)
+	RoutingTable int
+
+	// Gateway is the egress gateway annotated for the pod (nil for the configured one)
+	Gateway net.IP
+
+	// Source is where the tenant was found
+	Source Source
+}
+
+func (t *Tenant) String() string {
+	s := fmt.Sprintf("fwmark 0x%x/0x%x from %s", t.Fwmark, t.Mask, t.Source)
+	if t.Name != "" {
+		s = fmt.Sprintf("tenant %s (%s)", t.Name, s)
+	}
+	if t.Gateway != nil {
+		s += fmt.Sprintf(", gateway %s", t.Gateway)
+	}
+	return s
+}
+
+// Tenant returns the typed tenant of resolved annotations, nil if the pod has none
+// The values were validated during resolution; an unparsable one is an error.
+func (a RoutingAnnotations) Tenant() (*Tenant, error) {
+	if a.Fwmark == "" {
+		return nil, nil
+	}
+	mark, err := strconv.ParseUint(strings.TrimSpace(a.Fwmark), 0, 32)
+	if err != nil || mark == 0 {
+		return nil, &api.InvalidError{Kind: ErrInvalidFwmark, Msg: fmt.Sprintf("fwmark value '%s' is not a mark", a.Fwmark)}
+	}
+	tenant := &Tenant{Name: a.TenantName, Fwmark: uint32(mark), Mask: api.TenantMask, RoutingTable: a.Table, Source: a.Source}
+	if a.Gateway != "" {
+		if tenant.Gateway = net.ParseIP(a.Gateway).To4(); tenant.Gateway == nil {
+			return nil, &api.InvalidError{Kind: ErrInvalidGateway, Msg: fmt.Sprintf("gateway value '%s' is not an IPv4 address", a.Gateway)}
+		}
+	}
+	return tenant, nil
+}
+
+// LivePods is the pod network state of a node as seen by the API server or the
+// container runtime
+type LivePods struct {
+	// IPs of pods that still exist and are not terminated
+	IPs map[string]bool
+
+	// Pending lists pods ("namespace/name") that exist but have no IP yet
+	// Their sandbox may be between CNI ADD and the kubelet status update, so a
+	// rule whose IP is not in IPs might still belong to one of them
+	Pending []string
+}
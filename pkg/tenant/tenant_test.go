@@ -0,0 +1,26 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestRoutingAnnotations_Tenant verifies that unparsable values are typed errors
+func TestRoutingAnnotations_Tenant(t *testing.T) {
+	if _, err := (RoutingAnnotations{Fwmark: "tenant-a"}).Tenant(); !errors.Is(err, ErrInvalidFwmark) {
+		t.Errorf("fwmark: err = %v, want ErrInvalidFwmark", err)
+	}
+	if _, err := (RoutingAnnotations{Fwmark: "0x10", Gateway: "fd00::1"}).Tenant(); !errors.Is(err, ErrInvalidGateway) {
+		t.Errorf("gateway: err = %v, want ErrInvalidGateway", err)
+	}
+	tenant, err := (RoutingAnnotations{Fwmark: "0x10", TenantName: "blue", Table: 110, Source: SourceNamespace}).Tenant()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tenant.Name != "blue" || tenant.RoutingTable != 110 {
+		t.Errorf("tenant = %+v, want blue with table 110", tenant)
+	}
+	if got, want := tenant.String(), "tenant blue (fwmark 0x10/0xff from namespace)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,38 @@
+package tenant
+
+import (
+	"time"
+)
+
+const (
+	// DefaultAPITimeout is the Kubernetes API timeout when the CNI operation has no deadline
+	// CNI operations are time-sensitive; prevents hanging if API is slow/unreachable
+	DefaultAPITimeout = 5 * time.Second
+
+	// MinAPITimeout is the floor for adaptive Kubernetes API timeouts
+	// Below this a single apiserver round-trip rarely succeeds
+	MinAPITimeout = 1 * time.Second
+
+	// MaxAPITimeout is the ceiling for adaptive Kubernetes API timeouts
+	MaxAPITimeout = 30 * time.Second
+)
+
+// APITimeout derives the Kubernetes API timeout from the CNI operation deadline.
+//
+// Half of the time remaining until deadline is granted, so iptables and route
+// programming still fit in the budget afterwards, clamped to [MinAPITimeout, MaxAPITimeout].
+// A zero deadline (budget unknown) returns the fixed DefaultAPITimeout.
+func APITimeout(deadline, now time.Time) time.Duration {
+	if deadline.IsZero() {
+		return DefaultAPITimeout
+	}
+
+	timeout := deadline.Sub(now) / 2
+	if timeout < MinAPITimeout {
+		return MinAPITimeout
+	}
+	if timeout > MaxAPITimeout {
+		return MaxAPITimeout
+	}
+	return timeout
+}
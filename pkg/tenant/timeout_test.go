@@ -0,0 +1,31 @@
+package tenant
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAPITimeout verifies the adaptive timeout and its bounds
+func TestAPITimeout(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		deadline time.Time
+		want     time.Duration
+	}{
+		{name: "no deadline", deadline: time.Time{}, want: DefaultAPITimeout},
+		{name: "generous budget hits ceiling", deadline: now.Add(120 * time.Second), want: MaxAPITimeout},
+		{name: "half of remaining", deadline: now.Add(20 * time.Second), want: 10 * time.Second},
+		{name: "short budget hits floor", deadline: now.Add(1 * time.Second), want: MinAPITimeout},
+		{name: "deadline passed", deadline: now.Add(-time.Second), want: MinAPITimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := APITimeout(tt.deadline, now); got != tt.want {
+				t.Errorf("APITimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}